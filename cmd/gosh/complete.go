@@ -0,0 +1,155 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+// completer returns the candidates to complete the word being typed in the
+// arguments of a command, where args holds the command name and the
+// arguments before the word.
+type completer func(r *interp.Runner, args []string, word string) []string
+
+// completers holds the completion functions for specific commands, which are
+// used instead of completing file names in their arguments.
+var completers = map[string]completer{
+	"cd":    completeDirs,
+	"pushd": completeDirs,
+}
+
+// wordBreaks are the bytes which separate words for tab completion.
+const wordBreaks = " \t;&|()<>"
+
+// complete implements tab completion for an interactive runner, returning the
+// start of the word before pos and the candidates to replace it with.
+//
+// The first word of a command is completed with the names of builtins,
+// functions, and programs in $PATH, unless it contains a slash.
+// Other words are completed with the function in [completers] for the command,
+// or with file names otherwise.
+func complete(r *interp.Runner, line string, pos int) (int, []string) {
+	start := strings.LastIndexAny(line[:pos], wordBreaks) + 1
+	word := line[start:pos]
+
+	// Find the words of the command before the one being completed.
+	cmdStart := strings.LastIndexAny(line[:start], ";&|()") + 1
+	args := strings.Fields(line[cmdStart:start])
+	for len(args) > 0 && strings.Contains(args[0], "=") {
+		args = args[1:] // skip assignments like "FOO=bar cmd"
+	}
+	var candidates []string
+	switch {
+	case len(args) == 0 && !strings.Contains(word, "/"):
+		candidates = completeCommands(r, word)
+	case len(args) > 0 && completers[args[0]] != nil:
+		candidates = completers[args[0]](r, args, word)
+	default:
+		candidates = completeFiles(r, word, false)
+	}
+	slices.Sort(candidates)
+	return start, slices.Compact(candidates)
+}
+
+func completeCommands(r *interp.Runner, word string) []string {
+	var candidates []string
+	for _, name := range interp.Builtins() {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, name)
+		}
+	}
+	for name := range r.Funcs {
+		if strings.HasPrefix(name, word) {
+			candidates = append(candidates, name)
+		}
+	}
+	for _, dir := range filepath.SplitList(lookupVar(r, "PATH").String()) {
+		if dir == "" {
+			dir = "."
+		}
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			name := entry.Name()
+			if !strings.HasPrefix(name, word) || entry.IsDir() {
+				continue
+			}
+			if info, err := entry.Info(); err == nil && executable(info) {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	return candidates
+}
+
+func completeDirs(r *interp.Runner, args []string, word string) []string {
+	return completeFiles(r, word, true)
+}
+
+// completeFiles returns the files whose paths start with word, relative to
+// the runner's directory, with a trailing slash for directories.
+func completeFiles(r *interp.Runner, word string, onlyDirs bool) []string {
+	dir, base := "", word
+	if i := strings.LastIndexByte(word, '/'); i >= 0 {
+		dir, base = word[:i+1], word[i+1:]
+	}
+	readDir := dir
+	if home := lookupVar(r, "HOME").String(); home != "" && strings.HasPrefix(readDir, "~/") {
+		readDir = home + readDir[1:]
+	}
+	if !filepath.IsAbs(readDir) {
+		readDir = filepath.Join(r.Dir, readDir)
+	}
+	entries, _ := os.ReadDir(readDir)
+	var candidates []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) {
+			continue
+		}
+		// Like Bash, hidden files are only completed when asked for.
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".") {
+			continue
+		}
+		isDir := entry.IsDir()
+		if entry.Type()&os.ModeSymlink != 0 {
+			info, err := os.Stat(filepath.Join(readDir, name))
+			isDir = err == nil && info.IsDir()
+		}
+		switch {
+		case isDir:
+			candidates = append(candidates, dir+name+"/")
+		case !onlyDirs:
+			candidates = append(candidates, dir+name)
+		}
+	}
+	return candidates
+}
+
+// lookupVar returns a variable in the runner, which are only kept in
+// [interp.Runner.Vars] once a command has been run.
+func lookupVar(r *interp.Runner, name string) expand.Variable {
+	if vr, ok := r.Vars[name]; ok {
+		return vr
+	}
+	return r.Env.Get(name)
+}
+
+// executable reports whether a file in $PATH can be run as a program.
+func executable(info os.FileInfo) bool {
+	if runtime.GOOS == "windows" {
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".exe", ".com", ".bat", ".cmd":
+			return true
+		}
+		return false
+	}
+	return info.Mode().IsRegular() && info.Mode()&0o111 != 0
+}
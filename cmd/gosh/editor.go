@@ -0,0 +1,425 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by [editor.readLine] when the user pressed
+// Ctrl-C, which discards the line being edited.
+var errInterrupted = errors.New("interrupted")
+
+// editor reads lines from a terminal in raw mode, supporting readline-style
+// editing keys, a history of previous lines, and tab completion.
+//
+// The supported keys are the arrows, Home, End, Delete, Backspace, Tab, and:
+//
+//	Ctrl-A, Ctrl-E  move to the start or end of the line
+//	Ctrl-B, Ctrl-F  move back or forward one character
+//	Alt-B, Alt-F    move back or forward one word
+//	Ctrl-P, Ctrl-N  go to the previous or next line in the history
+//	Ctrl-R          search the history backwards
+//	Ctrl-K, Ctrl-U  delete until the end or start of the line
+//	Ctrl-W          delete the previous word
+//	Ctrl-L          clear the screen
+//	Ctrl-C          discard the line
+//	Ctrl-D          delete a character, or end the input on an empty line
+type editor struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// history holds the previous lines, oldest first.
+	history []string
+
+	// complete, if not nil, is called when Tab is pressed with the line and
+	// the cursor position. It returns the start of the word being completed
+	// and the candidates to replace it with.
+	complete func(line string, pos int) (start int, candidates []string)
+
+	// highlight, if not nil, returns the line to draw with colors.
+	// It must not change the characters which are shown.
+	highlight func(line string) string
+
+	// prompt is the last line of the current prompt, which is drawn again
+	// when the line changes.
+	prompt string
+	line   []rune
+	pos    int
+}
+
+func newEditor(in io.Reader, out io.Writer) *editor {
+	return &editor{in: bufio.NewReader(in), out: out}
+}
+
+// addHistory adds a line to the history, unless it is empty or the same as
+// the previous one.
+func (e *editor) addHistory(line string) {
+	line = strings.TrimRight(line, "\n")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+}
+
+const (
+	keyCtrlA     = 'a' & 0x1f
+	keyCtrlB     = 'b' & 0x1f
+	keyCtrlC     = 'c' & 0x1f
+	keyCtrlD     = 'd' & 0x1f
+	keyCtrlE     = 'e' & 0x1f
+	keyCtrlF     = 'f' & 0x1f
+	keyCtrlG     = 'g' & 0x1f
+	keyCtrlK     = 'k' & 0x1f
+	keyCtrlL     = 'l' & 0x1f
+	keyCtrlN     = 'n' & 0x1f
+	keyCtrlP     = 'p' & 0x1f
+	keyCtrlR     = 'r' & 0x1f
+	keyCtrlU     = 'u' & 0x1f
+	keyCtrlW     = 'w' & 0x1f
+	keyTab       = '\t'
+	keyEnter     = '\r'
+	keyNewline   = '\n'
+	keyEscape    = 0x1b
+	keyBackspace = 0x7f
+	keyCtrlH     = 'h' & 0x1f
+
+	// Keys sent as escape sequences use runes which cannot be typed.
+	keyUp = unicode.MaxRune + 1 + iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyDelete
+	keyWordLeft
+	keyWordRight
+	keyUnknown
+)
+
+// readKey reads a single key press, decoding escape sequences.
+func (e *editor) readKey() (rune, error) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || r != keyEscape {
+		return r, err
+	}
+	// Escape sequences arrive all at once, so only look at buffered input
+	// to not block on a lone Escape key.
+	if e.in.Buffered() == 0 {
+		return keyEscape, nil
+	}
+	r, _, _ = e.in.ReadRune()
+	switch r {
+	case 'b':
+		return keyWordLeft, nil
+	case 'f':
+		return keyWordRight, nil
+	case '[', 'O':
+	default:
+		return keyUnknown, nil
+	}
+	// Read the parameters and final byte of a sequence like "\x1b[3~".
+	var params []byte
+	for e.in.Buffered() > 0 {
+		b, _ := e.in.ReadByte()
+		if b >= 0x40 && b <= 0x7e {
+			return escapeKey(string(params), b), nil
+		}
+		params = append(params, b)
+	}
+	return keyUnknown, nil
+}
+
+func escapeKey(params string, final byte) rune {
+	switch final {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		if params == "1;3" || params == "1;5" {
+			return keyWordRight
+		}
+		return keyRight
+	case 'D':
+		if params == "1;3" || params == "1;5" {
+			return keyWordLeft
+		}
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyHome
+		case "4", "8":
+			return keyEnd
+		case "3":
+			return keyDelete
+		}
+	}
+	return keyUnknown
+}
+
+// readLine shows a prompt and reads a line, without its trailing newline.
+// It returns [io.EOF] if the input ended or Ctrl-D was pressed on an empty
+// line, and [errInterrupted] if Ctrl-C was pressed.
+func (e *editor) readLine(prompt string) (string, error) {
+	fmt.Fprint(e.out, strings.ReplaceAll(prompt, "\n", "\r\n"))
+	if i := strings.LastIndexByte(prompt, '\n'); i >= 0 {
+		prompt = prompt[i+1:]
+	}
+	e.prompt = prompt
+	e.line, e.pos = e.line[:0], 0
+	histIndex := len(e.history)
+	pending := "" // the line being edited, while going through the history
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			if err == io.EOF && len(e.line) > 0 {
+				// Input ended without a newline.
+				fmt.Fprint(e.out, "\r\n")
+				return string(e.line), nil
+			}
+			return "", err
+		}
+		switch key {
+		case keyEnter, keyNewline:
+			fmt.Fprint(e.out, "\r\n")
+			return string(e.line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(e.line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(e.pos, e.pos+1)
+		case keyDelete:
+			e.delete(e.pos, e.pos+1)
+		case keyBackspace, keyCtrlH:
+			e.delete(e.pos-1, e.pos)
+		case keyLeft, keyCtrlB:
+			e.pos = max(e.pos-1, 0)
+		case keyRight, keyCtrlF:
+			e.pos = min(e.pos+1, len(e.line))
+		case keyHome, keyCtrlA:
+			e.pos = 0
+		case keyEnd, keyCtrlE:
+			e.pos = len(e.line)
+		case keyWordLeft:
+			e.pos = e.wordStart()
+		case keyWordRight:
+			e.pos = e.wordEnd()
+		case keyCtrlK:
+			e.delete(e.pos, len(e.line))
+		case keyCtrlU:
+			e.delete(0, e.pos)
+		case keyCtrlW:
+			e.delete(e.wordStart(), e.pos)
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case keyUp, keyCtrlP:
+			if histIndex == 0 {
+				continue
+			}
+			if histIndex == len(e.history) {
+				pending = string(e.line)
+			}
+			histIndex--
+			e.setLine(e.history[histIndex])
+		case keyDown, keyCtrlN:
+			if histIndex == len(e.history) {
+				continue
+			}
+			histIndex++
+			if histIndex == len(e.history) {
+				e.setLine(pending)
+			} else {
+				e.setLine(e.history[histIndex])
+			}
+		case keyCtrlR:
+			line, accept, err := e.search()
+			if err != nil {
+				return "", err
+			}
+			e.setLine(line)
+			if accept {
+				e.draw()
+				fmt.Fprint(e.out, "\r\n")
+				return line, nil
+			}
+		case keyTab:
+			e.completeWord()
+		default:
+			if !unicode.IsPrint(key) {
+				continue
+			}
+			e.insert(string(key))
+		}
+		e.draw()
+	}
+}
+
+// search implements Ctrl-R, searching the history backwards for lines
+// containing what is typed. It returns the line which was found, and whether
+// Enter was pressed to accept it as the input line.
+func (e *editor) search() (line string, accept bool, _ error) {
+	orig := string(e.line)
+	var query []rune
+	index := len(e.history) // the index of the match
+	// find looks for the query in the history, starting from index from.
+	find := func(from int) {
+		for i := min(from, len(e.history)-1); i >= 0; i-- {
+			if strings.Contains(e.history[i], string(query)) {
+				index = i
+				return
+			}
+		}
+	}
+	for {
+		match := orig
+		if index < len(e.history) {
+			match = e.history[index]
+		}
+		fmt.Fprintf(e.out, "\r(reverse-i-search)`%s': %s\x1b[K", string(query), match)
+		key, err := e.readKey()
+		if err != nil {
+			return "", false, err
+		}
+		switch key {
+		case keyCtrlR:
+			find(index - 1)
+		case keyBackspace, keyCtrlH:
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case keyCtrlC, keyCtrlG:
+			return orig, false, nil
+		case keyEnter, keyNewline:
+			return match, true, nil
+		default:
+			if !unicode.IsPrint(key) {
+				// Like Bash, other keys such as the arrows stop
+				// searching and keep the match to edit it.
+				return match, false, nil
+			}
+			query = append(query, key)
+			find(index)
+		}
+	}
+}
+
+// completeWord implements Tab. If there is a single candidate, the word is
+// replaced with it; otherwise, the word is extended with the longest prefix
+// shared by all candidates, or the candidates are listed if it can't be.
+func (e *editor) completeWord() {
+	if e.complete == nil {
+		return
+	}
+	line := string(e.line)
+	bytePos := len(string(e.line[:e.pos]))
+	start, candidates := e.complete(line, bytePos)
+	if len(candidates) == 0 {
+		return
+	}
+	word := line[start:bytePos]
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	for !utf8.ValidString(prefix) {
+		prefix = prefix[:len(prefix)-1]
+	}
+	if len(candidates) == 1 && !strings.HasSuffix(prefix, "/") {
+		prefix += " "
+	}
+	if len(prefix) > len(word) {
+		e.delete(len([]rune(line[:start])), e.pos)
+		e.insert(prefix)
+		return
+	}
+	if len(candidates) > 1 {
+		fmt.Fprint(e.out, "\r\n")
+		for _, c := range candidates {
+			fmt.Fprintf(e.out, "%s\r\n", c)
+		}
+	}
+}
+
+func (e *editor) insert(s string) {
+	rs := []rune(s)
+	e.line = append(e.line[:e.pos], append(rs, e.line[e.pos:]...)...)
+	e.pos += len(rs)
+}
+
+// delete deletes the runes from i to j, if they are within the line.
+func (e *editor) delete(i, j int) {
+	i, j = max(i, 0), min(j, len(e.line))
+	if i >= j {
+		return
+	}
+	e.line = append(e.line[:i], e.line[j:]...)
+	if e.pos > j {
+		e.pos -= j - i
+	} else if e.pos > i {
+		e.pos = i
+	}
+}
+
+func (e *editor) setLine(s string) {
+	e.line = append(e.line[:0], []rune(s)...)
+	e.pos = len(e.line)
+}
+
+// wordStart returns the start of the word before the cursor.
+func (e *editor) wordStart() int {
+	i := e.pos
+	for i > 0 && unicode.IsSpace(e.line[i-1]) {
+		i--
+	}
+	for i > 0 && !unicode.IsSpace(e.line[i-1]) {
+		i--
+	}
+	return i
+}
+
+// wordEnd returns the end of the word after the cursor.
+func (e *editor) wordEnd() int {
+	i := e.pos
+	for i < len(e.line) && unicode.IsSpace(e.line[i]) {
+		i++
+	}
+	for i < len(e.line) && !unicode.IsSpace(e.line[i]) {
+		i++
+	}
+	return i
+}
+
+// draw draws the prompt and the line again, placing the cursor.
+func (e *editor) draw() {
+	line := string(e.line)
+	if e.highlight != nil {
+		line = e.highlight(line)
+	}
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.prompt, line)
+	if n := len(e.line) - e.pos; n > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", n)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+var editorTests = []struct {
+	in      string
+	want    string
+	wantErr error
+}{
+	{"echo foo\r", "echo foo", nil},
+	{"echo foo\n", "echo foo", nil},
+	{"echo foo", "echo foo", nil},
+	{"", "", io.EOF},
+	{"\x04", "", io.EOF},
+	{"echo\x03", "", errInterrupted},
+	{"echo fooo\x7f\r", "echo foo", nil},
+	{"echo foo\x02\x02\x02bar \r", "echo bar foo", nil},
+	{"echo foo\x1b[D\x1b[D\x1b[D\x1b[3~\r", "echo oo", nil},
+	{"foo\x01echo \x05 bar\r", "echo foo bar", nil},
+	{"echo foo\x1b[H\x1b[Fx\r", "echo foox", nil},
+	{"echo foo bar\x1bb\x0b\r", "echo foo ", nil},
+	{"echo foo bar\x1b[1;5D\x1b[1;5Dx\x06y\r", "echo xfyoo bar", nil},
+	{"echo foo bar\x17\x17\x17ls\r", "ls", nil},
+	{"echo foo\x02\x02\x15ls \r", "ls oo", nil},
+
+	// History; see editorHistory.
+	{"\x1b[A\r", "third", nil},
+	{"\x10\x10\r", "second", nil},
+	{"\x10\x10\x10\x10\r", "first", nil},
+	{"new\x1b[A\x1b[A\x1b[B\x1b[B\r", "new", nil},
+	{"new\x1b[A\x0e\r", "new", nil},
+	{"\x1b[A\x1b[A!\r", "second!", nil},
+
+	// Searching the history.
+	{"\x12ir\r", "third", nil},
+	{"\x12ir\x12\r", "first", nil},
+	{"\x12ir\x12\x12\r", "first", nil},
+	{"\x12sec\x1b[Cx\r", "secondx", nil},
+	{"orig\x12sec\x07\r", "orig", nil},
+	{"\x12zzz\r", "", nil},
+
+	// Tab completion; see editorComplete.
+	{"ec\t\r", "echo ", nil},
+	{"echo fo\tx\r", "echo foo.sh x", nil},
+	{"echo b\t\r", "echo ba", nil},
+	{"echo ba\t\r", "echo ba", nil},
+	{"echo dir\t\r", "echo dir/", nil},
+	{"echo zzz\t\r", "echo zzz", nil},
+}
+
+var editorHistory = []string{"first", "second", "third"}
+
+func editorComplete(line string, pos int) (int, []string) {
+	start := strings.LastIndexByte(line[:pos], ' ') + 1
+	var candidates []string
+	for _, c := range []string{"echo", "foo.sh", "bar", "baz", "dir/"} {
+		if strings.HasPrefix(c, line[start:pos]) {
+			candidates = append(candidates, c)
+		}
+	}
+	return start, candidates
+}
+
+func TestEditor(t *testing.T) {
+	t.Parallel()
+	for _, tc := range editorTests {
+		t.Run("", func(t *testing.T) {
+			e := newEditor(strings.NewReader(tc.in), io.Discard)
+			e.complete = editorComplete
+			for _, line := range editorHistory {
+				e.addHistory(line)
+			}
+			got, err := e.readLine("$ ")
+			if err != tc.wantErr {
+				t.Fatalf("readLine(%q) error: want %v, got %v", tc.in, tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Fatalf("readLine(%q): want %q, got %q", tc.in, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	for _, name := range []string{"foo.sh", "foo.txt", ".hidden", "sub/bar"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, err := interp.New(interp.Dir(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Reset()
+	tests := []struct {
+		line      string
+		wantStart int
+		want      []string
+	}{
+		{"cat fo", 4, []string{"foo.sh", "foo.txt"}},
+		{"cat ", 4, []string{"foo.sh", "foo.txt", "sub/"}},
+		{"cat .h", 4, []string{".hidden"}},
+		{"cat sub/", 4, []string{"sub/bar"}},
+		{"cat <fo", 5, []string{"foo.sh", "foo.txt"}},
+		{"cd ", 3, []string{"sub/"}},
+		{"true; cd s", 9, []string{"sub/"}},
+		{"ech", 0, []string{"echo"}},
+		{"FOO=bar ech", 8, []string{"echo"}},
+		{"true && unal", 8, []string{"unalias"}},
+		{"./fo", 0, []string{"./foo.sh", "./foo.txt"}},
+		{"cat nomatch", 4, nil},
+	}
+	for _, tc := range tests {
+		start, got := complete(r, tc.line, len(tc.line))
+		if start != tc.wantStart || !slices.Equal(got, tc.want) {
+			t.Errorf("complete(%q): want %d %q, got %d %q",
+				tc.line, tc.wantStart, tc.want, start, got)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	t.Parallel()
+	colors := strings.NewReplacer(
+		"{K}", colorReset+colorKeyword,
+		"{C}", colorReset+colorCommand,
+		"{S}", colorReset+colorString,
+		"{V}", colorReset+colorVariable,
+		"{#}", colorReset+colorComment,
+		"{}", colorReset,
+	)
+	tests := []struct {
+		line, want string
+	}{
+		{"", ""},
+		{"echo foo", "{C}echo{} foo"},
+		{"  ls -l # list", "  {C}ls{} -l {#}# list{}"},
+		{"echo a#b", "{C}echo{} a#b"},
+		{"echo 'a b' \"c $d e\" f", "{C}echo{} {S}'a b'{} {S}\"c {V}$d{S} e\"{} f"},
+		{"echo \"unclosed $x", "{C}echo{} {S}\"unclosed {V}$x{}"},
+		{"echo ${a:-b c} $(cd x; pwd)", "{C}echo{} {V}${a:-b c}{} {V}$(cd x; pwd){}"},
+		{"echo a\\ b $1$@x", "{C}echo{} a\\ b {V}$1$@{}x"},
+		{"FOO=bar go build | grep x", "FOO=bar {C}go{} build | {C}grep{} x"},
+		{"if true; then echo; fi", "{K}if{} {C}true{}; {K}then{} {C}echo{}; {K}fi{}"},
+		{"for x in a; do f; done >out", "{K}for{} x in a; {K}do{} {C}f{}; {K}done{} >out"},
+		{"! { e\"c\"ho; }", "{K}!{} {K}{{} {C}e{S}\"c\"{C}ho{}; {K}}{}"},
+	}
+	for _, tc := range tests {
+		want := colors.Replace(tc.want)
+		if got := highlight(tc.line); got != want {
+			t.Errorf("highlight(%q):\nwant: %q\ngot:  %q", tc.line, want, got)
+		}
+	}
+}
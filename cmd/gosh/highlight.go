@@ -0,0 +1,224 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// The colors used by [highlight], as ANSI escape sequences.
+const (
+	colorNone     = ""
+	colorKeyword  = "\x1b[35m"   // magenta
+	colorCommand  = "\x1b[1;32m" // bold green
+	colorString   = "\x1b[33m"   // yellow
+	colorVariable = "\x1b[36m"   // cyan
+	colorComment  = "\x1b[90m"   // grey
+	colorReset    = "\x1b[0m"
+)
+
+// highlight colors a line of shell input for a terminal.
+//
+// Since a line is often incomplete while it is being typed, such as when a
+// quote or a compound command isn't closed yet, the line is scanned
+// loosely rather than parsed.
+func highlight(line string) string {
+	h := &highlighter{}
+	cmdPos := true // whether the next word is a command name
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t':
+			h.write(colorNone, line[i:i+1])
+			i++
+		case c == '#' && (i == 0 || strings.IndexByte(" \t;&|()", line[i-1]) >= 0):
+			h.write(colorComment, line[i:])
+			i = len(line)
+		case strings.IndexByte(";&|()<>", c) >= 0:
+			h.write(colorNone, line[i:i+1])
+			if c != '<' && c != '>' && c != ')' {
+				cmdPos = true
+			}
+			i++
+		default:
+			end := wordEnd(line, i)
+			word := line[i:end]
+			switch {
+			case cmdPos && syntax.IsKeyword(word):
+				h.write(colorKeyword, word)
+				switch word {
+				case "for", "select", "case", "function", "[[", "]]", "}", "fi", "done", "esac":
+					// These are followed by names or arguments,
+					// or they end a command.
+					cmdPos = false
+				}
+			case cmdPos && isAssign(word):
+				h.word(word, colorNone)
+			case cmdPos:
+				h.word(word, colorCommand)
+				cmdPos = false
+			default:
+				h.word(word, colorNone)
+			}
+			i = end
+		}
+	}
+	h.write(colorNone, "")
+	return h.String()
+}
+
+// useColor reports whether the terminal should show colors, following
+// https://no-color.org.
+func useColor() bool {
+	_, noColor := os.LookupEnv("NO_COLOR")
+	return !noColor && os.Getenv("TERM") != "dumb"
+}
+
+// isAssign reports whether a word looks like an assignment such as "foo=bar".
+func isAssign(word string) bool {
+	name, _, ok := strings.Cut(word, "=")
+	name = strings.TrimSuffix(name, "+")
+	return ok && syntax.ValidName(name)
+}
+
+type highlighter struct {
+	strings.Builder
+	color string
+}
+
+// write writes s in a color, only switching colors when needed.
+func (h *highlighter) write(color, s string) {
+	if color != h.color {
+		h.WriteString(colorReset)
+		h.WriteString(color)
+		h.color = color
+	}
+	h.WriteString(s)
+}
+
+// word writes a word, coloring its quotes and expansions,
+// and the rest with a base color.
+func (h *highlighter) word(word, base string) {
+	for i := 0; i < len(word); {
+		switch word[i] {
+		case '\\':
+			end := min(i+2, len(word))
+			h.write(base, word[i:end])
+			i = end
+		case '\'':
+			end := quoteEnd(word, i)
+			h.write(colorString, word[i:end])
+			i = end
+		case '"':
+			end := quoteEnd(word, i)
+			for j := i; j < end; {
+				if word[j] == '$' || word[j] == '`' {
+					k := expansionEnd(word[:end], j)
+					h.write(colorVariable, word[j:k])
+					j = k
+					continue
+				}
+				k := j + 1
+				if word[j] == '\\' {
+					k = min(j+2, end)
+				}
+				h.write(colorString, word[j:k])
+				j = k
+			}
+			i = end
+		case '$', '`':
+			end := expansionEnd(word, i)
+			h.write(colorVariable, word[i:end])
+			i = end
+		default:
+			h.write(base, word[i:i+1])
+			i++
+		}
+	}
+}
+
+// wordEnd returns the end of the word starting at s[i], skipping over quotes
+// and expansions which may contain spaces.
+func wordEnd(s string, i int) int {
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == ' ' || c == '\t' || strings.IndexByte(";&|()<>", c) >= 0:
+			return i
+		case c == '\\':
+			i = min(i+2, len(s))
+		case c == '\'' || c == '"':
+			i = quoteEnd(s, i)
+		case c == '$' || c == '`':
+			i = expansionEnd(s, i)
+		default:
+			i++
+		}
+	}
+	return i
+}
+
+// quoteEnd returns the end of the quoted string starting at s[i],
+// or the end of s if the quote is not closed.
+func quoteEnd(s string, i int) int {
+	quote := s[i]
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quote == '"' {
+				i++
+			}
+		case quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// expansionEnd returns the end of the expansion starting at s[i], such as
+// "$foo", "${foo}", "$(foo)", or "`foo`", or the end of s if it is not closed.
+func expansionEnd(s string, i int) int {
+	if s[i] == '`' {
+		if j := strings.IndexByte(s[i+1:], '`'); j >= 0 {
+			return i + 1 + j + 1
+		}
+		return len(s)
+	}
+	i++ // the dollar sign
+	if i >= len(s) {
+		return i
+	}
+	switch c := s[i]; {
+	case c == '{' || c == '(':
+		closing := byte('}')
+		if c == '(' {
+			closing = ')'
+		}
+		depth := 0
+		for ; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '\'', '"':
+				i = quoteEnd(s, i) - 1
+			case c:
+				depth++
+			case closing:
+				if depth--; depth == 0 {
+					return i + 1
+				}
+			}
+		}
+		return len(s)
+	case strings.IndexByte("@*#?-$!0123456789", c) >= 0:
+		return i + 1
+	}
+	for i < len(s) && (s[i] == '_' || 'a' <= s[i] && s[i] <= 'z' ||
+		'A' <= s[i] && s[i] <= 'Z' || '0' <= s[i] && s[i] <= '9') {
+		i++
+	}
+	return i
+}
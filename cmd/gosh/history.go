@@ -0,0 +1,60 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// historySize is the number of lines kept in the history file.
+const historySize = 1000
+
+// historyFile returns the path to the history file, which is $HISTFILE
+// or ~/.gosh_history by default. It is empty if the history is not saved.
+func historyFile(r *interp.Runner) string {
+	if vr := lookupVar(r, "HISTFILE"); vr.IsSet() {
+		return vr.String()
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gosh_history")
+}
+
+// loadHistory returns the last lines in the history file.
+// If the file has grown past [historySize], it is truncated.
+func loadHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) > historySize {
+		lines = lines[len(lines)-historySize:]
+		// Errors are ignored, as the history is not essential.
+		os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+	}
+	return lines
+}
+
+// appendHistory appends a line to the history file.
+func appendHistory(path, line string) {
+	if path == "" || strings.TrimSpace(line) == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return
+	}
+	f.WriteString(line + "\n")
+	f.Close()
+}
@@ -5,11 +5,14 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"flag"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"golang.org/x/term"
@@ -18,41 +21,178 @@ import (
 	"mvdan.cc/sh/v3/syntax"
 )
 
-var command = flag.String("c", "", "command to be executed")
+func usage(w io.Writer) {
+	fmt.Fprint(w, `usage: gosh [options] [file [argument...]]
+       gosh [options] -c command [name [argument...]]
+       gosh [options] -s [argument...]
+
+Shell options like -e, -u, -x, or -o pipefail are enabled with a leading '-'
+and disabled with a leading '+', just like with the "set" builtin.
+
+  -c      run the command string, setting $0 and the parameters from the rest
+  -s      read the commands from stdin, setting the parameters from the rest
+  -i      act as an interactive shell
+  -l      act as a login shell, sourcing /etc/profile and ~/.profile
+  --norc  do not source ~/.goshrc in an interactive shell
+  --help  show this help text
+`)
+}
+
+// invocation holds the command line arguments to gosh, which follow sh(1).
+type invocation struct {
+	command     bool // -c
+	stdin       bool // -s
+	interactive bool // -i
+	login       bool // -l or --login
+	noRC        bool // --norc
+
+	// shellOpts holds the shell options for [interp.Params], such as "-e",
+	// "+x", or "-o" followed by "pipefail".
+	shellOpts []string
+	// operands holds the arguments following the options.
+	operands []string
+}
+
+// errHelp is returned by [parseArgs] when --help is given.
+var errHelp = errors.New("help requested")
+
+func parseArgs(list []string) (*invocation, error) {
+	a := &invocation{}
+	for len(list) > 0 {
+		arg := list[0]
+		switch arg {
+		case "--", "-":
+			// Like other shells, a lone "-" also ends the options.
+			a.operands = list[1:]
+			return a, nil
+		case "--help":
+			return nil, errHelp
+		case "--norc", "-norc":
+			a.noRC = true
+			list = list[1:]
+			continue
+		case "--login":
+			a.login = true
+			list = list[1:]
+			continue
+		}
+		if strings.HasPrefix(arg, "--") {
+			return nil, fmt.Errorf("invalid option: %q", arg)
+		}
+		if len(arg) < 2 || (arg[0] != '-' && arg[0] != '+') {
+			break // the first operand
+		}
+		list = list[1:]
+		sign := arg[:1]
+		for _, c := range arg[1:] {
+			switch {
+			case sign == "-" && c == 'c':
+				a.command = true
+			case sign == "-" && c == 's':
+				a.stdin = true
+			case sign == "-" && c == 'i':
+				a.interactive = true
+			case sign == "-" && c == 'l':
+				a.login = true
+			case c == 'o':
+				if len(list) == 0 {
+					return nil, fmt.Errorf("%so: option requires an argument", sign)
+				}
+				a.shellOpts = append(a.shellOpts, sign+"o", list[0])
+				list = list[1:]
+			default:
+				a.shellOpts = append(a.shellOpts, sign+string(c))
+			}
+		}
+	}
+	if a.command && len(list) == 0 {
+		return nil, fmt.Errorf("-c: option requires an argument")
+	}
+	a.operands = list
+	return a, nil
+}
 
 func main() {
-	flag.Parse()
-	err := runAll()
+	err := runAll(os.Args[1:])
 	if e, ok := interp.IsExitStatus(err); ok {
 		os.Exit(int(e))
 	}
-	if err != nil {
+	var usageErr usageError
+	switch {
+	case err == errHelp:
+		usage(os.Stdout)
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(os.Stderr, "gosh: %v\n", usageErr.err)
+		usage(os.Stderr)
+		os.Exit(2)
+	case err != nil:
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func runAll() error {
-	r, err := interp.New(interp.StdIO(os.Stdin, os.Stdout, os.Stderr))
-	if err != nil {
+// usageError is an error in the command line arguments, like an unknown option.
+type usageError struct{ err error }
+
+func (u usageError) Error() string { return u.err.Error() }
+
+func runAll(list []string) error {
+	a, err := parseArgs(list)
+	if err == errHelp {
 		return err
 	}
-
-	if *command != "" {
-		return run(r, strings.NewReader(*command), "")
+	if err != nil {
+		return usageError{err}
 	}
-	if flag.NArg() == 0 {
-		if term.IsTerminal(int(os.Stdin.Fd())) {
-			return runInteractive(r, os.Stdin, os.Stdout, os.Stderr)
+	// The operands are the positional parameters, except for the command
+	// string and its name with -c, or the script file without -c or -s.
+	params := a.operands
+	name := ""
+	switch {
+	case a.command:
+		params = params[1:]
+		if len(params) > 0 {
+			name, params = params[0], params[1:]
 		}
+	case !a.stdin && len(params) > 0:
+		params = params[1:]
+	}
+	readStdin := !a.command && (a.stdin || len(a.operands) == 0)
+	stdinTerm := readStdin && term.IsTerminal(int(os.Stdin.Fd()))
+	interactive := a.interactive || stdinTerm
+	var tty *os.File
+	if interactive && stdinTerm {
+		tty = takeTerminal(os.Stdin)
+	}
+	r, err := interp.New(
+		interp.StdIO(os.Stdin, os.Stdout, os.Stderr),
+		interp.Interactive(interactive),
+		interp.JobControl(tty),
+		interp.ProcessLimits(true),
+		interp.Params(append(a.shellOpts, append([]string{"--"}, params...)...)...),
+	)
+	if err != nil {
+		return usageError{err}
+	}
+	if err := startup(r, a.login, interactive && !a.noRC, os.Stderr); err != nil {
+		return err
+	}
+
+	switch {
+	case a.command:
+		return run(r, strings.NewReader(a.operands[0]), name)
+	case interactive:
+		return runInteractive(r, os.Stdin, os.Stdout, os.Stderr)
+	case readStdin:
 		return run(r, os.Stdin, "")
 	}
-	for _, path := range flag.Args() {
-		if err := runPath(r, path); err != nil {
-			return err
-		}
+	err = runPath(r, a.operands[0])
+	if errors.Is(err, fs.ErrNotExist) {
+		// Like sh, a missing script file is like a command not found.
+		fmt.Fprintf(os.Stderr, "gosh: %v\n", err)
+		return interp.NewExitStatus(127)
 	}
-	return nil
+	return err
 }
 
 func run(r *interp.Runner, reader io.Reader, name string) error {
@@ -60,7 +200,7 @@ func run(r *interp.Runner, reader io.Reader, name string) error {
 	if err != nil {
 		return err
 	}
-	r.Reset()
+	// Don't reset the runner, to keep what the startup files did.
 	ctx := context.Background()
 	return r.Run(ctx, prog)
 }
@@ -74,15 +214,78 @@ func runPath(r *interp.Runner, path string) error {
 	return run(r, f, path)
 }
 
+// startup sources the files which initialize the shell, ignoring those which
+// don't exist: /etc/profile and ~/.profile for a login shell, and then ~/.goshrc
+// if rc is true. Errors are printed to stderr without stopping the shell,
+// unless a file made it exit.
+func startup(r *interp.Runner, login, rc bool, stderr io.Writer) error {
+	home, _ := os.UserHomeDir()
+	var paths []string
+	if login {
+		paths = append(paths, "/etc/profile")
+		if home != "" {
+			paths = append(paths, filepath.Join(home, ".profile"))
+		}
+	}
+	if rc && home != "" {
+		paths = append(paths, filepath.Join(home, ".goshrc"))
+	}
+	ctx := context.Background()
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "gosh: %v\n", err)
+			continue
+		}
+		prog, err := syntax.NewParser().Parse(f, path)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "gosh: %v\n", err)
+			continue
+		}
+		// Run each statement, as running a whole file implies an exit.
+		for _, stmt := range prog.Stmts {
+			err := r.Run(ctx, stmt)
+			if r.Exited() {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func runInteractive(r *interp.Runner, stdin io.Reader, stdout, stderr io.Writer) error {
 	parser := syntax.NewParser()
-	fmt.Fprintf(stdout, "$ ")
+	hr := &historyReader{runner: r, stderr: stderr}
+	if f, ok := stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		tr := &termReader{fd: int(f.Fd()), ed: newEditor(f, stdout)}
+		tr.ed.complete = func(line string, pos int) (int, []string) {
+			return complete(r, line, pos)
+		}
+		if useColor() {
+			tr.ed.highlight = highlight
+		}
+		tr.histFile = historyFile(r)
+		for _, line := range loadHistory(tr.histFile) {
+			tr.ed.addHistory(line)
+			r.AddHistory(line)
+		}
+		hr.lines = tr
+	} else {
+		hr.lines = &plainReader{br: bufio.NewReader(stdin), out: stdout}
+	}
+	hr.prompt = prompt(r, "PS1", "$ ", stderr)
 	var runErr error
 	fn := func(stmts []*syntax.Stmt) bool {
 		if parser.Incomplete() {
-			fmt.Fprintf(stdout, "> ")
+			hr.prompt = prompt(r, "PS2", "> ", stderr)
 			return true
 		}
+		r.AddHistory(hr.cmd.String())
+		hr.cmd.Reset()
 		ctx := context.Background()
 		for _, stmt := range stmts {
 			runErr = r.Run(ctx, stmt)
@@ -90,11 +293,122 @@ func runInteractive(r *interp.Runner, stdin io.Reader, stdout, stderr io.Writer)
 				return false
 			}
 		}
-		fmt.Fprintf(stdout, "$ ")
+		hr.prompt = prompt(r, "PS1", "$ ", stderr)
 		return true
 	}
-	if err := parser.Interactive(stdin, fn); err != nil {
-		return err
+	for {
+		err := parser.Interactive(hr, fn)
+		var parseErr syntax.ParseError
+		switch {
+		case err == errInterrupted:
+			// Ctrl-C discards the command being typed, even if it
+			// spans multiple lines, so start parsing again.
+		case errors.As(err, &parseErr) && !parseErr.Incomplete:
+			// Like Bash, a syntax error discards the command,
+			// but the shell keeps on reading the next ones.
+			// Incomplete input can only mean that the input ended.
+			fmt.Fprintf(stderr, "gosh: %v\n", err)
+		case err != nil:
+			return err
+		default:
+			return runErr
+		}
+		hr.pending = ""
+		hr.cmd.Reset()
+		hr.prompt = prompt(r, "PS1", "$ ", stderr)
+	}
+}
+
+// prompt returns the prompt string in the variable name, such as PS1,
+// or def if the variable is not set.
+func prompt(r *interp.Runner, name, def string, stderr io.Writer) string {
+	ps := def
+	if vr := r.Vars[name]; vr.IsSet() {
+		ps = vr.String()
+	}
+	prompt, err := r.Prompt(context.Background(), ps)
+	if err != nil {
+		fmt.Fprintf(stderr, "gosh: %v\n", err)
+		prompt = def
+	}
+	return prompt
+}
+
+// lineReader reads lines of input for an interactive shell, showing a prompt
+// first. Lines include their trailing newline, if any.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// plainReader reads lines as they are, such as when the input isn't a terminal.
+type plainReader struct {
+	br  *bufio.Reader
+	out io.Writer
+}
+
+func (p *plainReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(p.out, prompt)
+	return p.br.ReadString('\n')
+}
+
+// termReader reads lines from a terminal with an [editor],
+// saving them to a history file if it is not empty.
+type termReader struct {
+	fd       int
+	ed       *editor
+	histFile string
+}
+
+func (t *termReader) readLine(prompt string) (string, error) {
+	state, err := term.MakeRaw(t.fd)
+	if err != nil {
+		return "", err
+	}
+	line, err := t.ed.readLine(prompt)
+	term.Restore(t.fd, state)
+	if err != nil {
+		return "", err
+	}
+	n := len(t.ed.history)
+	t.ed.addHistory(line)
+	if len(t.ed.history) > n {
+		appendHistory(t.histFile, line)
+	}
+	return line + "\n", nil
+}
+
+// historyReader performs history expansion on each line of input, like Bash,
+// and keeps the lines of the command being parsed to add it to the history.
+type historyReader struct {
+	runner *interp.Runner
+	lines  lineReader
+	stderr io.Writer
+
+	prompt  string          // the prompt to show for the next line
+	pending string          // the rest of the current line, not read yet
+	cmd     strings.Builder // the lines of the current command
+}
+
+func (h *historyReader) Read(p []byte) (int, error) {
+	if h.pending == "" {
+		line, err := h.lines.readLine(h.prompt)
+		if line == "" {
+			return 0, err
+		}
+		expanded, err := h.runner.ExpandHistory(line)
+		switch {
+		case err != nil:
+			// Like Bash, drop the line entirely.
+			fmt.Fprintf(h.stderr, "gosh: %v\n", err)
+			expanded = "\n"
+		case expanded != line:
+			// Like Bash, show the command after expansion.
+			fmt.Fprint(h.stderr, expanded)
+		}
+		h.cmd.WriteString(expanded)
+		h.pending = expanded
 	}
-	return runErr
+	n := copy(p, h.pending)
+	h.pending = h.pending[n:]
+	return n, nil
 }
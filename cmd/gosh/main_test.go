@@ -6,8 +6,12 @@ package main
 import (
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
+	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
 )
 
@@ -141,12 +145,12 @@ var interactiveTests = []struct {
 	},
 	{
 		pairs: []string{
-			"echo *; :\n",
+			"echo main*; :\n",
 			"main.go main_test.go\n$ ",
-			"echo *\n",
+			"echo main*\n",
 			"main.go main_test.go\n$ ",
 			"shopt -s globstar; echo **\n",
-			"main.go main_test.go\n$ ",
+			"complete.go editor.go editor_test.go highlight.go history.go main.go main_test.go os_notunix.go os_unix.go\n$ ",
 		},
 	},
 	{
@@ -173,6 +177,70 @@ var interactiveTests = []struct {
 		},
 		wantErr: "1:1: reached EOF without matching ( with )",
 	},
+	{
+		pairs: []string{
+			"echo foo\n",
+			"foo\n$ ",
+			"!!\n",
+			"echo foo\nfoo\n$ ",
+			"echo a 'b c' d\n",
+			"a b c d\n$ ",
+			"echo !^ !$ '!$'\n",
+			"echo a d '!$'\na d !$\n$ ",
+			"!-3 bar\n",
+			"echo foo bar\nfoo bar\n$ ",
+			"^bar^baz\n",
+			"echo foo baz\nfoo baz\n$ ",
+			"!nope\n",
+			"gosh: !nope: event not found\n$ ",
+		},
+	},
+	{
+		pairs: []string{
+			"echo foo\n",
+			"foo\n$ ",
+			"if true\n",
+			"> ",
+			"then echo bar; fi\n",
+			"bar\n$ ",
+			"fc -l\n",
+			"1\t echo foo\n2\t if true\nthen echo bar; fi\n$ ",
+			"fc -s foo=qux 1\n",
+			"echo qux\nqux\n$ ",
+			"fc -ln -2\n",
+			"\t fc -l\n\t echo qux\n$ ",
+		},
+	},
+	{
+		pairs: []string{
+			"echo )\n",
+			"gosh: 1:6: a command can only contain words and redirects; encountered )\n$ ",
+			"echo a; if true; then\n",
+			"> ",
+			"fi fi\n",
+			"gosh: 2:4: statements must be separated by &, ; or a newline\n$ ",
+			"echo after\n",
+			"after\n$ ",
+		},
+	},
+	{
+		pairs: []string{
+			"alias greet='echo hi'\n",
+			"$ ",
+			"greet there\n",
+			"hi there\n$ ",
+		},
+	},
+	{
+		pairs: []string{
+			"PS1='\\[\\e[1m\\]$foo: \\[\\e[0m\\]' PS2='>> ' foo=bar\n",
+			"\x1b[1mbar: \x1b[0m",
+			"echo 'x\n",
+			">> ",
+			"y'\n",
+			"x\ny\n\x1b[1mbar: \x1b[0m",
+		},
+	},
 }
 
 func TestInteractive(t *testing.T) {
@@ -181,7 +249,10 @@ func TestInteractive(t *testing.T) {
 		t.Run("", func(t *testing.T) {
 			inReader, inWriter := io.Pipe()
 			outReader, outWriter := io.Pipe()
-			runner, _ := interp.New(interp.StdIO(inReader, outWriter, outWriter))
+			runner, _ := interp.New(
+				interp.StdIO(inReader, outWriter, outWriter),
+				interp.Interactive(true),
+			)
 			errc := make(chan error, 1)
 			go func() {
 				errc <- runInteractive(runner, inReader, outWriter, outWriter)
@@ -236,6 +307,103 @@ func TestInteractiveExit(t *testing.T) {
 	}
 }
 
+func TestStartup(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	files := map[string]string{
+		".profile": "profile=1",
+		".goshrc":  "rc=1; ${exit_early:+exit 3}",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(home, name), []byte(src), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		login, rc bool
+		want      string
+	}{
+		{false, false, ","},
+		{true, false, "1,"},
+		{false, true, ",1"},
+		{true, true, "1,1"},
+	}
+	for _, tc := range tests {
+		r, _ := interp.New(interp.StdIO(nil, io.Discard, io.Discard))
+		if err := startup(r, tc.login, tc.rc, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Vars["profile"].String() + "," + r.Vars["rc"].String(); got != tc.want {
+			t.Errorf("startup(login=%v, rc=%v) ran %q, want %q", tc.login, tc.rc, got, tc.want)
+		}
+	}
+
+	// A startup file can make the shell exit.
+	r, _ := interp.New(
+		interp.Env(expand.ListEnviron("HOME="+home, "exit_early=1")),
+		interp.StdIO(nil, io.Discard, io.Discard),
+	)
+	err := startup(r, true, true, io.Discard)
+	if status, ok := interp.IsExitStatus(err); !ok || status != 3 {
+		t.Fatalf("want exit status 3, got: %v", err)
+	}
+}
+
+func TestParseArgs(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		args    []string
+		want    invocation
+		wantErr string
+	}{
+		{args: nil, want: invocation{}},
+		{
+			args: []string{"script.sh", "-e", "arg"},
+			want: invocation{operands: []string{"script.sh", "-e", "arg"}},
+		},
+		{
+			args: []string{"-c", "echo $0 $1", "name", "arg"},
+			want: invocation{command: true, operands: []string{"echo $0 $1", "name", "arg"}},
+		},
+		{
+			args: []string{"-ec", "cmd"},
+			want: invocation{command: true, shellOpts: []string{"-e"}, operands: []string{"cmd"}},
+		},
+		{
+			args: []string{"-s", "-x", "+u", "-o", "pipefail", "+o", "noglob", "--", "-a"},
+			want: invocation{
+				stdin:     true,
+				shellOpts: []string{"-x", "+u", "-o", "pipefail", "+o", "noglob"},
+				operands:  []string{"-a"},
+			},
+		},
+		{
+			args: []string{"-il", "--norc", "-", "file"},
+			want: invocation{interactive: true, login: true, noRC: true, operands: []string{"file"}},
+		},
+		{args: []string{"-c"}, wantErr: "-c: option requires an argument"},
+		{args: []string{"-eo"}, wantErr: "-o: option requires an argument"},
+		{args: []string{"--nope"}, wantErr: `invalid option: "--nope"`},
+		{args: []string{"--help"}, wantErr: "help requested"},
+	}
+	for _, tc := range tests {
+		got, err := parseArgs(tc.args)
+		if tc.wantErr != "" {
+			if fmt.Sprint(err) != tc.wantErr {
+				t.Errorf("parseArgs(%q): want error %q, got %v", tc.args, tc.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseArgs(%q): unexpected error: %v", tc.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tc.want) {
+			t.Errorf("parseArgs(%q):\nwant: %+v\ngot:  %+v", tc.args, tc.want, *got)
+		}
+	}
+}
+
 // readString will keep reading from a reader until all bytes from the supplied
 // string are read.
 func readString(r io.Reader, want string) error {
@@ -0,0 +1,12 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build !unix
+
+package main
+
+import "os"
+
+// takeTerminal returns nil, as job control is only supported on Unix-like
+// systems.
+func takeTerminal(*os.File) *os.File { return nil }
@@ -0,0 +1,38 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// takeTerminal prepares an interactive shell for job control, returning the
+// terminal to give to [interp.JobControl], or nil if it cannot be used.
+//
+// Like Bash, the shell leads its own process group in the foreground, and it
+// catches the signals which would otherwise stop it when the user presses
+// Ctrl-Z or when it uses the terminal while a program is in the foreground.
+// The signals are caught rather than ignored, as programs inherit the signals
+// ignored by the shell.
+func takeTerminal(tty *os.File) *os.File {
+	fd := int(tty.Fd())
+	signal.Ignore(syscall.SIGTTOU)
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGTSTP, syscall.SIGTTIN)
+	pid := unix.Getpid()
+	if unix.Getpgrp() != pid {
+		// This fails if the shell leads a session, which is fine,
+		// as it then leads a process group as well.
+		unix.Setpgid(0, 0)
+	}
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPGRP, unix.Getpgrp()); err != nil {
+		return nil
+	}
+	return tty
+}
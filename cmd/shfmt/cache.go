@@ -0,0 +1,90 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+)
+
+// formatCache remembers which files were already formatted, as requested via
+// --cache, so that later runs can skip parsing and printing them again.
+//
+// Each entry is an empty file named after a hash of the shfmt build, the
+// options used, and the formatted source. Editing a file or changing any of
+// the options simply results in a different key, so entries never need to be
+// invalidated, and concurrent runs of shfmt can safely share a cache.
+type formatCache struct {
+	dir     string
+	buildID string
+}
+
+func openCache() (*formatCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("cannot use --cache: %w", err)
+	}
+	return newCache(filepath.Join(dir, "shfmt")), nil
+}
+
+func newCache(dir string) *formatCache {
+	return &formatCache{dir: dir, buildID: buildID()}
+}
+
+// buildID identifies the shfmt build, as different versions may format the
+// same source differently.
+func buildID() string {
+	id := version
+	if info, ok := debug.ReadBuildInfo(); ok {
+		id += " " + info.Main.Version
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified":
+				id += " " + setting.Value
+			}
+		}
+	}
+	// Development builds may not have any version information at all.
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			id += fmt.Sprintf(" %d %d", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return id
+}
+
+// key returns the cache key for formatting src with the options described by
+// opts.
+func (c *formatCache) key(opts string, src []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00", c.buildID, opts)
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *formatCache) path(key string) string {
+	return filepath.Join(c.dir, key[:2], key)
+}
+
+// has reports whether the cache has an entry for key.
+func (c *formatCache) has(key string) bool {
+	_, err := os.Stat(c.path(key))
+	return err == nil
+}
+
+// add adds an entry for key to the cache. Errors are ignored,
+// as failing to add an entry only means formatting a file again.
+func (c *formatCache) add(key string) {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return
+	}
+	if f, err := os.Create(path); err == nil {
+		f.Close()
+	}
+}
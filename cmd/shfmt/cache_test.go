@@ -0,0 +1,30 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestFormatCache(t *testing.T) {
+	t.Parallel()
+
+	c := newCache(t.TempDir())
+	src := []byte("echo foo\n")
+	key := c.key("indent=0", src)
+	qt.Assert(t, qt.HasLen(key, 64))
+	qt.Check(t, qt.Equals(c.key("indent=0", src), key))
+	qt.Check(t, qt.Not(qt.Equals(c.key("indent=2", src), key)))
+	qt.Check(t, qt.Not(qt.Equals(c.key("indent=0", []byte("echo bar\n")), key)))
+
+	qt.Check(t, qt.IsFalse(c.has(key)))
+	c.add(key)
+	qt.Check(t, qt.IsTrue(c.has(key)))
+
+	// Another build of shfmt does not reuse the entries.
+	other := &formatCache{dir: c.dir, buildID: c.buildID + " modified"}
+	qt.Check(t, qt.IsFalse(other.has(other.key("indent=0", src))))
+}
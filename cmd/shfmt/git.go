@@ -0,0 +1,116 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// gitChange is a file changed according to git, as found via --staged or
+// --since, along with the lines which were added or modified.
+type gitChange struct {
+	path  string
+	lines []lineRange
+}
+
+// gitChanges returns the files changed in the index when staged is true,
+// or otherwise those changed in the working tree since a revision.
+// Deleted files are skipped, and paths may limit which files are included.
+func gitChanges(staged bool, since string, paths []string) ([]gitChange, error) {
+	args := []string{
+		"-c", "core.quotePath=false",
+		"diff", "--unified=0", "--no-color", "--no-ext-diff", "--relative",
+		"--src-prefix=a/", "--dst-prefix=b/", "--diff-filter=d",
+	}
+	if staged {
+		args = append(args, "--cached")
+	} else {
+		args = append(args, since)
+	}
+	args = append(args, "--")
+	args = append(args, paths...)
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git diff: %s", msg)
+		}
+		return nil, fmt.Errorf("git diff: %w", err)
+	}
+	return parseGitDiff(bytes.NewReader(out))
+}
+
+// parseGitDiff parses the output of "git diff --unified=0".
+func parseGitDiff(r io.Reader) ([]gitChange, error) {
+	var changes []gitChange
+	var cur *gitChange
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "diff "):
+			cur = nil
+		case strings.HasPrefix(line, "+++ ") && cur == nil:
+			name := strings.TrimPrefix(line, "+++ ")
+			if name == "/dev/null" {
+				continue
+			}
+			if strings.HasPrefix(name, `"`) {
+				// Git quotes unusual names like C strings.
+				unquoted, err := strconv.Unquote(name)
+				if err != nil {
+					return nil, fmt.Errorf("invalid git diff path: %s", name)
+				}
+				name = unquoted
+			}
+			name, ok := strings.CutPrefix(name, "b/")
+			if !ok {
+				return nil, fmt.Errorf("invalid git diff path: %s", name)
+			}
+			changes = append(changes, gitChange{path: filepath.FromSlash(name), lines: []lineRange{}})
+			cur = &changes[len(changes)-1]
+		case strings.HasPrefix(line, "@@ ") && cur != nil:
+			rng, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			if rng != (lineRange{}) {
+				cur.lines = append(cur.lines, rng)
+			}
+		}
+	}
+	return changes, scanner.Err()
+}
+
+// parseHunkHeader returns the lines added by a hunk with a header like
+// "@@ -10,2 +12,3 @@", or the zero value if it only removes lines.
+func parseHunkHeader(line string) (lineRange, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return lineRange{}, fmt.Errorf("invalid git diff hunk: %s", line)
+	}
+	start, count, hasCount := strings.Cut(fields[2][1:], ",")
+	first, err := strconv.Atoi(start)
+	n := 1
+	if err == nil && hasCount {
+		n, err = strconv.Atoi(count)
+	}
+	if err != nil {
+		return lineRange{}, fmt.Errorf("invalid git diff hunk: %s", line)
+	}
+	if n == 0 {
+		return lineRange{}, nil
+	}
+	return lineRange{first, first + n - 1}, nil
+}
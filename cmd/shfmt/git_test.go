@@ -0,0 +1,60 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseGitDiff(t *testing.T) {
+	t.Parallel()
+	diff := `diff --git a/foo.sh b/foo.sh
+index 1111111..2222222 100644
+--- a/foo.sh
++++ b/foo.sh
+@@ -2 +2 @@ foo
+-echo a
++echo  b
+@@ -10,3 +10,0 @@ bar
+-x
+-y
+-z
+@@ -20,0 +18,2 @@
++++ not a header
++echo c
+diff --git a/dir/new.sh b/dir/new.sh
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/dir/new.sh
+@@ -0,0 +1,3 @@
++a
++b
++c
+diff --git a/img.png b/img.png
+index 4444444..5555555 100644
+Binary files a/img.png and b/img.png differ
+diff --git "a/sp ace\"s.sh" "b/sp ace\"s.sh"
+--- "a/sp ace\"s.sh"
++++ "b/sp ace\"s.sh"
+@@ -1 +1 @@
+-a
++b
+`
+	changes, err := parseGitDiff(strings.NewReader(diff))
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.CmpEquals(changes, []gitChange{
+		{"foo.sh", []lineRange{{2, 2}, {18, 19}}},
+		{filepath.FromSlash("dir/new.sh"), []lineRange{{1, 3}}},
+		{`sp ace"s.sh`, []lineRange{{1, 1}}},
+	}, cmp.AllowUnexported(gitChange{}, lineRange{})))
+
+	_, err = parseGitDiff(strings.NewReader("diff --git a/x b/x\n+++ b/x\n@@ -1 +x @@\n"))
+	qt.Assert(t, qt.ErrorMatches(err, `invalid git diff hunk: .*`))
+}
@@ -0,0 +1,277 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// lintMode is the value of --lint, which may be given without a value
+// like a boolean flag to use the default text output.
+type lintMode string
+
+func (m *lintMode) String() string { return string(*m) }
+
+func (m *lintMode) Set(s string) error {
+	switch s {
+	case "true", "text":
+		*m = "text"
+	case "false":
+		*m = ""
+	case "json":
+		*m = "json"
+	default:
+		return fmt.Errorf("must be text or json")
+	}
+	return nil
+}
+
+func (m *lintMode) IsBoolFlag() bool { return true }
+
+// The lint rules which --lint reports.
+const (
+	ruleUnquotedExpansion = "unquoted-expansion"
+	ruleUncheckedCd       = "unchecked-cd"
+	ruleUselessCat        = "useless-cat"
+	ruleBackquotes        = "backquotes"
+	ruleTestAndOr         = "test-and-or"
+)
+
+// linter collects the lint findings in all the files visited by shfmt,
+// as requested via --lint. Each finding is a warning whose code is the rule.
+type linter struct {
+	findings []syntax.Diagnostic
+}
+
+// lintFinding is a finding as written by --lint=json.
+type lintFinding struct {
+	Path    string `json:"path"`
+	Line    uint   `json:"line"`
+	Col     uint   `json:"col"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// addFile runs all lint rules on a file which was parsed correctly.
+// If lines is not nil, only the findings within those lines are kept.
+func (l *linter) addFile(path string, f *syntax.File, lines []lineRange) {
+	add := func(node syntax.Node, rule, format string, args ...any) {
+		l.findings = append(l.findings, syntax.Diagnostic{
+			Filename: path,
+			Pos:      node.Pos(),
+			End:      node.End(),
+			Severity: syntax.SeverityWarning,
+			Code:     rule,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+	first := len(l.findings)
+	errexit := usesErrexit(f)
+	checked := make(map[*syntax.Stmt]bool)
+	syntax.Walk(f, func(node syntax.Node) bool {
+		switch node := node.(type) {
+		case *syntax.IfClause:
+			for _, stmt := range node.Cond {
+				checked[stmt] = true
+			}
+		case *syntax.WhileClause:
+			for _, stmt := range node.Cond {
+				checked[stmt] = true
+			}
+		case *syntax.BinaryCmd:
+			switch node.Op {
+			case syntax.AndStmt, syntax.OrStmt:
+				checked[node.X] = true
+			case syntax.Pipe:
+				if file := uselessCat(node.X); file != "" {
+					add(node.X, ruleUselessCat,
+						"useless use of cat; redirect the file instead, like \"cmd < %s\"", file)
+				}
+			}
+		case *syntax.Stmt:
+			if !errexit && !checked[node] && !node.Negated && commandName(node) == "cd" {
+				add(node, ruleUncheckedCd,
+					"cd may fail; handle the error, like \"cd dir || exit\"")
+			}
+		case *syntax.CallExpr:
+			if op := testAndOr(node); op != nil {
+				join := "&&"
+				if op.Lit() == "-o" {
+					join = "||"
+				}
+				add(op, ruleTestAndOr,
+					"%s in test commands is ambiguous; use multiple tests joined with %s instead",
+					op.Lit(), join)
+			}
+			for _, arg := range node.Args[min(1, len(node.Args)):] {
+				for _, part := range arg.Parts {
+					if pe, ok := part.(*syntax.ParamExp); ok && splitsFields(pe) {
+						add(pe, ruleUnquotedExpansion,
+							"unquoted expansion undergoes field splitting and globbing; quote it like \"%s\"",
+							printNode(pe))
+					}
+				}
+			}
+		case *syntax.CmdSubst:
+			if node.Backquotes {
+				add(node, ruleBackquotes,
+					"backquotes are deprecated and hard to nest; use $(...) instead")
+			}
+		}
+		return true
+	})
+	if lines != nil {
+		kept := slices.DeleteFunc(l.findings[first:], func(d syntax.Diagnostic) bool {
+			line := int(d.Pos.Line())
+			return !slices.ContainsFunc(lines, func(rng lineRange) bool {
+				return line >= rng.first && line <= rng.last
+			})
+		})
+		l.findings = l.findings[:first+len(kept)]
+	}
+	// Walking the syntax tree does not always visit nodes in order.
+	slices.SortStableFunc(l.findings[first:], func(a, b syntax.Diagnostic) int {
+		if a.Pos.Line() != b.Pos.Line() {
+			return cmp.Compare(a.Pos.Line(), b.Pos.Line())
+		}
+		return cmp.Compare(a.Pos.Col(), b.Pos.Col())
+	})
+}
+
+// commandName returns the name of the simple command run by a statement,
+// if it is a literal.
+func commandName(stmt *syntax.Stmt) string {
+	call, ok := stmt.Cmd.(*syntax.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return ""
+	}
+	return call.Args[0].Lit()
+}
+
+// testAndOr returns the first "-a" or "-o" binary operator used by a test
+// command like "[ a -a b ]".
+func testAndOr(call *syntax.CallExpr) *syntax.Word {
+	if len(call.Args) == 0 {
+		return nil
+	}
+	switch call.Args[0].Lit() {
+	case "[", "test":
+	default:
+		return nil
+	}
+	args := call.Args[1:]
+	for i, arg := range args {
+		switch arg.Lit() {
+		case "-a", "-o":
+			// Not an operand, nor the unary operator "-a file".
+			if i > 0 && !testOperator(args[i-1].Lit()) {
+				return arg
+			}
+		}
+	}
+	return nil
+}
+
+// testOperator reports whether an argument to a test command is an operator,
+// such that the argument following it must be an operand.
+func testOperator(s string) bool {
+	switch s {
+	case "=", "==", "!=", "<", ">", "!", "(":
+		return true
+	}
+	return len(s) > 1 && s[0] == '-'
+}
+
+// usesErrexit reports whether a file runs "set -e" or "set -o errexit",
+// in which case a failing cd already stops the script.
+func usesErrexit(f *syntax.File) bool {
+	found := false
+	syntax.Walk(f, func(node syntax.Node) bool {
+		call, ok := node.(*syntax.CallExpr)
+		if !ok || found || len(call.Args) == 0 || call.Args[0].Lit() != "set" {
+			return !found
+		}
+		for i, arg := range call.Args[1:] {
+			switch s := arg.Lit(); {
+			case s == "-o" && i+2 < len(call.Args) && call.Args[i+2].Lit() == "errexit":
+				found = true
+			case strings.HasPrefix(s, "-") && !strings.HasPrefix(s, "--") && strings.Contains(s, "e"):
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// uselessCat returns the file read by a statement like "cat file",
+// if it can be replaced by an input redirection to the next command.
+func uselessCat(stmt *syntax.Stmt) string {
+	call, ok := stmt.Cmd.(*syntax.CallExpr)
+	if !ok || stmt.Negated || stmt.Background || len(stmt.Redirs) > 0 ||
+		len(call.Assigns) > 0 || len(call.Args) != 2 || call.Args[0].Lit() != "cat" {
+		return ""
+	}
+	file := call.Args[1]
+	if lit := file.Lit(); lit == "" || strings.HasPrefix(lit, "-") {
+		// Only plain file names; "cat -" and options are not useless.
+		return ""
+	}
+	return file.Lit()
+}
+
+// splitsFields reports whether an unquoted parameter expansion can undergo
+// field splitting and globbing in a way that is likely a bug. Special
+// parameters which expand to a number, like "$#", are not reported.
+func splitsFields(pe *syntax.ParamExp) bool {
+	if pe.Length || pe.Width {
+		return false
+	}
+	switch pe.Param.Value {
+	case "#", "?", "$", "!", "-":
+		return pe.Exp != nil || pe.Repl != nil || pe.Slice != nil
+	}
+	return true
+}
+
+// printNode prints a node with the default printer, such as to quote
+// part of the source in a message.
+func printNode(node syntax.Node) string {
+	var sb strings.Builder
+	syntax.NewPrinter().Print(&sb, node)
+	return sb.String()
+}
+
+func (l *linter) writeText(w io.Writer) error {
+	var b strings.Builder
+	for _, d := range l.findings {
+		fmt.Fprintf(&b, "%s (%s)\n", d.Error(), d.Code)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (l *linter) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.SetEscapeHTML(false)
+	findings := []lintFinding{}
+	for _, d := range l.findings {
+		findings = append(findings, lintFinding{
+			Path:    d.Filename,
+			Line:    d.Pos.Line(),
+			Col:     d.Pos.Col(),
+			Rule:    d.Code,
+			Message: d.Message,
+		})
+	}
+	return enc.Encode(findings)
+}
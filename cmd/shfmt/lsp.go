@@ -0,0 +1,493 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// lspServer is a minimal language server as requested via --lsp,
+// speaking JSON-RPC over stdio as described by the Language Server Protocol.
+//
+// It supports formatting whole documents or ranges of lines, publishing parse
+// errors as diagnostics, and listing functions as document symbols.
+// Documents are synchronized in full on every change.
+type lspServer struct {
+	r *bufio.Reader
+	w io.Writer
+
+	f        *formatter
+	docs     map[string]string // by URI
+	shutdown bool
+}
+
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  any              `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error codes defined by JSON-RPC and the Language Server Protocol.
+const (
+	lspParseError     = -32700
+	lspMethodNotFound = -32601
+	lspInvalidParams  = -32602
+	lspRequestFailed  = -32803
+)
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"` // in UTF-16 code units
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspDocumentSymbol struct {
+	Name           string              `json:"name"`
+	Kind           int                 `json:"kind"`
+	Range          lspRange            `json:"range"`
+	SelectionRange lspRange            `json:"selectionRange"`
+	Children       []lspDocumentSymbol `json:"children,omitempty"`
+}
+
+const (
+	lspSeverityError       = 1
+	lspSeverityWarning     = 2
+	lspSeverityInformation = 3
+	lspSymbolFunction      = 12
+)
+
+// lspSeverity returns the LSP severity for a diagnostic's severity.
+func lspSeverity(s syntax.Severity) int {
+	switch s {
+	case syntax.SeverityWarning:
+		return lspSeverityWarning
+	case syntax.SeverityInfo:
+		return lspSeverityInformation
+	}
+	return lspSeverityError
+}
+
+type lspTextDocument struct {
+	URI  string `json:"uri"`
+	Text string `json:"text"`
+}
+
+type lspDocumentParams struct {
+	TextDocument   lspTextDocument `json:"textDocument"`
+	ContentChanges []struct {
+		Range *lspRange `json:"range"`
+		Text  string    `json:"text"`
+	} `json:"contentChanges"`
+	Range lspRange `json:"range"`
+}
+
+// runLSP runs a language server until the client asks it to exit,
+// returning the exit status.
+func runLSP(r io.Reader, w io.Writer) int {
+	s := &lspServer{
+		r:    bufio.NewReader(r),
+		w:    w,
+		f:    newFormatter(),
+		docs: make(map[string]string),
+	}
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return 1 // the client went away without asking us to exit
+		}
+		if err != nil {
+			s.write(&lspMessage{Error: &lspError{lspParseError, err.Error()}})
+			continue
+		}
+		if msg.Method == "exit" {
+			if s.shutdown {
+				return 0
+			}
+			return 1
+		}
+		result, rerr := s.handle(msg)
+		if msg.ID == nil {
+			continue // a notification; no response
+		}
+		resp := &lspMessage{ID: msg.ID, Result: result, Error: rerr}
+		if rerr == nil && result == nil {
+			resp.Result = json.RawMessage("null")
+		}
+		if err := s.write(resp); err != nil {
+			return 1
+		}
+	}
+}
+
+func (s *lspServer) read() (*lspMessage, error) {
+	header, err := textproto.NewReader(s.r).ReadMIMEHeader()
+	if err != nil {
+		if len(header) == 0 && errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Content-Length: %v", err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.r, body); err != nil {
+		return nil, err
+	}
+	msg := &lspMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *lspServer) write(msg *lspMessage) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+func (s *lspServer) notify(method string, params any) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		panic(err) // our own types always marshal
+	}
+	s.write(&lspMessage{Method: method, Params: raw})
+}
+
+func (s *lspServer) handle(msg *lspMessage) (any, *lspError) {
+	var params lspDocumentParams
+	if len(msg.Params) > 0 && msg.Method != "initialize" {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &lspError{lspInvalidParams, err.Error()}
+		}
+	}
+	uri := params.TextDocument.URI
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":                1, // full
+				"documentFormattingProvider":      true,
+				"documentRangeFormattingProvider": true,
+				"documentSymbolProvider":          true,
+			},
+			"serverInfo": map[string]any{"name": "shfmt", "version": version},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		s.docs[uri] = params.TextDocument.Text
+		s.publishDiagnostics(uri)
+	case "textDocument/didChange":
+		for _, change := range params.ContentChanges {
+			if change.Range == nil {
+				s.docs[uri] = change.Text
+			}
+		}
+		s.publishDiagnostics(uri)
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		s.notify("textDocument/publishDiagnostics", map[string]any{
+			"uri": uri, "diagnostics": []lspDiagnostic{},
+		})
+	case "textDocument/formatting":
+		return s.format(uri, nil)
+	case "textDocument/rangeFormatting":
+		return s.format(uri, &params.Range)
+	case "textDocument/documentSymbol":
+		src, ok := s.docs[uri]
+		if !ok {
+			return nil, &lspError{lspInvalidParams, "unknown document: " + uri}
+		}
+		f, _, err := s.parse(uri, src)
+		if err != nil {
+			return []lspDocumentSymbol{}, nil
+		}
+		return functionSymbols(strings.SplitAfter(src, "\n"), f.Stmts), nil
+	default:
+		if msg.ID != nil && !strings.HasPrefix(msg.Method, "$/") {
+			return nil, &lspError{lspMethodNotFound, "method not supported: " + msg.Method}
+		}
+	}
+	return nil, nil
+}
+
+// parse parses a document, configuring the parser and printer
+// for it just like when formatting a file on disk.
+func (s *lspServer) parse(uri, src string) (*syntax.File, bool, error) {
+	path := uriPath(uri)
+	conf, err := s.f.applyConfig(path, detectLang(path, []byte(src)))
+	if err != nil {
+		return nil, false, err
+	}
+	f, err := s.f.parser.Parse(strings.NewReader(src), path)
+	return f, conf.simplify, err
+}
+
+func (s *lspServer) publishDiagnostics(uri string) {
+	src := s.docs[uri]
+	diags := []lspDiagnostic{}
+	if _, _, err := s.parse(uri, src); err != nil {
+		d, ok := syntax.AsDiagnostic(err)
+		if !ok {
+			d = syntax.Diagnostic{Severity: syntax.SeverityError, Message: err.Error()}
+		}
+		lines := strings.SplitAfter(src, "\n")
+		start := lspPos(lines, d.Pos)
+		end := start
+		if d.End.IsValid() {
+			end = lspPos(lines, d.End)
+		}
+		diags = append(diags, lspDiagnostic{
+			Range:    lspRange{start, end},
+			Severity: lspSeverity(d.Severity),
+			Source:   "shfmt",
+			Message:  d.Message,
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", map[string]any{
+		"uri": uri, "diagnostics": diags,
+	})
+}
+
+// format formats a document, returning the edits to apply to it. If rng is
+// not nil, only the edits touching the lines within the range are returned.
+func (s *lspServer) format(uri string, rng *lspRange) (any, *lspError) {
+	src, ok := s.docs[uri]
+	if !ok {
+		return nil, &lspError{lspInvalidParams, "unknown document: " + uri}
+	}
+	f, doSimplify, err := s.parse(uri, src)
+	if err != nil {
+		return nil, &lspError{lspRequestFailed, err.Error()}
+	}
+	if doSimplify {
+		syntax.Simplify(f)
+	}
+	var buf bytes.Buffer
+	if err := s.f.printer.Print(&buf, f); err != nil {
+		return nil, &lspError{lspRequestFailed, err.Error()}
+	}
+	edits := []lspTextEdit{}
+	oldLines := strings.SplitAfter(src, "\n")
+	newLines := strings.SplitAfter(buf.String(), "\n")
+	for _, h := range lineHunks(oldLines, newLines) {
+		if rng != nil && !h.overlaps(rng.Start.Line, rng.End.Line) {
+			continue
+		}
+		edits = append(edits, lspTextEdit{
+			Range: lspRange{
+				Start: lspPosition{Line: h.oldStart},
+				End:   lineStart(oldLines, h.oldEnd),
+			},
+			NewText: strings.Join(newLines[h.newStart:h.newEnd], ""),
+		})
+	}
+	return edits, nil
+}
+
+// lineHunk replaces the lines oldStart to oldEnd with newStart to newEnd.
+type lineHunk struct {
+	oldStart, oldEnd int
+	newStart, newEnd int
+}
+
+// overlaps reports whether the hunk replaces or inserts lines
+// within the lines first to last, inclusive.
+func (h lineHunk) overlaps(first, last int) bool {
+	if h.oldStart == h.oldEnd { // only inserting lines
+		return first <= h.oldStart && h.oldStart <= last
+	}
+	return h.oldStart <= last && h.oldEnd > first
+}
+
+// maxDiffCells limits the size of the table used by lineHunks,
+// beyond which all differing lines are replaced as a single hunk.
+const maxDiffCells = 4 << 20
+
+// lineHunks returns the hunks which turn the old lines into the new lines,
+// via the longest common subsequence of lines.
+func lineHunks(old, new []string) []lineHunk {
+	// Trim the common prefix and suffix, which is usually most of the lines.
+	pre := 0
+	for pre < len(old) && pre < len(new) && old[pre] == new[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(old)-pre && suf < len(new)-pre && old[len(old)-1-suf] == new[len(new)-1-suf] {
+		suf++
+	}
+	a, b := old[pre:len(old)-suf], new[pre:len(new)-suf]
+	if len(a) == 0 && len(b) == 0 {
+		return nil
+	}
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return []lineHunk{{pre, pre + len(a), pre, pre + len(b)}}
+	}
+	// lcs[i][j] is the length of the longest common subsequence
+	// of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var hunks []lineHunk
+	var cur *lineHunk
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		if i < len(a) && j < len(b) && a[i] == b[j] {
+			cur = nil
+			i++
+			j++
+			continue
+		}
+		if cur == nil {
+			hunks = append(hunks, lineHunk{pre + i, pre + i, pre + j, pre + j})
+			cur = &hunks[len(hunks)-1]
+		}
+		if j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]) {
+			i++
+			cur.oldEnd = pre + i
+		} else {
+			j++
+			cur.newEnd = pre + j
+		}
+	}
+	return hunks
+}
+
+// lineStart returns the position at the start of a line, or at the end of the
+// document if the line does not exist.
+func lineStart(lines []string, line int) lspPosition {
+	if line < len(lines) {
+		return lspPosition{Line: line}
+	}
+	last := lines[len(lines)-1]
+	return lspPosition{Line: len(lines) - 1, Character: utf16Len(last)}
+}
+
+// functionSymbols returns the functions declared in a list of statements,
+// along with the functions nested inside them.
+func functionSymbols(lines []string, stmts []*syntax.Stmt) []lspDocumentSymbol {
+	symbols := []lspDocumentSymbol{}
+	for _, stmt := range stmts {
+		syntax.Walk(stmt, func(node syntax.Node) bool {
+			fn, ok := node.(*syntax.FuncDecl)
+			if !ok {
+				return true
+			}
+			symbols = append(symbols, lspDocumentSymbol{
+				Name: fn.Name.Value,
+				Kind: lspSymbolFunction,
+				Range: lspRange{
+					Start: lspPos(lines, fn.Pos()),
+					End:   lspPos(lines, fn.End()),
+				},
+				SelectionRange: lspRange{
+					Start: lspPos(lines, fn.Name.Pos()),
+					End:   lspPos(lines, fn.Name.End()),
+				},
+				Children: functionSymbols(lines, []*syntax.Stmt{fn.Body}),
+			})
+			return false
+		})
+	}
+	return symbols
+}
+
+// lspPos converts a position from the syntax package, which counts columns
+// in bytes starting at 1, to one which counts characters in UTF-16 code units
+// starting at 0.
+func lspPos(lines []string, pos syntax.Pos) lspPosition {
+	if !pos.IsValid() {
+		return lspPosition{}
+	}
+	line := int(pos.Line()) - 1
+	col := int(pos.Col()) - 1
+	if line >= len(lines) {
+		return lspPosition{Line: line}
+	}
+	text := lines[line]
+	if col > len(text) {
+		col = len(text)
+	}
+	return lspPosition{Line: line, Character: utf16Len(text[:col])}
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n++
+		if r >= 0x10000 {
+			n++ // a surrogate pair
+		}
+	}
+	return n
+}
+
+// uriPath returns the file path for a "file" URI, which is used to find
+// configuration files and detect the shell language.
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	path := u.Path
+	// Windows paths look like "/C:/foo".
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path)
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestLSP(t *testing.T) {
+	t.Parallel()
+
+	uri := "file://" + filepath.ToSlash(t.TempDir()) + "/foo.sh"
+	src := "#!/bin/bash\nfoo()  {\n\techo  bar\n}\nok\nbaz()  { inner() { :; }; }\n"
+
+	var in bytes.Buffer
+	id := 0
+	send := func(method string, params any) {
+		msg := map[string]any{"jsonrpc": "2.0", "method": method, "params": params}
+		if !strings.HasPrefix(method, "textDocument/did") && method != "initialized" && method != "exit" {
+			id++
+			msg["id"] = id
+		}
+		body, err := json.Marshal(msg)
+		qt.Assert(t, qt.IsNil(err))
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	doc := map[string]any{"uri": uri}
+	send("initialize", map[string]any{})
+	send("initialized", map[string]any{})
+	send("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "shellscript", "text": src},
+	})
+	send("textDocument/formatting", map[string]any{"textDocument": doc})
+	send("textDocument/rangeFormatting", map[string]any{
+		"textDocument": doc,
+		"range":        lspRange{lspPosition{4, 0}, lspPosition{5, 0}},
+	})
+	send("textDocument/documentSymbol", map[string]any{"textDocument": doc})
+	send("textDocument/didChange", map[string]any{
+		"textDocument":   doc,
+		"contentChanges": []any{map[string]any{"text": "echo 'ñ' (\n"}},
+	})
+	send("textDocument/formatting", map[string]any{"textDocument": doc})
+	send("textDocument/hover", map[string]any{"textDocument": doc})
+	send("shutdown", nil)
+	send("exit", nil)
+
+	var out bytes.Buffer
+	qt.Assert(t, qt.Equals(runLSP(&in, &out), 0))
+
+	type message struct {
+		ID     int             `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+		Result json.RawMessage `json:"result"`
+		Error  *lspError       `json:"error"`
+	}
+	var msgs []message
+	s := &lspServer{r: bufio.NewReader(&out)}
+	for {
+		raw, err := s.read()
+		if err != nil {
+			break
+		}
+		body, _ := json.Marshal(raw)
+		var msg message
+		qt.Assert(t, qt.IsNil(json.Unmarshal(body, &msg)))
+		msgs = append(msgs, msg)
+	}
+	qt.Assert(t, qt.HasLen(msgs, 9))
+
+	qt.Check(t, qt.StringContains(string(msgs[0].Result), `"documentFormattingProvider":true`))
+
+	// Opening a valid document publishes no diagnostics.
+	qt.Check(t, qt.Equals(msgs[1].Method, "textDocument/publishDiagnostics"))
+	qt.Check(t, qt.StringContains(string(msgs[1].Params), `"diagnostics":[]`))
+
+	var edits []lspTextEdit
+	qt.Assert(t, qt.IsNil(json.Unmarshal(msgs[2].Result, &edits)))
+	qt.Check(t, qt.DeepEquals(edits, []lspTextEdit{
+		{lspRange{lspPosition{1, 0}, lspPosition{3, 0}}, "foo() {\n\techo bar\n"},
+		{lspRange{lspPosition{5, 0}, lspPosition{6, 0}}, "baz() { inner() { :; }; }\n"},
+	}))
+
+	// Only the edit within the range.
+	qt.Assert(t, qt.IsNil(json.Unmarshal(msgs[3].Result, &edits)))
+	qt.Check(t, qt.DeepEquals(edits, []lspTextEdit{
+		{lspRange{lspPosition{5, 0}, lspPosition{6, 0}}, "baz() { inner() { :; }; }\n"},
+	}))
+
+	var symbols []lspDocumentSymbol
+	qt.Assert(t, qt.IsNil(json.Unmarshal(msgs[4].Result, &symbols)))
+	qt.Assert(t, qt.HasLen(symbols, 2))
+	qt.Check(t, qt.Equals(symbols[0].Name, "foo"))
+	qt.Check(t, qt.Equals(symbols[0].Range, lspRange{lspPosition{1, 0}, lspPosition{3, 1}}))
+	qt.Check(t, qt.Equals(symbols[0].SelectionRange, lspRange{lspPosition{1, 0}, lspPosition{1, 3}}))
+	qt.Check(t, qt.Equals(symbols[1].Name, "baz"))
+	qt.Assert(t, qt.HasLen(symbols[1].Children, 1))
+	qt.Check(t, qt.Equals(symbols[1].Children[0].Name, "inner"))
+
+	// The parse error is published as a diagnostic, with its column
+	// counting UTF-16 code units rather than bytes.
+	qt.Check(t, qt.Equals(msgs[5].Method, "textDocument/publishDiagnostics"))
+	var diags struct {
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	qt.Assert(t, qt.IsNil(json.Unmarshal(msgs[5].Params, &diags)))
+	qt.Assert(t, qt.HasLen(diags.Diagnostics, 1))
+	qt.Check(t, qt.Equals(diags.Diagnostics[0].Range.Start, lspPosition{0, 9}))
+	qt.Check(t, qt.StringContains(diags.Diagnostics[0].Message, "encountered ("))
+
+	// Formatting fails on invalid syntax, and unknown methods are errors.
+	qt.Check(t, qt.IsNotNil(msgs[6].Error))
+	qt.Check(t, qt.Equals(msgs[7].Error.Code, lspMethodNotFound))
+	qt.Check(t, qt.Equals(msgs[8].ID, 7))
+	qt.Check(t, qt.IsNil(msgs[8].Error))
+}
+
+func TestLineHunks(t *testing.T) {
+	t.Parallel()
+	lines := func(s string) []string { return strings.SplitAfter(s, "\n") }
+	tests := []struct {
+		old, new string
+		want     []lineHunk
+	}{
+		{"a\nb\n", "a\nb\n", nil},
+		{"a\nb\nc\n", "a\nB\nc\n", []lineHunk{{1, 2, 1, 2}}},
+		{"a\nb\nc\n", "a\nc\n", []lineHunk{{1, 2, 1, 1}}},
+		{"a\nc\n", "a\nb\nc\n", []lineHunk{{1, 1, 1, 2}}},
+		{"a\nb\nc\nd\ne\n", "A\nb\nc\nD\ne\n", []lineHunk{{0, 1, 0, 1}, {3, 4, 3, 4}}},
+		{"a", "a\n", []lineHunk{{0, 1, 0, 2}}},
+	}
+	for _, test := range tests {
+		got := lineHunks(lines(test.old), lines(test.new))
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%q to %q: got hunks %v, want %v", test.old, test.new, got, test.want)
+		}
+	}
+}
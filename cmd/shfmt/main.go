@@ -15,15 +15,20 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 
 	maybeio "github.com/google/renameio/v2/maybe"
 	diffpkg "github.com/rogpeppe/go-internal/diff"
 	"golang.org/x/term"
 	"mvdan.cc/editorconfig"
 
+	"mvdan.cc/sh/v3/embedded"
 	"mvdan.cc/sh/v3/fileutil"
 	"mvdan.cc/sh/v3/syntax"
+	"mvdan.cc/sh/v3/syntax/fmtconfig"
 	"mvdan.cc/sh/v3/syntax/typedjson"
 )
 
@@ -32,6 +37,75 @@ type multiFlag[T any] struct {
 	val         T
 }
 
+// excludeList is the value of --exclude, which may be given multiple times.
+type excludeList struct {
+	patterns []string
+	list     fileutil.IgnoreList
+}
+
+func (l *excludeList) String() string { return strings.Join(l.patterns, ",") }
+
+func (l *excludeList) Set(s string) error {
+	if err := l.list.Add(s); err != nil {
+		return err
+	}
+	l.patterns = append(l.patterns, s)
+	return nil
+}
+
+// formatSet is the value of --embedded, a comma-separated list of the formats
+// of the host files whose embedded shell scripts should be formatted.
+type formatSet map[string]bool
+
+func (s *formatSet) String() string {
+	var formats []string
+	for format := range *s {
+		formats = append(formats, format)
+	}
+	slices.Sort(formats)
+	return strings.Join(formats, ",")
+}
+
+func (s *formatSet) Set(v string) error {
+	*s = make(formatSet)
+	for _, format := range strings.Split(v, ",") {
+		switch format {
+		case embedded.FormatDockerfile, embedded.FormatYAML, embedded.FormatMarkdown:
+			(*s)[format] = true
+		default:
+			return fmt.Errorf("must be a list of dockerfile, yaml, or markdown")
+		}
+	}
+	return nil
+}
+
+// lineRange is the value of --line-range, the lines from first to last
+// counting from one, or the zero value to format all lines.
+type lineRange struct {
+	first, last int
+}
+
+func (r *lineRange) String() string {
+	if *r == (lineRange{}) {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", r.first, r.last)
+}
+
+func (r *lineRange) Set(v string) error {
+	first, last, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("must be of the form start:end")
+	}
+	var err1, err2 error
+	r.first, err1 = strconv.Atoi(first)
+	r.last, err2 = strconv.Atoi(last)
+	if err1 != nil || err2 != nil || r.first < 1 || r.last < r.first {
+		return fmt.Errorf("must be of the form start:end, with 1 <= start <= end")
+	}
+	return nil
+}
+
 var (
 	versionFlag = &multiFlag[bool]{"", "version", false}
 	list        = &multiFlag[bool]{"l", "list", false}
@@ -39,13 +113,22 @@ var (
 	write       = &multiFlag[bool]{"w", "write", false}
 	simplify    = &multiFlag[bool]{"s", "simplify", false}
 	minify      = &multiFlag[bool]{"mn", "minify", false}
+	fix         = &multiFlag[bool]{"", "fix", false}
 	find        = &multiFlag[bool]{"f", "find", false}
 	diff        = &multiFlag[bool]{"d", "diff", false}
 	applyIgnore = &multiFlag[bool]{"", "apply-ignore", false}
+	exclude     = &multiFlag[excludeList]{"", "exclude", excludeList{}}
+	embed       = &multiFlag[formatSet]{"", "embedded", nil}
+	jobs        = &multiFlag[uint]{"", "jobs", 0}
+	cacheFlag   = &multiFlag[bool]{"", "cache", false}
+	lines       = &multiFlag[lineRange]{"", "line-range", lineRange{}}
+	staged      = &multiFlag[bool]{"", "staged", false}
+	since       = &multiFlag[string]{"", "since", ""}
 
 	lang     = &multiFlag[syntax.LangVariant]{"ln", "language-dialect", syntax.LangAuto}
 	posix    = &multiFlag[bool]{"p", "posix", false}
-	filename = &multiFlag[string]{"", "filename", ""}
+	filename = &multiFlag[string]{"stdin-name", "filename", ""}
+	to       = &multiFlag[syntax.LangVariant]{"", "to", syntax.LangAuto}
 
 	indent      = &multiFlag[uint]{"i", "indent", 0}
 	binNext     = &multiFlag[bool]{"bn", "binary-next-line", false}
@@ -54,25 +137,38 @@ var (
 	keepPadding = &multiFlag[bool]{"kp", "keep-padding", false}
 	funcNext    = &multiFlag[bool]{"fn", "func-next-line", false}
 
-	toJSON   = &multiFlag[bool]{"tojson", "to-json", false} // TODO(v4): remove "tojson" for consistency
-	fromJSON = &multiFlag[bool]{"", "from-json", false}
+	toJSON     = &multiFlag[bool]{"tojson", "to-json", false} // TODO(v4): remove "tojson" for consistency
+	fromJSON   = &multiFlag[bool]{"", "from-json", false}
+	reportFmt  = &multiFlag[string]{"", "report", ""}
+	lint       = &multiFlag[lintMode]{"", "lint", ""}
+	summaryFmt = &multiFlag[string]{"", "summary", ""}
+	lsp        = &multiFlag[bool]{"", "lsp", false}
 
-	// useEditorConfig will be false if any parser or printer flags were used.
-	useEditorConfig = true
+	// useConfigFiles will be false if any parser or printer flags were used.
+	useConfigFiles = true
 
-	parser            *syntax.Parser
-	printer           *syntax.Printer
-	readBuf, writeBuf bytes.Buffer
-	color             bool
+	color bool
+
+	// rep is non-nil when --report is used.
+	rep *report
 
-	copyBuf = make([]byte, 32*1024)
+	// lnt is non-nil when --lint is used.
+	lnt *linter
+
+	// sum is non-nil when --summary is used.
+	sum *summary
+
+	// cache is non-nil when --cache is used.
+	cache *formatCache
 
 	version = "(devel)" // to match the default from runtime/debug
 
 	allFlags = []any{
-		versionFlag, list, write, simplify, minify, find, diff, applyIgnore,
-		lang, posix, filename,
+		versionFlag, list, write, simplify, minify, fix, find, diff, applyIgnore, exclude,
+		embed, jobs, cacheFlag, lines, staged, since,
+		lang, posix, filename, to,
 		indent, binNext, caseIndent, spaceRedirs, keepPadding, funcNext, toJSON, fromJSON,
+		reportFmt, lint, summaryFmt, lsp,
 	}
 )
 
@@ -110,6 +206,34 @@ func init() {
 			if name := f.long; name != "" {
 				flag.Var(&f.val, name, "")
 			}
+		case *multiFlag[lintMode]:
+			if name := f.short; name != "" {
+				flag.Var(&f.val, name, "")
+			}
+			if name := f.long; name != "" {
+				flag.Var(&f.val, name, "")
+			}
+		case *multiFlag[excludeList]:
+			if name := f.short; name != "" {
+				flag.Var(&f.val, name, "")
+			}
+			if name := f.long; name != "" {
+				flag.Var(&f.val, name, "")
+			}
+		case *multiFlag[formatSet]:
+			if name := f.short; name != "" {
+				flag.Var(&f.val, name, "")
+			}
+			if name := f.long; name != "" {
+				flag.Var(&f.val, name, "")
+			}
+		case *multiFlag[lineRange]:
+			if name := f.short; name != "" {
+				flag.Var(&f.val, name, "")
+			}
+			if name := f.long; name != "" {
+				flag.Var(&f.val, name, "")
+			}
 		default:
 			panic(fmt.Sprintf("%T", f))
 		}
@@ -135,13 +259,23 @@ directory, all shell scripts found under that directory will be used.
   -d,  --diff      error with a diff when the formatting differs
   -s,  --simplify  simplify the code
   -mn, --minify    minify the code to reduce its size (implies -s)
-  --apply-ignore   always apply EditorConfig ignore rules
+  --fix            fix the --lint findings which have mechanical fixes
+  --apply-ignore   always apply ignore rules from configuration files
+  --exclude glob   skip matching paths when walking directories
+  --embedded list  format scripts in dockerfile, yaml, and markdown files
+  --jobs uint      how many files to format concurrently, default GOMAXPROCS
+  --cache          skip files which were formatted in previous runs
+  --line-range s:e only format the lines from s to e, counting from 1
+  --staged         only format the lines changed in the git index
+  --since rev      only format the lines changed in git since a revision
 
 Parser options:
 
   -ln, --language-dialect str  bash/posix/mksh/bats, default "auto"
   -p,  --posix                 shorthand for -ln=posix
   --filename str               provide a name for the standard input file
+                               (alias: --stdin-name)
+  --to str                     convert to another dialect: bash/posix/mksh
 
 Printer options:
 
@@ -154,9 +288,13 @@ Printer options:
 
 Utilities:
 
-  -f, --find   recursively find all shell files and print the paths
-  --to-json    print syntax tree to stdout as a typed JSON
-  --from-json  read syntax tree from stdin as a typed JSON
+  -f, --find    recursively find all shell files and print the paths
+  --to-json     print syntax tree to stdout as a typed JSON
+  --from-json   read syntax tree from stdin as a typed JSON and print it
+  --report=fmt  print statistics about all shell files as json or markdown
+  --lint[=fmt]  report common mistakes in all shell files as text or json
+  --summary=fmt print the status of each file as json instead of the output
+  --lsp         run a language server over stdin and stdout
 
 For more information, see 'man shfmt' and https://github.com/mvdan/sh.
 `)
@@ -177,11 +315,77 @@ For more information, see 'man shfmt' and https://github.com/mvdan/sh.
 	}
 	if posix.val && lang.val != syntax.LangAuto {
 		fmt.Fprintf(os.Stderr, "-p and -ln=lang cannot coexist\n")
-		return 1
+		return exitUsage
 	}
 	if minify.val {
 		simplify.val = true
 	}
+	if reportFmt.val != "" {
+		switch reportFmt.val {
+		case "json", "markdown":
+		default:
+			fmt.Fprintf(os.Stderr, "--report must be json or markdown, got %q\n", reportFmt.val)
+			return exitUsage
+		}
+		if list.val || write.val || diff.val || find.val || toJSON.val || fromJSON.val {
+			fmt.Fprintln(os.Stderr, "--report cannot be used with -l, -w, -d, -f, --to-json, or --from-json")
+			return exitUsage
+		}
+		rep = newReport()
+	}
+	if lint.val != "" {
+		if list.val || write.val || diff.val || find.val || toJSON.val || fromJSON.val || rep != nil || fix.val {
+			fmt.Fprintln(os.Stderr, "--lint cannot be used with -l, -w, -d, -f, --to-json, --from-json, --report, or --fix")
+			return exitUsage
+		}
+		lnt = &linter{}
+	}
+	if summaryFmt.val != "" {
+		if summaryFmt.val != "json" {
+			fmt.Fprintf(os.Stderr, "--summary must be json, got %q\n", summaryFmt.val)
+			return exitUsage
+		}
+		if find.val || toJSON.val || rep != nil || lnt != nil {
+			fmt.Fprintln(os.Stderr, "--summary cannot be used with -f, --to-json, --report, or --lint")
+			return exitUsage
+		}
+		sum = &summary{}
+	}
+	if fromJSON.val && (list.val || write.val || diff.val || find.val) {
+		// The input is JSON, so there is no shell to compare or write to.
+		fmt.Fprintln(os.Stderr, "--from-json cannot be used with -l, -w, -d, or -f")
+		return exitUsage
+	}
+	if lines.val != (lineRange{}) && (toJSON.val || rep != nil || lnt != nil || len(embed.val) > 0) {
+		fmt.Fprintln(os.Stderr, "--line-range cannot be used with --to-json, --report, --lint, or --embedded")
+		return exitUsage
+	}
+	if staged.val || since.val != "" {
+		if staged.val && since.val != "" {
+			fmt.Fprintln(os.Stderr, "--staged and --since cannot coexist")
+			return exitUsage
+		}
+		if find.val || toJSON.val || fromJSON.val || rep != nil || lines.val != (lineRange{}) || len(embed.val) > 0 {
+			fmt.Fprintln(os.Stderr, "--staged and --since cannot be used with -f, --to-json, --from-json, --report, --line-range, or --embedded")
+			return exitUsage
+		}
+	}
+	if len(embed.val) > 0 && (toJSON.val || fromJSON.val || rep != nil || lnt != nil) {
+		fmt.Fprintln(os.Stderr, "--embedded cannot be used with --to-json, --from-json, --report, or --lint")
+		return exitUsage
+	}
+	if to.val != syntax.LangAuto {
+		switch to.val {
+		case syntax.LangPOSIX, syntax.LangBash, syntax.LangMirBSDKorn:
+		default:
+			fmt.Fprintf(os.Stderr, "--to must be bash, posix, or mksh, got %q\n", to.val)
+			return exitUsage
+		}
+		if fromJSON.val || rep != nil || lnt != nil || len(embed.val) > 0 {
+			fmt.Fprintln(os.Stderr, "--to cannot be used with --from-json, --report, --lint, or --embedded")
+			return exitUsage
+		}
+	}
 	flag.Visit(func(f *flag.Flag) {
 		switch f.Name {
 		case lang.short, lang.long,
@@ -192,24 +396,22 @@ For more information, see 'man shfmt' and https://github.com/mvdan/sh.
 			spaceRedirs.short, spaceRedirs.long,
 			keepPadding.short, keepPadding.long,
 			funcNext.short, funcNext.long:
-			useEditorConfig = false
+			useConfigFiles = false
 		}
 	})
-	parser = syntax.NewParser(syntax.KeepComments(true))
-	printer = syntax.NewPrinter(syntax.Minify(minify.val))
+	if !useConfigFiles && posix.val {
+		// -p equals -ln=posix
+		lang.val = syntax.LangPOSIX
+	}
 
-	if !useEditorConfig {
-		if posix.val {
-			// -p equals -ln=posix
-			lang.val = syntax.LangPOSIX
+	if lsp.val {
+		if flag.NArg() > 0 || list.val || write.val || diff.val || find.val ||
+			toJSON.val || fromJSON.val || rep != nil || lnt != nil || len(embed.val) > 0 ||
+			to.val != syntax.LangAuto || lines.val != (lineRange{}) || staged.val || since.val != "" || sum != nil {
+			fmt.Fprintln(os.Stderr, "--lsp cannot be used with paths or with -l, -w, -d, -f, --to-json, --from-json, --report, --lint, --embedded, --to, --line-range, --staged, --since, or --summary")
+			return exitUsage
 		}
-
-		syntax.Indent(indent.val)(printer)
-		syntax.BinaryNextLine(binNext.val)(printer)
-		syntax.SwitchCaseIndent(caseIndent.val)(printer)
-		syntax.SpaceRedirects(spaceRedirs.val)(printer)
-		syntax.KeepPadding(keepPadding.val)(printer)
-		syntax.FunctionNextLine(funcNext.val)(printer)
+		return runLSP(os.Stdin, os.Stdout)
 	}
 
 	// Decide whether or not to use color for the diff output,
@@ -220,7 +422,7 @@ For more information, see 'man shfmt' and https://github.com/mvdan/sh.
 	} else if term.IsTerminal(int(os.Stdout.Fd())) {
 		color = true
 	}
-	if flag.NArg() == 0 || (flag.NArg() == 1 && flag.Arg(0) == "-") {
+	if (flag.NArg() == 0 || (flag.NArg() == 1 && flag.Arg(0) == "-")) && !staged.val && since.val == "" {
 		name := "<standard input>"
 		if toJSON.val {
 			name = "" // the default is not useful there
@@ -228,23 +430,56 @@ For more information, see 'man shfmt' and https://github.com/mvdan/sh.
 		if filename.val != "" {
 			name = filename.val
 		}
-		if err := formatStdin(name); err != nil {
-			if err != errChangedWithDiff {
-				fmt.Fprintln(os.Stderr, err)
-			}
-			return 1
+		if write.val {
+			fmt.Fprintln(os.Stderr, "-w cannot be used on standard input")
+			return exitUsage
 		}
-		return 0
+		f := newFormatter()
+		if sum != nil {
+			f.stdout = io.Discard
+		}
+		err := f.formatStdin(name)
+		if f.commit != nil {
+			f.commit()
+		}
+		if err != nil && err != errChangedWithDiff {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		if sum != nil {
+			sum.add(name, f.status, err)
+		}
+		status := fileExitStatus(f.status, err)
+		if err != nil {
+			return writeSummary(status)
+		}
+		return finish(status)
 	}
 	if filename.val != "" {
 		fmt.Fprintln(os.Stderr, "-filename can only be used with stdin")
-		return 1
+		return exitUsage
 	}
 	if toJSON.val {
 		fmt.Fprintln(os.Stderr, "--to-json can only be used with stdin")
-		return 1
+		return exitUsage
+	}
+	if fromJSON.val {
+		fmt.Fprintln(os.Stderr, "--from-json can only be used with stdin")
+		return exitUsage
+	}
+	if cacheFlag.val {
+		var err error
+		if cache, err = openCache(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+	}
+	pool := newPool(int(jobs.val))
+	if staged.val || since.val != "" {
+		if err := formatGitChanges(pool); err != nil {
+			pool.fail(err)
+		}
+		return finish(pool.wait())
 	}
-	status := 0
 	for _, path := range flag.Args() {
 		if info, err := os.Stat(path); err == nil && !info.IsDir() && !applyIgnore.val && !find.val {
 			// When given paths to files directly, always format them,
@@ -252,50 +487,105 @@ For more information, see 'man shfmt' and https://github.com/mvdan/sh.
 			//
 			// One exception is --apply-ignore, which explicitly changes this behavior.
 			// Another is --find, whose logic depends on walkPath being called.
-			if err := formatPath(path, false); err != nil {
-				if err != errChangedWithDiff {
-					fmt.Fprintln(os.Stderr, err)
-				}
-				status = 1
-			}
+			pool.formatPath(path, false, false)
 			continue
 		}
 		if err := filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
 			if err != nil {
 				return err
 			}
-			switch err := walkPath(path, entry); err {
+			switch err := walkPath(pool, path, entry); err {
 			case nil:
 			case filepath.SkipDir:
 				return err
-			case errChangedWithDiff:
-				status = 1
 			default:
-				fmt.Fprintln(os.Stderr, err)
-				status = 1
+				pool.fail(err)
 			}
 			return nil
 		}); err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			status = 1
+			pool.fail(err)
 		}
 	}
+	return finish(pool.wait())
+}
+
+// finish writes any report, lint findings, or summary once all the files were
+// formatted with the given exit status, returning the final exit status.
+func finish(status int) int {
+	if err := writeReport(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		status = max(status, exitError)
+	}
+	if err := writeLint(); err == errLintFindings {
+		status = max(status, exitChanged)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		status = max(status, exitError)
+	}
+	return writeSummary(status)
+}
+
+// fileExitStatus returns the exit status for the result of formatting a file.
+// Files which need formatting are only a failure with -d, or with --summary
+// when they are not written to.
+func fileExitStatus(status string, err error) int {
+	if sum != nil && status == fileChanged {
+		return max(exitStatus(err), exitChanged)
+	}
+	return exitStatus(err)
+}
+
+func writeSummary(status int) int {
+	if sum == nil {
+		return status
+	}
+	if err := sum.writeJSON(os.Stdout, status); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return max(status, exitError)
+	}
 	return status
 }
 
-var errChangedWithDiff = fmt.Errorf("")
+func writeReport() error {
+	switch {
+	case rep == nil:
+		return nil
+	case reportFmt.val == "markdown":
+		return rep.writeMarkdown(os.Stdout)
+	default:
+		return rep.writeJSON(os.Stdout)
+	}
+}
 
-func formatStdin(name string) error {
-	if write.val {
-		return fmt.Errorf("-w cannot be used on standard input")
+// errLintFindings is returned by writeLint when any lint issues were found,
+// which only needs to result in a non-zero exit status.
+var errLintFindings = fmt.Errorf("")
+
+func writeLint() error {
+	if lnt == nil {
+		return nil
 	}
+	var err error
+	switch lint.val {
+	case "json":
+		err = lnt.writeJSON(os.Stdout)
+	default:
+		err = lnt.writeText(os.Stdout)
+	}
+	if err == nil && len(lnt.findings) > 0 {
+		err = errLintFindings
+	}
+	return err
+}
+
+var errChangedWithDiff = fmt.Errorf("")
+
+func (f *formatter) formatStdin(name string) error {
 	if applyIgnore.val {
 		// Mimic the logic from walkPath to apply the ignore rules.
-		props, err := ecQuery.Find(name, []string{"shell"})
-		if err != nil {
+		if ignored, err := isIgnored(name, false); err != nil {
 			return err
-		}
-		if props.Get("ignore") == "true" {
+		} else if ignored {
 			return nil
 		}
 	}
@@ -303,6 +593,16 @@ func formatStdin(name string) error {
 	if err != nil {
 		return err
 	}
+	if embed.val[embedded.Format(name)] {
+		return f.formatEmbedded(src, name)
+	}
+	return f.formatBytes(src, name, detectLang(name, src))
+}
+
+// detectLang returns the language variant to parse a script with,
+// which is the one given via flags or otherwise detected from the script's
+// name or shebang, falling back to bash.
+func detectLang(name string, src []byte) syntax.LangVariant {
 	fileLang := lang.val
 	if fileLang == syntax.LangAuto {
 		extensionLang := strings.TrimPrefix(filepath.Ext(name), ".")
@@ -314,13 +614,11 @@ func formatStdin(name string) error {
 			}
 		}
 	}
-	return formatBytes(src, name, fileLang)
+	return fileLang
 }
 
-var vcsDir = regexp.MustCompile(`^\.(git|svn|hg)$`)
-
-func walkPath(path string, entry fs.DirEntry) error {
-	if entry.IsDir() && vcsDir.MatchString(entry.Name()) {
+func walkPath(pool *pool, path string, entry fs.DirEntry) error {
+	if entry.IsDir() && fileutil.IsVCSDir(entry.Name()) {
 		return filepath.SkipDir
 	}
 	// We don't know the language variant at this point yet, as we are walking directories
@@ -332,34 +630,173 @@ func walkPath(path string, entry fs.DirEntry) error {
 	// TODO: Should there be a way to explicitly turn off ignore rules when walking?
 	// Perhaps swapping the default to --apply-ignore=auto and allowing --apply-ignore=false?
 	// I don't imagine it's a particularly uesful scenario for now.
-	props, err := ecQuery.Find(path, []string{"shell"})
+	ignored, err := isIgnored(path, entry.IsDir())
 	if err != nil {
 		return err
 	}
-	if props.Get("ignore") == "true" {
+	if ignored || exclude.val.list.Match(filepath.ToSlash(filepath.Clean(path)), entry.IsDir()) {
 		if entry.IsDir() {
 			return filepath.SkipDir
 		} else {
 			return nil
 		}
 	}
+	if !entry.IsDir() && embed.val[embedded.Format(path)] {
+		pool.formatPath(path, false, true)
+		return nil
+	}
 	conf := fileutil.CouldBeScript2(entry)
 	if conf == fileutil.ConfNotScript {
 		return nil
 	}
-	err = formatPath(path, conf == fileutil.ConfIfShebang)
-	if err != nil && !os.IsNotExist(err) {
+	pool.formatPath(path, conf == fileutil.ConfIfShebang, true)
+	return nil
+}
+
+// gitLines holds the lines changed in each file as found by --staged or
+// --since, which is only written to before any files are formatted.
+var gitLines map[string][]lineRange
+
+// formatGitChanges formats the shell scripts changed according to git,
+// as requested via --staged or --since.
+func formatGitChanges(pool *pool) error {
+	changes, err := gitChanges(staged.val, since.val, flag.Args())
+	if err != nil {
 		return err
 	}
+	gitLines = make(map[string][]lineRange, len(changes))
+	for _, change := range changes {
+		gitLines[change.path] = change.lines
+	}
+	for _, change := range changes {
+		if len(change.lines) == 0 {
+			continue // only lines were removed
+		}
+		info, err := os.Lstat(change.path)
+		if err != nil {
+			pool.fail(err)
+			continue
+		}
+		// Like when walking directories, skip files which aren't scripts.
+		if err := walkPath(pool, change.path, fs.FileInfoToDirEntry(info)); err != nil && err != filepath.SkipDir {
+			pool.fail(err)
+		}
+	}
 	return nil
 }
 
+// lineRanges returns the lines to format in a file,
+// or nil if all lines should be formatted.
+func lineRanges(path string) []lineRange {
+	if lines.val != (lineRange{}) {
+		return []lineRange{lines.val}
+	}
+	return gitLines[path]
+}
+
 var ecQuery = editorconfig.Query{
 	FileCache:   make(map[string]*editorconfig.File),
 	RegexpCache: make(map[string]*regexp.Regexp),
 }
 
-func propsOptions(lang syntax.LangVariant, props editorconfig.Section) {
+var cfgLoader fmtconfig.Loader
+
+// ignoreFiles holds the .shfmtignore files for each volume, found by absolute
+// paths from the root of the filesystem.
+var ignoreFiles = make(map[string]*fileutil.IgnoreFiles)
+
+// configMu guards ecQuery, cfgLoader, and ignoreFiles, which cache the files
+// they read, as multiple files may be formatted concurrently.
+var configMu sync.Mutex
+
+// isIgnored reports whether a path is listed in any .shfmtignore files,
+// or whether any EditorConfig or shfmt configuration files set ignore=true
+// for it.
+func isIgnored(path string, isDir bool) (bool, error) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	if ignored, err := ignoreFileListed(path, isDir); err != nil || ignored {
+		return ignored, err
+	}
+	cfg, err := cfgLoader.Load(path, syntax.LangAuto)
+	if err != nil {
+		return false, err
+	}
+	if cfg != nil && cfg.Ignore {
+		return true, nil
+	}
+	props, err := ecQuery.Find(path, []string{"shell"})
+	if err != nil {
+		return false, err
+	}
+	return props.Get("ignore") == "true", nil
+}
+
+func ignoreFileListed(path string, isDir bool) (bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false, err
+	}
+	vol := filepath.VolumeName(abs)
+	name := strings.TrimPrefix(filepath.ToSlash(abs[len(vol):]), "/")
+	if name == "" {
+		return false, nil // the root directory
+	}
+	files := ignoreFiles[vol]
+	if files == nil {
+		files = &fileutil.IgnoreFiles{FS: os.DirFS(vol + "/")}
+		ignoreFiles[vol] = files
+	}
+	ignored, err := files.Ignored(name, isDir)
+	if pathErr, ok := err.(*fs.PathError); ok {
+		// Make the path absolute again, as it is relative to the volume.
+		pathErr.Path = filepath.Join(vol+string(filepath.Separator), filepath.FromSlash(pathErr.Path))
+	}
+	return ignored, err
+}
+
+// formatter holds the state needed to format files, so that each of the
+// goroutines formatting files concurrently can use its own.
+type formatter struct {
+	parser            *syntax.Parser
+	printer           *syntax.Printer
+	readBuf, writeBuf bytes.Buffer
+	copyBuf           []byte
+
+	// stdout is where the output for a file is written to,
+	// which is buffered when formatting files concurrently.
+	stdout io.Writer
+
+	// commit, if set while formatting a file, records its results in the
+	// report or the lint findings. It is run after the output for all the
+	// previous files was written, so that the results are in order.
+	commit func()
+
+	// status is the status of the file for the summary, or empty if the
+	// file was not formatted, such as when it turned out to not be a script.
+	status string
+}
+
+func newFormatter() *formatter {
+	f := &formatter{
+		parser:  syntax.NewParser(syntax.KeepComments(true)),
+		printer: syntax.NewPrinter(syntax.Minify(minify.val)),
+		copyBuf: make([]byte, 32*1024),
+		stdout:  os.Stdout,
+	}
+	if !useConfigFiles {
+		syntax.Indent(indent.val)(f.printer)
+		syntax.BinaryNextLine(binNext.val)(f.printer)
+		syntax.SwitchCaseIndent(caseIndent.val)(f.printer)
+		syntax.SpaceRedirects(spaceRedirs.val)(f.printer)
+		syntax.KeepPadding(keepPadding.val)(f.printer)
+		syntax.FunctionNextLine(funcNext.val)(f.printer)
+	}
+	return f
+}
+
+func (f *formatter) propsOptions(lang syntax.LangVariant, props editorconfig.Section) {
+	parser, printer := f.parser, f.printer
 	// if shell_variant is set to a valid string, it will take precedence
 	lang.Set(props.Get("shell_variant"))
 	syntax.Variant(lang)(parser)
@@ -382,12 +819,26 @@ func propsOptions(lang syntax.LangVariant, props editorconfig.Section) {
 	syntax.FunctionNextLine(props.Get("function_next_line") == "true")(printer)
 }
 
-func formatPath(path string, checkShebang bool) error {
+func (fm *formatter) formatPath(path string, checkShebang bool) error {
 	f, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
+	readBuf, copyBuf := &fm.readBuf, fm.copyBuf
+
+	if embed.val[embedded.Format(path)] {
+		if find.val {
+			fmt.Fprintln(fm.stdout, path)
+			return nil
+		}
+		readBuf.Reset()
+		if _, err := io.CopyBuffer(readBuf, f, copyBuf); err != nil {
+			return err
+		}
+		f.Close()
+		return fm.formatEmbedded(readBuf.Bytes(), path)
+	}
 
 	fileLang := lang.val
 	shebangForAuto := false
@@ -399,7 +850,7 @@ func formatPath(path string, checkShebang bool) error {
 	}
 	readBuf.Reset()
 	if checkShebang || shebangForAuto {
-		n, err := io.ReadAtLeast(f, copyBuf[:32], len("#!/bin/sh\n"))
+		n, err := io.ReadAtLeast(f, copyBuf[:fileutil.ShebangLen], len("#!/bin/sh\n"))
 		switch {
 		case !checkShebang:
 			// only wanted the shebang for LangAuto
@@ -421,49 +872,109 @@ func formatPath(path string, checkShebang bool) error {
 		readBuf.Write(copyBuf[:n])
 	}
 	if find.val {
-		fmt.Println(path)
+		fmt.Fprintln(fm.stdout, path)
 		return nil
 	}
-	if _, err := io.CopyBuffer(&readBuf, f, copyBuf); err != nil {
+	if _, err := io.CopyBuffer(readBuf, f, copyBuf); err != nil {
 		return err
 	}
 	f.Close()
-	return formatBytes(readBuf.Bytes(), path, fileLang)
+	return fm.formatBytes(readBuf.Bytes(), path, fileLang)
+}
+
+// fileConfig is the configuration used to format a file.
+type fileConfig struct {
+	lang     syntax.LangVariant // the language variant the parser uses
+	simplify bool
+
+	// key describes all the options affecting the formatting,
+	// for the entries in the cache.
+	key string
 }
 
-func editorConfigLangs(l syntax.LangVariant) []string {
-	// All known shells match [[shell]].
-	// As a special case, bash and the bash-like bats also match [[bash]]
-	// We can later consider others like [[mksh]] or [[posix-shell]],
-	// just consider what list of languages the EditorConfig spec might eventually use.
-	switch l {
-	case syntax.LangBash, syntax.LangBats:
-		return []string{"shell", "bash"}
-	case syntax.LangPOSIX, syntax.LangMirBSDKorn, syntax.LangAuto:
-		return []string{"shell"}
+// applyConfig configures the parser and printer to format the script at path
+// following the flags or any configuration files.
+func (f *formatter) applyConfig(path string, fileLang syntax.LangVariant) (fileConfig, error) {
+	conf, err := f.loadConfig(path, fileLang)
+	conf.key = fmt.Sprintf("%s simplify=%t minify=%t fix=%t to=%s lines=%v",
+		conf.key, conf.simplify, minify.val, fix.val, to.val, lineRanges(path))
+	return conf, err
+}
+
+func (f *formatter) loadConfig(path string, fileLang syntax.LangVariant) (fileConfig, error) {
+	parser, printer := f.parser, f.printer
+	conf := fileConfig{lang: fileLang, simplify: simplify.val}
+	if !useConfigFiles {
+		syntax.Variant(fileLang)(parser)
+		conf.key = fmt.Sprintf("flags lang=%s indent=%d bn=%t ci=%t sr=%t kp=%t fn=%t",
+			fileLang, indent.val, binNext.val, caseIndent.val, spaceRedirs.val, keepPadding.val, funcNext.val)
+		return conf, nil
 	}
-	return nil
+	configMu.Lock()
+	defer configMu.Unlock()
+	// shfmt's own configuration files take precedence over EditorConfig.
+	cfg, err := cfgLoader.Load(path, fileLang)
+	if err != nil {
+		return conf, err
+	}
+	if cfg != nil {
+		if cfg.Lang != syntax.LangAuto {
+			conf.lang = cfg.Lang
+		}
+		syntax.Variant(conf.lang)(parser)
+		for _, opt := range cfg.PrinterOptions() {
+			opt(printer)
+		}
+		if minify.val {
+			syntax.Minify(true)(printer)
+		}
+		conf.simplify = conf.simplify || cfg.Simplify
+		conf.key = fmt.Sprintf("shfmt lang=%s %+v", conf.lang, *cfg)
+		return conf, nil
+	}
+	props, err := ecQuery.Find(path, fmtconfig.Languages(fileLang))
+	if err != nil {
+		return conf, err
+	}
+	f.propsOptions(fileLang, props)
+	syntax.Minify(minify.val)(printer)
+	conf.key = fmt.Sprintf("editorconfig lang=%s %s", fileLang, props.String())
+	return conf, nil
 }
 
-func formatBytes(src []byte, path string, fileLang syntax.LangVariant) error {
-	if useEditorConfig {
-		props, err := ecQuery.Find(path, editorConfigLangs(fileLang))
-		if err != nil {
-			return err
+func (f *formatter) formatBytes(src []byte, path string, fileLang syntax.LangVariant) error {
+	conf, err := f.applyConfig(path, fileLang)
+	if err != nil {
+		return err
+	}
+	fileLang = conf.lang
+	// The cache only remembers which files are formatted,
+	// so it can't be used when doing anything else with them.
+	useCache := cache != nil && rep == nil && lnt == nil && !fromJSON.val && !toJSON.val
+	var cacheKey string
+	if useCache {
+		cacheKey = cache.key(conf.key, src)
+		if cache.has(cacheKey) {
+			f.status = fileUnchanged
+			if !list.val && !write.val && !diff.val {
+				f.stdout.Write(src)
+			}
+			return nil
 		}
-		propsOptions(fileLang, props)
-	} else {
-		syntax.Variant(fileLang)(parser)
 	}
 	var node syntax.Node
-	var err error
 	if fromJSON.val {
 		node, err = typedjson.Decode(bytes.NewReader(src))
 		if err != nil {
 			return err
 		}
 	} else {
-		node, err = parser.Parse(bytes.NewReader(src), path)
+		node, err = f.parser.ParseBytes(src, path)
+		if err != nil && rep != nil {
+			// Parse errors are part of the report, not failures.
+			f.commit = func() { rep.addError(path, fileLang, err) }
+			return nil
+		}
 		if err != nil {
 			if s, ok := err.(syntax.LangError); ok && lang.val == syntax.LangAuto {
 				return fmt.Errorf("%w (parsed as %s via -%s=%s)", s, fileLang, lang.short, lang.val)
@@ -471,21 +982,107 @@ func formatBytes(src []byte, path string, fileLang syntax.LangVariant) error {
 			return err
 		}
 	}
-	if simplify.val {
+	if rep != nil {
+		// src is only valid until the next file is formatted.
+		src := bytes.Clone(src)
+		f.commit = func() { rep.addFile(path, fileLang, src, node.(*syntax.File)) }
+		return nil
+	}
+	if lnt != nil {
+		f.commit = func() { lnt.addFile(path, node.(*syntax.File), lineRanges(path)) }
+		return nil
+	}
+	if to.val != syntax.LangAuto {
+		if errs := syntax.Convert(node, fileLang, to.val); len(errs) > 0 {
+			var all []error
+			for _, err := range errs {
+				if path != "" {
+					all = append(all, fmt.Errorf("%s:%w", path, err))
+				} else {
+					all = append(all, err)
+				}
+			}
+			return errors.Join(all...)
+		}
+	}
+	if conf.simplify {
 		syntax.Simplify(node)
 	}
+	if fix.val {
+		syntax.Fix(node, syntax.FixAll)
+	}
 	if toJSON.val {
 		// must be standard input; fine to return
 		// TODO: change the default behavior to be compact,
 		// and allow using --to-json=pretty or --to-json=indent.
-		return typedjson.EncodeOptions{Indent: "\t"}.Encode(os.Stdout, node)
+		return typedjson.EncodeOptions{Indent: "\t"}.Encode(f.stdout, node)
 	}
-	writeBuf.Reset()
-	printer.Print(&writeBuf, node)
-	res := writeBuf.Bytes()
+	f.writeBuf.Reset()
+	f.printer.Print(&f.writeBuf, node)
+	res := f.writeBuf.Bytes()
+	if fromJSON.val {
+		// A syntax tree modified by another tool may not be printable,
+		// such as a literal containing spaces or unbalanced quotes.
+		if _, err := f.parser.ParseBytes(res, ""); err != nil {
+			return fmt.Errorf("syntax tree from JSON does not print as valid shell: %w", err)
+		}
+	}
+	if ranges := lineRanges(path); ranges != nil {
+		res = formatLines(src, res, ranges)
+	}
+	if useCache && bytes.Equal(src, res) {
+		cache.add(cacheKey)
+	}
+	var written func()
+	if useCache {
+		written = func() { cache.add(cache.key(conf.key, res)) }
+	}
+	return f.writeResult(path, src, res, written)
+}
+
+// formatLines returns src with only the formatting changes from res which
+// touch the lines in any of the ranges. A change which spans lines outside of
+// the ranges, such as joining a "then" with its "if" line, is kept as a whole.
+func formatLines(src, res []byte, ranges []lineRange) []byte {
+	oldLines := strings.SplitAfter(string(src), "\n")
+	newLines := strings.SplitAfter(string(res), "\n")
+	var out bytes.Buffer
+	last := 0
+	var hunks []lineHunk
+	for _, h := range lineHunks(oldLines, newLines) {
+		// Consecutive lines which were changed, such as when reindenting,
+		// replace each other one by one.
+		if n := h.oldEnd - h.oldStart; n > 1 && n == h.newEnd-h.newStart {
+			for i := 0; i < n; i++ {
+				hunks = append(hunks, lineHunk{h.oldStart + i, h.oldStart + i + 1, h.newStart + i, h.newStart + i + 1})
+			}
+		} else {
+			hunks = append(hunks, h)
+		}
+	}
+	for _, h := range hunks {
+		if !slices.ContainsFunc(ranges, func(rng lineRange) bool {
+			return h.overlaps(rng.first-1, rng.last-1)
+		}) {
+			continue
+		}
+		out.WriteString(strings.Join(oldLines[last:h.oldStart], ""))
+		out.WriteString(strings.Join(newLines[h.newStart:h.newEnd], ""))
+		last = h.oldEnd
+	}
+	out.WriteString(strings.Join(oldLines[last:], ""))
+	return out.Bytes()
+}
+
+// writeResult writes the result of formatting a file following the flags,
+// such as listing the file if it changed or writing the result to it.
+// If the file is written to, written is called afterwards if it is not nil.
+func (f *formatter) writeResult(path string, src, res []byte, written func()) error {
+	f.status = fileUnchanged
 	if !bytes.Equal(src, res) {
+		f.status = fileChanged
 		if list.val {
-			fmt.Println(path)
+			fmt.Fprintln(f.stdout, path)
 		}
 		if write.val {
 			info, err := os.Lstat(path)
@@ -497,17 +1094,22 @@ func formatBytes(src []byte, path string, fileLang syntax.LangVariant) error {
 			if err := maybeio.WriteFile(path, res, perm); err != nil {
 				return err
 			}
+			f.status = fileWritten
+			if written != nil {
+				written()
+			}
 		}
 		if diff.val {
+			stdout := f.stdout
 			diffBytes := diffpkg.Diff(path+".orig", src, path, res)
 			if !color {
-				os.Stdout.Write(diffBytes)
+				stdout.Write(diffBytes)
 				return errChangedWithDiff
 			}
 			// The first three lines are the header with the filenames, including --- and +++,
 			// and are marked in bold.
 			current := terminalBold
-			os.Stdout.WriteString(current)
+			io.WriteString(stdout, current)
 			for i, line := range bytes.SplitAfter(diffBytes, []byte("\n")) {
 				last := current
 				switch {
@@ -522,15 +1124,15 @@ func formatBytes(src []byte, path string, fileLang syntax.LangVariant) error {
 					current = terminalReset
 				}
 				if current != last {
-					os.Stdout.WriteString(current)
+					io.WriteString(stdout, current)
 				}
-				os.Stdout.Write(line)
+				stdout.Write(line)
 			}
 			return errChangedWithDiff
 		}
 	}
 	if !list.val && !write.val && !diff.val {
-		os.Stdout.Write(res)
+		f.stdout.Write(res)
 	}
 	return nil
 }
@@ -542,3 +1144,50 @@ const (
 	terminalReset = "\u001b[0m"
 	terminalBold  = "\u001b[1m"
 )
+
+// formatEmbedded formats the shell scripts embedded in a host file like a
+// Dockerfile, as requested via --embedded.
+func (f *formatter) formatEmbedded(src []byte, path string) error {
+	res, err := embedded.Replace(path, src, func(s *embedded.Snippet) (string, error) {
+		fileLang := lang.val
+		if fileLang == syntax.LangAuto {
+			if err := fileLang.Set(s.Lang); err != nil {
+				return s.Src, nil // a language we don't support, like zsh
+			}
+		}
+		conf, err := f.applyConfig(path, fileLang)
+		if err != nil {
+			return "", err
+		}
+		node, err := f.parser.Parse(strings.NewReader(s.Src), path)
+		// Errors should use positions in the host file.
+		hostPos := func(pos syntax.Pos) syntax.Pos {
+			line, col := s.SyntaxPos(pos)
+			return syntax.NewPos(pos.Offset(), line, col)
+		}
+		switch perr := err.(type) {
+		case nil:
+		case syntax.ParseError:
+			perr.Pos = hostPos(perr.Pos)
+			return "", perr
+		case syntax.LangError:
+			perr.Pos = hostPos(perr.Pos)
+			return "", perr
+		default:
+			return "", err
+		}
+		if conf.simplify {
+			syntax.Simplify(node)
+		}
+		if fix.val {
+			syntax.Fix(node, syntax.FixAll)
+		}
+		var sb strings.Builder
+		f.printer.Print(&sb, node)
+		return sb.String(), nil
+	})
+	if err != nil {
+		return err
+	}
+	return f.writeResult(path, src, res, nil)
+}
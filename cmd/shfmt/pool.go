@@ -0,0 +1,117 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// pool formats files concurrently, with each worker goroutine using its own
+// formatter. The output for each file is buffered and written in the order
+// that the files were added, so that it is the same as formatting them one
+// after the other.
+type pool struct {
+	jobs  chan *job
+	order chan *job     // jobs in the order they were added
+	done  chan struct{} // closed once all output was written
+
+	status int
+}
+
+type job struct {
+	path           string
+	checkShebang   bool
+	ignoreNotExist bool
+
+	stdout bytes.Buffer
+	commit func()
+	status string
+	err    error
+	done   chan struct{} // closed once the fields above are set
+}
+
+// newPool starts a pool with the given number of workers,
+// defaulting to GOMAXPROCS if zero.
+func newPool(workers int) *pool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	p := &pool{
+		jobs:  make(chan *job),
+		order: make(chan *job, 4*workers),
+		done:  make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go p.work(newFormatter())
+	}
+	go p.emit()
+	return p
+}
+
+// formatPath adds a file to be formatted via [formatter.formatPath].
+// Errors about the file not existing are ignored if ignoreNotExist is set,
+// such as when it was found by walking a directory.
+func (p *pool) formatPath(path string, checkShebang, ignoreNotExist bool) {
+	j := &job{
+		path:           path,
+		checkShebang:   checkShebang,
+		ignoreNotExist: ignoreNotExist,
+		done:           make(chan struct{}),
+	}
+	p.order <- j
+	p.jobs <- j
+}
+
+// fail adds an error which is reported in order with the output for files.
+func (p *pool) fail(err error) {
+	j := &job{err: err, done: make(chan struct{})}
+	close(j.done)
+	p.order <- j
+}
+
+// wait waits for all the files to be formatted and their output written,
+// returning the resulting exit status.
+func (p *pool) wait() int {
+	close(p.jobs)
+	close(p.order)
+	<-p.done
+	return p.status
+}
+
+func (p *pool) work(f *formatter) {
+	for j := range p.jobs {
+		f.stdout = &j.stdout
+		f.commit = nil
+		f.status = ""
+		err := f.formatPath(j.path, j.checkShebang)
+		if err != nil && !(j.ignoreNotExist && os.IsNotExist(err)) {
+			j.err = err
+		}
+		j.commit = f.commit
+		j.status = f.status
+		close(j.done)
+	}
+}
+
+func (p *pool) emit() {
+	for j := range p.order {
+		<-j.done
+		if sum == nil {
+			os.Stdout.Write(j.stdout.Bytes())
+		} else {
+			sum.add(j.path, j.status, j.err)
+		}
+		if j.commit != nil {
+			j.commit()
+		}
+		if j.err != nil && j.err != errChangedWithDiff {
+			fmt.Fprintln(os.Stderr, j.err)
+		}
+		p.status = max(p.status, fileExitStatus(j.status, j.err))
+	}
+	close(p.done)
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// maxLongestFiles is how many of the longest files a report lists.
+const maxLongestFiles = 10
+
+// report collects statistics about all the files visited by shfmt,
+// as requested via --report.
+type report struct {
+	Files     int            `json:"files"`
+	Lines     int            `json:"lines"`
+	Functions int            `json:"functions"`
+	Dialects  map[string]int `json:"dialects"`
+
+	// Constructs counts how many files use each construct at least once.
+	Constructs map[string]int `json:"constructs"`
+
+	LongestFiles []reportFile  `json:"longestFiles"`
+	ParseErrors  []reportError `json:"parseErrors"`
+}
+
+type reportFile struct {
+	Path  string `json:"path"`
+	Lines int    `json:"lines"`
+}
+
+type reportError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+func newReport() *report {
+	return &report{
+		Dialects:     make(map[string]int),
+		Constructs:   make(map[string]int),
+		LongestFiles: []reportFile{},
+		ParseErrors:  []reportError{},
+	}
+}
+
+// addFile records a file which was parsed correctly.
+func (r *report) addFile(path string, lang syntax.LangVariant, src []byte, f *syntax.File) {
+	r.Files++
+	r.Dialects[lang.String()]++
+	lines := bytes.Count(src, []byte("\n"))
+	if len(src) > 0 && src[len(src)-1] != '\n' {
+		lines++
+	}
+	r.Lines += lines
+	r.LongestFiles = append(r.LongestFiles, reportFile{Path: path, Lines: lines})
+	slices.SortStableFunc(r.LongestFiles, func(a, b reportFile) int {
+		return b.Lines - a.Lines
+	})
+	if len(r.LongestFiles) > maxLongestFiles {
+		r.LongestFiles = r.LongestFiles[:maxLongestFiles]
+	}
+
+	used := make(map[string]bool)
+	syntax.Walk(f, func(node syntax.Node) bool {
+		if name := constructName(node); name != "" {
+			used[name] = true
+		}
+		if _, ok := node.(*syntax.FuncDecl); ok {
+			r.Functions++
+		}
+		return true
+	})
+	for name := range used {
+		r.Constructs[name]++
+	}
+}
+
+// addError records a file which failed to parse.
+func (r *report) addError(path string, lang syntax.LangVariant, err error) {
+	r.Files++
+	r.Dialects[lang.String()]++
+	r.ParseErrors = append(r.ParseErrors, reportError{Path: path, Error: err.Error()})
+}
+
+// constructName returns the name of the construct a node represents,
+// if it is one of those tracked by the report.
+func constructName(node syntax.Node) string {
+	switch node := node.(type) {
+	case *syntax.FuncDecl:
+		return "functions"
+	case *syntax.ArrayExpr:
+		return "arrays"
+	case *syntax.TestClause:
+		return "[[ ]]"
+	case *syntax.ArithmCmd:
+		return "(( ))"
+	case *syntax.ArithmExp:
+		return "$(( ))"
+	case *syntax.CmdSubst:
+		if node.Backquotes {
+			return "backquotes"
+		}
+		return "$( )"
+	case *syntax.ProcSubst:
+		return "process substitutions"
+	case *syntax.ExtGlob:
+		return "extended globs"
+	case *syntax.CaseClause:
+		return "case"
+	case *syntax.ForClause:
+		if node.Select {
+			return "select"
+		}
+		return "for"
+	case *syntax.WhileClause:
+		return "while"
+	case *syntax.DeclClause:
+		return node.Variant.Value
+	case *syntax.LetClause:
+		return "let"
+	case *syntax.CoprocClause:
+		return "coproc"
+	case *syntax.TimeClause:
+		return "time"
+	case *syntax.Redirect:
+		switch node.Op {
+		case syntax.Hdoc, syntax.DashHdoc:
+			return "heredocs"
+		case syntax.WordHdoc:
+			return "herestrings"
+		}
+	case *syntax.SglQuoted:
+		if node.Dollar {
+			return "$' '"
+		}
+	case *syntax.ParamExp:
+		if node.Index != nil {
+			return "array indexing"
+		}
+	}
+	return ""
+}
+
+func (r *report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+func (r *report) writeMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# shfmt report\n\n")
+	fmt.Fprintf(&b, "- Files: %d\n", r.Files)
+	fmt.Fprintf(&b, "- Lines: %d\n", r.Lines)
+	fmt.Fprintf(&b, "- Functions: %d\n", r.Functions)
+	fmt.Fprintf(&b, "- Parse errors: %d\n", len(r.ParseErrors))
+
+	writeCounts := func(title, column string, counts map[string]int) {
+		fmt.Fprintf(&b, "\n## %s\n\n| %s | Files |\n| --- | --- |\n", title, column)
+		names := make([]string, 0, len(counts))
+		for name := range counts {
+			names = append(names, name)
+		}
+		// Most common first, then sorted by name for stable output.
+		slices.SortFunc(names, func(a, b string) int {
+			if n := counts[b] - counts[a]; n != 0 {
+				return n
+			}
+			return strings.Compare(a, b)
+		})
+		for _, name := range names {
+			fmt.Fprintf(&b, "| `%s` | %d |\n", name, counts[name])
+		}
+	}
+	writeCounts("Dialects", "Dialect", r.Dialects)
+	writeCounts("Constructs", "Construct", r.Constructs)
+
+	if len(r.LongestFiles) > 0 {
+		fmt.Fprintf(&b, "\n## Longest files\n\n| File | Lines |\n| --- | --- |\n")
+		for _, f := range r.LongestFiles {
+			fmt.Fprintf(&b, "| %s | %d |\n", f.Path, f.Lines)
+		}
+	}
+	if len(r.ParseErrors) > 0 {
+		fmt.Fprintf(&b, "\n## Parse errors\n\n")
+		for _, e := range r.ParseErrors {
+			fmt.Fprintf(&b, "- %s\n", e.Error)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// The exit codes, as documented in shfmt.1.scd.
+// When more than one applies, such as when some files need formatting and
+// others could not be parsed, the highest one is used.
+const (
+	exitOK         = 0
+	exitChanged    = 1 // files need formatting, or lint findings were found
+	exitUsage      = 2 // invalid flags, which is also what the flag package uses
+	exitParseError = 3 // scripts could not be parsed or converted
+	exitError      = 4 // any other error, such as failing to read or write a file
+)
+
+// exitStatus returns the exit code for an error formatting a file.
+func exitStatus(err error) int {
+	var parseErr syntax.ParseError
+	var langErr syntax.LangError
+	var convertErr syntax.ConvertError
+	switch {
+	case err == nil:
+		return exitOK
+	case err == errChangedWithDiff:
+		return exitChanged
+	case errors.As(err, &parseErr), errors.As(err, &langErr),
+		errors.As(err, &convertErr):
+		return exitParseError
+	}
+	return exitError
+}
+
+// The status of each file in the summary.
+const (
+	fileUnchanged  = "unchanged"   // the file was already formatted
+	fileChanged    = "changed"     // the file needs formatting
+	fileWritten    = "written"     // the file was formatted and written to
+	fileParseError = "parse-error" // the file could not be parsed or converted
+	fileError      = "error"       // any other error
+)
+
+// summary collects the status of each file formatted by shfmt,
+// as requested via --summary.
+type summary struct {
+	Files    []summaryFile `json:"files"`
+	ExitCode int           `json:"exitCode"`
+}
+
+type summaryFile struct {
+	Path   string `json:"path,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// add records the result of formatting a file, where status is empty if the
+// file turned out to not be a shell script.
+func (s *summary) add(path, status string, err error) {
+	switch exitStatus(err) {
+	case exitOK:
+		if status == "" {
+			return
+		}
+	case exitChanged:
+		status = fileChanged
+	case exitParseError:
+		status = fileParseError
+	default:
+		status = fileError
+		// Errors while walking directories are not for a file being formatted.
+		var pathErr *fs.PathError
+		if path == "" && errors.As(err, &pathErr) {
+			path = pathErr.Path
+		}
+	}
+	file := summaryFile{Path: path, Status: status}
+	if err != nil && err != errChangedWithDiff {
+		file.Error = err.Error()
+	}
+	s.Files = append(s.Files, file)
+}
+
+func (s *summary) writeJSON(w io.Writer, status int) error {
+	s.ExitCode = status
+	if s.Files == nil {
+		s.Files = []summaryFile{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(s)
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package embedded
+
+import (
+	"regexp"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+var dockerHeredoc = regexp.MustCompile(`^<<(-?)(["']?)([A-Za-z_][A-Za-z0-9_]*)(["']?)\s*$`)
+
+// Dockerfile returns the shell snippets in the RUN instructions of a
+// Dockerfile, which are run with "/bin/sh -c" by default.
+//
+// Lines continued with a trailing backslash are kept as such, as they mean
+// the same in shell, but comment and empty lines between them are left out
+// like Docker does. RUN instructions using the exec form like `RUN ["ls", "-l"]` are
+// skipped, and those using a single heredoc like "RUN <<EOF" result in the
+// heredoc's body. Flags like "--mount" are skipped.
+func Dockerfile(src []byte) []Snippet {
+	lines := splitLines(src)
+	var snippets []Snippet
+	var b builder
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		start := indentLen(line)
+		rest := line[start:]
+		if rest == "" || rest[0] == '#' {
+			continue
+		}
+		word, _, _ := strings.Cut(strings.ReplaceAll(rest, "\t", " "), " ")
+		if !strings.EqualFold(word, "RUN") {
+			i = skipContinued(lines, i)
+			continue
+		}
+		// Skip the instruction and any flags.
+		argStart := start + len(word)
+		for {
+			argStart += indentLen(line[argStart:])
+			if !strings.HasPrefix(line[argStart:], "--") {
+				break
+			}
+			flag, _, _ := strings.Cut(strings.ReplaceAll(line[argStart:], "\t", " "), " ")
+			argStart += len(flag)
+		}
+		args := line[argStart:]
+		if strings.HasPrefix(args, "[") {
+			i = skipContinued(lines, i) // exec form
+			continue
+		}
+		if m := dockerHeredoc.FindStringSubmatch(args); m != nil && m[2] == m[4] {
+			stripTabs, delim := m[1] == "-", m[3]
+			host := &hostRange{start: i + 1}
+			for i++; i < len(lines); i++ {
+				body := lines[i]
+				if stripTabs {
+					body = strings.TrimLeft(body, "\t")
+				}
+				if body == delim {
+					break
+				}
+				b.add(body+"\n", uint(i+1), uint(len(lines[i])-len(body)+1))
+			}
+			host.end = i
+			s := b.snippet("sh")
+			s.host = host
+			snippets = append(snippets, s)
+			continue
+		}
+		host := &hostRange{
+			start:     i,
+			first:     line[:argStart],
+			indent:    line[:start],
+			continued: true,
+		}
+		b.add(args, uint(i+1), uint(argStart+1))
+		for strings.HasSuffix(lines[i], `\`) && i+1 < len(lines) {
+			b.add("\n", uint(i+1), uint(len(lines[i])+1))
+			i++
+			for i+1 < len(lines) && isDockerComment(lines[i]) {
+				// Comments and empty lines are removed by Docker,
+				// so they would be lost when replacing the snippet.
+				i++
+				host = nil
+			}
+			b.add(lines[i], uint(i+1), 1)
+		}
+		b.add("\n", uint(i+1), uint(len(lines[i])+1))
+		s := b.snippet("sh")
+		if host != nil {
+			host.end = i + 1
+			s.host = host
+		}
+		snippets = append(snippets, s)
+	}
+	return snippets
+}
+
+func isDockerComment(line string) bool {
+	line = strings.TrimLeft(line, " \t")
+	return line == "" || line[0] == '#'
+}
+
+// skipContinued returns the index of the last line of an instruction starting
+// at line i, which may be continued with trailing backslashes.
+func skipContinued(lines []string, i int) int {
+	for strings.HasSuffix(lines[i], `\`) && i+1 < len(lines) {
+		i++
+	}
+	return i
+}
+
+// joinable reports whether the lines of a shell program still mean the same
+// when joined as a single line, like Docker does with the lines of a RUN
+// instruction which end with backslashes.
+func joinable(lang string, lines []string) bool {
+	if len(lines) < 2 {
+		return true
+	}
+	var variant syntax.LangVariant
+	if err := variant.Set(lang); err != nil {
+		variant = syntax.LangBash
+	}
+	parser := syntax.NewParser(syntax.Variant(variant))
+	// Minifying removes line breaks and comments which do not change
+	// what the program does, so two equivalent programs print the same.
+	printer := syntax.NewPrinter(syntax.Minify(true))
+	minify := func(src string) (string, bool) {
+		f, err := parser.Parse(strings.NewReader(src), "")
+		if err != nil {
+			return "", false
+		}
+		var sb strings.Builder
+		printer.Print(&sb, f)
+		return sb.String(), true
+	}
+	split, ok := minify(strings.Join(lines, "\n"))
+	if !ok {
+		return false
+	}
+	joined, ok := minify(strings.Join(lines, " "))
+	return ok && joined == split
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package embedded locates shell scripts embedded in other file formats, such
+// as the RUN instructions in a Dockerfile, so that they can be parsed,
+// formatted, or linted like any other shell script.
+//
+// Each script is returned as a [Snippet], which keeps track of where each of
+// its parts came from, so that positions such as those of parse errors can be
+// mapped back to the host file. [Replace] puts new sources for the snippets
+// back into the host file, such as after formatting them.
+package embedded
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Snippet is a shell script embedded in a host file.
+type Snippet struct {
+	// Src is the shell source, as it would be run.
+	Src string
+
+	// Lang is the shell language the snippet is written in, such as "sh" or
+	// "bash", which can be given to [syntax.LangVariant.Set]. Note that it
+	// may also be a language which the syntax package does not support,
+	// such as "zsh".
+	Lang string
+
+	segs []segment
+	host *hostRange
+}
+
+// hostRange describes which lines of the host file hold a snippet,
+// so that its source can be replaced via [Replace].
+// It is nil for snippets which cannot be replaced.
+type hostRange struct {
+	start, end int // the 0-based range of lines, end being exclusive
+
+	first  string // the text kept at the start of the first line, like "RUN "
+	indent string // the indentation for any following non-empty lines
+	last   string // the text kept at the end of the last line, like a comment
+
+	// continued means that the lines are joined with trailing backslashes,
+	// like in a Dockerfile RUN instruction.
+	continued bool
+
+	// plain means that the new source must be a single line which can be
+	// a plain scalar in YAML, as it is not quoted.
+	plain bool
+}
+
+// segment is a part of a snippet which was copied as-is from a single line
+// in the host file.
+type segment struct {
+	offset    int  // byte offset in Src
+	line, col uint // position in the host file
+}
+
+// Pos returns the line and column in the host file which correspond to the
+// byte offset in Src, such as from [syntax.Pos.Offset]. Like in the syntax
+// package, lines and columns are 1-based and columns count bytes.
+func (s *Snippet) Pos(offset int) (line, col uint) {
+	if len(s.segs) == 0 {
+		return 0, 0
+	}
+	i := sort.Search(len(s.segs), func(i int) bool {
+		return s.segs[i].offset > offset
+	}) - 1
+	if i < 0 {
+		return s.segs[0].line, s.segs[0].col
+	}
+	seg := s.segs[i]
+	return seg.line, seg.col + uint(offset-seg.offset)
+}
+
+// SyntaxPos is like [Snippet.Pos], taking a position from parsing Src.
+func (s *Snippet) SyntaxPos(pos syntax.Pos) (line, col uint) {
+	return s.Pos(int(pos.Offset()))
+}
+
+// The host file formats returned by [Format].
+const (
+	FormatDockerfile = "dockerfile"
+	FormatMakefile   = "makefile"
+	FormatMarkdown   = "markdown"
+	FormatYAML       = "yaml" // only GitHub Actions workflows for now
+)
+
+// Format returns the format of a host file from its name, such as
+// [FormatDockerfile] for a file named "Dockerfile", or an empty string if the
+// format is not supported.
+func Format(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	lower := strings.ToLower(path.Base(name))
+	switch ext := path.Ext(lower); {
+	case lower == "dockerfile", lower == "containerfile",
+		ext == ".dockerfile", strings.HasPrefix(lower, "dockerfile."):
+		return FormatDockerfile
+	case lower == "makefile", lower == "gnumakefile", ext == ".mk", ext == ".mak":
+		return FormatMakefile
+	case ext == ".md", ext == ".markdown":
+		return FormatMarkdown
+	case ext == ".yml", ext == ".yaml":
+		if strings.Contains(name, ".github/workflows/") {
+			return FormatYAML
+		}
+	}
+	return ""
+}
+
+// Extract returns the shell snippets embedded in src, choosing the format of
+// the host file from its name via [Format]. For instance, a file named
+// "Dockerfile" is handled via [Dockerfile], and one ending with ".md" via
+// [Markdown]. If the format is not supported, no snippets are returned.
+func Extract(name string, src []byte) []Snippet {
+	switch Format(name) {
+	case FormatDockerfile:
+		return Dockerfile(src)
+	case FormatMakefile:
+		return Makefile(src)
+	case FormatMarkdown:
+		return Markdown(src)
+	case FormatYAML:
+		return GitHubActions(src)
+	}
+	return nil
+}
+
+// Replace returns a copy of the host file src with the source of each of its
+// snippets replaced by the result of calling fn with it, which is usually
+// the snippet's source after formatting it. The snippets are found as with
+// [Extract], and if fn returns an error, Replace stops and returns it.
+//
+// The new sources follow the host file's indentation and quoting, such as
+// continuing the lines of a Dockerfile RUN instruction with backslashes.
+// Snippets whose new source cannot be embedded without changing its meaning
+// are left as they were, such as a single-line YAML value which would need
+// multiple lines, or a RUN instruction with multiple lines which Docker would
+// join as one. Makefile recipes are never replaced, as they are made up of
+// separate commands.
+func Replace(name string, src []byte, fn func(s *Snippet) (string, error)) ([]byte, error) {
+	lines := strings.SplitAfter(string(src), "\n")
+	var out strings.Builder
+	next := 0 // the next line to copy
+	for _, s := range Extract(name, src) {
+		if s.host == nil {
+			continue
+		}
+		newSrc, err := fn(&s)
+		if err != nil {
+			return nil, err
+		}
+		newLines, ok := s.host.lines(s.Lang, newSrc)
+		if !ok {
+			continue
+		}
+		for _, line := range lines[next:s.host.start] {
+			out.WriteString(line)
+		}
+		newline := "\n"
+		if s.host.start < len(lines) && strings.HasSuffix(lines[s.host.start], "\r\n") {
+			newline = "\r\n"
+		}
+		for i, line := range newLines {
+			out.WriteString(line)
+			if i == len(newLines)-1 && s.host.end > s.host.start {
+				// Keep the last line's ending, which is missing
+				// if it was the end of the host file.
+				last := lines[s.host.end-1]
+				out.WriteString(last[len(strings.TrimRight(last, "\r\n")):])
+			} else {
+				out.WriteString(newline)
+			}
+		}
+		next = s.host.end
+	}
+	for _, line := range lines[next:] {
+		out.WriteString(line)
+	}
+	return []byte(out.String()), nil
+}
+
+// lines returns the host file lines to replace the snippet's lines with,
+// without line endings, and whether the new source can be embedded.
+func (h *hostRange) lines(lang, src string) ([]string, bool) {
+	srcLines := splitLines([]byte(src))
+	if h.plain && (len(srcLines) != 1 || !plainScalar(srcLines[0])) {
+		return nil, false
+	}
+	if h.continued && !joinable(lang, srcLines) {
+		return nil, false
+	}
+	lines := make([]string, len(srcLines))
+	for i, line := range srcLines {
+		switch {
+		case i == 0:
+			line = h.first + line
+		case line != "":
+			line = h.indent + line
+		}
+		if h.continued && i < len(srcLines)-1 {
+			line += " \\"
+		}
+		lines[i] = line
+	}
+	if len(lines) > 0 {
+		lines[len(lines)-1] += h.last
+	}
+	return lines, true
+}
+
+// builder builds a snippet from parts of the lines in the host file.
+type builder struct {
+	sb   strings.Builder
+	segs []segment
+}
+
+// add adds text found at the given position in the host file.
+func (b *builder) add(text string, line, col uint) {
+	b.segs = append(b.segs, segment{offset: b.sb.Len(), line: line, col: col})
+	b.sb.WriteString(text)
+}
+
+func (b *builder) empty() bool { return b.sb.Len() == 0 }
+
+func (b *builder) snippet(lang string) Snippet {
+	s := Snippet{Src: b.sb.String(), Lang: lang, segs: b.segs}
+	*b = builder{}
+	return s
+}
+
+// splitLines splits src into lines, without their line endings.
+func splitLines(src []byte) []string {
+	s := string(src)
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
+
+// indentLen returns the number of leading spaces and tabs in s.
+func indentLen(s string) int {
+	return len(s) - len(strings.TrimLeft(s, " \t"))
+}
@@ -0,0 +1,311 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package embedded
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+type wantSnippet struct {
+	src  string
+	lang string
+	// pos maps substrings of src, which must be unique,
+	// to their line and column in the host file.
+	pos map[string][2]uint
+}
+
+var extractTests = []struct {
+	name string
+	src  string
+	want []wantSnippet
+}{
+	{
+		name: "Dockerfile",
+		src: "FROM alpine\n" +
+			"# RUN not this\n" +
+			"ENV A=b \\\n" +
+			"    RUN=c\n" +
+			"RUN apk add \\\n" +
+			"    # a comment\n" +
+			"      curl  &&  echo $A\n" +
+			"run --mount=type=cache,target=/x  make\n" +
+			"RUN [\"echo\", \"exec form\"]\n" +
+			"RUN <<EOF\n" +
+			"set -e\n" +
+			"echo heredoc\n" +
+			"EOF\n",
+		want: []wantSnippet{
+			{"apk add \\\n      curl  &&  echo $A\n", "sh", map[string][2]uint{
+				"apk":  {5, 5},
+				"curl": {7, 7},
+			}},
+			{"make\n", "sh", map[string][2]uint{"make": {8, 35}}},
+			{"set -e\necho heredoc\n", "sh", map[string][2]uint{"heredoc": {12, 6}}},
+		},
+	},
+	{
+		name: "src/Makefile",
+		src: "CC := gcc\n" +
+			"X = a:b\n" +
+			"all: foo ; @echo start\n" +
+			"\t@echo $$HOME \\\n" +
+			"\t\t$(CC)\n" +
+			"\n" +
+			"# comment\n" +
+			"\t-rm -f $$x$$y\n" +
+			".PHONY: all\n" +
+			"define tmpl\n" +
+			"\techo not a recipe\n" +
+			"endef\n" +
+			"clean:\n" +
+			"\trm -rf out\n",
+		want: []wantSnippet{
+			{"echo start\necho $HOME \\\n\t$(CC)\nrm -f $x$y\n", "sh", map[string][2]uint{
+				"start": {3, 18},
+				"HOME":  {4, 10},
+				"CC":    {5, 5},
+				"x$y":   {8, 11},
+				"y\n":   {8, 14},
+			}},
+			{"rm -rf out\n", "sh", map[string][2]uint{"out": {14, 9}}},
+		},
+	},
+	{
+		name: ".github/workflows/ci.yml",
+		src: "on: push\n" +
+			"jobs:\n" +
+			"  test:\n" +
+			"    steps:\n" +
+			"      - run: go test ./...  # plain\n" +
+			"      - name: Multi\n" +
+			"        run: |\n" +
+			"          set -e\n" +
+			"            indented\n" +
+			"          echo done\n" +
+			"\n" +
+			"        shell: bash\n" +
+			"      - run: \"quoted\"\n" +
+			"      - run: >\n" +
+			"          folded\n",
+		want: []wantSnippet{
+			{"go test ./...\n", "bash", map[string][2]uint{"test": {5, 17}}},
+			{"set -e\n  indented\necho done\n", "bash", map[string][2]uint{
+				"set":      {8, 11},
+				"indented": {9, 13},
+				"done":     {10, 16},
+			}},
+		},
+	},
+	{
+		name: "README.md",
+		src: "# Title\n" +
+			"```sh\n" +
+			"echo one\n" +
+			"```\n" +
+			"```go\n" +
+			"fmt.Println()\n" +
+			"```\n" +
+			"~~~~ Bash\n" +
+			"echo two\n" +
+			"echo '```'\n" +
+			"  echo three\n" +
+			"~~~~\n" +
+			"```console\n" +
+			"$ echo four\n" +
+			"```\n",
+		want: []wantSnippet{
+			{"echo one\n", "sh", map[string][2]uint{"one": {3, 6}}},
+			{"echo two\necho '```'\n  echo three\n", "bash", map[string][2]uint{
+				"two":   {9, 6},
+				"three": {11, 8},
+			}},
+		},
+	},
+	{name: "main.go", src: "package main\n"},
+	{name: "config.yml", src: "run: echo foo\n"},
+}
+
+func TestExtract(t *testing.T) {
+	t.Parallel()
+	for _, tc := range extractTests {
+		got := Extract(tc.name, []byte(tc.src))
+		if len(got) != len(tc.want) {
+			t.Errorf("%s: got %d snippets, want %d: %+v", tc.name, len(got), len(tc.want), got)
+			continue
+		}
+		for i, want := range tc.want {
+			s := got[i]
+			if s.Src != want.src || s.Lang != want.lang {
+				t.Errorf("%s: snippet %d got %q in %q, want %q in %q",
+					tc.name, i, s.Src, s.Lang, want.src, want.lang)
+				continue
+			}
+			if _, err := syntax.NewParser().Parse(strings.NewReader(s.Src), ""); err != nil {
+				t.Errorf("%s: snippet %d does not parse: %v", tc.name, i, err)
+			}
+			for sub, pos := range want.pos {
+				offset := strings.Index(s.Src, sub)
+				line, col := s.Pos(offset)
+				if line != pos[0] || col != pos[1] {
+					t.Errorf("%s: snippet %d maps %q to %d:%d, want %d:%d",
+						tc.name, i, sub, line, col, pos[0], pos[1])
+				}
+			}
+		}
+	}
+}
+
+func TestSyntaxPos(t *testing.T) {
+	t.Parallel()
+	src := "FROM alpine\nRUN echo ok && \\\n    echo )\n"
+	snippets := Dockerfile([]byte(src))
+	if len(snippets) != 1 {
+		t.Fatalf("got %d snippets, want 1", len(snippets))
+	}
+	s := snippets[0]
+	_, err := syntax.NewParser().Parse(strings.NewReader(s.Src), "")
+	var perr syntax.ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("wanted a parse error, got %v", err)
+	}
+	line, col := s.SyntaxPos(perr.Pos)
+	if line != 3 || col != 10 {
+		t.Fatalf("parse error mapped to %d:%d, want 3:10", line, col)
+	}
+}
+
+var replaceTests = []struct {
+	name string
+	src  string
+	want string
+}{
+	{
+		name: "Dockerfile",
+		src: "FROM alpine\n" +
+			"RUN apk  add curl && \\\n" +
+			"    echo  done\n" +
+			"  RUN --mount=type=cache,target=/x  make  all\n" +
+			"RUN echo  a && \\\n" +
+			"    # Docker drops this comment\n" +
+			"    echo  b\n" +
+			"RUN if  true; then \\\n" +
+			"    echo; fi\n" +
+			"RUN <<EOF\n" +
+			"if  true; then echo  heredoc; fi\n" +
+			"EOF\n" +
+			"CMD [\"sh\"]",
+		want: "FROM alpine\n" +
+			"RUN apk add curl && \\\n" +
+			"\techo done\n" +
+			"  RUN --mount=type=cache,target=/x  make all\n" +
+			"RUN echo  a && \\\n" +
+			"    # Docker drops this comment\n" +
+			"    echo  b\n" +
+			"RUN if  true; then \\\n" +
+			"    echo; fi\n" +
+			"RUN <<EOF\n" +
+			"if true; then echo heredoc; fi\n" +
+			"EOF\n" +
+			"CMD [\"sh\"]",
+	},
+	{
+		name: "README.md",
+		src: "# Title\r\n" +
+			"  ```sh\r\n" +
+			"  foo()  {\r\n" +
+			"  bar\r\n" +
+			"  }\r\n" +
+			"  ```\r\n",
+		want: "# Title\r\n" +
+			"  ```sh\r\n" +
+			"  foo() {\r\n" +
+			"  \tbar\r\n" +
+			"  }\r\n" +
+			"  ```\r\n",
+	},
+	{
+		name: ".github/workflows/test.yml",
+		src: "jobs:\n" +
+			"  test:\n" +
+			"    steps:\n" +
+			"      - run: echo  plain  # comment\n" +
+			"      - run: |\n" +
+			"          for f in *; do\n" +
+			"          echo  $f\n" +
+			"          done\n" +
+			"\n" +
+			"      - run: a=b;  echo  $a\n" +
+			"      - run: foo() { bar; }\n",
+		want: "jobs:\n" +
+			"  test:\n" +
+			"    steps:\n" +
+			"      - run: echo plain  # comment\n" +
+			"      - run: |\n" +
+			"          for f in *; do\n" +
+			"          \techo $f\n" +
+			"          done\n" +
+			"\n" +
+			"      - run: a=b;  echo  $a\n" +
+			"      - run: foo() { bar; }\n",
+	},
+	{
+		name: "Makefile",
+		src:  "all:\n\techo  foo\n",
+		want: "all:\n\techo  foo\n",
+	},
+}
+
+func TestReplace(t *testing.T) {
+	t.Parallel()
+	format := func(s *Snippet) (string, error) {
+		f, err := syntax.NewParser(syntax.KeepComments(true)).Parse(strings.NewReader(s.Src), "")
+		if err != nil {
+			return "", err
+		}
+		var sb strings.Builder
+		syntax.NewPrinter().Print(&sb, f)
+		return sb.String(), nil
+	}
+	for _, tc := range replaceTests {
+		got, err := Replace(tc.name, []byte(tc.src), format)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if string(got) != tc.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tc.name, got, tc.want)
+		}
+	}
+
+	// Errors stop the replacement.
+	_, err := Replace("Dockerfile", []byte("RUN echo )\n"), format)
+	if err == nil {
+		t.Errorf("expected a parse error")
+	}
+}
+
+func TestJoinable(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		lines []string
+		want  bool
+	}{
+		{[]string{"foo"}, true},
+		{[]string{"foo &&", "\tbar |", "\tbaz"}, true},
+		{[]string{"foo", "bar"}, false},
+		{[]string{"foo # comment", "bar"}, false},
+		{[]string{"if true; then", "\tfoo", "fi"}, false},
+		{[]string{"foo )"}, true},
+		{[]string{"foo &&", "bar )"}, false},
+	}
+	for _, test := range tests {
+		if got := joinable("sh", test.lines); got != test.want {
+			t.Errorf("joinable(%q) = %t, want %t", test.lines, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package embedded
+
+import (
+	"regexp"
+	"strings"
+)
+
+var githubRun = regexp.MustCompile(`^(\s*(?:-\s+)?)run:(?:\s+(.*?))?\s*$`)
+
+// GitHubActions returns the shell snippets in the "run" steps of a GitHub
+// Actions workflow, which are run with Bash by default.
+//
+// Both literal block scalars like "run: |" and single-line plain scalars like
+// "run: make test" are supported. Other kinds of YAML scalars, such as quoted
+// or folded ones, are skipped. Note that expressions like "${{ matrix.os }}"
+// are kept as-is, even though they are not valid shell syntax.
+func GitHubActions(src []byte) []Snippet {
+	lines := splitLines(src)
+	var snippets []Snippet
+	var b builder
+	for i := 0; i < len(lines); i++ {
+		m := githubRun.FindStringSubmatchIndex(lines[i])
+		if m == nil {
+			continue
+		}
+		line := lines[i]
+		keyIndent := m[3] // the column where "run" starts
+		if m[4] < 0 {
+			continue // an empty value
+		}
+		value := line[m[4]:m[5]]
+		if j := strings.Index(value, " #"); j >= 0 {
+			value = strings.TrimRight(value[:j], " \t")
+		}
+		if !strings.HasPrefix(value, "|") {
+			if !plainScalar(value) {
+				continue
+			}
+			b.add(value+"\n", uint(i+1), uint(m[4]+1))
+			s := b.snippet("bash")
+			s.host = &hostRange{
+				start: i,
+				end:   i + 1,
+				first: line[:m[4]],
+				last:  line[m[4]+len(value):],
+				plain: true,
+			}
+			snippets = append(snippets, s)
+			continue
+		}
+		if strings.Trim(value[1:], "-+0123456789") != "" {
+			continue // not a block scalar header
+		}
+		// The block's indentation is set by its first non-empty line,
+		// which must be more indented than the key.
+		contentIndent := -1
+		last := i
+		for j := i + 1; j < len(lines); j++ {
+			body := lines[j]
+			if strings.TrimSpace(body) == "" {
+				continue
+			}
+			indent := len(body) - len(strings.TrimLeft(body, " "))
+			if contentIndent < 0 {
+				if indent <= keyIndent {
+					break
+				}
+				contentIndent = indent
+			}
+			if indent < contentIndent {
+				break
+			}
+			last = j
+		}
+		for j := i + 1; j <= last; j++ {
+			body := lines[j]
+			start := min(contentIndent, len(body))
+			b.add(body[start:]+"\n", uint(j+1), uint(start+1))
+		}
+		if !b.empty() {
+			pad := strings.Repeat(" ", contentIndent)
+			s := b.snippet("bash")
+			s.host = &hostRange{start: i + 1, end: last + 1, first: pad, indent: pad}
+			snippets = append(snippets, s)
+		}
+		i = last
+	}
+	return snippets
+}
+
+// plainScalar reports whether a single-line value can be a plain scalar in
+// YAML, meaning that it does not need quoting.
+func plainScalar(value string) bool {
+	switch value[0] {
+	case '>', '|', '"', '\'', '&', '*', '!', '{', '[', '#', '%', '@', '`':
+		return false
+	}
+	return !strings.Contains(value, ": ") && !strings.Contains(value, " #") &&
+		!strings.HasSuffix(value, ":")
+}
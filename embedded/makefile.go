@@ -0,0 +1,127 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package embedded
+
+import "strings"
+
+// Makefile returns the shell snippets in the recipes of a Makefile's rules,
+// one per rule, where each line of the recipe is a command. Note that make
+// runs each recipe line in a separate shell, unless .ONESHELL is used.
+//
+// The prefixes "@", "-", and "+" which make uses to alter how a command is run
+// are left out, and each "$$" is unescaped as "$". Other references to make
+// variables like "$(CC)" are kept as-is, as they are valid shell syntax.
+// Lines continued with a trailing backslash are kept as such, as they mean
+// the same in shell.
+//
+// Recipes inside "define" blocks are not supported.
+func Makefile(src []byte) []Snippet {
+	lines := splitLines(src)
+	var snippets []Snippet
+	var b builder
+	flush := func() {
+		if !b.empty() {
+			snippets = append(snippets, b.snippet("sh"))
+		}
+	}
+	inRule, inDefine := false, false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " \t")
+		switch {
+		case inDefine:
+			if strings.HasPrefix(trimmed, "endef") {
+				inDefine = false
+			}
+			continue
+		case inRule && strings.HasPrefix(line, "\t"):
+			i = addRecipeLine(&b, lines, i, 1)
+			continue
+		case trimmed == "", trimmed[0] == '#':
+			continue // blank lines and comments don't end a rule
+		}
+		flush()
+		inRule = false
+		if strings.HasPrefix(trimmed, "define") {
+			inDefine = true
+			continue
+		}
+		colon := ruleColon(line)
+		if colon < 0 {
+			i = skipContinued(lines, i)
+			continue
+		}
+		inRule = true
+		// A recipe may start on the rule line after a semicolon.
+		if semi := strings.IndexByte(line[colon:], ';'); semi >= 0 {
+			i = addRecipeLine(&b, lines, i, colon+semi+1)
+		}
+	}
+	flush()
+	return snippets
+}
+
+// ruleColon returns the index of the colon separating a rule's targets from
+// its prerequisites, or -1 if the line is not a rule, such as when it is a
+// variable assignment like "A := b".
+func ruleColon(line string) int {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '=':
+			return -1
+		case ':':
+			if i+1 < len(line) && line[i+1] == '=' {
+				return -1
+			}
+			if strings.HasPrefix(line[i:], "::=") {
+				return -1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// addRecipeLine adds the command in a recipe line starting at byte offset
+// start of lines[i], as well as the lines continuing it, returning the index
+// of the last line added.
+func addRecipeLine(b *builder, lines []string, i, start int) int {
+	line := lines[i]
+	// Leave out the whitespace and prefixes before the command.
+	for start < len(line) && strings.IndexByte(" \t@-+", line[start]) >= 0 {
+		start++
+	}
+	for {
+		addUnescaped(b, line[start:], uint(i+1), uint(start+1))
+		if !strings.HasSuffix(line, `\`) || i+1 >= len(lines) {
+			break
+		}
+		b.add("\n", uint(i+1), uint(len(line)+1))
+		i++
+		line = lines[i]
+		// A recipe prefix tab in a continued line is removed.
+		start = 0
+		if strings.HasPrefix(line, "\t") {
+			start = 1
+		}
+	}
+	b.add("\n", uint(i+1), uint(len(line)+1))
+	return i
+}
+
+// addUnescaped adds text from a recipe, unescaping each "$$" as "$".
+func addUnescaped(b *builder, text string, line, col uint) {
+	for {
+		i := strings.Index(text, "$$")
+		if i < 0 {
+			break
+		}
+		b.add(text[:i+1], line, col)
+		text = text[i+2:]
+		col += uint(i + 2)
+	}
+	if text != "" {
+		b.add(text, line, col)
+	}
+}
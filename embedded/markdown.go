@@ -0,0 +1,77 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package embedded
+
+import (
+	"regexp"
+	"strings"
+)
+
+var markdownFence = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^ \t`]*)")
+
+// markdownLangs maps the info strings of fenced code blocks to languages.
+var markdownLangs = map[string]string{
+	"sh":    "sh",
+	"shell": "sh",
+	"posix": "sh",
+	"bash":  "bash",
+	"mksh":  "mksh",
+	"ksh":   "ksh",
+	"zsh":   "zsh",
+	"bats":  "bats",
+}
+
+// Markdown returns the shell snippets in the fenced code blocks of a Markdown
+// document whose info string names a shell language, like "```sh" or
+// "~~~bash". Blocks for other languages like "console", which mix commands
+// with their output, are skipped.
+func Markdown(src []byte) []Snippet {
+	lines := splitLines(src)
+	var snippets []Snippet
+	var b builder
+	for i := 0; i < len(lines); i++ {
+		m := markdownFence.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+		indent, fence := len(m[1]), m[2]
+		lang := markdownLangs[strings.ToLower(m[3])]
+		closed := false
+		pad := strings.Repeat(" ", indent)
+		host := &hostRange{start: i + 1, first: pad, indent: pad}
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if isClosingFence(line, fence) {
+				closed = true
+				break
+			}
+			// Up to as many spaces as the opening fence's indentation
+			// are removed from each line.
+			start := 0
+			for start < indent && start < len(line) && line[start] == ' ' {
+				start++
+			}
+			b.add(line[start:]+"\n", uint(i+1), uint(start+1))
+		}
+		if lang != "" && closed {
+			host.end = i
+			s := b.snippet(lang)
+			s.host = host
+			snippets = append(snippets, s)
+		}
+		b = builder{}
+	}
+	return snippets
+}
+
+// isClosingFence reports whether a line closes a fenced code block opened
+// with fence, using at least as many of the same characters.
+func isClosingFence(line, fence string) bool {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return false
+	}
+	n := len(trimmed) - len(strings.TrimLeft(trimmed, fence[:1]))
+	return n >= len(fence) && strings.TrimSpace(trimmed[n:]) == ""
+}
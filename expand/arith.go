@@ -5,67 +5,109 @@ package expand
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
 	"mvdan.cc/sh/v3/syntax"
 )
 
+// Arithm expands an arithmetic expression, like Bash does with 64-bit signed
+// integers which wrap around on overflow, unless [Config.CheckedArithm] is set.
+//
+// Integer constants may be decimal, octal with a leading "0", hexadecimal with
+// a leading "0x", or use any base from 2 to 64 in the form "base#value".
+// Variables whose values are not integer constants are evaluated as arithmetic
+// expressions themselves, so that with x="1+2", "x*2" expands to 6.
 func Arithm(cfg *Config, expr syntax.ArithmExpr) (int, error) {
+	a := &arithm[int64]{
+		ops: int64Ops{checked: cfg.CheckedArithm},
+		cfg: cfg,
+		get: cfg.envGet,
+		set: cfg.envSet,
+	}
+	n, err := a.eval(expr)
+	return int(n), err
+}
+
+// arithmOps implements the integer operations used by [arithm],
+// either on 64-bit integers via [int64Ops] or on integers of arbitrary
+// precision via [bigOps].
+type arithmOps[T any] interface {
+	fromInt(n int64) T
+	isZero(x T) bool
+	format(x T) string
+	// constant parses an integer constant, as checked by [isArithmConst].
+	constant(s string) (T, error)
+	bitNot(x T) T
+	binary(op syntax.BinAritOperator, x, y T) (T, error)
+}
+
+// arithm evaluates arithmetic expressions with integers of type T.
+// It backs both [Arithm] and [ArithmEvaluator].
+type arithm[T any] struct {
+	ops arithmOps[T]
+
+	// cfg is used to expand words in the expression, like "$x",
+	// and to track how deeply variable values are evaluated.
+	cfg *Config
+
+	get func(name string) string
+	set func(name, value string) error
+}
+
+func (a *arithm[T]) eval(expr syntax.ArithmExpr) (T, error) {
+	var zero T
 	switch expr := expr.(type) {
 	case *syntax.Word:
-		str, err := Literal(cfg, expr)
+		str, err := Literal(a.cfg, expr)
 		if err != nil {
-			return 0, err
+			return zero, err
 		}
-		// recursively fetch vars
-		i := 0
-		for syntax.ValidName(str) {
-			val := cfg.envGet(str)
-			if val == "" {
-				break
-			}
-			if i++; i >= maxNameRefDepth {
-				break
-			}
-			str = val
+		if syntax.ValidName(str) {
+			a.cfg.observe(UseVar, str, expr)
 		}
-		// default to 0
-		return atoi(str), nil
+		return a.value(str)
 	case *syntax.ParenArithm:
-		return Arithm(cfg, expr.X)
+		return a.eval(expr.X)
 	case *syntax.UnaryArithm:
 		switch expr.Op {
 		case syntax.Inc, syntax.Dec:
 			name := expr.X.(*syntax.Word).Lit()
-			old := atoi(cfg.envGet(name))
-			val := old
-			if expr.Op == syntax.Inc {
-				val++
-			} else {
-				val--
+			a.cfg.observe(UseVar, name, expr.X)
+			old, err := a.value(a.get(name))
+			if err != nil {
+				return zero, err
+			}
+			op := syntax.Add
+			if expr.Op == syntax.Dec {
+				op = syntax.Sub
 			}
-			if err := cfg.envSet(name, strconv.Itoa(val)); err != nil {
-				return 0, err
+			val, err := a.ops.binary(op, old, a.ops.fromInt(1))
+			if err != nil {
+				return zero, err
+			}
+			if err := a.set(name, a.ops.format(val)); err != nil {
+				return zero, err
 			}
 			if expr.Post {
 				return old, nil
 			}
 			return val, nil
 		}
-		val, err := Arithm(cfg, expr.X)
+		val, err := a.eval(expr.X)
 		if err != nil {
-			return 0, err
+			return zero, err
 		}
 		switch expr.Op {
 		case syntax.Not:
-			return oneIf(val == 0), nil
+			return a.oneIf(a.ops.isZero(val)), nil
 		case syntax.BitNegation:
-			return ^val, nil
+			return a.ops.bitNot(val), nil
 		case syntax.Plus:
 			return val, nil
 		default: // syntax.Minus
-			return -val, nil
+			return a.ops.binary(syntax.Sub, a.ops.fromInt(0), val)
 		}
 	case *syntax.BinaryArithm:
 		switch expr.Op {
@@ -73,32 +115,50 @@ func Arithm(cfg *Config, expr syntax.ArithmExpr) (int, error) {
 			syntax.MulAssgn, syntax.QuoAssgn, syntax.RemAssgn,
 			syntax.AndAssgn, syntax.OrAssgn, syntax.XorAssgn,
 			syntax.ShlAssgn, syntax.ShrAssgn:
-			return cfg.assgnArit(expr)
+			return a.assign(expr)
 		case syntax.TernQuest: // TernColon can't happen here
-			cond, err := Arithm(cfg, expr.X)
+			cond, err := a.eval(expr.X)
 			if err != nil {
-				return 0, err
+				return zero, err
 			}
 			b2 := expr.Y.(*syntax.BinaryArithm) // must have Op==TernColon
-			if cond == 1 {
-				return Arithm(cfg, b2.X)
+			if !a.ops.isZero(cond) {
+				return a.eval(b2.X)
+			}
+			return a.eval(b2.Y)
+		case syntax.AndArit, syntax.OrArit:
+			// The right side is only evaluated if needed.
+			left, err := a.eval(expr.X)
+			if err != nil {
+				return zero, err
+			}
+			if !a.ops.isZero(left) == (expr.Op == syntax.OrArit) {
+				return a.oneIf(!a.ops.isZero(left)), nil
 			}
-			return Arithm(cfg, b2.Y)
+			right, err := a.eval(expr.Y)
+			if err != nil {
+				return zero, err
+			}
+			return a.oneIf(!a.ops.isZero(right)), nil
 		}
-		left, err := Arithm(cfg, expr.X)
+		left, err := a.eval(expr.X)
 		if err != nil {
-			return 0, err
+			return zero, err
 		}
-		right, err := Arithm(cfg, expr.Y)
+		right, err := a.eval(expr.Y)
 		if err != nil {
-			return 0, err
+			return zero, err
 		}
-		return binArit(expr.Op, left, right)
+		return a.ops.binary(expr.Op, left, right)
 	default:
 		panic(fmt.Sprintf("unexpected arithm expr: %T", expr))
 	}
 }
 
+func (a *arithm[T]) oneIf(b bool) T {
+	return a.ops.fromInt(int64(oneIf(b)))
+}
+
 func oneIf(b bool) int {
 	if b {
 		return 1
@@ -113,93 +173,249 @@ func atoi(s string) int {
 	return n
 }
 
-func (cfg *Config) assgnArit(b *syntax.BinaryArithm) (int, error) {
+// value evaluates a string in an arithmetic expression, which may be
+// empty, an integer constant, a variable name, or an expression.
+func (a *arithm[T]) value(str string) (T, error) {
+	var zero T
+	str = strings.TrimSpace(str)
+	switch {
+	case str == "":
+		return a.ops.fromInt(0), nil
+	case syntax.ValidName(str):
+		// recursively fetch vars
+		for i := 0; ; i++ {
+			val := strings.TrimSpace(a.get(str))
+			if val == "" || i >= maxNameRefDepth {
+				return a.ops.fromInt(0), nil
+			}
+			if !syntax.ValidName(val) {
+				return a.value(val)
+			}
+			str = val
+		}
+	case isArithmConst(str):
+		return a.ops.constant(str)
+	}
+	if a.cfg.arithmDepth >= maxArithmDepth {
+		return zero, fmt.Errorf("%s: expression recursion level exceeded", str)
+	}
+	expr, err := syntax.NewParser().Arithmetic(strings.NewReader(str))
+	if err != nil {
+		return zero, err
+	}
+	if expr == nil {
+		return a.ops.fromInt(0), nil
+	}
+	if w, ok := expr.(*syntax.Word); ok && w.Lit() == str {
+		// Not a name nor an integer constant, such as "@".
+		return zero, fmt.Errorf("%s: syntax error: operand expected (error token is %q)", str, str)
+	}
+	a.cfg.arithmDepth++
+	defer func() { a.cfg.arithmDepth-- }()
+	return a.eval(expr)
+}
+
+// maxArithmDepth limits how deeply the values of variables can be evaluated
+// as arithmetic expressions, such as with x="x+1".
+const maxArithmDepth = 1024
+
+// isArithmConst reports whether s looks like an integer constant,
+// which may not be valid, such as "08" or "2#3".
+func isArithmConst(s string) bool {
+	if s[0] < '0' || s[0] > '9' {
+		return false
+	}
+	for _, c := range s {
+		if arithmDigit(c, 64) < 0 && c != '#' {
+			return false
+		}
+	}
+	return true
+}
+
+// arithmDigit returns the value of a digit in a base from 2 to 64,
+// where letters are case insensitive up to base 36, or -1 if c is not a digit.
+func arithmDigit(c rune, base int) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'z':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'Z' && base <= 36:
+		return int(c-'A') + 10
+	case c >= 'A' && c <= 'Z':
+		return int(c-'A') + 36
+	case c == '@':
+		return 62
+	case c == '_':
+		return 63
+	}
+	return -1
+}
+
+// arithmConstDigits splits an integer constant like Bash, such as "12", "012",
+// "0x1f", or "36#zz", into its base and its digits, calling digit for each.
+func arithmConstDigits(s string, digit func(base, d int)) error {
+	base, digits := 10, s
+	if b, rest, ok := strings.Cut(s, "#"); ok {
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 2 || n > 64 {
+			return fmt.Errorf("%s: invalid arithmetic base (error token is %q)", s, s)
+		}
+		if rest == "" {
+			return fmt.Errorf("%s: invalid integer constant (error token is %q)", s, s)
+		}
+		base, digits = n, rest
+	} else if len(s) > 1 && s[0] == '0' {
+		if s[1] == 'x' || s[1] == 'X' {
+			base, digits = 16, s[2:]
+		} else {
+			base, digits = 8, s[1:]
+		}
+	}
+	for _, c := range digits {
+		d := arithmDigit(c, base)
+		if d < 0 || d >= base {
+			return fmt.Errorf("%s: value too great for base (error token is %q)", s, s)
+		}
+		digit(base, d)
+	}
+	return nil
+}
+
+func (a *arithm[T]) assign(b *syntax.BinaryArithm) (T, error) {
+	var zero T
 	name := b.X.(*syntax.Word).Lit()
-	val := atoi(cfg.envGet(name))
-	arg, err := Arithm(cfg, b.Y)
+	val, err := a.eval(b.Y)
 	if err != nil {
-		return 0, err
+		return zero, err
 	}
-	switch b.Op {
-	case syntax.Assgn:
-		val = arg
-	case syntax.AddAssgn:
-		val += arg
-	case syntax.SubAssgn:
-		val -= arg
-	case syntax.MulAssgn:
-		val *= arg
-	case syntax.QuoAssgn:
-		if arg == 0 {
-			return 0, fmt.Errorf("division by zero")
+	if b.Op != syntax.Assgn {
+		a.cfg.observe(UseVar, name, b.X)
+		old, err := a.value(a.get(name))
+		if err != nil {
+			return zero, err
 		}
-		val /= arg
-	case syntax.RemAssgn:
-		if arg == 0 {
-			return 0, fmt.Errorf("division by zero")
+		op := map[syntax.BinAritOperator]syntax.BinAritOperator{
+			syntax.AddAssgn: syntax.Add,
+			syntax.SubAssgn: syntax.Sub,
+			syntax.MulAssgn: syntax.Mul,
+			syntax.QuoAssgn: syntax.Quo,
+			syntax.RemAssgn: syntax.Rem,
+			syntax.AndAssgn: syntax.And,
+			syntax.OrAssgn:  syntax.Or,
+			syntax.XorAssgn: syntax.Xor,
+			syntax.ShlAssgn: syntax.Shl,
+			syntax.ShrAssgn: syntax.Shr,
+		}[b.Op]
+		if val, err = a.ops.binary(op, old, val); err != nil {
+			return zero, err
 		}
-		val %= arg
-	case syntax.AndAssgn:
-		val &= arg
-	case syntax.OrAssgn:
-		val |= arg
-	case syntax.XorAssgn:
-		val ^= arg
-	case syntax.ShlAssgn:
-		val <<= uint(arg)
-	case syntax.ShrAssgn:
-		val >>= uint(arg)
-	}
-	if err := cfg.envSet(name, strconv.Itoa(val)); err != nil {
-		return 0, err
+	}
+	if err := a.set(name, a.ops.format(val)); err != nil {
+		return zero, err
 	}
 	return val, nil
 }
 
-func intPow(a, b int) int {
-	p := 1
+// int64Ops implements [arithmOps] with 64-bit signed integers, which wrap
+// around on overflow like in Bash unless checked is set.
+type int64Ops struct {
+	checked bool
+}
+
+func (int64Ops) fromInt(n int64) int64 { return n }
+func (int64Ops) isZero(x int64) bool   { return x == 0 }
+func (int64Ops) format(x int64) string { return strconv.FormatInt(x, 10) }
+func (int64Ops) bitNot(x int64) int64  { return ^x }
+
+func (o int64Ops) constant(s string) (int64, error) {
+	var n int64
+	overflow := false
+	err := arithmConstDigits(s, func(base, d int) {
+		if n > (math.MaxInt64-int64(d))/int64(base) {
+			overflow = true
+		}
+		n = n*int64(base) + int64(d)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, o.checkOverflow(overflow)
+}
+
+// checkOverflow returns an error if an operation overflowed
+// and checked arithmetic is enabled.
+func (o int64Ops) checkOverflow(overflow bool) error {
+	if overflow && o.checked {
+		return fmt.Errorf("integer overflow")
+	}
+	return nil
+}
+
+func (o int64Ops) pow(a, b int64) (int64, error) {
+	if b < 0 {
+		return 0, fmt.Errorf("exponent less than 0")
+	}
+	p := int64(1)
+	overflow := false
 	for b > 0 {
 		if b&1 != 0 {
+			overflow = overflow || mulOverflows(p, a)
 			p *= a
 		}
 		b >>= 1
-		a *= a
+		if b > 0 {
+			overflow = overflow || mulOverflows(a, a)
+			a *= a
+		}
+	}
+	return p, o.checkOverflow(overflow)
+}
+
+func mulOverflows(x, y int64) bool {
+	if x == 0 || y == 0 {
+		return false
 	}
-	return p
+	r := x * y
+	return r/y != x || (x == -1 && y == math.MinInt64) || (y == -1 && x == math.MinInt64)
 }
 
-func binArit(op syntax.BinAritOperator, x, y int) (int, error) {
+func (o int64Ops) binary(op syntax.BinAritOperator, x, y int64) (int64, error) {
 	switch op {
 	case syntax.Add:
-		return x + y, nil
+		r := x + y
+		return r, o.checkOverflow((x > 0 && y > 0 && r < 0) || (x < 0 && y < 0 && r >= 0))
 	case syntax.Sub:
-		return x - y, nil
+		r := x - y
+		return r, o.checkOverflow((x >= 0 && y < 0 && r < 0) || (x < 0 && y > 0 && r >= 0))
 	case syntax.Mul:
-		return x * y, nil
+		return x * y, o.checkOverflow(mulOverflows(x, y))
 	case syntax.Quo:
 		if y == 0 {
 			return 0, fmt.Errorf("division by zero")
 		}
-		return x / y, nil
+		// Like in Bash, the smallest integer divided by -1 wraps around.
+		return x / y, o.checkOverflow(x == math.MinInt64 && y == -1)
 	case syntax.Rem:
 		if y == 0 {
 			return 0, fmt.Errorf("division by zero")
 		}
 		return x % y, nil
 	case syntax.Pow:
-		return intPow(x, y), nil
+		return o.pow(x, y)
 	case syntax.Eql:
-		return oneIf(x == y), nil
+		return int64(oneIf(x == y)), nil
 	case syntax.Gtr:
-		return oneIf(x > y), nil
+		return int64(oneIf(x > y)), nil
 	case syntax.Lss:
-		return oneIf(x < y), nil
+		return int64(oneIf(x < y)), nil
 	case syntax.Neq:
-		return oneIf(x != y), nil
+		return int64(oneIf(x != y)), nil
 	case syntax.Leq:
-		return oneIf(x <= y), nil
+		return int64(oneIf(x <= y)), nil
 	case syntax.Geq:
-		return oneIf(x >= y), nil
+		return int64(oneIf(x >= y)), nil
 	case syntax.And:
 		return x & y, nil
 	case syntax.Or:
@@ -207,13 +423,12 @@ func binArit(op syntax.BinAritOperator, x, y int) (int, error) {
 	case syntax.Xor:
 		return x ^ y, nil
 	case syntax.Shr:
-		return x >> uint(y), nil
+		// Like Bash on most platforms, only the low six bits of the
+		// shift count are used.
+		return x >> (uint(y) & 63), nil
 	case syntax.Shl:
-		return x << uint(y), nil
-	case syntax.AndArit:
-		return oneIf(x != 0 && y != 0), nil
-	case syntax.OrArit:
-		return oneIf(x != 0 || y != 0), nil
+		r := x << (uint(y) & 63)
+		return r, o.checkOverflow(r>>(uint(y)&63) != x)
 	default: // syntax.Comma
 		// x is executed but its result discarded
 		return y, nil
@@ -0,0 +1,198 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package expand
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// ArithmEvaluator evaluates arithmetic expressions like [Arithm], but without
+// a [Config] or an [Environ]. Variables are resolved and assigned via funcs,
+// which makes it useful for calculators or for evaluating expressions in
+// configuration files.
+//
+// The result may be a 64-bit signed integer via [ArithmEvaluator.Int64],
+// which wraps around on overflow like Bash, or an integer of arbitrary
+// precision via [ArithmEvaluator.Big].
+//
+// The zero value is ready to use, with all variables being unset and
+// assignments failing with an error.
+type ArithmEvaluator struct {
+	// Get returns the value of a variable, which is evaluated as an
+	// arithmetic expression itself if it is not an integer constant.
+	// An empty string, as returned for unset variables, evaluates as zero.
+	// If nil, all variables are unset.
+	//
+	// Get is also used by parameter expansions within words in the
+	// expression, such as "$x" or "${#x}".
+	Get func(name string) string
+
+	// Set assigns a value to a variable, such as with "x = 3" or "x++".
+	// The value is always formatted as a decimal integer.
+	// If nil, assignments fail with an error.
+	Set func(name, value string) error
+
+	// Checked makes [ArithmEvaluator.Int64] fail with an error when an
+	// integer overflows, rather than wrapping around like Bash does.
+	// It has no effect on [ArithmEvaluator.Big].
+	Checked bool
+}
+
+// Int64 evaluates an arithmetic expression with 64-bit signed integers.
+func (e *ArithmEvaluator) Int64(expr syntax.ArithmExpr) (int64, error) {
+	return newArithm[int64](e, int64Ops{checked: e.Checked}).eval(expr)
+}
+
+// Big evaluates an arithmetic expression with integers of arbitrary precision,
+// so that no operation overflows.
+// Since there is no integer width, shift counts are not truncated,
+// and negative shift counts are an error.
+// Exponentiations and left shifts resulting in more than a million bits
+// are an error as well, as they could exhaust time and memory.
+func (e *ArithmEvaluator) Big(expr syntax.ArithmExpr) (*big.Int, error) {
+	return newArithm[*big.Int](e, bigOps{}).eval(expr)
+}
+
+func newArithm[T any](e *ArithmEvaluator, ops arithmOps[T]) *arithm[T] {
+	return &arithm[T]{
+		ops: ops,
+		cfg: &Config{Env: FuncEnviron(e.get)},
+		get: e.get,
+		set: e.set,
+	}
+}
+
+func (e *ArithmEvaluator) get(name string) string {
+	if e.Get == nil {
+		return ""
+	}
+	return e.Get(name)
+}
+
+func (e *ArithmEvaluator) set(name, value string) error {
+	if e.Set == nil {
+		return fmt.Errorf("%s: variables are read-only", name)
+	}
+	return e.Set(name, value)
+}
+
+// maxBigBits is the maximum size in bits of the results of exponentiations
+// and left shifts in [ArithmEvaluator.Big], so that expressions such as
+// "2 ** 99999999999" fail quickly.
+const maxBigBits = 1 << 20
+
+// bigOps implements [arithmOps] with integers of arbitrary precision.
+// Its operations never modify their arguments.
+type bigOps struct{}
+
+func (bigOps) fromInt(n int64) *big.Int   { return big.NewInt(n) }
+func (bigOps) isZero(x *big.Int) bool     { return x.Sign() == 0 }
+func (bigOps) format(x *big.Int) string   { return x.String() }
+func (bigOps) bitNot(x *big.Int) *big.Int { return new(big.Int).Not(x) }
+
+func (bigOps) constant(s string) (*big.Int, error) {
+	n := new(big.Int)
+	d := new(big.Int)
+	err := arithmConstDigits(s, func(base, digit int) {
+		n.Mul(n, d.SetInt64(int64(base)))
+		n.Add(n, d.SetInt64(int64(digit)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// shiftCount returns the number of bits to shift x by. Since there is no
+// integer width, the count must not be negative, and a left shift must not
+// result in more than maxBigBits bits.
+func (bigOps) shiftCount(op syntax.BinAritOperator, x, y *big.Int) (uint, error) {
+	if y.Sign() < 0 || !y.IsInt64() || y.Int64() > math.MaxInt32 {
+		return 0, fmt.Errorf("%s: invalid shift count", y)
+	}
+	if op == syntax.Shl && x.Sign() != 0 && int64(x.BitLen())+y.Int64() > maxBigBits {
+		return 0, fmt.Errorf("%s: shift count too large", y)
+	}
+	return uint(y.Int64()), nil
+}
+
+func (bigOps) pow(x, y *big.Int) (*big.Int, error) {
+	if y.Sign() < 0 {
+		return nil, fmt.Errorf("exponent less than 0")
+	}
+	// The result has at least (x.BitLen()-1)*y+1 bits, unless x is
+	// -1, 0, or 1, where the result is one of those too.
+	if n := int64(x.BitLen() - 1); n > 0 && (!y.IsInt64() || y.Int64() > maxBigBits/n) {
+		return nil, fmt.Errorf("%s: exponent too large", y)
+	}
+	if x.CmpAbs(big.NewInt(1)) <= 0 {
+		// Avoid looping over the bits of a huge exponent.
+		r := new(big.Int).Set(x)
+		if y.Sign() == 0 {
+			r.SetInt64(1)
+		} else if x.Sign() < 0 && y.Bit(0) == 0 {
+			r.Neg(r)
+		}
+		return r, nil
+	}
+	return new(big.Int).Exp(x, y, nil), nil
+}
+
+func (o bigOps) binary(op syntax.BinAritOperator, x, y *big.Int) (*big.Int, error) {
+	r := new(big.Int)
+	switch op {
+	case syntax.Add:
+		return r.Add(x, y), nil
+	case syntax.Sub:
+		return r.Sub(x, y), nil
+	case syntax.Mul:
+		return r.Mul(x, y), nil
+	case syntax.Quo:
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return r.Quo(x, y), nil
+	case syntax.Rem:
+		if y.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return r.Rem(x, y), nil
+	case syntax.Pow:
+		return o.pow(x, y)
+	case syntax.Eql:
+		return o.fromInt(int64(oneIf(x.Cmp(y) == 0))), nil
+	case syntax.Gtr:
+		return o.fromInt(int64(oneIf(x.Cmp(y) > 0))), nil
+	case syntax.Lss:
+		return o.fromInt(int64(oneIf(x.Cmp(y) < 0))), nil
+	case syntax.Neq:
+		return o.fromInt(int64(oneIf(x.Cmp(y) != 0))), nil
+	case syntax.Leq:
+		return o.fromInt(int64(oneIf(x.Cmp(y) <= 0))), nil
+	case syntax.Geq:
+		return o.fromInt(int64(oneIf(x.Cmp(y) >= 0))), nil
+	case syntax.And:
+		return r.And(x, y), nil
+	case syntax.Or:
+		return r.Or(x, y), nil
+	case syntax.Xor:
+		return r.Xor(x, y), nil
+	case syntax.Shr, syntax.Shl:
+		n, err := o.shiftCount(op, x, y)
+		if err != nil {
+			return nil, err
+		}
+		if op == syntax.Shr {
+			return r.Rsh(x, n), nil
+		}
+		return r.Lsh(x, n), nil
+	default: // syntax.Comma
+		// x is executed but its result discarded
+		return y, nil
+	}
+}
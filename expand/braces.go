@@ -4,6 +4,7 @@
 package expand
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 
@@ -25,45 +26,23 @@ func Braces(word *syntax.Word) []*syntax.Word {
 			continue
 		}
 		if br.Sequence {
-			chars := false
-
-			fromLit := br.Elems[0].Lit()
-			toLit := br.Elems[1].Lit()
-			zeros := max(extraLeadingZeros(fromLit), extraLeadingZeros(toLit))
-
-			from, err1 := strconv.Atoi(fromLit)
-			to, err2 := strconv.Atoi(toLit)
-			if err1 != nil || err2 != nil {
-				chars = true
-				from = int(br.Elems[0].Lit()[0])
-				to = int(br.Elems[1].Lit()[0])
-			}
-			upward := from <= to
-			incr := 1
-			if !upward {
-				incr = -1
-			}
-			if len(br.Elems) > 2 {
-				n, _ := strconv.Atoi(br.Elems[2].Lit())
-				if n != 0 && n > 0 == upward {
-					incr = n
-				}
-			}
-			n := from
+			seq := braceSequence(br)
+			upward := seq.from <= seq.to
+			n := seq.from
 			for {
-				if upward && n > to {
+				if upward && n > seq.to {
 					break
 				}
-				if !upward && n < to {
+				if !upward && n < seq.to {
 					break
 				}
 				next := *word
 				next.Parts = next.Parts[i+1:]
-				lit := &syntax.Lit{}
-				if chars {
+				lit := &syntax.Lit{ValuePos: br.Pos(), ValueEnd: br.End()}
+				if seq.chars {
 					lit.Value = string(rune(n))
 				} else {
-					lit.Value = strings.Repeat("0", zeros) + strconv.Itoa(n)
+					lit.Value = fmt.Sprintf("%0*d", seq.width, n)
 				}
 				next.Parts = append([]syntax.WordPart{lit}, next.Parts...)
 				exp := Braces(&next)
@@ -71,7 +50,7 @@ func Braces(word *syntax.Word) []*syntax.Word {
 					w.Parts = append(left, w.Parts...)
 				}
 				all = append(all, exp...)
-				n += incr
+				n += seq.incr
 			}
 			return all
 		}
@@ -90,11 +69,109 @@ func Braces(word *syntax.Word) []*syntax.Word {
 	return []*syntax.Word{{Parts: left}}
 }
 
-func extraLeadingZeros(s string) int {
-	for i, r := range s {
-		if r != '0' {
-			return i
+// braceSeq describes a sequence brace expansion like "{1..10..2}".
+type braceSeq struct {
+	from, to, incr int
+	width          int  // for zero padding, if positive
+	chars          bool // whether the ends are characters like "{a..z}"
+}
+
+func braceSequence(br *syntax.BraceExp) braceSeq {
+	var seq braceSeq
+	fromLit := br.Elems[0].Lit()
+	toLit := br.Elems[1].Lit()
+	// Like Bash, if either end has a leading zero,
+	// all numbers are padded with zeros to the same width.
+	if hasLeadingZero(fromLit) || hasLeadingZero(toLit) {
+		seq.width = max(len(fromLit), len(toLit))
+	}
+
+	from, err1 := strconv.Atoi(fromLit)
+	to, err2 := strconv.Atoi(toLit)
+	if err1 != nil || err2 != nil {
+		seq.chars = true
+		from = int(fromLit[0])
+		to = int(toLit[0])
+	}
+	seq.from, seq.to = from, to
+	seq.incr = 1
+	if from > to {
+		seq.incr = -1
+	}
+	if len(br.Elems) > 2 {
+		// The sign of the increment is ignored, as the direction
+		// is given by the two ends of the sequence.
+		n, _ := strconv.Atoi(br.Elems[2].Lit())
+		if n < 0 {
+			n = -n
+		}
+		if n != 0 {
+			seq.incr = n * seq.incr
 		}
 	}
-	return 0 // "0" has no extra leading zeros
+	return seq
+}
+
+// braceCount returns the number of words which [Braces] would produce from
+// a word's parts, without building them. Counts larger than limit may be
+// reported as limit+1, so that huge expansions do not overflow.
+func braceCount(parts []syntax.WordPart, limit int) int {
+	count := 1
+	for _, wp := range parts {
+		br, ok := wp.(*syntax.BraceExp)
+		if !ok {
+			continue
+		}
+		n := 0
+		if br.Sequence {
+			seq := braceSequence(br)
+			// The distance between the ends may not fit in an int.
+			span, step := uint64(seq.to)-uint64(seq.from), uint64(seq.incr)
+			if seq.incr < 0 {
+				span, step = -span, -step
+			}
+			if span/step >= uint64(limit) {
+				return limit + 1
+			}
+			n = int(span/step) + 1
+		} else {
+			for _, elem := range br.Elems {
+				n += braceCount(elem.Parts, limit)
+				if n > limit {
+					break
+				}
+			}
+		}
+		if n > limit || count > limit/n {
+			return limit + 1
+		}
+		count *= n
+	}
+	return count
+}
+
+// BracesString is like [Braces], but it performs brace expansion on a string,
+// returning the resulting strings. For example, "img{1..3}.{png,jpg}" results in
+// "img1.png", "img1.jpg", "img2.png", and so on. If there is no brace
+// expansion, the string is returned as the only element.
+//
+// Every character is literal, so unlike in a shell program, quotes and
+// backslashes are not special and cannot be used to escape braces.
+func BracesString(s string) []string {
+	word := &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: s}}}
+	if !syntax.SplitBraces(word) {
+		return []string{s}
+	}
+	words := Braces(word)
+	strs := make([]string, len(words))
+	for i, word := range words {
+		strs[i] = word.Lit()
+	}
+	return strs
+}
+
+// hasLeadingZero reports whether a number like "05" or "-05" has a leading zero.
+func hasLeadingZero(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	return len(s) > 1 && s[0] == '0'
 }
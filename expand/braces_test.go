@@ -5,6 +5,7 @@ package expand
 
 import (
 	"bytes"
+	"slices"
 	"testing"
 
 	"mvdan.cc/sh/v3/syntax"
@@ -161,6 +162,44 @@ var braceTests = []struct {
 		litWord("{1..1}"),
 		litWords("1"),
 	},
+	{
+		litWord("a{1..7..-3}"),
+		litWords("a1", "a4", "a7"),
+	},
+	{
+		litWord("a{7..1..3}"),
+		litWords("a7", "a4", "a1"),
+	},
+	{
+		litWord("{01..10..3}"),
+		litWords("01", "04", "07", "10"),
+	},
+	{
+		litWord("{-05..5..5}"),
+		litWords("-05", "000", "005"),
+	},
+}
+
+func TestBracesString(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", []string{""}},
+		{"foo", []string{"foo"}},
+		{"a{b", []string{"a{b"}},
+		{"img{1..3}.{png,jpg}", []string{"img1.png", "img1.jpg", "img2.png", "img2.jpg", "img3.png", "img3.jpg"}},
+		{"{1..10..2}", []string{"1", "3", "5", "7", "9"}},
+		{"{08..10}", []string{"08", "09", "10"}},
+		{`'{a,b}' \{c,d}`, []string{`'a' \c`, `'a' \d`, `'b' \c`, `'b' \d`}},
+	}
+	for _, tc := range tests {
+		got := BracesString(tc.in)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("BracesString(%q) got %q, want %q", tc.in, got, tc.want)
+		}
+	}
 }
 
 func TestBraces(t *testing.T) {
@@ -8,6 +8,8 @@ import (
 	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // Environ is the base interface for a shell's environment, allowing it to fetch
@@ -71,11 +73,25 @@ type Variable struct {
 	Exported bool
 	ReadOnly bool
 
+	// Integer, Lowercase, and Uppercase change how new values are assigned,
+	// like the attributes set via "declare -i", "declare -l", and "declare -u".
+	// Interpreters should evaluate the values of integer variables as
+	// arithmetic expressions, and convert the case of the others.
+	Integer   bool
+	Lowercase bool
+	Uppercase bool
+
 	Kind ValueKind
 
 	Str  string            // Used when Kind is String or NameRef.
 	List []string          // Used when Kind is Indexed.
 	Map  map[string]string // Used when Kind is Associative.
+
+	// Holes marks the elements of List which are not set, for sparse
+	// indexed arrays such as the one left by "a[5]=x" or "unset 'a[1]'".
+	// Such elements are empty strings. Holes may be shorter than List,
+	// in which case the rest of the elements are set.
+	Holes []bool
 }
 
 // IsSet returns whether the variable is set. An empty variable is set, but an
@@ -100,6 +116,38 @@ func (v Variable) String() string {
 	return ""
 }
 
+// IndexSet reports whether the element at an index of an indexed array is set.
+func (v Variable) IndexSet(i int) bool {
+	return i >= 0 && i < len(v.List) && !(i < len(v.Holes) && v.Holes[i])
+}
+
+// Indices returns the indexes of the elements of an indexed array which are
+// set, in increasing order, skipping the holes of a sparse array.
+func (v Variable) Indices() []int {
+	indices := make([]int, 0, len(v.List))
+	for i := range v.List {
+		if v.IndexSet(i) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// Elems returns the elements of an indexed array which are set, in order,
+// skipping the holes of a sparse array. The result must not be modified.
+func (v Variable) Elems() []string {
+	if !slices.Contains(v.Holes, true) {
+		return v.List
+	}
+	elems := make([]string, 0, len(v.List))
+	for i, s := range v.List {
+		if v.IndexSet(i) {
+			elems = append(elems, s)
+		}
+	}
+	return elems
+}
+
 // maxNameRefDepth defines the maximum number of times to follow references when
 // resolving a variable. Otherwise, simple name reference loops could crash a
 // program quite easily.
@@ -140,6 +188,93 @@ func (f funcEnviron) Get(name string) Variable {
 
 func (f funcEnviron) Each(func(name string, vr Variable) bool) {}
 
+// LazyEnviron returns an Environ with the variables in names, whose values are
+// resolved by calling fn the first time each variable is needed, such as when
+// fetching secrets from a vault. fn is called at most once per name, and empty
+// strings returned by it will be treated as unset variables. All resolved
+// variables will be exported.
+//
+// Other variables are looked up in base, which may be nil. The variables in
+// names take precedence over those in base, unless fn leaves them unset.
+//
+// Note that the returned Environ's Each method only includes the variables in
+// names which have already been resolved, so that programs run by a shell only
+// get the variables which the shell itself used, such as via an expansion or
+// the export builtin. The returned Environ is safe for concurrent use.
+func LazyEnviron(base Environ, names []string, fn func(name string) string) Environ {
+	l := &lazyEnviron{base: base, vars: make(map[string]*lazyVar, len(names))}
+	for _, name := range names {
+		if _, ok := l.vars[name]; ok {
+			continue
+		}
+		name := name
+		lv := &lazyVar{}
+		lv.get = sync.OnceValue(func() string {
+			value := fn(name)
+			lv.resolved.Store(true)
+			return value
+		})
+		l.vars[name] = lv
+		l.names = append(l.names, name)
+	}
+	return l
+}
+
+type lazyEnviron struct {
+	base  Environ
+	names []string // in the original order, to iterate deterministically
+	vars  map[string]*lazyVar
+}
+
+type lazyVar struct {
+	get      func() string
+	resolved atomic.Bool
+}
+
+func (l *lazyEnviron) Get(name string) Variable {
+	if lv, ok := l.vars[name]; ok {
+		if value := lv.get(); value != "" {
+			return Variable{Exported: true, Kind: String, Str: value}
+		}
+	}
+	if l.base == nil {
+		return Variable{}
+	}
+	return l.base.Get(name)
+}
+
+// overrides reports whether name is a resolved variable which is set,
+// taking precedence over base.
+func (l *lazyEnviron) overrides(name string) bool {
+	lv, ok := l.vars[name]
+	return ok && lv.resolved.Load() && lv.get() != ""
+}
+
+func (l *lazyEnviron) Each(fn func(name string, vr Variable) bool) {
+	if l.base != nil {
+		stopped := false
+		l.base.Each(func(name string, vr Variable) bool {
+			if l.overrides(name) {
+				return true // included below
+			}
+			stopped = !fn(name, vr)
+			return !stopped
+		})
+		if stopped {
+			return
+		}
+	}
+	for _, name := range l.names {
+		if !l.overrides(name) {
+			continue
+		}
+		value := l.vars[name].get()
+		if !fn(name, Variable{Exported: true, Kind: String, Str: value}) {
+			return
+		}
+	}
+}
+
 // ListEnviron returns an Environ with the supplied variables, in the form
 // "key=value". All variables will be exported. The last value in pairs is used
 // if multiple values are present.
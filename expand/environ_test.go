@@ -5,6 +5,7 @@ package expand
 
 import (
 	"reflect"
+	"sync"
 	"testing"
 )
 
@@ -80,3 +81,82 @@ func TestGetWithSameSubPrefix(t *testing.T) {
 		t.Fatalf("ListEnviron.Get(GREETING) wanted text1, got %q", got)
 	}
 }
+
+func TestVariableHoles(t *testing.T) {
+	t.Parallel()
+	vr := Variable{Kind: Indexed, List: []string{"a", "", "c", ""}, Holes: []bool{false, true}}
+	if got, want := vr.Indices(), []int{0, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Indices() got %v, want %v", got, want)
+	}
+	if got, want := vr.Elems(), []string{"a", "c", ""}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Elems() got %q, want %q", got, want)
+	}
+	for i, want := range []bool{true, false, true, true, false} {
+		if got := vr.IndexSet(i); got != want {
+			t.Errorf("IndexSet(%d) got %v, want %v", i, got, want)
+		}
+	}
+	elems, err := sliceElems(vr.List, vr.Holes, 1, 1, true)
+	if want := []string{"c"}; err != nil || !reflect.DeepEqual(elems, want) {
+		t.Errorf("sliceElems got %q, %v; want %q", elems, err, want)
+	}
+	if _, err := sliceElems(vr.List, vr.Holes, 0, -1, true); err != (SubstringError{Length: -1}) {
+		t.Errorf("sliceElems with a negative length got error %v", err)
+	}
+}
+
+func TestLazyEnviron(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	env := LazyEnviron(
+		ListEnviron("A=base", "B=base", "C=base"),
+		[]string{"A", "B", "C", "A"},
+		func(name string) string {
+			mu.Lock()
+			calls[name]++
+			mu.Unlock()
+			if name == "C" {
+				return "" // left unset
+			}
+			return "lazy-" + name
+		},
+	)
+	each := func() []string {
+		var list []string
+		env.Each(func(name string, vr Variable) bool {
+			list = append(list, name+"="+vr.String())
+			return true
+		})
+		return list
+	}
+	if got, want := each(), []string{"A=base", "B=base", "C=base"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Each before resolving:\nwant: %q\ngot:  %q", want, got)
+	}
+	if len(calls) > 0 {
+		t.Fatalf("Each should not resolve variables, got calls: %v", calls)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := env.Get("A").String(); got != "lazy-A" {
+				t.Errorf("want lazy-A, got %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := env.Get("C").String(); got != "base" {
+		t.Fatalf("want C to fall back to base, got %q", got)
+	}
+	if got := env.Get("D"); got.IsSet() {
+		t.Fatalf("want D unset, got %q", got.String())
+	}
+	if want := map[string]int{"A": 1, "C": 1}; !reflect.DeepEqual(calls, want) {
+		t.Fatalf("want calls %v, got %v", want, calls)
+	}
+	if got, want := each(), []string{"B=base", "C=base", "A=lazy-A"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Each after resolving:\nwant: %q\ngot:  %q", want, got)
+	}
+}
@@ -5,18 +5,22 @@ package expand
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"os"
 	"os/user"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"unicode/utf8"
 
 	"mvdan.cc/sh/v3/pattern"
 	"mvdan.cc/sh/v3/syntax"
@@ -60,14 +64,58 @@ type Config struct {
 	ReadDir func(string) ([]fs.FileInfo, error)
 
 	// ReadDir is used for file path globbing.
-	// If nil, and ReadDir is nil as well, globbing is disabled.
+	// If nil, and ReadDir and FS are nil as well, globbing is disabled.
 	// Use os.ReadDir to use the filesystem directly.
 	ReadDir2 func(string) ([]fs.DirEntry, error)
 
+	// FS, if non-nil, is used for file path globbing instead of ReadDir2,
+	// such as a virtual filesystem or one embedded in the program.
+	// Absolute paths like "/foo/bar" are looked up as "foo/bar" in FS,
+	// and relative paths are relative to $PWD, which should be absolute.
+	//
+	// Directories are read via [fs.ReadDir], and the existence of paths
+	// without pattern characters is checked via [fs.Stat],
+	// so FS may implement [fs.ReadDirFS] and [fs.StatFS] to be efficient.
+	FS fs.FS
+
+	// Context, if non-nil, is checked as globbing reads each directory,
+	// stopping with the context's error once it is done. This allows
+	// cancelling patterns which may walk an entire filesystem, like "/**/*".
+	Context context.Context
+
+	// GlobMaxMatches, if positive, is the maximum number of paths which
+	// globbing a pattern may match, including the directories matched by each
+	// of its elements along the way, like "*" in "*/*.go".
+	GlobMaxMatches int
+
+	// GlobMaxDepth, if positive, is the maximum number of directory levels
+	// which "**" may match with [Config.GlobStar], counting from where it starts.
+	GlobMaxDepth int
+
+	// MaxFields, if positive, is the maximum number of fields which expanding
+	// a word may produce, such as via a brace expansion like "{1..1000000}".
+	// Brace expansions are checked before any of their fields are built.
+	MaxFields int
+
+	// Glob, if non-nil, is used for file path globbing instead of the
+	// implementation built on ReadDir2, which can be used via [Glob].
+	// It is given each field to glob as a pattern,
+	// where quoted characters are escaped as per [pattern.QuoteMeta],
+	// and it returns the matching paths in order.
+	// Relative patterns are relative to $PWD.
+	//
+	// Glob is responsible for the GlobStar, DotGlob, NoCaseGlob, and ExtGlob
+	// options, while NullGlob and FailGlob are still applied to its results.
+	Glob func(pattern string) ([]string, error)
+
 	// GlobStar corresponds to the shell option that allows globbing with
 	// "**".
 	GlobStar bool
 
+	// DotGlob corresponds to the shell option that allows globbing to match
+	// file names starting with a dot, without the pattern starting with one.
+	DotGlob bool
+
 	// NoCaseGlob corresponds to the shell option that causes case-insensitive
 	// pattern matching in pathname expansion.
 	NoCaseGlob bool
@@ -76,15 +124,54 @@ type Config struct {
 	// patterns which match nothing to result in zero fields.
 	NullGlob bool
 
+	// FailGlob corresponds to the shell option that makes globbing patterns
+	// which match nothing result in a [NoMatchError].
+	// It takes precedence over NullGlob.
+	FailGlob bool
+
 	// NoUnset corresponds to the shell option that treats unset variables
 	// as errors.
 	NoUnset bool
 
+	// ExtGlob corresponds to the shell option that enables the extended
+	// pattern matching operators, such as "@(a|b)" and "+(x)".
+	// Otherwise, using them is an error.
+	ExtGlob bool
+
+	// PatSubReplacement corresponds to the shell option that replaces any
+	// unquoted "&" in the replacement string of "${var/pattern/string}"
+	// with the matched text. A backslash can be used to escape "&".
+	PatSubReplacement bool
+
+	// CheckedArithm makes arithmetic expansions fail with an error when an
+	// integer overflows, rather than wrapping around like Bash does.
+	CheckedArithm bool
+
+	// Collate, if non-nil, compares two strings as per the collation order
+	// of a locale, like LC_COLLATE. It is used to sort the results of
+	// globbing, which are otherwise in the order given by ReadDir2.
+	Collate func(a, b string) int
+
+	// ToUpper and ToLower, if non-nil, convert the case of a character as per
+	// a locale, like LC_CTYPE, in expansions such as "${var^^}" and "${var,}".
+	// If nil, [unicode.ToUpper] and [unicode.ToLower] are used.
+	ToUpper func(rune) rune
+	ToLower func(rune) rune
+
+	// Observe, if non-nil, is called with each parameter, command
+	// substitution, process substitution, and pathname pattern which an
+	// expansion consults, in order. This allows reporting which variables a
+	// program reads, for example.
+	Observe func(Use)
+
 	bufferAlloc bytes.Buffer // TODO: use strings.Builder
 	fieldAlloc  [4]fieldPart
 	fieldsAlloc [4][]fieldPart
 
 	ifs string
+	// The depth of arithmetic expressions being evaluated from the values
+	// of variables, to stop infinite recursion.
+	arithmDepth int
 	// A pointer to a parameter expansion node, if we're inside one.
 	// Necessary for ${LINENO}.
 	curParam *syntax.ParamExp
@@ -100,6 +187,27 @@ func (u UnexpectedCommandError) Error() string {
 	return fmt.Sprintf("unexpected command substitution at %s", u.Node.Pos())
 }
 
+// NoMatchError is returned when a globbing pattern matches nothing
+// and [Config.FailGlob] is set.
+type NoMatchError struct {
+	Pattern string
+}
+
+func (e NoMatchError) Error() string {
+	return "no match: " + e.Pattern
+}
+
+// ErrGlobLimit is wrapped by the errors returned when globbing a pattern
+// exceeds [Config.GlobMaxMatches] or [Config.GlobMaxDepth].
+var ErrGlobLimit = errors.New("glob limit exceeded")
+
+// ErrFieldsLimit is wrapped by the errors returned when expanding a word
+// exceeds [Config.MaxFields].
+var ErrFieldsLimit = errors.New("fields limit exceeded")
+
+// errExtGlob is returned when using extended globbing without [Config.ExtGlob].
+var errExtGlob = fmt.Errorf("extended globbing is not enabled")
+
 var zeroConfig = &Config{}
 
 // TODO: note that prepareConfig is modifying the user's config in place,
@@ -205,12 +313,27 @@ func Document(cfg *Config, word *syntax.Word) (string, error) {
 	return cfg.fieldJoin(field), nil
 }
 
-const patMode = pattern.Filenames | pattern.Braces
+// patMode returns the mode used to quote and detect pattern metacharacters.
+func (cfg *Config) patMode() pattern.Mode {
+	return pattern.Filenames | pattern.Braces | cfg.matchMode()
+}
+
+// matchMode returns the mode used to match strings against patterns,
+// which [Pattern] callers should use as well.
+func (cfg *Config) matchMode() pattern.Mode {
+	if cfg.ExtGlob {
+		return pattern.ExtendedOperators
+	}
+	return 0
+}
 
 // Pattern expands a single shell word as a pattern, using [syntax.QuotePattern]
 // on any non-quoted parts of the input word. The result can be used on
 // [syntax.TranslatePattern] directly.
 //
+// If cfg.ExtGlob is set, the result should be used with
+// [pattern.ExtendedOperators].
+//
 // The config specifies shell expansion options; nil behaves the same as an
 // empty config.
 func Pattern(cfg *Config, word *syntax.Word) (string, error) {
@@ -225,7 +348,7 @@ func Pattern(cfg *Config, word *syntax.Word) (string, error) {
 	buf := cfg.strBuilder()
 	for _, part := range field {
 		if part.quote > quoteNone {
-			buf.WriteString(pattern.QuoteMeta(part.val, patMode))
+			buf.WriteString(pattern.QuoteMeta(part.val, cfg.patMode()))
 		} else {
 			buf.WriteString(part.val)
 		}
@@ -237,6 +360,10 @@ func Pattern(cfg *Config, word *syntax.Word) (string, error) {
 // shell's format specifications. These include printf(1), among others.
 //
 // The resulting string is returned, along with the number of arguments used.
+// Like printf(1), an argument which is not a valid number is formatted as far
+// as it could be parsed, and the errors are returned along with the result.
+// A "\c" escape sequence in a %b argument stops the formatting altogether,
+// in which case all arguments are reported as used.
 //
 // The config specifies shell expansion options; nil behaves the same as an
 // empty config.
@@ -244,175 +371,168 @@ func Format(cfg *Config, format string, args []string) (string, int, error) {
 	cfg = prepareConfig(cfg)
 	buf := cfg.strBuilder()
 
-	consumed, err := formatIntoBuffer(buf, format, args)
-	if err != nil {
-		return "", 0, err
-	}
-
+	consumed, err := formatIntoBuffer(cfg, buf, format, args)
 	return buf.String(), consumed, err
 }
 
-// Format expands a format string with a number of arguments, following the
-// shell's format specifications. These include printf(1), among others.
-//
-// The resulting string is written to the provided buffer, and the number
-// of arguments used is returned.
+// formatIntoBuffer is like [Format], but it writes to the provided buffer.
 //
-// The config specifies shell expansion options; nil behaves the same as an
-// empty config.
-func formatIntoBuffer(buf *bytes.Buffer, format string, args []string) (int, error) {
-	var fmts []byte
+// If args is nil, only escape sequences are expanded; this is what $'...' strings
+// and "echo -e" use.
+func formatIntoBuffer(cfg *Config, buf *bytes.Buffer, format string, args []string) (int, error) {
 	initialArgs := len(args)
+	// Invalid numbers do not stop printf(1), but it does fail at the end.
+	var numErrs []error
+	nextArg := func() string {
+		if len(args) == 0 {
+			return ""
+		}
+		arg := args[0]
+		args = args[1:]
+		return arg
+	}
 
 formatLoop:
 	for i := 0; i < len(format); i++ {
-		// readDigits reads from 0 to max digits, either octal or
-		// hexadecimal.
-		readDigits := func(max int, hex bool) string {
-			j := 0
-			for ; j < max; j++ {
-				c := format[i+j]
-				if (c >= '0' && c <= '9') ||
-					(hex && c >= 'a' && c <= 'f') ||
-					(hex && c >= 'A' && c <= 'F') {
-					// valid octal or hex char
-				} else {
-					break
-				}
-			}
-			digits := format[i : i+j]
-			i += j - 1 // -1 since the outer loop does i++
-			return digits
-		}
 		c := format[i]
 		switch {
 		case c == '\\': // escaped
-			i++
-			switch c = format[i]; c {
-			case 'a': // bell
-				buf.WriteByte('\a')
-			case 'b': // backspace
-				buf.WriteByte('\b')
-			case 'e', 'E': // escape
-				buf.WriteByte('\x1b')
-			case 'f': // form feed
-				buf.WriteByte('\f')
-			case 'n': // new line
-				buf.WriteByte('\n')
-			case 'r': // carriage return
-				buf.WriteByte('\r')
-			case 't': // horizontal tab
-				buf.WriteByte('\t')
-			case 'v': // vertical tab
-				buf.WriteByte('\v')
-			case '\\', '\'', '"', '?': // just the character
-				buf.WriteByte(c)
-			case '0', '1', '2', '3', '4', '5', '6', '7':
-				digits := readDigits(3, false)
-				// if digits don't fit in 8 bits, 0xff via strconv
-				n, _ := strconv.ParseUint(digits, 8, 8)
-				buf.WriteByte(byte(n))
-			case 'x', 'u', 'U':
-				i++
-				max := 2
-				switch c {
-				case 'u':
-					max = 4
-				case 'U':
-					max = 8
+			val, size, _ := formatEscape(format[i+1:], false)
+			if args == nil && val == "\x00" {
+				// If we're about to expand a null byte in $'',
+				// stop the entire loop, like bash.
+				break formatLoop
+			}
+			buf.WriteString(val)
+			i += size
+		case args != nil && c == '%':
+			// if args == nil, we are not doing format
+			// arguments
+			spec := []byte{'%'}
+			for i++; i < len(format); i++ {
+				if c = format[i]; c != '-' && c != '+' && c != ' ' && c != '#' && c != '0' {
+					break
 				}
-				digits := readDigits(max, true)
-				if len(digits) > 0 {
-					// can't error
-					n, _ := strconv.ParseUint(digits, 16, 32)
-					if n == 0 {
-						// If we're about to print \x00,
-						// stop the entire loop, like bash.
-						break formatLoop
+				spec = append(spec, c)
+			}
+			// readNum reads a width or precision, which may be given by
+			// an argument via an asterisk.
+			readNum := func(precision bool) {
+				if i < len(format) && format[i] == '*' {
+					i++
+					n, err := formatInt(nextArg())
+					if err != nil {
+						numErrs = append(numErrs, err)
 					}
-					if c == 'x' {
-						// always as a single byte
-						buf.WriteByte(byte(n))
-					} else {
-						buf.WriteRune(rune(n))
+					if n < 0 && precision {
+						// A negative precision is taken as if it were omitted.
+						spec = spec[:len(spec)-1]
+						return
 					}
-					break
+					// A negative width is a left adjustment.
+					spec = strconv.AppendInt(spec, n, 10)
+					return
 				}
-				fallthrough
-			default: // no escape sequence
-				buf.WriteByte('\\')
-				buf.WriteByte(c)
+				for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+					spec = append(spec, format[i])
+				}
+			}
+			readNum(false)
+			if i < len(format) && format[i] == '.' {
+				spec = append(spec, '.')
+				i++
+				readNum(true)
+			}
+			if i >= len(format) {
+				return 0, fmt.Errorf("missing format char")
 			}
-		case len(fmts) > 0:
-			switch c {
+			switch c = format[i]; c {
 			case '%':
 				buf.WriteByte('%')
-				fmts = nil
 			case 'c':
-				var b byte
-				if len(args) > 0 {
-					arg := ""
-					arg, args = args[0], args[1:]
-					if len(arg) > 0 {
-						b = arg[0]
+				b := "\x00"
+				if arg := nextArg(); len(arg) > 0 {
+					b = arg[:1]
+				}
+				fmt.Fprintf(buf, string(spec)+"s", b)
+			case 's':
+				fmt.Fprintf(buf, string(spec)+"s", nextArg())
+			case 'q':
+				fmt.Fprintf(buf, string(spec)+"s", formatQuote(nextArg()))
+			case 'b':
+				arg := nextArg()
+				var sb strings.Builder
+				stop := false
+				for j := 0; j < len(arg); j++ {
+					if arg[j] != '\\' {
+						sb.WriteByte(arg[j])
+						continue
 					}
+					val, size, stop2 := formatEscape(arg[j+1:], true)
+					if stop = stop2; stop {
+						break
+					}
+					sb.WriteString(val)
+					j += size
 				}
-				buf.WriteByte(b)
-				fmts = nil
-			case '+', '-', ' ':
-				if len(fmts) > 1 {
-					return 0, fmt.Errorf("invalid format char: %c", c)
+				fmt.Fprintf(buf, string(spec)+"s", sb.String())
+				if stop {
+					// "\c" stops all output, and the format is not reused.
+					return initialArgs, errors.Join(numErrs...)
 				}
-				fmts = append(fmts, c)
-			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-				fmts = append(fmts, c)
-			case 's', 'b', 'd', 'i', 'u', 'o', 'x':
-				arg := ""
-				if len(args) > 0 {
-					arg, args = args[0], args[1:]
+			case 'd', 'i', 'u', 'o', 'x', 'X':
+				arg := nextArg()
+				n, err := formatInt(arg)
+				if err != nil {
+					numErrs = append(numErrs, err)
 				}
-				var farg any
-				if c == 'b' {
-					// Passing in nil for args ensures that % format
-					// strings aren't processed; only escape sequences
-					// will be handled.
-					_, err := formatIntoBuffer(buf, arg, nil)
-					if err != nil {
-						return 0, err
-					}
-				} else if c != 's' {
-					n, _ := strconv.ParseInt(arg, 0, 0)
-					if c == 'i' || c == 'd' {
-						farg = int(n)
-					} else {
-						farg = uint(n)
-					}
-					if c == 'i' || c == 'u' {
-						c = 'd'
+				switch c {
+				case 'd', 'i':
+					fmt.Fprintf(buf, string(spec)+"d", n)
+				case 'u':
+					fmt.Fprintf(buf, string(spec)+"d", uint64(n))
+				default:
+					fmt.Fprintf(buf, string(spec)+string(c), uint64(n))
+				}
+			case 'f', 'F', 'e', 'E', 'g', 'G':
+				f, err := formatFloat(nextArg())
+				if err != nil {
+					numErrs = append(numErrs, err)
+				}
+				switch c {
+				case 'F':
+					c = 'f'
+				case 'g', 'G':
+					if !bytes.ContainsRune(spec, '.') {
+						// Unlike Go, C defaults to a precision of 6.
+						spec = append(spec, ".6"...)
 					}
-				} else {
-					farg = arg
 				}
-				if farg != nil {
-					fmts = append(fmts, c)
-					fmt.Fprintf(buf, string(fmts), farg)
+				fmt.Fprintf(buf, string(spec)+string(c), f)
+			case '(':
+				// %(datefmt)T, where datefmt is given to strftime(3).
+				end := strings.IndexByte(format[i:], ')')
+				if end < 0 || i+end+1 >= len(format) || format[i+end+1] != 'T' {
+					return 0, fmt.Errorf("invalid format char: (")
+				}
+				datefmt := format[i+1 : i+end]
+				i += end + 1
+				n := int64(-1) // the current time if there is no argument
+				if len(args) > 0 {
+					var err error
+					if n, err = formatInt(nextArg()); err != nil {
+						numErrs = append(numErrs, err)
+					}
 				}
-				fmts = nil
+				fmt.Fprintf(buf, string(spec)+"s", cfg.strftime(datefmt, n))
 			default:
 				return 0, fmt.Errorf("invalid format char: %c", c)
 			}
-		case args != nil && c == '%':
-			// if args == nil, we are not doing format
-			// arguments
-			fmts = []byte{c}
 		default:
 			buf.WriteByte(c)
 		}
 	}
-	if len(fmts) > 0 {
-		return 0, fmt.Errorf("missing format char")
-	}
-	return initialArgs - len(args), nil
+	return initialArgs - len(args), errors.Join(numErrs...)
 }
 
 func (cfg *Config) fieldJoin(parts []fieldPart) string {
@@ -433,11 +553,11 @@ func (cfg *Config) escapedGlobField(parts []fieldPart) (escaped string, glob boo
 	buf := cfg.strBuilder()
 	for _, part := range parts {
 		if part.quote > quoteNone {
-			buf.WriteString(pattern.QuoteMeta(part.val, patMode))
+			buf.WriteString(pattern.QuoteMeta(part.val, cfg.patMode()))
 			continue
 		}
 		buf.WriteString(part.val)
-		if pattern.HasMeta(part.val, patMode) {
+		if pattern.HasMeta(part.val, cfg.patMode()) {
 			glob = true
 		}
 	}
@@ -453,44 +573,123 @@ func (cfg *Config) escapedGlobField(parts []fieldPart) (escaped string, glob boo
 func Fields(cfg *Config, words ...*syntax.Word) ([]string, error) {
 	cfg = prepareConfig(cfg)
 	fields := make([]string, 0, len(words))
+	err := cfg.fields(words, func(field string, _ []fieldPart) {
+		fields = append(fields, field)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// Field is one of the fields resulting from [FieldsParts].
+type Field struct {
+	// Value is the expanded field, as returned by [Fields].
+	Value string
+
+	// Parts are the pieces which make up Value, in order.
+	Parts []FieldPart
+}
+
+// FieldPart is a piece of a [Field] along with the syntax node it came from.
+type FieldPart struct {
+	// Value is the expanded text of this piece of the field.
+	Value string
+
+	// Node is the word part which produced Value, such as a [*syntax.Lit]
+	// or a [*syntax.ParamExp]. A word part may produce pieces of many
+	// fields, such as an unquoted parameter expansion split by $IFS.
+	//
+	// When a field is the result of globbing, it consists of a single piece
+	// whose node is the entire [*syntax.Word].
+	Node syntax.Node
+}
+
+// FieldsParts is like [Fields], but each resulting field also includes the
+// word parts which produced it, so that their positions can be used to
+// tell where each field came from in the source.
+func FieldsParts(cfg *Config, words ...*syntax.Word) ([]Field, error) {
+	cfg = prepareConfig(cfg)
+	fields := make([]Field, 0, len(words))
+	err := cfg.fields(words, func(value string, parts []fieldPart) {
+		field := Field{Value: value}
+		for _, part := range parts {
+			if part.val != "" {
+				field.Parts = append(field.Parts, FieldPart{Value: part.val, Node: part.node})
+			}
+		}
+		if len(field.Parts) == 0 && len(parts) > 0 {
+			// An empty field, such as from "" or '', where the last
+			// part is what kept it from being removed.
+			part := parts[len(parts)-1]
+			field.Parts = []FieldPart{{Node: part.node}}
+		}
+		fields = append(fields, field)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// fields implements [Fields] and [FieldsParts], calling fn with each field.
+// When a field is a glob match, its only part is the entire word.
+func (cfg *Config) fields(words []*syntax.Word, fn func(field string, parts []fieldPart)) error {
 	dir := cfg.envGet("PWD")
 	for _, word := range words {
 		word := *word // make a copy, since SplitBraces replaces the Parts slice
 		afterBraces := []*syntax.Word{&word}
 		if syntax.SplitBraces(&word) {
+			if cfg.MaxFields > 0 && braceCount(word.Parts, cfg.MaxFields) > cfg.MaxFields {
+				return cfg.fieldsLimit()
+			}
 			afterBraces = Braces(&word)
 		}
+		count := 0
 		for _, word2 := range afterBraces {
 			wfields, err := cfg.wordFields(word2.Parts)
 			if err != nil {
-				return nil, err
+				return err
+			}
+			if count += len(wfields); cfg.MaxFields > 0 && count > cfg.MaxFields {
+				return cfg.fieldsLimit()
 			}
 			for _, field := range wfields {
 				path, doGlob := cfg.escapedGlobField(field)
 				var matches []string
-				if doGlob && cfg.ReadDir2 != nil {
-					matches, err = cfg.glob(dir, path)
+				if doGlob && (cfg.Glob != nil || cfg.ReadDir2 != nil || cfg.FS != nil) {
+					cfg.observe(UseGlob, path, word2)
+					if cfg.Glob != nil {
+						matches, err = cfg.Glob(path)
+					} else {
+						matches, err = cfg.glob(dir, path)
+					}
 					if err != nil {
 						// We avoid [errors.As] as it allocates,
 						// and we know that [Config.glob] returns [pattern.Regexp] errors without wrapping.
 						if _, ok := err.(*pattern.SyntaxError); !ok {
-							return nil, err
+							return err
 						}
+					} else if len(matches) == 0 && cfg.FailGlob {
+						return NoMatchError{Pattern: cfg.fieldJoin(field)}
 					} else if len(matches) > 0 || cfg.NullGlob {
-						fields = append(fields, matches...)
+						for _, match := range matches {
+							fn(match, []fieldPart{{val: match, node: word2}})
+						}
 						continue
 					}
 				}
-				fields = append(fields, cfg.fieldJoin(field))
+				fn(cfg.fieldJoin(field), field)
 			}
 		}
 	}
-	return fields, nil
+	return nil
 }
 
 type fieldPart struct {
 	val   string
 	quote quoteLevel
+	node  syntax.Node // the word part which produced val
 }
 
 type quoteLevel uint
@@ -508,7 +707,7 @@ func (cfg *Config) wordField(wps []syntax.WordPart, ql quoteLevel) ([]fieldPart,
 		case *syntax.Lit:
 			s := wp.Value
 			if i == 0 && ql == quoteNone {
-				if prefix, rest := cfg.expandUser(s); prefix != "" {
+				if prefix, rest := cfg.expandUser(s, wp); prefix != "" {
 					// TODO: return two separate fieldParts,
 					// like in wordFields?
 					s = prefix + rest
@@ -531,9 +730,9 @@ func (cfg *Config) wordField(wps []syntax.WordPart, ql quoteLevel) ([]fieldPart,
 			if i := strings.IndexByte(s, '\x00'); i >= 0 {
 				s = s[:i]
 			}
-			field = append(field, fieldPart{val: s})
+			field = append(field, fieldPart{node: wp, val: s})
 		case *syntax.SglQuoted:
-			fp := fieldPart{quote: quoteSingle, val: wp.Value}
+			fp := fieldPart{node: wp, quote: quoteSingle, val: wp.Value}
 			if wp.Dollar {
 				fp.val, _, _ = Format(cfg, fp.val, nil)
 			}
@@ -552,25 +751,31 @@ func (cfg *Config) wordField(wps []syntax.WordPart, ql quoteLevel) ([]fieldPart,
 			if err != nil {
 				return nil, err
 			}
-			field = append(field, fieldPart{val: val})
+			field = append(field, fieldPart{node: wp, val: val})
 		case *syntax.CmdSubst:
 			val, err := cfg.cmdSubst(wp)
 			if err != nil {
 				return nil, err
 			}
-			field = append(field, fieldPart{val: val})
+			field = append(field, fieldPart{node: wp, val: val})
 		case *syntax.ArithmExp:
 			n, err := Arithm(cfg, wp.X)
 			if err != nil {
 				return nil, err
 			}
-			field = append(field, fieldPart{val: strconv.Itoa(n)})
+			field = append(field, fieldPart{node: wp, val: strconv.Itoa(n)})
 		case *syntax.ProcSubst:
+			cfg.observe(UseProcSubst, "", wp)
 			path, err := cfg.ProcSubst(wp)
 			if err != nil {
 				return nil, err
 			}
-			field = append(field, fieldPart{val: path})
+			field = append(field, fieldPart{node: wp, val: path})
+		case *syntax.ExtGlob:
+			if !cfg.ExtGlob {
+				return nil, errExtGlob
+			}
+			field = append(field, fieldPart{node: wp, val: wp.Op.String() + wp.Pattern.Value + ")"})
 		default:
 			panic(fmt.Sprintf("unhandled word part: %T", wp))
 		}
@@ -582,6 +787,7 @@ func (cfg *Config) cmdSubst(cs *syntax.CmdSubst) (string, error) {
 	if cfg.CmdSubst == nil {
 		return "", UnexpectedCommandError{Node: cs}
 	}
+	cfg.observe(UseCmdSubst, "", cs)
 	buf := cfg.strBuilder()
 	if err := cfg.CmdSubst(buf, cs); err != nil {
 		return "", err
@@ -604,32 +810,30 @@ func (cfg *Config) wordFields(wps []syntax.WordPart) ([][]fieldPart, error) {
 		fields = append(fields, curField)
 		curField = nil
 	}
-	splitAdd := func(val string) {
-		fieldStart := -1
-		for i, r := range val {
-			if cfg.ifsRune(r) {
-				if fieldStart >= 0 { // ending a field
-					curField = append(curField, fieldPart{val: val[fieldStart:i]})
-					fieldStart = -1
-				}
+	splitAdd := func(val string, node syntax.Node) {
+		cfg.splitIFS(val, func(s string) {
+			curField = append(curField, fieldPart{node: node, val: s})
+		}, func(force bool) {
+			if !force {
 				flush()
-			} else {
-				if fieldStart < 0 { // starting a new field
-					fieldStart = i
-				}
+				return
 			}
-		}
-		if fieldStart >= 0 { // ending a field without IFS
-			curField = append(curField, fieldPart{val: val[fieldStart:]})
-		}
+			if len(curField) == 0 {
+				// An empty field, like in "a::b" with IFS=":".
+				curField = append(curField, fieldPart{node: node})
+			}
+			fields = append(fields, curField)
+			curField = nil
+		})
 	}
 	for i, wp := range wps {
 		switch wp := wp.(type) {
 		case *syntax.Lit:
 			s := wp.Value
 			if i == 0 {
-				prefix, rest := cfg.expandUser(s)
+				prefix, rest := cfg.expandUser(s, wp)
 				curField = append(curField, fieldPart{
+					node:  wp,
 					quote: quoteSingle,
 					val:   prefix,
 				})
@@ -649,10 +853,10 @@ func (cfg *Config) wordFields(wps []syntax.WordPart) ([][]fieldPart, error) {
 				}
 				s = buf.String()
 			}
-			curField = append(curField, fieldPart{val: s})
+			curField = append(curField, fieldPart{node: wp, val: s})
 		case *syntax.SglQuoted:
 			allowEmpty = true
-			fp := fieldPart{quote: quoteSingle, val: wp.Value}
+			fp := fieldPart{node: wp, quote: quoteSingle, val: wp.Value}
 			if wp.Dollar {
 				fp.val, _, _ = Format(cfg, fp.val, nil)
 			}
@@ -660,12 +864,17 @@ func (cfg *Config) wordFields(wps []syntax.WordPart) ([][]fieldPart, error) {
 		case *syntax.DblQuoted:
 			if len(wp.Parts) == 1 {
 				pe, _ := wp.Parts[0].(*syntax.ParamExp)
-				if elems := cfg.quotedElemFields(pe); elems != nil {
+				elems, err := cfg.quotedElemFields(pe)
+				if err != nil {
+					return nil, err
+				}
+				if elems != nil {
 					for i, elem := range elems {
 						if i > 0 {
 							flush()
 						}
 						curField = append(curField, fieldPart{
+							node:  pe,
 							quote: quoteDouble,
 							val:   elem,
 						})
@@ -678,6 +887,10 @@ func (cfg *Config) wordFields(wps []syntax.WordPart) ([][]fieldPart, error) {
 			if err != nil {
 				return nil, err
 			}
+			if len(wfield) == 0 {
+				// Keep the field even if empty, like in $var"".
+				wfield = []fieldPart{{node: wp}}
+			}
 			for _, part := range wfield {
 				part.quote = quoteDouble
 				curField = append(curField, part)
@@ -687,27 +900,31 @@ func (cfg *Config) wordFields(wps []syntax.WordPart) ([][]fieldPart, error) {
 			if err != nil {
 				return nil, err
 			}
-			splitAdd(val)
+			splitAdd(val, wp)
 		case *syntax.CmdSubst:
 			val, err := cfg.cmdSubst(wp)
 			if err != nil {
 				return nil, err
 			}
-			splitAdd(val)
+			splitAdd(val, wp)
 		case *syntax.ArithmExp:
 			n, err := Arithm(cfg, wp.X)
 			if err != nil {
 				return nil, err
 			}
-			curField = append(curField, fieldPart{val: strconv.Itoa(n)})
+			curField = append(curField, fieldPart{node: wp, val: strconv.Itoa(n)})
 		case *syntax.ProcSubst:
+			cfg.observe(UseProcSubst, "", wp)
 			path, err := cfg.ProcSubst(wp)
 			if err != nil {
 				return nil, err
 			}
-			splitAdd(path)
+			splitAdd(path, wp)
 		case *syntax.ExtGlob:
-			return nil, fmt.Errorf("extended globbing is not supported")
+			if !cfg.ExtGlob {
+				return nil, errExtGlob
+			}
+			curField = append(curField, fieldPart{node: wp, val: wp.Op.String() + wp.Pattern.Value + ")"})
 		default:
 			panic(fmt.Sprintf("unhandled word part: %T", wp))
 		}
@@ -721,50 +938,77 @@ func (cfg *Config) wordFields(wps []syntax.WordPart) ([][]fieldPart, error) {
 
 // quotedElemFields returns the list of elements resulting from a quoted
 // parameter expansion that should be treated especially, like "${foo[@]}".
-func (cfg *Config) quotedElemFields(pe *syntax.ParamExp) []string {
+func (cfg *Config) quotedElemFields(pe *syntax.ParamExp) ([]string, error) {
 	if pe == nil || pe.Length || pe.Width {
-		return nil
+		return nil, nil
 	}
 	name := pe.Param.Value
 	if pe.Excl {
 		switch pe.Names {
 		case syntax.NamesPrefixWords: // "${!prefix@}"
-			return cfg.namesByPrefix(pe.Param.Value)
+			return cfg.namesByPrefix(pe.Param.Value), nil
 		case syntax.NamesPrefix: // "${!prefix*}"
-			return nil
+			return nil, nil
 		}
+		var keys []string
 		switch nodeLit(pe.Index) {
 		case "@": // "${!name[@]}"
 			switch vr := cfg.Env.Get(name); vr.Kind {
 			case Indexed:
-				keys := make([]string, 0, len(vr.Map))
-				// TODO: maps.Keys if it makes it into Go 1.23
-				for key := range vr.List {
+				keys = make([]string, 0, len(vr.List))
+				for _, key := range vr.Indices() {
 					keys = append(keys, strconv.Itoa(key))
 				}
-				return keys
 			case Associative:
-				keys := make([]string, 0, len(vr.Map))
+				keys = make([]string, 0, len(vr.Map))
 				// TODO: maps.Keys if it makes it into Go 1.23
 				for key := range vr.Map {
 					keys = append(keys, key)
 				}
-				return keys
 			}
 		}
-		return nil
+		if keys != nil {
+			cfg.observe(UseVar, name, pe)
+		}
+		return keys, nil
 	}
+	if pe.Slice != nil || pe.Repl != nil || (pe.Exp != nil && elemOp(pe.Exp.Op)) {
+		// Slices and operators applied to each element,
+		// like "${name[@]:1}" or "${name[@]@Q}".
+		index := nodeLit(pe.Index)
+		if name != "@" && name != "*" && index != "@" && index != "*" {
+			return nil, nil
+		}
+		_, elems, err := cfg.paramExpElems(pe)
+		if err != nil || elems == nil {
+			return nil, err
+		}
+		if name == "*" || index == "*" {
+			return []string{cfg.ifsJoin(elems)}, nil
+		}
+		return elems, nil
+	}
+	elems := cfg.quotedVarElems(name, pe.Index)
+	if elems != nil {
+		cfg.observe(UseVar, name, pe)
+	}
+	return elems, nil
+}
+
+// quotedVarElems is like quotedElemFields, for parameter expansions of all the
+// elements of a variable without any operators, like "${name[@]}" or "${*}".
+func (cfg *Config) quotedVarElems(name string, index syntax.ArithmExpr) []string {
 	switch name {
 	case "*": // "${*}"
 		return []string{cfg.ifsJoin(cfg.Env.Get(name).List)}
 	case "@": // "${@}"
 		return cfg.Env.Get(name).List
 	}
-	switch nodeLit(pe.Index) {
+	switch nodeLit(index) {
 	case "@": // "${name[@]}"
 		switch vr := cfg.Env.Get(name); vr.Kind {
 		case Indexed:
-			return vr.List
+			return vr.Elems()
 		case Associative:
 			// TODO: maps.Values if it makes it into Go 1.23
 			elems := make([]string, 0, len(vr.Map))
@@ -775,13 +1019,27 @@ func (cfg *Config) quotedElemFields(pe *syntax.ParamExp) []string {
 		}
 	case "*": // "${name[*]}"
 		if vr := cfg.Env.Get(name); vr.Kind == Indexed {
-			return []string{cfg.ifsJoin(vr.List)}
+			return []string{cfg.ifsJoin(vr.Elems())}
 		}
 	}
 	return nil
 }
 
-func (cfg *Config) expandUser(field string) (prefix, rest string) {
+// elemOp reports whether a parameter expansion operator
+// is applied to each element of an array separately.
+func elemOp(op syntax.ParExpOperator) bool {
+	switch op {
+	case syntax.RemSmallPrefix, syntax.RemLargePrefix,
+		syntax.RemSmallSuffix, syntax.RemLargeSuffix,
+		syntax.UpperFirst, syntax.UpperAll,
+		syntax.LowerFirst, syntax.LowerAll,
+		syntax.OtherParamOps:
+		return true
+	}
+	return false
+}
+
+func (cfg *Config) expandUser(field string, lit *syntax.Lit) (prefix, rest string) {
 	if len(field) == 0 || field[0] != '~' {
 		return "", field
 	}
@@ -791,6 +1049,7 @@ func (cfg *Config) expandUser(field string) (prefix, rest string) {
 		name = name[:i]
 	}
 	if name == "" {
+		cfg.observe(UseVar, "HOME", lit)
 		// Current user; try via "HOME", otherwise fall back to the
 		// system's appropriate home dir env var. Don't use os/user, as
 		// that's overkill. We can't use os.UserHomeDir, because we want
@@ -822,15 +1081,6 @@ func (cfg *Config) expandUser(field string) (prefix, rest string) {
 	return u.HomeDir, rest
 }
 
-func findAllIndex(pat, name string, n int) [][]int {
-	expr, err := pattern.Regexp(pat, 0)
-	if err != nil {
-		return nil
-	}
-	rx := regexp.MustCompile(expr)
-	return rx.FindAllStringIndex(name, n)
-}
-
 var rxGlobStar = regexp.MustCompile(".*")
 
 // pathJoin2 is a simpler version of [filepath.Join] without cleaning the result,
@@ -853,6 +1103,21 @@ func pathSplit(path string) []string {
 	return strings.Split(path, string(filepath.Separator))
 }
 
+// Glob performs pathname expansion on a pattern, such as "*.go" or "dir/**",
+// returning the matching paths in order.
+// Relative patterns are relative to $PWD.
+//
+// The config is used for its Env, FS, ReadDir2, Context, GlobStar, DotGlob,
+// NoCaseGlob, ExtGlob, Collate, GlobMaxMatches, and GlobMaxDepth fields. Glob ignores cfg.Glob, so it can be used to implement it.
+// If cfg.FS, cfg.ReadDir2, and cfg.ReadDir are nil, no paths match.
+func Glob(cfg *Config, pat string) ([]string, error) {
+	cfg = prepareConfig(cfg)
+	if cfg.ReadDir2 == nil && cfg.FS == nil {
+		return nil, nil
+	}
+	return cfg.glob(cfg.envGet("PWD"), pat)
+}
+
 func (cfg *Config) glob(base, pat string) ([]string, error) {
 	parts := pathSplit(pat)
 	matches := []string{""}
@@ -887,7 +1152,7 @@ func (cfg *Config) glob(base, pat string) ([]string, error) {
 				matches[i] = pathJoin2(dir, part)
 			}
 			continue
-		case !pattern.HasMeta(part, patMode):
+		case !pattern.HasMeta(part, cfg.patMode()):
 			var newMatches []string
 			for _, dir := range matches {
 				match := dir
@@ -895,6 +1160,14 @@ func (cfg *Config) glob(base, pat string) ([]string, error) {
 					match = filepath.Join(base, match)
 				}
 				match = pathJoin2(match, part)
+				if cfg.FS != nil {
+					info, err := fs.Stat(cfg.FS, fsPath(match))
+					if err != nil || (wantDir && !info.IsDir()) {
+						continue // doesn't exist, or not a directory
+					}
+					newMatches = append(newMatches, pathJoin2(dir, part))
+					continue
+				}
 				// We can't use ReadDir2 on the parent and match the directory
 				// entry by name, because short paths on Windows break that.
 				// Our only option is to ReadDir2 on the directory entry itself,
@@ -927,60 +1200,99 @@ func (cfg *Config) glob(base, pat string) ([]string, error) {
 			// Note that we need the results to be in depth-first order,
 			// and to avoid recursion, we use a slice as a stack.
 			// Since we pop from the back, we populate the stack backwards.
-			stack := make([]string, 0, len(matches))
+			type entry struct {
+				dir   string
+				depth int // the number of directory levels matched
+			}
+			stack := make([]entry, 0, len(matches))
 			for i := len(matches) - 1; i >= 0; i-- {
 				// "a/**" should match "a/ a/b a/b/cfg ...";
 				// note how the zero-match case has a trailing separator.
-				stack = append(stack, pathJoin2(matches[i], ""))
+				stack = append(stack, entry{pathJoin2(matches[i], ""), 0})
 			}
 			matches = matches[:0]
 			var newMatches []string // to reuse its capacity
 			for len(stack) > 0 {
-				dir := stack[len(stack)-1]
+				e := stack[len(stack)-1]
 				stack = stack[:len(stack)-1]
 
 				// Don't include the original "" match as it's not a valid path.
-				if dir != "" {
-					matches = append(matches, dir)
+				if e.dir != "" {
+					matches = append(matches, e.dir)
+					if err := cfg.globLimit(pat, matches); err != nil {
+						return nil, err
+					}
 				}
 
+				if cfg.Context != nil {
+					if err := cfg.Context.Err(); err != nil {
+						return nil, err
+					}
+				}
 				// If dir is not a directory, we keep the stack as-is and continue.
 				newMatches = newMatches[:0]
-				newMatches, _ = cfg.globDir(base, dir, rxGlobStar, false, wantDir, newMatches)
+				newMatches, _ = cfg.globDir(base, e.dir, rxGlobStar.MatchString, cfg.DotGlob, wantDir, newMatches)
+				if len(newMatches) > 0 && cfg.GlobMaxDepth > 0 && e.depth >= cfg.GlobMaxDepth {
+					return nil, fmt.Errorf("%s: %w: more than %d directory levels", pat, ErrGlobLimit, cfg.GlobMaxDepth)
+				}
 				for i := len(newMatches) - 1; i >= 0; i-- {
-					stack = append(stack, newMatches[i])
+					stack = append(stack, entry{newMatches[i], e.depth + 1})
 				}
 			}
 			continue
 		}
-		mode := pattern.Filenames | pattern.EntireString
+		mode := pattern.Filenames | pattern.EntireString | cfg.matchMode()
 		if cfg.NoCaseGlob {
 			mode |= pattern.NoGlobCase
 		}
-		expr, err := pattern.Regexp(part, mode)
+		m, err := pattern.Compile(part, mode)
 		if err != nil {
 			return nil, err
 		}
-		rx := regexp.MustCompile(expr)
-		matchHidden := part[0] == byte('.')
+		matchHidden := part[0] == byte('.') || cfg.DotGlob
 		var newMatches []string
 		for _, dir := range matches {
-			newMatches, err = cfg.globDir(base, dir, rx, matchHidden, wantDir, newMatches)
+			newMatches, err = cfg.globDir(base, dir, m.Match, matchHidden, wantDir, newMatches)
 			if err != nil {
 				return nil, err
 			}
+			if err := cfg.globLimit(pat, newMatches); err != nil {
+				return nil, err
+			}
 		}
 		matches = newMatches
 	}
+	if cfg.Collate != nil {
+		slices.SortStableFunc(matches, cfg.Collate)
+	}
 	return matches, nil
 }
 
-func (cfg *Config) globDir(base, dir string, rx *regexp.Regexp, matchHidden bool, wantDir bool, matches []string) ([]string, error) {
+// fieldsLimit returns the error for a word exceeding [Config.MaxFields].
+func (cfg *Config) fieldsLimit() error {
+	return fmt.Errorf("%w: more than %d fields", ErrFieldsLimit, cfg.MaxFields)
+}
+
+// globLimit returns an error if the matches for a pattern exceed
+// [Config.GlobMaxMatches].
+func (cfg *Config) globLimit(pat string, matches []string) error {
+	if cfg.GlobMaxMatches > 0 && len(matches) > cfg.GlobMaxMatches {
+		return fmt.Errorf("%s: %w: more than %d matches", pat, ErrGlobLimit, cfg.GlobMaxMatches)
+	}
+	return nil
+}
+
+func (cfg *Config) globDir(base, dir string, match func(string) bool, matchHidden bool, wantDir bool, matches []string) ([]string, error) {
+	if cfg.Context != nil {
+		if err := cfg.Context.Err(); err != nil {
+			return matches, err
+		}
+	}
 	fullDir := dir
 	if !filepath.IsAbs(dir) {
 		fullDir = filepath.Join(base, dir)
 	}
-	infos, err := cfg.ReadDir2(fullDir)
+	infos, err := cfg.readDir(fullDir)
 	if err != nil {
 		// We still want to return matches, for the sake of reusing slices.
 		return matches, err
@@ -995,7 +1307,11 @@ func (cfg *Config) globDir(base, dir string, rx *regexp.Regexp, matchHidden bool
 			// does not follow symlinks for each of the directory entries.
 			// ReadDir is somewhat wasteful here, as we only want its error result,
 			// but we could try to reuse its result as per the TODO in Config.glob.
-			if _, err := cfg.ReadDir2(filepath.Join(fullDir, info.Name())); err != nil {
+			if cfg.FS != nil {
+				if info, err := fs.Stat(cfg.FS, fsPath(filepath.Join(fullDir, name))); err != nil || !info.IsDir() {
+					continue
+				}
+			} else if _, err := cfg.ReadDir2(filepath.Join(fullDir, name)); err != nil {
 				continue
 			}
 		} else if !mode.IsDir() {
@@ -1005,13 +1321,107 @@ func (cfg *Config) globDir(base, dir string, rx *regexp.Regexp, matchHidden bool
 		if !matchHidden && name[0] == '.' {
 			continue
 		}
-		if rx.MatchString(name) {
+		if match(name) {
 			matches = append(matches, pathJoin2(dir, name))
 		}
 	}
 	return matches, nil
 }
 
+// readDir reads a directory for globbing, via FS if set, or ReadDir2 otherwise.
+func (cfg *Config) readDir(dir string) ([]fs.DirEntry, error) {
+	if cfg.FS != nil {
+		return fs.ReadDir(cfg.FS, fsPath(dir))
+	}
+	return cfg.ReadDir2(dir)
+}
+
+// fsPath converts a path used in globbing into one valid for [fs.FS],
+// which is unrooted and slash-separated. Absolute paths are relative to the root
+// of the filesystem, and a leading volume name like "C:" is dropped.
+func fsPath(name string) string {
+	name = filepath.ToSlash(name[len(filepath.VolumeName(name)):])
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+// SplitFields splits s into fields using $IFS, like the shell does with the
+// results of unquoted expansions.
+//
+// Sequences of the whitespace characters in $IFS, which are spaces, tabs, and
+// newlines, separate fields and are trimmed at the start and end of s.
+// Any other character in $IFS, along with the whitespace surrounding it,
+// delimits exactly one field, so that empty fields are possible;
+// for example, "a::b" is split into "a", "", and "b" when $IFS is ":".
+// A delimiter at the end of s does not result in an extra empty field.
+//
+// An unset $IFS is treated as " \t\n", and an empty $IFS results in no splitting.
+//
+// The config specifies shell expansion options; nil behaves the same as an
+// empty config.
+func SplitFields(cfg *Config, s string) []string {
+	cfg = prepareConfig(cfg)
+	var fields []string
+	inField := false
+	cfg.splitIFS(s, func(s string) {
+		fields = append(fields, s)
+		inField = true
+	}, func(force bool) {
+		if force && !inField {
+			fields = append(fields, "")
+		}
+		inField = false
+	})
+	return fields
+}
+
+// splitIFS implements field splitting as described in [SplitFields].
+// The add func is called with each field, and the end func is called
+// with each delimiter, where force reports whether the delimiter ends a field
+// even if it is empty.
+func (cfg *Config) splitIFS(s string, add func(s string), end func(force bool)) {
+	fieldStart := -1
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if !cfg.ifsRune(r) {
+			if fieldStart < 0 { // starting a new field
+				fieldStart = i
+			}
+			i += size
+			continue
+		}
+		if fieldStart >= 0 { // ending a field
+			add(s[fieldStart:i])
+			fieldStart = -1
+		}
+		// A delimiter is any IFS whitespace,
+		// plus at most one other IFS character.
+		force := false
+		for i < len(s) {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if !cfg.ifsRune(r) {
+				break
+			}
+			if !ifsSpace(r) {
+				if force {
+					break
+				}
+				force = true
+			}
+			i += size
+		}
+		end(force)
+	}
+	if fieldStart >= 0 { // ending a field without IFS
+		add(s[fieldStart:])
+	}
+}
+
+func ifsSpace(r rune) bool { return r == ' ' || r == '\t' || r == '\n' }
+
 // ReadFields splits and returns n fields from s, like the "read" shell builtin.
 // If raw is set, backslash escape sequences are not interpreted.
 //
@@ -4,11 +4,20 @@
 package expand
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"math/big"
 	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"mvdan.cc/sh/v3/syntax"
 )
@@ -130,6 +139,151 @@ func Test_glob(t *testing.T) {
 	}
 }
 
+func TestGlobFS(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"src/a.go":       {},
+		"src/b.go":       {},
+		"src/.hidden.go": {},
+		"src/sub/c.go":   {},
+		"src/sub/d.txt":  {},
+		"src/file":       {},
+		"etc/passwd":     {},
+		"src/link":       {Mode: fs.ModeSymlink, Data: []byte("a.go")},
+		"src/dirlink":    {Mode: fs.ModeSymlink, Data: []byte("sub")},
+	}
+	tests := []struct {
+		pwd      string
+		globStar bool
+		pat      string
+		want     []string
+	}{
+		{"/src", false, "*.go", []string{"a.go", "b.go"}},
+		{"/src", false, ".*.go", []string{".hidden.go"}},
+		{"/src", false, "*/*.go", []string{"dirlink/c.go", "sub/c.go"}},
+		{"/src", false, "*/", []string{"dirlink/", "sub/"}},
+		{"/src", false, "*link/*.txt", []string{"dirlink/d.txt"}},
+		{"/src", false, "file/*", nil},
+		{"/src", false, "sub/*", []string{"sub/c.go", "sub/d.txt"}},
+		{"/src", false, "nope/*", nil},
+		{"/src", false, "../etc/p*", []string{"../etc/passwd"}},
+		{"/src", false, "/etc/*", []string{"/etc/passwd"}},
+		{"/", false, "s*/sub/*.txt", []string{"src/sub/d.txt"}},
+		{"/", true, "src/**/c.go", []string{"src/dirlink/c.go", "src/sub/c.go"}},
+		{"/src", true, "s**", []string{"sub"}},
+		{"/src", true, "sub/**", []string{"sub/", "sub/c.go", "sub/d.txt"}},
+	}
+	for _, tc := range tests {
+		cfg := &Config{
+			Env:      ListEnviron("PWD=" + tc.pwd),
+			FS:       fsys,
+			GlobStar: tc.globStar,
+		}
+		got, err := Glob(cfg, tc.pat)
+		if err != nil {
+			t.Errorf("Glob(%q) error: %v", tc.pat, err)
+			continue
+		}
+		for i := range got {
+			got[i] = filepath.ToSlash(got[i])
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Glob(%q) in %q got %q, want %q", tc.pat, tc.pwd, got, tc.want)
+		}
+	}
+}
+
+func TestGlobLimits(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"a/b/c/d/file": {},
+		"a/b/c/file":   {},
+		"a/b/file":     {},
+		"a/file":       {},
+	}
+	tests := []struct {
+		maxMatches int
+		maxDepth   int
+		pat        string
+		wantErr    string
+	}{
+		{0, 0, "/**/*", ""},
+		{8, 0, "/**/*", ""},
+		{7, 0, "/**/*", "/**/*: glob limit exceeded: more than 7 matches"},
+		{0, 4, "/**/file", ""},
+		{0, 3, "/**/file", "/**/file: glob limit exceeded: more than 3 directory levels"},
+		{0, 3, "/a/**/file", ""},
+		{0, 1, "/*/*/*/*/*", ""},
+		{1, 0, "/*/*/*/*/*", ""},
+		{1, 0, "/a/*", "/a/*: glob limit exceeded: more than 1 matches"},
+	}
+	for _, tc := range tests {
+		cfg := &Config{
+			FS:             fsys,
+			GlobStar:       true,
+			GlobMaxMatches: tc.maxMatches,
+			GlobMaxDepth:   tc.maxDepth,
+		}
+		_, err := Glob(cfg, tc.pat)
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("Glob(%q) with limits %d/%d error: %v", tc.pat, tc.maxMatches, tc.maxDepth, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrGlobLimit) || err.Error() != tc.wantErr {
+			t.Errorf("Glob(%q) with limits %d/%d got error %v, want %q", tc.pat, tc.maxMatches, tc.maxDepth, err, tc.wantErr)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cfg := &Config{FS: fsys, GlobStar: true, Context: ctx}
+	for _, pat := range []string{"/**", "/*", "/a/*"} {
+		if _, err := Glob(cfg, pat); err != context.Canceled {
+			t.Errorf("Glob(%q) with a cancelled context got error %v", pat, err)
+		}
+	}
+}
+
+func TestFieldsLimit(t *testing.T) {
+	t.Parallel()
+	env := ListEnviron("FOO=a b c d")
+	tests := []struct {
+		maxFields int
+		src       string
+		wantErr   string
+	}{
+		{0, "{1..1000}", ""},
+		{1000, "{1..1000}", ""},
+		{999, "{1..1000}", "fields limit exceeded: more than 999 fields"},
+		{10, "{1..3}{a,b,c}", ""},
+		{8, "{1..3}{a,b,c}", "fields limit exceeded: more than 8 fields"},
+		{10, "{a,{1..5},b}", ""},
+		{5, "{a,{1..5},b}", "fields limit exceeded: more than 5 fields"},
+		{10, "{1..10..3}", ""},
+		{3, "{1..10..3}", "fields limit exceeded: more than 3 fields"},
+		{3, "{-9223372036854775808..9223372036854775807}", "fields limit exceeded: more than 3 fields"},
+		{100, "{1..1000000000}{1..1000000000}", "fields limit exceeded: more than 100 fields"},
+		{4, "$FOO", ""},
+		{3, "$FOO", "fields limit exceeded: more than 3 fields"},
+		{3, "{x,y}$FOO", "fields limit exceeded: more than 3 fields"},
+	}
+	for _, tc := range tests {
+		cfg := &Config{Env: env, MaxFields: tc.maxFields}
+		_, err := Fields(cfg, parseWord(t, tc.src))
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Errorf("Fields(%q) with limit %d error: %v", tc.src, tc.maxFields, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrFieldsLimit) || err.Error() != tc.wantErr {
+			t.Errorf("Fields(%q) with limit %d got error %v, want %q", tc.src, tc.maxFields, err, tc.wantErr)
+		}
+	}
+}
+
 type mockFileInfo struct {
 	name        string
 	typ         fs.FileMode
@@ -140,3 +294,293 @@ var _ fs.DirEntry = (*mockFileInfo)(nil)
 
 func (fi *mockFileInfo) Name() string      { return fi.name }
 func (fi *mockFileInfo) Type() fs.FileMode { return fi.typ }
+
+func TestPrompt(t *testing.T) {
+	t.Parallel()
+	env := ListEnviron(
+		"HOME=/home/user",
+		"PWD=/home/user/src/sh",
+		"USER=user",
+		"HOSTNAME=host.example.com",
+		"EUID=1000",
+		"TZ=UTC",
+		"foo=bar",
+		"dollar=$foo",
+	)
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{``, ``},
+		{`plain> `, `plain> `},
+		{`\u@\h:\w\$ `, `user@host:~/src/sh$ `},
+		{`\H \W`, `host.example.com sh`},
+		{`\[\e[1m\]>\[\e[0m\]`, "\x1b[1m>\x1b[0m"},
+		{`\D{%Y}`, time.Now().UTC().Format("2006")},
+		{`\D{%%} \D`, `% \D`},
+		{`\101\\\n\q`, "A\\\n\\q"},
+		{`$foo ${#foo} \$foo`, `bar 3 $foo`},
+		{`$dollar`, `$foo`},
+		{`$(echo)`, ``},
+		{"trailing\\", "trailing\\"},
+	}
+	for _, tc := range tests {
+		cfg := &Config{Env: env, CmdSubst: func(io.Writer, *syntax.CmdSubst) error { return nil }}
+		got, err := Prompt(cfg, tc.src)
+		if err != nil {
+			t.Errorf("Prompt(%q) error: %v", tc.src, err)
+		} else if got != tc.want {
+			t.Errorf("Prompt(%q) got %q, want %q", tc.src, got, tc.want)
+		}
+	}
+
+	env = ListEnviron("HOME=/root", "PWD=/root", "EUID=0")
+	got, err := Prompt(&Config{Env: env}, `\W \w \$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `~ ~ #`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	t.Parallel()
+	src := `echo $a "${b:-$c}" $((d + e)) $(cmd) <(cmd) ~/bin *.go "${arr[@]}" ${!ref} ${#arr[@]} '$quoted'`
+	file, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := file.Stmts[0].Cmd.(*syntax.CallExpr)
+
+	var got []string
+	cfg := &Config{
+		Env:       ListEnviron("HOME=/home/user", "ref=a", "arr=x"),
+		CmdSubst:  func(io.Writer, *syntax.CmdSubst) error { return nil },
+		ProcSubst: func(*syntax.ProcSubst) (string, error) { return "/dev/fd/3", nil },
+		Glob:      func(string) ([]string, error) { return nil, nil },
+		Observe: func(use Use) {
+			got = append(got, fmt.Sprintf("%d %s %s", use.Kind, use.Name, use.Node.Pos()))
+		},
+	}
+	if _, err := Fields(cfg, call.Args[1:]...); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1 a 1:6",
+		"1 b 1:10",
+		"1 c 1:15",
+		"1 d 1:23",
+		"1 e 1:27",
+		"2  1:31",
+		"3  1:38",
+		"1 HOME 1:45",
+		"4 *.go 1:51",
+		"1 arr 1:57",
+		"1 ref 1:68",
+		"1 a 1:68",
+		"1 arr 1:76",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("got uses:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		ifs  string // "-" for unset
+		src  string
+		want []string
+	}{
+		{"-", "", nil},
+		{"-", "  a \t b\n\nc  ", []string{"a", "b", "c"}},
+		{"", " a b ", []string{" a b "}},
+		{":", "a::b:", []string{"a", "", "b"}},
+		{":", ":b", []string{"", "b"}},
+		{":", ":", []string{""}},
+		{": ", " a : b ", []string{"a", "b"}},
+		{": ", "a :: b", []string{"a", "", "b"}},
+		{": ", " : a", []string{"", "a"}},
+		{"\t:", "\t\ta\t\t", []string{"a"}},
+		{"ab", "xaay", []string{"x", "", "y"}},
+		{" ", "é a", []string{"é", "a"}},
+	}
+	for _, tc := range tests {
+		env := ListEnviron()
+		if tc.ifs != "-" {
+			env = ListEnviron("IFS=" + tc.ifs)
+		}
+		got := SplitFields(&Config{Env: env}, tc.src)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("SplitFields(%q) with IFS=%q got %q, want %q", tc.src, tc.ifs, got, tc.want)
+		}
+	}
+}
+
+func parseArithm(t *testing.T, src string) syntax.ArithmExpr {
+	t.Helper()
+	expr, err := syntax.NewParser().Arithmetic(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return expr
+}
+
+func TestArithmEvaluator(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		src     string
+		want    string // for Int64
+		wantBig string // if different from want
+	}{
+		{src: "1 + 2*3", want: "7"},
+		{src: "x", want: "3"},
+		{src: "expr * 2", want: "14"},
+		{src: "$x$x + ${#expr}", want: "36"},
+		{src: "unset + 1", want: "1"},
+		{src: "0x10 + 010 + 2#11 + 64#_", want: "90"},
+		{src: "-7 / 2, -7 % 2", want: "-1"},
+		{src: "~5 & 0xff | 1 ^ 3", want: "250"},
+		{src: "x > 2 && x < 4 ? 10 : 20", want: "10"},
+		{src: "9223372036854775807 + 1", want: "-9223372036854775808", wantBig: "9223372036854775808"},
+		{src: "2**64", want: "0", wantBig: "18446744073709551616"},
+		{src: "3**41", want: "-420491770248316829", wantBig: "36472996377170786403"},
+		{src: "(-3)**65", want: "-7752514261426648835", wantBig: "-10301051460877537453973547267843"},
+		{src: "1 << 65", want: "2", wantBig: "36893488147419103232"},
+		{src: "-8 >> 1", want: "-4"},
+		{src: "99999999999999999999 % 7", want: "5", wantBig: "1"},
+	}
+	vars := map[string]string{"x": "3", "expr": "x+4"}
+	e := &ArithmEvaluator{Get: func(name string) string { return vars[name] }}
+	for _, tc := range tests {
+		expr := parseArithm(t, tc.src)
+		got, err := e.Int64(expr)
+		if err != nil {
+			t.Errorf("Int64(%q) error: %v", tc.src, err)
+		} else if got := fmt.Sprint(got); got != tc.want {
+			t.Errorf("Int64(%q) got %s, want %s", tc.src, got, tc.want)
+		}
+		wantBig := tc.want
+		if tc.wantBig != "" {
+			wantBig = tc.wantBig
+		}
+		gotBig, err := e.Big(expr)
+		if err != nil {
+			t.Errorf("Big(%q) error: %v", tc.src, err)
+		} else if got := gotBig.String(); got != wantBig {
+			t.Errorf("Big(%q) got %s, want %s", tc.src, got, wantBig)
+		}
+	}
+
+	for _, src := range []string{"1/0", "2**-1", "x = 1", "@", "$(cmd)"} {
+		if _, err := e.Int64(parseArithm(t, src)); err == nil {
+			t.Errorf("Int64(%q) did not error", src)
+		}
+	}
+	checked := &ArithmEvaluator{Checked: true}
+	for _, src := range []string{"9223372036854775807 + 1", "2**64", "1 << 63", "99999999999999999999"} {
+		if _, err := checked.Int64(parseArithm(t, src)); err == nil {
+			t.Errorf("checked Int64(%q) did not error", src)
+		}
+	}
+	if _, err := checked.Big(parseArithm(t, "1 << -1")); err == nil {
+		t.Errorf("Big with a negative shift count did not error")
+	}
+	for _, src := range []string{"2 ** 99999999999", "3 ** (1 << 62)", "1 << 99999999", "(1 << 999999) << 999999"} {
+		if _, err := e.Big(parseArithm(t, src)); err == nil {
+			t.Errorf("Big(%q) did not error", src)
+		}
+	}
+	for src, want := range map[string]string{
+		"(-1) ** 99999999999": "-1",
+		"(-1) ** 99999999998": "1",
+		"0 ** 99999999999":    "0",
+		"2 ** 1000000":        new(big.Int).Lsh(big.NewInt(1), 1000000).String(),
+	} {
+		got, err := e.Big(parseArithm(t, src))
+		if err != nil {
+			t.Errorf("Big(%q) error: %v", src, err)
+		} else if got.String() != want {
+			t.Errorf("Big(%q) got %.20s, want %.20s", src, got.String(), want)
+		}
+	}
+
+	e.Set = func(name, value string) error {
+		vars[name] = value
+		return nil
+	}
+	if _, err := e.Big(parseArithm(t, "y = 2**70, y++, z += y")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := vars["y"], "1180591620717411303425"; got != want {
+		t.Errorf("y got %s, want %s", got, want)
+	}
+	if got, want := vars["z"], "1180591620717411303425"; got != want {
+		t.Errorf("z got %s, want %s", got, want)
+	}
+}
+
+func TestFieldsParts(t *testing.T) {
+	t.Parallel()
+	src := `echo pre$x"q $y" '' $e"" *.go {a,b}c ~ $z`
+	file, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Env: ListEnviron("x=1  2", "y=3", "z=a::b", "HOME=/h", "IFS=: "),
+		FS:  fstest.MapFS{"foo.go": {}, "bar.go": {}},
+	}
+	call := file.Stmts[0].Cmd.(*syntax.CallExpr)
+	fields, err := FieldsParts(cfg, call.Args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, field := range fields {
+		var parts []string
+		for _, part := range field.Parts {
+			parts = append(parts, fmt.Sprintf("%q@%s", part.Value, part.Node.Pos()))
+		}
+		got = append(got, fmt.Sprintf("%q: %s", field.Value, strings.Join(parts, " ")))
+	}
+	want := []string{
+		`"echo": "echo"@1:1`,
+		`"pre1": "pre"@1:6 "1"@1:9`,
+		`"2q 3": "2"@1:9 "q "@1:12 "3"@1:14`,
+		`"": ""@1:18`,
+		`"": ""@1:23`,
+		`"bar.go": "bar.go"@1:26`,
+		`"foo.go": "foo.go"@1:26`,
+		`"ac": "a"@1:31 "c"@1:31`,
+		`"bc": "b"@1:31 "c"@1:31`,
+		`"/h": "/h"@1:38`,
+		`"a": "a"@1:40`,
+		`"": ""@1:40`,
+		`"b": "b"@1:40`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("FieldsParts got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestDeclString(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		vr   Variable
+		want string
+	}{
+		{Variable{Kind: String, Str: "x y"}, `declare -- v="x y"`},
+		{Variable{Kind: String, Str: "a\nb", Exported: true}, `declare -x v=$'a\nb'`},
+		{Variable{Integer: true}, `declare -i v`},
+		{Variable{Kind: NameRef, Str: "other"}, `declare -n v="other"`},
+		{Variable{Kind: Indexed, List: []string{"$x", "", "z"}, Holes: []bool{false, true}}, `declare -a v=([0]="\$x" [2]="z")`},
+		{Variable{Kind: Associative, Map: map[string]string{"k": "1", "a b": "2"}, ReadOnly: true}, `declare -Ar v=(["a b"]="2" [k]="1" )`},
+	}
+	for _, tc := range tests {
+		if got := DeclString("v", tc.vr); got != tc.want {
+			t.Errorf("DeclString(%#v) got %q, want %q", tc.vr, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package expand
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// formatEscape expands the escape sequence at the start of s, which follows a
+// backslash. It returns the expanded string and the number of bytes used from s.
+//
+// If bArg is true, the escape sequence is part of a %b argument,
+// where octal sequences may have an extra leading zero,
+// and "\c" means that all output should stop.
+func formatEscape(s string, bArg bool) (val string, size int, stop bool) {
+	if s == "" {
+		return `\`, 0, false
+	}
+	switch c := s[0]; c {
+	case 'a': // bell
+		return "\a", 1, false
+	case 'b': // backspace
+		return "\b", 1, false
+	case 'e', 'E': // escape
+		return "\x1b", 1, false
+	case 'f': // form feed
+		return "\f", 1, false
+	case 'n': // new line
+		return "\n", 1, false
+	case 'r': // carriage return
+		return "\r", 1, false
+	case 't': // horizontal tab
+		return "\t", 1, false
+	case 'v': // vertical tab
+		return "\v", 1, false
+	case '\\': // just the character
+		return `\`, 1, false
+	case '\'', '"', '?': // just the character, except in %b
+		if !bArg {
+			return s[:1], 1, false
+		}
+	case 'c':
+		if bArg {
+			return "", 1, true
+		}
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		start := 0
+		if bArg && c == '0' {
+			// %b allows \0nnn as well as \nnn.
+			start = 1
+		}
+		end := start
+		for end < len(s) && end < start+3 && s[end] >= '0' && s[end] <= '7' {
+			end++
+		}
+		n, _ := strconv.ParseUint(s[start:end], 8, 16)
+		// if digits don't fit in 8 bits, 0xff like bash
+		return string([]byte{byte(min(n, 0xff))}), end, false
+	case 'x', 'u', 'U':
+		max := 2
+		switch c {
+		case 'u':
+			max = 4
+		case 'U':
+			max = 8
+		}
+		end := 1
+		for end < len(s) && end <= max && isHexDigit(s[end]) {
+			end++
+		}
+		if end == 1 {
+			break // no escape sequence
+		}
+		// can't error
+		n, _ := strconv.ParseUint(s[1:end], 16, 32)
+		if c == 'x' {
+			// always as a single byte
+			return string([]byte{byte(n)}), end, false
+		}
+		return string(rune(n)), end, false
+	}
+	// no escape sequence
+	return `\` + s[:1], 1, false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') ||
+		(c >= 'a' && c <= 'f') ||
+		(c >= 'A' && c <= 'F')
+}
+
+// formatQuote quotes a string like the %q format in bash,
+// so that it can be reused as shell input.
+// Non-printable characters use $'...' quoting, and other special characters
+// are escaped with backslashes.
+func formatQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	var b strings.Builder
+	for rem := s; len(rem) > 0; {
+		r, size := utf8.DecodeRuneInString(rem)
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return formatQuoteDollar(s)
+		}
+		rem = rem[size:]
+	}
+	for i, r := range s {
+		switch r {
+		case ' ', '\t', '\n', '\'', '"', '\\', '|', '&', ';', '(', ')', '<', '>',
+			'!', '{', '}', '*', '[', '?', ']', '^', '$', '`', ',':
+			b.WriteByte('\\')
+		case '~':
+			// Tilde expansion only happens at the start of a word,
+			// or after an equals sign or colon in assignments.
+			if i == 0 || s[i-1] == '=' || s[i-1] == ':' {
+				b.WriteByte('\\')
+			}
+		case '#':
+			// Comments only start at the start of a word.
+			if i == 0 {
+				b.WriteByte('\\')
+			}
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func formatQuoteDollar(s string) string {
+	var b strings.Builder
+	b.WriteString("$'")
+	for rem := s; len(rem) > 0; {
+		r, size := utf8.DecodeRuneInString(rem)
+		switch {
+		case r == '\'', r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\a':
+			b.WriteString(`\a`)
+		case r == '\b':
+			b.WriteString(`\b`)
+		case r == '\x1b':
+			b.WriteString(`\E`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\v':
+			b.WriteString(`\v`)
+		case r != utf8.RuneError && unicode.IsPrint(r):
+			b.WriteString(rem[:size])
+		default:
+			for i := 0; i < size; i++ {
+				fmt.Fprintf(&b, "\\%03o", rem[i])
+			}
+		}
+		rem = rem[size:]
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+// formatInt parses an integer argument for printf(1), which may be in octal
+// or hexadecimal like in C, or a character constant like 'A.
+// If the argument is not entirely a valid number, the number parsed from its
+// prefix is returned along with an error.
+func formatInt(arg string) (int64, error) {
+	s := strings.TrimLeft(arg, " \t\n")
+	if s == "" {
+		return 0, nil
+	}
+	if s[0] == '\'' || s[0] == '"' {
+		if len(s) == 1 {
+			return 0, nil
+		}
+		r, size := utf8.DecodeRuneInString(s[1:])
+		if r == utf8.RuneError && size == 1 {
+			return int64(s[1]), nil
+		}
+		return int64(r), nil
+	}
+	neg := false
+	if s[0] == '+' || s[0] == '-' {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	base := 10
+	switch {
+	case len(s) > 1 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X'):
+		base = 16
+		s = s[2:]
+	case len(s) > 0 && s[0] == '0':
+		base = 8
+	}
+	end := 0
+	for end < len(s) && digitValue(s[end]) < base {
+		end++
+	}
+	if end == 0 {
+		return 0, fmt.Errorf("%s: invalid number", arg)
+	}
+	u, err := strconv.ParseUint(s[:end], base, 64)
+	if err != nil || u > math.MaxInt64 {
+		if neg {
+			return math.MinInt64, fmt.Errorf("%s: numerical result out of range", arg)
+		}
+		return math.MaxInt64, fmt.Errorf("%s: numerical result out of range", arg)
+	}
+	n := int64(u)
+	if neg {
+		n = -n
+	}
+	if end < len(s) {
+		return n, fmt.Errorf("%s: invalid number", arg)
+	}
+	return n, nil
+}
+
+func digitValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return 16
+}
+
+// formatFloat parses a floating point argument for printf(1),
+// which may also be a character constant like 'A.
+func formatFloat(arg string) (float64, error) {
+	s := strings.TrimLeft(arg, " \t\n")
+	if s == "" {
+		return 0, nil
+	}
+	if s[0] == '\'' || s[0] == '"' {
+		n, err := formatInt(s)
+		return float64(n), err
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err, ok := err.(*strconv.NumError); ok && err.Err == strconv.ErrRange {
+		return f, fmt.Errorf("%s: numerical result out of range", arg)
+	}
+	if err != nil {
+		// Like C, use the longest prefix which is a valid number.
+		for end := len(s) - 1; end > 0; end-- {
+			if f2, err2 := strconv.ParseFloat(s[:end], 64); err2 == nil {
+				f = f2
+				break
+			}
+		}
+		return f, fmt.Errorf("%s: invalid number", arg)
+	}
+	return f, nil
+}
+
+// strftime formats a time given in seconds since the Unix epoch following the
+// format of the C function of the same name, in the POSIX locale.
+// Like in bash, -1 means the current time, and an empty format means "%X".
+// The time zone is taken from $TZ.
+func (cfg *Config) strftime(format string, secs int64) string {
+	t := time.Now()
+	// -2 is the time the shell started, which we don't track.
+	if secs != -1 && secs != -2 {
+		t = time.Unix(secs, 0)
+	}
+	if tz := cfg.Env.Get("TZ"); tz.IsSet() {
+		loc, err := time.LoadLocation(tz.String())
+		if err != nil {
+			loc = time.UTC
+		}
+		t = t.In(loc)
+	}
+	if format == "" {
+		format = "%X"
+	}
+	var b strings.Builder
+	strftime(&b, format, t)
+	return b.String()
+}
+
+func strftime(b *strings.Builder, format string, t time.Time) {
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			b.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch c := format[i]; c {
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b', 'h':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'c':
+			strftime(b, "%a %b %e %H:%M:%S %Y", t)
+		case 'C':
+			fmt.Fprintf(b, "%02d", t.Year()/100)
+		case 'd':
+			fmt.Fprintf(b, "%02d", t.Day())
+		case 'D', 'x':
+			strftime(b, "%m/%d/%y", t)
+		case 'e':
+			fmt.Fprintf(b, "%2d", t.Day())
+		case 'F':
+			strftime(b, "%Y-%m-%d", t)
+		case 'g':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(b, "%02d", year%100)
+		case 'G':
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(b, "%d", year)
+		case 'H':
+			fmt.Fprintf(b, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(b, "%02d", (t.Hour()+11)%12+1)
+		case 'j':
+			fmt.Fprintf(b, "%03d", t.YearDay())
+		case 'k':
+			fmt.Fprintf(b, "%2d", t.Hour())
+		case 'l':
+			fmt.Fprintf(b, "%2d", (t.Hour()+11)%12+1)
+		case 'm':
+			fmt.Fprintf(b, "%02d", t.Month())
+		case 'M':
+			fmt.Fprintf(b, "%02d", t.Minute())
+		case 'n':
+			b.WriteByte('\n')
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'P':
+			b.WriteString(t.Format("pm"))
+		case 'r':
+			strftime(b, "%I:%M:%S %p", t)
+		case 'R':
+			strftime(b, "%H:%M", t)
+		case 's':
+			fmt.Fprintf(b, "%d", t.Unix())
+		case 'S':
+			fmt.Fprintf(b, "%02d", t.Second())
+		case 't':
+			b.WriteByte('\t')
+		case 'T', 'X':
+			strftime(b, "%H:%M:%S", t)
+		case 'u':
+			fmt.Fprintf(b, "%d", (int(t.Weekday())+6)%7+1)
+		case 'U':
+			fmt.Fprintf(b, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'V':
+			_, week := t.ISOWeek()
+			fmt.Fprintf(b, "%02d", week)
+		case 'w':
+			fmt.Fprintf(b, "%d", t.Weekday())
+		case 'W':
+			fmt.Fprintf(b, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+		case 'y':
+			fmt.Fprintf(b, "%02d", t.Year()%100)
+		case 'Y':
+			fmt.Fprintf(b, "%d", t.Year())
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case '%':
+			b.WriteByte('%')
+		default: // unknown conversions are kept as-is
+			b.WriteByte('%')
+			b.WriteByte(c)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package expand
+
+import "mvdan.cc/sh/v3/syntax"
+
+// UseKind describes what kind of [Use] an expansion made.
+type UseKind uint8
+
+const (
+	// UseVar is a parameter which is read, such as "foo" in "$foo",
+	// "${foo:-default}", "$((foo + 1))", or "${!ref}" with ref=foo.
+	// Special parameters like "$1" or "$?" are included,
+	// as is "HOME" when expanding a tilde like in "~/bin".
+	UseVar UseKind = iota + 1
+
+	// UseCmdSubst is a command substitution which is run, like "$(cmd)".
+	UseCmdSubst
+
+	// UseProcSubst is a process substitution which is started, like "<(cmd)".
+	UseProcSubst
+
+	// UseGlob is a pattern used for pathname expansion, like "*.go".
+	UseGlob
+)
+
+// Use is something which an expansion consulted, reported via [Config.Observe].
+type Use struct {
+	Kind UseKind
+
+	// Name is the name of the parameter for UseVar,
+	// and the pattern for UseGlob, where quoted characters are escaped
+	// as per [pattern.QuoteMeta]. It is empty for the other kinds.
+	Name string
+
+	// Node is the syntax node which caused the use, such as the
+	// [syntax.ParamExp] for "$foo", or the [syntax.Word] for a pattern.
+	// Its position is where the use happened.
+	Node syntax.Node
+}
+
+// observe reports a use via [Config.Observe], if set.
+func (cfg *Config) observe(kind UseKind, name string, node syntax.Node) {
+	if cfg.Observe != nil {
+		cfg.Observe(Use{Kind: kind, Name: name, Node: node})
+	}
+}
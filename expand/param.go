@@ -5,7 +5,6 @@ package expand
 
 import (
 	"fmt"
-	"regexp"
 	"slices"
 	"strconv"
 	"strings"
@@ -32,6 +31,18 @@ func (u UnsetParameterError) Error() string {
 	return fmt.Sprintf("%s: %s", u.Node.Param.Value, u.Message)
 }
 
+// SubstringError is returned when the length of a substring expansion like
+// "${name:offset:length}" is negative and counts back past the offset,
+// or when it is negative at all when slicing an array like "${name[@]:0:-1}".
+// Like Bash, shells should treat it as a fatal error.
+type SubstringError struct {
+	Length int
+}
+
+func (e SubstringError) Error() string {
+	return fmt.Sprintf("%d: substring expression < 0", e.Length)
+}
+
 func overridingUnset(pe *syntax.ParamExp) bool {
 	if pe.Exp == nil {
 		return false
@@ -47,6 +58,13 @@ func overridingUnset(pe *syntax.ParamExp) bool {
 }
 
 func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
+	str, _, err := cfg.paramExpElems(pe)
+	return str, err
+}
+
+// paramExpElems is like paramExp, but when the expansion results in multiple
+// elements, such as with "${foo[@]@Q}", it also returns them separately.
+func (cfg *Config) paramExpElems(pe *syntax.ParamExp) (string, []string, error) {
 	oldParam := cfg.curParam
 	cfg.curParam = pe
 	defer func() { cfg.curParam = oldParam }()
@@ -69,10 +87,14 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 	default:
 		vr = cfg.Env.Get(name)
 	}
+	cfg.observe(UseVar, name, pe)
 	orig := vr
-	_, vr = vr.Resolve(cfg.Env)
+	resolved, vr := vr.Resolve(cfg.Env)
+	if resolved == "" {
+		resolved = name
+	}
 	if cfg.NoUnset && vr.Kind == Unset && !overridingUnset(pe) {
-		return "", UnsetParameterError{
+		return "", nil, UnsetParameterError{
 			Node:    pe,
 			Message: "unbound variable",
 		}
@@ -84,13 +106,13 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 		if pe.Slice.Offset != nil {
 			sliceOffset, err = Arithm(cfg, pe.Slice.Offset)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 		}
 		if pe.Slice.Length != nil {
 			sliceLen, err = Arithm(cfg, pe.Slice.Length)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 		}
 	}
@@ -112,23 +134,18 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 		case Indexed:
 			indexAllElements = true
 			callVarInd = false
-			elems = vr.List
-			slicePos := func(n int) int {
-				if n < 0 {
-					n = len(elems) + n
-					if n < 0 {
-						n = len(elems)
-					}
-				} else if n > len(elems) {
-					n = len(elems)
+			elems = vr.Elems()
+			if pe.Slice != nil {
+				list, holes := vr.List, vr.Holes
+				if name == "@" || name == "*" {
+					// Like in Bash, offset zero is $0,
+					// so that "${@:1}" is all the positional parameters.
+					list, holes = append([]string{cfg.envGet("0")}, list...), nil
+				}
+				var err error
+				if elems, err = sliceElems(list, holes, sliceOffset, sliceLen, pe.Slice.Length != nil); err != nil {
+					return "", nil, err
 				}
-				return n
-			}
-			if pe.Slice != nil && pe.Slice.Offset != nil {
-				elems = elems[slicePos(sliceOffset):]
-			}
-			if pe.Slice != nil && pe.Slice.Length != nil {
-				elems = elems[:slicePos(sliceLen)]
 			}
 			str = strings.Join(elems, " ")
 		}
@@ -137,7 +154,18 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 		var err error
 		str, err = cfg.varInd(vr, index)
 		if err != nil {
-			return "", err
+			return "", nil, err
+		}
+	}
+	if vr.Kind == Associative && callVarInd {
+		switch nodeLit(index) {
+		case "@", "*":
+			indexAllElements = true
+			elems = make([]string, 0, len(vr.Map))
+			for _, val := range vr.Map {
+				elems = append(elems, val)
+			}
+			slices.Sort(elems)
 		}
 	}
 	if !indexAllElements {
@@ -161,10 +189,8 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 		case orig.Kind == NameRef:
 			strs = append(strs, orig.Str)
 		case pe.Index != nil && vr.Kind == Indexed:
-			for i, e := range vr.List {
-				if e != "" {
-					strs = append(strs, strconv.Itoa(i))
-				}
+			for _, i := range vr.Indices() {
+				strs = append(strs, strconv.Itoa(i))
 			}
 		case pe.Index != nil && vr.Kind == Associative:
 			// TODO: use maps.Keys
@@ -172,10 +198,11 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 				strs = append(strs, k)
 			}
 		case vr.Kind == Unset:
-			return "", fmt.Errorf("invalid indirect expansion")
+			return "", nil, fmt.Errorf("invalid indirect expansion")
 		case str == "":
-			return "", nil
+			return "", nil, nil
 		default:
+			cfg.observe(UseVar, str, pe)
 			vr = cfg.Env.Get(str)
 			strs = append(strs, vr.String())
 		}
@@ -183,54 +210,73 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 		str = strings.Join(strs, " ")
 	case pe.Slice != nil:
 		if callVarInd {
-			slicePos := func(n int) int {
-				if n < 0 {
-					n = len(str) + n
-					if n < 0 {
-						n = len(str)
-					}
-				} else if n > len(str) {
-					n = len(str)
-				}
-				return n
-			}
-			if pe.Slice.Offset != nil {
-				str = str[slicePos(sliceOffset):]
-			}
-			if pe.Slice.Length != nil {
-				str = str[:slicePos(sliceLen)]
+			var err error
+			if str, err = sliceString(str, sliceOffset, sliceLen, pe.Slice.Length != nil); err != nil {
+				return "", nil, err
 			}
 		} // else, elems are already sliced
 	case pe.Repl != nil:
-		orig, err := Pattern(cfg, pe.Repl.Orig)
+		origWord := pe.Repl.Orig
+		// A single slash followed by '#' or '%' anchors the pattern
+		// to the start or end of the string, respectively.
+		var anchor byte
+		if !pe.Repl.All && origWord != nil {
+			if lit, ok := origWord.Parts[0].(*syntax.Lit); ok && lit.Value != "" &&
+				(lit.Value[0] == '#' || lit.Value[0] == '%') {
+				anchor = lit.Value[0]
+				lit2 := *lit
+				lit2.Value = lit.Value[1:]
+				origWord = &syntax.Word{Parts: append([]syntax.WordPart{&lit2}, origWord.Parts[1:]...)}
+			}
+		}
+		orig, err := Pattern(cfg, origWord)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
-		if orig == "" {
+		if orig == "" && anchor == 0 {
 			break // nothing to replace
 		}
-		with, err := Literal(cfg, pe.Repl.With)
-		if err != nil {
-			return "", err
+		var with []string
+		if cfg.PatSubReplacement {
+			if with, err = cfg.replacement(pe.Repl.With); err != nil {
+				return "", nil, err
+			}
+		} else {
+			s, err := Literal(cfg, pe.Repl.With)
+			if err != nil {
+				return "", nil, err
+			}
+			with = []string{s}
+		}
+		for i, s := range with {
+			with[i] = pattern.QuoteReplacement(s)
 		}
-		n := 1
-		if pe.Repl.All {
-			n = -1
+		withStr := strings.Join(with, "&")
+		op := anchor
+		if op == 0 && pe.Repl.All {
+			op = '/'
 		}
-		locs := findAllIndex(orig, str, n)
-		buf := cfg.strBuilder()
-		last := 0
-		for _, loc := range locs {
-			buf.WriteString(str[last:loc[0]])
-			buf.WriteString(with)
-			last = loc[1]
+		// Like with other operators, each element is replaced separately.
+		repl := make([]string, len(elems))
+		for i, elem := range elems {
+			// Invalid patterns simply don't match, leaving elem as-is.
+			repl[i], _ = pattern.Replace(elem, orig, withStr, cfg.matchMode(), op)
 		}
-		buf.WriteString(str[last:])
-		str = buf.String()
+		elems = repl
+		str = strings.Join(repl, " ")
 	case pe.Exp != nil:
-		arg, err := Literal(cfg, pe.Exp.Word)
+		expandArg := Literal
+		switch pe.Exp.Op {
+		case syntax.RemSmallPrefix, syntax.RemLargePrefix,
+			syntax.RemSmallSuffix, syntax.RemLargeSuffix,
+			syntax.UpperFirst, syntax.UpperAll,
+			syntax.LowerFirst, syntax.LowerAll:
+			// The argument is a pattern, so quoted parts must match literally.
+			expandArg = Pattern
+		}
+		arg, err := expandArg(cfg, pe.Exp.Word)
 		if err != nil {
-			return "", err
+			return "", nil, err
 		}
 		switch op := pe.Exp.Op; op {
 		case syntax.AlternateUnsetOrNull:
@@ -258,7 +304,7 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 			fallthrough
 		case syntax.ErrorUnsetOrNull:
 			if str == "" {
-				return "", UnsetParameterError{
+				return "", nil, UnsetParameterError{
 					Node:    pe,
 					Message: arg,
 				}
@@ -271,7 +317,7 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 		case syntax.AssignUnsetOrNull:
 			if str == "" {
 				if err := cfg.envSet(name, arg); err != nil {
-					return "", err
+					return "", nil, err
 				}
 				str = arg
 			}
@@ -279,93 +325,411 @@ func (cfg *Config) paramExp(pe *syntax.ParamExp) (string, error) {
 			syntax.RemSmallSuffix, syntax.RemLargeSuffix:
 			suffix := op == syntax.RemSmallSuffix || op == syntax.RemLargeSuffix
 			small := op == syntax.RemSmallPrefix || op == syntax.RemSmallSuffix
+			elems = slices.Clone(elems) // don't modify the variable's value
 			for i, elem := range elems {
-				elems[i] = removePattern(elem, arg, suffix, small)
+				elems[i] = removePattern(elem, arg, cfg.matchMode(), suffix, small)
 			}
 			str = strings.Join(elems, " ")
 		case syntax.UpperFirst, syntax.UpperAll,
 			syntax.LowerFirst, syntax.LowerAll:
 
-			caseFunc := unicode.ToLower
+			caseFunc := cfg.toLower
 			if op == syntax.UpperFirst || op == syntax.UpperAll {
-				caseFunc = unicode.ToUpper
+				caseFunc = cfg.toUpper
 			}
 			all := op == syntax.UpperAll || op == syntax.LowerAll
 
-			// empty string means '?'; nothing to do there
-			expr, err := pattern.Regexp(arg, 0)
-			if err != nil {
-				return str, nil
-			}
-			rx := regexp.MustCompile(expr)
-
+			elems = slices.Clone(elems) // don't modify the variable's value
 			for i, elem := range elems {
-				rs := []rune(elem)
-				for ri, r := range rs {
-					if rx.MatchString(string(r)) {
-						rs[ri] = caseFunc(r)
-						if !all {
-							break
-						}
-					}
-				}
-				elems[i] = string(rs)
+				// An invalid pattern does not match, so nothing is converted.
+				elems[i], _ = pattern.MapCase(elem, arg, cfg.matchMode(), all, caseFunc)
 			}
 			str = strings.Join(elems, " ")
 		case syntax.OtherParamOps:
-			switch arg {
+			elems, err = cfg.paramOp(pe, arg, resolved, vr, elems)
+			if err != nil {
+				return "", nil, err
+			}
+			str = strings.Join(elems, " ")
+		}
+	}
+	if !indexAllElements || pe.Length || pe.Excl {
+		return str, nil, nil
+	}
+	return str, elems, nil
+}
+
+// paramOp applies an operator like "Q" in "${foo@Q}" to each of the elements
+// of a parameter expansion. The variable vr named name is the parameter,
+// after following any name references.
+func (cfg *Config) paramOp(pe *syntax.ParamExp, op, name string, vr Variable, elems []string) ([]string, error) {
+	positional, all := false, false
+	switch pe.Param.Value {
+	case "@", "*":
+		positional, all = true, true
+	}
+	switch nodeLit(pe.Index) {
+	case "@", "*":
+		all = true
+	}
+	// An empty element might not be set, like in "${foo[3]@Q}"
+	// when foo only has one element, in which case the result is empty.
+	isSet := vr.IsSet() && (all || vr.Kind == String || elems[0] != "")
+	if !isSet && pe.Index == nil {
+		switch vr.Kind {
+		case Indexed:
+			isSet = len(vr.List) > 0
+		case Associative:
+			_, isSet = vr.Map["0"]
+		}
+	}
+	flags := ""
+	if !positional {
+		flags = attrFlags(vr)
+	}
+
+	switch op {
+	case "Q", "E", "P", "U", "u", "L":
+		if !isSet {
+			return []string{""}, nil
+		}
+		res := make([]string, len(elems))
+		for i, elem := range elems {
+			var err error
+			switch op {
 			case "Q":
-				str, err = syntax.Quote(str, syntax.LangBash)
-				if err != nil {
-					// Is this even possible? If a user runs into this panic,
-					// it's most likely a bug we need to fix.
-					panic(err)
-				}
+				elem = quoteParam(elem)
 			case "E":
-				tail := str
-				var rns []rune
-				for tail != "" {
-					var rn rune
-					rn, _, tail, _ = strconv.UnquoteChar(tail, 0)
-					rns = append(rns, rn)
+				elem, _, err = Format(cfg, elem, nil)
+			case "P":
+				elem, err = Prompt(cfg, elem)
+			case "U":
+				elem = strings.Map(cfg.toUpper, elem)
+			case "L":
+				elem = strings.Map(cfg.toLower, elem)
+			case "u":
+				if r, size := utf8.DecodeRuneInString(elem); size > 0 {
+					elem = string(cfg.toUpper(r)) + elem[size:]
 				}
-				str = string(rns)
-			case "P", "A", "a":
-				panic(fmt.Sprintf("unhandled @%s param expansion", arg))
-			default:
-				panic(fmt.Sprintf("unexpected @%s param expansion", arg))
 			}
+			if err != nil {
+				return nil, err
+			}
+			res[i] = elem
+		}
+		return res, nil
+	case "a":
+		if !vr.IsSet() && flags == "" {
+			return []string{""}, nil
+		}
+		if !all {
+			return []string{flags}, nil
+		}
+		res := make([]string, len(elems))
+		for i := range res {
+			res[i] = flags
+		}
+		return res, nil
+	case "A":
+		var sb strings.Builder
+		switch {
+		case positional:
+			if len(elems) == 0 {
+				return []string{""}, nil
+			}
+			sb.WriteString("set --")
+			for _, elem := range elems {
+				sb.WriteByte(' ')
+				sb.WriteString(quoteParam(elem))
+			}
+			return []string{sb.String()}, nil
+		case !syntax.ValidName(name), !isSet && flags == "":
+			return []string{""}, nil
+		case all && (vr.Kind == Indexed || vr.Kind == Associative):
+			return []string{DeclString(name, vr)}, nil
+		case flags == "":
+			return []string{name + "=" + quoteParam(elems[0])}, nil
+		}
+		sb.WriteString("declare -")
+		sb.WriteString(flags)
+		sb.WriteByte(' ')
+		sb.WriteString(name)
+		if isSet {
+			sb.WriteByte('=')
+			sb.WriteString(quoteParam(elems[0]))
+		}
+		return []string{sb.String()}, nil
+	case "K", "k":
+		if positional || !all || (vr.Kind != Indexed && vr.Kind != Associative) {
+			return cfg.paramOp(pe, "Q", name, vr, elems)
+		}
+		var res []string
+		var sb strings.Builder
+		eachKeyValue(vr, func(key, val string) {
+			if op == "k" {
+				res = append(res, key, val)
+				return
+			}
+			if vr.Kind == Associative {
+				// Like Bash, associative arrays have a trailing space.
+				fmt.Fprintf(&sb, "%s %s ", key, declQuote(val))
+				return
+			}
+			if sb.Len() > 0 {
+				sb.WriteByte(' ')
+			}
+			fmt.Fprintf(&sb, "%s %s", key, declQuote(val))
+		})
+		if op == "K" {
+			res = []string{sb.String()}
 		}
+		return res, nil
 	}
-	return str, nil
+	return nil, fmt.Errorf("${%s@%s}: bad substitution", pe.Param.Value, op)
 }
 
-func removePattern(str, pat string, fromEnd, shortest bool) string {
-	var mode pattern.Mode
-	if shortest {
-		mode |= pattern.Shortest
+// toUpper converts a character to uppercase via [Config.ToUpper] if set,
+// or [unicode.ToUpper] otherwise.
+func (cfg *Config) toUpper(r rune) rune {
+	if cfg.ToUpper != nil {
+		return cfg.ToUpper(r)
 	}
-	expr, err := pattern.Regexp(pat, mode)
-	if err != nil {
-		return str
+	return unicode.ToUpper(r)
+}
+
+// toLower is like toUpper, for [Config.ToLower] and [unicode.ToLower].
+func (cfg *Config) toLower(r rune) rune {
+	if cfg.ToLower != nil {
+		return cfg.ToLower(r)
 	}
-	switch {
-	case fromEnd && shortest:
-		// use .* to get the right-most shortest match
-		expr = ".*(" + expr + ")$"
-	case fromEnd:
-		// simple suffix
-		expr = "(" + expr + ")$"
-	default:
-		// simple prefix
-		expr = "^(" + expr + ")"
+	return unicode.ToLower(r)
+}
+
+// DeclString formats a variable as the "declare" command which recreates it,
+// like "declare -p" does in Bash, such as `declare -a list=([0]="x" [2]="y")`.
+// Variables which have attributes but are not set, like after "declare -i n",
+// are formatted without a value.
+func DeclString(name string, vr Variable) string {
+	var sb strings.Builder
+	sb.WriteString("declare -")
+	if flags := attrFlags(vr); flags != "" {
+		sb.WriteString(flags)
+	} else {
+		sb.WriteByte('-')
 	}
-	// no need to check error as Translate returns one
-	rx := regexp.MustCompile(expr)
-	if loc := rx.FindStringSubmatchIndex(str); loc != nil {
-		// remove the original pattern (the submatch)
-		str = str[:loc[2]] + str[loc[3]:]
+	sb.WriteByte(' ')
+	sb.WriteString(name)
+	switch vr.Kind {
+	case String, NameRef:
+		sb.WriteByte('=')
+		sb.WriteString(declQuote(vr.Str))
+	case Indexed, Associative:
+		sb.WriteString("=(")
+		first := true
+		eachKeyValue(vr, func(key, val string) {
+			if vr.Kind == Indexed {
+				if !first {
+					sb.WriteByte(' ')
+				}
+				first = false
+				fmt.Fprintf(&sb, "[%s]=%s", key, declQuote(val))
+			} else {
+				// Like Bash, associative arrays have a trailing space.
+				fmt.Fprintf(&sb, "[%s]=%s ", key, declQuote(val))
+			}
+		})
+		sb.WriteByte(')')
+	}
+	return sb.String()
+}
+
+// eachKeyValue calls fn with each of the keys and values of an array,
+// where the keys of an associative array are sorted and quoted if needed.
+func eachKeyValue(vr Variable, fn func(key, val string)) {
+	switch vr.Kind {
+	case Indexed:
+		for _, i := range vr.Indices() {
+			fn(strconv.Itoa(i), vr.List[i])
+		}
+	case Associative:
+		keys := make([]string, 0, len(vr.Map))
+		for key := range vr.Map {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		for _, key := range keys {
+			quoted := key
+			if key == "" || strings.Trim(key, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_") != "" {
+				quoted = declQuote(key)
+			}
+			fn(quoted, vr.Map[key])
+		}
+	}
+}
+
+// attrFlags returns the attributes of a variable as the flags which
+// "declare" accepts, like "ar" for a read-only indexed array.
+func attrFlags(vr Variable) string {
+	var sb strings.Builder
+	switch vr.Kind {
+	case Indexed:
+		sb.WriteByte('a')
+	case Associative:
+		sb.WriteByte('A')
+	}
+	if vr.Integer {
+		sb.WriteByte('i')
+	}
+	if vr.Kind == NameRef {
+		sb.WriteByte('n')
+	}
+	if vr.ReadOnly {
+		sb.WriteByte('r')
+	}
+	if vr.Exported {
+		sb.WriteByte('x')
+	}
+	if vr.Lowercase {
+		sb.WriteByte('l')
+	}
+	if vr.Uppercase {
+		sb.WriteByte('u')
+	}
+	return sb.String()
+}
+
+// quoteParam quotes a string like "${foo@Q}" does, using single quotes unless
+// the string contains non-printable characters, in which case it uses the
+// $'...' form with escape sequences.
+func quoteParam(s string) string {
+	printable := utf8.ValidString(s)
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			printable = false
+			break
+		}
+	}
+	if printable {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	var sb strings.Builder
+	sb.WriteString("$'")
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		switch r {
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\x1b':
+			sb.WriteString(`\E`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\v':
+			sb.WriteString(`\v`)
+		case '\\', '\'':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		default:
+			if (r == utf8.RuneError && size == 1) || !unicode.IsPrint(r) {
+				// Like Bash, use octal escapes for each byte.
+				for i := 0; i < size; i++ {
+					fmt.Fprintf(&sb, "\\%03o", s[i])
+				}
+			} else {
+				sb.WriteString(s[:size])
+			}
+		}
+		s = s[size:]
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+// declQuote quotes a value like "declare -p" does, using double quotes unless
+// the value contains non-printable characters.
+func declQuote(s string) string {
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return quoteParam(s)
+		}
+	}
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\', '$', '`':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// replacement expands the replacement string of "${var/pattern/string}" when
+// [Config.PatSubReplacement] is enabled. The result is split at each unquoted
+// "&", so that joining it with the matched text gives the final replacement.
+func (cfg *Config) replacement(word *syntax.Word) ([]string, error) {
+	if word == nil {
+		return []string{""}, nil
+	}
+	var with []string
+	var buf strings.Builder // not cfg.strBuilder, as Literal below uses it
+	// split writes s, replacing any "&" unless escaped with a backslash.
+	// Other backslashes are kept, unless unescape is true.
+	split := func(s string, unescape bool) {
+		for i := 0; i < len(s); i++ {
+			switch b := s[i]; {
+			case b == '\\' && i+1 < len(s):
+				if i++; s[i] != '&' && !unescape {
+					buf.WriteByte('\\')
+				}
+				buf.WriteByte(s[i])
+			case b == '&':
+				with = append(with, buf.String())
+				buf.Reset()
+			default:
+				buf.WriteByte(b)
+			}
+		}
+	}
+	for _, wp := range word.Parts {
+		switch wp := wp.(type) {
+		case *syntax.Lit:
+			// Quote removal applies to literal backslashes.
+			split(wp.Value, true)
+		case *syntax.SglQuoted, *syntax.DblQuoted:
+			s, err := Literal(cfg, &syntax.Word{Parts: []syntax.WordPart{wp}})
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteString(s)
+		default:
+			// Unquoted expansions may result in "&" too.
+			s, err := Literal(cfg, &syntax.Word{Parts: []syntax.WordPart{wp}})
+			if err != nil {
+				return nil, err
+			}
+			split(s, false)
+		}
 	}
+	return append(with, buf.String()), nil
+}
+
+func removePattern(str, pat string, mode pattern.Mode, fromEnd, shortest bool) string {
+	trim := pattern.TrimPrefix
+	if fromEnd {
+		trim = pattern.TrimSuffix
+	}
+	// An invalid pattern does not match, so nothing is removed.
+	str, _ = trim(str, pat, mode, !shortest)
 	return str
 }
 
@@ -375,6 +739,10 @@ func (cfg *Config) varInd(vr Variable, idx syntax.ArithmExpr) (string, error) {
 	}
 	switch vr.Kind {
 	case String:
+		switch nodeLit(idx) {
+		case "*", "@":
+			return vr.Str, nil
+		}
 		n, err := Arithm(cfg, idx)
 		if err != nil {
 			return "", err
@@ -385,7 +753,7 @@ func (cfg *Config) varInd(vr Variable, idx syntax.ArithmExpr) (string, error) {
 	case Indexed:
 		switch nodeLit(idx) {
 		case "*", "@":
-			return strings.Join(vr.List, " "), nil
+			return strings.Join(vr.Elems(), " "), nil
 		}
 		i, err := Arithm(cfg, idx)
 		if err != nil {
@@ -430,3 +798,62 @@ func (cfg *Config) namesByPrefix(prefix string) []string {
 	})
 	return names
 }
+
+// sliceStart returns the start of a slice with the given offset over n
+// elements, where a negative offset counts back from the end.
+// An offset out of range results in an empty slice.
+func sliceStart(n, offset int) int {
+	if offset < 0 {
+		offset += n
+		if offset < 0 {
+			return n
+		}
+	}
+	return min(offset, n)
+}
+
+// sliceElems implements "${name[@]:offset:length}", given the elements of an
+// indexed array and its holes, as per [Variable.Holes].
+// Like in Bash, the offset is an index, so the elements start at the first one
+// which is set at or after it, while the length counts the elements which are
+// set. Unlike with strings, a negative length is an error.
+func sliceElems(list []string, holes []bool, offset, length int, hasLength bool) ([]string, error) {
+	if hasLength && length < 0 {
+		return nil, SubstringError{Length: length}
+	}
+	start := sliceStart(len(list), offset)
+	list, holes = list[start:], holes[min(start, len(holes)):]
+	if !slices.Contains(holes, true) {
+		if hasLength {
+			list = list[:min(length, len(list))]
+		}
+		return list, nil
+	}
+	var elems []string
+	for i, s := range list {
+		if hasLength && len(elems) == length {
+			break
+		}
+		if i >= len(holes) || !holes[i] {
+			elems = append(elems, s)
+		}
+	}
+	return elems, nil
+}
+
+// sliceString implements "${name:offset:length}",
+// where a negative length counts back from the end of the string.
+func sliceString(s string, offset, length int, hasLength bool) (string, error) {
+	start := sliceStart(len(s), offset)
+	if !hasLength {
+		return s[start:], nil
+	}
+	end := start + length
+	if length < 0 {
+		end = len(s) + length
+		if end < start {
+			return "", SubstringError{Length: length}
+		}
+	}
+	return s[start:min(end, len(s))], nil
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package expand
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Prompt expands a prompt string such as the value of $PS1, like Bash does
+// before printing it in interactive mode.
+//
+// First, the backslash escape sequences which are special in prompts are
+// decoded, such as "\u" for the user name, "\h" for the host name,
+// "\w" for the current directory, "\$" for "#" or "$" depending on whether the
+// user is root, "\D{format}" for the current time via strftime, and the
+// non-printing character delimiters "\[" and "\]", which are removed.
+// Then, the result is expanded as if it were within double quotes, like [Document],
+// which is what Bash does with its promptvars option set, its default.
+// Text inserted by the escape sequences is not expanded again.
+//
+// Like in Bash, the user name is taken from $USER, the host name from
+// $HOSTNAME, the effective user ID from $EUID, and the current directory from
+// $PWD, falling back to the system when unset.
+// The escapes "\j", "\l", "\v", "\V", "\!", and "\#" are not supported,
+// as they depend on the state of an interactive shell, and are kept as-is.
+//
+// The config specifies shell expansion options; nil behaves the same as an
+// empty config.
+func Prompt(cfg *Config, s string) (string, error) {
+	cfg = prepareConfig(cfg)
+	src := cfg.decodePrompt(s)
+	if src == "" {
+		return "", nil
+	}
+	word, err := syntax.NewParser().Document(strings.NewReader(src))
+	if err != nil {
+		return "", err
+	}
+	return Document(cfg, word)
+}
+
+// decodePrompt decodes the backslash escape sequences in a prompt string,
+// quoting the text they insert so that it is not expanded later.
+func (cfg *Config) decodePrompt(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'e':
+			b.WriteByte('\x1b')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case '\\':
+			b.WriteString(`\\`)
+		case '[', ']':
+			// Delimiters for non-printing characters, only used by readline.
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			end := i + 1
+			for end < len(s) && end < i+3 && s[end] >= '0' && s[end] <= '7' {
+				end++
+			}
+			n, _ := strconv.ParseUint(s[i:end], 8, 8)
+			writePromptText(&b, string([]byte{byte(n)}))
+			i = end - 1
+		case 'd':
+			writePromptText(&b, cfg.strftime("%a %b %d", -1))
+		case 't':
+			writePromptText(&b, cfg.strftime("%H:%M:%S", -1))
+		case 'T':
+			writePromptText(&b, cfg.strftime("%I:%M:%S", -1))
+		case '@':
+			writePromptText(&b, cfg.strftime("%I:%M %p", -1))
+		case 'A':
+			writePromptText(&b, cfg.strftime("%H:%M", -1))
+		case 'D':
+			end := -1
+			if i+1 < len(s) && s[i+1] == '{' {
+				end = strings.IndexByte(s[i+2:], '}')
+			}
+			if end < 0 {
+				// Not a valid "\D{format}", so keep it as-is.
+				b.WriteString(`\D`)
+				break
+			}
+			format := s[i+2 : i+2+end]
+			writePromptText(&b, cfg.strftime(format, -1))
+			i += 2 + end
+		case 'h', 'H':
+			host := cfg.envGet("HOSTNAME")
+			if host == "" {
+				host, _ = os.Hostname()
+			}
+			if c == 'h' {
+				host, _, _ = strings.Cut(host, ".")
+			}
+			writePromptText(&b, host)
+		case 'u':
+			name := cfg.envGet("USER")
+			if name == "" {
+				if u, err := user.Current(); err == nil {
+					name = u.Username
+				}
+			}
+			writePromptText(&b, name)
+		case 'w', 'W':
+			dir := cfg.envGet("PWD")
+			if dir == "" {
+				dir, _ = os.Getwd()
+			}
+			home := cfg.envGet("HOME")
+			switch {
+			case home != "" && dir == home:
+				dir = "~"
+			case c == 'W':
+				if dir != "/" {
+					dir = filepath.Base(dir)
+				}
+			case home != "" && strings.HasPrefix(dir, home+"/"):
+				dir = "~" + dir[len(home):]
+			}
+			writePromptText(&b, dir)
+		case 's':
+			writePromptText(&b, filepath.Base(cfg.envGet("0")))
+		case '$':
+			euid := cfg.envGet("EUID")
+			if euid == "" {
+				euid = strconv.Itoa(os.Geteuid())
+			}
+			if euid == "0" {
+				b.WriteByte('#')
+			} else {
+				b.WriteString(`\$`)
+			}
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// writePromptText writes text inserted by a prompt escape sequence, quoting
+// the characters which would be special within double quotes.
+func writePromptText(b *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '\\', '$', '`':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
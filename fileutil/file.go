@@ -6,17 +6,24 @@
 package fileutil
 
 import (
+	"bytes"
 	"io/fs"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strings"
-)
 
-var (
-	shebangRe = regexp.MustCompile(`^#!\s?/(usr/)?bin/(env\s+)?(sh|bash|mksh|bats|zsh)(\s|$)`)
-	extRe     = regexp.MustCompile(`\.(sh|bash|mksh|bats|zsh)$`)
+	"mvdan.cc/sh/v3/internal/shebang"
+	"mvdan.cc/sh/v3/syntax"
 )
 
+var extRe = regexp.MustCompile(`\.(sh|bash|mksh|bats|zsh)$`)
+
+// ShebangLen is how many bytes from the start of a file are enough to detect
+// its shebang via [Shebang] or [DetectScript], such as with
+// "#!/usr/local/bin/env -S busybox sh".
+const ShebangLen = 128
+
 // TODO: consider removing HasShebang in favor of Shebang in v4
 
 // HasShebang reports whether bs begins with a valid shell shebang.
@@ -30,12 +37,9 @@ func HasShebang(bs []byte) bool {
 //
 // For instance, it returns "sh" for "#!/bin/sh",
 // and "bash" for "#!/usr/bin/env bash".
+// POSIX shells like dash and "busybox sh" are reported as "sh".
 func Shebang(bs []byte) string {
-	m := shebangRe.FindSubmatch(bs)
-	if m == nil {
-		return ""
-	}
-	return string(m[3])
+	return shebang.Shell(bs)
 }
 
 // ScriptConfidence defines how likely a file is to be a shell script,
@@ -83,3 +87,55 @@ func CouldBeScript2(entry fs.DirEntry) ScriptConfidence {
 		return ConfIfShebang
 	}
 }
+
+// DetectScript reports which shell language variant a file is likely written
+// in, and how likely it is to be a shell script, given its name and the start
+// of its contents. The language is [syntax.LangAuto] unless the confidence is
+// [ConfIsScript].
+//
+// The contents may be nil if they have not been read yet, in which case
+// a file without a shell extension results in [ConfIfShebang], and its
+// contents should be given in a second call. The first [ShebangLen] bytes
+// are enough.
+//
+// A shebang takes precedence over a ".sh" extension, as such files are
+// often written for a specific shell like Bash. Zsh completion files,
+// which start with a "#compdef" line and usually have no extension,
+// are detected as well. Since the parser does not support Zsh, Zsh scripts
+// are reported as [syntax.LangBash], the closest variant, which is also how
+// shfmt formats them.
+func DetectScript(name string, src []byte) (lang syntax.LangVariant, conf ScriptConfidence) {
+	name = filepath.Base(name)
+	if m := extRe.FindStringSubmatch(name); m != nil {
+		shell := m[1]
+		if shell == "sh" {
+			if sb := Shebang(src); sb != "" {
+				shell = sb
+			}
+		}
+		return shellLang(shell), ConfIsScript
+	}
+	if strings.IndexByte(name, '.') > 0 {
+		return syntax.LangAuto, ConfNotScript // different extension
+	}
+	if src == nil {
+		return syntax.LangAuto, ConfIfShebang
+	}
+	if shell := Shebang(src); shell != "" {
+		return shellLang(shell), ConfIsScript
+	}
+	if bytes.HasPrefix(src, []byte("#compdef ")) || bytes.HasPrefix(src, []byte("#autoload")) {
+		return shellLang("zsh"), ConfIsScript
+	}
+	return syntax.LangAuto, ConfNotScript
+}
+
+// shellLang returns the language variant for a shell name as returned by
+// [Shebang], falling back to Bash for Zsh.
+func shellLang(shell string) syntax.LangVariant {
+	var lang syntax.LangVariant
+	if err := lang.Set(shell); err != nil {
+		return syntax.LangBash
+	}
+	return lang
+}
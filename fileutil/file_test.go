@@ -0,0 +1,96 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package fileutil
+
+import (
+	"testing"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func TestShebang(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"#!/bin/sh", "sh"},
+		{"#!/bin/sh -e\necho", "sh"},
+		{"#! /bin/bash", "bash"},
+		{"#!/usr/bin/bash", "bash"},
+		{"#!/usr/bin/env bash", "bash"},
+		{"#!/usr/local/bin/bash", "bash"},
+		{"#!/usr/local/bin/env zsh", "zsh"},
+		{"#!/usr/bin/env -S bash -eu", "bash"},
+		{"#!/usr/local/bin/env -S busybox sh", "sh"},
+		{"#!/bin/busybox sh", "sh"},
+		{"#!/bin/busybox ash", "sh"},
+		{"#!/bin/dash", "sh"},
+		{"#!/bin/ash", "sh"},
+		{"#!/bin/mksh", "mksh"},
+		{"#!/usr/bin/env bats", "bats"},
+
+		{"", ""},
+		{"echo", ""},
+		{"#!/bin/shell", ""},
+		{"#!/bin/ksh", ""}, // not supported by the parser
+		{"#!/usr/bin/env python", ""},
+		{"#!/usr/bin/env -S", ""},
+		{"#!/bin/busybox", ""},
+		{" #!/bin/sh", ""},
+		{"#!bin/sh", ""},
+	}
+	for _, test := range tests {
+		if got := Shebang([]byte(test.in)); got != test.want {
+			t.Errorf("Shebang(%q) got %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestDetectScript(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		src      string // nil if empty
+		wantLang syntax.LangVariant
+		wantConf ScriptConfidence
+	}{
+		{"a.sh", "", syntax.LangPOSIX, ConfIsScript},
+		{"a.sh", "#!/usr/bin/env bash\n", syntax.LangBash, ConfIsScript},
+		{"a.sh", "#!/usr/bin/env -S mksh\n", syntax.LangMirBSDKorn, ConfIsScript},
+		{"dir/a.bash", "", syntax.LangBash, ConfIsScript},
+		{"a.mksh", "", syntax.LangMirBSDKorn, ConfIsScript},
+		{"a.bats", "", syntax.LangBats, ConfIsScript},
+		{"a.bats", "#!/usr/bin/env bats\n", syntax.LangBats, ConfIsScript},
+		{"a.zsh", "", syntax.LangBash, ConfIsScript},
+
+		{"script", "", syntax.LangAuto, ConfIfShebang},
+		{"script", "#!/bin/sh\n", syntax.LangPOSIX, ConfIsScript},
+		{"script", "#!/usr/local/bin/env -S busybox sh\n", syntax.LangPOSIX, ConfIsScript},
+		{"script", "#!/bin/dash\n", syntax.LangPOSIX, ConfIsScript},
+		{"script", "#!/usr/bin/env bats\n", syntax.LangBats, ConfIsScript},
+		{"script", "#!/bin/zsh\n", syntax.LangBash, ConfIsScript},
+		{"_foo", "#compdef foo\n", syntax.LangBash, ConfIsScript},
+		{"_foo", "#autoload\n", syntax.LangBash, ConfIsScript},
+
+		{"script", "echo foo\n", syntax.LangAuto, ConfNotScript},
+		{"script", "#!/bin/ksh\n", syntax.LangAuto, ConfNotScript},
+		{"script", "#!/usr/bin/env python3\n", syntax.LangAuto, ConfNotScript},
+		{"script", " #compdef foo\n", syntax.LangAuto, ConfNotScript},
+		{"a.txt", "#!/bin/sh\n", syntax.LangAuto, ConfNotScript},
+		{"a.ksh", "", syntax.LangAuto, ConfNotScript},
+		{"a.sh.orig", "", syntax.LangAuto, ConfNotScript},
+	}
+	for _, test := range tests {
+		var src []byte
+		if test.src != "" {
+			src = []byte(test.src)
+		}
+		lang, conf := DetectScript(test.name, src)
+		if lang != test.wantLang || conf != test.wantConf {
+			t.Errorf("DetectScript(%q, %q) got (%v, %d), want (%v, %d)",
+				test.name, test.src, lang, conf, test.wantLang, test.wantConf)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package fileutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+	"strings"
+
+	"mvdan.cc/sh/v3/pattern"
+)
+
+// IgnoreFileName is the name of the files listing paths to be skipped by
+// [WalkScripts] and shfmt, using the same syntax as ".gitignore" files.
+const IgnoreFileName = ".shfmtignore"
+
+// IgnoreList is a list of patterns using the syntax of ".gitignore" files,
+// which tell whether paths should be ignored. The zero value is an empty list.
+//
+// A pattern containing a slash other than a trailing one is matched against
+// the entire path, and otherwise against any of its elements. A trailing
+// slash only matches directories, a leading "!" re-includes paths which were
+// ignored by previous patterns, and the wildcards "*", "?", "[...]", and "**"
+// behave like in Git.
+type IgnoreList struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	rx      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ParseIgnore parses an ignore file such as [IgnoreFileName].
+// Empty lines and lines starting with "#" are skipped.
+func ParseIgnore(r io.Reader) (*IgnoreList, error) {
+	l := &IgnoreList{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSuffix(scanner.Text(), "\r")
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		if err := l.Add(text); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	return l, scanner.Err()
+}
+
+// Add adds a pattern to the end of the list, so that it takes precedence over
+// all the previous patterns.
+func (l *IgnoreList) Add(pat string) error {
+	var rule ignoreRule
+	// Trailing spaces are ignored unless they are escaped.
+	for strings.HasSuffix(pat, " ") && !strings.HasSuffix(pat, `\ `) {
+		pat = pat[:len(pat)-1]
+	}
+	switch {
+	case strings.HasPrefix(pat, "!"):
+		rule.negate = true
+		pat = pat[1:]
+	case strings.HasPrefix(pat, `\!`), strings.HasPrefix(pat, `\#`):
+		pat = pat[1:]
+	}
+	if strings.HasSuffix(pat, "/") {
+		rule.dirOnly = true
+		pat = strings.TrimSuffix(pat, "/")
+	}
+	if pat == "" {
+		return fmt.Errorf("empty ignore pattern")
+	}
+	if strings.Contains(pat, "/") {
+		pat = strings.TrimPrefix(pat, "/")
+	} else {
+		pat = "**/" + pat
+	}
+	expr, err := pattern.Regexp(pat, pattern.Filenames|pattern.EntireString)
+	if err != nil {
+		return err
+	}
+	rx, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	rule.rx = rx
+	l.rules = append(l.rules, rule)
+	return nil
+}
+
+// Match reports whether a path is ignored by the list, where name is a
+// slash-separated path relative to the directory of the ignore file.
+// Like with Git, paths inside an ignored directory are always ignored.
+func (l *IgnoreList) Match(name string, isDir bool) bool {
+	for i := 1; i < len(name); i++ {
+		if name[i] == '/' {
+			if ignored, _ := l.match(name[:i], true); ignored {
+				return true
+			}
+		}
+	}
+	ignored, _ := l.match(name, isDir)
+	return ignored
+}
+
+// match is like Match without checking the parent directories, also
+// reporting whether any of the patterns matched.
+func (l *IgnoreList) match(name string, isDir bool) (ignored, matched bool) {
+	for i := len(l.rules) - 1; i >= 0; i-- {
+		rule := l.rules[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.rx.MatchString(name) {
+			return !rule.negate, true
+		}
+	}
+	return false, false
+}
+
+// IgnoreFiles finds the ignore files named [IgnoreFileName] in a filesystem,
+// such that the file in each directory applies to all the paths within it.
+type IgnoreFiles struct {
+	FS fs.FS
+
+	lists map[string]*IgnoreList // nil if a directory has none
+}
+
+// Ignored reports whether the ignore files in the directories containing name
+// ignore it, where name is a path in FS. The ignore file in a nested directory
+// takes precedence over the ones in its parent directories.
+//
+// Errors reading or parsing an ignore file are of type [*fs.PathError].
+func (f *IgnoreFiles) Ignored(name string, isDir bool) (bool, error) {
+	for i := 1; i < len(name); i++ {
+		if name[i] == '/' {
+			if ignored, err := f.ignored(name[:i], true); err != nil || ignored {
+				return ignored, err
+			}
+		}
+	}
+	return f.ignored(name, isDir)
+}
+
+func (f *IgnoreFiles) ignored(name string, isDir bool) (bool, error) {
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		list, err := f.list(dir)
+		if err != nil {
+			return false, err
+		}
+		if list != nil {
+			relative := name
+			if dir != "." {
+				relative = name[len(dir)+1:]
+			}
+			if ignored, matched := list.match(relative, isDir); matched {
+				return ignored, nil
+			}
+		}
+		if dir == "." || dir == "/" {
+			return false, nil
+		}
+	}
+}
+
+func (f *IgnoreFiles) list(dir string) (*IgnoreList, error) {
+	if list, ok := f.lists[dir]; ok {
+		return list, nil
+	}
+	if f.lists == nil {
+		f.lists = make(map[string]*IgnoreList)
+	}
+	var list *IgnoreList
+	name := path.Join(dir, IgnoreFileName)
+	file, err := f.FS.Open(name)
+	if err == nil {
+		list, err = ParseIgnore(file)
+		file.Close()
+		if err != nil {
+			return nil, &fs.PathError{Op: "parse", Path: name, Err: err}
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	f.lists[dir] = list
+	return list, nil
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package fileutil
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIgnoreList(t *testing.T) {
+	t.Parallel()
+	list, err := ParseIgnore(strings.NewReader(`
+# comment
+*.gen.sh
+/root.sh
+build/
+docs/**/*.sh
+!docs/keep/*.sh
+\#hash.sh
+trailing.sh   
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"foo.sh", false, false},
+		{"foo.gen.sh", false, true},
+		{"sub/foo.gen.sh", false, true},
+		{"root.sh", false, true},
+		{"sub/root.sh", false, false},
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build/foo.sh", false, true},
+		{"docs/foo.sh", false, true},
+		{"docs/a/b/foo.sh", false, true},
+		{"docs/keep/foo.sh", false, false},
+		{"#hash.sh", false, true},
+		{"trailing.sh", false, true},
+		{"comment", false, false},
+	}
+	for _, test := range tests {
+		if got := list.Match(test.name, test.isDir); got != test.want {
+			t.Errorf("Match(%q, %t) = %t, want %t", test.name, test.isDir, got, test.want)
+		}
+	}
+
+	if _, err := ParseIgnore(strings.NewReader("ok.sh\n[a\n")); err == nil {
+		t.Errorf("expected an error for an unterminated bracket")
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	t.Parallel()
+	files := IgnoreFiles{FS: fstest.MapFS{
+		IgnoreFileName:              {Data: []byte("vendor/\n*.gen.sh\n")},
+		"sub/" + IgnoreFileName:     {Data: []byte("!keep.gen.sh\nlocal.sh\n")},
+		"sub/dir/" + IgnoreFileName: {Data: []byte("!vendor/\n")},
+	}}
+	tests := []struct {
+		name  string
+		isDir bool
+		want  bool
+	}{
+		{"foo.sh", false, false},
+		{"foo.gen.sh", false, true},
+		{"vendor/foo.sh", false, true},
+		{"sub/keep.gen.sh", false, false},
+		{"sub/other.gen.sh", false, true},
+		{"sub/local.sh", false, true},
+		{"local.sh", false, false},
+		{"sub/dir/vendor", true, false},
+		{"sub/dir/vendor/foo.sh", false, false},
+	}
+	for _, test := range tests {
+		got, err := files.Ignored(test.name, test.isDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("Ignored(%q, %t) = %t, want %t", test.name, test.isDir, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package fileutil
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"regexp"
+
+	"mvdan.cc/editorconfig"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+var vcsDir = regexp.MustCompile(`^\.(git|svn|hg)$`)
+
+// IsVCSDir reports whether a directory name is one used by a version control
+// system, such as ".git". Such directories are skipped when walking a file
+// tree for shell scripts, by both [WalkScripts] and shfmt.
+func IsVCSDir(name string) bool {
+	return vcsDir.MatchString(name)
+}
+
+// WalkScripts walks the file tree rooted at root in fsys, calling fn for each
+// file which is a shell script, along with its language as detected by
+// [DetectScript]. Like with [fs.WalkDir], fn may return [fs.SkipDir] or
+// [fs.SkipAll] to skip the rest of the directory or the entire walk.
+//
+// Files are detected as scripts via their extension and, when that is not
+// enough, by reading a shebang from the start of their contents. Hidden files,
+// symbolic links, and version control directories like ".git" are skipped.
+//
+// Like shfmt, files and directories are also skipped when an EditorConfig file
+// in fsys sets "ignore = true" for them, either in a section matching their
+// path or in a "[[shell]]" section, or when they are listed by an ignore file
+// as described by [IgnoreFiles].
+func WalkScripts(fsys fs.FS, root string, fn func(path string, lang syntax.LangVariant) error) error {
+	w := &scriptWalker{
+		fsys:        fsys,
+		ignoreFiles: IgnoreFiles{FS: fsys},
+		configs:     make(map[string]*editorconfig.File),
+		regexpCache: make(map[string]*regexp.Regexp),
+	}
+	return fs.WalkDir(fsys, root, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if name != root && IsVCSDir(entry.Name()) {
+				return fs.SkipDir
+			}
+			if name != root {
+				if ignored, err := w.ignored(name, true); err != nil {
+					return err
+				} else if ignored {
+					return fs.SkipDir
+				}
+			}
+			return nil
+		}
+		lang, conf := DetectScript(name, nil)
+		if conf == ConfNotScript || CouldBeScript2(entry) == ConfNotScript {
+			return nil
+		}
+		if ignored, err := w.ignored(name, false); err != nil {
+			return err
+		} else if ignored {
+			return nil
+		}
+		if conf == ConfIfShebang || lang == syntax.LangPOSIX {
+			head, err := readHead(fsys, name)
+			if err != nil {
+				return err
+			}
+			if lang, conf = DetectScript(name, head); conf != ConfIsScript {
+				return nil
+			}
+		}
+		return fn(name, lang)
+	})
+}
+
+type scriptWalker struct {
+	fsys        fs.FS
+	ignoreFiles IgnoreFiles
+	configs     map[string]*editorconfig.File // nil if a directory has none
+	regexpCache map[string]*regexp.Regexp
+}
+
+// ignored reports whether name is listed by the ignore files in the
+// directories containing it, or whether their EditorConfig files set
+// "ignore = true" for it.
+func (w *scriptWalker) ignored(name string, isDir bool) (bool, error) {
+	if ignored, err := w.ignoreFiles.Ignored(name, isDir); err != nil || ignored {
+		return ignored, err
+	}
+	var props editorconfig.Section
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		file, err := w.config(dir)
+		if err != nil {
+			return false, err
+		}
+		if file != nil {
+			relative := name
+			if dir != "." {
+				relative = name[len(dir)+1:]
+			}
+			props.Add(file.Filter(relative, []string{"shell"}, w.regexpCache).Properties...)
+			if file.Root {
+				break
+			}
+		}
+		if dir == "." || dir == "/" {
+			break
+		}
+	}
+	return props.Get("ignore") == "true", nil
+}
+
+func (w *scriptWalker) config(dir string) (*editorconfig.File, error) {
+	if file, ok := w.configs[dir]; ok {
+		return file, nil
+	}
+	var file *editorconfig.File
+	f, err := w.fsys.Open(path.Join(dir, editorconfig.DefaultName))
+	if err == nil {
+		file, err = editorconfig.Parse(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	w.configs[dir] = file
+	return file, nil
+}
+
+// readHead reads the start of a file to detect its shebang.
+func readHead(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	buf := make([]byte, ShebangLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return buf[:n], nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package fileutil
+
+import (
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func TestWalkScripts(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"a.sh":                {Data: []byte("echo")},
+		"b.bash":              {},
+		"c.txt":               {Data: []byte("#!/bin/sh\n")},
+		"posix.sh":            {Data: []byte("#!/usr/bin/env bash\necho")},
+		"shebang":             {Data: []byte("#!/bin/busybox sh\necho")},
+		"noshebang":           {Data: []byte("echo")},
+		"_completion":         {Data: []byte("#compdef foo\n")},
+		".hidden.sh":          {},
+		".git/hook.sh":        {},
+		"dir/d.mksh":          {},
+		"dir/ignored.sh":      {},
+		"dir/.editorconfig":   {Data: []byte("[ignored.sh]\nignore = true\n")},
+		"vendor/e.sh":         {},
+		"vendor/sub/f.sh":     {},
+		".editorconfig":       {Data: []byte("[vendor]\nignore = true\n")},
+		"other/.editorconfig": {Data: []byte("root = true\n[[shell]]\nignore = true\n")},
+		"other/g.sh":          {},
+		".shfmtignore":        {Data: []byte("gen/\n")},
+		"gen/h.sh":            {},
+	}
+	type script struct {
+		path string
+		lang syntax.LangVariant
+	}
+	var got []script
+	err := WalkScripts(fsys, ".", func(path string, lang syntax.LangVariant) error {
+		got = append(got, script{path, lang})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []script{
+		{"_completion", syntax.LangBash},
+		{"a.sh", syntax.LangPOSIX},
+		{"b.bash", syntax.LangBash},
+		{"dir/d.mksh", syntax.LangMirBSDKorn},
+		{"posix.sh", syntax.LangBash},
+		{"shebang", syntax.LangPOSIX},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v,\nwant %v", got, want)
+	}
+
+	got = nil
+	err = WalkScripts(fsys, ".", func(path string, lang syntax.LangVariant) error {
+		got = append(got, script{path, lang})
+		return fs.SkipAll
+	})
+	if err != nil || len(got) != 1 {
+		t.Fatalf("SkipAll did not stop the walk: %v, %v", got, err)
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2016, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package shebang parses the shebang lines of shell scripts. It is shared by
+// the syntax and fileutil packages, as fileutil depends on syntax.
+package shebang
+
+import "regexp"
+
+var shebangRe = regexp.MustCompile(`^#!\s?/(usr/(local/)?)?bin/(env\s+(-S\s+)?)?(busybox\s+)?(sh|bash|dash|ash|mksh|bats|zsh)(\s|$)`)
+
+// Shell parses a "#!" sequence from the beginning of the input bytes,
+// and returns the shell that it points to, or an empty string.
+// POSIX shells like dash and "busybox sh" are reported as "sh".
+func Shell(bs []byte) string {
+	m := shebangRe.FindSubmatch(bs)
+	if m == nil {
+		return ""
+	}
+	switch shell := string(m[6]); shell {
+	case "dash", "ash":
+		return "sh"
+	default:
+		return shell
+	}
+}
@@ -7,10 +7,14 @@
 //
 // The interpreter generally aims to behave like Bash,
 // but it does not support all of its features.
+// Unlike Bash, the last command of a pipeline runs in the current shell by
+// default, as if the "lastpipe" option was enabled, so that "cmd | read var"
+// sets var. Use "shopt -u lastpipe" to run it in a subshell instead.
 //
-// The interpreter currently aims to behave like a non-interactive shell,
+// The interpreter behaves like a non-interactive shell by default,
 // which is how most shells run scripts, and is more useful to machines.
-// In the future, it may gain an option to behave like an interactive shell.
+// The [Interactive] option enables some of the features of an interactive
+// shell, such as the command history.
 package interp
 
 import (
@@ -19,12 +23,16 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log/slog"
 	"maps"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -80,6 +88,54 @@ type Runner struct {
 	// The slice is needed to preserve the relative order of middlewares.
 	execMiddlewares []func(ExecHandlerFunc) ExecHandlerFunc
 
+	// notFoundHandler is called for commands which are not found. It may be nil.
+	notFoundHandler CommandNotFoundHandlerFunc
+
+	// spanHandler starts spans for commands and function calls. It may be nil.
+	spanHandler SpanHandlerFunc
+
+	// auditHandler receives state-changing actions. It may be nil.
+	auditHandler AuditHandlerFunc
+
+	// diagnosticHandler receives warnings about the program. It may be nil.
+	diagnosticHandler DiagnosticHandlerFunc
+
+	// jobOutputHandler chooses where background jobs write to. It may be nil.
+	jobOutputHandler JobOutputHandlerFunc
+
+	// pty is set via PseudoTerminal.
+	pty bool
+
+	// jobControl is set via JobControl. It may be nil.
+	jobControl *jobControl
+
+	// fsys is set via FS. It may be nil.
+	fsys fs.FS
+
+	// program is set while running a Program. It may be nil.
+	program *Program
+
+	// interactive is set via Interactive.
+	// history holds the commands added via AddHistory.
+	interactive bool
+	history     []string
+
+	// signalCfg is set via Signals. It may be nil.
+	signalCfg *SignalConfig
+
+	// signals is replaced at each call to Run if signalCfg is set.
+	// It may be nil.
+	signals *signalState
+
+	// limits is set via Limits, and limitCounts is replaced at each call to
+	// Run. limitCounts may be nil.
+	limits      LimitConfig
+	limitCounts *limitCounts
+
+	// sandbox is set via Sandbox, and replaced at each call to Run.
+	// It may be nil.
+	sandbox *sandbox
+
 	// openHandler is a function responsible for opening files. It must not be nil.
 	openHandler OpenHandlerFunc
 
@@ -87,9 +143,47 @@ type Runner struct {
 	// glob expansion. It must be non-nil.
 	readDirHandler ReadDirHandlerFunc2
 
+	// globHandler is a function responsible for pathname expansion.
+	// It must be non-nil.
+	globHandler GlobHandlerFunc
+
+	// chdirHandler is consulted before changing directory. It may be nil.
+	chdirHandler ChdirHandlerFunc
+
 	// statHandler is a function responsible for getting file stat. It must be non-nil.
 	statHandler StatHandlerFunc
 
+	// sourceHandler loads the files run via the source builtin. It must be non-nil.
+	sourceHandler SourceHandlerFunc
+
+	// commandPolicy and builtinPolicy are consulted before running external
+	// commands and builtins respectively. They may be nil.
+	commandPolicy CommandPolicyFunc
+	builtinPolicy CommandPolicyFunc
+
+	// streams maps the paths registered via Streams to Go readers and writers.
+	streams map[string]any
+
+	// traceHooks grows with calls to Trace.
+	traceHooks []TraceHooks
+
+	// debugger is set via Debug. It may be nil.
+	debugger *Debugger
+
+	// report is set via Report. It may be nil.
+	report *RunReport
+
+	// errExitMode selects the rules followed by the "errexit" option.
+	errExitMode ErrExitMode
+
+	// watchdog is set via Watchdog. It may be nil.
+	watchdog *watchdog
+
+	// xtraceFormat selects the output format of the "xtrace" option,
+	// unless xtraceHandler is set.
+	xtraceFormat  XTraceFormat
+	xtraceHandler XTraceHandlerFunc
+
 	stdin  io.Reader
 	stdout io.Writer
 	stderr io.Writer
@@ -109,6 +203,32 @@ type Runner struct {
 	// rand is used mainly to generate temporary files.
 	rand *rand.Rand
 
+	// locale is set via Locale.
+	locale locale
+
+	// checkedArithm is set via CheckedArithm.
+	checkedArithm bool
+
+	// processLimits is set via ProcessLimits.
+	processLimits bool
+
+	// logger is set via Logger.
+	logger *slog.Logger
+
+	// deterministic, frozenTime, and randSeed are set via Deterministic.
+	deterministic bool
+	frozenTime    time.Time
+	randSeed      int64
+
+	// startTime is when the runner was reset, used for $SECONDS.
+	startTime time.Time
+
+	// random generates the values of $RANDOM.
+	random *rand.Rand
+
+	// jobPIDs numbers the background jobs in deterministic mode.
+	jobPIDs *atomic.Int64
+
 	// wgProcSubsts allows waiting for any process substitution sub-shells
 	// to finish running.
 	wgProcSubsts sync.WaitGroup
@@ -123,6 +243,24 @@ type Runner struct {
 	inSource  bool
 	noErrExit bool
 
+	// funcNames holds the names of the functions being called,
+	// with the innermost call last.
+	funcNames []string
+
+	// callStack holds the functions being called and the files being
+	// sourced, with the innermost last. It is used for variables like
+	// $BASH_SOURCE, which also cover sourced files.
+	callStack []callFrame
+
+	// funcSources holds the file in which each function was defined.
+	funcSources map[string]string
+
+	// lineno is the line of the statement being run, for $LINENO.
+	lineno uint
+
+	// xtraceLevel is how many command substitutions we are nested in.
+	xtraceLevel int
+
 	// track if a sourced script set positional parameters
 	sourceSetParams bool
 
@@ -139,6 +277,11 @@ type Runner struct {
 
 	bgShells errgroup.Group
 
+	// bgJobs holds the background jobs started since the last reset,
+	// and bgPID is the process ID of the last one, as in "$!".
+	bgJobs []*bgJob
+	bgPID  int
+
 	opts runnerOpts
 
 	origDir    string
@@ -159,9 +302,38 @@ type Runner struct {
 	// apply to the current shell, and not just the command.
 	keepRedirs bool
 
+	// fds holds the file descriptors other than the standard streams,
+	// such as those opened via "exec 3>file" or connected to coprocesses.
+	// The map is copied before any changes, so that it can be shared with
+	// subshells.
+	fds map[int]*shellFd
+
+	// execFiles holds the files opened as the standard streams via exec,
+	// like "exec >log", which are closed when the runner is reset.
+	execFiles []io.Closer
+
+	// hashed holds the commands remembered by the hash builtin,
+	// which were found by searching the directories in hashPath.
+	hashed   map[string]hashEntry
+	hashPath string
+
+	// umask is the file mode creation mask set by the umask builtin,
+	// applied to the modes of files created via the open handler.
+	// It does not apply to the programs run via the exec handler,
+	// as the process umask cannot be changed for a single child process.
+	umask os.FileMode
+
+	// inNotFoundHandle is set while running "command_not_found_handle",
+	// to not call it again for commands which it cannot find.
+	inNotFoundHandle bool
+
 	// Fake signal callbacks
 	callbackErr  string
 	callbackExit string
+
+	// callbackSignals holds the traps for real signals.
+	// An empty trap means that the signal is ignored.
+	callbackSignals map[syscall.Signal]string
 }
 
 type alias struct {
@@ -189,7 +361,9 @@ func New(opts ...RunnerOption) (*Runner, error) {
 		usedNew:        true,
 		openHandler:    DefaultOpenHandler(),
 		readDirHandler: DefaultReadDirHandler2(),
+		globHandler:    DefaultGlobHandler(),
 		statHandler:    DefaultStatHandler(),
+		sourceHandler:  DefaultSourceHandler(),
 	}
 	r.dirStack = r.dirBootstrap[:0]
 	for _, opt := range opts {
@@ -202,12 +376,21 @@ func New(opts ...RunnerOption) (*Runner, error) {
 	for i, opt := range bashOptsTable {
 		r.opts[len(shellOptsTable)+i] = opt.defaultState
 	}
+	if r.interactive {
+		// Like Bash, interactive shells expand aliases by default.
+		r.opts[optExpandAliases] = true
+	}
 
 	// Set the default fallbacks, if necessary.
-	if r.Env == nil {
+	if r.Env == nil && r.sandbox != nil {
+		// Untrusted programs must not see the host's variables, like secrets.
+		r.Env = expand.ListEnviron()
+	} else if r.Env == nil {
 		Env(nil)(r)
 	}
-	if r.Dir == "" {
+	if r.Dir == "" && r.sandbox != nil {
+		r.Dir = string(filepath.Separator)
+	} else if r.Dir == "" {
 		if err := Dir("")(r); err != nil {
 			return nil, err
 		}
@@ -227,6 +410,10 @@ type RunnerOption func(*Runner) error
 
 // Env sets the interpreter's environment. If nil, a copy of the current
 // process's environment is used.
+//
+// The environment is only read from as needed, so use [expand.LazyEnviron] to
+// provide variables whose values are expensive to obtain, such as secrets,
+// only fetching them if the program uses them.
 func Env(env expand.Environ) RunnerOption {
 	return func(r *Runner) error {
 		if env == nil {
@@ -253,7 +440,12 @@ func Dir(path string) RunnerOption {
 		if err != nil {
 			return fmt.Errorf("could not get absolute dir: %w", err)
 		}
-		info, err := os.Stat(path)
+		var info fs.FileInfo
+		if r.fsys != nil {
+			info, err = fs.Stat(r.fsys, virtualPath("", path))
+		} else {
+			info, err = os.Stat(path)
+		}
 		if err != nil {
 			return fmt.Errorf("could not stat: %w", err)
 		}
@@ -412,6 +604,87 @@ func ReadDirHandler2(f ReadDirHandlerFunc2) RunnerOption {
 	}
 }
 
+// GlobHandler sets the pathname expansion handler.
+// See [GlobHandlerFunc] for more info.
+func GlobHandler(f GlobHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.globHandler = f
+		return nil
+	}
+}
+
+// ChdirHandler sets a handler which is consulted every time the current
+// directory changes. See [ChdirHandlerFunc] for more info.
+func ChdirHandler(f ChdirHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.chdirHandler = f
+		return nil
+	}
+}
+
+// XTraceHandler sets the handler for the trace output of the "xtrace" option.
+// See [XTraceHandlerFunc] for more info.
+func XTraceHandler(f XTraceHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.xtraceHandler = f
+		return nil
+	}
+}
+
+// CommandNotFoundHandler sets the handler for commands which are not found.
+// See [CommandNotFoundHandlerFunc] for more info.
+func CommandNotFoundHandler(f CommandNotFoundHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.notFoundHandler = f
+		return nil
+	}
+}
+
+// SpanHandler sets the handler which starts a span for each external command
+// and function call. See [SpanHandlerFunc] for more info.
+func SpanHandler(f SpanHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.spanHandler = f
+		return nil
+	}
+}
+
+// AuditHandler sets the handler which receives every state-changing action,
+// such as running an external command. See [AuditHandlerFunc] for more info.
+func AuditHandler(f AuditHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.auditHandler = f
+		return nil
+	}
+}
+
+// DiagnosticHandler sets the handler which receives warnings about the
+// program being run. See [DiagnosticHandlerFunc] for more info.
+func DiagnosticHandler(f DiagnosticHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.diagnosticHandler = f
+		return nil
+	}
+}
+
+// SourceHandler sets the handler which loads the files run via the "source"
+// and "." builtins. See [SourceHandlerFunc] for more info.
+func SourceHandler(f SourceHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.sourceHandler = f
+		return nil
+	}
+}
+
+// JobOutputHandler sets the handler which chooses where the output of each
+// background job goes. See [JobOutputHandlerFunc] for more info.
+func JobOutputHandler(f JobOutputHandlerFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.jobOutputHandler = f
+		return nil
+	}
+}
+
 // StatHandler sets the stat handler. See [StatHandlerFunc] for more info.
 func StatHandler(f StatHandlerFunc) RunnerOption {
 	return func(r *Runner) error {
@@ -420,6 +693,288 @@ func StatHandler(f StatHandlerFunc) RunnerOption {
 	}
 }
 
+// CommandPolicy sets a policy which is consulted before running any external
+// command, including those run via the "command" and "exec" builtins.
+// See [CommandPolicyFunc] for more info.
+//
+// Unlike an [ExecHandlers] middleware, a denied command is reported to the
+// script like a regular shell error, and the policy applies regardless of which
+// exec handlers are in use.
+func CommandPolicy(f CommandPolicyFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.commandPolicy = f
+		return nil
+	}
+}
+
+// BuiltinPolicy is like [CommandPolicy], but it is consulted before running
+// any builtin instead.
+func BuiltinPolicy(f CommandPolicyFunc) RunnerOption {
+	return func(r *Runner) error {
+		r.builtinPolicy = f
+		return nil
+	}
+}
+
+// Deterministic makes the runner's behavior reproducible across runs, which is
+// useful to compare the output of programs against golden files:
+//
+//   - $RANDOM produces the same sequence of numbers given the same seed;
+//   - the clock is frozen at the given time, so $SECONDS is always zero and
+//     $EPOCHREALTIME does not change;
+//   - glob matches are sorted by name, whatever the order in which the
+//     [ReadDirHandlerFunc2] returns directory entries;
+//   - coprocesses are numbered from 1 in $COPROC_PID for each call to Reset.
+//
+// Note that external commands may still behave differently on each run.
+func Deterministic(seed int64, now time.Time) RunnerOption {
+	return func(r *Runner) error {
+		r.deterministic = true
+		r.randSeed = seed
+		r.frozenTime = now
+		return nil
+	}
+}
+
+// CheckedArithm sets whether arithmetic fails with an error when an integer
+// overflows, such as in "$((2**63))", rather than wrapping around like Bash.
+// This is useful to catch bugs in programs doing numeric work,
+// but it is not compatible with those relying on the wrapping behavior.
+func CheckedArithm(enabled bool) RunnerOption {
+	return func(r *Runner) error {
+		r.checkedArithm = enabled
+		return nil
+	}
+}
+
+// ProcessLimits sets whether the ulimit builtin may modify the resource limits
+// of the current process, which are shared by all of its goroutines and
+// inherited by the programs it runs, such as via [DefaultExecHandler].
+// This is useful for a shell like gosh, but not for a library running
+// programs within a larger process, so it is disabled by default;
+// ulimit can then print the limits, but modifying them fails.
+func ProcessLimits(enabled bool) RunnerOption {
+	return func(r *Runner) error {
+		r.processLimits = enabled
+		return nil
+	}
+}
+
+// Sandbox restricts the runner so that it can run untrusted programs:
+//
+//   - external commands are not allowed, failing as if they were not found,
+//     so programs cannot use the network either;
+//   - the host's filesystem cannot be used, as opening files, reading
+//     directories, and stat'ing files fail with [fs.ErrPermission],
+//     other than [Streams] and /dev/null;
+//   - the environment starts empty rather than copying the current process's,
+//     and the directory is "/" rather than the current process's,
+//     unless set via [Env] and [Dir];
+//   - the ulimit builtin cannot modify the limits of the current process;
+//   - each call to Run times out after ten seconds, and runs at most
+//     a hundred thousand loop iterations and a thousand nested function calls;
+//   - variables cannot hold values larger than one mebibyte,
+//     nor more than sixteen mebibytes in total;
+//   - a word cannot expand to more than a hundred thousand fields,
+//     such as via a brace expansion like "{1..1000000}".
+//
+// To use other limits, use [SandboxLimits] instead.
+//
+// Exceeding a limit stops the runner, making Run return an error wrapping
+// [ErrSandboxLimit], or [context.DeadlineExceeded] for the timeout.
+//
+// To give the program a read-only virtual filesystem, use [FS] after Sandbox.
+// Files still cannot be opened for writing, even if a handler set via
+// [OpenHandler] after Sandbox allows it.
+func Sandbox() RunnerOption {
+	return SandboxLimits(SandboxConfig{})
+}
+
+// PseudoTerminal makes [DefaultExecHandler] run each program attached to a new
+// pseudo-terminal, so that interactive programs such as ssh, vim, or those
+// prompting for passwords behave as they would in a terminal even when the
+// runner's standard input and output are pipes or buffers.
+//
+// The runner's standard input is copied to the terminal, and the terminal's
+// output is copied to the runner's standard output. Standard error is also
+// attached to the terminal when it is the same writer as standard output, and
+// is kept separate otherwise, so that redirections like "2>/dev/null" still
+// work. Note that terminals translate line endings, so a program writing
+// "foo\n" produces "foo\r\n".
+//
+// When the runner's standard input is itself a terminal, it is put into raw
+// mode while each program runs, and its window size is kept in sync with the
+// pseudo-terminal. The interrupt, termination, hangup, and quit signals
+// received by the current process while a program runs are forwarded to the
+// program's process group, as the program does not share our terminal,
+// unless the runner handles signals as configured via [Signals].
+//
+// Pseudo-terminals are only supported on Unix-like systems; elsewhere,
+// programs fail to start with exit status 126.
+func PseudoTerminal() RunnerOption {
+	return func(r *Runner) error {
+		r.pty = true
+		return nil
+	}
+}
+
+// Streams registers Go readers and writers by name, so that the shell can
+// redirect to and from them as if they were files,
+// without touching the real filesystem.
+// Each value must implement [io.Reader], [io.Writer], or both.
+//
+// A name matches a redirection or sourced path when they are equal after
+// expansion, so both "@artifacts" as in ">@artifacts" and "/dev/app/log" as in
+// "</dev/app/log" are valid names. Streams take precedence over [OpenHandler].
+//
+// The streams are never closed by the Runner, and any flags such as truncation
+// or appending are ignored. Note that writes to a stream may be concurrent if
+// background commands are used. The map is copied when the option is applied,
+// so later changes to it have no effect on the Runner.
+func Streams(streams map[string]any) RunnerOption {
+	return func(r *Runner) error {
+		for name, stream := range streams {
+			_, isReader := stream.(io.Reader)
+			_, isWriter := stream.(io.Writer)
+			if !isReader && !isWriter {
+				return fmt.Errorf("stream %q is neither a reader nor a writer: %T", name, stream)
+			}
+		}
+		r.streams = maps.Clone(streams)
+		return nil
+	}
+}
+
+// Trace adds hooks which observe the program as it runs.
+// It can be used multiple times; the hooks are called in the order they were
+// added. See [TraceHooks] for more info.
+func Trace(hooks TraceHooks) RunnerOption {
+	return func(r *Runner) error {
+		r.traceHooks = append(r.traceHooks, hooks)
+		return nil
+	}
+}
+
+// Report collects a summary of what the runner does into rep, such as the exit
+// status and duration of each statement, the commands executed, the number of
+// bytes written to standard output and error, and the peak number of
+// background jobs. See [RunReport] for more info.
+//
+// Note that standard output and error are wrapped to count the bytes written
+// to them, so programs executed by the runner are not given them directly.
+func Report(rep *RunReport) RunnerOption {
+	return func(r *Runner) error {
+		r.report = rep
+		r.traceHooks = append(r.traceHooks, rep.hooks())
+		return nil
+	}
+}
+
+// Debug attaches a debugger, which can pause the runner before statements run.
+// See [Debugger] for more info.
+func Debug(d *Debugger) RunnerOption {
+	return func(r *Runner) error {
+		r.debugger = d
+		return nil
+	}
+}
+
+// Watchdog enables a watchdog which reports statements that have been blocked
+// reading from or writing to a pipe, without any data flowing through it,
+// for longer than threshold. This is useful to debug programs which hang,
+// such as when a pipeline deadlocks or a read never finishes.
+//
+// The pipes being watched are the ones connecting the sides of pipelines,
+// and those connecting coprocesses to the shell. Each blocked read or write
+// is reported at most once, and a statement may continue normally after
+// being reported, as a stall is not necessarily a deadlock.
+//
+// The report function is called from a separate goroutine, with the context
+// given to [Runner.Run]. It does not carry a [HandlerContext].
+func Watchdog(threshold time.Duration, report WatchdogFunc) RunnerOption {
+	return func(r *Runner) error {
+		if threshold <= 0 {
+			return fmt.Errorf("watchdog threshold must be positive: %v", threshold)
+		}
+		r.watchdog = &watchdog{threshold: threshold, report: report}
+		return nil
+	}
+}
+
+// ErrExitMode selects the rules followed by the "errexit" shell option,
+// enabled via "set -e", in the cases where Bash and POSIX shells disagree.
+//
+// The following table shows whether the failing false command makes the
+// shell, or the subshell running it, exit before x is printed.
+// A dash means that the failure is ignored. Note that Bash's behavior is
+// that of the "inherit_errexit" option, which is enabled by default;
+// when it is disabled, command substitutions ignore "errexit" entirely.
+//
+//	Program                                  Bash  POSIX
+//	false; echo x                            exit  exit
+//	(false); echo x                          exit  exit
+//	(false && true); echo x                  exit  exit
+//	{ false && true; }; echo x               -     -
+//	f() { false; echo x; }; f                exit  exit
+//	f() { false; echo x; }; f || true        -     -
+//	if (false; echo x); then :; fi           -     -
+//	! { false; echo x; }                     -     -
+//	y=$(false; echo x)                       exit  exit
+//	y=$(false; echo x) || true               -     exit
+//	[ "$(false; echo x)" = x ] && true       -     exit
+//
+// In short, both modes ignore failures in conditions, in any command of an
+// && or || list except the last, and in commands preceded by !, including
+// any functions and subshells they run. The modes differ in whether command
+// substitutions inherit that exception: Bash lets them inherit it, whereas
+// POSIX shells like dash apply the "errexit" option within a command
+// substitution regardless of where it appears, so that x is never printed in
+// the last two examples.
+type ErrExitMode uint8
+
+const (
+	ErrExitBash  ErrExitMode = iota // the default, following Bash
+	ErrExitPOSIX                    // following POSIX shells like dash
+)
+
+// ErrExit selects the rules followed by the "errexit" shell option in the cases
+// where shells disagree. See [ErrExitMode] for more info.
+func ErrExit(mode ErrExitMode) RunnerOption {
+	return func(r *Runner) error {
+		switch mode {
+		case ErrExitBash, ErrExitPOSIX:
+		default:
+			return fmt.Errorf("invalid errexit mode: %d", mode)
+		}
+		r.errExitMode = mode
+		return nil
+	}
+}
+
+// XTraceFormat selects how the "xtrace" shell option, enabled via "set -x",
+// writes its trace output to standard error.
+type XTraceFormat uint8
+
+const (
+	XTraceText XTraceFormat = iota // the default, human-readable text like Bash
+	XTraceJSON                     // one JSON-encoded [XTraceRecord] per line
+)
+
+// XTrace selects the output format of the "xtrace" shell option.
+// [XTraceJSON] is useful to index and query traces in log pipelines.
+// The format is ignored if [XTraceHandler] is used.
+func XTrace(format XTraceFormat) RunnerOption {
+	return func(r *Runner) error {
+		switch format {
+		case XTraceText, XTraceJSON:
+		default:
+			return fmt.Errorf("invalid xtrace format: %d", format)
+		}
+		r.xtraceFormat = format
+		return nil
+	}
+}
+
 // StdIO configures an interpreter's standard input, standard output, and
 // standard error. If out or err are nil, they default to a writer that discards
 // the output.
@@ -438,6 +993,41 @@ func StdIO(in io.Reader, out, err io.Writer) RunnerOption {
 	}
 }
 
+// Opt reports whether the named shell option is enabled, where name is either
+// an option of the set builtin such as "errexit",
+// or an option of the shopt builtin such as "globstar".
+func (r *Runner) Opt(name string) (bool, error) {
+	_, opt := r.optByName(name, true)
+	if opt == nil {
+		return false, fmt.Errorf("invalid option name: %q", name)
+	}
+	return *opt, nil
+}
+
+// SetOpt enables or disables the named shell option, like "set -o errexit" or
+// "shopt -s globstar" would. See [Runner.Opt] for the option names.
+// The options of the shopt builtin which are not supported can only be set to
+// their default state.
+//
+// Options set before the first call to [Runner.Run] or [Runner.Reset] are kept
+// when the runner is reset, like those set via [Params].
+func (r *Runner) SetOpt(name string, enable bool) error {
+	i, opt := r.optByName(name, true)
+	if opt == nil {
+		return fmt.Errorf("invalid option name: %q", name)
+	}
+	if i >= len(shellOptsTable) {
+		if bo := bashOptsTable[i-len(shellOptsTable)]; !bo.supported && enable != bo.defaultState {
+			return fmt.Errorf("option %q cannot be %s: not supported", name, r.optStatusText(enable))
+		}
+	}
+	*opt = enable
+	if r.ecfg != nil {
+		r.updateExpandOpts()
+	}
+	return nil
+}
+
 // optByName returns the matching runner's option index and status
 func (r *Runner) optByName(name string, bash bool) (index int, status *bool) {
 	if bash {
@@ -483,16 +1073,51 @@ var shellOptsTable = [...]shellOpt{
 
 var bashOptsTable = [...]bashOpt{
 	// supported options, sorted alphabetically by name
+	{
+		name:         "compat31",
+		defaultState: false,
+		supported:    true,
+	},
+	{
+		name:         "dotglob",
+		defaultState: false,
+		supported:    true,
+	},
 	{
 		name:         "expand_aliases",
 		defaultState: false,
 		supported:    true,
 	},
+	{
+		name:         "extglob",
+		defaultState: false,
+		supported:    true,
+	},
+	{
+		name:         "failglob",
+		defaultState: false,
+		supported:    true,
+	},
 	{
 		name:         "globstar",
 		defaultState: false,
 		supported:    true,
 	},
+	{
+		name:         "huponexit",
+		defaultState: false,
+		supported:    true,
+	},
+	{
+		name:         "inherit_errexit",
+		defaultState: true,
+		supported:    true,
+	},
+	{
+		name:         "lastpipe",
+		defaultState: true,
+		supported:    true,
+	},
 	{
 		name:         "nocaseglob",
 		defaultState: false,
@@ -503,6 +1128,11 @@ var bashOptsTable = [...]bashOpt{
 		defaultState: false,
 		supported:    true,
 	},
+	{
+		name:         "patsub_replacement",
+		defaultState: true,
+		supported:    true,
+	},
 	// unsupported options, sorted alphabetically by name
 	{name: "assoc_expand_once"},
 	{name: "autocd"},
@@ -518,7 +1148,6 @@ var bashOptsTable = [...]bashOpt{
 		name:         "cmdhist",
 		defaultState: true,
 	},
-	{name: "compat31"},
 	{name: "compat32"},
 	{name: "compat40"},
 	{name: "compat41"},
@@ -532,15 +1161,12 @@ var bashOptsTable = [...]bashOpt{
 	},
 	{name: "direxpand"},
 	{name: "dirspell"},
-	{name: "dotglob"},
 	{name: "execfail"},
 	{name: "extdebug"},
-	{name: "extglob"},
 	{
 		name:         "extquote",
 		defaultState: true,
 	},
-	{name: "failglob"},
 	{
 		name:         "force_fignore",
 		defaultState: true,
@@ -554,16 +1180,10 @@ var bashOptsTable = [...]bashOpt{
 		name:         "hostcomplete",
 		defaultState: true,
 	},
-	{name: "huponexit"},
-	{
-		name:         "inherit_errexit",
-		defaultState: true,
-	},
 	{
 		name:         "interactive_comments",
 		defaultState: true,
 	},
-	{name: "lastpipe"},
 	{name: "lithist"},
 	{name: "localvar_inherit"},
 	{name: "localvar_unset"},
@@ -604,10 +1224,18 @@ const (
 
 	// These correspond to indexes (offset by the above seven items) of
 	// supported options in bashOptsTable
+	optCompat31
+	optDotGlob
 	optExpandAliases
+	optExtGlob
+	optFailGlob
 	optGlobStar
+	optHupOnExit
+	optInheritErrExit
+	optLastPipe
 	optNoCaseGlob
 	optNullGlob
+	optPatSubReplacement
 )
 
 // Reset returns a runner to its initial state, right before the first call to
@@ -633,7 +1261,11 @@ func (r *Runner) Reset() {
 			panic("interp.ExecHandler should be replaced with interp.ExecHandlers, not mixed")
 		}
 		if r.execHandler == nil {
-			r.execHandler = DefaultExecHandler(2 * time.Second)
+			killTimeout := 2 * time.Second
+			if r.signalCfg != nil && r.signalCfg.KillTimeout != 0 {
+				killTimeout = r.signalCfg.KillTimeout
+			}
+			r.execHandler = DefaultExecHandler(killTimeout)
 		}
 		// Middlewares are chained from first to last, and each can call the
 		// next in the chain, so we need to construct the chain backwards.
@@ -642,14 +1274,54 @@ func (r *Runner) Reset() {
 			r.execHandler = middleware(r.execHandler)
 		}
 	}
+	// Any extra file descriptors, such as those opened via exec, are closed.
+	for n, fd := range r.fds {
+		r.logErr("closing file descriptor", fd.close(r), "fd", n)
+	}
+	for _, f := range r.execFiles {
+		r.logErr("closing file", f.Close())
+	}
 	// reset the internal state
 	*r = Runner{
-		Env:            r.Env,
-		callHandler:    r.callHandler,
-		execHandler:    r.execHandler,
-		openHandler:    r.openHandler,
-		readDirHandler: r.readDirHandler,
-		statHandler:    r.statHandler,
+		Env:               r.Env,
+		callHandler:       r.callHandler,
+		execHandler:       r.execHandler,
+		openHandler:       r.openHandler,
+		readDirHandler:    r.readDirHandler,
+		globHandler:       r.globHandler,
+		chdirHandler:      r.chdirHandler,
+		statHandler:       r.statHandler,
+		sourceHandler:     r.sourceHandler,
+		commandPolicy:     r.commandPolicy,
+		builtinPolicy:     r.builtinPolicy,
+		streams:           r.streams,
+		traceHooks:        r.traceHooks,
+		debugger:          r.debugger,
+		report:            r.report,
+		errExitMode:       r.errExitMode,
+		watchdog:          r.watchdog,
+		xtraceFormat:      r.xtraceFormat,
+		xtraceHandler:     r.xtraceHandler,
+		notFoundHandler:   r.notFoundHandler,
+		spanHandler:       r.spanHandler,
+		auditHandler:      r.auditHandler,
+		diagnosticHandler: r.diagnosticHandler,
+		jobOutputHandler:  r.jobOutputHandler,
+		pty:               r.pty,
+		jobControl:        r.jobControl,
+		fsys:              r.fsys,
+		interactive:       r.interactive,
+		history:           r.history,
+		signalCfg:         r.signalCfg,
+		limits:            r.limits,
+		sandbox:           r.sandbox,
+		deterministic:     r.deterministic,
+		locale:            r.locale,
+		checkedArithm:     r.checkedArithm,
+		processLimits:     r.processLimits,
+		logger:            r.logger,
+		frozenTime:        r.frozenTime,
+		randSeed:          r.randSeed,
 
 		// These can be set by functions like Dir or Params, but
 		// builtins can overwrite them; reset the fields to whatever the
@@ -672,6 +1344,18 @@ func (r *Runner) Reset() {
 		Vars:     r.Vars,
 		dirStack: r.dirStack[:0],
 		usedNew:  r.usedNew,
+		umask:    processUmask(),
+	}
+	r.startTime = r.now()
+	seed := r.randSeed
+	if !r.deterministic {
+		seed = time.Now().UnixNano()
+	}
+	r.random = rand.New(rand.NewSource(seed))
+	r.jobPIDs = new(atomic.Int64)
+	if r.report != nil {
+		r.stdout = r.report.writer(1, r.stdout)
+		r.stderr = r.report.writer(2, r.stderr)
 	}
 	if r.Vars == nil {
 		r.Vars = make(map[string]expand.Variable)
@@ -680,7 +1364,7 @@ func (r *Runner) Reset() {
 	}
 	// TODO(v4): Use the supplied Env directly if it implements enough methods.
 	r.writeEnv = &overlayEnviron{parent: r.Env}
-	if !r.writeEnv.Get("HOME").IsSet() {
+	if !r.writeEnv.Get("HOME").IsSet() && r.sandbox == nil {
 		home, _ := os.UserHomeDir()
 		r.setVarString("HOME", home)
 	}
@@ -744,9 +1428,24 @@ func IsExitStatus(err error) (status uint8, ok bool) {
 // Calling Run on an entire *File implies an exit, meaning that an exit trap may
 // run.
 func (r *Runner) Run(ctx context.Context, node syntax.Node) error {
+	defer r.logPanic()
 	if !r.didReset {
 		r.Reset()
 	}
+	if r.sandbox != nil {
+		r.sandbox = &sandbox{cfg: r.sandbox.cfg}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.sandbox.cfg.Timeout)
+		defer cancel()
+	}
+	if r.limits.Statements > 0 {
+		r.limitCounts = &limitCounts{}
+	}
+	if r.signalCfg != nil {
+		var stop func()
+		ctx, stop = r.catchSignals(ctx)
+		defer stop()
+	}
 	r.fillExpandConfig(ctx)
 	r.err = nil
 	r.shellExited = false
@@ -754,17 +1453,29 @@ func (r *Runner) Run(ctx context.Context, node syntax.Node) error {
 	switch node := node.(type) {
 	case *syntax.File:
 		r.filename = node.Name
+		r.startFile(ctx, node)
 		r.stmts(ctx, node.Stmts)
+		if r.signalled(ctx) {
+			// Like a shell killed by a signal; the exit trap still runs.
+			ctx = context.WithoutCancel(ctx)
+			r.fillExpandConfig(ctx)
+			r.shellExited = false
+		}
 		if !r.shellExited {
 			r.exitShell(ctx, r.exit)
 		}
 	case *syntax.Stmt:
+		if jc := r.jobControl; jc != nil && jc.owner == r {
+			jc.setStmt(node)
+		}
 		r.stmt(ctx, node)
 	case syntax.Command:
 		r.cmd(ctx, node)
 	default:
 		return fmt.Errorf("node can only be File, Stmt, or Command: %T", node)
 	}
+	r.signalled(ctx)
+	r.addStoppedJobs()
 	if r.exit != 0 {
 		r.setErr(NewExitStatus(uint8(r.exit)))
 	}
@@ -786,6 +1497,55 @@ func (r *Runner) Exited() bool {
 	return r.shellExited
 }
 
+// ExpandWord expands a single word with the runner's current state, as if it
+// were an argument to a simple command. Parameter expansions, command
+// substitutions, and globbing all behave like they would in a program run by
+// the runner, using the same variables and handlers. As such, it is useful
+// for handlers which need to expand words like the shell would.
+//
+// Unlike in a program run by the runner, expansion errors are returned rather
+// than printed to stderr, and they do not make the shell exit.
+//
+// ExpandWord is not safe to use concurrently with Run, but it may be called
+// from within a handler on the same runner.
+func (r *Runner) ExpandWord(ctx context.Context, word *syntax.Word) ([]string, error) {
+	defer r.useExpandConfig(ctx)()
+	return expand.Fields(r.ecfg, word)
+}
+
+// Arithm evaluates an arithmetic expression with the runner's current state,
+// as if it were within $(( and )). Any assignments are made to the runner's
+// variables.
+//
+// Like [Runner.ExpandWord], errors are returned rather than printed, and it is
+// not safe to use concurrently with Run.
+func (r *Runner) Arithm(ctx context.Context, expr syntax.ArithmExpr) (int, error) {
+	defer r.useExpandConfig(ctx)()
+	return expand.Arithm(r.ecfg, expr)
+}
+
+// Prompt expands a prompt string such as the value of $PS1 with the runner's
+// current state, via [expand.Prompt].
+//
+// Like [Runner.ExpandWord], errors are returned rather than printed, and it is
+// not safe to use concurrently with Run.
+func (r *Runner) Prompt(ctx context.Context, s string) (string, error) {
+	defer r.useExpandConfig(ctx)()
+	return expand.Prompt(r.ecfg, s)
+}
+
+// useExpandConfig sets up the runner's expand config to use the given context,
+// returning a func to restore the previous config, as the runner may be in the
+// middle of a Run call.
+func (r *Runner) useExpandConfig(ctx context.Context) (restore func()) {
+	if !r.didReset {
+		r.Reset()
+	}
+	ecfg, ectx := r.ecfg, r.ectx
+	r.fillExpandConfig(ctx)
+	return func() { r.ecfg, r.ectx = ecfg, ectx }
+}
+
 // Subshell makes a copy of the given Runner, suitable for use concurrently
 // with the original. The copy will have the same environment, including
 // variables and functions, but they can all be modified without affecting the
@@ -803,24 +1563,76 @@ func (r *Runner) Subshell() *Runner {
 	// Keep in sync with the Runner type. Manually copy fields, to not copy
 	// sensitive ones like errgroup.Group, and to do deep copies of slices.
 	r2 := &Runner{
-		Dir:            r.Dir,
-		Params:         r.Params,
-		callHandler:    r.callHandler,
-		execHandler:    r.execHandler,
-		openHandler:    r.openHandler,
-		readDirHandler: r.readDirHandler,
-		statHandler:    r.statHandler,
-		stdin:          r.stdin,
-		stdout:         r.stdout,
-		stderr:         r.stderr,
-		filename:       r.filename,
-		opts:           r.opts,
-		usedNew:        r.usedNew,
-		exit:           r.exit,
-		lastExit:       r.lastExit,
+		Dir:               r.Dir,
+		Params:            r.Params,
+		callHandler:       r.callHandler,
+		execHandler:       r.execHandler,
+		openHandler:       r.openHandler,
+		readDirHandler:    r.readDirHandler,
+		globHandler:       r.globHandler,
+		chdirHandler:      r.chdirHandler,
+		statHandler:       r.statHandler,
+		sourceHandler:     r.sourceHandler,
+		commandPolicy:     r.commandPolicy,
+		builtinPolicy:     r.builtinPolicy,
+		streams:           r.streams,
+		traceHooks:        r.traceHooks,
+		debugger:          r.debugger,
+		report:            r.report,
+		errExitMode:       r.errExitMode,
+		watchdog:          r.watchdog,
+		xtraceFormat:      r.xtraceFormat,
+		xtraceHandler:     r.xtraceHandler,
+		notFoundHandler:   r.notFoundHandler,
+		spanHandler:       r.spanHandler,
+		auditHandler:      r.auditHandler,
+		diagnosticHandler: r.diagnosticHandler,
+		jobOutputHandler:  r.jobOutputHandler,
+		pty:               r.pty,
+		jobControl:        r.jobControl,
+		fsys:              r.fsys,
+		program:           r.program,
+		interactive:       r.interactive,
+		history:           slices.Clip(r.history),
+		signalCfg:         r.signalCfg,
+		signals:           r.signals,
+		limits:            r.limits,
+		limitCounts:       r.limitCounts,
+		sandbox:           r.sandbox,
+		deterministic:     r.deterministic,
+		locale:            r.locale,
+		checkedArithm:     r.checkedArithm,
+		processLimits:     r.processLimits,
+		logger:            r.logger,
+		frozenTime:        r.frozenTime,
+		randSeed:          r.randSeed,
+		startTime:         r.startTime,
+		jobPIDs:           r.jobPIDs,
+		bgPID:             r.bgPID,
+		stdin:             r.stdin,
+		stdout:            r.stdout,
+		stderr:            r.stderr,
+		filename:          r.filename,
+		opts:              r.opts,
+		usedNew:           r.usedNew,
+		exit:              r.exit,
+		lastExit:          r.lastExit,
+		noErrExit:         r.noErrExit,
+		funcNames:         slices.Clip(r.funcNames),
+		callStack:         slices.Clip(r.callStack),
+		funcSources:       maps.Clone(r.funcSources),
+		lineno:            r.lineno,
+		fds:               r.fds,
+		xtraceLevel:       r.xtraceLevel,
+		inNotFoundHandle:  r.inNotFoundHandle,
+		umask:             r.umask,
+		hashed:            maps.Clone(r.hashed),
+		hashPath:          r.hashPath,
 
 		origStdout: r.origStdout, // used for process substitutions
 	}
+	// Like Bash, subshells get their own sequence of random numbers.
+	r2.random = rand.New(rand.NewSource(r.random.Int63()))
 	// Funcs are copied, since they might be modified.
 	// Env vars aren't copied; setVar will copy lists and maps as needed.
 	oenv := &overlayEnviron{parent: r.writeEnv}
@@ -834,3 +1646,44 @@ func (r *Runner) Subshell() *Runner {
 	r2.didReset = true
 	return r2
 }
+
+// Clone makes a copy of the given Runner which shares no mutable state with
+// the original, so that a prepared runner can be copied many times and each
+// copy can be run in its own goroutine.
+//
+// Unlike [Runner.Subshell], whose variables are read from the original's
+// environment as it is modified, the copy takes a snapshot of all variables.
+// Variable values themselves are shared rather than copied, as the runner never
+// modifies them in place, so cloning is cheap even with large environments.
+// Calling [Runner.Reset] on the copy brings it back to the state in which the
+// original was created.
+//
+// Clone is not safe to use concurrently with Run on the original runner.
+// Once the copy is made, both runners can be used concurrently.
+func (r *Runner) Clone() *Runner {
+	r2 := r.Subshell()
+	r2.Env = r.Env
+	r2.writeEnv = r.writeEnv.(*overlayEnviron).snapshot()
+	r2.Vars = maps.Clone(r.Vars)
+
+	r2.origDir = r.origDir
+	r2.origParams = r.origParams
+	r2.origOpts = r.origOpts
+	r2.origStdin = r.origStdin
+	r2.origStderr = r.origStderr
+
+	// Subshells share the counters of the original, but clones do not.
+	if r.limitCounts != nil {
+		r2.limitCounts = &limitCounts{}
+		r2.limitCounts.statements.Store(r.limitCounts.statements.Load())
+	}
+	if r.sandbox != nil {
+		r2.sandbox = &sandbox{cfg: r.sandbox.cfg}
+		r2.sandbox.iterations.Store(r.sandbox.iterations.Load())
+	}
+	if r.jobPIDs != nil {
+		r2.jobPIDs = new(atomic.Int64)
+		r2.jobPIDs.Store(r.jobPIDs.Load())
+	}
+	return r2
+}
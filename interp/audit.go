@@ -0,0 +1,81 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// AuditKind is the kind of action described by an [AuditEvent].
+type AuditKind uint8
+
+const (
+	AuditExec   AuditKind = iota // an external command is about to run
+	AuditOpen                    // a file is about to be opened for writing
+	AuditExport                  // an exported variable is being set
+	AuditChdir                   // the current directory is about to change
+)
+
+func (k AuditKind) String() string {
+	switch k {
+	case AuditExec:
+		return "exec"
+	case AuditOpen:
+		return "open"
+	case AuditExport:
+		return "export"
+	case AuditChdir:
+		return "chdir"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the kind as its name, such as "exec".
+func (k AuditKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// AuditEvent describes an action which changes the state of the system or of
+// the processes started by the runner, as given to an [AuditHandlerFunc].
+//
+// Events are sent when an action is attempted, so an action may still fail
+// or be denied, such as by a [CommandPolicyFunc].
+type AuditEvent struct {
+	Kind AuditKind `json:"kind"`
+
+	// Time is when the action was attempted.
+	Time time.Time `json:"time"`
+
+	// Args holds the name and arguments of a command, for AuditExec.
+	Args []string `json:"args,omitempty"`
+
+	// Path is the absolute path of the file being opened for AuditOpen,
+	// or of the new directory for AuditChdir.
+	Path string `json:"path,omitempty"`
+
+	// Flags holds the flags used to open a file, like [os.O_WRONLY],
+	// for AuditOpen.
+	Flags int `json:"flags,omitempty"`
+
+	// Name and Value describe the variable for AuditExport.
+	// For arrays, Value is the first element.
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// audit sends an event to the audit handler, if any.
+// The variables set up by Reset are not audited.
+func (r *Runner) audit(ctx context.Context, ev AuditEvent) {
+	if r.auditHandler == nil || !r.didReset {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ev.Time = time.Now()
+	ev.Args = slices.Clone(ev.Args)
+	r.auditHandler(r.handlerCtx(ctx), ev)
+}
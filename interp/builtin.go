@@ -9,28 +9,44 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"github.com/muesli/cancelreader"
 	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/syntax"
 )
 
+// builtinNames holds the names of the builtins implemented by [Runner],
+// sorted.
+var builtinNames = []string{
+	".", ":", "[", "alias", "bg", "break", "builtin", "cd", "command",
+	"continue", "dirs", "echo", "eval", "exec", "exit", "false", "fc",
+	"fg", "getopts", "hash", "jobs", "mapfile", "popd", "printf", "pushd",
+	"pwd", "read", "readarray", "return", "set", "shift", "shopt", "source",
+	"test", "times", "trap", "true", "type", "ulimit", "umask", "unalias",
+	"unset", "wait",
+}
+
 func isBuiltin(name string) bool {
-	switch name {
-	case "true", ":", "false", "exit", "set", "shift", "unset",
-		"echo", "printf", "break", "continue", "pwd", "cd",
-		"wait", "builtin", "trap", "type", "source", ".", "command",
-		"dirs", "pushd", "popd", "umask", "alias", "unalias",
-		"fg", "bg", "getopts", "eval", "test", "[", "exec",
-		"return", "read", "mapfile", "readarray", "shopt":
-		return true
-	}
-	return false
+	_, found := slices.BinarySearch(builtinNames, name)
+	return found
+}
+
+// Builtins returns the names of the builtin commands implemented by [Runner],
+// sorted. This can be useful to complete command names in interactive shells.
+func Builtins() []string {
+	return slices.Clone(builtinNames)
 }
 
 // TODO: oneIf and atoi are duplicated in the expand package.
@@ -113,13 +129,26 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			}
 		}
 
+		exit := 0
 		for _, arg := range args {
-			if vars && r.lookupVar(arg).IsSet() {
+			if name, index, ok := strings.Cut(arg, "["); ok && vars && strings.HasSuffix(index, "]") {
+				if !r.unsetVarElem(name, strings.TrimSuffix(index, "]")) {
+					exit = 1
+				}
+				continue
+			}
+			if vr := r.lookupVar(arg); vars && vr.IsSet() {
+				if vr.ReadOnly {
+					r.errf("unset: %s: cannot unset: readonly variable\n", arg)
+					exit = 1
+					continue
+				}
 				r.delVar(arg)
 			} else if _, ok := r.Funcs[arg]; ok && funcs {
 				delete(r.Funcs, arg)
 			}
 		}
+		return exit
 	case "echo":
 		newline, doExpand := true, false
 	echoOpts:
@@ -148,23 +177,48 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			r.out("\n")
 		}
 	case "printf":
+		var dest string
+		fp := flagParser{remaining: args}
+		for fp.more() {
+			switch flag := fp.flag(); flag {
+			case "-v":
+				dest = fp.value()
+				if dest == "" {
+					r.errf("printf: -v: option requires an argument\n")
+					return 2
+				}
+			default:
+				r.errf("printf: invalid option %q\n", flag)
+				return 2
+			}
+		}
+		args := fp.args()
 		if len(args) == 0 {
 			r.errf("usage: printf format [arguments]\n")
 			return 2
 		}
 		format, args := args[0], args[1:]
+		var sb strings.Builder
+		exit := 0
 		for {
 			s, n, err := expand.Format(r.ecfg, format, args)
+			sb.WriteString(s)
 			if err != nil {
 				r.errf("%v\n", err)
-				return 1
+				exit = 1
 			}
-			r.out(s)
 			args = args[n:]
 			if n == 0 || len(args) == 0 {
 				break
 			}
 		}
+		if dest == "" {
+			r.out(sb.String())
+		} else if !r.setVarRef(dest, sb.String()) {
+			r.errf("printf: %q: not a valid identifier\n", dest)
+			return 2
+		}
+		return exit
 	case "break", "continue":
 		if !r.inLoop {
 			r.errf("%s is only useful in a loop\n", name)
@@ -223,21 +277,200 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			// ref: https://www.man7.org/linux/man-pages/man1/cd.1p.html#OPERANDS
 			if path == "-" {
 				path = r.envGet("OLDPWD")
+				if path == "" {
+					r.errf("cd: OLDPWD not set\n")
+					return 1
+				}
 				r.outf("%s\n", path)
 			}
 		default:
 			r.errf("usage: cd [dir]\n")
 			return 2
 		}
-		return r.changeDir(ctx, path)
+		return r.changeDir(ctx, "cd", path)
 	case "wait":
-		if len(args) > 0 {
-			panic("wait with args not handled yet")
+		fp := flagParser{remaining: args}
+		anyJob := false
+		pidVar := ""
+		for fp.more() {
+			switch flag := fp.flag(); flag {
+			case "-n":
+				anyJob = true
+			case "-f":
+				// stopped jobs are always waited for until they finish
+			case "-p":
+				if pidVar = fp.value(); pidVar == "" {
+					r.errf("wait: -p: option requires an argument\n")
+					return 2
+				}
+			default:
+				r.errf("wait: %s: invalid option\n", flag)
+				r.errf("wait: usage: wait [-fn] [-p var] [id ...]\n")
+				return 2
+			}
+		}
+		args := fp.args()
+		if pidVar != "" {
+			r.delVar(pidVar)
+		}
+		if len(args) == 0 && !anyJob {
+			err := r.bgShells.Wait()
+			if _, ok := IsExitStatus(err); err != nil && !ok {
+				r.setErr(err)
+			}
+			for _, job := range r.bgJobs {
+				job.listed = false
+				job.remembered = false
+			}
+			return 0
+		}
+		if !anyJob {
+			exit := 0
+			for _, arg := range args {
+				job := r.findJob(arg)
+				if job == nil {
+					if strings.HasPrefix(arg, "%") {
+						r.errf("wait: %s: no such job\n", arg)
+					} else {
+						r.errf("wait: pid %s is not a child of this shell\n", arg)
+					}
+					exit = 127
+					continue
+				}
+				if r.waitJob(ctx, []*bgJob{job}) == nil {
+					return 1 // cancelled
+				}
+				// Like Bash, the job can still be waited for again.
+				exit = int(job.exit)
+				if pidVar != "" {
+					r.setVarString(pidVar, strconv.Itoa(job.pid))
+				}
+			}
+			return exit
+		}
+		// With -n, wait for the next of the listed jobs to finish.
+		var jobs []*bgJob
+		for _, arg := range args {
+			if job := r.findJob(arg); job != nil && job.listed {
+				jobs = append(jobs, job)
+			} else {
+				r.errf("wait: %s: no such job\n", arg)
+			}
+		}
+		if len(args) == 0 {
+			for _, job := range r.bgJobs {
+				if job.listed {
+					jobs = append(jobs, job)
+				}
+			}
 		}
-		err := r.bgShells.Wait()
-		if _, ok := IsExitStatus(err); err != nil && !ok {
-			r.setErr(err)
+		if len(jobs) == 0 {
+			return 127
 		}
+		job := r.waitJob(ctx, jobs)
+		if job == nil {
+			return 1 // cancelled
+		}
+		job.listed = false
+		if pidVar != "" {
+			r.setVarString(pidVar, strconv.Itoa(job.pid))
+		}
+		return int(job.exit)
+	case "jobs":
+		r.addStoppedJobs()
+		fp := flagParser{remaining: args}
+		long, pidsOnly := false, false
+		for fp.more() {
+			switch flag := fp.flag(); flag {
+			case "-l":
+				long = true
+			case "-p":
+				pidsOnly = true
+			default:
+				r.errf("jobs: %s: invalid option\n", flag)
+				r.errf("jobs: usage: jobs [-lp] [jobspec ...]\n")
+				return 2
+			}
+		}
+		exit := 0
+		var jobs []*bgJob
+		for _, arg := range fp.args() {
+			if job := r.findJob(arg); job != nil && job.listed {
+				jobs = append(jobs, job)
+			} else {
+				r.errf("jobs: %s: no such job\n", arg)
+				exit = 1
+			}
+		}
+		if len(fp.args()) == 0 {
+			for _, job := range r.bgJobs {
+				if job.listed {
+					jobs = append(jobs, job)
+				}
+			}
+		}
+		for _, job := range jobs {
+			if pidsOnly {
+				r.outf("%d\n", job.pid)
+			} else {
+				r.out(r.formatJob(job, long))
+			}
+		}
+		// Like Bash, finished jobs are no longer listed once reported.
+		for _, job := range jobs {
+			select {
+			case <-job.done:
+				job.listed = false
+			default:
+			}
+		}
+		return exit
+	case "fg", "bg":
+		r.addStoppedJobs()
+		if r.jobControl == nil {
+			r.errf("%s: no job control\n", name)
+			return 1
+		}
+		if len(args) > 1 {
+			r.errf("%s: usage: %s [job_spec]\n", name, name)
+			return 2
+		}
+		spec, desc := "%%", "current"
+		if len(args) == 1 {
+			spec, desc = args[0], args[0]
+		}
+		job := r.findJob(spec)
+		if job == nil || !job.listed {
+			r.errf("%s: %s: no such job\n", name, desc)
+			return 1
+		}
+		select {
+		case <-job.done:
+			r.errf("%s: job has terminated\n", name)
+			job.listed = false
+			return 1
+		default:
+		}
+		if name == "bg" {
+			if job.group == nil || r.jobState(job) != "Stopped" {
+				r.errf("bg: job %d already in background\n", job.id)
+				return 0
+			}
+			r.resumeJob(ctx, job, false)
+			r.outf("[%d] %s &\n", job.id, job.text)
+			return 0
+		}
+		r.outf("%s\n", job.text)
+		if job.group != nil {
+			return r.resumeJob(ctx, job, true)
+		}
+		// A job started via "&" runs within the interpreter,
+		// so it cannot take the terminal; we simply wait for it.
+		if r.waitJob(ctx, []*bgJob{job}) == nil {
+			return 1 // cancelled
+		}
+		job.listed = false
+		return int(job.exit)
 	case "builtin":
 		if len(args) < 1 {
 			break
@@ -245,7 +478,7 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 		if !isBuiltin(args[0]) {
 			return 1
 		}
-		return r.builtinCode(ctx, pos, args[0], args[1:])
+		return r.builtin(ctx, pos, args[0], args[1:])
 	case "type":
 		anyNotFound := false
 		mode := ""
@@ -314,6 +547,15 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 				}
 				continue
 			}
+			r.hashSync(r.writeEnv)
+			if entry, ok := r.hashed[arg]; ok {
+				if mode == "-t" {
+					r.out("file\n")
+				} else {
+					r.outf("%s is hashed (%s)\n", arg, entry.path)
+				}
+				continue
+			}
 			if path, err := LookPathDir(r.Dir, r.writeEnv, arg); err == nil {
 				if mode == "-t" {
 					r.out("file\n")
@@ -345,15 +587,7 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			r.errf("%v: source: need filename\n", pos)
 			return 2
 		}
-		path, err := scriptFromPathDir(r.Dir, r.writeEnv, args[0])
-		if err != nil {
-			// If the script was not found in PATH or there was any error, pass
-			// the source path to the open handler so it has a chance to look
-			// at files it manages (eg: virtual filesystem), and also allow
-			// it to look for the sourced script in the current directory.
-			path = args[0]
-		}
-		f, err := r.open(ctx, path, os.O_RDONLY, 0, false)
+		path, f, err := r.sourceHandler(r.handlerCtx(ctx), args[0])
 		if err != nil {
 			r.errf("source: %v\n", err)
 			return 1
@@ -382,7 +616,10 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 		// parameters.
 		r.sourceSetParams = false
 		r.inSource = true // know that we're inside a sourced script.
+		r.callStack = append(r.callStack, callFrame{"source", path, pos.Line()})
+		r.startFile(ctx, file)
 		r.stmts(ctx, file.Stmts)
+		r.callStack = r.callStack[:len(r.callStack)-1]
 
 		// If we modified the parameters and the sourced file didn't
 		// explicitly set them, we restore the old ones.
@@ -449,7 +686,7 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 		}
 		if !show {
 			if isBuiltin(args[0]) {
-				return r.builtinCode(ctx, pos, args[0], args[1:])
+				return r.builtin(ctx, pos, args[0], args[1:])
 			}
 			r.exec(ctx, args)
 			return r.exit
@@ -467,13 +704,58 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 		}
 		return last
 	case "dirs":
+		long, lines, verbose := false, false, false
+		index := -1
+		for _, arg := range args {
+			if isDirStackIndex(arg) {
+				i, err := r.dirStackIndex(arg)
+				if err != nil {
+					r.errf("dirs: %s: %v\n", arg, err)
+					return 1
+				}
+				index = i
+				continue
+			}
+			if len(arg) < 2 || arg[0] != '-' {
+				r.errf("dirs: invalid argument %q\n", arg)
+				return 2
+			}
+			for _, c := range arg[1:] {
+				switch c {
+				case 'c':
+					r.dirStack = append(r.dirStack[:0], r.Dir)
+					return 0
+				case 'l':
+					long = true
+				case 'p':
+					lines = true
+				case 'v':
+					lines, verbose = true, true
+				default:
+					r.errf("dirs: invalid option \"-%c\"\n", c)
+					return 2
+				}
+			}
+		}
+		if index >= 0 {
+			r.outf("%s\n", r.dirsEntry(r.dirStack[index], long))
+			break
+		}
 		for i := len(r.dirStack) - 1; i >= 0; i-- {
-			r.outf("%s", r.dirStack[i])
-			if i > 0 {
+			if verbose {
+				r.outf("%2d  ", len(r.dirStack)-1-i)
+			}
+			r.outf("%s", r.dirsEntry(r.dirStack[i], long))
+			switch {
+			case lines:
+				r.out("\n")
+			case i > 0:
 				r.out(" ")
 			}
 		}
-		r.out("\n")
+		if !lines {
+			r.out("\n")
+		}
 	case "pushd":
 		change := true
 		if len(args) > 0 && args[0] == "-n" {
@@ -497,15 +779,38 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 				return 1
 			}
 			newtop := swap()
-			if code := r.changeDir(ctx, newtop); code != 0 {
+			if code := r.changeDir(ctx, "pushd", newtop); code != 0 {
 				return code
 			}
 			r.builtinCode(ctx, syntax.Pos{}, "dirs", nil)
 		case 1:
-			if change {
-				if code := r.changeDir(ctx, args[0]); code != 0 {
-					return code
+			if isDirStackIndex(args[0]) {
+				i, err := r.dirStackIndex(args[0])
+				if err != nil {
+					r.errf("pushd: %s: %v\n", args[0], err)
+					return 1
+				}
+				old := slices.Clone(r.dirStack)
+				// Rotate the stack so that the chosen directory is on top.
+				rotated := append(slices.Clone(r.dirStack[i+1:]), r.dirStack[:i+1]...)
+				r.dirStack = append(r.dirStack[:0], rotated...)
+				if change {
+					if code := r.changeDir(ctx, "pushd", r.dirStack[len(r.dirStack)-1]); code != 0 {
+						r.dirStack = append(r.dirStack[:0], old...)
+						return code
+					}
+				}
+			} else if change {
+				oldtop := r.Dir
+				if err := r.chdir(ctx, args[0]); err != nil {
+					var pathErr *fs.PathError
+					if errors.As(err, &pathErr) {
+						err = pathErr.Err
+					}
+					r.errf("pushd: %s: %v\n", args[0], err)
+					return 1
 				}
+				r.dirStack[len(r.dirStack)-1] = oldtop
 				r.dirStack = append(r.dirStack, r.Dir)
 			} else {
 				r.dirStack = append(r.dirStack, args[0])
@@ -522,27 +827,40 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			change = false
 			args = args[1:]
 		}
+		// By default, remove the top directory,
+		// or the one below it if we don't change directory.
+		index := len(r.dirStack) - 1
+		if !change {
+			index--
+		}
 		switch len(args) {
 		case 0:
-			if len(r.dirStack) < 2 {
-				r.errf("popd: directory stack empty\n")
-				return 1
+		case 1:
+			if !isDirStackIndex(args[0]) {
+				r.errf("popd: invalid argument\n")
+				return 2
 			}
-			oldtop := r.dirStack[len(r.dirStack)-1]
-			r.dirStack = r.dirStack[:len(r.dirStack)-1]
-			if change {
-				newtop := r.dirStack[len(r.dirStack)-1]
-				if code := r.changeDir(ctx, newtop); code != 0 {
-					return code
-				}
-			} else {
-				r.dirStack[len(r.dirStack)-1] = oldtop
+			i, err := r.dirStackIndex(args[0])
+			if err != nil {
+				r.errf("popd: %s: %v\n", args[0], err)
+				return 1
 			}
-			r.builtinCode(ctx, syntax.Pos{}, "dirs", nil)
+			index = i
 		default:
 			r.errf("popd: invalid argument\n")
 			return 2
 		}
+		if len(r.dirStack) < 2 {
+			r.errf("popd: directory stack empty\n")
+			return 1
+		}
+		if top := len(r.dirStack) - 1; index == top && change {
+			if code := r.changeDir(ctx, "popd", r.dirStack[top-1]); code != 0 {
+				return code
+			}
+		}
+		r.dirStack = slices.Delete(r.dirStack, index, index+1)
+		r.builtinCode(ctx, syntax.Pos{}, "dirs", nil)
 	case "return":
 		if !r.inFunc && !r.inSource {
 			r.errf("return: can only be done from a func or sourced script\n")
@@ -559,19 +877,72 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 		}
 		r.setErr(returnStatus(code))
 	case "read":
-		var prompt string
-		raw := false
+		var prompt, arrayName string
+		raw, silent := false, false
+		delim, nchars, exact := '\n', -1, false
+		var timeout time.Duration
+		in := r.stdin
 		fp := flagParser{remaining: args}
 		for fp.more() {
 			switch flag := fp.flag(); flag {
 			case "-r":
 				raw = true
+			case "-s":
+				silent = true
 			case "-p":
 				prompt = fp.value()
 				if prompt == "" {
 					r.errf("read: -p: option requires an argument\n")
 					return 2
 				}
+			case "-a":
+				arrayName = fp.value()
+				if arrayName == "" {
+					r.errf("read: -a: option requires an argument\n")
+					return 2
+				}
+			case "-d":
+				if !fp.hasValue() {
+					r.errf("read: -d: option requires an argument\n")
+					return 2
+				}
+				// An empty delimiter means the null byte.
+				delim = 0
+				if value := fp.value(); value != "" {
+					delim = rune(value[0])
+				}
+			case "-n", "-N":
+				value := fp.value()
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 0 {
+					r.errf("read: %s: invalid number\n", value)
+					return 1
+				}
+				nchars, exact = n, flag == "-N"
+			case "-t":
+				value := fp.value()
+				secs, err := strconv.ParseFloat(value, 64)
+				if err != nil || secs < 0 {
+					r.errf("read: %s: invalid timeout specification\n", value)
+					return 1
+				}
+				timeout = time.Duration(secs * float64(time.Second))
+				if timeout == 0 {
+					// Don't read anything; just check if there's input.
+					timeout = -1
+				}
+			case "-u":
+				value := fp.value()
+				fd, err := strconv.Atoi(value)
+				switch {
+				case err == nil && fd == 0:
+					in = r.stdin
+				case err == nil && r.fds[fd].reader() != nil:
+					in = r.fds[fd].reader()
+				default:
+					r.errf("read: %s: invalid file descriptor\n", value)
+					return 1
+				}
 			default:
 				r.errf("read: invalid option %q\n", flag)
 				return 2
@@ -579,6 +950,9 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 		}
 
 		args := fp.args()
+		if arrayName != "" {
+			args = []string{arrayName}
+		}
 		for _, name := range args {
 			if !syntax.ValidName(name) {
 				r.errf("read: invalid identifier %q\n", name)
@@ -586,27 +960,70 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			}
 		}
 
+		if timeout < 0 {
+			if in == nil {
+				return 1
+			}
+			// Only files may not have any input ready.
+			f, ok := in.(*os.File)
+			return oneIf(ok && !hasInput(f))
+		}
+
 		if prompt != "" {
 			r.out(prompt)
 		}
 
-		line, err := r.readLine(ctx, raw)
+		if f, ok := in.(*os.File); ok && silent {
+			// Not echoing input only makes sense on terminals.
+			if restore, err := noEcho(f); err == nil {
+				defer restore()
+			}
+		}
+
+		readCtx := ctx
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			readCtx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		if exact {
+			delim = -1
+		}
+		line, err := r.readDelim(readCtx, in, raw, delim, nchars)
 		if len(args) == 0 {
 			args = append(args, shellReplyVar)
 		}
 
-		values := expand.ReadFields(r.ecfg, string(line), len(args), raw)
-		for i, name := range args {
-			val := ""
-			if i < len(values) {
-				val = values[i]
+		switch {
+		case arrayName != "":
+			values := expand.ReadFields(r.ecfg, string(line), -1, raw)
+			r.setVar(arrayName, nil, expand.Variable{Kind: expand.Indexed, List: values})
+		case exact:
+			// The input is not split into fields.
+			if !raw {
+				line = unescapeRead(line)
+			}
+			r.setVarString(args[0], string(line))
+			for _, name := range args[1:] {
+				r.setVarString(name, "")
+			}
+		default:
+			values := expand.ReadFields(r.ecfg, string(line), len(args), raw)
+			for i, name := range args {
+				val := ""
+				if i < len(values) {
+					val = values[i]
+				}
+				r.setVarString(name, val)
 			}
-			r.setVarString(name, val)
 		}
 
 		// We can get data back from readLine and an error at the same time, so
 		// check err after we process the data.
 		if err != nil {
+			if ctx.Err() == nil && readCtx.Err() == context.DeadlineExceeded {
+				return 128 + 14 // SIGALRM, like bash
+			}
 			return 1
 		}
 
@@ -618,10 +1035,10 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			return 2
 		}
 		optind, _ := strconv.Atoi(r.envGet("OPTIND"))
+		if optind < 1 {
+			optind = 1
+		}
 		if optind-1 != r.optState.argidx {
-			if optind < 1 {
-				optind = 1
-			}
 			r.optState = getopts{argidx: optind - 1}
 		}
 		optstr := args[0]
@@ -634,31 +1051,155 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 		if len(args) == 0 {
 			args = r.Params
 		}
-		diagnostics := !strings.HasPrefix(optstr, ":")
+		// A leading colon selects silent error reporting, where OPTARG
+		// holds the offending option instead of printing diagnostics.
+		silent := strings.HasPrefix(optstr, ":")
+		diagnostics := !silent && r.envGet("OPTERR") != "0"
 
-		opt, optarg, done := r.optState.next(optstr, args)
+		state := r.optState
+		opt, optarg, hasArg, done := state.next(optstr, args)
 
-		r.setVarString(name, string(opt))
 		r.delVar("OPTARG")
 		switch {
-		case opt == '?' && diagnostics && !done:
-			r.errf("getopts: illegal option -- %q\n", optarg)
-		case opt == ':' && diagnostics:
-			r.errf("getopts: option requires an argument -- %q\n", optarg)
+		case done:
+			r.setVarString(name, "?")
+		case opt == '?':
+			r.setVarString(name, "?")
+			if silent {
+				r.setVarString("OPTARG", optarg)
+			} else if diagnostics {
+				r.errf("%s: illegal option -- %s\n", r.envGet("0"), optarg)
+			}
+		case opt == ':':
+			if silent {
+				r.setVarString(name, ":")
+				r.setVarString("OPTARG", optarg)
+			} else {
+				r.setVarString(name, "?")
+				if diagnostics {
+					r.errf("%s: option requires an argument -- %s\n", r.envGet("0"), optarg)
+				}
+			}
 		default:
-			if optarg != "" {
+			r.setVarString(name, string(opt))
+			if hasArg {
 				r.setVarString("OPTARG", optarg)
 			}
 		}
-		if optind-1 != r.optState.argidx {
-			r.setVarString("OPTIND", strconv.FormatInt(int64(r.optState.argidx+1), 10))
-		}
+		r.setVarString("OPTIND", strconv.Itoa(state.argidx+1))
+		// Set the state last, as assigning OPTIND resets it.
+		r.optState = state
 
 		return oneIf(done)
 
+	case "fc":
+		list, numbers, reverse, rerun := false, true, false, false
+		fp := flagParser{remaining: args}
+		for fp.more() {
+			if _, err := strconv.Atoi(fp.remaining[0]); err == nil && fp.current == "" {
+				break // a negative offset like "-2"
+			}
+			switch flag := fp.flag(); flag {
+			case "-l":
+				list = true
+			case "-n":
+				numbers = false
+			case "-r":
+				reverse = true
+			case "-s":
+				rerun = true
+			case "-e":
+				if editor := fp.value(); editor != "-" {
+					r.errf("fc: editing the history is not supported\n")
+					return 2
+				}
+				rerun = true
+			default:
+				r.errf("fc: invalid option %q\n", flag)
+				r.errf("fc: usage: fc [-e ename] [-lnr] [first] [last] or fc -s [pat=rep] [command]\n")
+				return 2
+			}
+		}
+		args := fp.args()
+		// The last command in the history is the one running fc.
+		hist := r.history
+		if len(hist) > 0 {
+			hist = hist[:len(hist)-1]
+		}
+		switch {
+		case list:
+			if len(hist) == 0 {
+				break
+			}
+			first, last := len(hist)-16, len(hist)-1
+			if len(args) > 0 {
+				var ok bool
+				if first, ok = historyRange(hist, args[0]); !ok {
+					r.errf("fc: history specification out of range\n")
+					return 1
+				}
+				last = len(hist) - 1
+				if len(args) > 1 {
+					if last, ok = historyRange(hist, args[1]); !ok {
+						r.errf("fc: history specification out of range\n")
+						return 1
+					}
+				}
+			}
+			first = max(first, 0)
+			if first > last {
+				first, last = last, first
+				reverse = !reverse
+			}
+			for i := 0; i <= last-first; i++ {
+				n := first + i
+				if reverse {
+					n = last - i
+				}
+				if numbers {
+					r.outf("%d", n+1)
+				}
+				r.outf("\t %s\n", hist[n])
+			}
+		case rerun:
+			var old, repl string
+			if len(args) > 0 && strings.Contains(args[0], "=") {
+				old, repl, _ = strings.Cut(args[0], "=")
+				args = args[1:]
+			}
+			n, ok := len(hist)-1, len(hist) > 0
+			if len(args) > 0 {
+				n, ok = historyRange(hist, args[0])
+			}
+			if !ok {
+				r.errf("fc: no command found\n")
+				return 1
+			}
+			cmd := hist[n]
+			if old != "" {
+				cmd = strings.ReplaceAll(cmd, old, repl)
+			}
+			r.errf("%s\n", cmd)
+			// Like Bash, replace the fc command in the history.
+			// Don't modify the slice in place, as subshells share it.
+			if len(r.history) > 0 {
+				r.history = append(r.history[:len(r.history)-1:len(r.history)-1], cmd)
+			}
+			file, err := syntax.NewParser().Parse(strings.NewReader(cmd), "")
+			if err != nil {
+				r.errf("fc: %v\n", err)
+				return 1
+			}
+			r.stmts(ctx, file.Stmts)
+			return r.exit
+		default:
+			r.errf("fc: editing the history is not supported\n")
+			return 2
+		}
+
 	case "shopt":
 		mode := ""
-		posixOpts := false
+		posixOpts, print, quiet := false, false, false
 		fp := flagParser{remaining: args}
 		for fp.more() {
 			switch flag := fp.flag(); flag {
@@ -666,8 +1207,10 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 				mode = flag
 			case "-o":
 				posixOpts = true
-			case "-p", "-q":
-				panic(fmt.Sprintf("unhandled shopt flag: %s", flag))
+			case "-p":
+				print = true
+			case "-q":
+				quiet = true
 			default:
 				r.errf("shopt: invalid option %q\n", flag)
 				return 2
@@ -678,15 +1221,25 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 		if len(args) == 0 {
 			if bash {
 				for i, opt := range bashOptsTable {
-					r.printOptLine(opt.name, r.opts[len(shellOptsTable)+i], opt.supported)
+					if print {
+						r.printOptCmd(opt.name, r.opts[len(shellOptsTable)+i], false)
+					} else {
+						r.printOptLine(opt.name, r.opts[len(shellOptsTable)+i], opt.supported)
+					}
 				}
 				break
 			}
 			for i, opt := range &shellOptsTable {
-				r.printOptLine(opt.name, r.opts[i], true)
+				if print {
+					r.printOptCmd(opt.name, r.opts[i], true)
+				} else {
+					r.printOptLine(opt.name, r.opts[i], true)
+				}
 			}
 			break
 		}
+		// When querying options, the status is 1 if any of them is off.
+		status := 0
 		for _, arg := range args {
 			i, opt := r.optByName(arg, bash)
 			if opt == nil {
@@ -710,11 +1263,22 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 					return 1
 				}
 				*opt = mode == "-s"
-			default: // ""
-				r.printOptLine(arg, *opt, supported)
+				continue
+			}
+			enabled, _ := r.Opt(arg)
+			if !enabled {
+				status = 1
+			}
+			switch {
+			case quiet:
+			case print:
+				r.printOptCmd(arg, enabled, !bash)
+			default:
+				r.printOptLine(arg, enabled, supported)
 			}
 		}
 		r.updateExpandOpts()
+		return status
 
 	case "alias":
 		show := func(name string, als alias) {
@@ -797,6 +1361,11 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			if r.callbackExit != "" {
 				r.outf("trap -- %q EXIT\n", r.callbackExit)
 			}
+			for _, ts := range trapSignals {
+				if trap, ok := r.callbackSignals[ts.sig]; ok {
+					r.outf("trap -- %q SIG%s\n", trap, ts.name)
+				}
+			}
 			if r.callbackErr != "" {
 				r.outf("trap -- %q ERR\n", r.callbackErr)
 			}
@@ -806,26 +1375,44 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			callback = args[0]
 			args = args[1:]
 		}
-		// For now, treat both empty and - the same since ERR and EXIT have no
-		// default callback.
-		if callback == "-" {
-			callback = ""
-		}
 		for _, arg := range args {
 			switch arg {
-			case "ERR":
-				r.callbackErr = callback
-			case "EXIT":
-				r.callbackExit = callback
+			case "ERR", "EXIT":
+				// For now, treat both empty and - the same since ERR
+				// and EXIT have no default callback.
+				trap := callback
+				if trap == "-" {
+					trap = ""
+				}
+				if arg == "ERR" {
+					r.callbackErr = trap
+				} else {
+					r.callbackExit = trap
+				}
 			default:
-				r.errf("trap: %s: invalid signal specification\n", arg)
-				return 2
+				sig, ok := trapSignal(arg)
+				if !ok {
+					r.errf("trap: %s: invalid signal specification\n", arg)
+					return 2
+				}
+				if callback == "-" {
+					delete(r.callbackSignals, sig)
+				} else {
+					if r.callbackSignals == nil {
+						r.callbackSignals = make(map[syscall.Signal]string)
+					}
+					r.callbackSignals[sig] = callback
+				}
 			}
 		}
+		r.syncTraps()
 
 	case "readarray", "mapfile":
 		dropDelim := false
 		delim := "\n"
+		in := r.stdin
+		count, origin, skip := 0, -1, 0
+		quantum, callback := 5000, ""
 		fp := flagParser{remaining: args}
 		for fp.more() {
 			switch flag := fp.flag(); flag {
@@ -843,6 +1430,53 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 					// string.
 					delim = "\x00"
 				}
+			case "-n", "-O", "-s", "-c":
+				if !fp.hasValue() {
+					r.errf("%s: %s: option requires an argument\n", name, flag)
+					return 2
+				}
+				value := fp.value()
+				n, err := strconv.Atoi(value)
+				switch {
+				case flag == "-n" && (err != nil || n < 0),
+					flag == "-s" && (err != nil || n < 0):
+					r.errf("%s: %s: invalid line count\n", name, value)
+					return 1
+				case flag == "-O" && (err != nil || n < 0):
+					r.errf("%s: %s: invalid array origin\n", name, value)
+					return 1
+				case flag == "-c" && (err != nil || n <= 0):
+					r.errf("%s: %s: invalid callback quantum\n", name, value)
+					return 1
+				}
+				switch flag {
+				case "-n":
+					count = n
+				case "-O":
+					origin = n
+				case "-s":
+					skip = n
+				case "-c":
+					quantum = n
+				}
+			case "-C":
+				if !fp.hasValue() {
+					r.errf("%s: -C: option requires an argument\n", name)
+					return 2
+				}
+				callback = fp.value()
+			case "-u":
+				value := fp.value()
+				fd, err := strconv.Atoi(value)
+				switch {
+				case err == nil && fd == 0:
+					in = r.stdin
+				case err == nil && r.fds[fd].reader() != nil:
+					in = r.fds[fd].reader()
+				default:
+					r.errf("%s: %s: invalid file descriptor\n", name, value)
+					return 1
+				}
 			default:
 				r.errf("%s: invalid option %q\n", name, flag)
 				return 2
@@ -864,51 +1498,551 @@ func (r *Runner) builtinCode(ctx context.Context, pos syntax.Pos, name string, a
 			r.errf("%s: Only one array name may be specified, %v\n", name, args)
 			return 2
 		}
+		if in == nil {
+			r.errf("%s: can't read, there's no stdin\n", name)
+			return 1
+		}
 
 		var vr expand.Variable
 		vr.Kind = expand.Indexed
-		scanner := bufio.NewScanner(r.stdin)
-		scanner.Split(mapfileSplit(delim[0], dropDelim))
-		for scanner.Scan() {
-			vr.List = append(vr.List, scanner.Text())
+		if origin < 0 {
+			// Without -O, the array is cleared first.
+			origin = 0
+		} else if prev := r.lookupVar(arrayName); prev.Kind == expand.Indexed {
+			vr.List = slices.Clone(prev.List)
+			vr.Holes = slices.Clone(prev.Holes)
+		} else if prev.Kind == expand.String {
+			vr.List = []string{prev.Str}
+		}
+		var br io.ByteReader
+		if count > 0 {
+			// Don't read past the lines we need,
+			// as the rest of the input may be used by other commands.
+			br = oneByteReader{in}
+		} else {
+			br = bufio.NewReader(in)
 		}
-		if err := scanner.Err(); err != nil {
-			r.errf("%s: unable to read, %v\n", name, err)
-			return 2
+		index := origin
+		for lines := 0; count == 0 || lines < count+skip; lines++ {
+			line, err := readRecord(br, delim[0])
+			if err != nil {
+				r.errf("%s: unable to read, %v\n", name, err)
+				return 2
+			}
+			if line == "" {
+				break // EOF
+			}
+			if lines < skip {
+				continue
+			}
+			if dropDelim {
+				line = strings.TrimSuffix(line, delim[:1])
+			}
+			if callback != "" && (index-origin+1)%quantum == 0 {
+				quoted, err := syntax.Quote(line, syntax.LangBash)
+				if err != nil {
+					r.errf("%s: %v\n", name, err)
+					return 1
+				}
+				src := callback + " " + strconv.Itoa(index) + " " + quoted
+				file, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+				if err != nil {
+					r.errf("%s: %v\n", name, err)
+					return 1
+				}
+				r.stmts(ctx, file.Stmts)
+			}
+			if n := len(vr.List); index > n {
+				// With -O past the end of the array,
+				// the indexes in between are left unset.
+				vr.Holes = append(vr.Holes, make([]bool, n-len(vr.Holes))...)
+				for ; n < index; n++ {
+					vr.List = append(vr.List, "")
+					vr.Holes = append(vr.Holes, true)
+				}
+			}
+			if index < len(vr.List) {
+				vr.List[index] = line
+				if index < len(vr.Holes) {
+					vr.Holes[index] = false
+				}
+			} else {
+				vr.List = append(vr.List, line)
+			}
+			index++
 		}
 		r.setVarInternal(arrayName, vr)
 
 		return 0
 
+	case "umask":
+		// Note that the mask only applies to the files created by the shell
+		// via redirections, as per [Runner.open]; the programs it runs
+		// inherit the process umask, which we cannot change for them alone.
+		symbolic, printCmd := false, false
+		// Not using flagParser, as symbolic modes may start with '+'.
+		for len(args) > 0 && len(args[0]) > 1 && args[0][0] == '-' {
+			arg := args[0]
+			args = args[1:]
+			if arg == "--" {
+				break
+			}
+			for _, c := range arg[1:] {
+				switch c {
+				case 'S':
+					symbolic = true
+				case 'p':
+					printCmd = true
+				default:
+					r.errf("umask: invalid option \"-%c\"\n", c)
+					return 2
+				}
+			}
+		}
+		if len(args) == 0 {
+			if printCmd {
+				r.out("umask ")
+				if symbolic {
+					r.out("-S ")
+				}
+			}
+			if symbolic {
+				r.outf("%s\n", symbolicUmask(r.umask))
+			} else {
+				r.outf("%04o\n", r.umask)
+			}
+			break
+		}
+		mode := args[0]
+		if mode != "" && mode[0] >= '0' && mode[0] <= '9' {
+			n, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil || n > 0o7777 {
+				r.errf("umask: %s: octal number out of range\n", mode)
+				return 1
+			}
+			r.umask = os.FileMode(n) & 0o777
+			break
+		}
+		mask, err := parseSymbolicUmask(mode, r.umask)
+		if err != nil {
+			r.errf("umask: %v\n", err)
+			return 1
+		}
+		r.umask = mask
+
+	case "ulimit":
+		// Note that resource limits apply to the entire process,
+		// so they are shared with any other runners,
+		// which is why they can only be modified via ProcessLimits.
+		hard, soft, all := false, false, false
+		type ulimitOp struct {
+			res      *ulimitResource
+			value    string
+			hasValue bool
+		}
+		var ops []ulimitOp
+		for _, arg := range args {
+			if len(arg) < 2 || arg[0] != '-' {
+				if len(ops) == 0 {
+					ops = append(ops, ulimitOp{res: findUlimitResource('f')})
+				}
+				if op := &ops[len(ops)-1]; !op.hasValue {
+					op.value, op.hasValue = arg, true
+				}
+				continue
+			}
+			for _, c := range []byte(arg[1:]) {
+				switch c {
+				case 'H':
+					hard = true
+				case 'S':
+					soft = true
+				case 'a':
+					all = true
+				default:
+					res := findUlimitResource(c)
+					if res == nil {
+						r.errf("ulimit: invalid option \"-%c\"\n", c)
+						return 2
+					}
+					ops = append(ops, ulimitOp{res: res})
+				}
+			}
+		}
+		if all {
+			for i := range ulimitResources {
+				if !r.ulimitPrint(&ulimitResources[i], hard && !soft, true) {
+					return 1
+				}
+			}
+			break
+		}
+		if len(ops) == 0 {
+			ops = append(ops, ulimitOp{res: findUlimitResource('f')})
+		}
+		for _, op := range ops {
+			if !op.hasValue {
+				if !r.ulimitPrint(op.res, hard && !soft, len(ops) > 1) {
+					return 1
+				}
+				continue
+			}
+			resource, ok := rlimitResource(op.res.flag)
+			var limit uint64
+			switch op.value {
+			case "unlimited":
+				limit = rlimInfinity
+			case "hard", "soft":
+				if ok {
+					var err error
+					if limit, err = getRlimit(resource, op.value == "hard"); err != nil {
+						r.errf("ulimit: %s: cannot get limit: %v\n", op.res.name, err)
+						return 1
+					}
+				}
+			default:
+				n, err := strconv.ParseUint(op.value, 10, 64)
+				if err != nil {
+					r.errf("ulimit: %s: invalid number\n", op.value)
+					return 1
+				}
+				limit = n * op.res.factor
+			}
+			if r.sandbox != nil || !r.processLimits {
+				r.errf("ulimit: %s: cannot modify limit: %v\n", op.res.name, fs.ErrPermission)
+				return 1
+			}
+			if !ok {
+				continue // not supported by this platform
+			}
+			if !hard && !soft {
+				hard, soft = true, true
+			}
+			if err := setRlimit(resource, hard, soft, limit); err != nil {
+				r.errf("ulimit: %s: cannot modify limit: %v\n", op.res.name, err)
+				return 1
+			}
+		}
+
+	case "hash":
+		r.hashSync(r.writeEnv)
+		var setPath string
+		reset, del, list, show := false, false, false, false
+		fp := flagParser{remaining: args}
+		for fp.more() {
+			switch flag := fp.flag(); flag {
+			case "-r":
+				clear(r.hashed)
+				reset = true
+			case "-d":
+				del = true
+			case "-l":
+				list = true
+			case "-t":
+				show = true
+			case "-p":
+				if setPath = fp.value(); setPath == "" {
+					r.errf("hash: -p: option requires an argument\n")
+					return 2
+				}
+			default:
+				r.errf("hash: invalid option %q\n", flag)
+				return 2
+			}
+		}
+		args := fp.args()
+		if len(args) == 0 {
+			switch {
+			case show:
+				r.errf("hash: -t: option requires an argument\n")
+				return 1
+			case del:
+				r.errf("hash: -d: option requires an argument\n")
+				return 1
+			case reset || setPath != "":
+				return 0
+			}
+			names := make([]string, 0, len(r.hashed))
+			for name := range r.hashed {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			switch {
+			case list:
+				for _, name := range names {
+					r.outf("builtin hash -p %s %s\n", r.hashed[name].path, name)
+				}
+			case len(names) == 0:
+				r.out("hash: hash table empty\n")
+			default:
+				r.out("hits\tcommand\n")
+				for _, name := range names {
+					entry := r.hashed[name]
+					r.outf("%4d\t%s\n", entry.hits, entry.path)
+				}
+			}
+			break
+		}
+		exit := 0
+		for _, name := range args {
+			entry, ok := r.hashed[name]
+			switch {
+			case show:
+				if !ok {
+					r.errf("hash: %s: not found\n", name)
+					exit = 1
+					continue
+				}
+				entry.hits++
+				r.hashed[name] = entry
+				if len(args) > 1 {
+					r.outf("%s\t", name)
+				}
+				r.outf("%s\n", entry.path)
+			case del:
+				if !ok {
+					r.errf("hash: %s: not found\n", name)
+					exit = 1
+					continue
+				}
+				delete(r.hashed, name)
+			case setPath != "":
+				if r.hashed == nil {
+					r.hashed = make(map[string]hashEntry)
+				}
+				r.hashed[name] = hashEntry{path: setPath}
+			case hasPathSeparator(name) || r.Funcs[name] != nil || isBuiltin(name):
+				// nothing to remember
+			default:
+				delete(r.hashed, name) // search PATH again
+				if _, err := r.hashLookPath(r.writeEnv, name, false); err != nil {
+					r.errf("hash: %s: not found\n", name)
+					exit = 1
+				}
+			}
+		}
+		return exit
+
+	case "times":
+		user, sys, childUser, childSys := processTimes()
+		r.outf("%s %s\n", formatTimes(user), formatTimes(sys))
+		r.outf("%s %s\n", formatTimes(childUser), formatTimes(childSys))
+
 	default:
-		// "umask", "fg", "bg",
 		r.errf("%s: unimplemented builtin\n", name)
 		return 2
 	}
 	return 0
 }
 
-// mapfileSplit returns a suitable Split function for a [bufio.Scanner];
-// the code is mostly stolen from [bufio.ScanLines].
-func mapfileSplit(delim byte, dropDelim bool) func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
+// ulimitResource describes a resource limit which can be queried or modified
+// via ulimit, following the names and units used by Bash.
+type ulimitResource struct {
+	flag   byte
+	name   string
+	unit   string
+	factor uint64 // how many bytes or units each unit stands for
+}
+
+// ulimitResources lists all resources in the order used by "ulimit -a".
+// The ones which the platform does not support, per [rlimitResource],
+// are reported as unlimited and cannot be modified.
+var ulimitResources = []ulimitResource{
+	{'R', "real-time non-blocking time", "microseconds", 1},
+	{'c', "core file size", "blocks", 1024},
+	{'d', "data seg size", "kbytes", 1024},
+	{'e', "scheduling priority", "", 1},
+	{'f', "file size", "blocks", 1024},
+	{'i', "pending signals", "", 1},
+	{'l', "max locked memory", "kbytes", 1024},
+	{'m', "max memory size", "kbytes", 1024},
+	{'n', "open files", "", 1},
+	{'p', "pipe size", "512 bytes", 512},
+	{'q', "POSIX message queues", "bytes", 1},
+	{'r', "real-time priority", "", 1},
+	{'s', "stack size", "kbytes", 1024},
+	{'t', "cpu time", "seconds", 1},
+	{'u', "max user processes", "", 1},
+	{'v', "virtual memory", "kbytes", 1024},
+	{'x', "file locks", "", 1},
+}
+
+// rlimInfinity represents a resource limit which is unlimited.
+const rlimInfinity = math.MaxUint64
+
+func findUlimitResource(flag byte) *ulimitResource {
+	for i := range ulimitResources {
+		if ulimitResources[i].flag == flag {
+			return &ulimitResources[i]
 		}
-		if i := bytes.IndexByte(data, delim); i >= 0 {
-			// We have a full newline-terminated line.
-			if dropDelim {
-				return i + 1, data[0:i], nil
-			} else {
-				return i + 1, data[0 : i+1], nil
+	}
+	return nil
+}
+
+// ulimitPrint prints the soft or hard limit of a resource,
+// optionally with a label like in "ulimit -a".
+func (r *Runner) ulimitPrint(res *ulimitResource, hard, label bool) bool {
+	limit := uint64(rlimInfinity)
+	if resource, ok := rlimitResource(res.flag); ok {
+		var err error
+		if limit, err = getRlimit(resource, hard); err != nil {
+			r.errf("ulimit: %s: cannot get limit: %v\n", res.name, err)
+			return false
+		}
+	}
+	if label {
+		unit := fmt.Sprintf("(-%c) ", res.flag)
+		if res.unit != "" {
+			unit = fmt.Sprintf("(%s, -%c) ", res.unit, res.flag)
+		}
+		r.outf("%-20s %20s", res.name, unit)
+	}
+	if limit == rlimInfinity {
+		r.out("unlimited\n")
+	} else {
+		r.outf("%d\n", limit/res.factor)
+	}
+	return true
+}
+
+// formatTimes formats a duration like the times builtin, such as "0m1.250s".
+func formatTimes(d time.Duration) string {
+	return fmt.Sprintf("%dm%.3fs", d/time.Minute, (d % time.Minute).Seconds())
+}
+
+// symbolicUmask formats a umask as the permissions it allows,
+// such as "u=rwx,g=rx,o=rx" for 0022.
+func symbolicUmask(mask os.FileMode) string {
+	var sb strings.Builder
+	for i, who := range "ugo" {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteRune(who)
+		sb.WriteByte('=')
+		shift := 6 - 3*i
+		for j, perm := range "rwx" {
+			if mask&(1<<(shift+2-j)) == 0 {
+				sb.WriteRune(perm)
 			}
 		}
-		// If we're at EOF, we have a final, non-terminated line. Return it.
-		if atEOF {
-			return len(data), data, nil
+	}
+	return sb.String()
+}
+
+// parseSymbolicUmask applies a symbolic mode like "g+w,o=" to a umask.
+// Note that the mode describes the permissions to allow,
+// so adding permissions removes them from the mask.
+func parseSymbolicUmask(mode string, mask os.FileMode) (os.FileMode, error) {
+	allowed := ^mask & 0o777
+	i := 0
+	for {
+		var who os.FileMode
+	whoLoop:
+		for ; i < len(mode); i++ {
+			switch mode[i] {
+			case 'u':
+				who |= 0o700
+			case 'g':
+				who |= 0o070
+			case 'o':
+				who |= 0o007
+			case 'a':
+				who |= 0o777
+			default:
+				break whoLoop
+			}
+		}
+		if who == 0 {
+			who = 0o777
+		}
+		for {
+			if i == len(mode) || (mode[i] != '+' && mode[i] != '-' && mode[i] != '=') {
+				return 0, fmt.Errorf("`%s': invalid symbolic mode operator", mode[i:min(i+1, len(mode))])
+			}
+			op := mode[i]
+			var perm os.FileMode
+		permLoop:
+			for i++; i < len(mode); i++ {
+				switch mode[i] {
+				case 'r':
+					perm |= 0o444
+				case 'w':
+					perm |= 0o222
+				case 'x':
+					perm |= 0o111
+				case '+', '-', '=', ',':
+					break permLoop
+				default:
+					return 0, fmt.Errorf("`%c': invalid symbolic mode character", mode[i])
+				}
+			}
+			switch op {
+			case '+':
+				allowed |= perm & who
+			case '-':
+				allowed &^= perm & who
+			case '=':
+				allowed = allowed&^who | perm&who
+			}
+			if i == len(mode) {
+				return ^allowed & 0o777, nil
+			}
+			if mode[i] == ',' {
+				i++
+				break
+			}
 		}
-		// Request more data.
-		return 0, nil, nil
+	}
+}
+
+// readRecord reads from br until and including the delim byte, or until the
+// end of the input. It returns an empty string at the end of the input.
+func readRecord(br io.ByteReader, delim byte) (string, error) {
+	var record []byte
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return string(record), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		record = append(record, b)
+		if b == delim {
+			return string(record), nil
+		}
+	}
+}
+
+// oneByteReader reads a byte at a time, to not consume more input than needed.
+type oneByteReader struct{ r io.Reader }
+
+func (o oneByteReader) ReadByte() (byte, error) {
+	var buf [1]byte
+	for {
+		n, err := o.r.Read(buf[:])
+		if n > 0 {
+			return buf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// printOptCmd prints the command which sets an option to its current state,
+// like "shopt -p" or "shopt -po".
+func (r *Runner) printOptCmd(name string, enabled, posixOpts bool) {
+	switch {
+	case posixOpts && enabled:
+		r.outf("set -o %s\n", name)
+	case posixOpts:
+		r.outf("set +o %s\n", name)
+	case enabled:
+		r.outf("shopt -s %s\n", name)
+	default:
+		r.outf("shopt -u %s\n", name)
 	}
 }
 
@@ -922,20 +2056,43 @@ func (r *Runner) printOptLine(name string, enabled, supported bool) {
 }
 
 func (r *Runner) readLine(ctx context.Context, raw bool) ([]byte, error) {
-	if r.stdin == nil {
+	return r.readDelim(ctx, r.stdin, raw, '\n', -1)
+}
+
+// readDelim reads from in until the delim byte is found, which is not included
+// in the result. A negative delim means that only nchars stops the reading.
+// If nchars is not negative, the reading stops once that many characters have
+// been read, not counting escaping backslashes unless raw is true.
+//
+// Unless raw is true, a backslash followed by a newline is a line continuation,
+// and other backslashes are kept to be handled later, such as by
+// [expand.ReadFields].
+func isRegularFile(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+func (r *Runner) readDelim(ctx context.Context, in io.Reader, raw bool, delim rune, nchars int) ([]byte, error) {
+	if in == nil {
 		return nil, errors.New("interp: can't read, there's no stdin")
 	}
+	if nchars == 0 {
+		return nil, nil
+	}
 
 	var line []byte
 	esc := false
+	chars := 0
+	runeStart := 0
 
-	stdin := r.stdin
-	if osFile, ok := stdin.(*os.File); ok {
+	// Regular files can't be polled, such as with "exec 3<file",
+	// but reading from them does not block either.
+	if osFile, ok := in.(*os.File); ok && !isRegularFile(osFile) {
 		cr, err := cancelreader.NewReader(osFile)
 		if err != nil {
 			return nil, err
 		}
-		stdin = cr
+		in = cr
 		done := make(chan struct{})
 		var wg sync.WaitGroup
 		wg.Add(1)
@@ -953,30 +2110,44 @@ func (r *Runner) readLine(ctx context.Context, raw bool) ([]byte, error) {
 			// Could put the Close in the above goroutine, but if "read" is
 			// immediately called again, the Close might overlap with creating a
 			// new cancelreader. Want this cancelreader to be completely closed
-			// by the time readLine returns.
+			// by the time readDelim returns.
 			cr.Close()
 		}()
 	}
 
 	for {
 		var buf [1]byte
-		n, err := stdin.Read(buf[:])
+		n, err := in.Read(buf[:])
 		if n > 0 {
 			b := buf[0]
+			count := true
 			switch {
 			case !raw && b == '\\':
 				line = append(line, b)
 				esc = !esc
+				// An escaping backslash is not a character.
+				count = !esc
 			case !raw && b == '\n' && esc:
 				// line continuation
-				line = line[len(line)-1:]
+				line = line[:len(line)-1]
 				esc = false
-			case b == '\n':
+				count = false
+			case rune(b) == delim && !esc:
 				return line, nil
 			default:
 				line = append(line, b)
 				esc = false
 			}
+			// Count whole characters, as they may be multiple bytes.
+			if !count {
+				runeStart = len(line)
+			} else if utf8.FullRune(line[runeStart:]) {
+				chars++
+				runeStart = len(line)
+			}
+			if nchars > 0 && chars >= nchars {
+				return line, nil
+			}
 		}
 		if err != nil {
 			return line, err
@@ -984,22 +2155,114 @@ func (r *Runner) readLine(ctx context.Context, raw bool) ([]byte, error) {
 	}
 }
 
-func (r *Runner) changeDir(ctx context.Context, path string) int {
+// unescapeRead removes the escaping backslashes in the input to read,
+// for when it's not split into fields.
+func unescapeRead(line []byte) []byte {
+	var out []byte
+	esc := false
+	for _, b := range line {
+		if b == '\\' && !esc {
+			esc = true
+			continue
+		}
+		out = append(out, b)
+		esc = false
+	}
+	return out
+}
+
+// isDirStackIndex reports whether an argument to dirs, pushd, or popd refers
+// to an entry in the directory stack, such as "+1" or "-0".
+func isDirStackIndex(arg string) bool {
+	if len(arg) < 2 || (arg[0] != '+' && arg[0] != '-') {
+		return false
+	}
+	_, err := strconv.Atoi(arg[1:])
+	return err == nil
+}
+
+// dirStackIndex returns the position in the directory stack for an argument
+// like "+N" or "-N", which count from the top and bottom of the stack.
+// Note that the top of the stack is the last element.
+func (r *Runner) dirStackIndex(arg string) (int, error) {
+	n, _ := strconv.Atoi(arg[1:])
+	i := n
+	if arg[0] == '+' {
+		i = len(r.dirStack) - 1 - n
+	}
+	if n < 0 || i < 0 || i >= len(r.dirStack) {
+		return 0, fmt.Errorf("directory stack index out of range")
+	}
+	return i, nil
+}
+
+// dirsEntry formats a directory in the stack, replacing $HOME with a tilde
+// unless long is true.
+func (r *Runner) dirsEntry(dir string, long bool) string {
+	if home := r.envGet("HOME"); !long && home != "" {
+		if dir == home {
+			return "~"
+		}
+		if rest, ok := strings.CutPrefix(dir, home+string(filepath.Separator)); ok {
+			return "~" + string(filepath.Separator) + rest
+		}
+	}
+	return dir
+}
+
+// changeDir changes the current directory on behalf of the named builtin,
+// returning its exit status.
+func (r *Runner) changeDir(ctx context.Context, name, path string) int {
+	if err := r.chdir(ctx, path); err != nil {
+		var denied *chdirDeniedError
+		if errors.As(err, &denied) {
+			r.errf("%s: %s: %v\n", name, path, denied.err)
+		}
+		return 1
+	}
+	return 0
+}
+
+// chdirDeniedError is returned by chdir when a [ChdirHandlerFunc] denies
+// changing the directory.
+type chdirDeniedError struct{ err error }
+
+func (e *chdirDeniedError) Error() string { return e.err.Error() }
+func (e *chdirDeniedError) Unwrap() error { return e.err }
+
+// chdir is like changeDir, but it returns an error describing why the
+// directory could not be changed.
+func (r *Runner) chdir(ctx context.Context, path string) error {
 	if path == "" {
 		path = "."
 	}
 	path = r.absPath(path)
+	if r.chdirHandler != nil {
+		newPath, err := r.chdirHandler(r.handlerCtx(ctx), path)
+		if err != nil {
+			return &chdirDeniedError{err}
+		}
+		path = r.absPath(newPath)
+	}
+	r.audit(ctx, AuditEvent{Kind: AuditChdir, Path: path})
 	info, err := r.stat(ctx, path)
-	if err != nil || !info.IsDir() {
-		return 1
+	if err != nil {
+		return err
 	}
-	if !hasPermissionToDir(path) {
-		return 1
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	if r.fsys == nil && !hasPermissionToDir(path) {
+		return fs.ErrPermission
 	}
 	r.Dir = path
 	r.setVarString("OLDPWD", r.envGet("PWD"))
 	r.setVarString("PWD", path)
-	return 0
+	// The top of the directory stack is always the current directory.
+	if len(r.dirStack) > 0 {
+		r.dirStack[len(r.dirStack)-1] = path
+	}
+	return nil
 }
 
 func absPath(dir, path string) string {
@@ -1067,7 +2330,17 @@ func (p *flagParser) flag() string {
 	return arg
 }
 
+func (p *flagParser) hasValue() bool {
+	return p.current != "" || len(p.remaining) > 0
+}
+
 func (p *flagParser) value() string {
+	if p.current != "" {
+		// We have "-ab", so "b" is the value for "-a".
+		arg := p.current[1:]
+		p.current = ""
+		return arg
+	}
 	if len(p.remaining) == 0 {
 		return ""
 	}
@@ -1083,41 +2356,57 @@ type getopts struct {
 	runeidx int
 }
 
-func (g *getopts) next(optstr string, args []string) (opt rune, optarg string, done bool) {
-	if len(args) == 0 || g.argidx >= len(args) {
-		return '?', "", true
+// next parses the next option in args, as given by optstr.
+// Invalid options are returned as '?', and options missing their argument
+// as ':', in both cases with optarg holding the option itself.
+func (g *getopts) next(optstr string, args []string) (opt rune, optarg string, hasArg, done bool) {
+	if g.argidx >= len(args) {
+		return '?', "", false, true
 	}
 	arg := []rune(args[g.argidx])
-	if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' {
-		return '?', "", true
+	if g.runeidx == 0 {
+		if len(arg) < 2 || arg[0] != '-' {
+			return '?', "", false, true
+		}
+		if string(arg) == "--" {
+			g.argidx++
+			return '?', "", false, true
+		}
+		g.runeidx = 1 // skip the dash
 	}
 
-	opts := arg[1:]
-	opt = opts[g.runeidx]
-	if g.runeidx+1 < len(opts) {
-		g.runeidx++
-	} else {
+	opt = arg[g.runeidx]
+	g.runeidx++
+	rest := arg[g.runeidx:]
+	if len(rest) == 0 {
 		g.argidx++
 		g.runeidx = 0
 	}
 
 	i := strings.IndexRune(optstr, opt)
-	if i < 0 {
+	if i < 0 || opt == ':' {
 		// invalid option
-		return '?', string(opt), false
+		return '?', string(opt), false, false
 	}
 
 	if i+1 < len(optstr) && optstr[i+1] == ':' {
-		if g.argidx >= len(args) {
+		switch {
+		case len(rest) > 0:
+			// the argument follows the option, as in "-ofile"
+			optarg = string(rest)
+			g.argidx++
+			g.runeidx = 0
+		case g.argidx < len(args):
+			optarg = args[g.argidx]
+			g.argidx++
+		default:
 			// missing argument
-			return ':', string(opt), false
+			return ':', string(opt), false, false
 		}
-		optarg = args[g.argidx]
-		g.argidx++
-		g.runeidx = 0
+		return opt, optarg, true, false
 	}
 
-	return opt, optarg, false
+	return opt, "", false, false
 }
 
 // optStatusText returns a shell option's status text display
@@ -0,0 +1,162 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Coverage records how many times each statement of a program runs,
+// which can be used to measure how much of a shell program its tests exercise.
+// Since each branch of a command like if or case is made of statements,
+// it also records which branches were taken.
+//
+// Use [Coverage.Hooks] with [Trace] to collect coverage from a [Runner].
+// All the statements in the files it runs are tracked, including files loaded
+// via the source builtin. [Coverage.AddFile] can be used to also include files
+// which may not run at all.
+//
+// Statements are identified by their file name and position, so a file which
+// is parsed and run multiple times, such as when sourced repeatedly, adds to
+// the same counts.
+//
+// The zero value is ready to use, and a Coverage is safe for concurrent use.
+type Coverage struct {
+	mu sync.Mutex
+
+	files []string // in the order they were first added
+	byPos map[coverKey]*StmtCoverage
+	nodes map[*syntax.Stmt]*StmtCoverage
+}
+
+type coverKey struct {
+	file   string
+	offset uint
+}
+
+// StmtCoverage describes how many times a statement ran.
+type StmtCoverage struct {
+	File  string
+	Pos   syntax.Pos
+	End   syntax.Pos
+	Count int
+}
+
+// AddFile adds all the statements in a file to the coverage,
+// which is done automatically when a file runs with [Coverage.Hooks].
+func (c *Coverage) AddFile(file *syntax.File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.byPos == nil {
+		c.byPos = make(map[coverKey]*StmtCoverage)
+		c.nodes = make(map[*syntax.Stmt]*StmtCoverage)
+	}
+	if !slices.Contains(c.files, file.Name) {
+		c.files = append(c.files, file.Name)
+	}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		st, ok := node.(*syntax.Stmt)
+		if !ok {
+			return true
+		}
+		if _, ok := c.nodes[st]; ok {
+			return true
+		}
+		key := coverKey{file.Name, st.Pos().Offset()}
+		sc := c.byPos[key]
+		if sc == nil {
+			sc = &StmtCoverage{File: file.Name, Pos: st.Pos(), End: st.End()}
+			c.byPos[key] = sc
+		}
+		c.nodes[st] = sc
+		return true
+	})
+}
+
+// Hooks returns the trace hooks which collect coverage.
+func (c *Coverage) Hooks() TraceHooks {
+	return TraceHooks{
+		File: func(ctx context.Context, file *syntax.File) {
+			c.AddFile(file)
+		},
+		BeforeStmt: func(ctx context.Context, stmt *syntax.Stmt) {
+			c.mu.Lock()
+			// Statements not in any file, such as when running a single
+			// statement via Runner.Run, are ignored.
+			if sc := c.nodes[stmt]; sc != nil {
+				sc.Count++
+			}
+			c.mu.Unlock()
+		},
+	}
+}
+
+// Stmts returns the coverage of all statements, sorted by file in the order
+// they were added, and then by position.
+func (c *Coverage) Stmts() []StmtCoverage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	list := make([]StmtCoverage, 0, len(c.byPos))
+	for _, sc := range c.byPos {
+		list = append(list, *sc)
+	}
+	slices.SortFunc(list, func(a, b StmtCoverage) int {
+		if a.File != b.File {
+			return slices.Index(c.files, a.File) - slices.Index(c.files, b.File)
+		}
+		if n := cmp.Compare(a.Pos.Offset(), b.Pos.Offset()); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.End.Offset(), b.End.Offset())
+	})
+	return list
+}
+
+// WriteGoCover writes the coverage as a profile in the "count" mode,
+// as understood by "go tool cover".
+func (c *Coverage) WriteGoCover(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: count\n")
+	for _, sc := range c.Stmts() {
+		fmt.Fprintf(bw, "%s:%d.%d,%d.%d 1 %d\n", sc.File,
+			sc.Pos.Line(), sc.Pos.Col(), sc.End.Line(), sc.End.Col(), sc.Count)
+	}
+	return bw.Flush()
+}
+
+// WriteLCOV writes the coverage as an LCOV tracefile, as understood by tools
+// like genhtml. Since LCOV works with lines, each line's count is the highest
+// count among the statements starting on it.
+func (c *Coverage) WriteLCOV(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	stmts := c.Stmts()
+	for len(stmts) > 0 {
+		file := stmts[0].File
+		fmt.Fprintf(bw, "TN:\nSF:%s\n", file)
+		found, hit := 0, 0
+		for len(stmts) > 0 && stmts[0].File == file {
+			line := stmts[0].Pos.Line()
+			count := 0
+			for len(stmts) > 0 && stmts[0].File == file && stmts[0].Pos.Line() == line {
+				count = max(count, stmts[0].Count)
+				stmts = stmts[1:]
+			}
+			fmt.Fprintf(bw, "DA:%d,%d\n", line, count)
+			found++
+			if count > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(bw, "LF:%d\nLH:%d\nend_of_record\n", found, hit)
+	}
+	return bw.Flush()
+}
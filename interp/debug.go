@@ -0,0 +1,182 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// DebugStep tells a paused [Runner] how to resume, and when to pause next.
+// Breakpoints always cause a pause, whichever the step.
+type DebugStep uint8
+
+const (
+	DebugContinue DebugStep = iota // pause at the next breakpoint
+	DebugStepInto                  // pause before the next statement
+	DebugStepOver                  // like DebugStepInto, skipping over function calls
+	DebugStepOut                   // pause once the current function returns
+)
+
+// Debugger pauses a [Runner] before statements run, allowing to inspect and
+// modify the program's state and to step through it.
+// Use [Debug] to attach a debugger to a runner.
+//
+// A debugger is safe for concurrent use, so its methods may be called while
+// the runner is running, or from within [Debugger.Pause].
+// Note that background commands, pipelines, and subshells may run concurrently
+// with the rest of the program, sharing the same breakpoints and stepping state.
+type Debugger struct {
+	// Pause is called whenever the runner pauses before a statement.
+	// The runner is blocked until Pause returns how to resume.
+	// The frame is only valid until Pause returns.
+	Pause func(ctx context.Context, frame *DebugFrame) DebugStep
+
+	mu sync.Mutex
+
+	files      map[*syntax.Stmt]string
+	lineBreaks map[lineBreak]bool
+	stmtBreaks map[*syntax.Stmt]bool
+
+	pauseNext bool
+	step      DebugStep
+	stepDepth int
+}
+
+type lineBreak struct {
+	file string
+	line uint
+}
+
+// DebugFrame describes the state of a paused [Runner].
+type DebugFrame struct {
+	// Stmt is the statement about to run.
+	Stmt *syntax.Stmt
+
+	// File is the name of the file containing the statement, if known.
+	File string
+
+	// Funcs holds the names of the functions being run, with the innermost
+	// call last.
+	Funcs []string
+
+	r *Runner
+}
+
+// Var returns the value of a variable, including special parameters like $@.
+func (f *DebugFrame) Var(name string) expand.Variable {
+	return f.r.lookupVar(name)
+}
+
+// SetVar sets the value of a variable as an assignment would,
+// so that it may modify a local variable if the paused statement is part of
+// a function. A zero value unsets the variable.
+func (f *DebugFrame) SetVar(name string, vr expand.Variable) error {
+	return f.r.writeEnv.Set(name, vr)
+}
+
+// Env returns a read-only view of all the variables.
+func (f *DebugFrame) Env() expand.Environ {
+	return &overlayEnviron{parent: f.r.writeEnv}
+}
+
+// SetBreakpoint sets a breakpoint which pauses before each statement starting
+// at the given line of the given file.
+func (d *Debugger) SetBreakpoint(file string, line uint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lineBreaks == nil {
+		d.lineBreaks = make(map[lineBreak]bool)
+	}
+	d.lineBreaks[lineBreak{file, line}] = true
+}
+
+// ClearBreakpoint removes a breakpoint set via [Debugger.SetBreakpoint].
+func (d *Debugger) ClearBreakpoint(file string, line uint) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.lineBreaks, lineBreak{file, line})
+}
+
+// SetStmtBreakpoint sets a breakpoint which pauses before a statement runs.
+// Note that the statement must be the same node which the runner interprets,
+// so it cannot be used with statements in files loaded via the source builtin.
+func (d *Debugger) SetStmtBreakpoint(stmt *syntax.Stmt) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stmtBreaks == nil {
+		d.stmtBreaks = make(map[*syntax.Stmt]bool)
+	}
+	d.stmtBreaks[stmt] = true
+}
+
+// ClearStmtBreakpoint removes a breakpoint set via [Debugger.SetStmtBreakpoint].
+func (d *Debugger) ClearStmtBreakpoint(stmt *syntax.Stmt) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.stmtBreaks, stmt)
+}
+
+// Break makes the runner pause before the next statement, whichever it is.
+// It can be used to pause at the start of a program, or to pause a running one.
+func (d *Debugger) Break() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pauseNext = true
+}
+
+// addFile remembers which file each statement belongs to.
+func (d *Debugger) addFile(file *syntax.File) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.files == nil {
+		d.files = make(map[*syntax.Stmt]string)
+	}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if st, ok := node.(*syntax.Stmt); ok {
+			d.files[st] = file.Name
+		}
+		return true
+	})
+}
+
+// stmt is called by a runner right before a statement runs,
+// pausing if needed.
+func (d *Debugger) stmt(ctx context.Context, r *Runner, st *syntax.Stmt) {
+	depth := len(r.funcNames)
+
+	d.mu.Lock()
+	file := d.files[st]
+	pause := d.pauseNext || d.stmtBreaks[st] || d.lineBreaks[lineBreak{file, st.Pos().Line()}]
+	switch d.step {
+	case DebugStepInto:
+		pause = true
+	case DebugStepOver:
+		pause = pause || depth <= d.stepDepth
+	case DebugStepOut:
+		pause = pause || depth < d.stepDepth
+	}
+	if !pause || d.Pause == nil {
+		d.mu.Unlock()
+		return
+	}
+	d.pauseNext = false
+	d.mu.Unlock()
+
+	frame := &DebugFrame{
+		Stmt:  st,
+		File:  file,
+		Funcs: slices.Clone(r.funcNames),
+		r:     r,
+	}
+	step := d.Pause(r.handlerCtx(ctx), frame)
+
+	d.mu.Lock()
+	d.step, d.stepDepth = step, depth
+	d.mu.Unlock()
+}
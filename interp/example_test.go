@@ -10,6 +10,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"testing/fstest"
 
 	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
@@ -90,3 +91,40 @@ func ExampleOpenHandler() {
 	// Output:
 	// foo
 }
+
+func ExampleFS() {
+	// Often an embed.FS, or a filesystem provided by a WebAssembly host.
+	fsys := fstest.MapFS{
+		"home/me/notes.txt": {Data: []byte("buy milk\n")},
+		"home/me/todo.txt":  {Data: []byte("call mum\n")},
+	}
+	src := "cd ~; for f in *.txt; do echo \"$f: $(<$f)\"; done; echo x >new.txt"
+	file, _ := syntax.NewParser().Parse(strings.NewReader(src), "")
+	runner, _ := interp.New(
+		interp.StdIO(nil, os.Stdout, os.Stdout),
+		interp.Env(expand.ListEnviron("HOME=/home/me")),
+		interp.FS(fsys),
+	)
+	runner.Run(context.TODO(), file)
+	// Output:
+	// notes.txt: buy milk
+	// todo.txt: call mum
+	// open new.txt: permission denied
+}
+
+func ExampleSourceFS() {
+	// Often an embed.FS holding a library of scripts.
+	lib := fstest.MapFS{
+		"lib/greet.sh": {Data: []byte("greet() { echo \"hello, $1\"; }\n")},
+	}
+	src := "PATH=/lib; source greet.sh; greet world; source missing.sh"
+	file, _ := syntax.NewParser().Parse(strings.NewReader(src), "")
+	runner, _ := interp.New(
+		interp.StdIO(nil, os.Stdout, os.Stdout),
+		interp.SourceHandler(interp.SourceFS(lib)),
+	)
+	runner.Run(context.TODO(), file)
+	// Output:
+	// hello, world
+	// source: open missing.sh: file does not exist
+}
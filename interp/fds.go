@@ -0,0 +1,254 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"io"
+	"maps"
+	"os"
+	"runtime"
+)
+
+// shellFd is a file descriptor other than the standard streams, such as one
+// opened via "exec 3>file" or connected to a coprocess.
+type shellFd struct {
+	rw any // an io.Reader, an io.Writer, or both
+
+	// file is set when the shell opened rw, and it is shared with
+	// duplicates like "4>&3". It is nil for the standard streams
+	// and for files which need no closing, like here-documents.
+	file *fdFile
+
+	// cloexec is set for file descriptors not passed to external programs,
+	// like those of coprocesses in Bash.
+	cloexec bool
+}
+
+// fdFile counts the file descriptors referring to a file opened by the shell,
+// so that it is closed once none of them do, like the kernel does.
+type fdFile struct {
+	// owner is the runner which opened the file, and which closes it.
+	// Subshells share the file descriptors of their parent,
+	// but they do not count towards refs nor close the file.
+	// It is nil once the file is closed.
+	owner *Runner
+
+	// refs is the number of file descriptors in the owner's table which
+	// refer to the file.
+	refs int
+}
+
+// ref and unref count a file descriptor being added to or removed from the
+// table of runner r.
+func (fd *shellFd) ref(r *Runner) {
+	if fd != nil && fd.file != nil && fd.file.owner == r {
+		fd.file.refs++
+	}
+}
+
+func (fd *shellFd) unref(r *Runner) {
+	if fd != nil && fd.file != nil && fd.file.owner == r {
+		fd.file.refs--
+	}
+}
+
+// close closes the file if it was opened by runner r.
+func (fd *shellFd) close(r *Runner) error {
+	if fd.file == nil || fd.file.owner != r {
+		return nil
+	}
+	fd.file.owner = nil // don't close it twice
+	if c, ok := fd.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// openedFd returns a file descriptor for a file opened by runner r.
+func (r *Runner) openedFd(rw any) *shellFd {
+	return &shellFd{rw: rw, file: &fdFile{owner: r}}
+}
+
+func (fd *shellFd) reader() io.Reader {
+	if fd == nil {
+		return nil
+	}
+	rd, _ := fd.rw.(io.Reader)
+	return rd
+}
+
+func (fd *shellFd) writer() io.Writer {
+	if fd == nil {
+		return nil
+	}
+	w, _ := fd.rw.(io.Writer)
+	return w
+}
+
+// setFd sets a file descriptor, replacing any previous one with the same
+// number. Files used as the standard streams are never owned by the table.
+func (r *Runner) setFd(n int, fd *shellFd) {
+	switch n {
+	case 0:
+		r.stdin = fd.reader()
+		return
+	case 1:
+		r.stdout = fd.writer()
+		return
+	case 2:
+		r.stderr = fd.writer()
+		return
+	}
+	r.putFd(n, fd)
+}
+
+// putFd sets or removes a file descriptor other than the standard streams,
+// keeping count of the references to the files opened by the shell.
+func (r *Runner) putFd(n int, fd *shellFd) {
+	r.fds = maps.Clone(r.fds) // the map may be shared with a parent shell
+	r.fds[n].unref(r)
+	if fd == nil {
+		delete(r.fds, n)
+		return
+	}
+	if r.fds == nil {
+		r.fds = make(map[int]*shellFd)
+	}
+	r.fds[n] = fd
+	fd.ref(r)
+}
+
+// getFd returns the file descriptor with a number, including the standard
+// streams, or nil if it is not open.
+func (r *Runner) getFd(n int) *shellFd {
+	switch n {
+	case 0:
+		return &shellFd{rw: r.stdin}
+	case 1:
+		return &shellFd{rw: r.stdout}
+	case 2:
+		return &shellFd{rw: r.stderr}
+	}
+	return r.fds[n]
+}
+
+// newFd adds a file descriptor for a coprocess, returning its number.
+// Like Bash, it uses the highest free number below 64.
+func (r *Runner) newFd(rw any) int {
+	n := 63
+	for r.fds[n] != nil {
+		n--
+	}
+	fd := r.openedFd(rw)
+	fd.cloexec = true
+	r.setFd(n, fd)
+	return n
+}
+
+// freeFd returns the lowest free file descriptor number starting at 10,
+// which Bash uses for redirections like "{fd}>file".
+func (r *Runner) freeFd() int {
+	n := 10
+	for r.fds[n] != nil {
+		n++
+	}
+	return n
+}
+
+// closeFd removes a file descriptor from the shell, if present.
+// The file is not actually closed until the end of the statement,
+// as redirections other than those of exec only apply to a single command.
+// See [Runner.stmtSync].
+func (r *Runner) closeFd(n int) {
+	if r.fds[n] == nil {
+		return
+	}
+	r.putFd(n, nil)
+}
+
+// restoreFds undoes the changes that the redirections of a statement made to
+// the file descriptors, given the table before and after the redirections.
+// Changes made by the command itself, such as via exec, are kept.
+func (r *Runner) restoreFds(before, after map[int]*shellFd) {
+	for n, fd := range after {
+		if before[n] != fd && r.fds[n] == fd {
+			r.putFd(n, before[n])
+		}
+	}
+	for n, old := range before {
+		if after[n] == nil && r.fds[n] == nil {
+			r.setFd(n, old)
+		}
+	}
+}
+
+// closeUnusedFds closes the files this runner opened in any of the given
+// tables which are no longer referred to by any of its file descriptors,
+// including duplicates like "5>&4".
+func (r *Runner) closeUnusedFds(tables ...map[int]*shellFd) {
+	for _, table := range tables {
+		for n, fd := range table {
+			if fd.file != nil && fd.file.refs == 0 {
+				r.logErr("closing file descriptor", fd.close(r), "fd", n)
+			}
+		}
+	}
+}
+
+// handlerFiles returns the file descriptors to expose via [HandlerContext].
+func (r *Runner) handlerFiles() map[int]any {
+	if len(r.fds) == 0 {
+		return nil
+	}
+	files := make(map[int]any, len(r.fds))
+	for n, fd := range r.fds {
+		files[n] = fd.rw
+	}
+	return files
+}
+
+// extraFiles returns the files to pass to a program as the file descriptors
+// starting at 3, leaving gaps for those which are not open or not files.
+func (hc HandlerContext) extraFiles() []*os.File {
+	var extra []*os.File
+	for n, rw := range hc.Files {
+		f, ok := rw.(*os.File)
+		if !ok || n < 3 {
+			continue
+		}
+		if hc.runner != nil && hc.runner.fds[n] != nil && hc.runner.fds[n].cloexec {
+			continue
+		}
+		for len(extra) <= n-3 {
+			extra = append(extra, nil)
+		}
+		extra[n-3] = f
+	}
+	return extra
+}
+
+// readOnly is a reader used as standard output or error, such as the
+// here-string in "echo foo 1<<<bar", which cannot be written to.
+type readOnly struct{ io.Reader }
+
+func (readOnly) Write([]byte) (int, error) { return 0, os.ErrInvalid }
+
+// newPipe returns a connected pair of files like [os.Pipe].
+// Where there are no pipes, such as on js/wasm and wasip1, it falls back to
+// an in-memory pipe, which cannot be passed on to programs as a file.
+func newPipe() (pr, pw io.ReadWriteCloser, _ error) {
+	if runtime.GOARCH == "wasm" {
+		r, w := io.Pipe()
+		return pipeReader{r}, pipeWriter{w}, nil
+	}
+	return os.Pipe()
+}
+
+type pipeReader struct{ *io.PipeReader }
+
+func (pipeReader) Write([]byte) (int, error) { return 0, os.ErrInvalid }
+
+type pipeWriter struct{ *io.PipeWriter }
+
+func (pipeWriter) Read([]byte) (int, error) { return 0, os.ErrInvalid }
@@ -0,0 +1,115 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FS makes the runner use fsys as its filesystem rather than the host's.
+// This is useful where there is no filesystem to speak of, such as with
+// js/wasm in a browser, or to give a program a virtual filesystem,
+// like an [embed.FS] or an [testing/fstest.MapFS].
+//
+// Absolute paths such as "/etc/profile" are looked up as "etc/profile"
+// within fsys. The current directory is "/" unless set via [Dir],
+// which should come after FS so that the directory is checked within fsys.
+//
+// FS replaces the handlers set via [OpenHandler], [ReadDirHandler2],
+// [StatHandler], and [SourceHandler], where the latter finds files like
+// [DefaultSourceHandler] does.
+// Since fsys is read-only, opening files for writing fails with
+// [fs.ErrPermission], other than "/dev/null", which discards what is written.
+// Use [Streams] to capture what a program writes to other files.
+func FS(fsys fs.FS) RunnerOption {
+	return func(r *Runner) error {
+		r.fsys = fsys
+		r.openHandler = func(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+			if filepath.ToSlash(path) == "/dev/null" {
+				return devNull{}, nil
+			}
+			if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+				return nil, &os.PathError{Op: "open", Path: path, Err: fs.ErrPermission}
+			}
+			f, err := fsys.Open(virtualPath(HandlerCtx(ctx).Dir, path))
+			if err != nil {
+				return nil, &os.PathError{Op: "open", Path: path, Err: unwrapPathError(err)}
+			}
+			return readOnlyFile{f}, nil
+		}
+		// Directories are read and files are stat'ed via absolute paths.
+		r.readDirHandler = func(ctx context.Context, path string) ([]fs.DirEntry, error) {
+			return fs.ReadDir(fsys, virtualPath("", path))
+		}
+		r.statHandler = func(ctx context.Context, path string, followSymlinks bool) (fs.FileInfo, error) {
+			info, err := fs.Stat(fsys, virtualPath("", path))
+			if err != nil {
+				return nil, &os.PathError{Op: "stat", Path: path, Err: unwrapPathError(err)}
+			}
+			return info, nil
+		}
+		r.sourceHandler = func(ctx context.Context, name string) (string, io.ReadCloser, error) {
+			hc := HandlerCtx(ctx)
+			var paths []string
+			if !strings.Contains(name, "/") {
+				for _, dir := range filepath.SplitList(hc.Env.Get("PATH").String()) {
+					paths = append(paths, filepath.Join(dir, name))
+				}
+			}
+			paths = append(paths, name)
+			for _, path := range paths {
+				vpath := virtualPath(hc.Dir, path)
+				if info, err := fs.Stat(fsys, vpath); err != nil || info.IsDir() {
+					continue
+				}
+				if f, err := fsys.Open(vpath); err == nil {
+					return path, f, nil
+				}
+			}
+			return "", nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		if r.Dir == "" {
+			r.Dir = string(filepath.Separator)
+		}
+		return nil
+	}
+}
+
+// virtualPath turns a host path into a path valid for [fs.FS],
+// resolving relative paths against dir.
+func virtualPath(dir, path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path = path[len(filepath.VolumeName(path)):]
+	return fsPath(filepath.ToSlash(path))
+}
+
+// unwrapPathError returns the underlying error of an [fs.PathError],
+// so that errors mention the path given to the runner.
+func unwrapPathError(err error) error {
+	if err, ok := err.(*fs.PathError); ok {
+		return err.Err
+	}
+	return err
+}
+
+// readOnlyFile is a file opened via [FS], which cannot be written to.
+type readOnlyFile struct{ fs.File }
+
+func (f readOnlyFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Err: fs.ErrPermission}
+}
+
+// devNull is "/dev/null" for [FS].
+type devNull struct{}
+
+func (devNull) Read([]byte) (int, error)    { return 0, io.EOF }
+func (devNull) Write(p []byte) (int, error) { return len(p), nil }
+func (devNull) Close() error                { return nil }
@@ -4,20 +4,25 @@
 package interp
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
 )
 
 // HandlerCtx returns HandlerContext value stored in ctx.
@@ -49,6 +54,23 @@ type HandlerContext struct {
 	Stdout io.Writer
 	// Stderr is the interpreter's current standard error writer.
 	Stderr io.Writer
+
+	// Files holds the interpreter's file descriptors other than the standard
+	// streams, such as those opened via "exec 3>file", keyed by number.
+	// Each is an [io.Reader], an [io.Writer], or both, and is often an
+	// [*os.File]. The map must not be modified.
+	Files map[int]any
+
+	runner *Runner // to use its table of hashed commands
+}
+
+// lookPath is like [LookPathDir], but it makes use of the runner's table of
+// hashed commands, if there is one.
+func (hc HandlerContext) lookPath(file string) (string, error) {
+	if hc.runner == nil {
+		return LookPathDir(hc.Dir, hc.Env, file)
+	}
+	return hc.runner.hashLookPath(hc.Env, file, true)
 }
 
 // CallHandlerFunc is a handler which runs on every [syntax.CallExpr].
@@ -81,23 +103,261 @@ type CallHandlerFunc func(ctx context.Context, args []string) ([]string, error)
 // Any other error will halt the Runner.
 type ExecHandlerFunc func(ctx context.Context, args []string) error
 
+// CommandNotFoundHandlerFunc is a handler which runs when the default
+// [ExecHandlerFunc] cannot find a command in PATH,
+// unless the shell function "command_not_found_handle" is defined,
+// which is called instead like in Bash.
+//
+// The arguments are those of the command which was not found.
+// Like with [ExecHandlerFunc], returning nil means that the command succeeded,
+// and an error from [NewExitStatus] sets a non-zero exit status.
+// Without either handler, the command fails with exit status 127.
+type CommandNotFoundHandlerFunc func(ctx context.Context, args []string) error
+
+// CommandPolicyFunc is a policy which decides whether a simple command may run.
+// It is called with the command name and its arguments, once field expansion
+// and any [CallHandlerFunc] have occurred.
+// The context carries a [HandlerContext], like with other handlers.
+//
+// Returning a nil error allows the command to run.
+// Any other error denies it: the error is printed to stderr and the command
+// fails with exit status 126, or 127 if the error is a [*CommandDeniedError]
+// with NotFound set.
+type CommandPolicyFunc func(ctx context.Context, name string, args []string) error
+
+// CommandDeniedError can be returned by a [CommandPolicyFunc] to describe why a
+// command was denied.
+type CommandDeniedError struct {
+	// Name is the name of the denied command.
+	Name string
+
+	// Reason is an optional human-readable explanation.
+	Reason string
+
+	// NotFound makes the command fail as if it did not exist,
+	// with exit status 127 rather than 126.
+	NotFound bool
+}
+
+func (e *CommandDeniedError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%s: command denied by policy", e.Name)
+	}
+	return fmt.Sprintf("%s: command denied by policy: %s", e.Name, e.Reason)
+}
+
+// TraceHooks holds callbacks which observe a [Runner] as it runs a program,
+// receiving the syntax nodes being interpreted.
+// This is useful to implement profilers, debuggers, or coverage tools,
+// and gives more structure than the "xtrace" option's text output.
+//
+// Any of the callbacks may be nil. They are called synchronously, so they must
+// not block for long. The context carries a [HandlerContext],
+// like with other handlers.
+//
+// Note that the callbacks may be called concurrently if background commands or
+// pipelines are used.
+type TraceHooks struct {
+	// File is called right before a file runs, be it the one given to
+	// [Runner.Run] or one loaded via the source builtin.
+	File func(ctx context.Context, file *syntax.File)
+
+	// BeforeStmt is called right before a statement runs.
+	BeforeStmt func(ctx context.Context, stmt *syntax.Stmt)
+
+	// AfterStmt is called right after a statement runs,
+	// with the time it took to run and its exit status.
+	AfterStmt func(ctx context.Context, stmt *syntax.Stmt, elapsed time.Duration, exit uint8)
+
+	// Expand is called after each set of words is expanded,
+	// such as the arguments to a command or the target of a redirection,
+	// with the resulting fields and the time the expansion took.
+	Expand func(ctx context.Context, words []*syntax.Word, fields []string, elapsed time.Duration)
+}
+
+// JobOutputHandlerFunc is a handler which is called each time a background job
+// is started via "&", to choose where the job's standard output and standard
+// error go, such as to keep the output of concurrent jobs apart.
+// [HandlerCtx] gives the job's writers as they would be otherwise.
+// Returning a nil writer keeps the one in [HandlerContext].
+//
+// If a returned writer has a Flush method, like [bufio.Writer], it is called
+// once the job finishes. Note that the writers may be used by multiple
+// goroutines at once, as a job may run commands concurrently.
+// Redirections which are part of the job, like in "cmd >file &", still apply.
+type JobOutputHandlerFunc func(ctx context.Context, job JobInfo) (stdout, stderr io.Writer)
+
+// JobInfo describes a background job, as given to a [JobOutputHandlerFunc].
+type JobInfo struct {
+	// ID is the job's number, as in "%1".
+	ID int
+
+	// PID is the job's process ID, as in "$!".
+	PID int
+
+	// Stmt is the statement run as the job.
+	Stmt *syntax.Stmt
+}
+
+// PrefixJobOutput returns a [JobOutputHandlerFunc] which writes the output of
+// each background job to the runner's standard output and standard error,
+// prefixing each line with the job's number like "[1] ". Lines are written
+// whole, so that the output of concurrent jobs is not interleaved mid-line.
+func PrefixJobOutput() JobOutputHandlerFunc {
+	return func(ctx context.Context, job JobInfo) (io.Writer, io.Writer) {
+		hc := HandlerCtx(ctx)
+		prefix := fmt.Sprintf("[%d] ", job.ID)
+		var stdout, stderr io.Writer
+		if hc.Stdout != nil {
+			stdout = &prefixWriter{w: hc.Stdout, prefix: prefix}
+		}
+		if hc.Stderr != nil {
+			stderr = &prefixWriter{w: hc.Stderr, prefix: prefix}
+		}
+		return stdout, stderr
+	}
+}
+
+// prefixWriter prefixes each line with a string, writing whole lines at once.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+
+	mu  sync.Mutex
+	buf []byte // the current line, including the prefix
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	for rest := p; len(rest) > 0; {
+		if len(pw.buf) == 0 {
+			pw.buf = append(pw.buf, pw.prefix...)
+		}
+		line, after, ok := bytes.Cut(rest, []byte("\n"))
+		pw.buf = append(pw.buf, line...)
+		rest = after
+		if !ok {
+			break
+		}
+		pw.buf = append(pw.buf, '\n')
+		if err := pw.flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes any incomplete last line.
+func (pw *prefixWriter) Flush() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.flush()
+}
+
+func (pw *prefixWriter) flush() error {
+	if len(pw.buf) == 0 {
+		return nil
+	}
+	_, err := pw.w.Write(pw.buf)
+	pw.buf = pw.buf[:0]
+	return err
+}
+
+// AuditHandlerFunc is a handler which receives every action which changes the
+// state of the system or of the processes started by the runner,
+// such as running external commands or opening files for writing.
+// See [AuditEvent] for more info.
+//
+// The handler only observes the actions; to deny them, use a
+// [CommandPolicyFunc] or an [OpenHandlerFunc].
+type AuditHandlerFunc func(ctx context.Context, ev AuditEvent)
+
+// DiagnosticHandlerFunc is a handler which receives warnings about the program
+// being run, such as features which are not supported and are ignored.
+// Each has [syntax.SeverityWarning] and a code like "unsupported-redirection".
+// The position of the diagnostic is invalid if it is not known.
+//
+// The warnings are sent to the [Logger] as well.
+type DiagnosticHandlerFunc func(ctx context.Context, d syntax.Diagnostic)
+
+// SpanKind is the kind of command described by a [Span].
+type SpanKind uint8
+
+const (
+	SpanExec SpanKind = iota // an external command, run via the exec handler
+	SpanFunc                 // a call to a shell function
+)
+
+// Span describes an external command or a function call which is about to run.
+type Span struct {
+	Kind SpanKind
+
+	// Args holds the command's name and arguments after expansion.
+	Args []string
+
+	// Start is when the command started running.
+	Start time.Time
+}
+
+// SpanHandlerFunc is a handler which starts a span for each external command
+// and each function call, to integrate with tracing systems like OpenTelemetry.
+//
+// The returned context is used to run the command, so it can carry the new
+// span; this way, the spans of commands run by a function are children of the
+// function's span. The returned end func, if not nil, is called once the
+// command finishes, with its exit status and how long it took.
+type SpanHandlerFunc func(ctx context.Context, span Span) (context.Context, func(exit uint8, elapsed time.Duration))
+
+// XTraceHandlerFunc is a handler which receives each line of trace output
+// from the "xtrace" shell option, enabled via "set -x".
+// It replaces writing the lines to standard error, in any [XTraceFormat].
+//
+// The line's text starts with the expansion of $PS4, which may use parameters
+// like $LINENO and $FUNCNAME to describe the traced command. The record's
+// other fields give the same information in a structured form.
+//
+// Use [HandlerCtx] to access the standard error of the traced command.
+type XTraceHandlerFunc func(ctx context.Context, rec XTraceRecord)
+
+// WatchdogFunc is a handler which receives the stalls found by a watchdog.
+// See [Watchdog] for more info.
+type WatchdogFunc func(ctx context.Context, stall Stall)
+
 // DefaultExecHandler returns the [ExecHandlerFunc] used by default.
 // It finds binaries in PATH and executes them.
-// When context is cancelled, an interrupt signal is sent to running processes.
+// When context is cancelled, an interrupt signal is sent to running processes,
+// or to their process groups when configured via [Signals].
 // killTimeout is a duration to wait before sending the kill signal.
 // A negative value means that a kill signal will be sent immediately.
 //
 // On Windows, the kill signal is always sent immediately,
 // because Go doesn't currently support sending Interrupt on Windows.
 // [Runner] defaults to a killTimeout of 2 seconds.
+//
+// Programs inherit the umask of the current process, not the one set via the
+// umask builtin, which only applies to the files opened by the shell itself.
+//
+// On js/wasm and wasip1, where there are no processes, programs which are
+// found fail with exit status 126 without using [os/exec];
+// use [ProcessExecHandler] to run them in some other way.
 func DefaultExecHandler(killTimeout time.Duration) ExecHandlerFunc {
 	return func(ctx context.Context, args []string) error {
 		hc := HandlerCtx(ctx)
-		path, err := LookPathDir(hc.Dir, hc.Env, args[0])
+		path, err := hc.lookPath(args[0])
 		if err != nil {
+			if hc.runner != nil {
+				if err, ok := hc.runner.commandNotFound(ctx, args); ok {
+					return err
+				}
+			}
 			fmt.Fprintln(hc.Stderr, err)
 			return NewExitStatus(127)
 		}
+		if runtime.GOARCH == "wasm" {
+			fmt.Fprintf(hc.Stderr, "%s: cannot run programs on %s/wasm\n", args[0], runtime.GOOS)
+			return NewExitStatus(126)
+		}
 		cmd := exec.Cmd{
 			Path:   path,
 			Args:   args,
@@ -107,29 +367,87 @@ func DefaultExecHandler(killTimeout time.Duration) ExecHandlerFunc {
 			Stdout: hc.Stdout,
 			Stderr: hc.Stderr,
 		}
+		if runtime.GOOS != "windows" {
+			cmd.ExtraFiles = hc.extraFiles()
+		}
+
+		var term *ptySession
+		if hc.runner != nil && hc.runner.pty {
+			if term, err = openPTY(&cmd, hc); err != nil {
+				fmt.Fprintf(hc.Stderr, "%v\n", err)
+				return NewExitStatus(126)
+			}
+			defer term.close()
+		}
+		// A pseudo-terminal's program always leads its own process group.
+		group := term != nil
+		if hc.runner != nil && hc.runner.signalCfg != nil && hc.runner.signalCfg.ProcessGroup {
+			setProcessGroup(&cmd)
+			group = true
+		}
+		var jc *jobControl
+		if hc.runner != nil && term == nil {
+			jc = hc.runner.jobControl
+		}
 
-		err = cmd.Start()
+		var fg *jobGroup
+		if jc != nil {
+			fg, err = jc.startForeground(&cmd)
+			group = true
+		} else {
+			err = cmd.Start()
+		}
 		if err == nil {
+			if term != nil {
+				term.started(cmd.Process)
+			}
+			if hc.runner != nil {
+				defer hc.runner.trackProcess(cmd.Process, group)()
+			}
 			if done := ctx.Done(); done != nil {
+				// Once the program has finished, its process group
+				// may be reused, so we must not signal it anymore.
+				finished := make(chan struct{})
+				defer close(finished)
+				stop := func(sig os.Signal) {
+					err := signalProcess(cmd.Process, sig, group)
+					if hc.runner != nil && !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH) {
+						hc.runner.logErr("stopping process", err, "pid", cmd.Process.Pid, "signal", sig.String())
+					}
+				}
 				go func() {
-					<-done
+					select {
+					case <-done:
+					case <-finished:
+						return
+					}
 
 					if killTimeout <= 0 || runtime.GOOS == "windows" {
-						_ = cmd.Process.Signal(os.Kill)
+						stop(os.Kill)
 						return
 					}
 
-					// TODO: don't temporarily leak this goroutine
-					// if the program stops itself with the
-					// interrupt.
 					go func() {
-						time.Sleep(killTimeout)
-						_ = cmd.Process.Signal(os.Kill)
+						select {
+						case <-time.After(killTimeout):
+							stop(os.Kill)
+						case <-finished:
+						}
 					}()
-					_ = cmd.Process.Signal(os.Interrupt)
+					stop(os.Interrupt)
 				}()
 			}
 
+			if fg != nil {
+				state := jc.waitForeground(hc.runner, fg, &cmd)
+				switch {
+				case state.signaled && ctx.Err() != nil:
+					return ctx.Err()
+				case state.status == 0:
+					return nil
+				}
+				return NewExitStatus(uint8(state.status))
+			}
 			err = cmd.Wait()
 		}
 
@@ -234,12 +552,8 @@ func lookPathDir(cwd string, env expand.Environ, file string, find findAny) (str
 	if len(pathList) == 0 {
 		pathList = []string{""}
 	}
-	chars := `/`
-	if runtime.GOOS == "windows" {
-		chars = `:\/`
-	}
 	exts := pathExts(env)
-	if strings.ContainsAny(file, chars) {
+	if hasPathSeparator(file) {
 		return find(cwd, file, exts)
 	}
 	for _, elem := range pathList {
@@ -258,6 +572,16 @@ func lookPathDir(cwd string, env expand.Environ, file string, find findAny) (str
 	return "", fmt.Errorf("%q: executable file not found in $PATH", file)
 }
 
+// hasPathSeparator reports whether a command name is a path,
+// meaning that it should not be searched for in PATH.
+func hasPathSeparator(file string) bool {
+	chars := `/`
+	if runtime.GOOS == "windows" {
+		chars = `:\/`
+	}
+	return strings.ContainsAny(file, chars)
+}
+
 // scriptFromPathDir is similar to LookPathDir, with the difference that it looks
 // for both executable and non-executable files.
 func scriptFromPathDir(cwd string, env expand.Environ, file string) (string, error) {
@@ -292,6 +616,8 @@ func pathExts(env expand.Environ) []string {
 // The path parameter may be relative to the current directory,
 // which can be fetched via [HandlerCtx].
 //
+// The perm parameter already has the mask set via the umask builtin applied.
+//
 // Use a return error of type [*os.PathError] to have the error printed to
 // stderr and the exit status set to 1. If the error is of any other type, the
 // interpreter will come to a stop.
@@ -333,6 +659,158 @@ func DefaultReadDirHandler2() ReadDirHandlerFunc2 {
 	}
 }
 
+// GlobOptions holds the shell options which affect pathname expansion.
+type GlobOptions struct {
+	// GlobStar corresponds to the "globstar" option, where "**" matches
+	// any number of directories.
+	GlobStar bool
+
+	// DotGlob corresponds to the "dotglob" option, where file names starting
+	// with a dot are matched without the pattern starting with one.
+	DotGlob bool
+
+	// NoCaseGlob corresponds to the "nocaseglob" option, where patterns
+	// match file names case-insensitively.
+	NoCaseGlob bool
+
+	// ExtGlob corresponds to the "extglob" option, where patterns may use
+	// extended operators like "@(a|b)", as per [pattern.ExtendedOperators].
+	ExtGlob bool
+}
+
+// GlobHandlerFunc is a handler which performs pathname expansion, also known
+// as globbing, returning the paths matching a pattern like "*.go" or "dir/**"
+// in order. It is not called if the "noglob" option is set.
+//
+// The pattern uses the syntax understood by [pattern.Regexp] with
+// [pattern.Filenames], where quoted characters are escaped.
+// Relative patterns are relative to [HandlerContext.Dir],
+// and the paths returned for them should be relative as well.
+// If no paths match, the pattern is used as a literal word,
+// unless the "nullglob" option is set.
+// Returning an error stops the expansion, like a pattern syntax error would.
+type GlobHandlerFunc func(ctx context.Context, pattern string, opts GlobOptions) ([]string, error)
+
+// DefaultGlobHandler returns the [GlobHandlerFunc] used by default.
+// It reads directories via the handler set by [ReadDirHandler2],
+// stopping once ctx is done or a glob limit set via [Limits] is exceeded.
+func DefaultGlobHandler() GlobHandlerFunc {
+	return func(ctx context.Context, pat string, opts GlobOptions) ([]string, error) {
+		hc := HandlerCtx(ctx)
+		cfg := &expand.Config{
+			Env: expand.FuncEnviron(func(name string) string {
+				if name == "PWD" {
+					return hc.Dir
+				}
+				return ""
+			}),
+			ReadDir2: func(path string) ([]fs.DirEntry, error) {
+				return hc.runner.readDir(ctx, path)
+			},
+			GlobStar:   opts.GlobStar,
+			DotGlob:    opts.DotGlob,
+			NoCaseGlob: opts.NoCaseGlob,
+			ExtGlob:    opts.ExtGlob,
+			Collate:    hc.runner.locale.compare,
+			Context:    ctx,
+
+			GlobMaxMatches: hc.runner.limits.GlobMatches,
+			GlobMaxDepth:   hc.runner.limits.GlobDepth,
+		}
+		return expand.Glob(cfg, pat)
+	}
+}
+
+// SourceHandlerFunc is a handler which resolves and loads the files run via
+// the "source" and "." builtins, such as "lib.sh" in "source lib.sh".
+// It returns the file's path, which is used in error messages and $BASH_SOURCE,
+// as well as a reader for its contents, which the builtin closes once parsed.
+//
+// Returning an error makes the builtin fail with exit status 1,
+// printing the error to stderr.
+type SourceHandlerFunc func(ctx context.Context, name string) (path string, _ io.ReadCloser, _ error)
+
+// DefaultSourceHandler returns the [SourceHandlerFunc] used by default.
+// Like Bash, names without a slash are searched in the directories in $PATH
+// before the current directory. Files are opened via the handler set by
+// [OpenHandler].
+func DefaultSourceHandler() SourceHandlerFunc {
+	return func(ctx context.Context, name string) (string, io.ReadCloser, error) {
+		hc := HandlerCtx(ctx)
+		path, err := scriptFromPathDir(hc.Dir, hc.Env, name)
+		if err != nil {
+			// If the script was not found in PATH or there was any error, pass
+			// the source path to the open handler so it has a chance to look
+			// at files it manages (eg: virtual filesystem), and also allow
+			// it to look for the sourced script in the current directory.
+			path = name
+		}
+		f, err := hc.runner.open(ctx, path, os.O_RDONLY, 0, false)
+		if err != nil {
+			return "", nil, err
+		}
+		return path, f, nil
+	}
+}
+
+// SourceFS returns a [SourceHandlerFunc] which loads files from fsys,
+// such as an [embed.FS] holding a library of scripts, rather than from disk.
+//
+// Names are slash-separated paths within fsys, where a leading slash is ignored.
+// Like with [DefaultSourceHandler], names without a slash are searched in the
+// directories in $PATH within fsys before its root, so that a program running
+// "source lib.sh" with PATH=/lib loads the file "lib/lib.sh".
+func SourceFS(fsys fs.FS) SourceHandlerFunc {
+	return func(ctx context.Context, name string) (string, io.ReadCloser, error) {
+		var paths []string
+		if !strings.Contains(name, "/") {
+			env := HandlerCtx(ctx).Env
+			for _, dir := range filepath.SplitList(env.Get("PATH").String()) {
+				paths = append(paths, fsPath(dir, name))
+			}
+		}
+		paths = append(paths, fsPath(name))
+		for _, p := range paths {
+			f, err := fsys.Open(p)
+			if err != nil {
+				continue
+			}
+			if info, err := f.Stat(); err == nil && !info.IsDir() {
+				return p, f, nil
+			}
+			f.Close()
+		}
+		return "", nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+}
+
+// fsPath joins slash-separated path elements into a path valid for [fs.FS].
+func fsPath(elem ...string) string {
+	p := strings.TrimLeft(path.Join(elem...), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// ChdirHandlerFunc is a handler which is consulted every time the runner
+// changes its current directory, such as via the cd builtin, including "cd -",
+// or via the pushd and popd builtins.
+// It is called with the absolute path of the target directory,
+// before checking that the directory exists.
+//
+// Returning a path changes to that directory instead, which allows confining
+// a program to a directory tree, or mapping paths onto a different layout.
+// $PWD is set to the returned path, and $OLDPWD to the previous $PWD.
+// A relative path is resolved against the current directory.
+//
+// Returning an error denies the change: the error is printed to stderr,
+// the builtin fails with exit status 1, and the directory is left unchanged.
+//
+// Subshells start in the current directory of the parent shell,
+// which the handler already allowed, so it is not called for them.
+type ChdirHandlerFunc func(ctx context.Context, path string) (string, error)
+
 // StatHandlerFunc is a handler which gets a file's information.
 type StatHandlerFunc func(ctx context.Context, name string, followSymlinks bool) (fs.FileInfo, error)
 
@@ -11,14 +11,18 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
 	"mvdan.cc/sh/v3/syntax"
 )
@@ -52,6 +56,15 @@ func blocklistGlob(ctx context.Context, path string) ([]fs.FileInfo, error) {
 	return nil, fmt.Errorf("blocklisted: glob")
 }
 
+func denyPolicy(name string) interp.CommandPolicyFunc {
+	return func(ctx context.Context, cmd string, args []string) error {
+		if cmd == name {
+			return &interp.CommandDeniedError{Name: cmd, Reason: "not allowed"}
+		}
+		return nil
+	}
+}
+
 func execPrint(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
 	return func(ctx context.Context, args []string) error {
 		hc := interp.HandlerCtx(ctx)
@@ -209,6 +222,78 @@ var modCases = []struct {
 		src:  "echo foo; echo foo bar",
 		want: "foo\nrefusing to run echo builtin with multiple args",
 	},
+	{
+		name: "PolicyDenyCommand",
+		opts: []interp.RunnerOption{
+			interp.ExecHandlers(execPrint),
+			interp.CommandPolicy(denyPolicy("foo")),
+		},
+		src:  "foo; echo $?; bar",
+		want: "foo: command denied by policy: not allowed\n126\nwould run: [bar]",
+	},
+	{
+		name: "PolicyDenyCommandBuiltin",
+		opts: []interp.RunnerOption{
+			interp.ExecHandlers(execPrint),
+			interp.CommandPolicy(denyPolicy("foo")),
+		},
+		src:  "command foo || exec foo",
+		want: "foo: command denied by policy: not allowed\nfoo: command denied by policy: not allowed\nexit status 126",
+	},
+	{
+		name: "PolicyNotFound",
+		opts: []interp.RunnerOption{
+			interp.CommandPolicy(func(ctx context.Context, name string, args []string) error {
+				return &interp.CommandDeniedError{Name: name, NotFound: true}
+			}),
+		},
+		src:  "foo bar",
+		want: "foo: command denied by policy\nexit status 127",
+	},
+	{
+		name: "PolicyArgs",
+		opts: []interp.RunnerOption{
+			interp.ExecHandlers(execPrint),
+			interp.CommandPolicy(func(ctx context.Context, name string, args []string) error {
+				if len(args) > 1 {
+					return fmt.Errorf("%s: too many arguments", name)
+				}
+				return nil
+			}),
+		},
+		src:  "foo a; foo a b",
+		want: "would run: [foo a]foo: too many arguments\nexit status 126",
+	},
+	{
+		name: "PolicyDenyBuiltin",
+		opts: []interp.RunnerOption{
+			interp.BuiltinPolicy(denyPolicy("cd")),
+		},
+		src:  "echo foo; builtin cd /; cd /",
+		want: "foo\ncd: command denied by policy: not allowed\ncd: command denied by policy: not allowed\nexit status 126",
+	},
+	{
+		name: "CommandNotFound",
+		opts: []interp.RunnerOption{
+			interp.CommandNotFoundHandler(func(ctx context.Context, args []string) error {
+				hc := interp.HandlerCtx(ctx)
+				fmt.Fprintf(hc.Stdout, "try installing %s\n", args[0])
+				return interp.NewExitStatus(127)
+			}),
+		},
+		src:  "foo_interp_missing bar; echo $?",
+		want: "try installing foo_interp_missing\n127\n",
+	},
+	{
+		name: "CommandNotFoundShellFunc",
+		opts: []interp.RunnerOption{
+			interp.CommandNotFoundHandler(func(ctx context.Context, args []string) error {
+				return fmt.Errorf("should not be called")
+			}),
+		},
+		src:  "command_not_found_handle() { echo \"func: $*\"; }; foo_interp_missing bar; echo $?",
+		want: "func: foo_interp_missing bar\n0\n",
+	},
 	{
 		name: "GlobForbid",
 		opts: []interp.RunnerOption{
@@ -217,6 +302,29 @@ var modCases = []struct {
 		src:  "echo *",
 		want: "blocklisted: glob\n",
 	},
+	{
+		name: "GlobVirtual",
+		opts: []interp.RunnerOption{
+			interp.GlobHandler(func(ctx context.Context, pat string, opts interp.GlobOptions) ([]string, error) {
+				if pat == "none*" {
+					return nil, nil
+				}
+				return []string{"virtual", pat, fmt.Sprint(opts.DotGlob)}, nil
+			}),
+		},
+		src:  "echo *.txt 'a*'; shopt -s dotglob; echo \"x\"*; echo none*; shopt -s nullglob; echo none*; set -f; echo *",
+		want: "virtual *.txt false a*\nvirtual x* true\nnone*\n\n*\n",
+	},
+	{
+		name: "GlobForbidHandler",
+		opts: []interp.RunnerOption{
+			interp.GlobHandler(func(ctx context.Context, pat string, opts interp.GlobOptions) ([]string, error) {
+				return nil, fmt.Errorf("blocklisted: glob %s", pat)
+			}),
+		},
+		src:  "echo *",
+		want: "blocklisted: glob *\n",
+	},
 }
 
 func TestRunnerHandlers(t *testing.T) {
@@ -400,3 +508,378 @@ func TestKillSignal(t *testing.T) {
 		})
 	}
 }
+
+func TestRunnerStreams(t *testing.T) {
+	t.Parallel()
+
+	var artifacts, log bytes.Buffer
+	streams := map[string]any{
+		"@artifacts":   &artifacts,
+		"/dev/app/log": struct{ io.Writer }{&log}, // write-only
+		"@input":       strings.NewReader("line one\nline two\n"),
+	}
+	src := `
+echo foo >@artifacts
+echo bar >>@artifacts
+name=log; echo "to $name" >/dev/app/$name
+read -r first <@input; read -r second <@input
+echo "$first, $second"
+echo never </dev/app/log
+read -r x </dev/app/log
+`
+	file := parse(t, nil, src)
+	var cb concBuffer
+	// Any redirection missing the streams must not touch the package directory.
+	r, err := interp.New(interp.StdIO(nil, &cb, &cb), interp.Streams(streams), interp.Dir(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The option keeps its own copy of the map.
+	delete(streams, "@artifacts")
+	streams["@input"] = strings.NewReader("modified\n")
+	if err := r.Run(context.Background(), file); err != nil {
+		cb.WriteString(err.Error())
+	}
+	if got, want := cb.String(), "line one, line two\nopen /dev/app/log: stream is not readable\nopen /dev/app/log: stream is not readable\nexit status 1"; got != want {
+		t.Fatalf("wrong output:\nwant: %q\ngot:  %q", want, got)
+	}
+	if got, want := artifacts.String(), "foo\nbar\n"; got != want {
+		t.Fatalf("wrong artifacts:\nwant: %q\ngot:  %q", want, got)
+	}
+	if got, want := log.String(), "to log\n"; got != want {
+		t.Fatalf("wrong log:\nwant: %q\ngot:  %q", want, got)
+	}
+
+	if _, err := interp.New(interp.Streams(map[string]any{"@bad": 123})); err == nil {
+		t.Fatal("expected an error for a stream which is neither a reader nor a writer")
+	}
+}
+
+func TestRunnerTrace(t *testing.T) {
+	t.Parallel()
+
+	src := "foo=bar\nif false; then :; fi\necho $foo | cat >/dev/null\nwait"
+	file := parse(t, nil, src)
+	var mu sync.Mutex
+	var events []string
+	printer := syntax.NewPrinter()
+	str := func(node syntax.Node) string {
+		var sb strings.Builder
+		printer.Print(&sb, node)
+		return sb.String()
+	}
+	hooks := interp.TraceHooks{
+		BeforeStmt: func(ctx context.Context, stmt *syntax.Stmt) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, fmt.Sprintf("before %s: %s", stmt.Pos(), str(stmt)))
+		},
+		AfterStmt: func(ctx context.Context, stmt *syntax.Stmt, elapsed time.Duration, exit uint8) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, fmt.Sprintf("after %s: %d", stmt.Pos(), exit))
+		},
+		Expand: func(ctx context.Context, words []*syntax.Word, fields []string, elapsed time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			var srcs []string
+			dynamic := false
+			for _, word := range words {
+				srcs = append(srcs, str(word))
+				dynamic = dynamic || word.Lit() == ""
+			}
+			if dynamic { // skip literal words, as they are many
+				events = append(events, fmt.Sprintf("expand %s: %q", srcs, fields))
+			}
+		},
+	}
+	r, err := interp.New(interp.Trace(hooks), interp.ExecHandlers(testExecHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(context.Background(), file); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"before 1:1: foo=bar",
+		"after 1:1: 0",
+		"before 2:1: if false; then :; fi",
+		"before 2:4: false",
+		"after 2:4: 1",
+		"after 2:1: 0",
+		"before 3:1: echo $foo | cat >/dev/null",
+		"before 3:1: echo $foo", // in a goroutine, but always before expanding
+		"expand [echo $foo]: [\"echo\" \"bar\"]",
+		"after 3:1: 0",
+		"before 3:13: cat >/dev/null",
+		"after 3:13: 0",
+		"after 3:1: 0",
+		"before 4:1: wait",
+		"after 4:1: 0",
+	}
+	// Each side of the pipe runs concurrently, so only check the order of
+	// events within each statement.
+	slices.Sort(want)
+	slices.Sort(events)
+	if !slices.Equal(events, want) {
+		t.Fatalf("wrong events:\nwant: %q\ngot:  %q", want, events)
+	}
+}
+
+type testSpanKey struct{}
+
+func TestRunnerSpanHandler(t *testing.T) {
+	t.Parallel()
+
+	file := parse(t, nil, "f() { ext $1; false; }\nf x\next y; true")
+	var events []string
+	spans := func(ctx context.Context, span interp.Span) (context.Context, func(uint8, time.Duration)) {
+		name := strings.Join(span.Args, " ")
+		if span.Kind == interp.SpanFunc {
+			name = "func " + name
+		}
+		if parent, _ := ctx.Value(testSpanKey{}).(string); parent != "" {
+			name = parent + " > " + name
+		}
+		events = append(events, "start "+name)
+		return context.WithValue(ctx, testSpanKey{}, name), func(exit uint8, elapsed time.Duration) {
+			events = append(events, fmt.Sprintf("end %s: %d", name, exit))
+		}
+	}
+	r, err := interp.New(
+		interp.SpanHandler(spans),
+		interp.ExecHandlers(func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+			return func(ctx context.Context, args []string) error {
+				if args[0] == "ext" {
+					// The span is available to the exec handler.
+					if ctx.Value(testSpanKey{}) == nil {
+						t.Errorf("missing span in the exec handler for %q", args)
+					}
+					return interp.NewExitStatus(uint8(len(args)))
+				}
+				return next(ctx, args)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(context.Background(), file); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"start func f x",
+		"start func f x > ext x",
+		"end func f x > ext x: 2",
+		"end func f x: 1",
+		"start ext y",
+		"end ext y: 2",
+	}
+	if !slices.Equal(events, want) {
+		t.Fatalf("wrong events:\nwant: %q\ngot:  %q", want, events)
+	}
+}
+
+func TestRunnerAuditHandler(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	file := parse(t, nil, `export FOO=bar
+plain=x
+echo hi >out.txt
+cat <out.txt >copy.txt
+cd sub 2>err.txt
+mkdir sub
+cd sub
+FOO=updated
+ext a b || true`)
+	var events []string
+	audit := func(ctx context.Context, ev interp.AuditEvent) {
+		if ev.Time.IsZero() {
+			t.Errorf("missing time in %#v", ev)
+		}
+		switch ev.Kind {
+		case interp.AuditExec:
+			events = append(events, fmt.Sprintf("%s %q", ev.Kind, ev.Args))
+		case interp.AuditOpen:
+			events = append(events, fmt.Sprintf("%s %s", ev.Kind, filepath.ToSlash(strings.TrimPrefix(ev.Path, dir))))
+		case interp.AuditExport:
+			events = append(events, fmt.Sprintf("%s %s=%s", ev.Kind, ev.Name, ev.Value))
+		case interp.AuditChdir:
+			events = append(events, fmt.Sprintf("%s %s", ev.Kind, filepath.ToSlash(strings.TrimPrefix(ev.Path, dir))))
+		}
+	}
+	r, err := interp.New(
+		interp.Env(expand.ListEnviron()),
+		interp.Dir(dir),
+		interp.AuditHandler(audit),
+		interp.ExecHandlers(testExecHandler, func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+			return func(ctx context.Context, args []string) error {
+				if args[0] == "ext" {
+					return interp.NewExitStatus(1)
+				}
+				return next(ctx, args)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(context.Background(), file); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"export FOO=bar",
+		`open /out.txt`,
+		"open /copy.txt",
+		`exec ["cat"]`,
+		"open /err.txt",
+		"chdir /sub",
+		`exec ["mkdir" "sub"]`,
+		"chdir /sub",
+		"export FOO=updated",
+		`exec ["ext" "a" "b"]`,
+	}
+	if !slices.Equal(events, want) {
+		t.Fatalf("wrong events:\nwant: %q\ngot:  %q", want, events)
+	}
+}
+
+// testProcess is a fake program for [interp.ProcessExecHandler],
+// which finishes once done is closed.
+type testProcess struct {
+	exit uint8
+	done chan struct{}
+	once sync.Once
+}
+
+func (p *testProcess) Wait() (uint8, error) {
+	<-p.done
+	return p.exit, nil
+}
+
+func (p *testProcess) Signal(sig os.Signal) error {
+	p.finish(130)
+	return nil
+}
+
+func (p *testProcess) finish(exit uint8) {
+	p.once.Do(func() {
+		p.exit = exit
+		close(p.done)
+	})
+}
+
+func startTestProcess(ctx context.Context, attr interp.ProcessAttr) (interp.Process, error) {
+	p := &testProcess{done: make(chan struct{})}
+	switch attr.Args[0] {
+	case "args":
+		fmt.Fprintf(attr.Stdout, "%q in %s\n", attr.Args, attr.Dir)
+		p.finish(uint8(len(attr.Args) - 1))
+	case "env":
+		fmt.Fprintln(attr.Stdout, slices.Contains(attr.Env, "FOO=bar"))
+		p.finish(0)
+	case "hang":
+		// Only finishes once it is signalled.
+	case "broken":
+		return nil, fmt.Errorf("broken: invalid program")
+	default:
+		return nil, fmt.Errorf("%s: %w", attr.Args[0], fs.ErrNotExist)
+	}
+	return p, nil
+}
+
+func TestProcessExecHandler(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix-like absolute paths")
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"args foo 'bar baz'; echo $?", "[\"args\" \"foo\" \"bar baz\"] in /dir\n2\n"},
+		{"args | while read l; do echo \"<$l>\"; done", "<[\"args\"] in /dir>\n"},
+		{"FOO=bar env; env", "true\nfalse\n"},
+		{"missing; echo $?", "\"missing\": executable file not found\n127\n"},
+		{"broken; echo $?", "broken: invalid program\n126\n"},
+		{"hang", "context deadline exceeded"},
+	}
+	p := syntax.NewParser()
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			file := parse(t, p, test.src)
+			var cb concBuffer
+			r, err := interp.New(
+				interp.StdIO(nil, &cb, &cb),
+				interp.FS(fstest.MapFS{"dir": {Mode: fs.ModeDir}}),
+				interp.Dir("/dir"),
+				interp.ExecHandler(interp.ProcessExecHandler(
+					interp.ProcessStarterFunc(startTestProcess), time.Second)),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			if err := r.Run(ctx, file); err != nil {
+				cb.WriteString(err.Error())
+			}
+			if got := cb.String(); got != test.want {
+				t.Fatalf("want:\n%q\ngot:\n%q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestFS(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("uses Unix-like absolute paths")
+	}
+	fsys := fstest.MapFS{
+		"etc/motd":       {Data: []byte("hello\n")},
+		"home/me/a.txt":  {Data: []byte("a\n")},
+		"home/me/b.txt":  {Data: []byte("b\n")},
+		"home/me/lib.sh": {Data: []byte("greet() { echo hi $1; }\n")},
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"read line </etc/motd; echo $line", "hello\n"},
+		{"echo $PWD; echo *", "/home/me\na.txt b.txt lib.sh\n"},
+		{"cd /etc && echo $PWD; echo m*; cd ../missing", "/etc\nmotd\nexit status 1"},
+		{"[[ -f a.txt && -d /etc && ! -e c.txt ]] && echo ok", "ok\n"},
+		{"while read l; do echo $l; done <../me/b.txt", "b\n"},
+		{"source lib.sh; greet you", "hi you\n"},
+		{"PATH=/etc; cd /; source motd 2>&1", "\"hello\": executable file not found in $PATH\nexit status 127"},
+		{"echo foo >/dev/null; echo bar >a.txt", "open a.txt: permission denied\nexit status 1"},
+		{"cat <missing.txt", "open missing.txt: file does not exist\nexit status 1"},
+	}
+	p := syntax.NewParser()
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			file := parse(t, p, test.src)
+			var cb concBuffer
+			r, err := interp.New(
+				interp.StdIO(nil, &cb, &cb),
+				interp.FS(fsys),
+				interp.Dir("/home/me"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Run(context.Background(), file); err != nil {
+				cb.WriteString(err.Error())
+			}
+			if got := cb.String(); got != test.want {
+				t.Fatalf("want:\n%q\ngot:\n%q", test.want, got)
+			}
+		})
+	}
+}
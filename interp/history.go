@@ -0,0 +1,383 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Interactive sets whether the runner behaves like an interactive shell.
+// For now, this keeps a history of the commands added via
+// [Runner.AddHistory], which the "fc" builtin can list and run again,
+// and enables history expansion via [Runner.ExpandHistory].
+// Like in Bash, it also enables the "expand_aliases" option.
+func Interactive(enabled bool) RunnerOption {
+	return func(r *Runner) error {
+		r.interactive = enabled
+		return nil
+	}
+}
+
+// AddHistory adds a command to the history of an interactive runner.
+// It does nothing if the runner is not interactive, or if the command is empty.
+// Unlike the rest of the shell state, the history is kept by [Runner.Reset].
+//
+// Like Bash, a shell should add each command to the history after any history
+// expansion and before running it, as the "fc" builtin expects the last
+// command in the history to be the one running.
+func (r *Runner) AddHistory(cmd string) {
+	cmd = strings.TrimRight(cmd, "\n")
+	if !r.interactive || strings.TrimSpace(cmd) == "" {
+		return
+	}
+	r.history = append(r.history, cmd)
+}
+
+// History returns the commands in the history of the runner, oldest first.
+// Like in Bash, the first command is number 1.
+func (r *Runner) History() []string {
+	return append([]string(nil), r.history...)
+}
+
+// ExpandHistory performs history expansion on a line of input for an
+// interactive runner, like Bash does before parsing each line.
+// The line is returned unchanged if the runner is not interactive.
+//
+// Events such as "!!", "!-2", "!3", "!echo", and "!?foo?" are supported,
+// as well as word designators like "!$", "!^", "!*", and "!!:2",
+// and quick substitutions like "^foo^bar^".
+// Modifiers such as ":s/foo/bar/" or ":h" are not supported yet.
+func (r *Runner) ExpandHistory(line string) (string, error) {
+	if !r.interactive {
+		return line, nil
+	}
+	return expandHistory(r.history, line)
+}
+
+// historyEventEnd reports whether a byte ends a history event like "!echo".
+func historyEventEnd(b byte) bool {
+	return strings.IndexByte(" \t\n;&|<>()'\"`:$^*", b) >= 0
+}
+
+func expandHistory(hist []string, line string) (string, error) {
+	if strings.HasPrefix(line, "^") {
+		// "^old^new^rest" is short for "!!:s/old/new/rest".
+		old, rest, _ := strings.Cut(line[1:], "^")
+		repl, rest, _ := strings.Cut(rest, "^")
+		repl, nl := strings.CutSuffix(repl, "\n")
+		if len(hist) == 0 {
+			return "", fmt.Errorf("!!: event not found")
+		}
+		prev := hist[len(hist)-1]
+		if old == "" || !strings.Contains(prev, old) {
+			return "", fmt.Errorf(":s^%s^%s: substitution failed", old, repl)
+		}
+		line = strings.Replace(prev, old, repl, 1) + rest
+		if nl {
+			line += "\n"
+		}
+		return line, nil
+	}
+	var sb strings.Builder
+	inSingle, inDouble := false, false
+	for i := 0; i < len(line); i++ {
+		b := line[i]
+		switch {
+		case b == '\\' && !inSingle && i+1 < len(line):
+			sb.WriteByte(b)
+			i++
+			sb.WriteByte(line[i])
+			continue
+		case b == '\'' && !inDouble:
+			inSingle = !inSingle
+		case b == '"' && !inSingle:
+			inDouble = !inDouble
+		}
+		if b != '!' || inSingle || i+1 == len(line) ||
+			strings.HasSuffix(line[:i], "$") || strings.HasSuffix(line[:i], "${") {
+			// Not an event, or one of "$!" and "${!var}".
+			sb.WriteByte(b)
+			continue
+		}
+		switch line[i+1] {
+		case ' ', '\t', '\n', '=', '(':
+			sb.WriteByte(b)
+			continue
+		case '"':
+			if inDouble {
+				sb.WriteByte(b)
+				continue
+			}
+		}
+		start := i
+		i++ // skip the '!'
+		event, words, err := historyEvent(hist, line, &i)
+		if err != nil {
+			return "", err
+		}
+		if words != "" {
+			if event, err = historyWords(event, words); err != nil {
+				return "", fmt.Errorf("%s: bad word specifier", line[start:i])
+			}
+		}
+		sb.WriteString(event)
+		i-- // the loop skips the last byte we consumed
+	}
+	return sb.String(), nil
+}
+
+// historyEvent parses a history event starting at line[*i], right after a '!',
+// advancing *i past it. It returns the command the event refers to,
+// and any word designator which followed it, such as "$" or "1-2".
+func historyEvent(hist []string, line string, i *int) (event, words string, _ error) {
+	start := *i - 1
+	notFound := func() (string, string, error) {
+		return "", "", fmt.Errorf("%s: event not found", line[start:*i])
+	}
+	n := -1 // the index in hist
+	switch b := line[*i]; {
+	case b == '!':
+		*i++
+		n = len(hist) - 1
+	case b == '$' || b == '^' || b == '*' || b == ':':
+		// "!$" is short for "!!:$".
+		n = len(hist) - 1
+	case b == '?':
+		*i++
+		end := strings.IndexAny(line[*i:], "?\n")
+		if end < 0 {
+			end = len(line) - *i
+		}
+		substr := line[*i : *i+end]
+		*i += end
+		if *i < len(line) && line[*i] == '?' {
+			*i++
+		}
+		for j := len(hist) - 1; j >= 0; j-- {
+			if substr != "" && strings.Contains(hist[j], substr) {
+				n = j
+				break
+			}
+		}
+		if n < 0 {
+			return notFound()
+		}
+	default:
+		end := *i
+		for end < len(line) && !historyEventEnd(line[end]) {
+			end++
+		}
+		spec := line[*i:end]
+		*i = end
+		if num, err := strconv.Atoi(spec); err == nil {
+			switch {
+			case num > 0:
+				n = num - 1
+			case num < 0:
+				n = len(hist) + num
+			}
+			if n < 0 || n >= len(hist) {
+				return notFound()
+			}
+			break
+		}
+		for j := len(hist) - 1; j >= 0; j-- {
+			if strings.HasPrefix(hist[j], spec) {
+				n = j
+				break
+			}
+		}
+		if n < 0 {
+			return notFound()
+		}
+	}
+	if n < 0 {
+		return notFound()
+	}
+	event = hist[n]
+
+	// Word designators follow a colon, which can be omitted
+	// if they start with one of "^$*-".
+	if *i < len(line) {
+		rest := line[*i:]
+		if rest[0] == ':' {
+			if n := wordDesignatorLen(rest[1:]); n > 0 {
+				words = rest[1 : 1+n]
+				*i += 1 + n
+			}
+		} else if strings.IndexByte("^$*-", rest[0]) >= 0 {
+			n := wordDesignatorLen(rest)
+			words = rest[:n]
+			*i += n
+		}
+	}
+	return event, words, nil
+}
+
+// wordDesignatorLen returns the length of the word designator at the start of
+// s, such as "2", "$", "1-3", or "2*", or zero if there is none.
+func wordDesignatorLen(s string) int {
+	i := 0
+	index := func() bool {
+		if i < len(s) && (s[i] == '^' || s[i] == '$') {
+			i++
+			return true
+		}
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i > start
+	}
+	switch {
+	case strings.HasPrefix(s, "*"):
+		return 1
+	case strings.HasPrefix(s, "-"):
+		i++
+		if !index() {
+			return 0
+		}
+		return i
+	case !index():
+		return 0
+	}
+	if i < len(s) {
+		switch s[i] {
+		case '*':
+			i++
+		case '-':
+			i++
+			index()
+		}
+	}
+	return i
+}
+
+// historyWords selects the words of a command with a word designator,
+// such as "2", "^", "$", "*", "1-3", or "2*".
+func historyWords(cmd, designator string) (string, error) {
+	fields := splitHistoryWords(cmd)
+	last := len(fields) - 1
+	index := func(s string) (int, error) {
+		switch s {
+		case "^":
+			return 1, nil
+		case "$":
+			return last, nil
+		}
+		return strconv.Atoi(s)
+	}
+	var from, to int
+	var err error
+	switch {
+	case designator == "*":
+		if last < 1 {
+			return "", nil
+		}
+		from, to = 1, last
+	case strings.HasSuffix(designator, "*"):
+		if from, err = index(designator[:len(designator)-1]); err != nil {
+			return "", err
+		}
+		to = last
+	case strings.HasSuffix(designator, "-"):
+		if from, err = index(designator[:len(designator)-1]); err != nil {
+			return "", err
+		}
+		to = last - 1
+	case strings.Contains(designator[1:], "-"):
+		first, second, _ := strings.Cut(designator[1:], "-")
+		first = designator[:1] + first
+		if from, err = index(first); err != nil {
+			return "", err
+		}
+		if to, err = index(second); err != nil {
+			return "", err
+		}
+	case strings.HasPrefix(designator, "-"):
+		if to, err = index(designator[1:]); err != nil {
+			return "", err
+		}
+	default:
+		if from, err = index(designator); err != nil {
+			return "", err
+		}
+		to = from
+	}
+	if from < 0 || to > last || from > to+1 {
+		return "", fmt.Errorf("bad word specifier")
+	}
+	return strings.Join(fields[from:to+1], " "), nil
+}
+
+// splitHistoryWords splits a command into words for history expansion,
+// keeping quoted strings together and splitting out control operators.
+func splitHistoryWords(cmd string) []string {
+	var words []string
+	var sb strings.Builder
+	flush := func() {
+		if sb.Len() > 0 {
+			words = append(words, sb.String())
+			sb.Reset()
+		}
+	}
+	var quote byte
+	for i := 0; i < len(cmd); i++ {
+		b := cmd[i]
+		switch {
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			} else if b == '\\' && quote == '"' && i+1 < len(cmd) {
+				sb.WriteByte(b)
+				i++
+				b = cmd[i]
+			}
+		case b == '\'' || b == '"':
+			quote = b
+		case b == '\\' && i+1 < len(cmd):
+			sb.WriteByte(b)
+			i++
+			b = cmd[i]
+		case b == ' ' || b == '\t' || b == '\n':
+			flush()
+			continue
+		case strings.IndexByte(";&|<>()", b) >= 0:
+			flush()
+			j := i + 1
+			for j < len(cmd) && cmd[j] == b {
+				j++
+			}
+			words = append(words, cmd[i:j])
+			i = j - 1
+			continue
+		}
+		sb.WriteByte(b)
+	}
+	flush()
+	return words
+}
+
+// historyRange parses the first and last arguments to "fc -l" or "fc -s",
+// which may be command numbers, negative offsets, or command prefixes,
+// returning indexes into hist.
+func historyRange(hist []string, spec string) (int, bool) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		switch {
+		case n > 0:
+			n--
+		case n <= 0:
+			n += len(hist)
+		}
+		return max(0, min(n, len(hist)-1)), len(hist) > 0
+	}
+	for i := len(hist) - 1; i >= 0; i-- {
+		if strings.HasPrefix(hist[i], spec) {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -6,18 +6,26 @@ package interp_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"log/slog"
+	"math"
 	"math/bits"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"runtime"
 	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"mvdan.cc/sh/v3/expand"
@@ -107,11 +115,15 @@ func TestMain(m *testing.M) {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
-		runner, _ := interp.New(
+		opts := []interp.RunnerOption{
 			interp.StdIO(os.Stdin, os.Stdout, os.Stderr),
 			interp.OpenHandler(testOpenHandler),
 			interp.ExecHandlers(testExecHandler),
-		)
+		}
+		if os.Getenv("GOSH_CMD") == "job_control" {
+			opts = append(opts, interp.JobControl(os.Stdin))
+		}
+		runner, _ := interp.New(opts...)
 		ctx := context.Background()
 		if err := runner.Run(ctx, file); err != nil {
 			if status, ok := interp.IsExitStatus(err); ok {
@@ -301,6 +313,26 @@ var runTests = []runTest{
 	{`printf '0%s1' 'a\bc'`, `0a\bc1`},
 	{`printf '0%b1' 'a\bc'`, "0a\bc1"},
 	{"printf 'a%bc'", "ac"},
+	{`printf '%b|' '\0101' '\101' '\q' 'a\cb' c`, "A|A|\\q|a"},
+	{`printf '%s\c|'`, `\c|`},
+	{`printf '%q|' 'a b' '' '~x' 'a=~' '#a' 'a#' "it's" $'\e\x01\t'`, `a\ b|''|\~x|a=\~|\#a|a#|it\'s|$'\E\001\t'|`},
+	{"printf %5q. a", "    a."},
+	{`printf '%d,' "'A" ' 12' -0x10; echo`, "65,12,-16,\n"},
+	{"printf '%d,' 1.5 abc 2>/dev/null; echo \" $?\"", "1,0, 1\n"},
+	{"printf '%d,' 1.5 abc >/dev/null", "1.5: invalid number\nabc: invalid number\nexit status 1 #JUSTERR"},
+	{"printf '%.2f|%e|%g|%G|%X|%#x'  3.14159 1000 0.0001 1e-5 255 255", "3.14|1.000000e+03|0.0001|1E-05|FF|0xff"},
+	{"printf '%*d|%-*d|%.*s|%.*d' 5 1 3 2 2 abcdef -1 7", "    1|2  |ab|7"},
+	{"printf '%s %s\n' a b c", "a b\nc \n"},
+	{"printf 'ab%z' 2>/dev/null", "abexit status 1"},
+	{"TZ=UTC printf '%(%Y-%m-%d %T %a %j)T|%10.3(%Y-%m)T|%()T' 1700000000 86400 0", "2023-11-14 22:13:20 Tue 318|       197|00:00:00"},
+	{"TZ=UTC printf '%(%c|%D|%r|%U|%W|%V|%G|%e|%k|%l|%z|%Z|%s|%u|%Q)T' 86400000", "Wed Sep 27 00:00:00 1972|09/27/72|12:00:00 AM|39|39|39|1972|27| 0|12|+0000|UTC|86400000|3|%Q"},
+	{"printf '%(%Y' 0", "invalid format char: (\nexit status 1 #JUSTERR"},
+	{"printf -v x '%s-%d' a 5; echo \"$x\"", "a-5\n"},
+	{"a=(x y); printf -v 'a[1]' %s z; echo ${a[@]}", "x z\n"},
+	{"declare -A m; printf -v 'm[k 1]' %s y; echo ${m[@]}", "y\n"},
+	{"printf -v 1a x", "printf: \"1a\": not a valid identifier\nexit status 2 #JUSTERR"},
+	{"printf -v", "printf: -v: option requires an argument\nexit status 2 #JUSTERR"},
+	{"printf -- '-%s' x", "-x"},
 
 	// words and quotes
 	{"echo  foo_interp_missing ", "foo_interp_missing\n"},
@@ -423,6 +455,20 @@ var runTests = []runTest{
 	{`arr=("foo_interp_missing"); echo ${arr[@]:99}`, "\n"},
 	{`echo ${arr[@]:1:99}; echo ${arr[*]:1:99}`, "\n\n"},
 	{`arr=(0 1 2 3 4 5 6 7 8 9 0 a b c d e f g h); echo ${arr[@]:3:4}`, "3 4 5 6\n"},
+	{`a=(a b c d e); echo "${a[@]: -2}|${a[@]: -2:1}|${a[@]: -10}|${a[@]:5}"`, "d e|d||\n"},
+	{`a=(a b c d e); printf '<%s>' "${a[@]:1:2}" "${a[*]:1:2}"`, "<b><c><b c>"},
+	{`a=(a b c d e); echo "${a[@]:1:-1}"; echo $?`, "-1: substring expression < 0\nexit status 1"},
+	{`(a=abc; echo "${a:2:-2}"; echo in); echo $?`, "-2: substring expression < 0\n1\n"},
+	{`a=(a b c d e); unset 'a[1]' 'a[3]'; echo "${a[@]:1:2}|${a[@]:2}|${a[@]: -2}|${a[*]:0:2}"`, "c e|c e|e|a c\n"},
+	{`a=(a b c); unset 'a[1]'; echo ${#a[@]} ${!a[@]} "${a[@]}"; declare -p a`, "2 0 2 a c\ndeclare -a a=([0]=\"a\" [2]=\"c\")\n"},
+	{`a[5]=x; echo ${#a[@]} ${!a[@]}; declare -p a`, "1 5\ndeclare -a a=([5]=\"x\")\n"},
+	{`a=([2]=x [4]=y); a+=(z); unset 'a[-1]'; declare -p a; unset 'a[4]'; declare -p a`, "declare -a a=([2]=\"x\" [4]=\"y\")\ndeclare -a a=([2]=\"x\")\n"},
+	{`a=(x "" z); echo ${!a[@]}; a[-1]=Z; echo ${a[@]}; a[-9]=y`, "0 1 2\nx Z\na: bad array subscript\nexit status 1 #IGNORE"},
+	{`a=([3]=q); a+=w; declare -p a; s=str; unset 's[0]'; echo ${s-unset}`, "declare -a a=([0]=\"w\" [3]=\"q\")\nunset\n"},
+	{`declare -A m=([k]=v [j]=w); unset 'm[k]'; declare -p m`, "declare -A m=([j]=\"w\" )\n"},
+	{`set -- p q r s t; echo "${@:2}|${@:2:2}|${@: -2}|${*:1:2}|${@:6}|${@: -1}"`, "q r s t|q r|s t|p q||t\n"},
+	{`set -- p q r; printf '<%s>' "${@:2:2}" "${*:2}"`, "<q><r><q r>"},
+	{`set -- p q; echo "${@:1:-1}"`, "-1: substring expression < 0\nexit status 1"},
 	{`echo ${foo_interp_missing[@]}; echo ${foo_interp_missing[*]}`, "\n\n"},
 	// TODO: reenable once we figure out the broken pipe error
 	//{`$ENV_PROG | while read line; do if test -z "$line"; then echo empty; fi; break; done`, ""}, // never begin with an empty element
@@ -445,8 +491,30 @@ var runTests = []runTest{
 	// special vars
 	{"echo $?; false; echo $?", "0\n1\n"},
 	{"for i in 1 2; do\necho $LINENO\necho $LINENO\ndone", "2\n3\n2\n3\n"},
+	{
+		`f() { echo "${FUNCNAME[@]}"; g; }; g() { echo "${FUNCNAME[@]}"; }; f; echo "[${FUNCNAME[@]}]"`,
+		"f\ng f\n[]\n",
+	},
 	{"[[ -n $$ && $$ -gt 0 ]]", ""},
 	{"[[ $$ -eq $PPID ]]", "exit status 1"},
+	{"[[ $RANDOM -ge 0 && $RANDOM -lt 32768 ]]", ""},
+	{"a=$RANDOM b=$RANDOM c=$RANDOM; [[ $a != $b || $b != $c ]]", ""},
+	{"[[ $SECONDS -ge 0 && $SECONDS -lt 10 ]]", ""},
+	{`[[ $EPOCHREALTIME =~ ^[0-9]+\.[0-9]{6}$ ]]`, ""},
+	{"[[ $SRANDOM -ge 0 && $EPOCHSECONDS -gt 1000000000 ]]", ""},
+	{"RANDOM=5; a=$RANDOM; RANDOM=5; b=$RANDOM; [[ $a == $b ]]", ""},
+	{"SECONDS=100; echo $SECONDS", "100\n"},
+	{"echo $((LINENO))\n(( LINENO == 2 )) && echo two", "1\ntwo\n"},
+	{`echo "${#BASH_SOURCE[@]} ${#BASH_LINENO[@]} ${#FUNCNAME[@]}"`, "0 0 0\n"},
+	{
+		"f() { echo \"${BASH_SOURCE[@]}|${BASH_LINENO[@]}|${FUNCNAME[@]}\"; }\nf",
+		"main|2|f\n",
+	},
+	{
+		`echo 'echo "${BASH_SOURCE[@]}|${BASH_LINENO[@]}|${FUNCNAME[@]}"; g() { echo "${FUNCNAME[@]}|${BASH_SOURCE[@]}|${BASH_LINENO[@]}"; }' >s.sh` +
+			"\nh() {\n\tsource s.sh\n}\nh\ng",
+		"s.sh main|3 5|source h\ng|s.sh|6\n",
+	},
 
 	// var manipulation
 	{"echo ${#a} ${#a[@]}", "0 0\n"},
@@ -482,6 +550,10 @@ var runTests = []runTest{
 		"a=abc; echo ${a:1:1}",
 		"b\n",
 	},
+	{
+		"a=abcdef; echo ${a:1:-1} ${a: -4:-2} ${a:2:-4}. ${a:5:10}; echo ${a:1:-10}",
+		"bcde cd . f\n-10: substring expression < 0\nexit status 1",
+	},
 	{
 		"a=foo_interp_missing; echo ${a/no/x} ${a/o/i} ${a//o/i} ${a/fo/}",
 		"foo_interp_missing fio_interp_missing fii_interp_missing o_interp_missing\n",
@@ -510,6 +582,13 @@ var runTests = []runTest{
 	{`a=xyz; echo "${a/y/a  b}"`, "xa  bz\n"},
 	{"a='foo_interp_missing/bar_interp_missing'; echo ${a//o*a/}", "fr_interp_missing\n"},
 	{"a=foobar; echo ${a//a/} ${a///b} ${a///}", "foobr foobar foobar\n"},
+	{"a=foo; echo ${a/#f/x} ${a/#o/x} ${a/%o/x} ${a/%f/x} ${a/#/x} ${a/%/x}", "xoo foo fox foo xfoo foox\n"},
+	{"a=foo; echo ${a/#f*/x} ${a/%o*/x} ${a/#*o/x}", "x fx x\n"},
+	{"a='#o#o'; echo ${a//#o/x} ${a/#\\#/x}", "xx xo#o\n"},
+	{"a=(foo bar); echo ${a[@]/#?/x} ${a[@]/%?/x} ${a[@]//o/0}", "xoo xar fox bax f00 bar\n"},
+	{`a=foo; r='<&>'; echo ${a/o/[&]} ${a//o/&&} ${a/o/$r} "${a/o/&}"`, "f[o]o foooo f<o>o foo\n"},
+	{`a=foo; r='\&'; echo ${a/o/\&} ${a/o/"&"} ${a/o/'&'} ${a/o/$r} ${a/o/\\&}`, "f&o f&o f&o f&o f\\oo\n"},
+	{"shopt -u patsub_replacement; a=foo; echo ${a/o/&}", "f&o\n"},
 	{
 		"echo ${a:-b}; echo $a; a=; echo ${a:-b}; a=c; echo ${a:-b}",
 		"b\n\nb\nc\n",
@@ -590,6 +669,10 @@ var runTests = []runTest{
 		"a=(àÉñ bAr_interp_missing); echo ${a[@]^}; echo ${a[*],,}",
 		"ÀÉñ BAr_interp_missing\nàéñ bar_interp_missing\n",
 	},
+	{
+		"shopt -s extglob; a=abc; echo ${a^b} ${a^^b} ${a^a}; a=ABC; echo ${a,,?(B)}",
+		"abc aBc Abc\nAbC\n",
+	},
 	{
 		"INTERP_X_1=a INTERP_X_2=b; echo ${!INTERP_X_*}",
 		"INTERP_X_1 INTERP_X_2\n",
@@ -622,6 +705,46 @@ var runTests = []runTest{
 		`a='"\n'; printf "%s %s" "${a}" "${a@E}"`,
 		"\"\\n \"\n",
 	},
+	{
+		`a="it's \$x"; b=(1 'two words' ''); echo "${a@Q}"; printf '<%s>' "${b[@]@Q}" ${b[@]@Q} "${b[*]@Q}"; echo`,
+		"'it'\\''s $x'\n<'1'><'two words'><''><'1'><'two><words'><''><'1' 'two words' ''>\n",
+	},
+	{
+		`a=$'tab\there\x01'; n=; echo "${a@Q}" "${n@Q}" "${unset_interp_missing@Q}|"`,
+		"$'tab\\there\\001' '' |\n",
+	},
+	{
+		`a='\e[1m\x41\n'; printf '%q\n' "${a@E}"; b=('a\tb' 'c'); printf '<%s>' "${b[@]@E}"; echo`,
+		"$'\\E[1mA\\n'\n<a\tb><c>\n",
+	},
+	{
+		`a=hello; b=(foo bar); echo "${a@U}" "${a@u}" "${b[@]@u}" "${b[*]@U}"; a=HeLLo; echo "${a@L}"`,
+		"HELLO Hello Foo Bar FOO BAR\nhello\n",
+	},
+	{
+		`v=1; a='\u@\H $v \\'; USER=me HOSTNAME=box; echo "${a@P}"`,
+		"me@box 1 \\\n #IGNORE",
+	},
+	{
+		`a="it's"; b=(1 'two words'); declare -A m=([k]='v 1' ['a b']=2); declare -ir r=5; export x=1; declare -l l=ab; e=; echo "${a@A}"; echo "${b@A}"; echo "${b[1]@A}"; echo "${b[@]@A}"; echo "${m[@]@A}"; echo "${r@A}"; echo "${x@A}"; echo "${l@A}"; echo "${e@A}"; echo "${unset_interp_missing@A}|"`,
+		"a='it'\\''s'\ndeclare -a b='1'\ndeclare -a b='two words'\ndeclare -a b=([0]=\"1\" [1]=\"two words\")\ndeclare -A m=([\"a b\"]=\"2\" [k]=\"v 1\" )\ndeclare -ir r='5'\ndeclare -x x='1'\ndeclare -l l='ab'\ne=''\n|\n #IGNORE",
+	},
+	{
+		`set -- a 'b c'; echo "${@@A}"; echo "${*@Q}"; echo "${1@A}|"; f() { echo "${@@A}|"; }; f`,
+		"set -- 'a' 'b c'\n'a' 'b c'\n|\n|\n",
+	},
+	{
+		`a=x; b=(1 2); declare -A m=([k]=v); declare -ri r=1; echo "${a@a}|${b@a}|${b[@]@a}|${m@a}|${r@a}|${unset_interp_missing@a}|"`,
+		"|a|a a|A|ir||\n",
+	},
+	{
+		`a=x; b=(1 'two words' ''); declare -A m=([k]='v 1' [j]=2); echo "${a@K}" "${b@K}"; echo "${b[@]@K}"; echo "${m[@]@K}|"; printf '<%s>' "${b[@]@k}" "${m[@]@k}"; echo`,
+		"'x' '1'\n0 \"1\" 1 \"two words\" 2 \"\"\nj \"2\" k \"v 1\" |\n<0><1><1><two words><2><><j><2><k><v 1>\n #IGNORE",
+	},
+	{
+		`b=(abc abd); echo ${b[@]#a} "${b[@]^^}" "${b[@]/b/x}"; printf '<%s>' "${b[@]%c}"; echo; echo ${b[@]}`,
+		"bc bd ABC ABD axc axd\n<ab><abd>\nabc abd\n",
+	},
 	{
 		"declare a; a+=(b); echo ${a[@]} ${#a[@]}",
 		"b 1\n",
@@ -959,7 +1082,7 @@ var runTests = []runTest{
 	{"pushd", "pushd: no other directory\nexit status 1 #JUSTERR"},
 	{"pushd -n", ""},
 	{"pushd foo_interp_missing bar_interp_missing", "pushd: too many arguments\nexit status 2 #JUSTERR"},
-	{"pushd does-not-exist; set -- $(dirs); echo $#", "1\n #IGNORE"},
+	{"pushd does-not-exist; set -- $(dirs); echo $#", "pushd: does-not-exist: no such file or directory\n1\n #IGNORE"},
 	{"mkdir a; pushd a >/dev/null; set -- $(dirs); echo $#", "2\n"},
 	{"mkdir a; set -- $(pushd a); echo $#", "2\n"},
 	{
@@ -1007,6 +1130,52 @@ var runTests = []runTest{
 		"mkdir a; pushd a >/dev/null; pushd >/dev/null; rm -r a; popd",
 		"exit status 1 #JUSTERR",
 	},
+	{
+		`HOME=$PWD; mkdir a b; pushd a; pushd ../b; dirs -v; dirs +1; dirs -0; echo ${DIRSTACK[@]#$HOME}`,
+		"~/a ~\n~/b ~/a ~\n 0  ~/b\n 1  ~/a\n 2  ~\n~/a\n~\n/b /a\n",
+	},
+	{
+		`HOME=$PWD; mkdir a b; pushd a >/dev/null; pushd ../b >/dev/null; dirs -l -p | sed "s@$HOME@H@"`,
+		"H/b\nH/a\nH\n",
+	},
+	{
+		`HOME=$PWD; mkdir a b; pushd a >/dev/null; pushd ../b >/dev/null; pushd +1; echo "${PWD#$HOME}"; pushd -0; popd +1; popd -n`,
+		"~/a ~ ~/b\n/a\n~/b ~/a ~\n~/b ~\n~/b\n",
+	},
+	{
+		`HOME=$PWD; mkdir a b; pushd a >/dev/null; cd ../b; dirs; DIRSTACK[1]=/x; DIRSTACK[0]=/y; dirs; dirs -c; dirs`,
+		"~/b ~\n~/b /x\n~/b\n",
+	},
+	{"pushd +1", "pushd: +1: directory stack index out of range\nexit status 1 #JUSTERR"},
+	{"popd -3", "popd: -3: directory stack index out of range\nexit status 1 #JUSTERR"},
+	{"dirs +2", "dirs: +2: directory stack index out of range\nexit status 1 #JUSTERR"},
+	{"pushd does-not-exist", "pushd: does-not-exist: no such file or directory\nexit status 1 #JUSTERR"},
+	{"unset OLDPWD; cd -", "cd: OLDPWD not set\nexit status 1 #JUSTERR"},
+
+	// umask
+	{"umask 027; umask; umask -S; umask -p", "0027\nu=rwx,g=rx,o=\numask 0027\n"},
+	{"umask 0; umask g-w,o=r; umask; umask -pS", "0023\numask -S u=rwx,g=rx,o=r\n"},
+	{"umask 077; umask a+rx; umask; umask u-x+w; umask", "0022\n0122\n"},
+	{"umask 01777; umask; (umask 022); umask", "0777\n0777\n"},
+	{"umask +; umask -- 0; umask", "0000\n"},
+	{"umask 999", "umask: 999: octal number out of range\nexit status 1 #JUSTERR"},
+	{"umask g+z", "umask: `z': invalid symbolic mode character\nexit status 1 #JUSTERR"},
+	{"umask k=r", "umask: `k': invalid symbolic mode operator\nexit status 1 #JUSTERR"},
+	{"umask -x", "umask: invalid option \"-x\"\nexit status 2 #JUSTERR #IGNORE"},
+
+	// hash
+	{"hash -p /x/y foo; hash -t foo; hash -l; hash -d foo; hash", "/x/y\nbuiltin hash -p /x/y foo\nhash: hash table empty\n"},
+	{"hash -p /x foo; hash -p /y bar; hash -t foo bar; hash -r; hash", "foo\t/x\nbar\t/y\nhash: hash table empty\n"},
+	{"hash -p /x foo; PATH=$PATH; hash", "hash: hash table empty\n"},
+	{"hash -p /x foo; (hash -d foo); hash -t foo", "/x\n"},
+	{"hash echo ./x; hash -l", ""},
+	{"hash nope_interp_missing", "hash: nope_interp_missing: not found\nexit status 1 #JUSTERR"},
+	{"hash -d foo", "hash: foo: not found\nexit status 1 #JUSTERR"},
+	{"hash -t", "hash: -t: option requires an argument\nexit status 1 #JUSTERR"},
+
+	// times
+	{"times >/dev/null", ""},
+	{"times | wc -l | tr -d ' '", "2\n"},
 
 	// binary cmd
 	{
@@ -1340,6 +1509,37 @@ var runTests = []runTest{
 		"f() { echo 1; }; { sleep 0.01; f; } & f() { echo 2; }; wait",
 		"1\n",
 	},
+	{"echo \"[$!]\"; true & [[ $! == [0-9]* ]] && echo set", "[]\nset\n"},
+	{"{ exit 3; } & wait $!; echo $?; wait $!; echo $?", "3\n3\n"},
+	{"{ exit 3; } & { exit 4; } & wait %1; echo $?; wait %2; echo $?", "3\n4\n"},
+	{"{ exit 3; } & wait; wait $! 2>/dev/null; echo $?", "127\n"},
+	{"{ exit 3; } & wait %1; wait %1", "exit status 3"},
+	{"wait %1", "wait: %1: no such job\nexit status 127 #JUSTERR"},
+	{"wait -x", "wait: -x: invalid option\nwait: usage: wait [-fn] [-p var] [id ...]\nexit status 2 #JUSTERR"},
+	{"wait -n", "exit status 127"},
+	{"wait -n -p id; echo $? ${id-unset}", "127 unset\n"},
+	{
+		"{ sleep 0.1; exit 3; } & { exit 4; } & wait -n; echo $?; wait -n; echo $?; wait -n; echo $?",
+		"4\n3\n127\n",
+	},
+	{
+		"{ exit 3; } & p=$!; wait -n -p id; echo $? $((id == p))",
+		"3 1\n",
+	},
+	{
+		"{ sleep 0.1; exit 3; } & p=$!; { exit 4; } & wait -n $p; echo $?",
+		"3\n",
+	},
+	{"{ exit 3; } & wait -n %1; echo $?; wait -n %1; echo $?", "3\nwait: %1: no such job\n127\n"},
+	{"{ exit 3; } & wait -n; wait $!; echo $?", "3\n"},
+	{"{ exit 3; } & { exit 4; } & wait -p id %1 %2; echo $? $((id == $!))", "4 1\n"},
+	{"{ exit 3; } & wait -f $!; echo $?", "3\n"},
+	{"sleep 0.1 & jobs; jobs -p >f; read p <f; [[ $p == $! ]] && echo same", "[1]+  Running                 sleep 0.1 &\nsame\n"},
+	{"{ exit 3; } & sleep 0.1 & jobs -p %2 >/dev/null; echo $?", "0\n"},
+	{"jobs %2", "jobs: %2: no such job\nexit status 1 #JUSTERR"},
+	{"jobs -x", "jobs: -x: invalid option\njobs: usage: jobs [-lp] [jobspec ...]\nexit status 2 #JUSTERR #IGNORE"},
+	{"fg", "fg: no job control\nexit status 1 #JUSTERR"},
+	{"true & bg %1", "bg: no job control\nexit status 1 #JUSTERR"},
 
 	// bash test
 	{
@@ -1518,6 +1718,26 @@ var runTests = []runTest{
 		"[[ a =~ [ ]]",
 		"exit status 2",
 	},
+	{
+		`[[ "foo bar" =~ (o+)\ (b.)r ]]; echo $? "${BASH_REMATCH[@]}" ${#BASH_REMATCH[@]}`,
+		"0 oo bar oo ba 3\n",
+	},
+	{
+		`[[ ab =~ (a)(x)?(b) ]]; printf '<%s>' "${BASH_REMATCH[@]}"; [[ abc =~ x ]]; echo $? ${#BASH_REMATCH[@]}`,
+		"<ab><a><><b>1 0\n",
+	},
+	{
+		`[[ abcd =~ (a|ab)(c|bcd) ]]; echo "${BASH_REMATCH[@]}"`,
+		"abcd a bcd\n",
+	},
+	{
+		`[[ a.c =~ "a.c" ]] && echo 1; [[ abc =~ "a.c" ]] || echo 2; [[ abc =~ a\.c ]] || echo 3; re="a.c"; [[ abc =~ $re ]] && echo 4; [[ abc =~ "$re" ]] || echo 5; [[ abc =~ 'a'.c ]] && echo 6`,
+		"1\n2\n3\n4\n5\n6\n",
+	},
+	{
+		`shopt -s compat31; [[ abc =~ "a.c" ]] && echo 1`,
+		"1\n",
+	},
 	{
 		"[[ -e a ]] && echo x; >a; [[ -e a ]] && echo y",
 		"y\n",
@@ -1845,6 +2065,25 @@ var runTests = []runTest{
 		"x=' 3'; let x++; echo \"$x\"",
 		"4\n",
 	},
+	{"echo $((9223372036854775807 + 1)) $((9223372036854775808)) $((99999999999999999999))", "-9223372036854775808 -9223372036854775808 7766279631452241919\n"},
+	{"echo $((-9223372036854775808 / -1)) $((-9223372036854775808 % -1)) $((9223372036854775807 * 2))", "-9223372036854775808 0 -2\n"},
+	{"echo $((1 << 64)) $((1 << 65)) $((1 << -1)) $((-8 >> 1))", "1 2 -9223372036854775808 -4\n"},
+	{"echo $((2 ** 63)) $((2 ** 64)) $((3 ** 0))", "-9223372036854775808 0 1\n"},
+	{"let x=2**-1", "exponent less than 0\nexit status 1 #JUSTERR"},
+	{"echo $((0x1F)) $((0X1f)) $((010)) $((0x)) $((2#101)) $((16#ff)) $((36#zz)) $((36#ZZ))", "31 31 8 0 5 255 1295 1295\n"},
+	{"echo $((64#@)) $((64#_)) $((64#a)) $((64#A)) $((37#a)) $((37#A)) $((64#zZ))", "62 63 10 36 10 36 2301\n"},
+	{"echo $((2#102))", "2#102: value too great for base (error token is \"2#102\")\n #IGNORE bash exits"},
+	{"echo $((08))", "08: value too great for base (error token is \"08\")\n #IGNORE bash exits"},
+	{"echo $((65#1))", "65#1: invalid arithmetic base (error token is \"65#1\")\n #IGNORE bash exits"},
+	{"echo $((10#))", "10#: invalid integer constant (error token is \"10#\")\n #IGNORE bash exits"},
+	{"x=0x10; echo $((x + 1)); ((x++)); echo $x", "17\n17\n"},
+	{"x=1+2; echo $((x * 2))", "6\n"},
+	{"x='y+1' y=z z=5; echo $((x))", "6\n"},
+	{"x='x+1'; echo $((x))", "x+1: expression recursion level exceeded\n #IGNORE bash exits"},
+	{"echo $((5 ? 2 : 3)) $((-1 ? 2 : 3)) $((0 ? 2 : 3))", "2 2 3\n"},
+	{"a=0; echo $((0 && a++)) $a $((1 || a++)) $a $((1 && a++)) $a $((0 ? a++ : 7)) $a", "0 0 1 0 0 1 7 1\n"},
+	{"let 'c = 1 << 3' 'd = c++ + ++c'; echo $c $d", "10 18\n"},
+	{"echo $((n = 0x10)); echo $n; ((n += 010)); echo $n", "16\n16\n24\n"},
 
 	// set/shift
 	{
@@ -1907,6 +2146,34 @@ var runTests = []runTest{
 		"set -e; false && true; true",
 		"",
 	},
+	{
+		"set -e; (false); echo foo_interp_missing",
+		"exit status 1",
+	},
+	{
+		"set -e; (exit 3); echo foo_interp_missing",
+		"exit status 3",
+	},
+	{
+		"set -e; (false && true); echo foo_interp_missing",
+		"exit status 1",
+	},
+	{
+		"set -e; { false && true; }; echo foo",
+		"foo\n",
+	},
+	{
+		"set -e; if (false; echo foo); then echo bar; fi",
+		"foo\nbar\n",
+	},
+	{
+		"set -e; ! { false; echo foo; }; echo bar",
+		"foo\nbar\n",
+	},
+	{
+		"set -e; f() { false; echo foo; }; f || true; f; echo foo_interp_missing",
+		"foo\nexit status 1",
+	},
 	{
 		"false | :",
 		"",
@@ -2053,6 +2320,18 @@ set +o pipefail
 		"a=1; echo $a; unset a; echo $a",
 		"1\n\n",
 	},
+	{
+		`command_not_found_handle() { echo "not found: $*"; return 3; }; foo_interp_missing a b; echo $?`,
+		"not found: foo_interp_missing a b\n3\n",
+	},
+	{
+		`x=1; command_not_found_handle() { x=2; exit 5; }; foo_interp_missing; echo $? $x`,
+		"5 1\n",
+	},
+	{
+		`command_not_found_handle() { echo "$1"; bar_interp_missing; }; foo_interp_missing`,
+		"foo_interp_missing\n\"bar_interp_missing\": executable file not found in $PATH\nexit status 127 #IGNORE bash recurses forever",
+	},
 	{
 		"notinpath() { echo func; }; notinpath; unset -f notinpath; notinpath",
 		"func\n\"notinpath\": executable file not found in $PATH\nexit status 127 #JUSTERR",
@@ -2075,7 +2354,7 @@ set +o pipefail
 	},
 	{
 		"readonly a=1; echo $a; unset a; echo $a",
-		"1\na: readonly variable\n1\n #IGNORE bash prints a warning",
+		"1\nunset: a: cannot unset: readonly variable\n1\n #IGNORE bash prints a prefix",
 	},
 	{
 		"f() { local a=1; echo $a; unset a; echo $a; }; f",
@@ -2122,15 +2401,30 @@ done <<< 2`,
 	{"shopt -so noexec; echo foo_interp_missing", ""},
 	{"shopt -u -o noexec; echo foo_interp_missing", "foo_interp_missing\n"},
 	{"shopt -u globstar; shopt globstar | grep 'off$' | wc -l | tr -d ' '", "1\n"},
+	{"shopt patsub_replacement", "patsub_replacement\ton\n"},
 	{"shopt -s globstar; shopt globstar | grep 'off$' | wc -l | tr -d ' '", "0\n"},
 	{"shopt extglob | grep 'off' | wc -l | tr -d ' '", "1\n"},
+	{"shopt -p extglob; shopt -s extglob; shopt -p extglob", "shopt -u extglob\nshopt -s extglob\n"},
+	{"shopt -po errexit; set -e; shopt -po errexit", "set +o errexit\nset -o errexit\n"},
+	{"shopt -p | while read a b c; do [ \"$a $b\" = 'shopt -s' ] || [ \"$a $b\" = 'shopt -u' ] || echo bad; done", ""},
+	{"shopt -q extglob; echo $?; shopt -s extglob; shopt -q extglob; echo $?", "1\n0\n"},
+	{"shopt -s globstar; shopt -q globstar extglob; echo $?", "1\n"},
+	{"shopt -u extglob; shopt extglob; echo $?", "extglob\toff\n1\n"},
+	{"shopt inherit_errexit", "inherit_errexit\ton\n #IGNORE"},
 	{
-		"shopt inherit_errexit",
-		"inherit_errexit\ton\t(\"off\" not supported)\n #JUSTERR",
+		`set -e; shopt -u inherit_errexit; x=$(false; echo hi); echo "[$x]"`,
+		"[hi]\n",
 	},
+	{"shopt lastpipe", "lastpipe\ton\n #IGNORE"},
+	{"echo foo | read x; echo \"[$x]\"", "[foo]\n #IGNORE"},
+	{"shopt -s lastpipe\necho foo | read x; echo \"[$x]\"", "[foo]\n"},
+	{"shopt -u lastpipe\necho foo | read x; echo \"[$x]\"", "[]\n"},
+	{"shopt -u lastpipe\nx=1; echo 2 | { read x; echo $x; }; echo $x", "2\n1\n"},
+	{"shopt -u lastpipe\necho foo | exit 3; echo $?", "3\n"},
+	{"shopt -u lastpipe; set -o pipefail\nfalse | true; echo $?", "1\n"},
 	{
-		"shopt -s extglob",
-		"shopt: invalid option name \"extglob\" \"off\" (\"on\" not supported)\nexit status 1 #IGNORE",
+		"shopt -s histappend",
+		"shopt: invalid option name \"histappend\" \"off\" (\"on\" not supported)\nexit status 1 #IGNORE",
 	},
 	{
 		"shopt -s interactive_comments",
@@ -2158,10 +2452,21 @@ done <<< 2`,
 		"touch a ab abB Ac Ad; shopt -s nocaseglob; echo *b",
 		"ab abB\n",
 	},
+	{
+		"touch .a b; echo *; shopt -s dotglob; echo *; echo .*",
+		"b\n.a b\n.a\n",
+	},
+	{
+		"mkdir -p c/.d; shopt -s globstar dotglob; echo ** c/* | sed 's@\\\\@/@g'",
+		"c c/.d c/.d\n",
+	},
 
 	// IFS
 	{`echo -n "$IFS"`, " \t\n"},
 	{`a="x:y:z"; IFS=:; echo $a`, "x y z\n"},
+	{`a="x::y:"; IFS=:; printf '<%s>' $a`, "<x><><y>"},
+	{`a=" x : y  z"; IFS=' :'; printf '<%s>' $a`, "<x><y><z>"},
+	{`a=":x:"; IFS=:; printf '<%s>' $a $a"" "$a"`, "<><x><><x><><:x:>"},
 	{`a=(x y z); IFS=-; echo ${a[*]}`, "x y z\n"},
 	{`a=(x y z); IFS=-; echo ${a[@]}`, "x y z\n"},
 	{`a=(x y z); IFS=-; echo "${a[*]}"`, "x-y-z\n"},
@@ -2212,6 +2517,13 @@ done <<< 2`,
 	// TODO: our builtin appears to not receive the piped bytes?
 	// {"trap 'echo on_err' ERR; trap | grep -q '.*echo on_err.*'", "trap -- \"echo on_err\" ERR\n"},
 	{"trap 'false' ERR EXIT; false", "exit status 1"},
+	{"trap 'echo on_int' INT; trap - INT; echo OK", "OK\n"},
+	{"trap 'echo caught' SIGTERM 1 quit; trap INT; echo OK", "OK\n"},
+	{"trap 'echo caught' 9999", "trap: 9999: invalid signal specification\nexit status 2 #JUSTERR"},
+	{
+		"trap 'echo at_exit' EXIT; trap 'echo caught' INT; trap '' TERM; trap; trap - EXIT",
+		"trap -- \"echo at_exit\" EXIT\ntrap -- \"echo caught\" SIGINT\ntrap -- \"\" SIGTERM\n #IGNORE",
+	},
 
 	// eval
 	{"eval", ""},
@@ -2651,6 +2963,35 @@ done <<< 2`,
 		"declare -r -x foo_interp_missing=bar_interp_missing; foo_interp_missing=x",
 		"foo_interp_missing: readonly variable\nexit status 1 #JUSTERR",
 	},
+	{"declare -rx foo_interp_missing=bar_interp_missing; declare -p foo_interp_missing", "declare -rx foo_interp_missing=\"bar_interp_missing\"\n"},
+	{"readonly a=1\nunset a 2>/dev/null\necho $? $a", "1 1\n"},
+	{"readonly a=1\nfor a in x y; do echo $a; done 2>/dev/null\necho $?", "1\n"},
+	{"readonly a=1\na=2 echo foo", "a: readonly variable\nfoo\n #IGNORE bash prints a prefix"},
+	{"f() { local -r a=1; a=2; }; f", "a: readonly variable\nexit status 1 #JUSTERR"},
+	{"f() { local -r a=1; echo $a; }; f; echo \"[$a]\"", "1\n[]\n"},
+	{"readonly a; declare -p a", "declare -r a\n"},
+	{"declare -p foo_interp_missing", "declare: foo_interp_missing: not found\nexit status 1 #JUSTERR"},
+
+	// integer, lowercase, and uppercase vars
+	{"declare -i a=2+3; echo $a; a+=4; echo $a; a=b; echo $a; b=3; a=b*2; echo $a", "5\n9\n0\n6\n"},
+	{"declare -i a; read a <<< 1+1; echo $a; declare -p a", "2\ndeclare -i a=\"2\"\n"},
+	{"declare -i a=(1+1 2*3); echo ${a[@]}", "2 6\n"},
+	{"declare -l a=FoO; echo $a; a+=BAR; echo $a; declare -u a; a=baz; echo $a", "foo\nfoobar\nBAZ\n"},
+	{"declare -u a; for a in x; do echo $a; done; printf -v a %s y; echo $a", "X\nY\n"},
+	{"declare -ilx a=3; declare -p a", "declare -ixl a=\"3\"\n"},
+	{"f() { local -u a=x; echo $a; }; f", "X\n"},
+
+	// printing vars
+	{
+		`a=x; b=$'1\t2'; c='$a "b" \c'; declare -p a b c`,
+		"declare -- a=\"x\"\ndeclare -- b=$'1\\t2'\ndeclare -- c=\"\\$a \\\"b\\\" \\\\c\"\n",
+	},
+	{`a=(x 'y z'); declare -p a`, "declare -a a=([0]=\"x\" [1]=\"y z\")\n"},
+	{`declare -A a=([k]=v ["x y"]=z); declare -p a`, "declare -A a=([k]=\"v\" [\"x y\"]=\"z\" )\n"},
+	{`declare -n a=b; declare -p a`, "declare -n a=\"b\"\n"},
+	{`a=x; b=$'1\n2'; c=(1 '2 3'); eval "$(declare -p a b c)"; echo "$a" "$b" "${c[1]}"`, "x 1\n2 2 3\n"},
+	{"readonly foo_interp_missing=x; readonly -p | grep foo_interp", "declare -r foo_interp_missing=\"x\"\n"},
+	{"export foo_interp_missing=x; export -p | grep foo_interp", "declare -x foo_interp_missing=\"x\"\n"},
 
 	// globbing
 	{"echo .", ".\n"},
@@ -2724,9 +3065,53 @@ done <<< 2`,
 		"shopt -s nullglob; touch existing-1; echo missing-* existing-*",
 		"existing-1\n",
 	},
-	// Extended globbing is not supported
-	{"ls ab+(2|3).txt", "extended globbing is not supported\nexit status 1 #JUSTERR"},
-	{"echo *(/)", "extended globbing is not supported\nexit status 1 #JUSTERR"},
+	// Extended globbing requires extglob, besides within [[
+	{"ls ab+(2|3).txt", "extended globbing is not enabled\nexit status 1 #JUSTERR"},
+	{"echo *(/)", "extended globbing is not enabled\nexit status 1 #JUSTERR"},
+	{
+		"shopt -s extglob\ntouch a.go a.c a.h; echo *.@(go|c) +(a).h ?(x)a.go",
+		"a.c a.go a.h a.go\n",
+	},
+	{
+		"shopt -s extglob\ncase abab in +(ab)) echo y;; esac; y=aab; echo ${y#+(a)} ${y##+(a)} ${y/*(a)/x}",
+		"y\nab b xb\n",
+	},
+	{
+		"shopt -s extglob\nv=abcb; echo ${v//!(b)/-} ${v/#!(a)/-} ${v#!(b)} ${v##!(b)} ${v%!(b)} ${v%%!(b)}. ${v^^!(a)}",
+		"- - abcb abcb . aBCB\n",
+	},
+	{
+		"shopt -s extglob\nfor f in a.go b.txt; do case $f in !(*.txt)) echo $f;; esac; done; p='+(a)'; v=aab; echo ${v#$p} ${v#\"$p\"} ${v##\"*\"}",
+		"a.go\nab aab aab\n",
+	},
+	{
+		"shopt -s extglob\nv=aab; echo ${v/@(a|aa)/x} ${v//@(b|ab)/x}",
+		"xb ax\n",
+	},
+	{
+		"shopt -s extglob\nv=aab; echo ${v#@(aa|a)} ${v##@(a|aa)}; v=héllo; echo ${v%?} ${v%*l*}",
+		"ab b\nhéll hél\n",
+	},
+	{
+		"[[ a.go == !(*.txt) ]] && echo y; [[ a.txt != !(*.txt) ]] && echo z",
+		"y\nz\n",
+	},
+	{
+		"[[ ab == @(a|x)b ]] && echo y; x='@(a|x)b'; [[ ab == $x ]] && echo z; [[ ab == \"$x\" ]] || echo w",
+		"y\nz\nw\n",
+	},
+	{
+		"shopt -s extglob\ntouch a.go b.txt .c d; echo !(*.txt) @(a|!(*.*)); shopt -s dotglob; echo !(*.txt)",
+		"a.go d d\n.c a.go d\n",
+	},
+	{
+		"shopt -s failglob\necho nomatch*\necho $?",
+		"no match: nomatch*\n1\n #IGNORE",
+	},
+	{
+		"shopt -s failglob nullglob; touch a; echo a* nomatch*",
+		"no match: nomatch*\nexit status 1 #IGNORE",
+	},
 	// Ensure that setting nullglob does not return invalid globs as null
 	// strings.
 	{
@@ -2818,6 +3203,27 @@ done <<< 2`,
 	{"{ time -p; } |& wc | tr -s ' '", " 3 6 29\n"},
 	{"{ time -p echo -n; } |& wc | tr -s ' '", " 3 6 29\n"},
 
+	// coproc
+	{
+		`coproc cat; echo hello >&${COPROC[1]}; read -r line <&${COPROC[0]}; echo "got $line"; eval "exec ${COPROC[1]}>&-"; wait; echo done`,
+		"got hello\ndone\n",
+	},
+	{
+		`coproc up { while read -r l; do echo "<$l>"; done; }; echo foo >&"${up[1]}"; eval "exec ${up[1]}>&-"; while read -r l; do echo "$l"; done <&"${up[0]}"`,
+		"<foo>\n",
+	},
+	{
+		`coproc { echo a; echo b; }; [[ $COPROC_PID -gt 0 ]] && echo pid; cat <&${COPROC[0]}`,
+		"pid\na\nb\n",
+	},
+	{
+		// closing a file descriptor without exec only applies to the command
+		`coproc cat; eval "echo x ${COPROC[1]}>&-"; echo y >&${COPROC[1]}; eval "exec ${COPROC[1]}>&-"; cat <&${COPROC[0]}`,
+		"x\ny\n",
+	},
+	{"read x <&5", "5: bad file descriptor\nexit status 1 #JUSTERR"},
+	{"echo foo <&0", "foo\n"},
+
 	// exec
 	{"exec", ""},
 	{
@@ -2828,6 +3234,23 @@ done <<< 2`,
 		"exec $GOSH_PROG 'echo foo_interp_missing'; echo bar_interp_missing",
 		"foo_interp_missing\n",
 	},
+	{"exec >f; echo foo; echo bar; exec >&2; cat f", "foo\nbar\n"},
+	{"exec 1>>f; echo foo; exec 1>>f; echo bar; exec >&2; cat f", "foo\nbar\n"},
+	{"exec 3>f; echo foo >&3; echo bar >&3; exec 3>&-; cat f", "foo\nbar\n"},
+	{"exec 3>f; exec 3>&-; echo foo >&3", "3: bad file descriptor\nexit status 1 #JUSTERR"},
+	{"exec 3>f; { echo foo >&3; } 3>g; echo bar >&3; cat f g", "bar\nfoo\n"},
+	{"{ echo foo >&3; } 3>f; cat f; echo bar >&3", "foo\n3: bad file descriptor\nexit status 1 #JUSTERR"},
+	{"printf 'a\nb\n' >f; exec 3<f; read -u 3 x; read y <&3; echo $x $y", "a b\n"},
+	{"printf 'a\nb\n' >f; exec 4<f; exec 5<&4-; read -u 5 x; echo $x; read -u 4 y", "a\nread: 4: invalid file descriptor\nexit status 1 #JUSTERR"},
+	{"exec 3<>f; echo foo >&3; exec 3>&-; cat f", "foo\n"},
+	{"exec {fd}>f; echo $fd; echo foo >&$fd; exec {fd}>&-; cat f", "10\nfoo\n"},
+	{"exec {a}>f {b}>g; echo $a $b", "10 11\n"},
+	{"exec 3<<< \"fd3\"; read -u 3 x; echo $x", "fd3\n"},
+	{"exec 3<<EOF\na\nb\nEOF\nread -u 3 x; read y <&3; echo $x $y", "a b\n"},
+	{"read -u 4 x 4<<<foo; echo $x", "foo\n"},
+	{"exec 4>f; exec 5>&4; exec 4>&-; echo y >&5; exec 5>&-; cat f", "y\n"},
+	{"exec 4>f; { echo a >&4; } 5>&4; echo b >&4; exec 4>&-; cat f", "a\nb\n"},
+	{"exec 4>f; echo a >&4; { echo b >&4; } 4>&-; echo c >&4; exec 4>&-; cat f", "4: bad file descriptor\na\nc\n #IGNORE"},
 
 	// read
 	{
@@ -2938,6 +3361,70 @@ done <<< 2`,
 		"a=d; echo -n y | (read a; echo -n $a)",
 		"y",
 	},
+	{
+		"read -n 3 a b <<< 'x yzw'; echo \"[$a][$b]\"; read -n1 a <<< 'é漢'; echo \"[$a]\"",
+		"[x][y]\n[é]\n",
+	},
+	{
+		`read -N 4 a b <<< ' x y z'; echo "[$a][$b]"; read -N 2 a <<< 'a\b'; echo "[$a]"; read -r -N 2 a <<< 'a\b'; echo "[$a]"`,
+		"[ x y][]\n[ab]\n[a\\]\n",
+	},
+	{
+		"read -N 3 a <<< 'a'; echo \"$? [$a]\"; read -N 0 a <<< 'a'; echo \"$? [$a]\"",
+		"1 [a\n]\n0 []\n",
+	},
+	{
+		"read -n abc a",
+		"read: abc: invalid number\nexit status 1 #JUSTERR",
+	},
+	{
+		`read -d , a <<< 'a\,b,c'; echo "[$a]"; read -d: a <<< 'x:y'; echo "[$a]"`,
+		"[a,b]\n[x]\n",
+	},
+	{
+		`read -d '' a < <(printf '1\n2\0'); echo "$? [$a]"`,
+		"0 [1\n2]\n",
+	},
+	{
+		"read -d",
+		"read: -d: option requires an argument\nexit status 2 #JUSTERR",
+	},
+	{
+		`read -a arr <<< ' a  b\ c '; echo "${#arr[@]} ${arr[1]}"`,
+		"2 b c\n",
+	},
+	{
+		"read a <<< 'x\\\ny'; echo \"[$a]\"",
+		"[xy]\n",
+	},
+	{
+		`{ sleep 0.2; echo late; } | (read -t 0.01 x; echo "$? [$x]")`,
+		"142 []\n",
+	},
+	{
+		`printf ab | (read -t 1 x; echo "$? [$x]")`,
+		"1 [ab]\n",
+	},
+	{
+		"read -t -1",
+		"read: -1: invalid timeout specification\nexit status 1 #JUSTERR",
+	},
+	{
+		"read -t 0 <<< x; echo $?; read -t 0 </dev/null; echo $?; sleep 0.1 | (read -t 0; echo $?)",
+		"0\n0\n1\n",
+	},
+	{
+		"read -s a <<< secret; echo $a",
+		"secret\n",
+	},
+	{
+		`coproc cat; echo hi >&${COPROC[1]}; read -u ${COPROC[0]} l; echo "[$l]"; eval "exec ${COPROC[1]}>&-"; wait`,
+		"[hi]\n",
+	},
+	{
+		"read -u 9 a",
+		"read: 9: invalid file descriptor\nexit status 1 #JUSTERR",
+	},
 
 	// getopts
 	{
@@ -2954,11 +3441,11 @@ done <<< 2`,
 	},
 	{
 		"getopts abc opt -z",
-		"getopts: illegal option -- \"z\"\n #IGNORE",
+		"gosh: illegal option -- z\n #IGNORE",
 	},
 	{
 		"getopts a: opt -a",
-		"getopts: option requires an argument -- \"a\"\n #IGNORE",
+		"gosh: option requires an argument -- a\n #IGNORE",
 	},
 	{
 		"getopts :abc opt -z; echo $opt; echo $OPTARG",
@@ -3000,6 +3487,50 @@ done <<< 2`,
 		"a() { while getopts abc: opt; do echo $opt $OPTARG; done }; a -a -b -c arg",
 		"a\nb\nc arg\n",
 	},
+	{
+		`getopts a: opt -a; echo "$opt ${OPTARG-unset}"`,
+		"gosh: option requires an argument -- a\n? unset\n #IGNORE",
+	},
+	{
+		`OPTERR=0; getopts a:b opt -a; echo "$opt ${OPTARG-unset}"; OPTIND=1; getopts a:b opt -z; echo "$opt ${OPTARG-unset}"`,
+		"? unset\n? unset\n",
+	},
+	{
+		"getopts a:b opt -afoo -b; echo $opt $OPTARG $OPTIND",
+		"a foo 2\n",
+	},
+	{
+		`getopts a: opt -a ""; echo "$opt [$OPTARG]"`,
+		"a []\n",
+	},
+	{
+		"getopts ab opt -- -a; echo $opt $OPTIND $?",
+		"? 2 1\n",
+	},
+	{
+		"getopts :ab opt -:; echo $opt $OPTARG",
+		"? :\n",
+	},
+	{
+		"getopts ab opt -ab; echo $opt $OPTIND; OPTIND=1; getopts ab opt -ab; echo $opt $OPTIND",
+		"a 1\na 1\n",
+	},
+	{
+		"a() { local OPTIND; while getopts ab opt; do echo $opt; done; }; a -a -b; a -ba",
+		"a\nb\nb\na\n",
+	},
+	{
+		"while getopts ab-: opt --foo=bar -a --baz; do echo $opt $OPTARG; done",
+		"- foo=bar\na\n- baz\n",
+	},
+	// fc
+	{"fc -l", ""},
+	{"fc -s", "fc: no command found\nexit status 1 #JUSTERR"},
+	{"fc", "fc: editing the history is not supported\nexit status 2 #JUSTERR"},
+	{
+		"fc -x",
+		"fc: invalid option \"-x\"\nfc: usage: fc [-e ename] [-lnr] [first] [last] or fc -s [pat=rep] [command]\nexit status 2 #JUSTERR",
+	},
 	// mapfile
 	{
 		"mapfile <<EOF\na\nb\nc\nEOF\n" + `for x in "${MAPFILE[@]}"; do echo "$x"; done`,
@@ -3017,6 +3548,85 @@ done <<< 2`,
 		"mapfile -t butter <<EOF\na\nb\nc\nEOF\n" + `for x in "${butter[@]}"; do echo "$x"; done`,
 		"a\nb\nc\n",
 	},
+	{
+		"cb() { echo \"cb $# $1 $2\"; }; mapfile -t -C cb -c 2 arr <<EOF\na\nb\nc\nd\ne\nEOF\n" + `echo "${arr[@]}"`,
+		"cb 2 1 b\ncb 2 3 d\na b c d e\n",
+	},
+	{
+		"mapfile -t -C 'echo cb' -c 1 <<EOF\na b\nEOF\n",
+		"cb 0 a b\n",
+	},
+	{
+		"{ mapfile -t -s 1 -n 2 arr; read x; } <<EOF\na\nb\nc\nd\nEOF\n" + `echo "${arr[@]}" "$x"`,
+		"b c d\n",
+	},
+	{
+		"mapfile -t -n 0 <<EOF\na\nb\nEOF\n" + `echo "${MAPFILE[@]}"`,
+		"a b\n",
+	},
+	{
+		"arr=(x y z w); mapfile -t -O 1 arr <<EOF\na\nb\nEOF\n" + `echo "${arr[@]}"`,
+		"x a b w\n",
+	},
+	{
+		"arr=(x y z w); mapfile -t arr <<EOF\na\nb\nEOF\n" + `echo "${arr[@]}"`,
+		"a b\n",
+	},
+	{
+		"arr=(x); mapfile -t -O 3 arr <<EOF\na\nEOF\n" + `echo "${#arr[@]} ${arr[3]}"`,
+		"2 a\n",
+	},
+	{
+		`a=([0]=x [2]=y [9]=z); unset "a[9]"; mapfile -t -O 5 a < <(printf "p\nq\n"); declare -p a`,
+		"declare -a a=([0]=\"x\" [2]=\"y\" [5]=\"p\" [6]=\"q\")\n",
+	},
+	{
+		`mapfile -O 2 -t b <<< r; declare -p b`,
+		"declare -a b=([2]=\"r\")\n",
+	},
+	{
+		`printf 'a:b:c' | { mapfile -d : -t; echo "${MAPFILE[@]}"; }`,
+		"a b c\n",
+	},
+	{"mapfile -n x", "mapfile: x: invalid line count\nexit status 1 #JUSTERR"},
+	{"mapfile -s -1", "mapfile: -1: invalid line count\nexit status 1 #JUSTERR"},
+	{"mapfile -O -1", "mapfile: -1: invalid array origin\nexit status 1 #JUSTERR"},
+	{"mapfile -c 0", "mapfile: 0: invalid callback quantum\nexit status 1 #JUSTERR"},
+	{"mapfile -u 7", "mapfile: 7: invalid file descriptor\nexit status 1 #JUSTERR #IGNORE"},
+	{
+		`coproc printf 'a\nb\n'; mapfile -t -u ${COPROC[0]} arr; wait; echo "${arr[@]}"`,
+		"a b\n",
+	},
+
+	// process substitution; named pipes are FIFOs on Unix-like systems
+	{
+		"sed 's/o/e/g' <(echo foo_interp_missing bar_interp_missing)",
+		"fee_interp_missing bar_interp_missing\n",
+	},
+	{
+		"cat <(echo foo_interp_missing) <(echo bar_interp_missing) <(echo baz)",
+		"foo_interp_missing\nbar_interp_missing\nbaz\n",
+	},
+	{
+		"cat <(cat <(echo nested))",
+		"nested\n",
+	},
+	{
+		"echo foo_interp_missing bar_interp_missing > >(sed 's/o/e/g')",
+		"fee_interp_missing bar_interp_missing\n",
+	},
+	{
+		"echo foo_interp_missing bar_interp_missing | tee >(sed 's/o/e/g') >/dev/null",
+		"fee_interp_missing bar_interp_missing\n",
+	},
+	{
+		"echo nested > >(cat > >(cat))",
+		"nested\n",
+	},
+	{
+		"diff <(echo a; echo b) <(echo a; echo b) && echo same",
+		"same\n",
+	},
 }
 
 var runTestsUnix = []runTest{
@@ -3050,6 +3660,33 @@ var runTestsUnix = []runTest{
 	{"sh() { :; }; sh -c 'echo foo_interp_missing'", ""},
 	{"sh() { :; }; command sh -c 'echo foo_interp_missing'", "foo_interp_missing\n"},
 
+	// umask
+	{"umask 077; >a; ls -l a | cut -c1-10", "-rw-------\n"},
+	{"umask 026; >a; ls -l a | cut -c1-10", "-rw-r-----\n"},
+	{"umask 0; umask 077 >b; ls -l b | cut -c1-10", "-rw-r--r--\n #IGNORE depends on the process umask"},
+
+	// hash
+	{
+		"mkdir bin; printf '#!/bin/sh\\necho foo' >bin/foo; chmod +x bin/foo; PATH=$PWD/bin:$PATH; foo; foo; [[ $(hash -t foo) == $PWD/bin/foo ]] && echo ok; out=$(hash); echo \"${out//$PWD/}\"",
+		"foo\nfoo\nok\nhits\tcommand\n   2\t/bin/foo\n",
+	},
+	{
+		"mkdir bin; printf '#!/bin/sh\\necho foo' >bin/foo; chmod +x bin/foo; PATH=$PWD/bin:$PATH; hash foo; type foo | sed \"s@$PWD@@\"",
+		"foo is hashed (/bin/foo)\n",
+	},
+	// Unlike Bash by default, we notice when a hashed command is gone.
+	{
+		"mkdir a b; printf '#!/bin/sh\\necho $0' >a/foo; chmod +x a/foo; cp a/foo b/foo; PATH=$PWD/a:$PWD/b:$PATH; foo >/dev/null; rm a/foo; [[ $(foo) == $PWD/b/foo ]] && echo ok",
+		"ok\n #IGNORE",
+	},
+
+	// ulimit
+	{"ulimit -Sn $(ulimit -Sn); echo $?", "ulimit: open files: cannot modify limit: permission denied\n1\n #IGNORE"},
+	{"[[ $(ulimit) == $(ulimit -f) ]] && echo same", "same\n"},
+	{"ulimit -n -t | cut -c1-25", "open files               \ncpu time                 \n"},
+	{"ulimit -n abc", "ulimit: abc: invalid number\nexit status 1 #JUSTERR"},
+	{"ulimit -z", "ulimit: invalid option \"-z\"\nexit status 2 #JUSTERR #IGNORE"},
+
 	// chmod is practically useless on Windows
 	{
 		"[ -x a ] && echo x; >a; chmod 0755 a; [ -x a ] && echo y",
@@ -3110,7 +3747,7 @@ var runTestsUnix = []runTest{
 	},
 	{
 		`unset UID`,
-		"UID: readonly variable\n #IGNORE",
+		"unset: UID: cannot unset: readonly variable\nexit status 1 #JUSTERR",
 	},
 	{
 		`test -n "$EUID" && echo OK`,
@@ -3122,12 +3759,12 @@ var runTestsUnix = []runTest{
 	},
 	{
 		`unset EUID`,
-		"EUID: readonly variable\n #IGNORE",
+		"unset: EUID: cannot unset: readonly variable\nexit status 1 #JUSTERR",
 	},
 	// GID is not set in bash
 	{
 		`unset GID`,
-		"GID: readonly variable\n #IGNORE",
+		"unset: GID: cannot unset: readonly variable\nexit status 1 #JUSTERR #IGNORE",
 	},
 	{
 		`[[ -z $GID ]] && echo "GID not set"`,
@@ -3170,45 +3807,20 @@ var runTestsUnix = []runTest{
 		"open /shouldnotexist/file: no such file or directory\nexit status 1 #JUSTERR",
 	},
 
-	// process substitution; named pipes (fifos) are a TODO for windows
-	{
-		"sed 's/o/e/g' <(echo foo_interp_missing bar_interp_missing)",
-		"fee_interp_missing bar_interp_missing\n",
-	},
+	// echo trace
 	{
-		"cat <(echo foo_interp_missing) <(echo bar_interp_missing) <(echo baz)",
-		"foo_interp_missing\nbar_interp_missing\nbaz\n",
+		`set -x; animals=("dog", "cat", "otter"); echo "hello ${animals[*]}"`,
+		`+ animals=("dog", "cat", "otter")
++ echo 'hello dog, cat, otter'
+hello dog, cat, otter
+`,
 	},
 	{
-		"cat <(cat <(echo nested))",
-		"nested\n",
-	},
-	{
-		"echo foo_interp_missing bar_interp_missing > >(sed 's/o/e/g')",
-		"fee_interp_missing bar_interp_missing\n",
-	},
-	{
-		"echo foo_interp_missing bar_interp_missing | tee >(sed 's/o/e/g') >/dev/null",
-		"fee_interp_missing bar_interp_missing\n",
-	},
-	{
-		"echo nested > >(cat > >(cat))",
-		"nested\n",
-	},
-	// echo trace
-	{
-		`set -x; animals=("dog", "cat", "otter"); echo "hello ${animals[*]}"`,
-		`+ animals=("dog", "cat", "otter")
-+ echo 'hello dog, cat, otter'
-hello dog, cat, otter
-`,
-	},
-	{
-		`set -x; s="always print a decimal point for %e, %E, %f, %F, %g and %G; do not remove trailing zeros for %g and %G"; echo "$s"`,
-		`+ s='always print a decimal point for %e, %E, %f, %F, %g and %G; do not remove trailing zeros for %g and %G'
-+ echo 'always print a decimal point for %e, %E, %f, %F, %g and %G; do not remove trailing zeros for %g and %G'
-always print a decimal point for %e, %E, %f, %F, %g and %G; do not remove trailing zeros for %g and %G
-`,
+		`set -x; s="always print a decimal point for %e, %E, %f, %F, %g and %G; do not remove trailing zeros for %g and %G"; echo "$s"`,
+		`+ s='always print a decimal point for %e, %E, %f, %F, %g and %G; do not remove trailing zeros for %g and %G'
++ echo 'always print a decimal point for %e, %E, %f, %F, %g and %G; do not remove trailing zeros for %g and %G'
+always print a decimal point for %e, %E, %f, %F, %g and %G; do not remove trailing zeros for %g and %G
+`,
 	},
 	{
 		`set -x
@@ -3303,6 +3915,22 @@ a
 b
 `,
 	},
+	{
+		`set -x; a=$(echo a); b=$(echo $(echo b))`,
+		"++ echo a\n+ a=a\n+++ echo b\n++ echo b\n+ b=b\n",
+	},
+	{
+		"PS4='$LINENO:${FUNCNAME[0]}: '; set -x\nf() { echo a; }\nf",
+		"3:: f\n2:f: echo a\na\n",
+	},
+	{
+		`PS4='x$(echo hi)'; set -x; a=$(echo b)`,
+		"xxhiecho b\nxhia=b\n",
+	},
+	{
+		`PS4=; set -x; echo a`,
+		"echo a\na\n",
+	},
 	{
 		`set -x; for i in $none_a $none_b; do echo $i; done`,
 		``,
@@ -3449,7 +4077,24 @@ func skipIfUnsupported(tb testing.TB, src string) {
 
 func TestRunnerRun(t *testing.T) {
 	t.Parallel()
+	testRunTests(t, func(ctx context.Context, r *interp.Runner, file *syntax.File) error {
+		return r.Run(ctx, file)
+	})
+}
+
+// TestProgramRun checks that programs prepared via Load behave the same.
+func TestProgramRun(t *testing.T) {
+	t.Parallel()
+	testRunTests(t, func(ctx context.Context, r *interp.Runner, file *syntax.File) error {
+		prog, err := interp.Load(file)
+		if err != nil {
+			return err
+		}
+		return prog.Run(ctx, r)
+	})
+}
 
+func testRunTests(t *testing.T, run func(context.Context, *interp.Runner, *syntax.File) error) {
 	p := syntax.NewParser()
 	for _, c := range runTests {
 		c := c
@@ -3475,7 +4120,7 @@ func TestRunnerRun(t *testing.T) {
 			}
 			ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
 			defer cancel()
-			if err := r.Run(ctx, file); err != nil {
+			if err := run(ctx, r, file); err != nil {
 				cb.WriteString(err.Error())
 			}
 			want := c.want
@@ -3911,6 +4556,26 @@ func TestRunnerOpts(t *testing.T) {
 			"(echo $foo); echo x | echo $foo",
 			"bar\nbar\n",
 		},
+		{
+			nil,
+			"set -e; [ \"$(false; echo foo)\" = foo ] && echo bar; x=$(false; echo foo) || true; echo $x",
+			"bar\nfoo\n",
+		},
+		{
+			opts(interp.ErrExit(interp.ErrExitBash)),
+			"set -e; x=$(false; echo foo); echo bar",
+			"exit status 1",
+		},
+		{
+			opts(interp.ErrExit(interp.ErrExitPOSIX)),
+			"set -e; [ \"$(false; echo foo)\" = foo ] && echo bar; x=$(false; echo foo) || true; echo \"[$x]\"",
+			"[]\n",
+		},
+		{
+			opts(interp.ErrExit(interp.ErrExitPOSIX)),
+			"set -e; if (false; echo foo); then echo bar; fi",
+			"foo\nbar\n",
+		},
 	}
 	p := syntax.NewParser()
 	for _, c := range cases {
@@ -4382,3 +5047,1304 @@ func TestRunnerSubshell(t *testing.T) {
 		t.Fatalf("wrong output:\nwant: %q\ngot:  %q", want, got)
 	}
 }
+
+func TestRunnerClone(t *testing.T) {
+	t.Parallel()
+
+	r, err := interp.New(interp.StdIO(nil, io.Discard, io.Discard))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, parse(t, nil, "foo=bar; list=(x y); f() { foo+=$1; list+=($1); echo $foo ${list[@]}; }")); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	clones := make([]*interp.Runner, n)
+	outs := make([]strings.Builder, n)
+	for i := range clones {
+		clones[i] = r.Clone()
+		interp.StdIO(nil, &outs[i], io.Discard)(clones[i])
+	}
+	var wg sync.WaitGroup
+	for i, r2 := range clones {
+		i, r2 := i, r2
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := fmt.Sprintf("f %d; f %d", i, i)
+			if err := r2.Run(ctx, parse(t, nil, src)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	// The original can keep running while its clones do.
+	if err := r.Run(ctx, parse(t, nil, "foo=orig; list[0]=orig")); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	for i := range outs {
+		want := fmt.Sprintf("bar%d x y %d\nbar%d%d x y %d %d\n", i, i, i, i, i, i)
+		if got := outs[i].String(); got != want {
+			t.Errorf("clone %d: wrong output:\nwant: %q\ngot:  %q", i, want, got)
+		}
+	}
+	if want, got := "orig", r.Vars["foo"].String(); got != want {
+		t.Fatalf("wrong output:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestRunnerCloneJobs(t *testing.T) {
+	t.Parallel()
+
+	r, err := interp.New(interp.Deterministic(1, time.Unix(0, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, parse(t, nil, "true & wait")); err != nil {
+		t.Fatal(err)
+	}
+	// Each clone numbers its jobs on its own, like the original would.
+	for i := 0; i < 3; i++ {
+		var out strings.Builder
+		r2 := r.Clone()
+		interp.StdIO(nil, &out, io.Discard)(r2)
+		if err := r2.Run(ctx, parse(t, nil, "true & wait; echo $!")); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := "2\n", out.String(); got != want {
+			t.Fatalf("clone %d: wrong output:\nwant: %q\ngot:  %q", i, want, got)
+		}
+	}
+}
+
+func TestRunnerReport(t *testing.T) {
+	t.Parallel()
+
+	file := parse(t, nil, `echo hi
+f() { echo oops >&2; return 3; }
+for i in 1 2; do f $i || true; done
+coproc read x
+true &
+echo done >&${COPROC[1]}
+wait
+`)
+	file.Name = "main.sh"
+	var stdout, stderr strings.Builder
+	var rep interp.RunReport
+	r, err := interp.New(interp.StdIO(nil, &stdout, &stderr), interp.Report(&rep))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+
+	var stmts []string
+	for _, sr := range rep.Stmts() {
+		stmts = append(stmts, fmt.Sprintf("%s:%s runs=%d failures=%d exit=%d",
+			sr.File, sr.Pos, sr.Runs, sr.Failures, sr.Exit))
+	}
+	wantStmts := []string{
+		"main.sh:1:1 runs=1 failures=0 exit=0",
+		"main.sh:2:1 runs=1 failures=0 exit=0",
+		"main.sh:2:5 runs=2 failures=0 exit=0",
+		"main.sh:2:7 runs=2 failures=0 exit=0",
+		"main.sh:2:22 runs=2 failures=0 exit=0",
+		"main.sh:3:1 runs=1 failures=0 exit=0",
+		"main.sh:3:18 runs=2 failures=2 exit=3",
+		"main.sh:3:18 runs=2 failures=0 exit=0",
+		"main.sh:3:26 runs=2 failures=0 exit=0",
+		"main.sh:4:1 runs=1 failures=0 exit=0",
+		"main.sh:4:13 runs=1 failures=0 exit=0",
+		"main.sh:5:1 runs=1 failures=0 exit=0",
+		"main.sh:6:1 runs=1 failures=0 exit=0",
+		"main.sh:7:1 runs=1 failures=0 exit=0",
+	}
+	if !slices.Equal(stmts, wantStmts) {
+		t.Errorf("wrong statements:\nwant: %q\ngot:  %q", wantStmts, stmts)
+	}
+
+	var cmds []string
+	for _, cr := range rep.Commands() {
+		cmds = append(cmds, fmt.Sprintf("%s=%d", strings.Join(cr.Args, " "), cr.Exit))
+	}
+	wantCmds := []string{
+		"echo hi=0",
+		"f 1=3", "echo oops=0", "return 3=3", "true=0",
+		"f 2=3", "echo oops=0", "return 3=3", "true=0",
+		"echo done=0", "read x=0", "true=0", "wait=0",
+	}
+	// The background jobs may run in any order.
+	slices.Sort(cmds[9:])
+	if !slices.Equal(cmds, wantCmds) {
+		t.Errorf("wrong commands:\nwant: %q\ngot:  %q", wantCmds, cmds)
+	}
+	if want, got := int64(len(stdout.String())), rep.Written(1); got != want {
+		t.Errorf("wrong stdout bytes: want %d, got %d", want, got)
+	}
+	if want, got := int64(len(stderr.String())), rep.Written(2); got != want || got == 0 {
+		t.Errorf("wrong stderr bytes: want %d, got %d", want, got)
+	}
+	if want, got := 2, rep.PeakJobs(); got != want {
+		t.Errorf("wrong peak jobs: want %d, got %d", want, got)
+	}
+
+	var buf bytes.Buffer
+	if err := rep.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := float64(2), decoded["peakJobs"]; got != want {
+		t.Errorf("wrong JSON peakJobs: want %v, got %v", want, got)
+	}
+}
+
+func TestRunnerDeterministic(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"b", "c", "a"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Return directory entries in reverse order.
+	readDir := func(ctx context.Context, path string) ([]fs.DirEntry, error) {
+		entries, err := os.ReadDir(path)
+		slices.Reverse(entries)
+		return entries, err
+	}
+	frozen := time.Date(2024, 3, 4, 5, 6, 7, 8000, time.UTC)
+	src := "echo $RANDOM $RANDOM; echo $(echo $RANDOM); echo $SECONDS $EPOCHREALTIME; echo *\ncoproc true\necho $COPROC_PID"
+	run := func() string {
+		var out strings.Builder
+		r, err := interp.New(
+			interp.Dir(dir),
+			interp.StdIO(nil, &out, &out),
+			interp.ReadDirHandler2(readDir),
+			interp.Deterministic(123, frozen),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+		defer cancel()
+		if err := r.Run(ctx, parse(t, nil, src)); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+	got := run()
+	lines := strings.Split(got, "\n")
+	if want := fmt.Sprintf("0 %d.000008", frozen.Unix()); lines[2] != want {
+		t.Fatalf("wrong time line:\nwant: %q\ngot:  %q", want, lines[2])
+	}
+	if want := "a b c"; lines[3] != want {
+		t.Fatalf("wrong glob line:\nwant: %q\ngot:  %q", want, lines[3])
+	}
+	if want := "1"; lines[4] != want {
+		t.Fatalf("wrong coproc line:\nwant: %q\ngot:  %q", want, lines[4])
+	}
+	for i := 0; i < 3; i++ {
+		if got2 := run(); got2 != got {
+			t.Fatalf("output changed between runs:\nfirst: %q\nlater: %q", got, got2)
+		}
+	}
+}
+
+func TestRunnerOpt(t *testing.T) {
+	t.Parallel()
+
+	r, err := interp.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"errexit", "globstar", "huponexit"} {
+		if on, err := r.Opt(name); err != nil || on {
+			t.Fatalf("Opt(%q) got %v, %v; want false, nil", name, on, err)
+		}
+		if err := r.SetOpt(name, true); err != nil {
+			t.Fatal(err)
+		}
+		if on, err := r.Opt(name); err != nil || !on {
+			t.Fatalf("Opt(%q) got %v, %v; want true, nil", name, on, err)
+		}
+	}
+	if _, err := r.Opt("foo"); err == nil {
+		t.Fatalf("Opt(%q) did not error", "foo")
+	}
+	if err := r.SetOpt("histappend", true); err == nil {
+		t.Fatalf("SetOpt(%q) did not error", "histappend")
+	}
+	if err := r.SetOpt("histappend", false); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	interp.StdIO(nil, &out, &out)(r)
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	// The options set before the first Run are kept by Reset;
+	// with huponexit, the background job is stopped when the shell exits.
+	src := "shopt globstar; shopt -o errexit; sleep 10 & exit 3"
+	for i := 0; i < 2; i++ {
+		out.Reset()
+		r.Reset()
+		if err := r.Run(ctx, parse(t, nil, src)); err == nil {
+			t.Fatal("expected an exit status error")
+		}
+		if want := "globstar\ton\nerrexit\ton\n"; out.String() != want {
+			t.Fatalf("want:\n%q\ngot:\n%q", want, out.String())
+		}
+		for len(r.JobStatuses()) == 0 {
+			select {
+			case <-ctx.Done():
+				t.Fatal("background job was not stopped")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		// Let the background job finish before the runner is reset.
+		r.Run(ctx, parse(t, nil, "wait"))
+	}
+}
+
+func TestRunnerHistory(t *testing.T) {
+	t.Parallel()
+
+	var out strings.Builder
+	r, err := interp.New(interp.StdIO(nil, &out, &out), interp.Interactive(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	// Add each command before running it, like an interactive shell would.
+	run := func(line string) {
+		t.Helper()
+		line, err := r.ExpandHistory(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.AddHistory(line)
+		if err := r.Run(ctx, parse(t, nil, line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run("x=1")
+	run("echo foo bar")
+	run("echo !^ !$")
+	run("!e:0 !?x=?")
+	r.AddHistory("  ")
+	run("fc -l")
+	run("fc -s x=y x")
+	run("fc -lnr -3 -2")
+	want := "foo bar\nfoo bar\nx=1\n" +
+		"1\t x=1\n2\t echo foo bar\n3\t echo foo bar\n4\t echo x=1\n" +
+		"y=1\n" +
+		"\t fc -l\n\t echo x=1\n"
+	if got := out.String(); got != want {
+		t.Fatalf("wrong output:\nwant: %q\ngot:  %q", want, got)
+	}
+	wantHist := []string{
+		"x=1", "echo foo bar", "echo foo bar", "echo x=1",
+		"fc -l", "y=1", "fc -lnr -3 -2",
+	}
+	if got := r.History(); !reflect.DeepEqual(got, wantHist) {
+		t.Fatalf("wrong history:\nwant: %q\ngot:  %q", wantHist, got)
+	}
+
+	for _, line := range []string{"!foo", "!9", "!!:9"} {
+		if _, err := r.ExpandHistory(line); err == nil {
+			t.Errorf("expected an error expanding %q", line)
+		}
+	}
+
+	// A runner which is not interactive does not keep a history.
+	r, err = interp.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddHistory("echo foo")
+	if got, _ := r.ExpandHistory("!!"); got != "!!" || len(r.History()) > 0 {
+		t.Fatalf("non-interactive runner kept a history")
+	}
+}
+
+func TestRunnerChdirHandler(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub", "dir"), 0o777); err != nil {
+		t.Fatal(err)
+	}
+	// Confine the program to root, where "/home" means root itself.
+	chdir := func(ctx context.Context, path string) (string, error) {
+		if path == filepath.FromSlash("/home") {
+			return root, nil
+		}
+		if rel, err := filepath.Rel(root, path); err != nil || strings.HasPrefix(rel, "..") {
+			return "", fmt.Errorf("outside of %s", filepath.Base(root))
+		}
+		return path, nil
+	}
+	src := `
+rel() { echo "${PWD#"$1"}" | tr '\\' /; }
+cd sub/dir; rel "$1"
+cd ../../..; echo $?; rel "$1"
+cd - >/dev/null; rel "$1"
+cd /home; rel "$1"; rel "$OLDPWD"
+pushd sub >/dev/null; rel "$1"
+popd >/dev/null; rel "$1"
+(cd ..; echo $?)
+`
+	var out strings.Builder
+	r, err := interp.New(
+		interp.Dir(root),
+		interp.Params("--", root),
+		interp.StdIO(nil, &out, &out),
+		interp.ChdirHandler(chdir),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, parse(t, nil, src)); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`/sub/dir
+cd: ../../..: outside of %[1]s
+1
+/sub/dir
+
+
+
+/sub
+
+cd: ..: outside of %[1]s
+1
+`, filepath.Base(root))
+	if got := out.String(); got != want {
+		t.Fatalf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestRunnerSandbox(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		src       string
+		want      string
+		wantLimit bool
+	}{
+		{"echo foo; ls", "foo\nls: command denied by policy: external commands are not allowed in a sandbox\nexit status 127", false},
+		{"echo foo >file", "open file: permission denied\nexit status 1", false},
+		{"echo foo >>/dev/null; echo bar", "bar\n", false},
+		{"echo foo >@out; echo bar", "bar\n", false},
+		{"ulimit -n 10", "ulimit: open files: cannot modify limit: permission denied\nexit status 1", false},
+		{"i=0; while true; do i=$((i+1)); done", "", true},
+		{"for ((;;)) { :; }", "", true},
+		{"f() { f; }; f", "", true},
+		{"x=a; while true; do x=$x$x; done", "", true},
+		{"echo ${HOME-unset} ${PATH-unset}", "unset unset\n", false},
+		{"read -r l </etc/passwd", "open /etc/passwd: permission denied\nexit status 1", false},
+		{"echo x >/dev/null; read -r l </dev/null; echo $?", "1\n", false},
+		{"[ -e / ] || echo denied; echo /*", "denied\n/*\n", false},
+		{"source /etc/profile", "source: open /etc/profile: permission denied\nexit status 1", false},
+		{": {1..3000000}", "more than 100000 fields", true},
+		{"x='a '; for i in {1..17}; do x=$x$x; done; : $x", "more than 100000 fields", true},
+		{"x=aaaaaaaaaa; for i in {1..16}; do x=$x$x; done; i=0; while true; do declare v$i=$x; i=$((i+1)); done", "more than 16777216 bytes of variables", true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			var out strings.Builder
+			r, err := interp.New(
+				interp.Dir(dir),
+				interp.StdIO(nil, &out, &out),
+				interp.Streams(map[string]any{"@out": io.Discard}),
+				interp.Sandbox(),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+			defer cancel()
+			err = r.Run(ctx, parse(t, nil, test.src))
+			if test.wantLimit {
+				if !errors.Is(err, interp.ErrSandboxLimit) || !strings.Contains(err.Error(), test.want) {
+					t.Fatalf("want a sandbox limit error with %q, got: %v", test.want, err)
+				}
+				return
+			}
+			if err != nil {
+				out.WriteString(err.Error())
+			}
+			if got := out.String(); got != test.want {
+				t.Fatalf("wrong output in %q:\nwant: %q\ngot:  %q", test.src, test.want, got)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "file")); err == nil {
+				t.Fatalf("file was created")
+			}
+		})
+	}
+}
+
+func TestRunnerSandboxLimits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cfg  interp.SandboxConfig
+		src  string
+		want string
+	}{
+		{
+			interp.SandboxConfig{LoopIterations: 10},
+			"for i in {1..5}; do :; done; for i in {1..5}; do :; done; echo ok; for i in {1..5}; do :; done",
+			"ok\nsandbox limit exceeded: more than 10 loop iterations",
+		},
+		{
+			interp.SandboxConfig{FuncDepth: 3},
+			"f() { echo $1; f $(($1+1)); }; f 1",
+			"1\n2\n3\nsandbox limit exceeded: more than 3 nested function calls",
+		},
+		{
+			interp.SandboxConfig{VarBytes: 100},
+			"x=$(printf '%0100d' 0)",
+			"sandbox limit exceeded: x: value larger than 100 bytes",
+		},
+		{
+			interp.SandboxConfig{TotalVarBytes: 1000},
+			"i=0; while true; do declare v$i=$(printf '%050d' 0); i=$((i+1)); done",
+			"sandbox limit exceeded: more than 1000 bytes of variables",
+		},
+		{
+			interp.SandboxConfig{Fields: 10},
+			"echo {1..10}; echo {1..11}",
+			"1 2 3 4 5 6 7 8 9 10\nsandbox limit exceeded: fields limit exceeded: more than 10 fields",
+		},
+		{
+			interp.SandboxConfig{Timeout: 10 * time.Millisecond, LoopIterations: math.MaxInt64},
+			"while true; do :; done",
+			"context deadline exceeded",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			var out strings.Builder
+			r, err := interp.New(interp.StdIO(nil, &out, &out), interp.SandboxLimits(test.cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+			defer cancel()
+			if err := r.Run(ctx, parse(t, nil, test.src)); err != nil {
+				out.WriteString(err.Error())
+			}
+			if got := out.String(); got != test.want {
+				t.Fatalf("wrong output in %q:\nwant: %q\ngot:  %q", test.src, test.want, got)
+			}
+		})
+	}
+
+	if _, err := interp.New(interp.SandboxLimits(interp.SandboxConfig{FuncDepth: -1})); err == nil {
+		t.Fatal("expected an error for negative sandbox limits")
+	}
+}
+
+func TestRunnerLimits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		cfg  interp.LimitConfig
+		src  string
+		want string
+	}{
+		{
+			interp.LimitConfig{FuncDepth: 10},
+			"f() { echo $1; f $(($1+1)); }; f 1 | tail -n 1",
+			"10\nlimit exceeded: more than 10 nested function calls",
+		},
+		{
+			interp.LimitConfig{FuncDepth: 10},
+			"f() { if (($1 < 10)); then f $(($1+1)); else echo $1; fi; }; f 1",
+			"10\n",
+		},
+		{
+			interp.LimitConfig{FuncDepth: 3},
+			"f() { (f); }; f",
+			"limit exceeded: more than 3 nested function calls",
+		},
+		{
+			interp.LimitConfig{LoopIterations: 5},
+			"for i in 1 2 3 4 5; do echo -n $i; done; for i in 1 2 3 4 5; do echo -n $i; done; echo",
+			"1234512345\n",
+		},
+		{
+			interp.LimitConfig{LoopIterations: 5},
+			"i=0; while true; do i=$((i+1)); done; echo unreachable",
+			"limit exceeded: more than 5 loop iterations",
+		},
+		{
+			interp.LimitConfig{LoopIterations: 5},
+			"for ((i = 0; i < 10; i++)); do echo -n $i; done",
+			"01234limit exceeded: more than 5 loop iterations",
+		},
+		{
+			interp.LimitConfig{Statements: 4},
+			"echo 1; echo 2; echo 3; echo 4",
+			"1\n2\n3\n4\n",
+		},
+		{
+			interp.LimitConfig{Statements: 4},
+			"echo 1; (echo 2; echo 3); echo 4",
+			"1\n2\n3\nlimit exceeded: more than 4 statements",
+		},
+		{
+			interp.LimitConfig{VarBytes: 1000},
+			"a=x; while :; do a+=xxxxxxxx; done; echo unreachable",
+			"limit exceeded: more than 1000 bytes of variables",
+		},
+		{
+			interp.LimitConfig{VarBytes: 1000},
+			"a=(); while :; do a+=(xxxxxxxx); done; echo unreachable",
+			"limit exceeded: more than 1000 bytes of variables",
+		},
+		{
+			interp.LimitConfig{VarBytes: 1000},
+			"declare -A m=(); i=0; while :; do m[k$((i++))]=x; done; echo unreachable",
+			"limit exceeded: more than 1000 bytes of variables",
+		},
+		{
+			// Locals and subshell variables are freed once they end.
+			interp.LimitConfig{VarBytes: 1000},
+			"x=$(printf '%0200d' 0); f() { local y=$x$x; }; for i in 1 2 3 4 5; do f; (z=$x$x); done; echo ok",
+			"ok\n",
+		},
+		{
+			interp.LimitConfig{GlobMatches: 3},
+			"touch a b c; echo *; touch d; echo *; echo unreachable",
+			"a b c\nlimit exceeded: *: glob limit exceeded: more than 3 matches",
+		},
+		{
+			interp.LimitConfig{GlobMatches: 3},
+			"mkdir a b c; touch a/x b/x c/x; echo */x; mkdir d; echo */x",
+			"a/x b/x c/x\nlimit exceeded: */x: glob limit exceeded: more than 3 matches",
+		},
+		{
+			interp.LimitConfig{GlobDepth: 2},
+			"shopt -s globstar; mkdir -p a/b; echo **; echo a/**; mkdir a/b/c; echo a/**; echo **",
+			"a a/b\na/ a/b\na/ a/b a/b/c\nlimit exceeded: **: glob limit exceeded: more than 2 directory levels",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			var out strings.Builder
+			r, err := interp.New(interp.StdIO(nil, &out, &out), interp.Dir(t.TempDir()), interp.Limits(test.cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = r.Run(context.Background(), parse(t, nil, test.src))
+			if err != nil {
+				if !errors.Is(err, interp.ErrLimitExceeded) {
+					t.Fatalf("want a limit error, got: %v", err)
+				}
+				out.WriteString(err.Error())
+			}
+			if got := out.String(); got != test.want {
+				t.Fatalf("wrong output in %q:\nwant: %q\ngot:  %q", test.src, test.want, got)
+			}
+			// The same runner can run again, with its limits reset.
+			if test.cfg.Statements > 0 {
+				if err := r.Run(context.Background(), parse(t, nil, "echo again")); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+
+	if _, err := interp.New(interp.Limits(interp.LimitConfig{Statements: -1})); err == nil {
+		t.Fatal("want an error for negative limits")
+	}
+}
+
+func TestRunnerLocale(t *testing.T) {
+	t.Parallel()
+
+	const src = `touch B a _c é e 1; echo *; x=éa; echo ${x^^}; declare -u y=$x; echo $y; [[ a < B ]] && echo lt || echo ge`
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"", "1 B _c a e é\nÉA\nÉA\nge\n"},
+		{"C.UTF-8", "1 B _c a e é\nÉA\nÉA\nge\n"},
+		{"C", "1 B _c a e é\néA\néA\nge\n"},
+		{"POSIX", "1 B _c a e é\néA\néA\nge\n"},
+		{"en_US.UTF-8", "1 _c a B e é\nÉA\nÉA\nlt\n"},
+		{"en_US.utf8@euro", "1 _c a B e é\nÉA\nÉA\nlt\n"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.locale, func(t *testing.T) {
+			t.Parallel()
+			var out strings.Builder
+			r, err := interp.New(
+				interp.Dir(t.TempDir()),
+				interp.StdIO(nil, &out, &out),
+				interp.Locale(test.locale),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Run(context.Background(), parse(t, nil, src)); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != test.want {
+				t.Fatalf("wrong output:\nwant: %q\ngot:  %q", test.want, got)
+			}
+		})
+	}
+
+	if _, err := interp.New(interp.Locale("en_US.ISO-8859-1")); err == nil {
+		t.Fatal("want an error for an unsupported locale")
+	}
+}
+
+func TestRunnerLogger(t *testing.T) {
+	t.Parallel()
+
+	var logs strings.Builder
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "stack" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	r, err := interp.New(
+		interp.StdIO(nil, io.Discard, io.Discard),
+		interp.Logger(logger),
+		interp.ExecHandlers(func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+			return func(ctx context.Context, args []string) error {
+				if args[0] == "crash" {
+					panic("crashed")
+				}
+				return next(ctx, args)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(context.Background(), parse(t, nil, "echo foo >&-; echo bar >&file")); err != nil {
+		t.Fatal(err)
+	}
+	want := `level=WARN msg="closing the standard streams is not supported" fd=1
+level=WARN msg="unsupported redirection" op=>& word=file
+`
+	if got := logs.String(); got != want {
+		t.Fatalf("wrong logs:\nwant: %q\ngot:  %q", want, got)
+	}
+
+	logs.Reset()
+	func() {
+		defer func() {
+			if p := recover(); p != "crashed" {
+				t.Fatalf("want the panic to continue, got: %v", p)
+			}
+		}()
+		r.Run(context.Background(), parse(t, nil, "crash"))
+	}()
+	want = "level=ERROR msg=\"panic while running shell code\" panic=crashed\n"
+	if got := logs.String(); got != want {
+		t.Fatalf("wrong logs:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestRunnerDiagnosticHandler(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	r, err := interp.New(
+		interp.StdIO(nil, io.Discard, io.Discard),
+		interp.DiagnosticHandler(func(ctx context.Context, d syntax.Diagnostic) {
+			got = append(got, fmt.Sprintf("%s-%s %s %s: %s", d.Pos, d.End, d.Severity, d.Code, d.Error()))
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := syntax.NewParser().Parse(strings.NewReader("echo foo >&-\necho bar >&file"), "script.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Run(context.Background(), file); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1:10-1:13 warning close-standard-stream: script.sh:1:10: closing the standard stream 1 is not supported",
+		"2:10-2:16 warning unsupported-redirection: script.sh:2:10: unsupported redirection: >&file",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("wrong diagnostics:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestRunnerSourceFS(t *testing.T) {
+	t.Parallel()
+
+	fsys := fstest.MapFS{
+		"a.sh":         {Data: []byte("echo a $BASH_SOURCE\n")},
+		"lib/b.sh":     {Data: []byte("echo b $BASH_SOURCE $@\n")},
+		"lib/dir.sh/x": {Data: []byte("")},
+		"bin/a.sh":     {Data: []byte("echo bin/a\n")},
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"source a.sh", "a a.sh\n"},
+		{"source /a.sh", "a a.sh\n"},
+		{"source ./lib/../a.sh", "a a.sh\n"},
+		{"source lib/b.sh x y", "b lib/b.sh x y\n"},
+		{"PATH=/lib; . b.sh", "b lib/b.sh\n"},
+		{"PATH=/bin:/lib; source a.sh", "bin/a\n"},
+		{"PATH=/lib; source dir.sh", "source: open dir.sh: file does not exist\nexit status 1"},
+		{"source b.sh", "source: open b.sh: file does not exist\nexit status 1"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			var out strings.Builder
+			r, err := interp.New(
+				interp.Env(expand.ListEnviron("PATH=")),
+				interp.StdIO(nil, &out, &out),
+				interp.SourceHandler(interp.SourceFS(fsys)),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Run(context.Background(), parse(t, nil, test.src)); err != nil {
+				fmt.Fprint(&out, err)
+			}
+			if got := out.String(); got != test.want {
+				t.Fatalf("wrong output in %q:\nwant: %q\ngot:  %q", test.src, test.want, got)
+			}
+		})
+	}
+}
+
+func TestRunnerJobOutput(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	outputs := make(map[int]*concBuffer)
+	var stmts []string
+	var out concBuffer
+	r, err := interp.New(
+		interp.StdIO(nil, &out, &out),
+		interp.JobOutputHandler(func(ctx context.Context, job interp.JobInfo) (io.Writer, io.Writer) {
+			mu.Lock()
+			defer mu.Unlock()
+			buf := &concBuffer{}
+			outputs[job.ID] = buf
+			var sb strings.Builder
+			syntax.NewPrinter().Print(&sb, job.Stmt)
+			stmts = append(stmts, sb.String())
+			return buf, nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := "echo main; { echo a1; sleep 0.05; echo a2; } & { echo b1; echo b2 >&2; } & echo c >/dev/null & wait; echo done"
+	if err := r.Run(context.Background(), parse(t, nil, src)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "main\nb2\ndone\n"; got != want {
+		t.Fatalf("wrong runner output:\nwant: %q\ngot:  %q", want, got)
+	}
+	want := map[int]string{1: "a1\na2\n", 2: "b1\n", 3: ""}
+	for id, w := range want {
+		if got := outputs[id].String(); got != w {
+			t.Fatalf("wrong output for job %d:\nwant: %q\ngot:  %q", id, w, got)
+		}
+	}
+	wantStmts := []string{"{\n\techo a1\n\tsleep 0.05\n\techo a2\n} &", "{\n\techo b1\n\techo b2 >&2\n} &", "echo c >/dev/null &"}
+	if !slices.Equal(stmts, wantStmts) {
+		t.Fatalf("wrong job statements:\nwant: %q\ngot:  %q", wantStmts, stmts)
+	}
+
+	// PrefixJobOutput writes whole lines, flushing any incomplete line once the job
+	// finishes, so the line "c" is written after the line "d" on stderr.
+	out.Reset()
+	r, err = interp.New(
+		interp.StdIO(nil, &out, &out),
+		interp.JobOutputHandler(interp.PrefixJobOutput()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src = "echo main; { echo a; printf 'b\\nc'; echo d >&2; } & wait; echo done; echo e &"
+	if err := r.Run(context.Background(), parse(t, nil, src)); err != nil {
+		t.Fatal(err)
+	}
+	r.Run(context.Background(), parse(t, nil, "wait"))
+	if got, want := out.String(), "main\n[1] a\n[1] b\n[1] d\n[1] cdone\n[1] e\n"; got != want {
+		t.Fatalf("wrong prefixed output:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestRunnerCheckedArithm(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"echo $((9223372036854775806 + 1)) $((-9223372036854775807 - 1)) $((2**62))", "9223372036854775807 -9223372036854775808 4611686018427387904\n"},
+		{"echo $((9223372036854775807 + 1))", "integer overflow\n"},
+		{"echo $((-9223372036854775807 - 2))", "integer overflow\n"},
+		{"echo $((3037000500 * 3037000500))", "integer overflow\n"},
+		{"echo $((2**63))", "integer overflow\n"},
+		{"echo $((1 << 63))", "integer overflow\n"},
+		{"echo $((9223372036854775808))", "integer overflow\n"},
+		{"echo $((-9223372036854775807 - 1 / -1))", "-9223372036854775806\n"},
+		{"x=-9223372036854775807; ((x--)); echo $x; ((x--)); echo $x", "-9223372036854775808\ninteger overflow\n-9223372036854775808\n"},
+		{"x=9223372036854775807; ((x += 1)); echo $x", "integer overflow\n9223372036854775807\n"},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			var out strings.Builder
+			r, err := interp.New(interp.StdIO(nil, &out, &out), interp.CheckedArithm(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Run(context.Background(), parse(t, nil, test.src))
+			if got := out.String(); got != test.want {
+				t.Fatalf("wrong output in %q:\nwant: %q\ngot:  %q", test.src, test.want, got)
+			}
+		})
+	}
+}
+
+func TestRunnerJobStatuses(t *testing.T) {
+	t.Parallel()
+
+	var out strings.Builder
+	r, err := interp.New(interp.StdIO(nil, &out, &out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `
+{ exit 3; } & a=$!
+{ exit 0; } & b=$!
+wait -n
+coproc { exit 4; }
+c=$COPROC_PID
+wait
+echo $a $b $c
+`
+	if err := r.Run(context.Background(), parse(t, nil, src)); err != nil {
+		t.Fatal(err)
+	}
+	var a, b, c int
+	if _, err := fmt.Sscan(out.String(), &a, &b, &c); err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]uint8{a: 3, b: 0, c: 4}
+	if got := r.JobStatuses(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	r.Reset()
+	if got := r.JobStatuses(); len(got) > 0 {
+		t.Fatalf("want no statuses after Reset, got %v", got)
+	}
+}
+
+func TestRunnerExpandWord(t *testing.T) {
+	t.Parallel()
+
+	var words []*syntax.Word
+	err := syntax.NewParser().Words(strings.NewReader(`$foo-$((n+1)) "$(echo $foo)" ${unset?oops}`), func(w *syntax.Word) bool {
+		words = append(words, w)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expr, err := syntax.NewParser().Arithmetic(strings.NewReader("m = n * 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r *interp.Runner
+	var expanded [][]string
+	r, err = interp.New(
+		interp.StdIO(nil, io.Discard, io.Discard),
+		interp.ExecHandlers(func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+			return func(ctx context.Context, args []string) error {
+				if args[0] != "expand-words" {
+					return next(ctx, args)
+				}
+				// Expand the words from a handler, in the middle of a Run.
+				for _, word := range words[:2] {
+					fields, err := r.ExpandWord(ctx, word)
+					if err != nil {
+						return err
+					}
+					expanded = append(expanded, fields)
+				}
+				return nil
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, parse(t, nil, "foo='a b'; n=3; expand-words")); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"a", "b-4"}, {"a b"}}
+	if !reflect.DeepEqual(expanded, want) {
+		t.Fatalf("wrong fields:\nwant: %q\ngot:  %q", want, expanded)
+	}
+
+	if _, err := r.ExpandWord(ctx, words[2]); err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Fatalf("want an unset parameter error, got: %v", err)
+	}
+	n, err := r.Arithm(ctx, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 {
+		t.Fatalf("wrong result: want 6, got %d", n)
+	}
+	fields, err := r.ExpandWord(ctx, words[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "b-4"}; !reflect.DeepEqual(fields, want) {
+		t.Fatalf("wrong fields:\nwant: %q\ngot:  %q", want, fields)
+	}
+	var out strings.Builder
+	interp.StdIO(nil, &out, io.Discard)(r)
+	if err := r.Run(ctx, parse(t, nil, "echo $m")); err != nil {
+		t.Fatal(err)
+	}
+	if want, got := "6\n", out.String(); got != want {
+		t.Fatalf("wrong output:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestRunnerXTraceJSON(t *testing.T) {
+	t.Parallel()
+
+	file, err := syntax.NewParser().Parse(strings.NewReader(
+		"set -x\na=$(echo a)\nfor i in x; do echo \"$a $i\" >/dev/null; done\nset +x\n"), "main.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr strings.Builder
+	r, err := interp.New(interp.StdIO(nil, io.Discard, &stderr), interp.XTrace(interp.XTraceJSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []interp.XTraceRecord
+	dec := json.NewDecoder(strings.NewReader(stderr.String()))
+	for dec.More() {
+		var rec interp.XTraceRecord
+		if err := dec.Decode(&rec); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Time.IsZero() {
+			t.Fatalf("missing time in record: %+v", rec)
+		}
+		rec.Time = time.Time{}
+		got = append(got, rec)
+	}
+	want := []interp.XTraceRecord{
+		{File: "main.sh", Line: 2, Col: 5, Args: []string{"echo", "a"}, Level: 2},
+		{File: "main.sh", Line: 2, Col: 1, Args: []string{"a=a"}, Level: 1},
+		{File: "main.sh", Line: 3, Col: 1, Args: []string{"for", "i", "in", "x"}, Level: 1},
+		{File: "main.sh", Line: 3, Col: 16, Args: []string{"echo", "a x"}, Level: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong records:\n%s\nwant:\n%+v\ngot:\n%+v", stderr.String(), want, got)
+	}
+
+	if _, err := interp.New(interp.XTrace(10)); err == nil {
+		t.Fatal("expected an error for an invalid format")
+	}
+}
+
+func TestRunnerXTraceHandler(t *testing.T) {
+	t.Parallel()
+
+	file := parse(t, nil, "PS4='+$LINENO+ '\nf() {\n\techo \"$@\"\n}\nset -x\nf foo bar\n")
+	var stderr strings.Builder
+	var got []string
+	r, err := interp.New(
+		interp.StdIO(nil, io.Discard, &stderr),
+		interp.XTrace(interp.XTraceJSON), // ignored
+		interp.XTraceHandler(func(ctx context.Context, rec interp.XTraceRecord) {
+			hc := interp.HandlerCtx(ctx)
+			fmt.Fprintf(hc.Stderr, "%s|%s|%q\n", rec.Text, rec.Func, rec.Args)
+			got = append(got, rec.Text)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+	want := "+6+ f foo bar||[\"f\" \"foo\" \"bar\"]\n+3+ echo 'foo bar'|f|[\"echo\" \"foo\" \"bar\"]\n"
+	if got := stderr.String(); got != want {
+		t.Fatalf("wrong trace output:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestRunnerHandlerFiles(t *testing.T) {
+	t.Parallel()
+
+	file := parse(t, nil, "exec 3>f {fd}>g\nwritefd 3 foo\nwritefd $fd bar\nwritefd 4 baz\nexec 3>&- {fd}>&-\ncat f g\n")
+	var cb concBuffer
+	r, err := interp.New(
+		interp.Dir(t.TempDir()),
+		interp.StdIO(nil, &cb, &cb),
+		interp.ExecHandlers(func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+			return func(ctx context.Context, args []string) error {
+				if args[0] != "writefd" {
+					return next(ctx, args)
+				}
+				hc := interp.HandlerCtx(ctx)
+				fd, _ := strconv.Atoi(args[1])
+				w, ok := hc.Files[fd].(io.Writer)
+				if !ok {
+					fmt.Fprintf(hc.Stderr, "%d: not open\n", fd)
+					return interp.NewExitStatus(1)
+				}
+				fmt.Fprintln(w, args[2])
+				return nil
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+	want := "4: not open\nfoo\nbar\n"
+	if got := cb.String(); got != want {
+		t.Fatalf("wrong output:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestRunnerWatchdog(t *testing.T) {
+	t.Parallel()
+
+	file := parse(t, nil, "{ sleep 0.2; echo hi; } | read -r x\nprintf '%1000000s' x | { sleep 0.2; cat >/dev/null; }\n")
+	var mu sync.Mutex
+	var got []string
+	r, err := interp.New(interp.StdIO(nil, io.Discard, io.Discard),
+		interp.Watchdog(50*time.Millisecond, func(ctx context.Context, stall interp.Stall) {
+			mu.Lock()
+			defer mu.Unlock()
+			if time.Since(stall.Since) < 50*time.Millisecond {
+				t.Errorf("stall reported too early: %s", stall)
+			}
+			got = append(got, fmt.Sprintf("%s fd=%d write=%t", stall.Stmt.Pos(), stall.Fd, stall.Write))
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"1:27 fd=0 write=false", "2:1 fd=1 write=true"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("wrong stalls:\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestRunnerCoverage(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	lib := "greet() {\n\tif [ \"$1\" = x ]; then\n\t\techo x\n\telse\n\t\techo other\n\tfi\n}\n"
+	if err := os.WriteFile(filepath.Join(dir, "lib.sh"), []byte(lib), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	unused := parse(t, nil, "echo never\n")
+	unused.Name = "unused.sh"
+	file, err := syntax.NewParser().Parse(strings.NewReader(
+		"source ./lib.sh\nfor i in 1 2; do greet $i; done\nsource ./lib.sh\n"), "main.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cov interp.Coverage
+	cov.AddFile(unused)
+	r, err := interp.New(interp.Dir(dir), interp.StdIO(nil, io.Discard, io.Discard), interp.Trace(cov.Hooks()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := cov.WriteGoCover(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `mode: count
+unused.sh:1.1,1.11 1 0
+main.sh:1.1,1.16 1 1
+main.sh:2.1,2.32 1 1
+main.sh:2.18,2.27 1 2
+main.sh:3.1,3.16 1 1
+DIR/lib.sh:1.1,7.2 1 2
+DIR/lib.sh:1.9,7.2 1 2
+DIR/lib.sh:2.2,6.4 1 2
+DIR/lib.sh:2.5,2.18 1 2
+DIR/lib.sh:3.3,3.9 1 0
+DIR/lib.sh:5.3,5.13 1 2
+`
+	if got := strings.ReplaceAll(sb.String(), dir, "DIR"); got != want {
+		t.Fatalf("wrong Go cover profile:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+
+	sb.Reset()
+	if err := cov.WriteLCOV(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want = `TN:
+SF:unused.sh
+DA:1,0
+LF:1
+LH:0
+end_of_record
+TN:
+SF:main.sh
+DA:1,1
+DA:2,2
+DA:3,1
+LF:3
+LH:3
+end_of_record
+TN:
+SF:DIR/lib.sh
+DA:1,2
+DA:2,2
+DA:3,0
+DA:5,2
+LF:4
+LH:3
+end_of_record
+`
+	if got := strings.ReplaceAll(sb.String(), dir, "DIR"); got != want {
+		t.Fatalf("wrong LCOV tracefile:\nwant:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestRunnerDebugger(t *testing.T) {
+	t.Parallel()
+
+	file, err := syntax.NewParser().Parse(strings.NewReader(`f() {
+	x=inner
+	echo $x
+}
+x=outer
+f
+echo $x done
+`), "main.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []string
+	steps := []interp.DebugStep{
+		interp.DebugStepInto,
+		interp.DebugStepOver,
+		interp.DebugStepOut,
+		interp.DebugContinue,
+	}
+	dbg := &interp.Debugger{
+		Pause: func(ctx context.Context, frame *interp.DebugFrame) interp.DebugStep {
+			events = append(events, fmt.Sprintf("%s:%d %v x=%s",
+				frame.File, frame.Stmt.Pos().Line(), frame.Funcs, frame.Var("x")))
+			if len(events) == 1 {
+				frame.SetVar("x", expand.Variable{Kind: expand.String, Str: "changed"})
+			}
+			step := steps[0]
+			steps = steps[1:]
+			return step
+		},
+	}
+	dbg.SetBreakpoint("main.sh", 6)
+
+	var cb concBuffer
+	r, err := interp.New(interp.StdIO(nil, &cb, &cb), interp.Debug(dbg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), runnerRunTimeout)
+	defer cancel()
+	if err := r.Run(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"main.sh:6 [] x=outer",
+		"main.sh:1 [f] x=changed",
+		"main.sh:2 [f] x=changed",
+		"main.sh:7 [] x=inner",
+	}
+	if !slices.Equal(events, want) {
+		t.Fatalf("wrong debugger pauses:\nwant: %q\ngot:  %q", want, events)
+	}
+	if want, got := "inner\ninner done\n", cb.String(); got != want {
+		t.Fatalf("wrong output:\nwant: %q\ngot:  %q", want, got)
+	}
+}
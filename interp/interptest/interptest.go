@@ -0,0 +1,176 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package interptest helps test Go programs which run shell code via
+// [interp.Runner], by replacing the external programs they run with fake
+// commands and recording how they were called.
+package interptest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Response describes what a fake command does each time it is run.
+type Response struct {
+	// Stdout and Stderr are written to the command's standard output and
+	// standard error, in that order.
+	Stdout string
+	Stderr string
+
+	// Exit is the command's exit status.
+	Exit uint8
+
+	// Err, if set, is returned by the exec handler instead of an exit status,
+	// stopping the runner like a command which could not be started would.
+	Err error
+
+	// Func, if set, runs after writing Stdout and Stderr, and its result
+	// replaces Exit and Err. Like any [interp.ExecHandlerFunc], it can use
+	// [interp.HandlerCtx] to read the command's input or its environment.
+	Func func(ctx context.Context, args []string) error
+}
+
+// Call is a record of an external command run by the interpreter.
+type Call struct {
+	// Args holds the command's name and arguments.
+	Args []string
+
+	// Dir is the directory the command ran in.
+	Dir string
+
+	// Faked is set if the command was faked via [Commands.Fake].
+	Faked bool
+}
+
+// Commands is a set of fake commands for an [interp.Runner].
+// Install it via [interp.ExecHandlers] with [Commands.ExecHandler].
+//
+// Builtins and shell functions are not affected, as they do not run via the
+// exec handler. The zero value is ready to use, and runs any commands which
+// were not faked as usual. Commands may be used by multiple goroutines at once,
+// such as when a pipeline runs multiple commands concurrently.
+type Commands struct {
+	// Strict makes any command which was not faked fail with exit status 127,
+	// as if it was not installed, rather than running it.
+	Strict bool
+
+	mu    sync.Mutex
+	fakes map[string]*fake
+	calls []Call
+}
+
+type fake struct {
+	responses []Response
+	runs      int
+}
+
+// Fake replaces an external command by its name, such as "git", with a fake
+// one which gives the responses in order, one per run.
+// The last response is repeated once all of them have been used.
+// Without any responses, the command succeeds without any output.
+//
+// Commands run via a path, such as "/usr/bin/git", also match by their base
+// name, unless they were faked separately.
+// Calling Fake again with the same name replaces the fake command.
+func (c *Commands) Fake(name string, responses ...Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fakes == nil {
+		c.fakes = make(map[string]*fake)
+	}
+	c.fakes[name] = &fake{responses: responses}
+}
+
+// Calls returns the external commands run so far, in the order they started.
+func (c *Commands) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.calls)
+}
+
+// CallArgs is like [Commands.Calls], but only returns the name and arguments
+// of each command, which is often enough to check what a program did.
+func (c *Commands) CallArgs() [][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	args := make([][]string, len(c.calls))
+	for i, call := range c.calls {
+		args[i] = call.Args
+	}
+	return args
+}
+
+// Reset forgets the calls recorded so far, and restarts the responses of each
+// fake command from the beginning.
+func (c *Commands) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = nil
+	for _, f := range c.fakes {
+		f.runs = 0
+	}
+}
+
+// ExecHandler is a middleware for [interp.ExecHandlers] which runs the fake
+// commands and records all calls, passing any other commands to next.
+func (c *Commands) ExecHandler(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		hc := interp.HandlerCtx(ctx)
+		resp, faked := c.record(hc.Dir, args)
+		if !faked {
+			if c.Strict {
+				if hc.Stderr != nil {
+					fmt.Fprintf(hc.Stderr, "%s: command not faked\n", args[0])
+				}
+				return interp.NewExitStatus(127)
+			}
+			return next(ctx, args)
+		}
+		if hc.Stdout != nil {
+			io.WriteString(hc.Stdout, resp.Stdout)
+		}
+		if hc.Stderr != nil {
+			io.WriteString(hc.Stderr, resp.Stderr)
+		}
+		switch {
+		case resp.Func != nil:
+			return resp.Func(ctx, args)
+		case resp.Err != nil:
+			return resp.Err
+		case resp.Exit != 0:
+			return interp.NewExitStatus(resp.Exit)
+		}
+		return nil
+	}
+}
+
+// record adds a call, returning the response to give if the command is faked.
+func (c *Commands) record(dir string, args []string) (Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.fakes[args[0]]
+	if f == nil {
+		f = c.fakes[filepath.Base(args[0])]
+	}
+	c.calls = append(c.calls, Call{
+		Args:  slices.Clone(args),
+		Dir:   dir,
+		Faked: f != nil,
+	})
+	if f == nil {
+		return Response{}, false
+	}
+	var resp Response
+	if n := len(f.responses); n > 0 {
+		resp = f.responses[min(f.runs, n-1)]
+	}
+	f.runs++
+	return resp, true
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interptest_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/interp/interptest"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func run(t *testing.T, cmds *interptest.Commands, src string, opts ...interp.RunnerOption) (string, error) {
+	t.Helper()
+	file, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	opts = append([]interp.RunnerOption{
+		interp.StdIO(nil, &out, &out),
+		interp.ExecHandlers(cmds.ExecHandler),
+	}, opts...)
+	r, err := interp.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Run(context.Background(), file)
+	return out.String(), err
+}
+
+func TestCommands(t *testing.T) {
+	t.Parallel()
+
+	var cmds interptest.Commands
+	cmds.Fake("git",
+		interptest.Response{Stdout: "main\n"},
+		interptest.Response{Stderr: "rejected\n", Exit: 1},
+	)
+	cmds.Fake("rev", interptest.Response{Func: func(ctx context.Context, args []string) error {
+		hc := interp.HandlerCtx(ctx)
+		data, err := io.ReadAll(hc.Stdin)
+		if err != nil {
+			return err
+		}
+		runes := []rune(strings.TrimSuffix(string(data), "\n"))
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		fmt.Fprintln(hc.Stdout, string(runes))
+		return nil
+	}})
+	cmds.Fake("true-ish")
+	src := `
+branch=$(git branch --show-current)
+echo "on $branch"
+git push origin "$branch" || echo "push failed: $?"
+git push --force; echo $?
+echo hello | /usr/bin/rev
+true-ish
+`
+	out, err := run(t, &cmds, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "on main\nrejected\npush failed: 1\nrejected\n1\nolleh\n"
+	if out != want {
+		t.Fatalf("wrong output:\nwant: %q\ngot:  %q", want, out)
+	}
+	wantArgs := [][]string{
+		{"git", "branch", "--show-current"},
+		{"git", "push", "origin", "main"},
+		{"git", "push", "--force"},
+		{"/usr/bin/rev"},
+		{"true-ish"},
+	}
+	if got := cmds.CallArgs(); !reflect.DeepEqual(got, wantArgs) {
+		t.Fatalf("wrong calls:\nwant: %q\ngot:  %q", wantArgs, got)
+	}
+
+	// Resetting restarts the responses.
+	cmds.Reset()
+	out, err = run(t, &cmds, "git rev-parse HEAD")
+	if err != nil || out != "main\n" {
+		t.Fatalf("unexpected output after Reset: %q, %v", out, err)
+	}
+	if n := len(cmds.Calls()); n != 1 {
+		t.Fatalf("want 1 call after Reset, got %d", n)
+	}
+}
+
+func TestCommandsErr(t *testing.T) {
+	t.Parallel()
+
+	var cmds interptest.Commands
+	errBroken := errors.New("broken pipe")
+	cmds.Fake("curl", interptest.Response{Err: errBroken})
+	out, err := run(t, &cmds, "curl example.com; echo unreachable")
+	if !errors.Is(err, errBroken) {
+		t.Fatalf("want error %v, got %v", errBroken, err)
+	}
+	if out != "" {
+		t.Fatalf("want no output, got %q", out)
+	}
+}
+
+func TestCommandsStrict(t *testing.T) {
+	t.Parallel()
+
+	// Not faked and not strict, so the next exec handler runs them.
+	var cmds interptest.Commands
+	next := func(next interp.ExecHandlerFunc) interp.ExecHandlerFunc {
+		return func(ctx context.Context, args []string) error {
+			fmt.Fprintf(interp.HandlerCtx(ctx).Stdout, "ran %s\n", args[0])
+			return nil
+		}
+	}
+	out, err := run(t, &cmds, "make all", interp.ExecHandlers(next))
+	if err != nil || out != "ran make\n" {
+		t.Fatalf("unexpected result: %q, %v", out, err)
+	}
+
+	cmds = interptest.Commands{Strict: true}
+	out, err = run(t, &cmds, "make all; echo $?", interp.ExecHandlers(next))
+	if err != nil || out != "make: command not faked\n127\n" {
+		t.Fatalf("unexpected result: %q, %v", out, err)
+	}
+	want := []interptest.Call{{Args: []string{"make", "all"}, Dir: cmds.Calls()[0].Dir}}
+	if got := cmds.Calls(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wrong calls:\nwant: %+v\ngot:  %+v", want, got)
+	}
+}
+
+func ExampleCommands() {
+	src := `
+if git diff --quiet; then
+	echo "clean"
+else
+	echo "dirty"
+fi
+git status --short
+`
+	file, _ := syntax.NewParser().Parse(strings.NewReader(src), "")
+
+	var cmds interptest.Commands
+	cmds.Fake("git",
+		interptest.Response{Exit: 1},
+		interptest.Response{Stdout: " M main.go\n"},
+	)
+	runner, _ := interp.New(
+		interp.StdIO(nil, os.Stdout, os.Stdout),
+		interp.ExecHandlers(cmds.ExecHandler),
+	)
+	runner.Run(context.TODO(), file)
+	for _, args := range cmds.CallArgs() {
+		fmt.Println(args)
+	}
+	// Output:
+	// dirty
+	//  M main.go
+	// [git diff --quiet]
+	// [git status --short]
+}
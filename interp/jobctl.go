@@ -0,0 +1,300 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// JobControl enables job control for an interactive shell whose controlling
+// terminal is tty, like "set -m" in Bash. It only has an effect on Unix-like
+// systems, and it does nothing if tty is nil.
+//
+// Each program run in the foreground starts in a new process group, or in the
+// same one as the rest of its pipeline, which is given the terminal while it
+// runs. If the programs are stopped, such as when the user presses Ctrl-Z,
+// the command returns an exit status of 128 plus the signal number, and the
+// programs become a stopped job which the "fg" and "bg" builtins can resume.
+// The "jobs" builtin lists both stopped jobs and those started via "&".
+//
+// The current process should be the leader of the terminal's foreground
+// process group, and it should catch or ignore SIGTSTP, SIGTTIN, and SIGTTOU
+// to not be stopped itself. The runner ignores SIGTTOU, as it is sent when
+// taking back the terminal from a program.
+func JobControl(tty *os.File) RunnerOption {
+	return func(r *Runner) error {
+		if tty == nil {
+			r.jobControl = nil
+			return nil
+		}
+		jc, err := newJobControl(tty)
+		if err != nil || jc == nil {
+			r.jobControl = nil
+			return err
+		}
+		jc.owner = r
+		r.jobControl = jc
+		return nil
+	}
+}
+
+// jobControl is the state for [JobControl], shared with subshells.
+type jobControl struct {
+	tty       *os.File
+	shellPgid int
+	owner     *Runner // the runner whose job table is used
+
+	mu sync.Mutex
+	// fg is the process group for the programs running in the foreground,
+	// if any.
+	fg *jobGroup
+	// shellModes holds the terminal modes used by the shell, which are
+	// restored once the terminal is taken back from a program.
+	shellModes any
+	// stopped holds the groups which stopped while running in a subshell,
+	// such as in a pipeline, to be added to the owner's job table.
+	stopped []*jobGroup
+	// stmt is the statement being run by the owner, used to show pipelines
+	// as they were typed rather than in the order their programs started.
+	stmt string
+}
+
+// jobGroup is a process group of programs started in the foreground via job
+// control. Once it is stopped, it becomes a job in the runner's job table.
+type jobGroup struct {
+	pgid    int
+	lastPID int      // the program whose exit status is used
+	live    int      // the programs which have not finished yet
+	text    []string // the programs in the group, in the order they started
+
+	job     *bgJob // set once the group stops for the first time
+	stopped bool
+	status  int           // the exit status when stopped, as 128 plus the signal
+	stops   chan struct{} // receives a value when the group stops
+	modes   any           // the terminal modes used by the group
+}
+
+// startForeground starts a program in the foreground process group.
+func (jc *jobControl) startForeground(cmd *exec.Cmd) (*jobGroup, error) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	g := jc.fg
+	if g == nil {
+		g = &jobGroup{stops: make(chan struct{}, 1)}
+		jc.shellModes = getTermModes(jc.tty)
+	}
+	setForegroundGroup(cmd, jc.tty, g.pgid)
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	pid := cmd.Process.Pid
+	if g.pgid == 0 {
+		g.pgid = pid
+	}
+	g.lastPID = pid
+	g.live++
+	g.text = append(g.text, strings.Join(cmd.Args, " "))
+	jc.fg = g
+	return g, nil
+}
+
+// waitForeground waits for a program started via [jobControl.startForeground]
+// to finish or stop. If it stopped, waiting for it carries on in the
+// background, and its group becomes a stopped job.
+func (jc *jobControl) waitForeground(r *Runner, g *jobGroup, cmd *exec.Cmd) procState {
+	pid := cmd.Process.Pid
+	state := waitProcess(pid)
+	jc.report(g, pid, state)
+	if !state.stopped {
+		cmd.Wait() // the process is gone, but its output may still be copied
+		return state
+	}
+	go func() {
+		for {
+			state := waitProcess(pid)
+			jc.report(g, pid, state)
+			if !state.stopped {
+				cmd.Wait()
+				return
+			}
+		}
+	}()
+	if r == jc.owner {
+		r.addStoppedJobs()
+	}
+	return state
+}
+
+// procState is the state of a program after waiting for it, which may have
+// finished or stopped.
+type procState struct {
+	stopped  bool
+	signaled bool
+	status   int // the exit status, or 128 plus the signal number
+}
+
+// report records a change in the state of a program in a group.
+func (jc *jobControl) report(g *jobGroup, pid int, state procState) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+	if state.stopped {
+		if g.stopped {
+			return // another program in the group stopped too
+		}
+		g.stopped = true
+		g.status = state.status
+		if len(g.text) > 1 && jc.stmt != "" {
+			g.text = []string{jc.stmt}
+		}
+		if g.job == nil {
+			g.job = &bgJob{
+				pid:        g.pgid,
+				text:       g.text[0],
+				group:      g,
+				done:       make(chan struct{}),
+				listed:     true,
+				remembered: true,
+			}
+			g.job.cancel = func() { hangUpGroup(g.pgid) }
+			jc.stopped = append(jc.stopped, g)
+		}
+		select {
+		case g.stops <- struct{}{}:
+		default:
+		}
+	} else {
+		g.live--
+		if pid == g.lastPID && g.job != nil {
+			g.job.exit = uint8(state.status)
+		}
+		if g.live == 0 && g.job != nil {
+			g.job.seq = lastJobSeq.Add(1)
+			close(g.job.done)
+		}
+	}
+	if jc.fg == g && (state.stopped || g.live == 0) {
+		// Take back the terminal, as the group is no longer running in
+		// the foreground.
+		jc.fg = nil
+		if state.stopped {
+			g.modes = getTermModes(jc.tty)
+		}
+		setTerminalGroup(jc.tty, jc.shellPgid)
+		setTermModes(jc.tty, jc.shellModes)
+	}
+}
+
+// setStmt records the statement being run by the owner runner.
+func (jc *jobControl) setStmt(st *syntax.Stmt) {
+	var sb strings.Builder
+	syntax.NewPrinter(syntax.SingleLine(true)).Print(&sb, st)
+	jc.mu.Lock()
+	jc.stmt = sb.String()
+	jc.mu.Unlock()
+}
+
+// addStoppedJobs adds the groups which were stopped since the last call to
+// the runner's job table, printing each of them like Bash does.
+func (r *Runner) addStoppedJobs() {
+	jc := r.jobControl
+	if jc == nil || r != jc.owner {
+		return
+	}
+	jc.mu.Lock()
+	stopped := jc.stopped
+	jc.stopped = nil
+	jc.mu.Unlock()
+	for _, g := range stopped {
+		r.addJob(g.job)
+		r.errf("\n%s", r.formatJob(g.job, false))
+	}
+}
+
+// resumeJob continues a stopped job, either in the background or in the
+// foreground, in which case it waits for it to finish or stop again.
+func (r *Runner) resumeJob(ctx context.Context, job *bgJob, foreground bool) int {
+	jc := r.jobControl
+	g := job.group
+	jc.mu.Lock()
+	g.stopped = false
+	select {
+	case <-g.stops:
+	default:
+	}
+	if foreground {
+		jc.shellModes = getTermModes(jc.tty)
+		setTermModes(jc.tty, g.modes)
+		setTerminalGroup(jc.tty, g.pgid)
+		jc.fg = g
+	}
+	jc.mu.Unlock()
+	continueGroup(g.pgid)
+	if !foreground {
+		return 0
+	}
+	select {
+	case <-job.done:
+		job.listed = false
+		return int(job.exit)
+	case <-g.stops:
+		jc.mu.Lock()
+		status := g.status
+		jc.mu.Unlock()
+		r.errf("\n%s", r.formatJob(job, false))
+		return status
+	case <-ctx.Done():
+		return 1
+	}
+}
+
+// jobState returns the state of a job as shown by "jobs".
+func (r *Runner) jobState(job *bgJob) string {
+	select {
+	case <-job.done:
+		if job.exit != 0 {
+			return fmt.Sprintf("Exit %d", job.exit)
+		}
+		return "Done"
+	default:
+	}
+	if g := job.group; g != nil {
+		r.jobControl.mu.Lock()
+		defer r.jobControl.mu.Unlock()
+		if g.stopped {
+			return "Stopped"
+		}
+	}
+	return "Running"
+}
+
+// formatJob formats a job like the "jobs" builtin, such as:
+//
+//	[1]+  Stopped                 sleep 10
+//
+// With long, the process ID is included as well, like "jobs -l".
+func (r *Runner) formatJob(job *bgJob, long bool) string {
+	mark := " "
+	switch job {
+	case r.findJob("%+"):
+		mark = "+"
+	case r.findJob("%-"):
+		mark = "-"
+	}
+	state := r.jobState(job)
+	text := job.text
+	if state == "Running" {
+		text += " &"
+	}
+	if long {
+		return fmt.Sprintf("[%d]%s %d %-24s%s\n", job.id, mark, job.pid, state, text)
+	}
+	return fmt.Sprintf("[%d]%s  %-24s%s\n", job.id, mark, state, text)
+}
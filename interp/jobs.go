@@ -0,0 +1,234 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// bgJob is a background job started via "&" or "coproc",
+// or a group of programs stopped via [JobControl].
+type bgJob struct {
+	id   int    // as in "%1", while listed
+	pid  int    // as in "$!"
+	text string // the command, as shown by "jobs"
+
+	// group is set for jobs which are process groups rather than subshells.
+	group *jobGroup
+
+	cancel context.CancelFunc // stops the job, as with "huponexit"
+
+	done chan struct{}
+	exit uint8 // set before done is closed
+	seq  int64 // the order in which jobs finished, set before done is closed
+
+	// listed is set while the job is in the job table, until "wait -n"
+	// returns its status or "wait" is used without arguments.
+	// remembered is set while its status can still be waited for by process
+	// ID, until "wait" is used without arguments.
+	listed     bool
+	remembered bool
+}
+
+// lastJobPID is used to give each background job and coprocess a unique
+// process ID, as they run within the interpreter rather than as separate
+// processes.
+var lastJobPID atomic.Int64
+
+// lastJobSeq orders background jobs by the time they finished.
+var lastJobSeq atomic.Int64
+
+// bgSubshell is like [Runner.Subshell], but for a background job which keeps
+// running while the shell carries on, so it gets its own copy of the variables
+// rather than reading the shell's.
+func (r *Runner) bgSubshell() *Runner {
+	r2 := r.Subshell()
+	r2.writeEnv.(*overlayEnviron).parent = r.writeEnv.(*overlayEnviron).snapshot()
+	return r2
+}
+
+// goJob runs fn in the background as a new job, where fn runs a statement via
+// r2, a subshell of r, using a context which is cancelled to stop the job.
+// The statement is only given for jobs started via "&", whose output may be
+// redirected by the runner's [JobOutputHandlerFunc].
+func (r *Runner) goJob(ctx context.Context, r2 *Runner, st *syntax.Stmt, fn func(ctx context.Context)) *bgJob {
+	pids := &lastJobPID
+	if r.deterministic {
+		pids = r.jobPIDs
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	job := &bgJob{
+		cancel:     cancel,
+		pid:        int(pids.Add(1)),
+		text:       "coproc",
+		done:       make(chan struct{}),
+		listed:     true,
+		remembered: true,
+	}
+	if st != nil {
+		// Like Bash, the "&" is only shown while the job is running.
+		st2 := *st
+		st2.Background = false
+		var sb strings.Builder
+		syntax.NewPrinter(syntax.SingleLine(true)).Print(&sb, &st2)
+		job.text = sb.String()
+	}
+	r.addJob(job)
+	r2.jobControl = nil // programs in background jobs don't take the terminal
+	r.bgPID = job.pid
+	var flushers []interface{ Flush() error }
+	if r.jobOutputHandler != nil && st != nil {
+		stdout, stderr := r.jobOutputHandler(r2.handlerCtx(ctx), JobInfo{
+			ID:   job.id,
+			PID:  job.pid,
+			Stmt: st,
+		})
+		for _, w := range []io.Writer{stdout, stderr} {
+			if f, ok := w.(interface{ Flush() error }); ok {
+				flushers = append(flushers, f)
+			}
+		}
+		if stdout != nil {
+			r2.stdout = stdout
+		}
+		if stderr != nil {
+			r2.stderr = stderr
+		}
+	}
+	reportDone := r.startJob()
+	r.bgShells.Go(func() error {
+		defer r.logPanic()
+		defer reportDone()
+		defer cancel()
+		fn(ctx)
+		for _, f := range flushers {
+			r.logErr("flushing job output", f.Flush(), "job", job.id)
+		}
+		job.exit = uint8(r2.exit)
+		job.seq = lastJobSeq.Add(1)
+		close(job.done)
+		if r2.exit != 0 {
+			r2.setErr(NewExitStatus(uint8(r2.exit)))
+		}
+		return r2.err
+	})
+	return job
+}
+
+// addJob adds a job to the job table, numbering it after the listed ones.
+func (r *Runner) addJob(job *bgJob) {
+	job.id = 1
+	for _, j := range r.bgJobs {
+		if j.listed {
+			job.id = max(job.id, j.id+1)
+		}
+	}
+	r.bgJobs = append(r.bgJobs, job)
+}
+
+// hangUpJobs stops any background jobs which are still running,
+// like Bash does with the "huponexit" option when the shell exits.
+func (r *Runner) hangUpJobs() {
+	for _, job := range r.bgJobs {
+		job.cancel()
+	}
+}
+
+// findJob finds a listed job by a job specification like "%1", "%%", "%+",
+// or "%-", or any job whose status can be waited for by process ID.
+func (r *Runner) findJob(spec string) *bgJob {
+	if !strings.HasPrefix(spec, "%") {
+		pid, err := strconv.Atoi(spec)
+		if err != nil {
+			return nil
+		}
+		for _, job := range r.bgJobs {
+			if job.remembered && job.pid == pid {
+				return job
+			}
+		}
+		return nil
+	}
+	var listed []*bgJob
+	for _, job := range r.bgJobs {
+		if job.listed {
+			listed = append(listed, job)
+		}
+	}
+	switch spec = spec[1:]; spec {
+	case "", "%", "+":
+		if len(listed) > 0 {
+			return listed[len(listed)-1]
+		}
+	case "-":
+		if len(listed) > 1 {
+			return listed[len(listed)-2]
+		}
+	default:
+		id, err := strconv.Atoi(spec)
+		if err != nil {
+			return nil
+		}
+		for _, job := range listed {
+			if job.id == id {
+				return job
+			}
+		}
+	}
+	return nil
+}
+
+// waitJob waits for any of the given jobs to finish, returning the one which
+// finished first. It returns nil if ctx is cancelled first.
+func (r *Runner) waitJob(ctx context.Context, jobs []*bgJob) *bgJob {
+	var first *bgJob
+	for _, job := range jobs {
+		select {
+		case <-job.done:
+			if first == nil || job.seq < first.seq {
+				first = job
+			}
+		default:
+		}
+	}
+	if first != nil {
+		return first
+	}
+	cases := make([]reflect.SelectCase, len(jobs)+1)
+	for i, job := range jobs {
+		cases[i] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(job.done)}
+	}
+	cases[len(jobs)] = reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())}
+	i, _, _ := reflect.Select(cases)
+	if i == len(jobs) {
+		return nil
+	}
+	return jobs[i]
+}
+
+// JobStatuses returns the exit statuses of the background jobs which have
+// finished since the runner was last reset, keyed by their process IDs as
+// given by "$!". Jobs started via "&" and "coproc" are included, even if the
+// program already waited for them with the "wait" builtin.
+//
+// Background jobs may still be running once [Runner.Run] returns,
+// in which case they are not included.
+func (r *Runner) JobStatuses() map[int]uint8 {
+	statuses := make(map[int]uint8)
+	for _, job := range r.bgJobs {
+		select {
+		case <-job.done:
+			statuses[job.pid] = job.exit
+		default:
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,131 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"mvdan.cc/sh/v3/expand"
+)
+
+// ErrLimitExceeded is wrapped by the errors returned by [Runner.Run] when a
+// program exceeds one of the limits set via [Limits].
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitConfig bounds how much work a [Runner] may do, to stop runaway programs
+// such as "f() { f; }; f" or "while true; do :; done".
+// A zero field means no limit.
+type LimitConfig struct {
+	// FuncDepth is the maximum number of nested function calls.
+	FuncDepth int
+
+	// LoopIterations is the maximum number of iterations of each loop,
+	// counted separately every time a loop starts.
+	LoopIterations int
+
+	// Statements is the maximum number of statements executed by each call
+	// to [Runner.Run], including those in subshells and background jobs.
+	Statements int64
+
+	// VarBytes is the maximum number of bytes used by shell variables,
+	// including arrays, as seen by the shell or any of its subshells.
+	// The memory used is approximated from the length of the names and values
+	// of the variables, and does not include the environment the runner
+	// started with via [Env].
+	VarBytes int64
+
+	// GlobMatches is the maximum number of paths which globbing a pattern
+	// may match, including the directories matched along the way,
+	// as per [expand.Config.GlobMaxMatches].
+	GlobMatches int
+
+	// GlobDepth is the maximum number of directory levels which "**" may match
+	// when the "globstar" option is set, as per [expand.Config.GlobMaxDepth].
+	GlobDepth int
+}
+
+// Limits sets limits on how much work the runner may do. Exceeding a limit
+// stops the runner, making Run return an error wrapping [ErrLimitExceeded].
+//
+// See [Sandbox] and [SandboxLimits] for limits meant for untrusted programs.
+func Limits(cfg LimitConfig) RunnerOption {
+	return func(r *Runner) error {
+		if cfg.FuncDepth < 0 || cfg.LoopIterations < 0 || cfg.Statements < 0 || cfg.VarBytes < 0 ||
+			cfg.GlobMatches < 0 || cfg.GlobDepth < 0 {
+			return fmt.Errorf("limits cannot be negative: %+v", cfg)
+		}
+		r.limits = cfg
+		return nil
+	}
+}
+
+// limitCounts holds the counters for a single call to Run,
+// shared with any subshells.
+type limitCounts struct {
+	statements atomic.Int64
+}
+
+// limitStmt counts a statement, reporting false and stopping the runner if
+// there have been too many.
+func (r *Runner) limitStmt() bool {
+	limit := r.limits.Statements
+	if limit == 0 || r.limitCounts == nil || r.limitCounts.statements.Add(1) <= limit {
+		return true
+	}
+	r.setErr(fmt.Errorf("%w: more than %d statements", ErrLimitExceeded, limit))
+	return false
+}
+
+// limitLoop counts an iteration of a loop, given its number of iterations so
+// far, reporting false and stopping the runner if there have been too many.
+func (r *Runner) limitLoop(iterations *int) bool {
+	*iterations++
+	limit := r.limits.LoopIterations
+	if limit == 0 || *iterations <= limit {
+		return true
+	}
+	r.setErr(fmt.Errorf("%w: more than %d loop iterations", ErrLimitExceeded, limit))
+	return false
+}
+
+// limitFunc reports whether another function call can be made,
+// stopping the runner if it cannot.
+func (r *Runner) limitFunc() bool {
+	limit := r.limits.FuncDepth
+	if limit == 0 || len(r.funcNames) < limit {
+		return true
+	}
+	r.setErr(fmt.Errorf("%w: more than %d nested function calls", ErrLimitExceeded, limit))
+	return false
+}
+
+// limitVars reports whether setting a variable keeps the memory used by the
+// variables within the limit, stopping the runner if it does not.
+func (r *Runner) limitVars(name string, vr expand.Variable) bool {
+	limit := r.limits.VarBytes
+	if limit == 0 {
+		return true
+	}
+	if size, ok := r.varsSize(name, vr); !ok || size <= limit {
+		return true
+	}
+	r.setErr(fmt.Errorf("%w: more than %d bytes of variables", ErrLimitExceeded, limit))
+	return false
+}
+
+// varsSize approximates the memory used by the variables once name is set to
+// vr, as per [overlayEnviron.totalSize]. It reports false if it is unknown.
+func (r *Runner) varsSize(name string, vr expand.Variable) (int64, bool) {
+	env, ok := r.writeEnv.(*overlayEnviron)
+	if !ok {
+		return 0, false
+	}
+	size := env.totalSize() + varSize(name, vr)
+	if prev := r.writeEnv.Get(name); prev.IsSet() {
+		size -= varSize(name, prev)
+	}
+	return size, true
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Locale sets the locale of the runner, such as "C" or "en_US.UTF-8",
+// which affects how strings are sorted and how the case of letters is
+// converted: the order of glob matches, comparisons like "[[ a < b ]]",
+// and expansions like "${var^^}" or variables declared with "declare -u".
+//
+// The default is "C.UTF-8", so that programs behave the same whatever the
+// system, which sorts strings by their bytes like "C" but converts the case of
+// any Unicode letter. The "C" locale only converts the case of ASCII letters.
+// Unlike Bash, the LANG and LC_* variables are not used; to follow the locale
+// of the current process, use something like:
+//
+//	interp.Locale(os.Getenv("LANG"))
+//
+// Only the C and POSIX locales and those using UTF-8 are supported.
+// The collation order of locales other than C.UTF-8 is approximated by comparing
+// strings without regard to case, and then placing lowercase letters first,
+// which is how locales like en_US.UTF-8 sort most words in glibc.
+func Locale(name string) RunnerOption {
+	return func(r *Runner) error {
+		loc, err := parseLocale(name)
+		if err != nil {
+			return err
+		}
+		r.locale = loc
+		return nil
+	}
+}
+
+// locale holds the parts of a locale which the interpreter supports.
+type locale struct {
+	// ascii is set if text is not known to be encoded as UTF-8,
+	// so that only the case of ASCII letters can be converted.
+	ascii bool
+
+	// dictionary is set if strings are sorted ignoring case at first,
+	// rather than by their bytes.
+	dictionary bool
+}
+
+// parseLocale parses a locale name of the form "language_TERRITORY.codeset@modifier",
+// such as "en_US.UTF-8", where only the language is required.
+func parseLocale(name string) (locale, error) {
+	if name == "" {
+		return locale{}, nil // C.UTF-8
+	}
+	lang, _, _ := strings.Cut(name, "@")
+	lang, codeset, hasCodeset := strings.Cut(lang, ".")
+	if hasCodeset {
+		switch strings.ToLower(codeset) {
+		case "utf-8", "utf8":
+		default:
+			return locale{}, fmt.Errorf("unsupported locale: %q", name)
+		}
+	}
+	return locale{
+		ascii:      !hasCodeset,
+		dictionary: lang != "C" && lang != "POSIX",
+	}, nil
+}
+
+// compare compares two strings as per the locale's collation order.
+func (l locale) compare(a, b string) int {
+	if !l.dictionary {
+		return strings.Compare(a, b)
+	}
+	if c := compareFold(a, b); c != 0 {
+		return c
+	}
+	// Strings which only differ in case have lowercase letters first,
+	// which come after uppercase ones in Unicode.
+	return strings.Compare(b, a)
+}
+
+// compareFold compares two strings without regard to case.
+func compareFold(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if ra, rb := unicode.ToLower(ra), unicode.ToLower(rb); ra != rb {
+			if ra < rb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[na:], b[nb:]
+	}
+	return len(a) - len(b)
+}
+
+func (l locale) toUpper(r rune) rune {
+	if !l.ascii || r < utf8.RuneSelf {
+		return unicode.ToUpper(r)
+	}
+	return r
+}
+
+func (l locale) toLower(r rune) rune {
+	if !l.ascii || r < utf8.RuneSelf {
+		return unicode.ToLower(r)
+	}
+	return r
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Logger sets a logger for diagnostics about what the runner does internally,
+// which would otherwise be dropped silently, such as:
+//
+//   - warnings about unsupported features which are ignored, like the
+//     redirection "cmd >&file", or closing one of the standard streams;
+//   - errors which are ignored, like failing to close a file or to signal
+//     a process which is being stopped;
+//   - panics in handlers or in the interpreter, which are logged with a stack
+//     trace before the panic continues.
+//
+// The output of the shell program, including its error messages, is not logged.
+// The default is nil, which disables logging.
+func Logger(l *slog.Logger) RunnerOption {
+	return func(r *Runner) error {
+		r.logger = l
+		return nil
+	}
+}
+
+// logWarn logs a warning about an unsupported feature, if there is a logger.
+func (r *Runner) logWarn(msg string, args ...any) {
+	if r.logger != nil {
+		r.logger.Warn(msg, args...)
+	}
+}
+
+// diagnose sends a warning about a node of the program to the diagnostic
+// handler, if any. The node may be nil if it is not known.
+func (r *Runner) diagnose(ctx context.Context, node syntax.Node, code, format string, a ...any) {
+	if r.diagnosticHandler == nil {
+		return
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	d := syntax.Diagnostic{
+		Filename: r.filename,
+		Severity: syntax.SeverityWarning,
+		Code:     code,
+		Message:  fmt.Sprintf(format, a...),
+	}
+	if node != nil {
+		d.Pos, d.End = node.Pos(), node.End()
+	}
+	r.diagnosticHandler(r.handlerCtx(ctx), d)
+}
+
+// logErr logs an error which is ignored, if there is a logger and err is not nil.
+func (r *Runner) logErr(msg string, err error, args ...any) {
+	if r.logger != nil && err != nil {
+		r.logger.Warn(msg, append([]any{"err", err}, args...)...)
+	}
+}
+
+// logPanic logs any panic in the current goroutine with a stack trace,
+// and then continues panicking. It must be called via defer.
+func (r *Runner) logPanic() {
+	if r.logger == nil {
+		return
+	}
+	if p := recover(); p != nil {
+		r.logger.Error("panic while running shell code", "panic", p, "stack", string(debug.Stack()))
+		panic(p)
+	}
+}
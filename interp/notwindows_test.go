@@ -0,0 +1,10 @@
+// Copyright (c) 2019, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build !windows
+
+package interp_test
+
+func shortPathName(path string) (string, error) {
+	panic("only works on windows")
+}
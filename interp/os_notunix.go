@@ -7,13 +7,67 @@ package interp
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
+	"time"
 )
 
-func mkfifo(path string, mode uint32) error {
-	return fmt.Errorf("unsupported")
-}
-
 // hasPermissionToDir is a no-op on Windows.
 func hasPermissionToDir(string) bool {
 	return true
 }
+
+// hasInput always returns true on Windows.
+func hasInput(*os.File) bool {
+	return true
+}
+
+// setProcessGroup is a no-op on Windows.
+func setProcessGroup(*exec.Cmd) {}
+
+// signalProcess ignores process groups on Windows.
+func signalProcess(proc *os.Process, sig os.Signal, group bool) error {
+	return proc.Signal(sig)
+}
+
+func noEcho(*os.File) (restore func(), _ error) {
+	return nil, fmt.Errorf("unsupported")
+}
+
+// newJobControl returns nil on Windows, as job control is not supported.
+func newJobControl(*os.File) (*jobControl, error) {
+	return nil, nil
+}
+
+func setForegroundGroup(*exec.Cmd, *os.File, int) {}
+func setTerminalGroup(*os.File, int)              {}
+func getTermModes(*os.File) any                   { return nil }
+func setTermModes(*os.File, any)                  {}
+func continueGroup(int)                           {}
+func hangUpGroup(int)                             {}
+
+func waitProcess(int) procState { return procState{status: 1} }
+
+// processUmask always returns the usual default umask on Windows.
+func processUmask() os.FileMode {
+	return 0o022
+}
+
+// rlimitResource always returns false on Windows,
+// so that ulimit is a no-op.
+func rlimitResource(flag byte) (resource int, ok bool) {
+	return 0, false
+}
+
+func getRlimit(resource int, hard bool) (uint64, error) {
+	return rlimInfinity, nil
+}
+
+func setRlimit(resource int, hard, soft bool, limit uint64) error {
+	return nil
+}
+
+// processTimes always returns zero durations on Windows.
+func processTimes() (user, sys, childUser, childSys time.Duration) {
+	return 0, 0, 0, 0
+}
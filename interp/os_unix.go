@@ -6,15 +6,256 @@
 package interp
 
 import (
+	"bufio"
+	"bytes"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
 	"golang.org/x/sys/unix"
 )
 
-func mkfifo(path string, mode uint32) error {
-	return unix.Mkfifo(path, mode)
-}
-
 // hasPermissionToDir returns true if the OS current user has execute
 // permission to the given directory
 func hasPermissionToDir(path string) bool {
 	return unix.Access(path, unix.X_OK) == nil
 }
+
+// hasInput reports whether reading from a file would not block,
+// either because there is input ready or because it reached EOF.
+func hasInput(f *os.File) bool {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return true
+	}
+	ready := true
+	rc.Control(func(fd uintptr) {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, 0)
+		ready = err != nil || n > 0
+	})
+	return ready
+}
+
+// setProcessGroup makes a command start in a new process group,
+// unless it starts a new session, which already implies a new group.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if !cmd.SysProcAttr.Setsid {
+		cmd.SysProcAttr.Setpgid = true
+	}
+}
+
+// signalProcess sends a signal to a process, or to its entire process group
+// if it leads one.
+func signalProcess(proc *os.Process, sig os.Signal, group bool) error {
+	if s, ok := sig.(syscall.Signal); ok && group {
+		return unix.Kill(-proc.Pid, s)
+	}
+	return proc.Signal(sig)
+}
+
+// noEcho stops a terminal from echoing its input,
+// returning a func to restore its previous state.
+func noEcho(f *os.File) (restore func(), _ error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var old *unix.Termios
+	ctrlErr := rc.Control(func(fd uintptr) {
+		if old, err = unix.IoctlGetTermios(int(fd), ioctlReadTermios); err != nil {
+			return
+		}
+		state := *old
+		state.Lflag &^= unix.ECHO
+		err = unix.IoctlSetTermios(int(fd), ioctlWriteTermios, &state)
+	})
+	if err == nil {
+		err = ctrlErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		rc.Control(func(fd uintptr) {
+			unix.IoctlSetTermios(int(fd), ioctlWriteTermios, old)
+		})
+	}, nil
+}
+
+// newJobControl prepares to take back the terminal from programs run via
+// [JobControl], which sends SIGTTOU to the shell as it is not in the
+// foreground process group at that point.
+func newJobControl(tty *os.File) (*jobControl, error) {
+	signal.Ignore(syscall.SIGTTOU)
+	return &jobControl{tty: tty, shellPgid: unix.Getpgrp()}, nil
+}
+
+// setForegroundGroup makes a command join the process group pgid,
+// or start a new one which is given the terminal if pgid is zero.
+func setForegroundGroup(cmd *exec.Cmd, tty *os.File, pgid int) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	if pgid == 0 {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(tty.Fd())
+	} else {
+		cmd.SysProcAttr.Pgid = pgid
+	}
+}
+
+// setTerminalGroup makes a process group the terminal's foreground group.
+func setTerminalGroup(tty *os.File, pgid int) {
+	unix.IoctlSetPointerInt(int(tty.Fd()), unix.TIOCSPGRP, pgid)
+}
+
+// getTermModes returns the current modes of a terminal,
+// to be restored later via [setTermModes].
+func getTermModes(tty *os.File) any {
+	modes, err := unix.IoctlGetTermios(int(tty.Fd()), ioctlReadTermios)
+	if err != nil {
+		return nil
+	}
+	return modes
+}
+
+func setTermModes(tty *os.File, modes any) {
+	if modes, ok := modes.(*unix.Termios); ok {
+		unix.IoctlSetTermios(int(tty.Fd()), ioctlWriteTermios, modes)
+	}
+}
+
+// waitProcess waits for a child process to finish or stop.
+// Unlike [os.Process.Wait], it reports when a process is stopped.
+func waitProcess(pid int) procState {
+	var status unix.WaitStatus
+	for {
+		_, err := unix.Wait4(pid, &status, unix.WUNTRACED, nil)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return procState{status: 1}
+		}
+		break
+	}
+	switch {
+	case status.Stopped():
+		return procState{stopped: true, status: 128 + int(status.StopSignal())}
+	case status.Signaled():
+		return procState{signaled: true, status: 128 + int(status.Signal())}
+	}
+	return procState{status: status.ExitStatus()}
+}
+
+// continueGroup resumes a stopped process group.
+func continueGroup(pgid int) {
+	unix.Kill(-pgid, unix.SIGCONT)
+}
+
+// hangUpGroup stops a process group like Bash does with a stopped job when
+// the shell exits, sending SIGCONT as well in case it is stopped.
+func hangUpGroup(pgid int) {
+	unix.Kill(-pgid, unix.SIGHUP)
+	unix.Kill(-pgid, unix.SIGCONT)
+}
+
+// processUmask returns the file mode creation mask of the current process,
+// which is the initial umask for each [Runner].
+var processUmask = sync.OnceValue(func() os.FileMode {
+	// Linux can tell us the umask without us having to modify it,
+	// which could race with other goroutines creating files.
+	if data, err := os.ReadFile("/proc/self/status"); err == nil {
+		sc := bufio.NewScanner(bytes.NewReader(data))
+		for sc.Scan() {
+			if val, ok := bytes.CutPrefix(sc.Bytes(), []byte("Umask:")); ok {
+				if n, err := strconv.ParseUint(string(bytes.TrimSpace(val)), 8, 32); err == nil {
+					return os.FileMode(n)
+				}
+			}
+		}
+	}
+	mask := unix.Umask(0o022)
+	unix.Umask(mask)
+	return os.FileMode(mask)
+})
+
+// rlimitResource returns the resource for a ulimit flag such as 'n',
+// if the platform supports it.
+func rlimitResource(flag byte) (resource int, ok bool) {
+	switch flag {
+	case 'c':
+		return unix.RLIMIT_CORE, true
+	case 'd':
+		return unix.RLIMIT_DATA, true
+	case 'f':
+		return unix.RLIMIT_FSIZE, true
+	case 'n':
+		return unix.RLIMIT_NOFILE, true
+	case 's':
+		return unix.RLIMIT_STACK, true
+	case 't':
+		return unix.RLIMIT_CPU, true
+	}
+	return 0, false
+}
+
+// getRlimit returns the soft or hard limit for a resource,
+// where rlimInfinity means that there is no limit.
+func getRlimit(resource int, hard bool) (uint64, error) {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(resource, &rlim); err != nil {
+		return 0, err
+	}
+	limit := uint64(rlim.Cur)
+	if hard {
+		limit = uint64(rlim.Max)
+	}
+	if limit == uint64(unix.RLIM_INFINITY) {
+		return rlimInfinity, nil
+	}
+	return limit, nil
+}
+
+// setRlimit sets the soft limit, the hard limit, or both for a resource.
+func setRlimit(resource int, hard, soft bool, limit uint64) error {
+	var rlim unix.Rlimit
+	if err := unix.Getrlimit(resource, &rlim); err != nil {
+		return err
+	}
+	if limit == rlimInfinity {
+		limit = uint64(unix.RLIM_INFINITY)
+	}
+	if hard {
+		setRlimitValue(&rlim.Max, limit)
+	}
+	if soft {
+		setRlimitValue(&rlim.Cur, limit)
+	}
+	return unix.Setrlimit(resource, &rlim)
+}
+
+// setRlimitValue exists as the fields in [unix.Rlimit] are signed on some
+// platforms like FreeBSD.
+func setRlimitValue[T int64 | uint64](dst *T, limit uint64) {
+	*dst = T(limit)
+}
+
+// processTimes returns the user and system CPU times used by the current
+// process and by its terminated child processes.
+func processTimes() (user, sys, childUser, childSys time.Duration) {
+	var self, children unix.Rusage
+	unix.Getrusage(unix.RUSAGE_SELF, &self)
+	unix.Getrusage(unix.RUSAGE_CHILDREN, &children)
+	return time.Duration(self.Utime.Nano()), time.Duration(self.Stime.Nano()),
+		time.Duration(children.Utime.Nano()), time.Duration(children.Stime.Nano())
+}
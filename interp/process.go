@@ -0,0 +1,128 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// Process is a program started by a [ProcessStarter], which may be an
+// operating system process, a WebAssembly module, or a Go function.
+type Process interface {
+	// Wait waits for the program to finish, returning its exit status.
+	// An error means that the program could not be waited for,
+	// which stops the runner.
+	Wait() (exit uint8, _ error)
+
+	// Signal asks the program to stop, such as with [os.Interrupt],
+	// or forces it to stop with [os.Kill].
+	Signal(sig os.Signal) error
+}
+
+// ProcessAttr holds what a [ProcessStarter] needs to start a program.
+type ProcessAttr struct {
+	// Args holds the program's name, as given to the shell, and its arguments.
+	Args []string
+
+	// Env holds the exported variables, in the form "key=value".
+	Env []string
+
+	// Dir is the runner's current directory.
+	Dir string
+
+	Stdin  io.Reader // nil if the runner has no standard input
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// ProcessStarter starts the programs run via [ProcessExecHandler].
+type ProcessStarter interface {
+	// Start starts a program. It returns an error wrapping [fs.ErrNotExist]
+	// if there is no such program.
+	Start(ctx context.Context, attr ProcessAttr) (Process, error)
+}
+
+// ProcessStarterFunc is a func implementing [ProcessStarter].
+type ProcessStarterFunc func(ctx context.Context, attr ProcessAttr) (Process, error)
+
+// Start calls f(ctx, attr).
+func (f ProcessStarterFunc) Start(ctx context.Context, attr ProcessAttr) (Process, error) {
+	return f(ctx, attr)
+}
+
+// ProcessExecHandler returns an [ExecHandlerFunc] which runs programs via
+// starter rather than via [os/exec] like [DefaultExecHandler] does.
+// This allows running programs where there are no processes, such as on
+// js/wasm or wasip1, where the default handler cannot run any programs.
+//
+// Programs which do not exist make the command fail with exit status 127,
+// like [DefaultExecHandler], and programs which cannot be started make it
+// fail with exit status 126.
+//
+// When the context is cancelled, the program is sent [os.Interrupt],
+// followed by [os.Kill] once killTimeout has elapsed.
+// If killTimeout is zero or negative, [os.Kill] is sent right away.
+func ProcessExecHandler(starter ProcessStarter, killTimeout time.Duration) ExecHandlerFunc {
+	return func(ctx context.Context, args []string) error {
+		hc := HandlerCtx(ctx)
+		proc, err := starter.Start(ctx, ProcessAttr{
+			Args:   args,
+			Env:    execEnv(hc.Env),
+			Dir:    hc.Dir,
+			Stdin:  hc.Stdin,
+			Stdout: hc.Stdout,
+			Stderr: hc.Stderr,
+		})
+		if errors.Is(err, fs.ErrNotExist) {
+			if hc.runner != nil {
+				if err, ok := hc.runner.commandNotFound(ctx, args); ok {
+					return err
+				}
+			}
+			fmt.Fprintf(hc.Stderr, "%q: executable file not found\n", args[0])
+			return NewExitStatus(127)
+		}
+		if err != nil {
+			fmt.Fprintf(hc.Stderr, "%v\n", err)
+			return NewExitStatus(126)
+		}
+		if done := ctx.Done(); done != nil {
+			finished := make(chan struct{})
+			defer close(finished)
+			go func() {
+				select {
+				case <-done:
+				case <-finished:
+					return
+				}
+				if killTimeout <= 0 {
+					proc.Signal(os.Kill)
+					return
+				}
+				proc.Signal(os.Interrupt)
+				select {
+				case <-time.After(killTimeout):
+					proc.Signal(os.Kill)
+				case <-finished:
+				}
+			}()
+		}
+		exit, err := proc.Wait()
+		switch {
+		case err != nil:
+			return err
+		case exit > 128 && ctx.Err() != nil:
+			return ctx.Err()
+		case exit != 0:
+			return NewExitStatus(exit)
+		}
+		return nil
+	}
+}
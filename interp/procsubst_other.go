@@ -0,0 +1,24 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build !unix && !windows
+
+package interp
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime"
+)
+
+// procSubstPipe is not supported on systems without named pipes.
+type procSubstPipe struct{ path string }
+
+func newProcSubstPipe(rnd *rand.Rand, write bool) (*procSubstPipe, error) {
+	return nil, fmt.Errorf("process substitution is not supported on %s", runtime.GOOS)
+}
+
+func (p *procSubstPipe) open() (*os.File, error) { panic("unreachable") }
+
+func (p *procSubstPipe) close(f *os.File) error { panic("unreachable") }
@@ -0,0 +1,65 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build unix
+
+package interp
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// procSubstPipe is the named pipe behind the path given by a process
+// substitution like "<(cmd)", which is a temporary FIFO on Unix-like systems.
+type procSubstPipe struct {
+	path  string
+	write bool // whether the shell writes to the pipe, as in "<(cmd)"
+}
+
+func newProcSubstPipe(rnd *rand.Rand, write bool) (*procSubstPipe, error) {
+	dir := os.TempDir()
+
+	// We can't atomically create a random unused temporary FIFO.
+	// Similar to os.CreateTemp,
+	// keep trying new random paths until one does not exist.
+	// We use a uint64 because a uint32 easily runs into retries.
+	try := 0
+	for {
+		path := fmt.Sprintf("%s/sh-interp-%x", dir, rnd.Uint64())
+		err := unix.Mkfifo(path, 0o666)
+		if err == nil {
+			return &procSubstPipe{path: path, write: write}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("cannot create fifo: %v", err)
+		}
+		if try++; try > 100 {
+			return nil, fmt.Errorf("giving up at creating fifo: %v", err)
+		}
+	}
+}
+
+// open waits for the other end of the pipe to be opened, returning the
+// shell's end.
+func (p *procSubstPipe) open() (*os.File, error) {
+	flag := os.O_RDONLY
+	if p.write {
+		flag = os.O_WRONLY
+	}
+	return os.OpenFile(p.path, flag, 0)
+}
+
+// close closes the shell's end of the pipe, if it was opened,
+// and removes the pipe.
+func (p *procSubstPipe) close(f *os.File) error {
+	var err error
+	if f != nil {
+		err = f.Close()
+	}
+	os.Remove(p.path)
+	return err
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// procSubstPipe is the named pipe behind the path given by a process
+// substitution like "<(cmd)", which lives under `\\.\pipe\` on Windows.
+// The pipe is gone once both ends are closed, so no cleanup is needed.
+type procSubstPipe struct {
+	path   string
+	write  bool // whether the shell writes to the pipe, as in "<(cmd)"
+	handle windows.Handle
+}
+
+func newProcSubstPipe(rnd *rand.Rand, write bool) (*procSubstPipe, error) {
+	access := uint32(windows.PIPE_ACCESS_INBOUND)
+	if write {
+		access = windows.PIPE_ACCESS_OUTBOUND
+	}
+	// Like with FIFOs on Unix-like systems, keep trying new random names
+	// until we create a pipe which did not exist.
+	try := 0
+	for {
+		path := fmt.Sprintf(`\\.\pipe\sh-interp-%x`, rnd.Uint64())
+		name, err := windows.UTF16PtrFromString(path)
+		if err != nil {
+			return nil, err
+		}
+		handle, err := windows.CreateNamedPipe(name,
+			access|windows.FILE_FLAG_FIRST_PIPE_INSTANCE,
+			windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+			1, 64<<10, 64<<10, 0, nil)
+		if err == nil {
+			return &procSubstPipe{path: path, write: write, handle: handle}, nil
+		}
+		// With FILE_FLAG_FIRST_PIPE_INSTANCE, an existing pipe results in
+		// an access denied error.
+		if !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			return nil, fmt.Errorf("cannot create named pipe: %v", err)
+		}
+		if try++; try > 100 {
+			return nil, fmt.Errorf("giving up at creating named pipe: %v", err)
+		}
+	}
+}
+
+// open waits for the other end of the pipe to be opened, returning the
+// shell's end.
+func (p *procSubstPipe) open() (*os.File, error) {
+	err := windows.ConnectNamedPipe(p.handle, nil)
+	// The other end may have opened the pipe before we started waiting.
+	if err != nil && !errors.Is(err, windows.ERROR_PIPE_CONNECTED) {
+		return nil, err
+	}
+	return os.NewFile(uintptr(p.handle), p.path), nil
+}
+
+// close closes the shell's end of the pipe, which must have been returned
+// by open if it is not nil.
+func (p *procSubstPipe) close(f *os.File) error {
+	if f == nil {
+		return windows.CloseHandle(p.handle)
+	}
+	if p.write {
+		// Unlike with FIFOs, closing our end may discard any data which
+		// the other end has not read yet.
+		windows.FlushFileBuffers(p.handle)
+	}
+	return f.Close()
+}
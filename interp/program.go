@@ -0,0 +1,198 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/pattern"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Program is a shell program prepared via [Load] to be run many times,
+// such as a hook script which is run for every event.
+//
+// A Program is never modified once loaded,
+// so it may be run by many runners concurrently.
+type Program struct {
+	file *syntax.File
+
+	// patterns holds the case patterns which do not depend on the shell's
+	// state, compiled with and without the "extglob" option.
+	// A nil matcher means that the pattern is invalid and matches nothing.
+	patterns map[programPattern]*pattern.Matcher
+
+	// arithms holds the results of the arithmetic expressions which
+	// only use numbers, such as "1 << 4".
+	arithms map[syntax.ArithmExpr]int
+}
+
+type programPattern struct {
+	word    *syntax.Word
+	extGlob bool
+}
+
+// Load prepares a parsed program to be run via [Program.Run].
+// Work which does not depend on the shell's state is done once here rather
+// than on every run, such as compiling the patterns in case clauses which
+// are made up of literal strings, and evaluating the arithmetic expressions
+// which only use numbers.
+//
+// Load fails if the program contains nodes which a [Runner] cannot run,
+// such as the test declarations parsed with [syntax.LangBats].
+// The file must not be modified once loaded.
+func Load(file *syntax.File) (*Program, error) {
+	if file == nil {
+		return nil, fmt.Errorf("cannot load a nil file")
+	}
+	prog := &Program{
+		file:     file,
+		patterns: make(map[programPattern]*pattern.Matcher),
+		arithms:  make(map[syntax.ArithmExpr]int),
+	}
+	var err error
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if err != nil {
+			return false
+		}
+		switch node := node.(type) {
+		case *syntax.TestDecl:
+			err = fmt.Errorf("%s: cannot run test declarations", node.Pos())
+		case *syntax.CaseClause:
+			for _, ci := range node.Items {
+				for _, word := range ci.Patterns {
+					prog.loadPattern(word)
+				}
+			}
+		case *syntax.ArithmCmd:
+			prog.loadArithm(node.X)
+		case *syntax.LetClause:
+			for _, expr := range node.Exprs {
+				prog.loadArithm(expr)
+			}
+		case *syntax.CStyleLoop:
+			prog.loadArithm(node.Init)
+			prog.loadArithm(node.Cond)
+			prog.loadArithm(node.Post)
+		case *syntax.ArrayElem:
+			prog.loadArithm(node.Index)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// File returns the program given to [Load].
+func (p *Program) File() *syntax.File { return p.file }
+
+// Run runs the program with a runner, like [Runner.Run] does with the
+// program's file. A fresh runner may be used for each run, or a runner may be
+// reused via [Runner.Reset].
+func (p *Program) Run(ctx context.Context, r *Runner) error {
+	if !r.didReset {
+		r.Reset()
+	}
+	r.program = p
+	defer func() { r.program = nil }()
+	return r.Run(ctx, p.file)
+}
+
+// matchCasePattern reports whether str matches a pattern in a case clause,
+// using the pattern compiled by [Load] if there is one.
+func (r *Runner) matchCasePattern(word *syntax.Word, str string) bool {
+	extGlob := r.opts[optExtGlob]
+	// Trace hooks observe each expansion, so the pattern must be expanded.
+	if r.program != nil && len(r.traceHooks) == 0 {
+		if m, ok := r.program.patterns[programPattern{word, extGlob}]; ok {
+			return m != nil && m.Match(str)
+		}
+	}
+	return r.match(r.pattern(word), str, extGlob)
+}
+
+func (p *Program) loadPattern(word *syntax.Word) {
+	if !staticWord(word) {
+		return
+	}
+	for _, extGlob := range []bool{false, true} {
+		str, err := expand.Pattern(&expand.Config{ExtGlob: extGlob}, word)
+		if err != nil {
+			continue // left to the runner, which reports the error
+		}
+		mode := pattern.EntireString
+		if extGlob {
+			mode |= pattern.ExtendedOperators
+		}
+		m, _ := pattern.Compile(str, mode)
+		p.patterns[programPattern{word, extGlob}] = m
+	}
+}
+
+// staticWord reports whether a word always expands to the same string,
+// as it consists of literal strings alone.
+func staticWord(word *syntax.Word) bool {
+	for i, part := range word.Parts {
+		switch part := part.(type) {
+		case *syntax.Lit:
+			if i == 0 && strings.HasPrefix(part.Value, "~") {
+				return false // tilde expansion depends on $HOME
+			}
+		case *syntax.SglQuoted, *syntax.ExtGlob:
+		case *syntax.DblQuoted:
+			for _, part := range part.Parts {
+				if _, ok := part.(*syntax.Lit); !ok {
+					return false
+				}
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func (p *Program) loadArithm(expr syntax.ArithmExpr) {
+	if expr == nil || !staticArithm(expr) {
+		return
+	}
+	// Evaluating with checks for overflows means that the result
+	// is the same whether or not a runner uses CheckedArithm.
+	n, err := expand.Arithm(&expand.Config{CheckedArithm: true}, expr)
+	if err != nil {
+		return // left to the runner, which reports the error
+	}
+	p.arithms[expr] = n
+}
+
+// staticArithm reports whether an arithmetic expression only uses numbers,
+// so that it always evaluates to the same result.
+func staticArithm(expr syntax.ArithmExpr) bool {
+	switch expr := expr.(type) {
+	case *syntax.BinaryArithm:
+		return staticArithm(expr.X) && staticArithm(expr.Y)
+	case *syntax.UnaryArithm:
+		return staticArithm(expr.X)
+	case *syntax.ParenArithm:
+		return staticArithm(expr.X)
+	case *syntax.Word:
+		// An integer constant like "12", "0x1f", or "36#zz".
+		s := expr.Lit()
+		if len(expr.Parts) != 1 || s == "" || s[0] < '0' || s[0] > '9' {
+			return false
+		}
+		for _, c := range s {
+			if !syntax.ValidName(string(c)) && !(c >= '0' && c <= '9') && c != '@' && c != '#' {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
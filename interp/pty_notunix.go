@@ -0,0 +1,22 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build !unix
+
+package interp
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+type ptySession struct{}
+
+func openPTY(*exec.Cmd, HandlerContext) (*ptySession, error) {
+	return nil, fmt.Errorf("pseudo-terminals are not supported on this platform")
+}
+
+func (*ptySession) started(*os.Process) {}
+
+func (*ptySession) close() {}
@@ -0,0 +1,177 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+//go:build unix
+
+package interp
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/muesli/cancelreader"
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// ptyDrainTimeout is how long to keep copying a terminal's output once its
+// program has exited, in case a background process still holds the terminal.
+const ptyDrainTimeout = 100 * time.Millisecond
+
+// ptySession is a pseudo-terminal attached to a single program
+// run via [PseudoTerminal].
+type ptySession struct {
+	primary, secondary *os.File
+
+	stdin  io.Reader
+	stdout io.Writer
+
+	// term is the runner's standard input when it is a terminal.
+	term *os.File
+
+	// forward is set when signals should be forwarded to the program,
+	// as the runner does not handle them via [Signals].
+	forward bool
+
+	restore func() // restores term's state, if needed
+
+	// input wraps stdin when it is a file, so that copying it can be stopped.
+	input     cancelreader.CancelReader
+	inputDone chan struct{}
+
+	copied chan struct{} // closed once all output is copied
+	sigs   chan os.Signal
+	wg     sync.WaitGroup // for the signal forwarding goroutine
+}
+
+func openPTY(cmd *exec.Cmd, hc HandlerContext) (*ptySession, error) {
+	primary, secondary, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	s := &ptySession{
+		primary:   primary,
+		secondary: secondary,
+		stdin:     hc.Stdin,
+		stdout:    hc.Stdout,
+		forward:   hc.runner.signalCfg == nil,
+	}
+	if f, ok := hc.Stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		s.term = f
+		// Not all terminals support window sizes; ignore errors.
+		_ = pty.InheritSize(f, primary)
+	}
+	cmd.Stdin = secondary
+	cmd.Stdout = secondary
+	cmd.Stderr = hc.Stderr
+	if hc.Stderr == hc.Stdout {
+		cmd.Stderr = secondary
+	}
+	// Like a shell's job control, give the program its own session,
+	// with the pseudo-terminal as its controlling terminal.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	return s, nil
+}
+
+// started connects the terminal to the runner once the program has started.
+func (s *ptySession) started(proc *os.Process) {
+	// Only the program should hold the secondary end, so that reading from
+	// the primary end stops once the program exits.
+	s.secondary.Close()
+	s.secondary = nil
+
+	if s.term != nil {
+		if state, err := term.MakeRaw(int(s.term.Fd())); err == nil {
+			s.restore = func() { term.Restore(int(s.term.Fd()), state) }
+		}
+	}
+
+	s.copied = make(chan struct{})
+	go func() {
+		if s.stdout != nil {
+			io.Copy(s.stdout, s.primary)
+		} else {
+			io.Copy(io.Discard, s.primary)
+		}
+		close(s.copied)
+	}()
+
+	if s.stdin != nil {
+		in := s.stdin
+		if f, ok := in.(*os.File); ok {
+			// Don't leave a goroutine behind which could steal the input
+			// meant for the commands which follow.
+			if cr, err := cancelreader.NewReader(f); err == nil {
+				s.input = cr
+				in = cr
+			}
+		}
+		s.inputDone = make(chan struct{})
+		go func() {
+			defer close(s.inputDone)
+			if _, err := io.Copy(s.primary, in); err == nil {
+				// Mimic typing Ctrl-D, so that the program sees the
+				// end of its input.
+				s.primary.Write([]byte{4})
+			}
+		}()
+	}
+
+	s.sigs = make(chan os.Signal, 1)
+	if s.forward {
+		signal.Notify(s.sigs, unix.SIGWINCH, unix.SIGINT, unix.SIGTERM, unix.SIGHUP, unix.SIGQUIT)
+	} else {
+		signal.Notify(s.sigs, unix.SIGWINCH)
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for sig := range s.sigs {
+			if sig == unix.SIGWINCH {
+				if s.term != nil {
+					_ = pty.InheritSize(s.term, s.primary)
+				}
+				continue
+			}
+			// The program leads its own process group.
+			_ = unix.Kill(-proc.Pid, sig.(syscall.Signal))
+		}
+	}()
+}
+
+// close waits for the terminal's output to be copied and releases it,
+// restoring the state of the runner's terminal.
+func (s *ptySession) close() {
+	if s.sigs != nil {
+		signal.Stop(s.sigs)
+		close(s.sigs)
+		s.wg.Wait()
+	}
+	if s.copied != nil {
+		select {
+		case <-s.copied:
+		case <-time.After(ptyDrainTimeout):
+		}
+	}
+	if s.input != nil {
+		s.input.Cancel()
+		<-s.inputDone
+		s.input.Close()
+	}
+	if s.secondary != nil {
+		s.secondary.Close()
+	}
+	s.primary.Close()
+	if s.copied != nil {
+		<-s.copied
+	}
+	if s.restore != nil {
+		s.restore()
+	}
+}
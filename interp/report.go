@@ -0,0 +1,304 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// RunReport collects a summary of what a [Runner] did, such as the exit status
+// and duration of each statement and the commands it executed, which can be
+// useful to collect telemetry in CI systems without parsing trace output.
+//
+// Use [Report] to collect a report from a runner, including any subshells and
+// background jobs it starts. Like with [Coverage], statements in files are
+// identified by their file name and position, so running the same file
+// multiple times adds to the same results.
+//
+// The zero value is ready to use, and a RunReport is safe for concurrent use.
+type RunReport struct {
+	mu sync.Mutex
+
+	files []string // in the order they were first added
+	byPos map[reportKey]*StmtResult
+	nodes map[*syntax.Stmt]*StmtResult
+
+	commands []CommandResult
+	written  [3]int64
+
+	jobs, peakJobs int
+}
+
+// reportKey is like coverKey, but also using the end position, since a
+// statement like "foo || bar" starts at the same position as "foo".
+type reportKey struct {
+	file        string
+	offset, end uint
+}
+
+// StmtResult describes the runs of a single statement.
+type StmtResult struct {
+	File string
+	Pos  syntax.Pos
+	End  syntax.Pos
+
+	// Runs is how many times the statement ran,
+	// and Failures is how many of those had a non-zero exit status.
+	Runs     int
+	Failures int
+
+	// Exit is the exit status of the last run.
+	Exit uint8
+
+	// Elapsed is the total time taken by all runs.
+	Elapsed time.Duration
+}
+
+// CommandResult describes a simple command which ran, be it a function,
+// a builtin, or a program.
+type CommandResult struct {
+	// Args holds the command's name and arguments after expansion.
+	Args []string `json:"args"`
+
+	// Start is when the command started running.
+	Start time.Time `json:"start"`
+
+	// Elapsed is how long the command took, and Exit its exit status.
+	Elapsed time.Duration `json:"elapsed"`
+	Exit    uint8         `json:"exit"`
+}
+
+func (rep *RunReport) addFile(file *syntax.File) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	if rep.byPos == nil {
+		rep.byPos = make(map[reportKey]*StmtResult)
+		rep.nodes = make(map[*syntax.Stmt]*StmtResult)
+	}
+	if !slices.Contains(rep.files, file.Name) {
+		rep.files = append(rep.files, file.Name)
+	}
+	syntax.Walk(file, func(node syntax.Node) bool {
+		if st, ok := node.(*syntax.Stmt); ok {
+			rep.nodes[st] = rep.result(file.Name, st)
+		}
+		return true
+	})
+}
+
+// result returns the result for a statement, creating it if needed.
+// The caller must hold the lock.
+func (rep *RunReport) result(file string, st *syntax.Stmt) *StmtResult {
+	key := reportKey{file, st.Pos().Offset(), st.End().Offset()}
+	sr := rep.byPos[key]
+	if sr == nil {
+		sr = &StmtResult{File: file, Pos: st.Pos(), End: st.End()}
+		rep.byPos[key] = sr
+	}
+	return sr
+}
+
+func (rep *RunReport) hooks() TraceHooks {
+	return TraceHooks{
+		File: func(ctx context.Context, file *syntax.File) {
+			rep.addFile(file)
+		},
+		AfterStmt: func(ctx context.Context, st *syntax.Stmt, elapsed time.Duration, exit uint8) {
+			rep.mu.Lock()
+			defer rep.mu.Unlock()
+			sr := rep.nodes[st]
+			if sr == nil {
+				// A statement not in any file, such as when running
+				// a single statement via Runner.Run.
+				if rep.byPos == nil {
+					rep.byPos = make(map[reportKey]*StmtResult)
+					rep.nodes = make(map[*syntax.Stmt]*StmtResult)
+				}
+				sr = rep.result("", st)
+				rep.nodes[st] = sr
+			}
+			sr.Runs++
+			if exit != 0 {
+				sr.Failures++
+			}
+			sr.Exit = exit
+			sr.Elapsed += elapsed
+		},
+	}
+}
+
+// startCommand records a command which is about to run, returning its index
+// to be given to endCommand.
+func (rep *RunReport) startCommand(args []string) int {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.commands = append(rep.commands, CommandResult{
+		Args:  slices.Clone(args),
+		Start: time.Now(),
+	})
+	return len(rep.commands) - 1
+}
+
+func (rep *RunReport) endCommand(i int, exit int) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	cr := &rep.commands[i]
+	cr.Elapsed = time.Since(cr.Start)
+	cr.Exit = uint8(exit)
+}
+
+// startJob records a background job starting, returning a func to call once it
+// finishes.
+func (rep *RunReport) startJob() (done func()) {
+	rep.mu.Lock()
+	rep.jobs++
+	rep.peakJobs = max(rep.peakJobs, rep.jobs)
+	rep.mu.Unlock()
+	return func() {
+		rep.mu.Lock()
+		rep.jobs--
+		rep.mu.Unlock()
+	}
+}
+
+// writer wraps w so that the bytes written to it count towards fd,
+// which must be 1 or 2.
+func (rep *RunReport) writer(fd int, w io.Writer) io.Writer {
+	cw := &countWriter{rep: rep, fd: fd, w: w}
+	if f, ok := w.(interface{ Fd() uintptr }); ok {
+		// Keep "test -t" working on terminals.
+		return &countFile{cw, f}
+	}
+	return cw
+}
+
+type countWriter struct {
+	rep *RunReport
+	fd  int
+	w   io.Writer
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.rep.mu.Lock()
+	cw.rep.written[cw.fd] += int64(n)
+	cw.rep.mu.Unlock()
+	return n, err
+}
+
+type countFile struct {
+	*countWriter
+	f interface{ Fd() uintptr }
+}
+
+func (cf *countFile) Fd() uintptr { return cf.f.Fd() }
+
+// Stmts returns the results of all statements which ran, sorted by file in the
+// order they were added, and then by position. Statements which never ran are
+// not included.
+func (rep *RunReport) Stmts() []StmtResult {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	list := make([]StmtResult, 0, len(rep.byPos))
+	for _, sr := range rep.byPos {
+		if sr.Runs > 0 {
+			list = append(list, *sr)
+		}
+	}
+	slices.SortFunc(list, func(a, b StmtResult) int {
+		if a.File != b.File {
+			return slices.Index(rep.files, a.File) - slices.Index(rep.files, b.File)
+		}
+		if n := cmp.Compare(a.Pos.Offset(), b.Pos.Offset()); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.End.Offset(), b.End.Offset())
+	})
+	return list
+}
+
+// Commands returns all the simple commands which ran, in the order they started.
+func (rep *RunReport) Commands() []CommandResult {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return slices.Clone(rep.commands)
+}
+
+// Written returns the number of bytes written to the runner's standard output
+// or error, for fd 1 or 2 respectively. Writes to other files, such as via
+// redirections, are not counted.
+func (rep *RunReport) Written(fd int) int64 {
+	if fd != 1 && fd != 2 {
+		return 0
+	}
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return rep.written[fd]
+}
+
+// PeakJobs returns the highest number of background jobs, including
+// coprocesses, which ran at the same time.
+func (rep *RunReport) PeakJobs() int {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return rep.peakJobs
+}
+
+type stmtResultJSON struct {
+	File     string        `json:"file,omitempty"`
+	Line     uint          `json:"line"`
+	Col      uint          `json:"col"`
+	EndLine  uint          `json:"endLine"`
+	EndCol   uint          `json:"endCol"`
+	Runs     int           `json:"runs"`
+	Failures int           `json:"failures"`
+	Exit     uint8         `json:"exit"`
+	Elapsed  time.Duration `json:"elapsed"`
+}
+
+// WriteJSON writes the report as a single JSON object, with durations in
+// nanoseconds. The format is stable; new fields may be added in the future.
+func (rep *RunReport) WriteJSON(w io.Writer) error {
+	stmts := rep.Stmts()
+	out := struct {
+		Stmts    []stmtResultJSON `json:"stmts"`
+		Commands []CommandResult  `json:"commands"`
+		Stdout   int64            `json:"stdoutBytes"`
+		Stderr   int64            `json:"stderrBytes"`
+		PeakJobs int              `json:"peakJobs"`
+	}{
+		Stmts:    make([]stmtResultJSON, len(stmts)),
+		Commands: rep.Commands(),
+		Stdout:   rep.Written(1),
+		Stderr:   rep.Written(2),
+		PeakJobs: rep.PeakJobs(),
+	}
+	for i, sr := range stmts {
+		out.Stmts[i] = stmtResultJSON{
+			File:     sr.File,
+			Line:     sr.Pos.Line(),
+			Col:      sr.Pos.Col(),
+			EndLine:  sr.End.Line(),
+			EndCol:   sr.End.Col(),
+			Runs:     sr.Runs,
+			Failures: sr.Failures,
+			Exit:     sr.Exit,
+			Elapsed:  sr.Elapsed,
+		}
+	}
+	if out.Commands == nil {
+		out.Commands = []CommandResult{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc.Encode(out)
+}
@@ -13,8 +13,8 @@ import (
 	"math"
 	"math/rand"
 	"os"
-	"regexp"
-	"runtime"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -39,7 +39,12 @@ const (
 func (r *Runner) fillExpandConfig(ctx context.Context) {
 	r.ectx = ctx
 	r.ecfg = &expand.Config{
-		Env: expandEnv{r},
+		Env:     expandEnv{r},
+		ToUpper: r.locale.toUpper,
+		ToLower: r.locale.toLower,
+
+		CheckedArithm: r.checkedArithm,
+		MaxFields:     r.sandboxFields(),
 		CmdSubst: func(w io.Writer, cs *syntax.CmdSubst) error {
 			switch len(cs.Stmts) {
 			case 0: // nothing to do
@@ -60,14 +65,20 @@ func (r *Runner) fillExpandConfig(ctx context.Context) {
 			}
 			r2 := r.Subshell()
 			r2.stdout = w
+			r2.xtraceLevel++
+			if r.errExitMode == ErrExitPOSIX {
+				// POSIX shells don't let command substitutions inherit
+				// the contexts where errexit is ignored.
+				r2.noErrExit = false
+			}
+			if !r.opts[optInheritErrExit] {
+				r2.opts[optErrExit] = false
+			}
 			r2.stmts(ctx, cs.Stmts)
 			r.lastExpandExit = r2.exit
 			return r2.err
 		},
 		ProcSubst: func(ps *syntax.ProcSubst) (string, error) {
-			if runtime.GOOS == "windows" {
-				return "", fmt.Errorf("TODO: support process substitution on Windows")
-			}
 			if len(ps.Stmts) == 0 { // nothing to do
 				return os.DevNull, nil
 			}
@@ -75,26 +86,9 @@ func (r *Runner) fillExpandConfig(ctx context.Context) {
 			if r.rand == nil {
 				r.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
 			}
-			dir := os.TempDir()
-
-			// We can't atomically create a random unused temporary FIFO.
-			// Similar to os.CreateTemp,
-			// keep trying new random paths until one does not exist.
-			// We use a uint64 because a uint32 easily runs into retries.
-			var path string
-			try := 0
-			for {
-				path = fmt.Sprintf("%s/sh-interp-%x", dir, r.rand.Uint64())
-				err := mkfifo(path, 0o666)
-				if err == nil {
-					break
-				}
-				if !os.IsExist(err) {
-					return "", fmt.Errorf("cannot create fifo: %v", err)
-				}
-				if try++; try > 100 {
-					return "", fmt.Errorf("giving up at creating fifo: %v", err)
-				}
+			pipe, err := newProcSubstPipe(r.rand, ps.Op == syntax.CmdIn)
+			if err != nil {
+				return "", err
 			}
 
 			r2 := r.Subshell()
@@ -102,42 +96,46 @@ func (r *Runner) fillExpandConfig(ctx context.Context) {
 			r.wgProcSubsts.Add(1)
 			go func() {
 				defer r.wgProcSubsts.Done()
+				defer r.logPanic()
+				f, err := pipe.open()
+				if err != nil {
+					pipe.close(nil)
+					r.errf("cannot open pipe for process substitution: %v\n", err)
+					return
+				}
 				switch ps.Op {
 				case syntax.CmdIn:
-					f, err := os.OpenFile(path, os.O_WRONLY, 0)
-					if err != nil {
-						r.errf("cannot open fifo for stdout: %v\n", err)
-						return
-					}
 					r2.stdout = f
 					defer func() {
-						if err := f.Close(); err != nil {
-							r.errf("closing stdout fifo: %v\n", err)
+						if err := pipe.close(f); err != nil {
+							r.errf("closing process substitution pipe: %v\n", err)
 						}
-						os.Remove(path)
 					}()
 				default: // syntax.CmdOut
-					f, err := os.OpenFile(path, os.O_RDONLY, 0)
-					if err != nil {
-						r.errf("cannot open fifo for stdin: %v\n", err)
-						return
-					}
 					r2.stdin = f
 					r2.stdout = stdout
-
-					defer func() {
-						f.Close()
-						os.Remove(path)
-					}()
+					defer pipe.close(f)
 				}
 				r2.stmts(ctx, ps.Stmts)
 			}()
-			return path, nil
+			return pipe.path, nil
 		},
 	}
 	r.updateExpandOpts()
 }
 
+// readDir reads a directory via the runner's handler during globbing.
+func (r *Runner) readDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	entries, err := r.readDirHandler(ctx, path)
+	if r.deterministic {
+		entries = slices.Clone(entries)
+		slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+			return strings.Compare(a.Name(), b.Name())
+		})
+	}
+	return entries, err
+}
+
 // catShortcutArg checks if a statement is of the form "$(<file)". The redirect
 // word is returned if there's a match, and nil otherwise.
 func catShortcutArg(stmt *syntax.Stmt) *syntax.Word {
@@ -156,30 +154,53 @@ func catShortcutArg(stmt *syntax.Stmt) *syntax.Word {
 
 func (r *Runner) updateExpandOpts() {
 	if r.opts[optNoGlob] {
-		r.ecfg.ReadDir2 = nil
+		r.ecfg.Glob = nil
 	} else {
-		r.ecfg.ReadDir2 = func(s string) ([]fs.DirEntry, error) {
-			return r.readDirHandler(r.handlerCtx(context.Background()), s)
+		r.ecfg.Glob = func(pat string) ([]string, error) {
+			return r.globHandler(r.handlerCtx(r.ectx), pat, GlobOptions{
+				GlobStar:   r.opts[optGlobStar],
+				DotGlob:    r.opts[optDotGlob],
+				NoCaseGlob: r.opts[optNoCaseGlob],
+				ExtGlob:    r.opts[optExtGlob],
+			})
 		}
 	}
-	r.ecfg.GlobStar = r.opts[optGlobStar]
-	r.ecfg.NoCaseGlob = r.opts[optNoCaseGlob]
 	r.ecfg.NullGlob = r.opts[optNullGlob]
+	r.ecfg.FailGlob = r.opts[optFailGlob]
+	r.ecfg.ExtGlob = r.opts[optExtGlob]
+	r.ecfg.PatSubReplacement = r.opts[optPatSubReplacement]
 	r.ecfg.NoUnset = r.opts[optNoUnset]
 }
 
 func (r *Runner) expandErr(err error) {
 	if err != nil {
+		if errors.Is(err, expand.ErrGlobLimit) {
+			r.setErr(fmt.Errorf("%w: %w", ErrLimitExceeded, err))
+			return
+		}
+		if errors.Is(err, expand.ErrFieldsLimit) {
+			r.setErr(fmt.Errorf("%w: %w", ErrSandboxLimit, err))
+			return
+		}
 		errMsg := err.Error()
 		fmt.Fprintln(r.stderr, errMsg)
 		switch {
-		case errors.As(err, &expand.UnsetParameterError{}):
+		case errors.As(err, &expand.NoMatchError{}):
+			// Like Bash, the command fails without running,
+			// but the shell carries on.
+			r.exit = 1
+			return
+		case errors.As(err, &expand.UnsetParameterError{}),
+			errors.As(err, &expand.SubstringError{}):
 		case errMsg == "invalid indirect expansion":
 			// TODO: These errors are treated as fatal by bash.
 			// Make the error type reflect that.
 		case strings.HasSuffix(errMsg, "not supported"):
 			// TODO: This "has suffix" is a temporary measure until the expand
-			// package supports all syntax nodes like extended globbing.
+			// package supports all syntax nodes like "!(pattern)".
+			r.logWarn("unsupported expansion", "err", err)
+			r.diagnose(r.ectx, nil, "unsupported-expansion", "unsupported expansion: %v", err)
+		case errMsg == "extended globbing is not enabled":
 		default:
 			return // other cases do not exit
 		}
@@ -188,31 +209,52 @@ func (r *Runner) expandErr(err error) {
 }
 
 func (r *Runner) arithm(expr syntax.ArithmExpr) int {
+	if r.program != nil {
+		if n, ok := r.program.arithms[expr]; ok {
+			return n
+		}
+	}
 	n, err := expand.Arithm(r.ecfg, expr)
 	r.expandErr(err)
 	return n
 }
 
 func (r *Runner) fields(words ...*syntax.Word) []string {
+	start := r.expandStart()
 	strs, err := expand.Fields(r.ecfg, words...)
+	if !start.IsZero() {
+		r.traceExpand(start, words, strs)
+	}
 	r.expandErr(err)
 	return strs
 }
 
 func (r *Runner) literal(word *syntax.Word) string {
+	start := r.expandStart()
 	str, err := expand.Literal(r.ecfg, word)
+	if !start.IsZero() {
+		r.traceExpand(start, []*syntax.Word{word}, []string{str})
+	}
 	r.expandErr(err)
 	return str
 }
 
 func (r *Runner) document(word *syntax.Word) string {
+	start := r.expandStart()
 	str, err := expand.Document(r.ecfg, word)
+	if !start.IsZero() {
+		r.traceExpand(start, []*syntax.Word{word}, []string{str})
+	}
 	r.expandErr(err)
 	return str
 }
 
 func (r *Runner) pattern(word *syntax.Word) string {
+	start := r.expandStart()
 	str, err := expand.Pattern(r.ecfg, word)
+	if !start.IsZero() {
+		r.traceExpand(start, []*syntax.Word{word}, []string{str})
+	}
 	r.expandErr(err)
 	return str
 }
@@ -244,6 +286,8 @@ func (r *Runner) handlerCtx(ctx context.Context) context.Context {
 		Stdin:  r.stdin,
 		Stdout: r.stdout,
 		Stderr: r.stderr,
+		Files:  r.handlerFiles(),
+		runner: r,
 	}
 	return context.WithValue(ctx, handlerCtxKey{}, hc)
 }
@@ -281,26 +325,90 @@ func (r *Runner) stop(ctx context.Context) bool {
 }
 
 func (r *Runner) stmt(ctx context.Context, st *syntax.Stmt) {
-	if r.stop(ctx) {
+	if r.stop(ctx) || !r.limitStmt() {
 		return
 	}
+	oldLineno := r.lineno
+	r.lineno = st.Pos().Line()
 	r.exit = 0
 	if st.Background {
-		r2 := r.Subshell()
-		st2 := *st
-		st2.Background = false
-		r.bgShells.Go(func() error {
-			return r2.Run(ctx, &st2)
-		})
+		r2 := r.bgSubshell()
+		r.goJob(ctx, r2, st, func(ctx context.Context) { r2.stmtTraced(ctx, st) })
 	} else {
-		r.stmtSync(ctx, st)
+		r.stmtTraced(ctx, st)
 	}
 	r.lastExit = r.exit
+	r.lineno = oldLineno
+	r.runTraps(ctx)
+}
+
+// stmtTraced is like stmtSync, but calling any trace hooks around it.
+// Note that it ignores st.Background.
+func (r *Runner) stmtTraced(ctx context.Context, st *syntax.Stmt) {
+	if r.debugger != nil {
+		r.debugger.stmt(ctx, r, st)
+	}
+	if len(r.traceHooks) == 0 {
+		r.stmtSync(ctx, st)
+		return
+	}
+	hctx := r.handlerCtx(ctx)
+	for _, hooks := range r.traceHooks {
+		if hooks.BeforeStmt != nil {
+			hooks.BeforeStmt(hctx, st)
+		}
+	}
+	start := time.Now()
+	r.stmtSync(ctx, st)
+	elapsed := time.Since(start)
+	hctx = r.handlerCtx(ctx)
+	for _, hooks := range r.traceHooks {
+		if hooks.AfterStmt != nil {
+			hooks.AfterStmt(hctx, st, elapsed, uint8(r.exit))
+		}
+	}
+}
+
+// startFile lets any trace hooks and debugger know that a file is about to run.
+func (r *Runner) startFile(ctx context.Context, file *syntax.File) {
+	if r.debugger != nil {
+		r.debugger.addFile(file)
+	}
+	if len(r.traceHooks) == 0 {
+		return
+	}
+	hctx := r.handlerCtx(ctx)
+	for _, hooks := range r.traceHooks {
+		if hooks.File != nil {
+			hooks.File(hctx, file)
+		}
+	}
+}
+
+// expandStart returns the current time if any trace hooks need to observe
+// expansions, and the zero time otherwise.
+func (r *Runner) expandStart() time.Time {
+	if len(r.traceHooks) == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// traceExpand calls any trace hooks after an expansion which started at start.
+func (r *Runner) traceExpand(start time.Time, words []*syntax.Word, fields []string) {
+	elapsed := time.Since(start)
+	hctx := r.handlerCtx(r.ectx)
+	for _, hooks := range r.traceHooks {
+		if hooks.Expand != nil {
+			hooks.Expand(hctx, words, fields, elapsed)
+		}
+	}
 }
 
 func (r *Runner) stmtSync(ctx context.Context, st *syntax.Stmt) {
 	defer r.wgProcSubsts.Wait()
-	oldIn, oldOut, oldErr := r.stdin, r.stdout, r.stderr
+	oldIn, oldOut, oldErr, oldFds := r.stdin, r.stdout, r.stderr, r.fds
+	var closers []io.Closer
 	for _, rd := range st.Redirs {
 		cls, err := r.redir(ctx, rd)
 		if err != nil {
@@ -308,18 +416,26 @@ func (r *Runner) stmtSync(ctx context.Context, st *syntax.Stmt) {
 			break
 		}
 		if cls != nil {
-			defer cls.Close()
+			closers = append(closers, cls)
 		}
 	}
+	redirFds := r.fds
 	if r.exit == 0 && st.Cmd != nil {
-		r.cmd(ctx, st.Cmd)
+		if st.Negated {
+			oldNoErrExit := r.noErrExit
+			r.noErrExit = true
+			r.cmd(ctx, st.Cmd)
+			r.noErrExit = oldNoErrExit
+		} else {
+			r.cmd(ctx, st.Cmd)
+		}
 	}
 	if st.Negated {
 		r.exit = oneIf(r.exit == 0)
-	} else if _, ok := st.Cmd.(*syntax.CallExpr); !ok {
+	} else if !errExitCmd(st.Cmd) {
 	} else if r.exit != 0 && !r.noErrExit && r.opts[optErrExit] {
-		// If the "errexit" option is set and a simple command failed,
-		// exit the shell. Exceptions:
+		// If the "errexit" option is set and a simple command or a
+		// subshell failed, exit the shell. Exceptions:
 		//
 		//   conditions (if <cond>, while <cond>, etc)
 		//   part of && or || lists
@@ -328,18 +444,43 @@ func (r *Runner) stmtSync(ctx context.Context, st *syntax.Stmt) {
 	} else if r.exit != 0 && !r.noErrExit {
 		r.trapCallback(ctx, r.callbackErr, "error")
 	}
-	if !r.keepRedirs {
+	if r.keepRedirs {
+		// Redirections via exec, like "exec >log" or "exec 3>&-",
+		// apply to the rest of the shell.
+		r.keepRedirs = false
+		r.execFiles = append(r.execFiles, closers...)
+	} else {
 		r.stdin, r.stdout, r.stderr = oldIn, oldOut, oldErr
+		for _, cls := range closers {
+			r.logErr("closing redirection", cls.Close())
+		}
+		if len(st.Redirs) > 0 {
+			r.restoreFds(oldFds, redirFds)
+		}
+	}
+	if len(oldFds) > 0 || len(redirFds) > 0 {
+		r.closeUnusedFds(oldFds, redirFds)
 	}
 }
 
+// errExitCmd reports whether a failure of cm can trigger the "errexit" option.
+// Other compound commands only fail due to the commands they contain,
+// which trigger the option themselves unless it was being ignored.
+func errExitCmd(cm syntax.Command) bool {
+	switch cm.(type) {
+	case *syntax.CallExpr, *syntax.Subshell:
+		return true
+	}
+	return false
+}
+
 func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 	if r.stop(ctx) {
 		return
 	}
 
 	tracingEnabled := r.opts[optXTrace]
-	trace := r.tracer()
+	trace := r.tracer(cm.Pos())
 
 	switch cm := cm.(type) {
 	case *syntax.Block:
@@ -382,11 +523,7 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 				if as.Array != nil {
 					trace.expr(as)
 				} else if as.Value != nil {
-					val, err := syntax.Quote(vr.String(), syntax.LangBash)
-					if err != nil { // should never happen
-						panic(err)
-					}
-					trace.stringf("%s=%s", as.Name.Value, val)
+					trace.assign(as.Name.Value, vr.String())
 				}
 				trace.newLineFlush()
 			}
@@ -413,9 +550,11 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 			// Inline command vars are always exported.
 			vr.Exported = true
 
-			restores = append(restores, restoreVar{name, origVr})
-
-			r.setVarInternal(name, vr)
+			// Like Bash, run the command even if the assignment failed,
+			// such as when the variable is read-only.
+			if r.setVarInternal(name, vr) {
+				restores = append(restores, restoreVar{name, origVr})
+			}
 		}
 
 		trace.call(fields[0], fields[1:]...)
@@ -436,29 +575,40 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 				r.stmt(ctx, cm.Y)
 			}
 		case syntax.Pipe, syntax.PipeAll:
-			pr, pw, err := os.Pipe()
+			pr, pw, err := newPipe()
 			if err != nil {
 				r.setErr(err)
 				return
 			}
 			r2 := r.Subshell()
-			r2.stdout = pw
+			r2.stdout = r.watchWriter(ctx, pw, cm.X, 1)
 			if cm.Op == syntax.PipeAll {
-				r2.stderr = pw
+				r2.stderr = r.watchWriter(ctx, pw, cm.X, 2)
 			} else {
 				r2.stderr = r.stderr
 			}
-			r.stdin = pr
+			// With "lastpipe", the last command runs in the current shell,
+			// so that "cmd | read var" sets var.
+			r3 := r
+			if !r.opts[optLastPipe] {
+				r3 = r.Subshell()
+			}
+			r3.stdin = r.watchReader(ctx, pr, cm.Y)
 			var wg sync.WaitGroup
 			wg.Add(1)
 			go func() {
+				defer r.logPanic()
 				r2.stmt(ctx, cm.X)
 				pw.Close()
 				wg.Done()
 			}()
-			r.stmt(ctx, cm.Y)
+			r3.stmt(ctx, cm.Y)
 			pr.Close()
 			wg.Wait()
+			if r3 != r {
+				r.exit = r3.exit
+				r.setErr(r3.err)
+			}
 			if r.opts[optPipeFail] && r2.exit != 0 && r.exit == 0 {
 				r.exit = r2.exit
 				r.shellExited = r2.shellExited
@@ -480,6 +630,7 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 			r.cmd(ctx, cm.Else)
 		}
 	case *syntax.WhileClause:
+		iterations := 0
 		for !r.stop(ctx) {
 			oldNoErrExit := r.noErrExit
 			r.noErrExit = true
@@ -488,13 +639,14 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 
 			stop := (r.exit == 0) == cm.Until
 			r.exit = 0
-			if stop || r.loopStmtsBroken(ctx, cm.Do) {
+			if stop || r.loopStmtsBroken(ctx, &iterations, cm.Do) {
 				break
 			}
 		}
 	case *syntax.ForClause:
 		switch y := cm.Loop.(type) {
 		case *syntax.WordIter:
+			iterations := 0
 			name := y.Name.Value
 			items := r.Params // for i; do ...
 
@@ -539,13 +691,15 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 				}
 
 				// execute commands until break or return is encountered
-				if r.loopStmtsBroken(ctx, cm.Do) {
+				if r.loopStmtsBroken(ctx, &iterations, cm.Do) {
 					break
 				}
 			}
 
 			for _, field := range items {
-				r.setVarString(name, field)
+				if !r.setVarString(name, field) {
+					break
+				}
 				trace.stringf("for %s in", y.Name.Value)
 				if inToken {
 					for _, item := range y.Items {
@@ -556,7 +710,7 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 					trace.string(` "$@"`)
 				}
 				trace.newLineFlush()
-				if r.loopStmtsBroken(ctx, cm.Do) {
+				if r.loopStmtsBroken(ctx, &iterations, cm.Do) {
 					break
 				}
 			}
@@ -564,8 +718,9 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 			if y.Init != nil {
 				r.arithm(y.Init)
 			}
+			iterations := 0
 			for y.Cond == nil || r.arithm(y.Cond) != 0 {
-				if r.exit != 0 || r.loopStmtsBroken(ctx, cm.Do) {
+				if r.exit != 0 || r.loopStmtsBroken(ctx, &iterations, cm.Do) {
 					break
 				}
 				if y.Post != nil {
@@ -588,11 +743,8 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 
 			switch expr := expr.(type) {
 			case *syntax.Word:
-				qs, err := syntax.Quote(r.literal(expr), syntax.LangBash)
-				if err != nil {
-					return
-				}
-				trace.stringf("let %v", qs)
+				trace.string("let ")
+				trace.quoted(r.literal(expr))
 			case *syntax.BinaryArithm, *syntax.UnaryArithm:
 				trace.expr(cm)
 			case *syntax.ParenArithm:
@@ -610,8 +762,7 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 		str := r.literal(cm.Word)
 		for _, ci := range cm.Items {
 			for _, word := range ci.Patterns {
-				pattern := r.pattern(word)
-				if match(pattern, str) {
+				if r.matchCasePattern(word, str) {
 					r.stmts(ctx, ci.Stmts)
 					return
 				}
@@ -623,8 +774,8 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 			r.exit = 1
 		}
 	case *syntax.DeclClause:
-		local, global := false, false
-		var modes []string
+		local, global, print := false, false, false
+		var attrs expand.Variable
 		valType := ""
 		switch cm.Variant.Value {
 		case "declare":
@@ -639,35 +790,55 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 			}
 			local = true
 		case "export":
-			modes = append(modes, "-x")
+			attrs.Exported = true
 		case "readonly":
-			modes = append(modes, "-r")
+			attrs.ReadOnly = true
 		case "nameref":
 			valType = "-n"
 		}
+		var printNames []string
 		for _, as := range cm.Args {
 			for _, as := range r.flattenAssign(as) {
 				name := as.Name.Value
-				if strings.HasPrefix(name, "-") {
-					switch name {
-					case "-x", "-r":
-						modes = append(modes, name)
-					case "-a", "-A", "-n":
-						valType = name
-					case "-g":
-						global = true
-					default:
-						r.errf("declare: invalid option %q\n", name)
-						r.exit = 2
-						return
+				if name == "--" {
+					continue
+				}
+				if len(name) > 1 && name[0] == '-' {
+					for _, c := range name[1:] {
+						switch c {
+						case 'x':
+							attrs.Exported = true
+						case 'r':
+							attrs.ReadOnly = true
+						case 'i':
+							attrs.Integer = true
+						case 'l':
+							attrs.Lowercase, attrs.Uppercase = true, false
+						case 'u':
+							attrs.Lowercase, attrs.Uppercase = false, true
+						case 'a', 'A', 'n':
+							valType = "-" + string(c)
+						case 'g':
+							global = true
+						case 'p':
+							print = true
+						default:
+							r.errf("%s: invalid option \"-%c\"\n", cm.Variant.Value, c)
+							r.exit = 2
+							return
+						}
 					}
 					continue
 				}
 				if !syntax.ValidName(name) {
-					r.errf("declare: invalid name %q\n", name)
+					r.errf("%s: invalid name %q\n", cm.Variant.Value, name)
 					r.exit = 1
 					return
 				}
+				if print {
+					printNames = append(printNames, name)
+					continue
+				}
 				var vr expand.Variable
 				if !as.Naked {
 					vr = r.assignVal(as, valType)
@@ -677,16 +848,9 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 				} else if local {
 					vr.Local = true
 				}
-				for _, mode := range modes {
-					switch mode {
-					case "-x":
-						vr.Exported = true
-					case "-r":
-						vr.ReadOnly = true
-					}
-				}
+				addAttrs(&vr, attrs)
 				if as.Naked {
-					if vr.Exported || vr.Local || vr.ReadOnly {
+					if vr.Local || hasAttrs(vr) {
 						r.setVarInternal(name, vr)
 					}
 				} else {
@@ -694,6 +858,9 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 				}
 			}
 		}
+		if print {
+			r.printDecl(cm.Variant.Value, attrs, printNames)
+		}
 	case *syntax.TimeClause:
 		start := time.Now()
 		if cm.Stmt != nil {
@@ -710,6 +877,8 @@ func (r *Runner) cmd(ctx context.Context, cm syntax.Command) {
 		// TODO: can we do these?
 		r.outf(format, "user", elapsedString(0, cm.PosixFormat))
 		r.outf(format, "sys", elapsedString(0, cm.PosixFormat))
+	case *syntax.CoprocClause:
+		r.coproc(ctx, cm)
 	default:
 		panic(fmt.Sprintf("unhandled command node: %T", cm))
 	}
@@ -747,6 +916,9 @@ func (r *Runner) exitShell(ctx context.Context, status int) {
 	r.shellExited = true
 	// Restore the original exit status. We ignore the callbacks.
 	r.exit = status
+	if r.opts[optHupOnExit] {
+		r.hangUpJobs()
+	}
 }
 
 func (r *Runner) flattenAssign(as *syntax.Assign) []*syntax.Assign {
@@ -773,13 +945,18 @@ func (r *Runner) flattenAssign(as *syntax.Assign) []*syntax.Assign {
 	return asgns
 }
 
-func match(pat, name string) bool {
-	expr, err := pattern.Regexp(pat, pattern.EntireString)
+// match reports whether name matches the entire pattern,
+// where extGlob enables the extended pattern matching operators.
+func (r *Runner) match(pat, name string, extGlob bool) bool {
+	mode := pattern.EntireString
+	if extGlob {
+		mode |= pattern.ExtendedOperators
+	}
+	m, err := pattern.Compile(pat, mode)
 	if err != nil {
 		return false
 	}
-	rx := regexp.MustCompile(expr)
-	return rx.MatchString(name)
+	return m.Match(name)
 }
 
 func elapsedString(d time.Duration, posix bool) string {
@@ -831,35 +1008,80 @@ func (r *Runner) hdocReader(rd *syntax.Redirect) io.Reader {
 }
 
 func (r *Runner) redir(ctx context.Context, rd *syntax.Redirect) (io.Closer, error) {
-	if rd.Hdoc != nil {
-		r.stdin = r.hdocReader(rd)
-		return nil, nil
+	n := 1
+	switch rd.Op {
+	case syntax.RdrIn, syntax.RdrInOut, syntax.DplIn, syntax.WordHdoc,
+		syntax.Hdoc, syntax.DashHdoc:
+		n = 0
 	}
-	orig := &r.stdout
+	varName := ""
 	if rd.N != nil {
-		switch rd.N.Value {
-		case "1":
-		case "2":
-			orig = &r.stderr
+		if name, ok := strings.CutPrefix(rd.N.Value, "{"); ok {
+			// Like Bash, "{fd}>file" opens a new file descriptor
+			// and stores its number in a variable.
+			varName = strings.TrimSuffix(name, "}")
+			n = r.freeFd()
+		} else {
+			n = atoi(rd.N.Value)
 		}
 	}
+	if rd.Hdoc != nil {
+		r.hdocFd(n, varName, r.hdocReader(rd))
+		return nil, nil
+	}
 	arg := r.literal(rd.Word)
 	switch rd.Op {
 	case syntax.WordHdoc:
-		r.stdin = strings.NewReader(arg + "\n")
+		r.hdocFd(n, varName, strings.NewReader(arg+"\n"))
 		return nil, nil
-	case syntax.DplOut:
-		switch arg {
-		case "1":
-			*orig = r.stdout
-		case "2":
-			*orig = r.stderr
+	case syntax.DplOut, syntax.DplIn:
+		if arg == "-" {
+			if varName != "" {
+				n = atoi(r.envGet(varName))
+			}
+			if n <= 2 {
+				r.logWarn("closing the standard streams is not supported", "fd", n)
+				r.diagnose(ctx, rd, "close-standard-stream", "closing the standard stream %d is not supported", n)
+			}
+			r.closeFd(n)
+			return nil, nil
+		}
+		// "4>&3-" moves the file descriptor rather than duplicating it.
+		src, move := strings.CutSuffix(arg, "-")
+		m, err := strconv.Atoi(src)
+		if err != nil {
+			// TODO: support ">&file" as a synonym of "&>file".
+			r.logWarn("unsupported redirection", "op", rd.Op.String(), "word", arg)
+			r.diagnose(ctx, rd, "unsupported-redirection", "unsupported redirection: %s%s", rd.Op, arg)
+			return nil, nil
+		}
+		if m == n {
+			return nil, nil
+		}
+		fd := r.getFd(m)
+		switch {
+		case m == 0 || (m <= 2 && rd.Op == syntax.DplOut):
+			// The standard streams may be nil, such as without any input.
+		case fd == nil, rd.Op == syntax.DplIn && fd.reader() == nil,
+			rd.Op == syntax.DplOut && fd.writer() == nil:
+			r.errf("%s: bad file descriptor\n", src)
+			return nil, fmt.Errorf("bad file descriptor: %s", src)
+		}
+		if move && m > 2 {
+			r.closeFd(m)
+		} else {
+			// A duplicate shares the file, which stays open until
+			// both file descriptors are closed.
+			fd = &shellFd{rw: fd.rw, file: fd.file}
+		}
+		r.setFd(n, fd)
+		if varName != "" {
+			r.setVarString(varName, strconv.Itoa(n))
 		}
 		return nil, nil
-	case syntax.RdrIn, syntax.RdrOut, syntax.AppOut,
+	case syntax.RdrIn, syntax.RdrOut, syntax.AppOut, syntax.RdrInOut,
 		syntax.RdrAll, syntax.AppAll:
 		// done further below
-	// case syntax.DplIn:
 	default:
 		panic(fmt.Sprintf("unhandled redirect op: %v", rd.Op))
 	}
@@ -869,26 +1091,95 @@ func (r *Runner) redir(ctx context.Context, rd *syntax.Redirect) (io.Closer, err
 		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
 	case syntax.RdrOut, syntax.RdrAll:
 		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case syntax.RdrInOut:
+		mode = os.O_RDWR | os.O_CREATE
 	}
 	f, err := r.open(ctx, arg, mode, 0o644, true)
 	if err != nil {
 		return nil, err
 	}
 	switch rd.Op {
-	case syntax.RdrIn:
-		r.stdin = f
-	case syntax.RdrOut, syntax.AppOut:
-		*orig = f
 	case syntax.RdrAll, syntax.AppAll:
 		r.stdout = f
 		r.stderr = f
-	default:
-		panic(fmt.Sprintf("unhandled redirect op: %v", rd.Op))
+		return f, nil
+	}
+	if n > 2 {
+		// The file is closed once no file descriptor refers to it.
+		r.setFd(n, r.openedFd(f))
+		if varName != "" {
+			r.setVarString(varName, strconv.Itoa(n))
+		}
+		return nil, nil
 	}
+	r.setFd(n, &shellFd{rw: f})
 	return f, nil
 }
 
-func (r *Runner) loopStmtsBroken(ctx context.Context, stmts []*syntax.Stmt) bool {
+// hdocFd sets a file descriptor to read a here-document or here-string,
+// such as "3<<<foo", which is standard input unless given a number.
+func (r *Runner) hdocFd(n int, varName string, rd io.Reader) {
+	switch n {
+	case 0:
+		r.stdin = rd
+		return
+	case 1, 2:
+		// Like a read-only file, writes to it fail.
+		r.setFd(n, &shellFd{rw: readOnly{rd}})
+		return
+	}
+	r.setFd(n, &shellFd{rw: rd})
+	if varName != "" {
+		r.setVarString(varName, strconv.Itoa(n))
+	}
+}
+
+// coproc starts a coprocess in the background, connected to the shell via
+// a pair of pipes whose file descriptors are stored in an array variable.
+func (r *Runner) coproc(ctx context.Context, cm *syntax.CoprocClause) {
+	name := "COPROC"
+	if cm.Name != nil {
+		name = r.literal(cm.Name)
+	}
+	inR, inW, err := newPipe()
+	if err != nil {
+		r.errf("coproc: %v\n", err)
+		r.exit = 1
+		return
+	}
+	outR, outW, err := newPipe()
+	if err != nil {
+		inR.Close()
+		inW.Close()
+		r.errf("coproc: %v\n", err)
+		r.exit = 1
+		return
+	}
+	r2 := r.bgSubshell()
+	r2.stdin = r.watchReader(ctx, inR, cm.Stmt)
+	r2.stdout = r.watchWriter(ctx, outW, cm.Stmt, 1)
+	job := r.goJob(ctx, r2, nil, func(ctx context.Context) {
+		r2.stmtTraced(ctx, cm.Stmt)
+		// Closing our ends of the pipes lets the shell see EOF when reading,
+		// and get an error when writing.
+		inR.Close()
+		outW.Close()
+	})
+
+	fds := []string{
+		strconv.Itoa(r.newFd(outR)),
+		strconv.Itoa(r.newFd(inW)),
+	}
+	r.setVar(name, nil, expand.Variable{Kind: expand.Indexed, List: fds})
+	r.setVarString(name+"_PID", strconv.Itoa(job.pid))
+}
+
+// loopStmtsBroken runs an iteration of a loop, given the number of iterations
+// of the loop so far, and reports whether the loop should stop.
+func (r *Runner) loopStmtsBroken(ctx context.Context, iterations *int, stmts []*syntax.Stmt) bool {
+	if !r.sandboxLoop() || !r.limitLoop(iterations) {
+		return true
+	}
 	oldInLoop := r.inLoop
 	r.inLoop = true
 	defer func() { r.inLoop = oldInLoop }()
@@ -906,6 +1197,32 @@ func (r *Runner) loopStmtsBroken(ctx context.Context, stmts []*syntax.Stmt) bool
 	return false
 }
 
+// callFrame is a function call or a sourced file, as tracked for variables
+// like $FUNCNAME, $BASH_SOURCE, and $BASH_LINENO.
+type callFrame struct {
+	name   string // the function name, or "source"
+	source string // the file in which the code being run is defined
+	line   uint   // the line from which it was called or sourced
+}
+
+// curSource returns the file in which the code being run is defined,
+// like ${BASH_SOURCE[0]}.
+func (r *Runner) curSource() string {
+	if n := len(r.callStack); n > 0 {
+		return r.callStack[n-1].source
+	}
+	return r.mainSource()
+}
+
+// mainSource returns the name of the file given to Run, or "main" if it has
+// no name, like Bash does when reading a program from standard input.
+func (r *Runner) mainSource() string {
+	if r.filename != "" {
+		return r.filename
+	}
+	return "main"
+}
+
 type returnStatus uint8
 
 func (s returnStatus) Error() string { return fmt.Sprintf("return status %d", s) }
@@ -923,39 +1240,168 @@ func (r *Runner) call(ctx context.Context, pos syntax.Pos, args []string) {
 			return
 		}
 	}
+	if r.report != nil {
+		i := r.report.startCommand(args)
+		defer func() {
+			exit := r.exit
+			if code, ok := r.err.(returnStatus); ok {
+				exit = int(code)
+			}
+			r.report.endCommand(i, exit)
+		}()
+	}
 	name := args[0]
 	if body := r.Funcs[name]; body != nil {
+		if r.sandbox != nil && len(r.funcNames) >= r.sandbox.cfg.FuncDepth {
+			r.setErr(fmt.Errorf("%w: more than %d nested function calls", ErrSandboxLimit, r.sandbox.cfg.FuncDepth))
+			return
+		}
+		if !r.limitFunc() {
+			return
+		}
 		// stack them to support nested func calls
 		oldParams := r.Params
 		r.Params = args[1:]
 		oldInFunc := r.inFunc
 		r.inFunc = true
+		r.funcNames = append(r.funcNames, name)
+		source, ok := r.funcSources[name]
+		if !ok {
+			source = r.mainSource()
+		}
+		r.callStack = append(r.callStack, callFrame{name, source, pos.Line()})
 
 		// Functions run in a nested scope.
 		// Note that Runner.exec below does something similar.
 		origEnv := r.writeEnv
 		r.writeEnv = &overlayEnviron{parent: r.writeEnv, funcScope: true}
 
-		r.stmt(ctx, body)
+		spanCtx, endSpan := r.startSpan(ctx, SpanFunc, args)
+		r.stmt(spanCtx, body)
 
 		r.writeEnv = origEnv
 
 		r.Params = oldParams
 		r.inFunc = oldInFunc
+		r.funcNames = r.funcNames[:len(r.funcNames)-1]
+		r.callStack = r.callStack[:len(r.callStack)-1]
 		if code, ok := r.err.(returnStatus); ok {
 			r.err = nil
 			r.exit = int(code)
 		}
+		endSpan()
 		return
 	}
 	if isBuiltin(name) {
-		r.exit = r.builtinCode(ctx, pos, name, args[1:])
+		r.exit = r.builtin(ctx, pos, name, args[1:])
 		return
 	}
 	r.exec(ctx, args)
 }
 
+// startSpan starts a span via the span handler, if any, returning the context
+// to run the command with and a func to end the span once it finishes.
+func (r *Runner) startSpan(ctx context.Context, kind SpanKind, args []string) (context.Context, func()) {
+	if r.spanHandler == nil {
+		return ctx, func() {}
+	}
+	start := time.Now()
+	spanCtx, end := r.spanHandler(r.handlerCtx(ctx), Span{Kind: kind, Args: args, Start: start})
+	if spanCtx == nil {
+		spanCtx = ctx
+	}
+	return spanCtx, func() {
+		if end != nil {
+			end(uint8(r.exit), time.Since(start))
+		}
+	}
+}
+
+// now returns the current time, which is frozen in deterministic mode.
+func (r *Runner) now() time.Time {
+	if r.deterministic {
+		return r.frozenTime
+	}
+	return time.Now()
+}
+
+// startJob lets the report know that a background job is starting,
+// returning a func to call once it finishes.
+func (r *Runner) startJob() (done func()) {
+	if r.report == nil {
+		return func() {}
+	}
+	return r.report.startJob()
+}
+
+// commandNotFound runs the "command_not_found_handle" function in a subshell
+// for a command which was not found, like Bash does, or otherwise the handler
+// set via [CommandNotFoundHandler]. It reports false if there is neither.
+func (r *Runner) commandNotFound(ctx context.Context, args []string) (error, bool) {
+	const name = "command_not_found_handle"
+	if r.Funcs[name] == nil || r.inNotFoundHandle {
+		if r.notFoundHandler == nil {
+			return nil, false
+		}
+		return r.notFoundHandler(r.handlerCtx(ctx), args), true
+	}
+	r2 := r.Subshell()
+	// Unlike Bash, don't recurse forever if the function itself runs
+	// a command which is not found.
+	r2.inNotFoundHandle = true
+	r2.call(ctx, syntax.Pos{}, append([]string{name}, args...))
+	if r2.err != nil {
+		return r2.err, true
+	}
+	if r2.exit != 0 {
+		return NewExitStatus(uint8(r2.exit)), true
+	}
+	return nil, true
+}
+
+// allowed consults a command policy, if any, reporting whether the command
+// may run. If it may not, the exit status is set accordingly.
+func (r *Runner) allowed(ctx context.Context, policy CommandPolicyFunc, name string, args []string) bool {
+	if policy == nil {
+		return true
+	}
+	err := policy(r.handlerCtx(ctx), name, args)
+	if err == nil {
+		return true
+	}
+	r.errf("%v\n", err)
+	r.exit = 126
+	var denied *CommandDeniedError
+	if errors.As(err, &denied) && denied.NotFound {
+		r.exit = 127
+	}
+	return false
+}
+
+// builtin runs a builtin as a command, consulting the builtin policy first.
+func (r *Runner) builtin(ctx context.Context, pos syntax.Pos, name string, args []string) int {
+	if !r.allowed(ctx, r.builtinPolicy, name, args) {
+		return r.exit
+	}
+	return r.builtinCode(ctx, pos, name, args)
+}
+
 func (r *Runner) exec(ctx context.Context, args []string) {
+	r.audit(ctx, AuditEvent{Kind: AuditExec, Args: args})
+	if r.sandbox != nil {
+		r.errf("%v\n", &CommandDeniedError{
+			Name:     args[0],
+			Reason:   "external commands are not allowed in a sandbox",
+			NotFound: true,
+		})
+		r.exit = 127
+		return
+	}
+	if !r.allowed(ctx, r.commandPolicy, args[0], args[1:]) {
+		return
+	}
+	ctx, endSpan := r.startSpan(ctx, SpanExec, args)
+	defer endSpan()
 	err := r.execHandler(r.handlerCtx(ctx), args)
 	if status, ok := IsExitStatus(err); ok {
 		r.exit = int(status)
@@ -969,8 +1415,73 @@ func (r *Runner) exec(ctx context.Context, args []string) {
 	r.exit = 0
 }
 
+// hashEntry is a command in the table managed by the hash builtin.
+type hashEntry struct {
+	path string
+	hits int
+}
+
+// hashSync clears the table of hashed commands if PATH has changed since the
+// table was filled.
+func (r *Runner) hashSync(env expand.Environ) {
+	if path := env.Get("PATH").String(); path != r.hashPath {
+		clear(r.hashed)
+		r.hashPath = path
+	}
+}
+
+// hashLookPath is like [LookPathDir], but it remembers where commands were
+// found in PATH, to avoid searching for them again.
+// If hit is true, the lookup counts towards the command's hits.
+func (r *Runner) hashLookPath(env expand.Environ, file string, hit bool) (string, error) {
+	if hasPathSeparator(file) {
+		return LookPathDir(r.Dir, env, file)
+	}
+	r.hashSync(env)
+	if entry, ok := r.hashed[file]; ok {
+		// Unlike Bash by default, check that the file is still there.
+		if _, err := findExecutable(r.Dir, entry.path, nil); err == nil {
+			if hit {
+				entry.hits++
+				r.hashed[file] = entry
+			}
+			return entry.path, nil
+		}
+		delete(r.hashed, file)
+	}
+	path, err := LookPathDir(r.Dir, env, file)
+	if err != nil {
+		return "", err
+	}
+	// Directories in PATH relative to the current directory, such as ".",
+	// could point elsewhere once the current directory changes.
+	dir := filepath.Dir(path)
+	absDir := slices.ContainsFunc(filepath.SplitList(r.hashPath), func(elem string) bool {
+		return filepath.IsAbs(elem) && filepath.Clean(elem) == dir
+	})
+	if absDir {
+		if r.hashed == nil {
+			r.hashed = make(map[string]hashEntry)
+		}
+		r.hashed[file] = hashEntry{path: path, hits: oneIf(hit)}
+	}
+	return path, nil
+}
+
 func (r *Runner) open(ctx context.Context, path string, flags int, mode os.FileMode, print bool) (io.ReadWriteCloser, error) {
-	f, err := r.openHandler(r.handlerCtx(ctx), path, flags, mode)
+	if flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+		r.audit(ctx, AuditEvent{Kind: AuditOpen, Path: r.absPath(path), Flags: flags})
+	}
+	var f io.ReadWriteCloser
+	var err error
+	if stream, ok := r.streams[path]; ok {
+		f, err = openStream(path, stream, flags)
+	} else if r.sandbox != nil && flags&(os.O_WRONLY|os.O_RDWR) != 0 && path != "/dev/null" {
+		err = &os.PathError{Op: "open", Path: path, Err: fs.ErrPermission}
+	} else {
+		// Note that the process umask still applies on top of ours.
+		f, err = r.openHandler(r.handlerCtx(ctx), path, flags, mode&^r.umask)
+	}
 	// TODO: support wrapped PathError returned from openHandler.
 	switch err.(type) {
 	case nil:
@@ -984,6 +1495,34 @@ func (r *Runner) open(ctx context.Context, path string, flags int, mode os.FileM
 	return f, err
 }
 
+// streamFile wraps a stream registered via [Streams] as a file.
+// Closing it does not close the stream.
+type streamFile struct {
+	io.Reader
+	io.Writer
+}
+
+func (streamFile) Close() error { return nil }
+
+func openStream(path string, stream any, flags int) (io.ReadWriteCloser, error) {
+	var f streamFile
+	if flags&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY {
+		rd, ok := stream.(io.Reader)
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("stream is not readable")}
+		}
+		f.Reader = rd
+	}
+	if flags&(os.O_WRONLY|os.O_RDWR) != 0 {
+		wr, ok := stream.(io.Writer)
+		if !ok {
+			return nil, &os.PathError{Op: "open", Path: path, Err: errors.New("stream is not writable")}
+		}
+		f.Writer = wr
+	}
+	return f, nil
+}
+
 func (r *Runner) stat(ctx context.Context, name string) (fs.FileInfo, error) {
 	path := absPath(r.Dir, name)
 	return r.statHandler(ctx, path, true)
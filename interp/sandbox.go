@@ -0,0 +1,143 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+)
+
+// ErrSandboxLimit is wrapped by the errors returned by [Runner.Run] when a
+// program exceeds one of the limits of a [Sandbox].
+var ErrSandboxLimit = errors.New("sandbox limit exceeded")
+
+// SandboxConfig holds the limits of a runner restricted via [SandboxLimits],
+// which bound how much time and memory an untrusted program may use.
+// A zero field means the default limit, which is the one used by [Sandbox].
+type SandboxConfig struct {
+	// Timeout bounds how long each call to [Runner.Run] may take.
+	// The default is ten seconds.
+	Timeout time.Duration
+
+	// LoopIterations is the maximum number of loop iterations in each call
+	// to [Runner.Run], counted across all loops. The default is 100000.
+	LoopIterations int64
+
+	// FuncDepth is the maximum number of nested function calls.
+	// The default is 1000.
+	FuncDepth int
+
+	// VarBytes is the maximum size of the value of a single variable,
+	// approximated like [LimitConfig.VarBytes]. The default is one mebibyte.
+	VarBytes int64
+
+	// TotalVarBytes is the maximum size of all variables, approximated like
+	// [LimitConfig.VarBytes]. The default is sixteen mebibytes.
+	TotalVarBytes int64
+
+	// Fields is the maximum number of fields a word may expand to,
+	// as per [expand.Config.MaxFields]. The default is 100000.
+	Fields int
+}
+
+// SandboxLimits is like [Sandbox], but with the given limits rather than
+// the default ones.
+func SandboxLimits(cfg SandboxConfig) RunnerOption {
+	return func(r *Runner) error {
+		if cfg.Timeout < 0 || cfg.LoopIterations < 0 || cfg.FuncDepth < 0 ||
+			cfg.VarBytes < 0 || cfg.TotalVarBytes < 0 || cfg.Fields < 0 {
+			return fmt.Errorf("sandbox limits cannot be negative: %+v", cfg)
+		}
+		setDefault(&cfg.Timeout, 10*time.Second)
+		setDefault(&cfg.LoopIterations, 100_000)
+		setDefault(&cfg.FuncDepth, 1000)
+		setDefault(&cfg.VarBytes, 1<<20)       // 1MiB
+		setDefault(&cfg.TotalVarBytes, 16<<20) // 16MiB
+		setDefault(&cfg.Fields, 100_000)
+		r.sandbox = &sandbox{cfg: cfg}
+		r.openHandler = sandboxOpen
+		r.readDirHandler = sandboxReadDir
+		r.statHandler = sandboxStat
+		return nil
+	}
+}
+
+func setDefault[T comparable](field *T, value T) {
+	var zero T
+	if *field == zero {
+		*field = value
+	}
+}
+
+// sandbox holds the limits of a sandboxed runner and its state for a single
+// call to Run, shared with any subshells.
+type sandbox struct {
+	cfg        SandboxConfig
+	iterations atomic.Int64
+}
+
+// sandboxLoop counts a loop iteration, reporting false and stopping the
+// runner if there have been too many.
+func (r *Runner) sandboxLoop() bool {
+	if r.sandbox == nil || r.sandbox.iterations.Add(1) <= r.sandbox.cfg.LoopIterations {
+		return true
+	}
+	r.setErr(fmt.Errorf("%w: more than %d loop iterations", ErrSandboxLimit, r.sandbox.cfg.LoopIterations))
+	return false
+}
+
+// sandboxFields returns the maximum number of fields a word may expand to,
+// as per [expand.Config.MaxFields].
+func (r *Runner) sandboxFields() int {
+	if r.sandbox == nil {
+		return 0
+	}
+	return r.sandbox.cfg.Fields
+}
+
+// sandboxVar reports whether a variable value fits in the sandbox,
+// and whether all the variables still do once it is set,
+// stopping the runner if they do not.
+func (r *Runner) sandboxVar(name string, vr expand.Variable) bool {
+	if r.sandbox == nil {
+		return true
+	}
+	cfg := r.sandbox.cfg
+	if varSize(name, vr) > cfg.VarBytes {
+		r.setErr(fmt.Errorf("%w: %s: value larger than %d bytes", ErrSandboxLimit, name, cfg.VarBytes))
+		return false
+	}
+	if size, ok := r.varsSize(name, vr); ok && size > cfg.TotalVarBytes {
+		r.setErr(fmt.Errorf("%w: more than %d bytes of variables", ErrSandboxLimit, cfg.TotalVarBytes))
+		return false
+	}
+	return true
+}
+
+// sandboxOpen, sandboxReadDir, and sandboxStat are the handlers set by
+// [SandboxLimits], denying access to the host's filesystem.
+// Directories are read as empty, so that globs match nothing.
+func sandboxOpen(ctx context.Context, path string, flag int, perm os.FileMode) (io.ReadWriteCloser, error) {
+	if filepath.ToSlash(path) == "/dev/null" {
+		return devNull{}, nil
+	}
+	return nil, &os.PathError{Op: "open", Path: path, Err: fs.ErrPermission}
+}
+
+func sandboxReadDir(ctx context.Context, path string) ([]fs.DirEntry, error) {
+	return nil, nil
+}
+
+func sandboxStat(ctx context.Context, path string, followSymlinks bool) (fs.FileInfo, error) {
+	return nil, &os.PathError{Op: "stat", Path: path, Err: fs.ErrPermission}
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// SignalConfig configures how a [Runner] handles the signals received by the
+// current process, and how it stops the programs it runs. See [Signals].
+type SignalConfig struct {
+	// Catch lists the signals to catch while [Runner.Run] executes,
+	// such as [os.Interrupt] and [syscall.SIGTERM].
+	// Signals which are not caught are handled as usual by the Go runtime,
+	// which typically terminates the current process without stopping the
+	// programs being run.
+	//
+	// A caught signal is handled like a shell would do for the script:
+	// if the script set a trap for it, such as "trap 'echo bye' INT",
+	// the trap runs once the current command finishes.
+	// If the script ignores it, such as via "trap '' INT", nothing happens.
+	// Otherwise, the running programs are stopped as if the context had been
+	// cancelled, any exit trap runs, and Run returns an exit status of 128
+	// plus the signal number.
+	Catch []os.Signal
+
+	// Forward sends each caught signal which is not ignored by the script
+	// to the programs running at the time.
+	Forward bool
+
+	// ProcessGroup starts each program in a new process group, so that
+	// signals sent to a program, including those to stop it when the context
+	// is cancelled, also reach any processes it started in turn.
+	// It only has an effect on Unix-like systems.
+	ProcessGroup bool
+
+	// KillTimeout is how long to wait after interrupting a program before
+	// killing it, replacing the two seconds used by the default exec handler.
+	// A negative value kills programs immediately; zero keeps the default.
+	KillTimeout time.Duration
+}
+
+// Signals sets how the runner handles signals, such as an interrupt when the
+// user presses Ctrl-C. By default, signals are not caught by the runner,
+// programs share the process group of the current process, and cancelling
+// the context interrupts them and kills them after two seconds.
+func Signals(cfg SignalConfig) RunnerOption {
+	return func(r *Runner) error {
+		r.signalCfg = &cfg
+		return nil
+	}
+}
+
+// trapSignals are the signals which can be trapped by name or number,
+// besides the EXIT and ERR pseudo-signals.
+var trapSignals = []struct {
+	name string
+	sig  syscall.Signal
+}{
+	{"HUP", syscall.Signal(1)}, // syscall.SIGHUP is missing on js/wasm
+	{"INT", syscall.SIGINT},
+	{"QUIT", syscall.SIGQUIT},
+	{"TERM", syscall.SIGTERM},
+}
+
+// trapSignal parses a signal specification as given to the trap builtin,
+// such as "INT", "SIGINT", or "2".
+func trapSignal(spec string) (syscall.Signal, bool) {
+	if n, err := strconv.Atoi(spec); err == nil {
+		for _, ts := range trapSignals {
+			if int(ts.sig) == n {
+				return ts.sig, true
+			}
+		}
+		return 0, false
+	}
+	spec = strings.TrimPrefix(strings.ToUpper(spec), "SIG")
+	for _, ts := range trapSignals {
+		if ts.name == spec {
+			return ts.sig, true
+		}
+	}
+	return 0, false
+}
+
+func signalName(sig syscall.Signal) string {
+	for _, ts := range trapSignals {
+		if ts.sig == sig {
+			return ts.name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
+// signalState holds the signals caught during a single call to Run,
+// shared with any subshells.
+type signalState struct {
+	owner *Runner // the runner whose traps are used
+
+	mu      sync.Mutex
+	traps   map[syscall.Signal]string // a copy of owner.callbackSignals
+	pending []syscall.Signal          // trapped, but the trap has not run yet
+	procs   map[*os.Process]bool      // running programs; true if they lead a group
+}
+
+// signalCause is the cause of the context cancellation when a caught signal
+// stops the runner.
+type signalCause struct{ sig syscall.Signal }
+
+func (c signalCause) Error() string { return "caught signal " + signalName(c.sig) }
+
+// catchSignals starts catching the configured signals for a call to Run,
+// returning the context to use and a func to stop catching them.
+func (r *Runner) catchSignals(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	st := &signalState{owner: r, procs: make(map[*os.Process]bool)}
+	r.signals = st
+	r.syncTraps()
+
+	ch := make(chan os.Signal, 1)
+	// Note that Notify without any signals would catch all of them.
+	if len(r.signalCfg.Catch) > 0 {
+		signal.Notify(ch, r.signalCfg.Catch...)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for osig := range ch {
+			sig, ok := osig.(syscall.Signal)
+			if !ok {
+				continue
+			}
+			st.mu.Lock()
+			trap, trapped := st.traps[sig]
+			ignored := trapped && trap == ""
+			if trapped && !ignored {
+				st.pending = append(st.pending, sig)
+			}
+			if r.signalCfg.Forward && !ignored {
+				for proc, group := range st.procs {
+					_ = signalProcess(proc, sig, group)
+				}
+			}
+			st.mu.Unlock()
+			if !trapped {
+				cancel(signalCause{sig})
+			}
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(ch)
+		close(ch)
+		wg.Wait()
+		cancel(nil)
+	}
+}
+
+// syncTraps lets the signal catching goroutine know about the traps set by
+// the runner, if it is the one catching signals.
+func (r *Runner) syncTraps() {
+	st := r.signals
+	if st == nil || st.owner != r {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.traps = make(map[syscall.Signal]string, len(r.callbackSignals))
+	for sig, trap := range r.callbackSignals {
+		st.traps[sig] = trap
+	}
+}
+
+// runTraps runs the traps for any signals caught since the last call,
+// preserving the exit status of the last command.
+func (r *Runner) runTraps(ctx context.Context) {
+	st := r.signals
+	if st == nil || st.owner != r || r.handlingTrap {
+		return
+	}
+	st.mu.Lock()
+	pending := st.pending
+	st.pending = nil
+	st.mu.Unlock()
+	for _, sig := range pending {
+		exit := r.exit
+		r.trapCallback(ctx, r.callbackSignals[sig], signalName(sig))
+		if r.Exited() {
+			return
+		}
+		r.exit = exit
+		r.lastExit = exit
+	}
+}
+
+// trackProcess records a running program, so that caught signals can be
+// forwarded to it, returning a func to call once the program has finished.
+func (r *Runner) trackProcess(proc *os.Process, group bool) (done func()) {
+	st := r.signals
+	if st == nil {
+		return func() {}
+	}
+	st.mu.Lock()
+	st.procs[proc] = group
+	st.mu.Unlock()
+	return func() {
+		st.mu.Lock()
+		delete(st.procs, proc)
+		st.mu.Unlock()
+	}
+}
+
+// signalled reports whether the runner was stopped by a caught signal, in
+// which case it replaces the context error with the appropriate exit status.
+func (r *Runner) signalled(ctx context.Context) bool {
+	var cause signalCause
+	if r.err == nil || !errors.As(context.Cause(ctx), &cause) {
+		return false
+	}
+	r.err = nil
+	r.exit = 128 + int(cause.sig)
+	return true
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"regexp"
+	"strings"
 
 	"golang.org/x/term"
 
@@ -39,12 +40,24 @@ func (r *Runner) bashTest(ctx context.Context, expr syntax.TestExpr, classic boo
 					return "1"
 				}
 			} else { // [[
+				// Like Bash, always allow extended globbing operators.
+				extGlob := r.ecfg.ExtGlob
+				r.ecfg.ExtGlob = true
 				pattern := r.pattern(yw)
-				if match(pattern, str) == (x.Op != syntax.TsNoMatch) {
+				r.ecfg.ExtGlob = extGlob
+				if r.match(pattern, str, true) == (x.Op != syntax.TsNoMatch) {
 					return "1"
 				}
 			}
 			return ""
+		case syntax.TsReMatch:
+			if !classic {
+				str := r.literal(x.X.(*syntax.Word))
+				if r.regexpMatch(str, r.regexpPattern(x.Y.(*syntax.Word))) {
+					return "1"
+				}
+				return ""
+			}
 		}
 		if r.binTest(ctx, x.Op, r.bashTest(ctx, x.X, classic), r.bashTest(ctx, x.Y, classic)) {
 			return "1"
@@ -59,6 +72,53 @@ func (r *Runner) bashTest(ctx context.Context, expr syntax.TestExpr, classic boo
 	return ""
 }
 
+// regexpPattern expands the right-hand side of a "=~" test into a regular
+// expression. Like in Bash, quoted characters match literally,
+// unless the "compat31" option is set.
+func (r *Runner) regexpPattern(word *syntax.Word) string {
+	if r.opts[optCompat31] {
+		return r.literal(word)
+	}
+	var sb strings.Builder
+	for _, wp := range word.Parts {
+		switch wp := wp.(type) {
+		case *syntax.Lit:
+			s := wp.Value
+			for i := 0; i < len(s); i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+					sb.WriteString(regexp.QuoteMeta(s[i : i+1]))
+				} else {
+					sb.WriteByte(s[i])
+				}
+			}
+		case *syntax.SglQuoted, *syntax.DblQuoted:
+			sb.WriteString(regexp.QuoteMeta(r.literal(&syntax.Word{Parts: []syntax.WordPart{wp}})))
+		default:
+			sb.WriteString(r.literal(&syntax.Word{Parts: []syntax.WordPart{wp}}))
+		}
+	}
+	return sb.String()
+}
+
+// regexpMatch reports whether str matches the regular expression expr,
+// setting BASH_REMATCH to the matched text and capture groups.
+// Like POSIX extended regular expressions, the leftmost-longest match is used.
+func (r *Runner) regexpMatch(str, expr string) bool {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		r.exit = 2
+		return false
+	}
+	re.Longest()
+	groups := re.FindStringSubmatch(str)
+	if groups == nil {
+		groups = []string{}
+	}
+	r.setVar("BASH_REMATCH", nil, expand.Variable{Kind: expand.Indexed, List: groups})
+	return len(groups) > 0
+}
+
 func (r *Runner) binTest(ctx context.Context, op syntax.BinTestOperator, x, y string) bool {
 	switch op {
 	case syntax.TsReMatch:
@@ -106,9 +166,9 @@ func (r *Runner) binTest(ctx context.Context, op syntax.BinTestOperator, x, y st
 	case syntax.OrTest:
 		return x != "" || y != ""
 	case syntax.TsBefore:
-		return x < y
+		return r.locale.compare(x, y) < 0
 	default: // syntax.TsAfter
-		return x > y
+		return r.locale.compare(x, y) > 0
 	}
 }
 
@@ -2,46 +2,90 @@ package interp
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/syntax"
 )
 
+// XTraceRecord is a line of trace output written by the "xtrace" option
+// when using the [XTraceJSON] format, encoded as a JSON object.
+//
+// The format is stable; new fields may be added in the future, but existing
+// fields will not be removed nor change their meaning.
+type XTraceRecord struct {
+	// Time is when the traced command was about to run.
+	Time time.Time `json:"time"`
+
+	// File, Line, and Col are the position of the traced command.
+	// File is only set if the runner was given a [syntax.File] with a name.
+	File string `json:"file,omitempty"`
+	Line uint   `json:"line"`
+	Col  uint   `json:"col"`
+
+	// Args holds the traced words after expansion, such as the name and
+	// arguments of a simple command, or an assignment like "foo=bar".
+	// Compound commands like "for" and "case" hold their keywords too.
+	Args []string `json:"args"`
+
+	// Level is the nesting level of the command, starting at 1 and
+	// increasing by one within each command substitution.
+	// It is how many times "+" is repeated in the text format.
+	Level int `json:"level"`
+
+	// Func is the name of the function being run, if any.
+	Func string `json:"func,omitempty"`
+
+	// Text is the line as written in the [XTraceText] format,
+	// starting with the expansion of $PS4 and without a trailing newline.
+	// It is only set for an [XTraceHandlerFunc], and not encoded as JSON.
+	Text string `json:"-"`
+}
+
 // tracer prints expressions like a shell would do if its
 // options '-o' is set to either 'xtrace' or its shorthand, '-x'.
+//
+// Each line is built both as text, like Bash would print it,
+// and as a list of words for [XTraceRecord].
 type tracer struct {
-	buf       bytes.Buffer
-	printer   *syntax.Printer
-	output    io.Writer
-	needsPlus bool
+	r       *Runner
+	buf     bytes.Buffer
+	printer *syntax.Printer
+
+	pos  syntax.Pos
+	args []string
 }
 
-func (r *Runner) tracer() *tracer {
+func (r *Runner) tracer(pos syntax.Pos) *tracer {
 	if !r.opts[optXTrace] {
 		return nil
 	}
 
 	return &tracer{
-		printer:   syntax.NewPrinter(),
-		output:    r.stderr,
-		needsPlus: true,
+		r:       r,
+		printer: syntax.NewPrinter(),
+		pos:     pos,
 	}
 }
 
-// string writes s to tracer.buf if tracer is non-nil,
-// prepending "+" if tracer.needsPlus is true.
+// text writes s to the text form of the current line.
+func (t *tracer) text(s string) {
+	t.buf.WriteString(s)
+}
+
+// string writes s to the current line if tracer is non-nil.
 func (t *tracer) string(s string) {
 	if t == nil {
 		return
 	}
 
-	if t.needsPlus {
-		t.buf.WriteString("+ ")
-	}
-	t.needsPlus = false
-	t.buf.WriteString(s)
+	t.text(s)
+	t.args = append(t.args, strings.Fields(s)...)
 }
 
 func (t *tracer) stringf(f string, a ...any) {
@@ -52,42 +96,123 @@ func (t *tracer) stringf(f string, a ...any) {
 	t.string(fmt.Sprintf(f, a...))
 }
 
-// expr prints x to tracer.buf if tracer is non-nil,
-// prepending "+" if tracer.isFirstPrint is true.
+// expr prints x to the current line if tracer is non-nil.
 func (t *tracer) expr(x syntax.Node) {
 	if t == nil {
 		return
 	}
 
-	if t.needsPlus {
-		t.buf.WriteString("+ ")
+	var sb strings.Builder
+	if err := t.printer.Print(&sb, x); err != nil {
+		panic(err)
 	}
-	t.needsPlus = false
-	if err := t.printer.Print(&t.buf, x); err != nil {
+	t.text(sb.String())
+	t.args = append(t.args, sb.String())
+}
+
+// quoted writes s quoted as a single word, like [tracer.string].
+func (t *tracer) quoted(s string) {
+	if t == nil {
+		return
+	}
+
+	qs, err := syntax.Quote(s, syntax.LangBash)
+	if err != nil { // should never happen
 		panic(err)
 	}
+	t.text(qs)
+	t.args = append(t.args, s)
 }
 
-// flush writes the contents of tracer.buf to the tracer.stdout.
-func (t *tracer) flush() {
+// assign writes an assignment of a string value, like [tracer.string].
+func (t *tracer) assign(name, value string) {
 	if t == nil {
 		return
 	}
 
-	t.output.Write(t.buf.Bytes())
-	t.buf.Reset()
+	qs, err := syntax.Quote(value, syntax.LangBash)
+	if err != nil { // should never happen
+		panic(err)
+	}
+	t.text(name + "=" + qs)
+	t.args = append(t.args, name+"="+value)
 }
 
-// newLineFlush is like flush, but with extra new line before tracer.buf gets flushed.
+// newLineFlush ends the current line, writing it out.
+// Empty lines, such as those from set, are not written at all.
 func (t *tracer) newLineFlush() {
 	if t == nil {
 		return
 	}
+	defer func() {
+		t.buf.Reset()
+		t.args = nil
+	}()
+	if len(t.args) == 0 {
+		return
+	}
 
-	t.buf.WriteString("\n")
-	t.flush()
-	// reset state
-	t.needsPlus = true
+	r := t.r
+	rec := XTraceRecord{
+		Time:  time.Now(),
+		File:  r.filename,
+		Line:  t.pos.Line(),
+		Col:   t.pos.Col(),
+		Args:  t.args,
+		Level: r.xtraceLevel + 1,
+	}
+	if n := len(r.funcNames); n > 0 {
+		rec.Func = r.funcNames[n-1]
+	}
+	if r.xtraceHandler == nil && r.xtraceFormat == XTraceJSON {
+		enc := json.NewEncoder(r.stderr)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(rec); err != nil { // should never happen
+			panic(err)
+		}
+		return
+	}
+	rec.Text = t.prefix(rec.Level) + t.buf.String()
+	if r.xtraceHandler != nil {
+		r.xtraceHandler(r.handlerCtx(r.ectx), rec)
+		return
+	}
+	io.WriteString(r.stderr, rec.Text+"\n")
+}
+
+// prefix expands $PS4 for the current line, repeating its first character
+// once per nesting level like Bash does.
+func (t *tracer) prefix(level int) string {
+	r := t.r
+	vr := r.lookupVar("PS4")
+	if !vr.IsSet() {
+		return strings.Repeat("+", level) + " "
+	}
+	word, err := syntax.NewParser().Document(strings.NewReader(vr.String()))
+	if err != nil || word == nil {
+		return ""
+	}
+	// $LINENO refers to the traced command, not to where $PS4 was parsed.
+	syntax.Walk(word, func(node syntax.Node) bool {
+		if pe, ok := node.(*syntax.ParamExp); ok && pe.Param.Value == "LINENO" {
+			pe.Dollar = syntax.NewPos(pe.Dollar.Offset(), t.pos.Line(), pe.Dollar.Col())
+		}
+		return true
+	})
+
+	// Don't trace any commands run while expanding $PS4,
+	// nor let them change the exit status of the traced command.
+	lastExpandExit := r.lastExpandExit
+	r.opts[optXTrace] = false
+	ps4, _ := expand.Document(r.ecfg, word)
+	r.opts[optXTrace] = true
+	r.lastExpandExit = lastExpandExit
+
+	if ps4 == "" {
+		return ""
+	}
+	first, size := utf8.DecodeRuneInString(ps4)
+	return strings.Repeat(string(first), level) + ps4[size:]
 }
 
 // call prints a command and its arguments with varying formats depending on the cmd type,
@@ -97,23 +222,23 @@ func (t *tracer) call(cmd string, args ...string) {
 	if t == nil {
 		return
 	}
+	if cmd == "set" {
+		// TODO: only first occurrence of set is not printed, succeeding calls are printed
+		return
+	}
+	t.args = append(append(t.args, cmd), args...)
 
 	s := strings.Join(args, " ")
 	if strings.TrimSpace(s) == "" {
 		// fields may be empty for function () {} declarations
-		t.string(cmd)
+		t.text(cmd)
 	} else if isBuiltin(cmd) {
-		if cmd == "set" {
-			// TODO: only first occurrence of set is not printed, succeeding calls are printed
-			return
-		}
-
 		qs, err := syntax.Quote(s, syntax.LangBash)
 		if err != nil { // should never happen
 			panic(err)
 		}
-		t.stringf("%s %s", cmd, qs)
+		t.text(cmd + " " + qs)
 	} else {
-		t.stringf("%s %s", cmd, s)
+		t.text(cmd + " " + s)
 	}
 }
@@ -4,8 +4,13 @@
 package interp
 
 import (
+	"context"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"mvdan.cc/sh/v3/syntax"
 )
 
 func TestElapsedString(t *testing.T) {
@@ -43,3 +48,87 @@ func TestElapsedString(t *testing.T) {
 		})
 	}
 }
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	src := `
+case $1 in
+"a b" | c*) echo static ;;
+$2) echo dynamic ;;
+~) echo home ;;
+esac
+((x = 1 << 4))
+((1 << 4))
+let 2+3 y
+for ((i = 0; i < 3; i++)); do :; done
+arr=([2*3]=x)
+`
+	file, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "a b" and c*, both with and without extglob.
+	if got := len(prog.patterns); got != 4 {
+		t.Errorf("want 4 compiled patterns, got %d", got)
+	}
+	var arithms []int
+	for _, n := range prog.arithms {
+		arithms = append(arithms, n)
+	}
+	// "1 << 4", "2+3", and "2*3", as the rest use variables.
+	if got := len(arithms); got != 3 {
+		t.Errorf("want 3 evaluated expressions, got %d: %v", got, arithms)
+	}
+
+	file, err = syntax.NewParser(syntax.Variant(syntax.LangBats)).Parse(strings.NewReader("@test foo { :; }"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Errorf("want an error loading a test declaration")
+	}
+}
+
+func TestProgramConcurrent(t *testing.T) {
+	t.Parallel()
+
+	src := `case $1 in a*) echo $((1 << 4)) $1 ;; *) echo other ;; esac`
+	file, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for _, arg := range []string{"abc", "xyz", "a", "b"} {
+		arg := arg
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var sb strings.Builder
+			r, err := New(StdIO(nil, &sb, &sb), Params(arg))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := prog.Run(context.Background(), r); err != nil {
+				t.Error(err)
+			}
+			want := "other\n"
+			if arg[0] == 'a' {
+				want = "16 " + arg + "\n"
+			}
+			if got := sb.String(); got != want {
+				t.Errorf("Run with %q: want %q, got %q", arg, want, got)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/creack/pty"
@@ -152,6 +153,180 @@ func TestRunnerTerminalExec(t *testing.T) {
 	}
 }
 
-func shortPathName(path string) (string, error) {
-	panic("only works on windows")
+func TestRunnerPseudoTerminal(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		opts []interp.RunnerOption
+		in   string
+		src  string
+		want string
+	}{
+		{
+			"Pipe", nil, "",
+			"$GOSH_PROG 'for n in 0 1 2; do if [[ -t $n ]]; then echo -n $n; fi; done; echo end'",
+			"end\n",
+		},
+		{
+			"Pseudo", []interp.RunnerOption{interp.PseudoTerminal()}, "",
+			"$GOSH_PROG 'for n in 0 1 2; do if [[ -t $n ]]; then echo -n $n; fi; done; echo end'",
+			"012end\r\n",
+		},
+		{
+			"SeparateStderr", []interp.RunnerOption{interp.PseudoTerminal()}, "",
+			"$GOSH_PROG 'for n in 0 1 2; do if [[ -t $n ]]; then echo -n $n; fi; done; echo end' 2>/dev/null",
+			"01end\r\n",
+		},
+		{
+			"Input", []interp.RunnerOption{interp.PseudoTerminal()}, "foo\n",
+			"$GOSH_PROG 'read x; echo got $x'; echo done",
+			"foo\r\ngot foo\r\ndone\n",
+		},
+		{
+			"InputEOF", []interp.RunnerOption{interp.PseudoTerminal()}, "",
+			"$GOSH_PROG 'read x; echo $?'",
+			"1\r\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+			var out strings.Builder
+			opts := append(test.opts, interp.StdIO(strings.NewReader(test.in), &out, &out))
+			r, err := interp.New(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Run(context.Background(), parse(t, nil, test.src)); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != test.want {
+				t.Fatalf("\nwant: %q\ngot:  %q", test.want, got)
+			}
+		})
+	}
+}
+
+func TestRunnerJobControl(t *testing.T) {
+	t.Parallel()
+	// The shell needs a controlling terminal, so it runs in a new session.
+	primary, secondary, err := pty.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	prog := os.Getenv("GOSH_PROG")
+	cmd := exec.Command(prog, `
+		GOSH_CMD= $GOSH_PROG 'kill -STOP $$; echo resumed'
+		[[ $? -gt 128 ]] && echo stopped
+		jobs; fg; echo "done $?"; jobs
+		fg; bg %2; echo $?
+	`)
+	cmd.Env = append(os.Environ(), "GOSH_CMD=job_control")
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = secondary, secondary, secondary
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	secondary.Close()
+	// Reading the primary end fails once the shell has exited.
+	out, _ := io.ReadAll(primary)
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("%v: %q", err, out)
+	}
+	job := prog + " kill -STOP $$; echo resumed"
+	want := "\r\n[1]+  Stopped                 " + job + "\r\n" +
+		"stopped\r\n" +
+		"[1]+  Stopped                 " + job + "\r\n" +
+		job + "\r\n" +
+		"resumed\r\n" +
+		"done 0\r\n" +
+		"fg: current: no such job\r\n" +
+		"bg: %2: no such job\r\n" +
+		"1\r\n"
+	if got := string(out); got != want {
+		t.Fatalf("\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+// TestRunnerSignals is not parallel, as it sends signals to the test process.
+func TestRunnerProcessLimits(t *testing.T) {
+	t.Parallel()
+
+	// Setting the limit to its current value does not affect other tests.
+	const src = "ulimit -Sn $(ulimit -Sn); echo $?"
+	for _, enabled := range []bool{false, true} {
+		var out strings.Builder
+		r, err := interp.New(interp.StdIO(nil, &out, &out), interp.ProcessLimits(enabled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Run(context.Background(), parse(t, nil, src)); err != nil {
+			t.Fatal(err)
+		}
+		want := "ulimit: open files: cannot modify limit: permission denied\n1\n"
+		if enabled {
+			want = "0\n"
+		}
+		if got := out.String(); got != want {
+			t.Errorf("ProcessLimits(%v): want %q, got %q", enabled, want, got)
+		}
+	}
+}
+
+func TestRunnerSignals(t *testing.T) {
+	catch := []os.Signal{syscall.SIGHUP, syscall.SIGTERM}
+	tests := []struct {
+		name string
+		cfg  interp.SignalConfig
+		src  string
+		want string
+	}{
+		{
+			"Trap", interp.SignalConfig{Catch: catch, Forward: true},
+			"trap 'echo trapped; exit 3' HUP; kill -HUP $$; sleep 5; echo unreachable",
+			"trapped\nexit status 3",
+		},
+		{
+			"TrapKeepsStatus", interp.SignalConfig{Catch: catch},
+			"trap 'echo trapped' HUP; kill -HUP $$; sleep 0.1; false; echo $?",
+			"trapped\n1\n",
+		},
+		{
+			"Ignore", interp.SignalConfig{Catch: catch, Forward: true},
+			"trap '' HUP; kill -HUP $$; sleep 0.1; echo still",
+			"still\n",
+		},
+		{
+			"Default", interp.SignalConfig{Catch: catch, KillTimeout: -1},
+			"trap 'echo at_exit' EXIT; kill -TERM $$; sleep 5; echo unreachable",
+			"at_exit\nexit status 143",
+		},
+		{
+			"NoGroup", interp.SignalConfig{},
+			"$GOSH_PROG 'pgid=$(ps -o pgid= -p $$); [[ ${pgid// /} == $$ ]] || echo shared'",
+			"shared\n",
+		},
+		{
+			"ProcessGroup", interp.SignalConfig{ProcessGroup: true},
+			"$GOSH_PROG 'pgid=$(ps -o pgid= -p $$); [[ ${pgid// /} == $$ ]] && echo leader'",
+			"leader\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var out strings.Builder
+			r, err := interp.New(interp.StdIO(nil, &out, &out), interp.Signals(test.cfg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Run(context.Background(), parse(t, nil, test.src)); err != nil {
+				out.WriteString(err.Error())
+			}
+			if got := out.String(); got != test.want {
+				t.Fatalf("\nwant: %q\ngot:  %q", test.want, got)
+			}
+		})
+	}
 }
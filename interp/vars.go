@@ -6,11 +6,13 @@ package interp
 import (
 	"fmt"
 	"maps"
+	"math/rand"
 	"os"
 	"runtime"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/syntax"
@@ -23,6 +25,9 @@ type overlayEnviron struct {
 	// We need to know if the current scope is a function's scope, because
 	// functions can modify global variables.
 	funcScope bool
+
+	// size is the approximate memory used by values, as given by varSize.
+	size int64
 }
 
 func (o *overlayEnviron) Get(name string) expand.Variable {
@@ -37,11 +42,10 @@ func (o *overlayEnviron) Set(name string, vr expand.Variable) error {
 	if o.funcScope && !vr.Local && !o.values[name].Local {
 		if vr.IsSet() {
 			// "foo=bar" on a global var in a function updates the global scope
-		} else if vr.Exported || vr.ReadOnly {
+		} else if hasAttrs(vr) {
 			// "foo=bar" followed by "export foo" or "readonly foo"
 			prev := o.Get(name)
-			prev.Exported = prev.Exported || vr.Exported
-			prev.ReadOnly = prev.ReadOnly || vr.ReadOnly
+			addAttrs(&prev, vr)
 			vr = prev
 		}
 		// In a function, the parent environment is ours, so it's always read-write.
@@ -52,11 +56,19 @@ func (o *overlayEnviron) Set(name string, vr expand.Variable) error {
 	if o.values == nil {
 		o.values = make(map[string]expand.Variable)
 	}
-	if !vr.IsSet() && (vr.Exported || vr.Local || vr.ReadOnly) {
+	old, hadOld := o.values[name]
+	defer func() {
+		if hadOld {
+			o.size -= varSize(name, old)
+		}
+		if vr, ok := o.values[name]; ok {
+			o.size += varSize(name, vr)
+		}
+	}()
+	if !vr.IsSet() && (vr.Local || hasAttrs(vr)) {
 		// marking as exported/local/readonly
-		prev.Exported = prev.Exported || vr.Exported
 		prev.Local = prev.Local || vr.Local
-		prev.ReadOnly = prev.ReadOnly || vr.ReadOnly
+		addAttrs(&prev, vr)
 		vr = prev
 		o.values[name] = vr
 		return nil
@@ -71,9 +83,13 @@ func (o *overlayEnviron) Set(name string, vr expand.Variable) error {
 			return nil
 		}
 		delete(o.values, name)
-	} else if prev.Exported {
-		// variable is set and was marked as exported
-		vr.Exported = true
+	} else {
+		// variable is set, keeping attributes like being exported
+		addAttrs(&prev, vr)
+		vr.Exported = prev.Exported
+		vr.ReadOnly = prev.ReadOnly
+		vr.Integer = prev.Integer
+		vr.Lowercase, vr.Uppercase = prev.Lowercase, prev.Uppercase
 	}
 	// modifying the entire variable
 	vr.Local = prev.Local || vr.Local
@@ -81,6 +97,23 @@ func (o *overlayEnviron) Set(name string, vr expand.Variable) error {
 	return nil
 }
 
+// hasAttrs reports whether a variable has any attributes other than Local.
+func hasAttrs(vr expand.Variable) bool {
+	return vr.Exported || vr.ReadOnly || vr.Integer || vr.Lowercase || vr.Uppercase
+}
+
+// addAttrs adds the attributes of src other than Local to dst.
+// Since the lowercase and uppercase attributes are exclusive,
+// setting one of them in src replaces both in dst.
+func addAttrs(dst *expand.Variable, src expand.Variable) {
+	dst.Exported = dst.Exported || src.Exported
+	dst.ReadOnly = dst.ReadOnly || src.ReadOnly
+	dst.Integer = dst.Integer || src.Integer
+	if src.Lowercase || src.Uppercase {
+		dst.Lowercase, dst.Uppercase = src.Lowercase, src.Uppercase
+	}
+}
+
 func (o *overlayEnviron) Each(f func(name string, vr expand.Variable) bool) {
 	o.parent.Each(f)
 	for name, vr := range o.values {
@@ -90,6 +123,59 @@ func (o *overlayEnviron) Each(f func(name string, vr expand.Variable) bool) {
 	}
 }
 
+// snapshot returns a copy of the environment which no longer depends on any
+// of its overlay layers, so that it may be modified and read independently.
+// Variable values are shared, as setVar never modifies them in place.
+func (o *overlayEnviron) snapshot() *overlayEnviron {
+	var layers []*overlayEnviron
+	parent := expand.Environ(o)
+	for {
+		o2, ok := parent.(*overlayEnviron)
+		if !ok {
+			break
+		}
+		layers = append(layers, o2)
+		parent = o2.parent
+	}
+	values := make(map[string]expand.Variable)
+	for i := len(layers) - 1; i >= 0; i-- {
+		maps.Copy(values, layers[i].values)
+	}
+	var size int64
+	for name, vr := range values {
+		size += varSize(name, vr)
+	}
+	return &overlayEnviron{parent: parent, values: values, size: size}
+}
+
+// totalSize returns the approximate memory used by the values of all the
+// overlay layers, as given by varSize.
+func (o *overlayEnviron) totalSize() int64 {
+	size := int64(0)
+	for env := expand.Environ(o); ; {
+		o2, ok := env.(*overlayEnviron)
+		if !ok {
+			return size
+		}
+		size += o2.size
+		env = o2.parent
+	}
+}
+
+// varSize approximates the memory used by a variable, in bytes,
+// counting the length of its name and values plus an overhead for each string.
+func varSize(name string, vr expand.Variable) int64 {
+	const overhead = 16 // roughly, the size of a string header
+	size := int64(len(name) + len(vr.Str) + overhead)
+	for _, s := range vr.List {
+		size += int64(len(s) + overhead)
+	}
+	for k, s := range vr.Map {
+		size += int64(len(k) + len(s) + 2*overhead)
+	}
+	return size
+}
+
 func execEnv(env expand.Environ) []string {
 	list := make([]string, 0, 64)
 	env.Each(func(name string, vr expand.Variable) bool {
@@ -133,10 +219,38 @@ func (r *Runner) lookupVar(name string) expand.Variable {
 		vr.Kind, vr.Str = expand.String, strconv.Itoa(r.lastExit)
 	case "$":
 		vr.Kind, vr.Str = expand.String, strconv.Itoa(os.Getpid())
+	case "!":
+		if r.bgPID > 0 {
+			vr.Kind, vr.Str = expand.String, strconv.Itoa(r.bgPID)
+		}
 	case "PPID":
 		vr.Kind, vr.Str = expand.String, strconv.Itoa(os.Getppid())
 	case "DIRSTACK":
-		vr.Kind, vr.List = expand.Indexed, r.dirStack
+		// The top of the stack, the current directory, goes first.
+		vr.Kind, vr.List = expand.Indexed, slices.Clone(r.dirStack)
+		slices.Reverse(vr.List)
+	case "RANDOM":
+		vr.Kind, vr.Str = expand.String, strconv.Itoa(r.random.Intn(32768))
+	case "SECONDS":
+		secs := int64(r.now().Sub(r.startTime) / time.Second)
+		vr.Kind, vr.Str = expand.String, strconv.FormatInt(secs, 10)
+	case "EPOCHREALTIME":
+		now := r.now()
+		vr.Kind, vr.Str = expand.String, fmt.Sprintf("%d.%06d", now.Unix(), now.Nanosecond()/1000)
+	case "FUNCNAME", "BASH_SOURCE", "BASH_LINENO":
+		vr = r.callStackVar(name)
+	case "LINENO":
+		// Note that the expand package uses the position of $LINENO
+		// itself, so this is for cases like arithmetic expressions.
+		vr.Kind, vr.Str = expand.String, strconv.FormatUint(uint64(r.lineno), 10)
+	case "SRANDOM":
+		n := rand.Uint32()
+		if r.deterministic {
+			n = r.random.Uint32()
+		}
+		vr.Kind, vr.Str = expand.String, strconv.FormatUint(uint64(n), 10)
+	case "EPOCHSECONDS":
+		vr.Kind, vr.Str = expand.String, strconv.FormatInt(r.now().Unix(), 10)
 	case "0":
 		vr.Kind = expand.String
 		if r.filename != "" {
@@ -156,7 +270,8 @@ func (r *Runner) lookupVar(name string) expand.Variable {
 	if vr.IsSet() {
 		return vr
 	}
-	if vr := r.writeEnv.Get(name); vr.IsSet() {
+	vr = r.writeEnv.Get(name)
+	if vr.IsSet() {
 		return vr
 	}
 	if runtime.GOOS == "windows" {
@@ -165,7 +280,8 @@ func (r *Runner) lookupVar(name string) expand.Variable {
 			return vr
 		}
 	}
-	return expand.Variable{}
+	// Unset variables may still have attributes, like after "declare -i foo".
+	return vr
 }
 
 func (r *Runner) envGet(name string) string {
@@ -180,27 +296,131 @@ func (r *Runner) delVar(name string) {
 	}
 }
 
-func (r *Runner) setVarString(name, value string) {
-	r.setVar(name, nil, expand.Variable{Kind: expand.String, Str: value})
+func (r *Runner) setVarString(name, value string) bool {
+	return r.setVar(name, nil, expand.Variable{Kind: expand.String, Str: value})
+}
+
+// setVarRef is like setVarString, but the variable may also be an array
+// element given as "name[index]", like in "printf -v".
+// It returns false if ref is not a valid variable reference.
+func (r *Runner) setVarRef(ref, value string) bool {
+	name, index, isElem := strings.Cut(ref, "[")
+	if isElem {
+		var ok bool
+		if index, ok = strings.CutSuffix(index, "]"); !ok {
+			return false
+		}
+	}
+	if !syntax.ValidName(name) {
+		return false
+	}
+	vr := expand.Variable{Kind: expand.String, Str: value}
+	if !isElem {
+		r.setVar(name, nil, vr)
+		return true
+	}
+	var expr syntax.ArithmExpr
+	if _, cur := r.lookupVar(name).Resolve(r.writeEnv); cur.Kind == expand.Associative {
+		expr = &syntax.Word{Parts: []syntax.WordPart{&syntax.Lit{Value: index}}}
+	} else {
+		var err error
+		expr, err = syntax.NewParser().Arithmetic(strings.NewReader(index))
+		if err != nil || expr == nil {
+			return false
+		}
+	}
+	r.setVar(name, expr, vr)
+	return true
 }
 
-func (r *Runner) setVarInternal(name string, vr expand.Variable) {
+// setVarInternal sets a variable, reporting whether it succeeded.
+// If it failed, such as when the variable is read-only, the error is printed
+// and the exit status is set to 1.
+func (r *Runner) setVarInternal(name string, vr expand.Variable) bool {
 	if r.opts[optAllExport] {
 		vr.Exported = true
 	}
+	if !r.sandboxVar(name, vr) || !r.limitVars(name, vr) {
+		return false
+	}
 	if err := r.writeEnv.Set(name, vr); err != nil {
 		r.errf("%s: %v\n", name, err)
 		r.exit = 1
-		return
+		return false
 	}
+	return true
 }
 
-func (r *Runner) setVar(name string, index syntax.ArithmExpr, vr expand.Variable) {
+func (r *Runner) setVar(name string, index syntax.ArithmExpr, vr expand.Variable) (ok bool) {
+	if name == "DIRSTACK" {
+		// Like bash, allow replacing the directories in the stack,
+		// but not the current directory or the size of the stack.
+		if index != nil {
+			if i := r.arithm(index); i > 0 && i < len(r.dirStack) {
+				r.dirStack[len(r.dirStack)-1-i] = vr.Str
+			}
+		}
+		return true
+	}
+	if index == nil && vr.Kind == expand.String {
+		// Like Bash, assigning these does not set a value, and reading
+		// them keeps on producing new values.
+		switch name {
+		case "RANDOM":
+			seed, _ := strconv.ParseInt(vr.Str, 10, 64)
+			r.random = rand.New(rand.NewSource(seed))
+			return true
+		case "SECONDS":
+			secs, _ := strconv.ParseInt(vr.Str, 10, 64)
+			r.startTime = r.now().Add(-time.Duration(secs) * time.Second)
+			return true
+		}
+		if name == "OPTIND" {
+			// Like Bash, any assignment to OPTIND resets getopts,
+			// even if the value does not change.
+			r.optState = getopts{argidx: -1}
+		}
+	}
 	cur := r.lookupVar(name)
 	if name2, var2 := cur.Resolve(r.writeEnv); name2 != "" {
 		name = name2
 		cur = var2
 	}
+	if cur.ReadOnly {
+		// Don't evaluate integer values for read-only variables.
+		return r.setVarInternal(name, vr)
+	}
+	if r.auditHandler != nil && (vr.Exported || cur.Exported) {
+		defer func() {
+			if ok {
+				value := r.lookupVar(name).String()
+				r.audit(r.ectx, AuditEvent{Kind: AuditExport, Name: name, Value: value})
+			}
+		}()
+	}
+	vr.Integer = vr.Integer || cur.Integer
+	if !vr.Lowercase && !vr.Uppercase {
+		vr.Lowercase, vr.Uppercase = cur.Lowercase, cur.Uppercase
+	}
+	switch {
+	case !vr.Integer && !vr.Lowercase && !vr.Uppercase:
+	case vr.Kind == expand.String:
+		vr.Str = r.attrValue(vr, vr.Str)
+	case vr.Kind == expand.Indexed:
+		vr.List = slices.Clone(vr.List)
+		for i, s := range vr.List {
+			vr.List[i] = r.attrValue(vr, s)
+		}
+	case vr.Kind == expand.Associative:
+		vr.Map = maps.Clone(vr.Map)
+		for k, s := range vr.Map {
+			vr.Map[k] = r.attrValue(vr, s)
+		}
+	}
+	if name == "PATH" {
+		// Like Bash, forget the hashed commands whenever PATH is assigned.
+		clear(r.hashed)
+	}
 
 	if vr.Kind == expand.String && index == nil {
 		// When assigning a string to an array, fall back to the
@@ -217,44 +437,194 @@ func (r *Runner) setVar(name string, index syntax.ArithmExpr, vr expand.Variable
 		}
 	}
 	if index == nil {
-		r.setVarInternal(name, vr)
-		return
+		return r.setVarInternal(name, vr)
 	}
 
 	// from the syntax package, we know that value must be a string if index
 	// is non-nil; nested arrays are forbidden.
 	valStr := vr.Str
 
-	var list []string
 	switch cur.Kind {
 	case expand.String:
-		list = append(list, cur.Str)
-	case expand.Indexed:
-		// TODO: only clone when inside a subshell and getting a var from outside for the first time
-		list = slices.Clone(cur.List)
+		cur.List, cur.Holes = []string{cur.Str}, nil
 	case expand.Associative:
 		// if the existing variable is already an AssocArray, try our
 		// best to convert the key to a string
 		w, ok := index.(*syntax.Word)
 		if !ok {
-			return
+			return false
 		}
 		k := r.literal(w)
 
 		// TODO: only clone when inside a subshell and getting a var from outside for the first time
 		cur.Map = maps.Clone(cur.Map)
 		cur.Map[k] = valStr
-		r.setVarInternal(name, cur)
-		return
+		return r.setVarInternal(name, cur)
 	}
 	k := r.arithm(index)
-	for len(list) < k+1 {
-		list = append(list, "")
+	if k < 0 {
+		// Like Bash, negative indexes count back from the end.
+		if k += len(cur.List); k < 0 {
+			r.errf("%s: bad array subscript\n", name)
+			r.exit = 1
+			return false
+		}
 	}
-	list[k] = valStr
 	cur.Kind = expand.Indexed
-	cur.List = list
-	r.setVarInternal(name, cur)
+	return r.setVarInternal(name, setElem(cur, k, valStr))
+}
+
+// unsetVarElem unsets an element of an array given as "name[index]",
+// like in "unset 'a[1]'". It returns false if it failed.
+func (r *Runner) unsetVarElem(name, index string) bool {
+	if !syntax.ValidName(name) {
+		r.errf("unset: %s[%s]: not a valid identifier\n", name, index)
+		return false
+	}
+	cur := r.lookupVar(name)
+	if name2, var2 := cur.Resolve(r.writeEnv); name2 != "" {
+		name, cur = name2, var2
+	}
+	if cur.ReadOnly {
+		r.errf("unset: %s: cannot unset: readonly variable\n", name)
+		return false
+	}
+	switch cur.Kind {
+	case expand.Associative:
+		if _, ok := cur.Map[index]; ok {
+			cur.Map = maps.Clone(cur.Map)
+			delete(cur.Map, index)
+			return r.setVarInternal(name, cur)
+		}
+	case expand.String, expand.Indexed:
+		expr, err := syntax.NewParser().Arithmetic(strings.NewReader(index))
+		if err != nil || expr == nil {
+			r.errf("unset: %s[%s]: bad array subscript\n", name, index)
+			return false
+		}
+		i := r.arithm(expr)
+		if cur.Kind == expand.String {
+			// Like Bash, a string is like an array with one element.
+			if i == 0 || i == -1 {
+				r.delVar(name)
+			}
+			return true
+		}
+		if i < 0 {
+			i += len(cur.List)
+		}
+		return r.setVarInternal(name, unsetElem(cur, i))
+	}
+	return true
+}
+
+// setElem returns an indexed array with the element at an index set,
+// leaving holes for any elements before it which were not set,
+// like Bash does for sparse arrays such as after "a[5]=x".
+func setElem(vr expand.Variable, i int, val string) expand.Variable {
+	// Lists may be shared with subshells and clones, so copy them.
+	vr.List = slices.Clone(vr.List)
+	if n := len(vr.List); i >= n {
+		if i > n {
+			holes := make([]bool, i)
+			copy(holes, vr.Holes)
+			for j := n; j < i; j++ {
+				holes[j] = true
+			}
+			vr.Holes = holes
+		}
+		vr.List = append(vr.List, make([]string, i+1-n)...)
+	} else if !vr.IndexSet(i) {
+		vr.Holes = slices.Clone(vr.Holes)
+		vr.Holes[i] = false
+	}
+	vr.List[i] = val
+	return vr
+}
+
+// unsetElem returns an indexed array with the element at an index unset,
+// leaving a hole unless it is the last element.
+func unsetElem(vr expand.Variable, i int) expand.Variable {
+	if !vr.IndexSet(i) {
+		return vr
+	}
+	if i < len(vr.List)-1 {
+		vr.List = slices.Clone(vr.List)
+		vr.List[i] = ""
+		holes := make([]bool, len(vr.List))
+		copy(holes, vr.Holes)
+		holes[i] = true
+		vr.Holes = holes
+		return vr
+	}
+	// Drop the last element along with any holes before it.
+	for i > 0 && !vr.IndexSet(i-1) {
+		i--
+	}
+	vr.List = vr.List[:i:i]
+	vr.Holes = vr.Holes[:min(i, len(vr.Holes)):min(i, len(vr.Holes))]
+	if !slices.Contains(vr.Holes, true) {
+		vr.Holes = nil
+	}
+	return vr
+}
+
+// attrValue applies the attributes of a variable to a value being assigned to
+// it, such as evaluating it as an arithmetic expression if it is an integer.
+func (r *Runner) attrValue(vr expand.Variable, s string) string {
+	switch {
+	case vr.Integer:
+		expr, err := syntax.NewParser().Arithmetic(strings.NewReader(s))
+		if err != nil {
+			r.errf("%s: %v\n", s, err)
+			r.exit = 1
+			return "0"
+		}
+		if expr == nil {
+			return "0"
+		}
+		return strconv.Itoa(r.arithm(expr))
+	case vr.Lowercase:
+		return strings.Map(r.locale.toLower, s)
+	case vr.Uppercase:
+		return strings.Map(r.locale.toUpper, s)
+	}
+	return s
+}
+
+// callStackVar returns one of the arrays describing the call stack, with the
+// innermost call first. Like in Bash, when running a named file, the program
+// itself is at the bottom of the stack as "main", and $FUNCNAME is only set
+// within functions.
+func (r *Runner) callStackVar(name string) expand.Variable {
+	var vr expand.Variable
+	if name == "FUNCNAME" && len(r.funcNames) == 0 {
+		return vr
+	}
+	vr.Kind = expand.Indexed
+	vr.List = []string{}
+	for i := len(r.callStack) - 1; i >= 0; i-- {
+		frame := r.callStack[i]
+		switch name {
+		case "FUNCNAME":
+			vr.List = append(vr.List, frame.name)
+		case "BASH_SOURCE":
+			vr.List = append(vr.List, frame.source)
+		case "BASH_LINENO":
+			vr.List = append(vr.List, strconv.FormatUint(uint64(frame.line), 10))
+		}
+	}
+	if r.filename != "" {
+		switch name {
+		case "FUNCNAME":
+			vr.List = append(vr.List, "main")
+		case "BASH_SOURCE":
+			vr.List = append(vr.List, r.filename)
+		case "BASH_LINENO":
+			vr.List = append(vr.List, "0")
+		}
+	}
+	return vr
 }
 
 func (r *Runner) setFunc(name string, body *syntax.Stmt) {
@@ -262,6 +632,10 @@ func (r *Runner) setFunc(name string, body *syntax.Stmt) {
 		r.Funcs = make(map[string]*syntax.Stmt, 4)
 	}
 	r.Funcs[name] = body
+	if r.funcSources == nil {
+		r.funcSources = make(map[string]string, 4)
+	}
+	r.funcSources[name] = r.curSource()
 }
 
 func stringIndex(index syntax.ArithmExpr) bool {
@@ -292,12 +666,18 @@ func (r *Runner) assignVal(as *syntax.Assign, valType string) expand.Variable {
 		}
 		switch prev.Kind {
 		case expand.String:
+			if prev.Integer {
+				// Appending to an integer variable adds to it.
+				prev.Str = prev.Str + "+(" + s + ")"
+				break
+			}
 			prev.Str += s
 		case expand.Indexed:
-			if len(prev.List) == 0 {
-				prev.List = append(prev.List, "")
+			first := ""
+			if len(prev.List) > 0 {
+				first = prev.List[0]
 			}
-			prev.List[0] += s
+			prev = setElem(prev, 0, first+s)
 		case expand.Associative:
 			// TODO
 		}
@@ -353,27 +733,43 @@ func (r *Runner) assignVal(as *syntax.Assign, valType string) expand.Variable {
 		index += len(elemValues[i].values)
 		maxIndex = max(maxIndex, index)
 	}
-	// Flatten down the values.
+	// Flatten down the values, leaving holes for any indexes which were
+	// skipped, like in "([2]=x)".
 	strs := make([]string, maxIndex)
+	holes := make([]bool, maxIndex)
+	for i := range holes {
+		holes[i] = true
+	}
 	for _, ev := range elemValues {
 		for i, str := range ev.values {
 			strs[ev.index+i] = str
+			holes[ev.index+i] = false
 		}
 	}
+	if !slices.Contains(holes, true) {
+		holes = nil
+	}
 	if !as.Append {
 		prev.Kind = expand.Indexed
-		prev.List = strs
+		prev.List, prev.Holes = strs, holes
 		return prev
 	}
 	switch prev.Kind {
 	case expand.Unset:
 		prev.Kind = expand.Indexed
-		prev.List = strs
+		prev.List, prev.Holes = strs, holes
 	case expand.String:
 		prev.Kind = expand.Indexed
 		prev.List = append([]string{prev.Str}, strs...)
+		if holes != nil {
+			prev.Holes = append([]bool{false}, holes...)
+		}
 	case expand.Indexed:
-		prev.List = append(prev.List, strs...)
+		if holes != nil {
+			prev.Holes = append(slices.Clip(prev.Holes), make([]bool, len(prev.List)-len(prev.Holes))...)
+			prev.Holes = append(prev.Holes, holes...)
+		}
+		prev.List = append(slices.Clip(prev.List), strs...)
 	case expand.Associative:
 		// TODO
 	default:
@@ -381,3 +777,35 @@ func (r *Runner) assignVal(as *syntax.Assign, valType string) expand.Variable {
 	}
 	return prev
 }
+
+// printDecl prints variables in a form which can be reused as shell input,
+// like "declare -p". If no names are given, it prints all the variables which
+// have the attributes set in attrs.
+func (r *Runner) printDecl(cmd string, attrs expand.Variable, names []string) {
+	if len(names) == 0 {
+		seen := make(map[string]bool)
+		r.writeEnv.Each(func(name string, vr expand.Variable) bool {
+			seen[name] = true
+			return true
+		})
+		for name := range seen {
+			vr := r.lookupVar(name)
+			if (attrs.Exported && !vr.Exported) || (attrs.ReadOnly && !vr.ReadOnly) ||
+				(attrs.Integer && !vr.Integer) || (attrs.Lowercase && !vr.Lowercase) ||
+				(attrs.Uppercase && !vr.Uppercase) {
+				continue
+			}
+			names = append(names, name)
+		}
+		slices.Sort(names)
+	}
+	for _, name := range names {
+		vr := r.lookupVar(name)
+		if !vr.IsSet() && !hasAttrs(vr) {
+			r.errf("%s: %s: not found\n", cmd, name)
+			r.exit = 1
+			continue
+		}
+		r.outf("%s\n", expand.DeclString(name, vr))
+	}
+}
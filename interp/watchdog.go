@@ -0,0 +1,106 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package interp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Stall describes a statement which has been blocked on a pipe for too long,
+// as reported by a watchdog enabled via [Watchdog].
+type Stall struct {
+	// Stmt is the statement using the pipe, such as one side of a pipeline
+	// or the command of a coprocess.
+	Stmt *syntax.Stmt
+
+	// Fd is the statement's file descriptor which the pipe is connected to:
+	// 0 for standard input, and 1 or 2 for standard output or error.
+	Fd int
+
+	// Write is true if the statement was blocked writing to the pipe,
+	// and false if it was blocked reading from it.
+	Write bool
+
+	// Since is when the statement started being blocked.
+	Since time.Time
+}
+
+func (s Stall) String() string {
+	op := "reading from"
+	if s.Write {
+		op = "writing to"
+	}
+	return fmt.Sprintf("%s: blocked %s fd %d since %s",
+		s.Stmt.Pos(), op, s.Fd, s.Since.Format(time.RFC3339))
+}
+
+type watchdog struct {
+	threshold time.Duration
+	report    WatchdogFunc
+}
+
+// watchedPipe wraps one end of a pipe, reporting any reads or writes which
+// block for longer than the watchdog's threshold.
+type watchedPipe struct {
+	w     *watchdog
+	ctx   context.Context
+	stall Stall
+	rw    io.ReadWriteCloser
+
+	mu    sync.Mutex
+	timer *time.Timer
+}
+
+// watchReader wraps the reading end of a pipe used as a statement's stdin.
+func (r *Runner) watchReader(ctx context.Context, pr io.ReadWriteCloser, stmt *syntax.Stmt) io.ReadWriteCloser {
+	if r.watchdog == nil {
+		return pr
+	}
+	return &watchedPipe{w: r.watchdog, ctx: ctx, rw: pr, stall: Stall{Stmt: stmt, Fd: 0}}
+}
+
+// watchWriter wraps the writing end of a pipe used as a statement's stdout
+// or stderr, depending on fd.
+func (r *Runner) watchWriter(ctx context.Context, pw io.ReadWriteCloser, stmt *syntax.Stmt, fd int) io.ReadWriteCloser {
+	if r.watchdog == nil {
+		return pw
+	}
+	return &watchedPipe{w: r.watchdog, ctx: ctx, rw: pw, stall: Stall{Stmt: stmt, Fd: fd, Write: true}}
+}
+
+func (p *watchedPipe) start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stall := p.stall
+	stall.Since = time.Now()
+	p.timer = time.AfterFunc(p.w.threshold, func() { p.w.report(p.ctx, stall) })
+}
+
+func (p *watchedPipe) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.timer.Stop()
+}
+
+func (p *watchedPipe) Read(b []byte) (int, error) {
+	p.start()
+	defer p.stop()
+	return p.rw.Read(b)
+}
+
+func (p *watchedPipe) Write(b []byte) (int, error) {
+	p.start()
+	defer p.stop()
+	return p.rw.Write(b)
+}
+
+func (p *watchedPipe) Close() error {
+	return p.rw.Close()
+}
@@ -0,0 +1,38 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package pattern
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// MapCase applies a case mapping such as [unicode.ToUpper] to the characters
+// of s which match the pattern on their own, like Bash's "${name^^pattern}"
+// and "${name,,pattern}". Unless all is set, only the first character of s
+// is considered, like "${name^pattern}" and "${name,pattern}".
+//
+// An empty pattern matches any character, like "?".
+func MapCase(s, pat string, mode Mode, all bool, mapping func(rune) rune) (string, error) {
+	if pat == "" {
+		pat = "?"
+	}
+	m, err := Compile(pat, mode)
+	if err != nil {
+		return s, err
+	}
+	if !all {
+		r, size := utf8.DecodeRuneInString(s)
+		if size == 0 || !m.Match(s[:size]) {
+			return s, nil
+		}
+		return string(mapping(r)) + s[size:], nil
+	}
+	return strings.Map(func(r rune) rune {
+		if m.Match(string(r)) {
+			return mapping(r)
+		}
+		return r
+	}, s), nil
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package pattern
+
+import "strings"
+
+// Filter returns the names from a sequence which match the pattern with
+// [Filenames] semantics, such as the paths of a directory listing or archive.
+// Both the sequence of names and the returned sequence are iterators with
+// the same signature as iter.Seq[string], so they can be used with range
+// loops in Go 1.23 and later.
+//
+// The pattern is compiled once and matched per path segment, so each name
+// only needs to be split at its slashes. A segment of the pattern which is
+// exactly "**" matches any number of segments, and a trailing one matches
+// at least one. Slashes inside brace expressions or extended operator groups
+// never match.
+func Filter(pat string, mode Mode, names func(yield func(string) bool)) (func(yield func(string) bool), error) {
+	var segs []filterSegment
+	for _, seg := range splitSegments(pat, mode) {
+		if seg == "**" {
+			segs = append(segs, filterSegment{})
+			continue
+		}
+		m, err := Compile(seg, mode|Filenames)
+		if err != nil {
+			return nil, err
+		}
+		segs = append(segs, filterSegment{m: m})
+	}
+	return func(yield func(string) bool) {
+		names(func(name string) bool {
+			if !matchSegments(segs, name) {
+				return true // skip it
+			}
+			return yield(name)
+		})
+	}, nil
+}
+
+// filterSegment is a path segment of a pattern for [Filter],
+// where a nil matcher means "**".
+type filterSegment struct {
+	m *Matcher
+}
+
+// splitSegments splits a pattern at each slash which is not inside a bracket
+// expression, a brace expression, or an extended operator group. Escaped
+// slashes are also separators, as they match a slash too.
+func splitSegments(pat string, mode Mode) []string {
+	var segs []string
+	depth, start := 0, 0
+	for i := 0; i < len(pat); {
+		switch c := pat[i]; {
+		case c == '\\' && i+1 < len(pat) && pat[i+1] == '/' && depth == 0:
+			segs = append(segs, pat[start:i])
+			i += 2
+			start = i
+		case c == '/' && depth == 0:
+			segs = append(segs, pat[start:i])
+			i++
+			start = i
+		case mode&ExtendedOperators != 0 && extGroupStart(pat, i):
+			depth++
+			i += 2
+		case mode&Braces != 0 && c == '{':
+			depth++
+			i++
+		case (c == ')' || c == '}') && depth > 0:
+			depth--
+			i++
+		default:
+			i = skipPatternChar(pat, i)
+		}
+	}
+	return append(segs, pat[start:])
+}
+
+// matchSegments reports whether each slash-separated segment of name
+// matches the segments of a pattern.
+func matchSegments(segs []filterSegment, name string) bool {
+	seg, rest := segs[0], segs[1:]
+	if seg.m == nil { // "**"
+		if len(rest) == 0 {
+			return true
+		}
+		for {
+			if matchSegments(rest, name) {
+				return true
+			}
+			i := strings.IndexByte(name, '/')
+			if i < 0 {
+				return false
+			}
+			name = name[i+1:]
+		}
+	}
+	first, after, found := strings.Cut(name, "/")
+	if !seg.m.Match(first) {
+		return false
+	}
+	if len(rest) == 0 {
+		return !found
+	}
+	return found && matchSegments(rest, after)
+}
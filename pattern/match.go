@@ -0,0 +1,307 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package pattern
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Matcher matches entire strings against a shell pattern, as returned by
+// [Compile]. Unlike [Regexp], it supports the "!(list)" extended operator,
+// which matches any string not matched by "@(list)".
+type Matcher struct {
+	rx    *regexp.Regexp // if the pattern has no "!(list)" operators
+	items []matchItem
+}
+
+// matchItem is either a pattern segment without "!(list)" operators,
+// or an extended operator group whose list contains them.
+type matchItem struct {
+	rx *regexp.Regexp
+
+	op   byte // one of '?', '*', '+', '@', or '!'
+	alts []*Matcher
+}
+
+// Compile parses a pattern to match entire strings with, like [Regexp]
+// with [EntireString]. The [Shortest] mode has no effect.
+//
+// The returned matcher can be reused and is safe for concurrent use.
+// Recently compiled patterns are cached, so compiling the same pattern
+// repeatedly, such as in a loop, is cheap.
+//
+// When using the "!(list)" operator, [Filenames] only applies to the parts
+// of the pattern outside of it, so it may match slashes.
+func Compile(pat string, mode Mode) (*Matcher, error) {
+	mode &^= Shortest | EntireString
+	key := cacheKey{pat, mode}
+	cache.Lock()
+	m := cache.matchers[key]
+	cache.Unlock()
+	if m != nil {
+		return m, nil
+	}
+	m, err := compile(pat, mode)
+	if err != nil {
+		return nil, err
+	}
+	cache.Lock()
+	if len(cache.matchers) >= maxCached {
+		clear(cache.matchers) // simpler than tracking which were used least
+	}
+	cache.matchers[key] = m
+	cache.Unlock()
+	return m, nil
+}
+
+// maxCached limits how many matchers [Compile] keeps in its cache.
+const maxCached = 256
+
+type cacheKey struct {
+	pat  string
+	mode Mode
+}
+
+var cache = struct {
+	sync.Mutex
+	matchers map[cacheKey]*Matcher
+}{matchers: make(map[cacheKey]*Matcher)}
+
+func compile(pat string, mode Mode) (*Matcher, error) {
+	if mode&ExtendedOperators == 0 || !strings.Contains(pat, "!(") {
+		rx, err := compileEntire(pat, mode)
+		if err != nil {
+			return nil, err
+		}
+		return &Matcher{rx: rx}, nil
+	}
+	m := &Matcher{}
+	segStart := 0
+	flushSegment := func(end int) error {
+		if end > segStart {
+			rx, err := compileEntire(pat[segStart:end], mode)
+			if err != nil {
+				return withOffset(err, segStart)
+			}
+			m.items = append(m.items, matchItem{rx: rx})
+		}
+		return nil
+	}
+	for i := 0; i < len(pat); {
+		if !extGroupStart(pat, i) {
+			i = skipPatternChar(pat, i)
+			continue
+		}
+		end := extGroupEnd(pat, i+2)
+		if end < 0 {
+			return nil, &SyntaxError{
+				msg:    "( was not matched with a closing )",
+				Offset: i,
+				Kind:   UnterminatedGroup,
+			}
+		}
+		list := pat[i+2 : end]
+		if pat[i] != '!' && !strings.Contains(list, "!(") {
+			i = end + 1 // a group which Regexp supports
+			continue
+		}
+		if err := flushSegment(i); err != nil {
+			return nil, err
+		}
+		item := matchItem{op: pat[i]}
+		altStart := i + 2
+		for _, alt := range splitExtGroup(list) {
+			am, err := compile(alt, mode)
+			if err != nil {
+				return nil, withOffset(err, altStart)
+			}
+			item.alts = append(item.alts, am)
+			altStart += len(alt) + 1 // the '|' separator
+		}
+		m.items = append(m.items, item)
+		i = end + 1
+		segStart = i
+	}
+	if err := flushSegment(len(pat)); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func compileEntire(pat string, mode Mode) (*regexp.Regexp, error) {
+	expr, err := Regexp(pat, mode|EntireString)
+	if err != nil {
+		return nil, err
+	}
+	return regexp.Compile(expr)
+}
+
+// extGroupStart reports whether an extended operator like "@(" starts at i.
+func extGroupStart(pat string, i int) bool {
+	if i+1 >= len(pat) || pat[i+1] != '(' {
+		return false
+	}
+	switch pat[i] {
+	case '?', '*', '+', '@', '!':
+		return true
+	}
+	return false
+}
+
+// skipPatternChar returns the index after the pattern character at i,
+// skipping over escaped characters and bracket expressions as a whole.
+func skipPatternChar(pat string, i int) int {
+	switch pat[i] {
+	case '\\':
+		return min(i+2, len(pat))
+	case '[':
+		if name, err := charClass(pat[i:]); err == nil && name != "" {
+			return i + len(name)
+		}
+		j := i + 1
+		if j < len(pat) && (pat[j] == '!' || pat[j] == '^') {
+			j++
+		}
+		if j < len(pat) && pat[j] == ']' {
+			j++
+		}
+		for ; j < len(pat); j++ {
+			switch pat[j] {
+			case '\\':
+				j++
+			case ']':
+				return j + 1
+			}
+		}
+	}
+	return i + 1 // including a '[' without a closing ']'
+}
+
+// extGroupEnd returns the index of the ')' closing the extended operator
+// group whose list starts at i, or -1 if it is not closed.
+func extGroupEnd(pat string, i int) int {
+	depth := 1
+	for i < len(pat) {
+		switch {
+		case extGroupStart(pat, i):
+			depth++
+			i += 2
+		case pat[i] == ')':
+			if depth--; depth == 0 {
+				return i
+			}
+			i++
+		default:
+			i = skipPatternChar(pat, i)
+		}
+	}
+	return -1
+}
+
+// splitExtGroup splits the list of an extended operator group by '|'.
+func splitExtGroup(list string) []string {
+	var alts []string
+	depth, start := 0, 0
+	for i := 0; i < len(list); {
+		switch {
+		case extGroupStart(list, i):
+			depth++
+			i += 2
+		case list[i] == ')':
+			depth--
+			i++
+		case list[i] == '|' && depth == 0:
+			alts = append(alts, list[start:i])
+			i++
+			start = i
+		default:
+			i = skipPatternChar(list, i)
+		}
+	}
+	return append(alts, list[start:])
+}
+
+// Match reports whether the entire string s matches the pattern.
+func (m *Matcher) Match(s string) bool {
+	if m.rx != nil {
+		return m.rx.MatchString(s)
+	}
+	return matchItems(m.items, s)
+}
+
+func matchItems(items []matchItem, s string) bool {
+	if len(items) == 0 {
+		return s == ""
+	}
+	item, rest := items[0], items[1:]
+	if len(rest) == 0 {
+		return item.match(s)
+	}
+	for i := 0; i <= len(s); i++ {
+		if i < len(s) && !utf8.RuneStart(s[i]) {
+			continue
+		}
+		if item.match(s[:i]) && matchItems(rest, s[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (item *matchItem) match(s string) bool {
+	if item.rx != nil {
+		return item.rx.MatchString(s)
+	}
+	switch item.op {
+	case '@':
+		return item.matchAny(s)
+	case '!':
+		return !item.matchAny(s)
+	case '?':
+		return s == "" || item.matchAny(s)
+	case '*':
+		return s == "" || item.matchRepeated(s)
+	default: // '+'
+		return item.matchAny(s) || item.matchRepeated(s)
+	}
+}
+
+func (item *matchItem) matchAny(s string) bool {
+	for _, alt := range item.alts {
+		if alt.Match(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRepeated reports whether s is made up of one or more non-empty strings
+// each matching any of the alternatives.
+func (item *matchItem) matchRepeated(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 1; i <= len(s); i++ {
+		if i < len(s) && !utf8.RuneStart(s[i]) {
+			continue
+		}
+		if item.matchAny(s[:i]) && (i == len(s) || item.matchRepeated(s[i:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether name matches the entire shell pattern,
+// supporting the same operators as [Compile].
+func Match(pat, name string, mode Mode) (bool, error) {
+	m, err := Compile(pat, mode)
+	if err != nil {
+		return false, err
+	}
+	return m.Match(name), nil
+}
@@ -0,0 +1,245 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package pattern
+
+import (
+	"regexp/syntax"
+	"slices"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Disjoint reports whether no string can match both patterns a and b,
+// such as "*.go" and "*.txt".
+//
+// The answer is conservative: if either pattern is invalid, uses the
+// "!(list)" extended operator, or is too complex to analyze, Disjoint
+// reports false.
+func Disjoint(a, b string, mode Mode) bool {
+	pa, pb := compileProg(a, mode), compileProg(b, mode)
+	if pa == nil || pb == nil {
+		return false
+	}
+	overlap := false
+	complete := exploreProduct(pa, pb, true, func(aMatch, bMatch bool) bool {
+		overlap = aMatch && bMatch
+		return overlap
+	})
+	return complete && !overlap
+}
+
+// Subsumes reports whether any string matching pattern b also matches
+// pattern a, such as with "a*" and "ab". For example, a case clause with
+// pattern b is unreachable if an earlier clause has pattern a.
+//
+// The answer is conservative: if either pattern is invalid, uses the
+// "!(list)" extended operator, or is too complex to analyze, Subsumes
+// reports false.
+func Subsumes(a, b string, mode Mode) bool {
+	pa, pb := compileProg(a, mode), compileProg(b, mode)
+	if pa == nil || pb == nil {
+		return false
+	}
+	escaped := false
+	complete := exploreProduct(pa, pb, false, func(aMatch, bMatch bool) bool {
+		escaped = bMatch && !aMatch
+		return escaped
+	})
+	return complete && !escaped
+}
+
+// compileProg compiles a pattern into the automaton used by its regular
+// expression, or returns nil if that is not possible.
+func compileProg(pat string, mode Mode) *syntax.Prog {
+	m, err := Compile(pat, mode)
+	if err != nil || m.rx == nil {
+		return nil
+	}
+	re, err := syntax.Parse(m.rx.String(), syntax.Perl)
+	if err != nil {
+		return nil
+	}
+	prog, err := syntax.Compile(re.Simplify())
+	if err != nil {
+		return nil
+	}
+	return prog
+}
+
+// maxProductStates limits how many states [exploreProduct] visits,
+// as the subset construction may need exponentially many of them.
+const maxProductStates = 10000
+
+// productState is a pair of states in two automata, each of them being
+// the set of instructions which the threads of the automaton are at.
+type productState struct {
+	a, b  []uint32
+	start bool // no input has been consumed yet
+}
+
+// exploreProduct walks the states of the product of automata a and b which
+// are reachable by consuming the same input, calling visit with whether each
+// automaton matches the input consumed so far. States where b can no longer
+// match are skipped, as well as those where a can no longer match if needA
+// is set.
+//
+// The walk stops early when visit returns true. The result is false if the
+// walk was stopped because it visited too many states.
+func exploreProduct(a, b *syntax.Prog, needA bool, visit func(aMatch, bMatch bool) bool) bool {
+	queue := []productState{{
+		a:     []uint32{uint32(a.Start)},
+		b:     []uint32{uint32(b.Start)},
+		start: true,
+	}}
+	seen := make(map[string]bool)
+	for len(queue) > 0 {
+		st := queue[0]
+		queue = queue[1:]
+		if visit(progMatches(a, st.a, st.start), progMatches(b, st.b, st.start)) {
+			return true
+		}
+		insta := progClosure(a, st.a, st.start, false)
+		instb := progClosure(b, st.b, st.start, false)
+
+		// Split the runes into intervals which every instruction
+		// either fully matches or does not match at all,
+		// so that we only need to try one rune from each interval.
+		bounds := []rune{0, 0xd800, 0xe000} // skipping surrogate halves
+		bounds = progBounds(a, insta, bounds)
+		bounds = progBounds(b, instb, bounds)
+		slices.Sort(bounds)
+		bounds = slices.Compact(bounds)
+		for _, r := range bounds {
+			if r > unicode.MaxRune || !utf8.ValidRune(r) {
+				continue
+			}
+			next := productState{
+				a: progStep(a, insta, r),
+				b: progStep(b, instb, r),
+			}
+			if len(next.b) == 0 || (needA && len(next.a) == 0) {
+				continue
+			}
+			key := stateKey(next.a) + "|" + stateKey(next.b)
+			if seen[key] {
+				continue
+			}
+			if len(seen) >= maxProductStates {
+				return false
+			}
+			seen[key] = true
+			queue = append(queue, next)
+		}
+	}
+	return true
+}
+
+// progClosure returns the rune-consuming and matching instructions which
+// can be reached from the given ones without consuming input.
+func progClosure(prog *syntax.Prog, pcs []uint32, start, end bool) []uint32 {
+	var empty syntax.EmptyOp
+	if start {
+		empty |= syntax.EmptyBeginText | syntax.EmptyBeginLine
+	}
+	if end {
+		empty |= syntax.EmptyEndText | syntax.EmptyEndLine
+	}
+	var insts []uint32
+	seen := make(map[uint32]bool)
+	stack := slices.Clone(pcs)
+	for len(stack) > 0 {
+		pc := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[pc] {
+			continue
+		}
+		seen[pc] = true
+		switch inst := prog.Inst[pc]; inst.Op {
+		case syntax.InstAlt, syntax.InstAltMatch:
+			stack = append(stack, inst.Out, inst.Arg)
+		case syntax.InstCapture, syntax.InstNop:
+			stack = append(stack, inst.Out)
+		case syntax.InstEmptyWidth:
+			if syntax.EmptyOp(inst.Arg)&^empty == 0 {
+				stack = append(stack, inst.Out)
+			}
+		case syntax.InstFail:
+		default: // InstMatch and the rune instructions
+			insts = append(insts, pc)
+		}
+	}
+	return insts
+}
+
+// progMatches reports whether an automaton at the given instructions matches
+// when there is no more input.
+func progMatches(prog *syntax.Prog, pcs []uint32, start bool) bool {
+	for _, pc := range progClosure(prog, pcs, start, true) {
+		if prog.Inst[pc].Op == syntax.InstMatch {
+			return true
+		}
+	}
+	return false
+}
+
+// progStep returns the instructions which follow those in insts after
+// consuming the rune r, sorted so that equal states have equal keys.
+func progStep(prog *syntax.Prog, insts []uint32, r rune) []uint32 {
+	var next []uint32
+	for _, pc := range insts {
+		inst := prog.Inst[pc]
+		ok := false
+		switch inst.Op {
+		case syntax.InstRune, syntax.InstRune1:
+			ok = inst.MatchRune(r)
+		case syntax.InstRuneAny:
+			ok = true
+		case syntax.InstRuneAnyNotNL:
+			ok = r != '\n'
+		}
+		if ok {
+			next = append(next, inst.Out)
+		}
+	}
+	slices.Sort(next)
+	return slices.Compact(next)
+}
+
+// progBounds appends the runes at which the rune instructions in insts start
+// or stop matching.
+func progBounds(prog *syntax.Prog, insts []uint32, bounds []rune) []rune {
+	for _, pc := range insts {
+		inst := prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstRune:
+			if len(inst.Rune) == 1 {
+				// A single rune, which may match case-insensitively.
+				r := inst.Rune[0]
+				bounds = append(bounds, r, r+1)
+				if syntax.Flags(inst.Arg)&syntax.FoldCase != 0 {
+					for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+						bounds = append(bounds, f, f+1)
+					}
+				}
+				break
+			}
+			for i := 0; i+1 < len(inst.Rune); i += 2 {
+				bounds = append(bounds, inst.Rune[i], inst.Rune[i+1]+1)
+			}
+		case syntax.InstRune1:
+			bounds = append(bounds, inst.Rune[0], inst.Rune[0]+1)
+		case syntax.InstRuneAnyNotNL:
+			bounds = append(bounds, '\n', '\n'+1)
+		}
+	}
+	return bounds
+}
+
+func stateKey(pcs []uint32) string {
+	key := make([]byte, 0, len(pcs)*4)
+	for _, pc := range pcs {
+		key = append(key, byte(pc), byte(pc>>8), byte(pc>>16), byte(pc>>24))
+	}
+	return string(key)
+}
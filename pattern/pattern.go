@@ -20,21 +20,55 @@ import (
 // Not all functions change their behavior with all of the options below.
 type Mode uint
 
+// SyntaxError is returned when a pattern is not valid.
 type SyntaxError struct {
 	msg string
 	err error
+
+	// Offset is the byte offset in the pattern where the error was found,
+	// such as the start of an unterminated bracket expression.
+	Offset int
+
+	// Kind is the kind of error, which allows handling errors
+	// without depending on their messages.
+	Kind ErrorKind
 }
 
 func (e SyntaxError) Error() string { return e.msg }
 
 func (e SyntaxError) Unwrap() error { return e.err }
 
+// ErrorKind describes the kind of a [SyntaxError].
+type ErrorKind uint8
+
 const (
-	Shortest     Mode = 1 << iota // prefer the shortest match.
-	Filenames                     // "*" and "?" don't match slashes; only "**" does
-	Braces                        // support "{a,b}" and "{1..4}"
-	EntireString                  // match the entire string using ^$ delimiters
-	NoGlobCase                    // Do case-insensitive match (that is, use (?i) in the regexp)
+	_                   ErrorKind = iota
+	UnterminatedBracket           // a "[" without a closing "]"
+	UnterminatedGroup             // an extended operator like "@(" without a closing ")"
+	BadRange                      // a range like "[z-a]" or "{5..1}" going backwards
+	BadClass                      // a class like "[[:wrong:]]" or "[[=a=]]" which is not supported
+	TrailingBackslash             // a "\" at the end of the pattern
+)
+
+// withOffset returns err with its offset moved forward by n, if it is a
+// [SyntaxError], for errors in a part of a pattern which starts at offset n.
+func withOffset(err error, n int) error {
+	if serr, ok := err.(*SyntaxError); ok {
+		serr2 := *serr
+		serr2.Offset += n
+		return &serr2
+	}
+	return err
+}
+
+const (
+	Shortest          Mode = 1 << iota // prefer the shortest match.
+	Filenames                          // "*" and "?" don't match slashes; only "**" does
+	Braces                             // support "{a,b}" and "{1..4}"
+	EntireString                       // match the entire string using ^$ delimiters
+	NoGlobCase                         // Do case-insensitive match (that is, use (?i) in the regexp)
+	ExtendedOperators                  // support extended operators like "@(a|b)", as with Bash's extglob
+	CaptureGroups                      // capture what each wildcard matches; see [Regexp]
 )
 
 var numRange = regexp.MustCompile(`^([+-]?\d+)\.\.([+-]?\d+)}`)
@@ -45,6 +79,18 @@ var numRange = regexp.MustCompile(`^([+-]?\d+)\.\.([+-]?\d+)}`)
 //
 // For example, Regexp(`foo*bar?`, true) returns `foo.*bar.`.
 //
+// With [ExtendedOperators], the operators "?(list)", "*(list)", "+(list)", and
+// "@(list)" are supported, where list is one or more patterns separated by "|".
+// The "!(list)" operator cannot be expressed as a regular expression,
+// so it results in an error; use [Compile] to support it.
+//
+// With [CaptureGroups], each wildcard, bracket expression, and extended
+// operator group is a capturing group, numbered in the order in which they
+// appear in the pattern, so that what each of them matched can be extracted.
+// No other capturing groups are used. For example, Regexp(`*.[ch]`, CaptureGroups)
+// returns `(?s)(.*)\.([ch])`. Note that "**/" with [Filenames] is a single group
+// which also captures the trailing slash, if any.
+//
 // Note that this function (and [QuoteMeta]) should not be directly used with file
 // paths if Windows is supported, as the path separator on that platform is the
 // same character as the escaping character for shell patterns.
@@ -65,6 +111,9 @@ noopLoop:
 		return pat, nil
 	}
 	closingBraces := []int{}
+	capture := mode&CaptureGroups != 0
+	var extGroups []byte // the extended operators of the open groups, like '@'
+	var extStarts []int  // the offsets where the open groups start
 	var buf bytes.Buffer
 	// Enable matching `\n` with the `.` metacharacter as globs match `\n`
 	buf.WriteString("(?s)")
@@ -77,49 +126,98 @@ noopLoop:
 	}
 writeLoop:
 	for i := 0; i < len(pat); i++ {
-		switch c := pat[i]; c {
+		c := pat[i]
+		if mode&ExtendedOperators != 0 && i+1 < len(pat) && pat[i+1] == '(' {
+			switch c {
+			case '?', '*', '+', '@':
+				extGroups = append(extGroups, c)
+				extStarts = append(extStarts, i)
+				if capture {
+					buf.WriteByte('(')
+				}
+				buf.WriteString("(?:")
+				i++
+				continue
+			case '!':
+				return "", fmt.Errorf("extended globbing operator !( is not supported")
+			}
+		}
+		switch c {
+		case '|', ')':
+			if len(extGroups) == 0 {
+				buf.WriteString(regexp.QuoteMeta(string(c)))
+				break
+			}
+			if c == '|' {
+				buf.WriteByte('|')
+				break
+			}
+			buf.WriteByte(')')
+			if op := extGroups[len(extGroups)-1]; op != '@' {
+				buf.WriteByte(op) // one of '?', '*', or '+'
+				if mode&Shortest != 0 {
+					buf.WriteByte('?')
+				}
+			}
+			if capture {
+				buf.WriteByte(')')
+			}
+			extGroups = extGroups[:len(extGroups)-1]
+			extStarts = extStarts[:len(extStarts)-1]
 		case '*':
+			var expr string
 			if mode&Filenames != 0 {
 				if i++; i < len(pat) && pat[i] == '*' {
 					if i++; i < len(pat) && pat[i] == '/' {
-						buf.WriteString("(.*/|)")
+						expr = "(.*/|)"
 						dotMeta = true
 					} else {
-						buf.WriteString(".*")
+						expr = ".*"
 						dotMeta = true
 						i--
 					}
 				} else {
-					buf.WriteString("[^/]*")
+					expr = "[^/]*"
 					i--
 				}
 			} else {
-				buf.WriteString(".*")
+				expr = ".*"
 				dotMeta = true
 			}
 			if mode&Shortest != 0 {
-				buf.WriteByte('?')
+				expr += "?"
+			}
+			if capture && expr[0] != '(' {
+				expr = "(" + expr + ")"
 			}
+			buf.WriteString(expr)
 		case '?':
+			expr := "."
 			if mode&Filenames != 0 {
-				buf.WriteString("[^/]")
+				expr = "[^/]"
 			} else {
-				buf.WriteByte('.')
 				dotMeta = true
 			}
+			if capture {
+				expr = "(" + expr + ")"
+			}
+			buf.WriteString(expr)
 		case '\\':
 			if i++; i >= len(pat) {
-				return "", &SyntaxError{msg: `\ at end of pattern`}
+				return "", &SyntaxError{msg: `\ at end of pattern`, Offset: i - 1, Kind: TrailingBackslash}
 			}
 			buf.WriteString(regexp.QuoteMeta(string(pat[i])))
 		case '[':
 			name, err := charClass(pat[i:])
 			if err != nil {
-				return "", &SyntaxError{msg: "charClass invalid", err: err}
+				return "", &SyntaxError{msg: "charClass invalid", err: err, Offset: i, Kind: BadClass}
 			}
 			if name != "" {
-				buf.WriteString(name)
 				i += len(name) - 1
+				if capture {
+					name = "(" + name + ")"
+				}
+				buf.WriteString(name)
 				break
 			}
 			if mode&Filenames != 0 {
@@ -132,21 +230,25 @@ writeLoop:
 					}
 				}
 			}
+			if capture {
+				buf.WriteByte('(')
+			}
 			buf.WriteByte(c)
+			bracketStart := i
 			if i++; i >= len(pat) {
-				return "", &SyntaxError{msg: "[ was not matched with a closing ]"}
+				return "", unterminatedBracket(bracketStart)
 			}
 			switch c = pat[i]; c {
 			case '!', '^':
 				buf.WriteByte('^')
 				if i++; i >= len(pat) {
-					return "", &SyntaxError{msg: "[ was not matched with a closing ]"}
+					return "", unterminatedBracket(bracketStart)
 				}
 			}
 			if c = pat[i]; c == ']' {
 				buf.WriteByte(']')
 				if i++; i >= len(pat) {
-					return "", &SyntaxError{msg: "[ was not matched with a closing ]"}
+					return "", unterminatedBracket(bracketStart)
 				}
 			}
 			rangeStart := byte(0)
@@ -164,7 +266,11 @@ writeLoop:
 					break loopBracket
 				}
 				if rangeStart != 0 && rangeStart > c {
-					return "", &SyntaxError{msg: fmt.Sprintf("invalid range: %c-%c", rangeStart, c)}
+					return "", &SyntaxError{
+						msg:    fmt.Sprintf("invalid range: %c-%c", rangeStart, c),
+						Offset: i - 2,
+						Kind:   BadRange,
+					}
 				}
 				if c == '-' {
 					rangeStart = pat[i-1]
@@ -173,7 +279,10 @@ writeLoop:
 				}
 			}
 			if i >= len(pat) {
-				return "", &SyntaxError{msg: "[ was not matched with a closing ]"}
+				return "", unterminatedBracket(bracketStart)
+			}
+			if capture {
+				buf.WriteByte(')')
 			}
 		case '{':
 			if mode&Braces == 0 {
@@ -207,7 +316,7 @@ writeLoop:
 				start, err1 := strconv.Atoi(match[1])
 				end, err2 := strconv.Atoi(match[2])
 				if err1 != nil || err2 != nil || start > end {
-					return "", &SyntaxError{msg: fmt.Sprintf("invalid range: %q", match[0])}
+					return "", &SyntaxError{msg: fmt.Sprintf("invalid range: %q", match[0]), Offset: i, Kind: BadRange}
 				}
 				// TODO: can we do better here?
 				buf.WriteString("(?:")
@@ -243,6 +352,13 @@ writeLoop:
 			}
 		}
 	}
+	if len(extGroups) > 0 {
+		return "", &SyntaxError{
+			msg:    "( was not matched with a closing )",
+			Offset: extStarts[len(extStarts)-1],
+			Kind:   UnterminatedGroup,
+		}
+	}
 	if mode&EntireString != 0 {
 		buf.WriteString("$")
 	}
@@ -253,6 +369,10 @@ writeLoop:
 	return buf.String(), nil
 }
 
+func unterminatedBracket(offset int) error {
+	return &SyntaxError{msg: "[ was not matched with a closing ]", Offset: offset, Kind: UnterminatedBracket}
+}
+
 func charClass(s string) (string, error) {
 	if strings.HasPrefix(s, "[[.") || strings.HasPrefix(s, "[[=") {
 		return "", fmt.Errorf("collating features not available")
@@ -276,7 +396,8 @@ func charClass(s string) (string, error) {
 }
 
 // HasMeta returns whether a string contains any unescaped pattern
-// metacharacters: '*', '?', or '['. When the function returns false, the given
+// metacharacters: '*', '?', or '['. With [ExtendedOperators], the extended
+// operators like "@(" count as well. When the function returns false, the given
 // pattern can only match at most one string.
 //
 // For example, HasMeta(`foo\*bar`) returns false, but HasMeta(`foo*bar`)
@@ -296,6 +417,10 @@ func HasMeta(pat string, mode Mode) bool {
 			if mode&Braces != 0 {
 				return true
 			}
+		case '+', '@', '!':
+			if mode&ExtendedOperators != 0 && i+1 < len(pat) && pat[i+1] == '(' {
+				return true
+			}
 		}
 	}
 	return false
@@ -303,6 +428,7 @@ func HasMeta(pat string, mode Mode) bool {
 
 // QuoteMeta returns a string that quotes all pattern metacharacters in the
 // given text. The returned string is a pattern that matches the literal text.
+// With [ExtendedOperators], the characters '(', ')', and '|' are quoted too.
 //
 // For example, QuoteMeta(`foo*bar?`) returns `foo\*bar\?`.
 func QuoteMeta(pat string, mode Mode) string {
@@ -318,6 +444,11 @@ loop:
 		case '*', '?', '[', '\\':
 			needsEscaping = true
 			break loop
+		case '(', ')', '|':
+			if mode&ExtendedOperators != 0 {
+				needsEscaping = true
+				break loop
+			}
 		}
 	}
 	if !needsEscaping { // short-cut without a string copy
@@ -332,6 +463,10 @@ loop:
 			if mode&Braces != 0 {
 				buf.WriteByte('\\')
 			}
+		case '(', ')', '|':
+			if mode&ExtendedOperators != 0 {
+				buf.WriteByte('\\')
+			}
 		}
 		buf.WriteRune(r)
 	}
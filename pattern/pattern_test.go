@@ -4,9 +4,13 @@
 package pattern
 
 import (
+	"errors"
 	"fmt"
 	"regexp/syntax"
+	"slices"
+	"sync"
 	"testing"
+	"unicode"
 )
 
 var translateTests = []struct {
@@ -82,6 +86,21 @@ var translateTests = []struct {
 	{pat: `[[:wrong:]]`, wantErr: true},
 	{pat: `[[=x=]]`, wantErr: true},
 	{pat: `[[.x.]]`, wantErr: true},
+	{pat: `@(a|b)`, want: `@\(a\|b\)`},
+	{pat: `@(a|b)`, mode: ExtendedOperators, want: `(?:a|b)`},
+	{pat: `x?(a)*(b|c)+(d)`, mode: ExtendedOperators, want: `x(?:a)?(?:b|c)*(?:d)+`},
+	{pat: `+(a|*(b))`, mode: ExtendedOperators | Filenames, want: `(?:a|(?:b)*)+`},
+	{pat: `@(*.go|?)`, mode: ExtendedOperators | Filenames, want: `(?:[^/]*\.go|[^/])`},
+	{pat: `+(a)@(b)`, mode: ExtendedOperators | Shortest, want: `(?:a)+?(?:b)`},
+	{pat: `a|b)`, mode: ExtendedOperators, want: `a\|b\)`},
+	{pat: `\@(a)`, mode: ExtendedOperators, want: `@\(a\)`},
+	{pat: `@(a`, mode: ExtendedOperators, wantErr: true},
+	{pat: `!(a)`, mode: ExtendedOperators, wantErr: true},
+	{pat: `a*b?c`, mode: CaptureGroups, want: `(?s)a(.*)b(.)c`},
+	{pat: `*[ch][[:digit:]]`, mode: CaptureGroups | Shortest, want: `(?s)(.*?)([ch])([[:digit:]])`},
+	{pat: `**/*.go`, mode: CaptureGroups | Filenames, want: `(?s)(.*/|)([^/]*)\.go`},
+	{pat: `+(a|?)x`, mode: CaptureGroups | ExtendedOperators, want: `(?s)((?:a|(.))+)x`},
+	{pat: `{a,b}\*`, mode: CaptureGroups | Braces, want: `(?:a|b)\*`},
 }
 
 func TestRegexp(t *testing.T) {
@@ -121,6 +140,10 @@ var metaTests = []struct {
 	{`\[`, 0, false, `\\\[`},
 	{`{`, 0, false, `{`},
 	{`{`, Braces, true, `\{`},
+	{`@(a|b)`, 0, false, `@(a|b)`},
+	{`@(a|b)`, ExtendedOperators, true, `@\(a\|b\)`},
+	{`!(a)`, ExtendedOperators, true, `!\(a\)`},
+	{`\@(a)`, ExtendedOperators, false, `\\@\(a\)`},
 }
 
 func TestMeta(t *testing.T) {
@@ -136,3 +159,347 @@ func TestMeta(t *testing.T) {
 		}
 	}
 }
+
+var matcherTests = []struct {
+	pat     string
+	mode    Mode
+	matches []string
+	others  []string
+}{
+	{pat: `foo*`, matches: []string{"foo", "foobar"}, others: []string{"fo", "xfoo"}},
+	{pat: `+(a)b`, mode: ExtendedOperators, matches: []string{"ab", "aab"}, others: []string{"b", "abb"}},
+	{pat: `!(a)`, mode: ExtendedOperators, matches: []string{"", "b", "aa"}, others: []string{"a"}},
+	{pat: `!(*.txt)`, mode: ExtendedOperators, matches: []string{"foo.go", "txt"}, others: []string{"foo.txt", ".txt"}},
+	{pat: `a!(b|c)d`, mode: ExtendedOperators, matches: []string{"ad", "axd", "abbd"}, others: []string{"abd", "acd"}},
+	{pat: `@(a|!(b))`, mode: ExtendedOperators, matches: []string{"a", "c", "abc"}, others: []string{"b"}},
+	{pat: `+(!(x))`, mode: ExtendedOperators, matches: []string{"a", "xax"}, others: []string{"x"}},
+	{pat: `*(a|!(?))b`, mode: ExtendedOperators, matches: []string{"b", "aab", "xyb"}, others: []string{"xb"}},
+	{pat: `[!(]!(é)`, mode: ExtendedOperators, matches: []string{"aé2", "a"}, others: []string{"aé", "(b"}},
+	{pat: `\!(a)`, mode: ExtendedOperators, matches: []string{"!(a)"}, others: []string{"b"}},
+}
+
+func TestMatcher(t *testing.T) {
+	t.Parallel()
+	for _, tc := range matcherTests {
+		m, err := Compile(tc.pat, tc.mode)
+		if err != nil {
+			t.Errorf("Compile(%q, %b) errored with %q", tc.pat, tc.mode, err)
+			continue
+		}
+		for _, s := range tc.matches {
+			if !m.Match(s) {
+				t.Errorf("%q did not match %q", tc.pat, s)
+			}
+		}
+		for _, s := range tc.others {
+			if m.Match(s) {
+				t.Errorf("%q matched %q", tc.pat, s)
+			}
+		}
+	}
+	for _, pat := range []string{`!(a`, `a!(b)[`} {
+		if _, err := Compile(pat, ExtendedOperators); err == nil {
+			t.Errorf("Compile(%q) did not error", pat)
+		}
+		if _, err := Match(pat, "a", ExtendedOperators); err == nil {
+			t.Errorf("Match(%q) did not error", pat)
+		}
+	}
+	if ok, err := Match(`!(*.txt)`, "foo.go", ExtendedOperators); !ok || err != nil {
+		t.Errorf("Match(%q) got %t, %v", `!(*.txt)`, ok, err)
+	}
+}
+
+func TestCompileConcurrent(t *testing.T) {
+	t.Parallel()
+	m1, err := Compile(`*.@(go|!(txt))`, ExtendedOperators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m2, err := Compile(`*.@(go|!(txt))`, ExtendedOperators|Shortest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m1 != m2 {
+		t.Errorf("Compile did not reuse the cached matcher")
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < maxCached*2; j++ {
+				pat := fmt.Sprintf("*%d", j)
+				if ok, err := Match(pat, fmt.Sprintf("foo%d", j), 0); !ok || err != nil {
+					t.Errorf("Match(%q) got %t, %v", pat, ok, err)
+				}
+				if m1.Match("foo.txt") || !m1.Match("foo.c") {
+					t.Errorf("%q matched incorrectly", `*.@(go|!(txt))`)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+var replaceTests = []struct {
+	s, pat, repl string
+	mode         Mode
+	op           byte
+	want         string
+}{
+	{s: "abcabc", pat: "b", repl: "x", want: "axcabc"},
+	{s: "abcabc", pat: "b", repl: "x", op: '/', want: "axcaxc"},
+	{s: "abcabc", pat: "a", repl: "x", op: '#', want: "xbcabc"},
+	{s: "abcabc", pat: "b", repl: "x", op: '#', want: "abcabc"},
+	{s: "abcabc", pat: "?c", repl: "x", op: '%', want: "abcax"},
+	{s: "aab", pat: "@(a|aa)", repl: "x", mode: ExtendedOperators, want: "xb"},
+	{s: "abcb", pat: "!(b)", repl: "x", mode: ExtendedOperators, op: '/', want: "x"},
+	{s: "abcb", pat: "!(a)", repl: "x", mode: ExtendedOperators, op: '#', want: "x"},
+	{s: "abc", pat: "b", repl: "<&>", want: "a<b>c"},
+	{s: "abc", pat: "b", repl: `<\&\\\x>`, want: `a<&\\x>c`},
+	{s: "abc", pat: "[", repl: "x", want: "abc"},
+	{s: "héllo", pat: "?", repl: "<&>", op: '/', want: "<h><é><l><l><o>"},
+}
+
+func TestReplace(t *testing.T) {
+	t.Parallel()
+	for _, tc := range replaceTests {
+		got, err := Replace(tc.s, tc.pat, tc.repl, tc.mode, tc.op)
+		if tc.pat == "[" {
+			if err == nil || got != tc.want {
+				t.Errorf("Replace(%q, %q) got %q, %v; want an error", tc.s, tc.pat, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("Replace(%q, %q, %q, %q) got %q, %v; want %q",
+				tc.s, tc.pat, tc.repl, tc.op, got, err, tc.want)
+		}
+	}
+	if got, want := QuoteReplacement(`a&b\c`), `a\&b\\c`; got != want {
+		t.Errorf("QuoteReplacement got %q, want %q", got, want)
+	}
+}
+
+var trimTests = []struct {
+	s, pat  string
+	mode    Mode
+	suffix  bool
+	longest bool
+	want    string
+}{
+	{s: "abcabc", pat: "*b", want: "cabc"},
+	{s: "abcabc", pat: "*b", longest: true, want: "c"},
+	{s: "abcabc", pat: "b*", suffix: true, want: "abca"},
+	{s: "abcabc", pat: "b*", suffix: true, longest: true, want: "a"},
+	{s: "abcabc", pat: "x", want: "abcabc"},
+	{s: "abcabc", pat: "x", suffix: true, want: "abcabc"},
+	{s: "aab", pat: "@(aa|a)", mode: ExtendedOperators, want: "ab"},
+	{s: "aab", pat: "@(a|aa)", mode: ExtendedOperators, longest: true, want: "b"},
+	{s: "abc", pat: "!(a)", mode: ExtendedOperators, want: "abc"},
+	{s: "abc", pat: "!(a)", mode: ExtendedOperators, longest: true, want: ""},
+	{s: "aXbXc", pat: "*X!(b)", mode: ExtendedOperators, want: "bXc"},
+	{s: "aXbXc", pat: "!(c)X*", mode: ExtendedOperators, suffix: true, want: "aXb"},
+	{s: "héllo", pat: "?", want: "éllo"},
+	{s: "héllo", pat: "?", suffix: true, want: "héll"},
+	{s: "héllo", pat: "*l*", suffix: true, want: "hél"},
+	{s: "héllo", pat: "*l*", suffix: true, longest: true, want: ""},
+	{s: "héllo", pat: "*l", longest: true, want: "o"},
+	{s: "abc", pat: "[", want: "abc"},
+}
+
+func TestTrim(t *testing.T) {
+	t.Parallel()
+	for _, tc := range trimTests {
+		trim := TrimPrefix
+		if tc.suffix {
+			trim = TrimSuffix
+		}
+		got, err := trim(tc.s, tc.pat, tc.mode, tc.longest)
+		if tc.pat == "[" {
+			if err == nil || got != tc.want {
+				t.Errorf("trim(%q, %q) got %q, %v; want an error", tc.s, tc.pat, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("trim(%q, %q, suffix=%t, longest=%t) got %q, %v; want %q",
+				tc.s, tc.pat, tc.suffix, tc.longest, got, err, tc.want)
+		}
+	}
+}
+
+var mapCaseTests = []struct {
+	s, pat string
+	mode   Mode
+	all    bool
+	want   string
+}{
+	{s: "abc", pat: "", want: "Abc"},
+	{s: "abc", pat: "", all: true, want: "ABC"},
+	{s: "abc", pat: "b", want: "abc"},
+	{s: "abc", pat: "b", all: true, want: "aBc"},
+	{s: "abc", pat: "[ac]", all: true, want: "AbC"},
+	{s: "abc", pat: "?(b)", mode: ExtendedOperators, all: true, want: "aBc"},
+	{s: "abc", pat: "!(b)", mode: ExtendedOperators, all: true, want: "AbC"},
+	{s: "éa", pat: "é", want: "Éa"},
+	{s: "", pat: "", want: ""},
+	{s: "abc", pat: "[", want: "abc"},
+}
+
+func TestMapCase(t *testing.T) {
+	t.Parallel()
+	for _, tc := range mapCaseTests {
+		got, err := MapCase(tc.s, tc.pat, tc.mode, tc.all, unicode.ToUpper)
+		if tc.pat == "[" {
+			if err == nil || got != tc.want {
+				t.Errorf("MapCase(%q, %q) got %q, %v; want an error", tc.s, tc.pat, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("MapCase(%q, %q, all=%t) got %q, %v; want %q",
+				tc.s, tc.pat, tc.all, got, err, tc.want)
+		}
+	}
+}
+
+var overlapTests = []struct {
+	a, b     string
+	mode     Mode
+	disjoint bool
+	subsumes bool // a subsumes b
+}{
+	{a: "*.go", b: "*.txt", disjoint: true},
+	{a: "*", b: "foo", subsumes: true},
+	{a: "a*", b: "ab", subsumes: true},
+	{a: "ab", b: "a*"},
+	{a: "*b", b: "*ab", subsumes: true},
+	{a: "*ab", b: "*b"},
+	{a: "a*", b: "*b"},
+	{a: "foo", b: "foo", subsumes: true},
+	{a: "[a-c]", b: "b", subsumes: true},
+	{a: "[a-c]", b: "[b-d]"},
+	{a: "[!a]", b: "a", disjoint: true},
+	{a: "?", b: "é", subsumes: true},
+	{a: "?", b: "", disjoint: true},
+	{a: "*", b: "", subsumes: true},
+	{a: "*", b: "a/b", subsumes: true},
+	{a: "*", b: "a/b", mode: Filenames, disjoint: true},
+	{a: "FOO", b: "foo", disjoint: true},
+	{a: "FOO", b: "foo", mode: NoGlobCase, subsumes: true},
+	{a: "[[:digit:]]*", b: "1*", subsumes: true},
+	{a: "{a,b}c", b: "bc", mode: Braces, subsumes: true},
+	{a: "+(ab)", b: "ab@(ab|abab)", mode: ExtendedOperators, subsumes: true},
+	{a: "@(a|b)", b: "c", mode: ExtendedOperators, disjoint: true},
+	{a: "!(a)", b: "b", mode: ExtendedOperators}, // not analyzed
+	{a: "[", b: "a"},                             // invalid
+}
+
+func TestOverlap(t *testing.T) {
+	t.Parallel()
+	for _, tc := range overlapTests {
+		if got := Disjoint(tc.a, tc.b, tc.mode); got != tc.disjoint {
+			t.Errorf("Disjoint(%q, %q) got %t, want %t", tc.a, tc.b, got, tc.disjoint)
+		}
+		if got := Disjoint(tc.b, tc.a, tc.mode); got != tc.disjoint {
+			t.Errorf("Disjoint(%q, %q) got %t, want %t", tc.b, tc.a, got, tc.disjoint)
+		}
+		if got := Subsumes(tc.a, tc.b, tc.mode); got != tc.subsumes {
+			t.Errorf("Subsumes(%q, %q) got %t, want %t", tc.a, tc.b, got, tc.subsumes)
+		}
+	}
+}
+
+var syntaxErrorTests = []struct {
+	pat    string
+	mode   Mode
+	offset int
+	kind   ErrorKind
+}{
+	{pat: `ab[cd`, offset: 2, kind: UnterminatedBracket},
+	{pat: `[!`, offset: 0, kind: UnterminatedBracket},
+	{pat: `a[b-a]`, offset: 2, kind: BadRange},
+	{pat: `x{5..1}`, mode: Braces, offset: 1, kind: BadRange},
+	{pat: `*[[:wrong:]]`, offset: 1, kind: BadClass},
+	{pat: `[[=a=]]`, offset: 0, kind: BadClass},
+	{pat: `ab\`, offset: 2, kind: TrailingBackslash},
+	{pat: `a@(b|+(c)`, mode: ExtendedOperators, offset: 1, kind: UnterminatedGroup},
+	{pat: `a!(b`, mode: ExtendedOperators, offset: 1, kind: UnterminatedGroup},
+	{pat: `a!(b|c[)`, mode: ExtendedOperators, offset: 6, kind: UnterminatedBracket},
+	{pat: `!(a)x[`, mode: ExtendedOperators, offset: 5, kind: UnterminatedBracket},
+}
+
+func TestSyntaxError(t *testing.T) {
+	t.Parallel()
+	for _, tc := range syntaxErrorTests {
+		_, err := Compile(tc.pat, tc.mode)
+		var serr *SyntaxError
+		if !errors.As(err, &serr) {
+			t.Errorf("Compile(%q) got %v; want a SyntaxError", tc.pat, err)
+			continue
+		}
+		if serr.Offset != tc.offset || serr.Kind != tc.kind {
+			t.Errorf("Compile(%q) got offset %d and kind %d; want %d and %d",
+				tc.pat, serr.Offset, serr.Kind, tc.offset, tc.kind)
+		}
+	}
+}
+
+var filterTests = []struct {
+	pat  string
+	mode Mode
+	want []string
+}{
+	{pat: `*`, want: []string{"a.go", "b.txt", ".hidden"}},
+	{pat: `*.go`, want: []string{"a.go"}},
+	{pat: `*/*.go`, want: []string{"dir/c.go"}},
+	{pat: `**/*.go`, want: []string{"a.go", "dir/c.go", "dir/sub/d.go"}},
+	{pat: `dir/**`, want: []string{"dir/c.go", "dir/sub/d.go", "dir/[!x]"}},
+	{pat: `dir\/c.go`, want: []string{"dir/c.go"}},
+	{pat: `d[!x]r/?.go`, want: []string{"dir/c.go"}},
+	{pat: `?[!a]?`, want: nil}, // brackets never match slashes
+	{pat: `dir/@(c|sub/d).go`, mode: ExtendedOperators, want: []string{"dir/c.go"}},
+	{pat: `{a,dir/c}.go`, mode: Braces, want: []string{"a.go"}},
+}
+
+func TestFilter(t *testing.T) {
+	t.Parallel()
+	names := []string{"a.go", "b.txt", ".hidden", "dir/c.go", "dir/sub/d.go", "dir/[!x]", "a/b"}
+	seq := func(yield func(string) bool) {
+		for _, name := range names {
+			if !yield(name) {
+				return
+			}
+		}
+	}
+	for _, tc := range filterTests {
+		filtered, err := Filter(tc.pat, tc.mode, seq)
+		if err != nil {
+			t.Errorf("Filter(%q) errored with %q", tc.pat, err)
+			continue
+		}
+		var got []string
+		filtered(func(name string) bool {
+			got = append(got, name)
+			return true
+		})
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("Filter(%q) got %q, want %q", tc.pat, got, tc.want)
+		}
+	}
+	// Stopping early stops consuming names.
+	filtered, _ := Filter(`*`, 0, seq)
+	count := 0
+	filtered(func(name string) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Errorf("Filter did not stop early, got %d names", count)
+	}
+	if _, err := Filter(`dir/[`, 0, seq); err == nil {
+		t.Errorf("Filter did not error")
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package pattern
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Replace replaces matches of a pattern in s with repl, like Bash's
+// "${name/pattern/repl}". Like Bash, the longest match at the leftmost
+// position is used.
+//
+// The op mirrors the character which may follow the first slash in Bash:
+// 0 replaces the first match, '/' replaces all non-overlapping matches,
+// '#' only replaces a match at the start of s, and '%' only replaces a match
+// at the end of s.
+//
+// Like with Bash's patsub_replacement option, each "&" in repl is replaced by
+// the matched text. A backslash escapes a following "&" or backslash, and is
+// kept as-is otherwise. Use [QuoteReplacement] to replace with literal text.
+func Replace(s, pat, repl string, mode Mode, op byte) (string, error) {
+	locs, err := matchIndexes(pat, s, mode, op)
+	if err != nil || len(locs) == 0 {
+		return s, err
+	}
+	with := splitReplacement(repl)
+	var sb strings.Builder
+	last := 0
+	for _, loc := range locs {
+		sb.WriteString(s[last:loc[0]])
+		sb.WriteString(strings.Join(with, s[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	sb.WriteString(s[last:])
+	return sb.String(), nil
+}
+
+// QuoteReplacement returns a string that quotes the characters which are
+// special in the replacement string for [Replace], so that it is used literally.
+func QuoteReplacement(s string) string {
+	if !strings.ContainsAny(s, `\&`) {
+		return s
+	}
+	var sb strings.Builder
+	for _, r := range s {
+		if r == '\\' || r == '&' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// splitReplacement splits a replacement string for [Replace] at each
+// unescaped "&", removing the backslashes which escape characters.
+func splitReplacement(repl string) []string {
+	if !strings.ContainsAny(repl, `\&`) {
+		return []string{repl}
+	}
+	var with []string
+	var sb strings.Builder
+	for i := 0; i < len(repl); i++ {
+		switch c := repl[i]; {
+		case c == '\\' && i+1 < len(repl) && (repl[i+1] == '\\' || repl[i+1] == '&'):
+			i++
+			sb.WriteByte(repl[i])
+		case c == '&':
+			with = append(with, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return append(with, sb.String())
+}
+
+// matchIndexes returns the locations of the matches to replace in s,
+// as described in [Replace].
+func matchIndexes(pat, s string, mode Mode, op byte) ([][]int, error) {
+	mode &^= Shortest | EntireString
+	if mode&ExtendedOperators != 0 && strings.Contains(pat, "!(") {
+		m, err := Compile(pat, mode)
+		if err != nil {
+			return nil, err
+		}
+		return m.matchIndexes(s, op), nil
+	}
+	expr, err := Regexp(pat, mode)
+	if err != nil {
+		return nil, err
+	}
+	switch op {
+	case '#':
+		expr = "^(?:" + expr + ")"
+	case '%':
+		expr = "(?:" + expr + ")$"
+	}
+	rx, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	rx.Longest()
+	n := 1
+	if op == '/' {
+		n = -1
+	}
+	return rx.FindAllStringIndex(s, n), nil
+}
+
+// matchIndexes is like the func of the same name, where the matcher is tried
+// against each substring of s.
+func (m *Matcher) matchIndexes(s string, op byte) [][]int {
+	bounds := make([]int, 0, len(s)+1)
+	for i := range s {
+		bounds = append(bounds, i)
+	}
+	bounds = append(bounds, len(s))
+	switch op {
+	case '#': // the longest prefix
+		for i := len(bounds) - 1; i >= 0; i-- {
+			if end := bounds[i]; m.Match(s[:end]) {
+				return [][]int{{0, end}}
+			}
+		}
+		return nil
+	case '%': // the longest suffix
+		for _, start := range bounds {
+			if m.Match(s[start:]) {
+				return [][]int{{start, len(s)}}
+			}
+		}
+		return nil
+	}
+	// Empty matches right after a previous match are ignored,
+	// like with [regexp.Regexp.FindAllStringIndex].
+	var locs [][]int
+	lastEnd := -1
+	for i := 0; i < len(bounds)-1; i++ {
+		start := bounds[i]
+		for j := len(bounds) - 1; j >= i; j-- {
+			end := bounds[j]
+			if !m.Match(s[start:end]) {
+				continue
+			}
+			if end > start || start != lastEnd {
+				locs = append(locs, []int{start, end})
+				lastEnd = end
+			}
+			if end > start {
+				i = j - 1
+			}
+			break
+		}
+		if op != '/' && len(locs) > 0 {
+			break
+		}
+	}
+	return locs
+}
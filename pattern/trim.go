@@ -0,0 +1,75 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package pattern
+
+// TrimPrefix removes the shortest prefix of s matching the pattern, like
+// Bash's "${name#pattern}", or the longest one if longest is set, like
+// "${name##pattern}". If no prefix matches, s is returned unchanged.
+//
+// Prefixes are only considered at rune boundaries, so that multibyte
+// characters are never split.
+func TrimPrefix(s, pat string, mode Mode, longest bool) (string, error) {
+	start, end, err := trimIndex(s, pat, mode, false, longest)
+	if err != nil {
+		return s, err
+	}
+	return s[:start] + s[end:], nil
+}
+
+// TrimSuffix removes the shortest suffix of s matching the pattern, like
+// Bash's "${name%pattern}", or the longest one if longest is set, like
+// "${name%%pattern}". If no suffix matches, s is returned unchanged.
+//
+// Suffixes are only considered at rune boundaries, so that multibyte
+// characters are never split.
+func TrimSuffix(s, pat string, mode Mode, longest bool) (string, error) {
+	start, end, err := trimIndex(s, pat, mode, true, longest)
+	if err != nil {
+		return s, err
+	}
+	return s[:start] + s[end:], nil
+}
+
+// trimIndex returns the location of the prefix or suffix of s to trim,
+// which is empty if there is none.
+func trimIndex(s, pat string, mode Mode, suffix, longest bool) (start, end int, err error) {
+	op := byte('#')
+	if suffix {
+		op = '%'
+	}
+	locs, err := matchIndexes(pat, s, mode, op)
+	if err != nil || len(locs) == 0 {
+		return 0, 0, err
+	}
+	loc := locs[0]
+	if longest {
+		return loc[0], loc[1], nil
+	}
+	// The shortest match cannot be longer than the longest one,
+	// so only try the rune boundaries within it, starting with the
+	// shortest prefix or suffix.
+	m, err := Compile(pat, mode)
+	if err != nil {
+		return 0, 0, err
+	}
+	var bounds []int
+	for i := range s[loc[0]:loc[1]] {
+		bounds = append(bounds, loc[0]+i)
+	}
+	bounds = append(bounds, loc[1])
+	if suffix {
+		for i := len(bounds) - 1; i >= 0; i-- {
+			if start := bounds[i]; m.Match(s[start:]) {
+				return start, len(s), nil
+			}
+		}
+	} else {
+		for _, end := range bounds {
+			if m.Match(s[:end]) {
+				return 0, end, nil
+			}
+		}
+	}
+	return loc[0], loc[1], nil // not reached, as the longest match matches
+}
@@ -11,4 +11,8 @@
 //	shell.Fields("echo /foo/bar")     // on Unix-like
 //	shell.Fields("echo C:\\foo\\bar") // on Windows
 //	shell.Fields("echo 'C:\foo\bar'") // on Windows, with quotes
+//
+// To do the opposite and quote strings for a shell, such as to build a command
+// line from a list of arguments, see [mvdan.cc/sh/v3/syntax.Quote] and
+// [mvdan.cc/sh/v3/syntax.QuoteFields], which support each shell language variant.
 package shell
@@ -16,36 +16,56 @@ import (
 // expansion, and quote removal.
 //
 // If env is nil, the current environment variables are used. Empty variables
-// are treated as unset; to support variables which are set but empty, use the
-// expand package directly.
+// are treated as unset; to support variables which are set but empty, use
+// [ExpandConfig] with an [expand.Environ] such as [expand.ListEnviron].
 //
 // Command substitutions like $(echo foo) aren't supported to avoid running
 // arbitrary code. To support those, use an interpreter with the expand package.
 //
 // An error will be reported if the input string had invalid syntax.
 func Expand(s string, env func(string) string) (string, error) {
+	return ExpandConfig(s, envConfig(env))
+}
+
+// ExpandConfig is like [Expand], but performs the expansion with cfg,
+// allowing the use of any [expand.Environ] as well as any other options
+// like [expand.Config.NoUnset] or [expand.Config.CmdSubst].
+//
+// If cfg or its Env field is nil, the current environment variables are used.
+// The given configuration is not modified.
+func ExpandConfig(s string, cfg *expand.Config) (string, error) {
 	p := syntax.NewParser()
 	word, err := p.Document(strings.NewReader(s))
 	if err != nil {
 		return "", err
 	}
-	if env == nil {
-		env = os.Getenv
-	}
-	cfg := &expand.Config{Env: expand.FuncEnviron(env)}
-	return expand.Document(cfg, word)
+	return expand.Document(withEnv(cfg), word)
 }
 
 // Fields performs shell expansion on s as if it were a command's arguments,
 // using env to resolve variables. It is similar to Expand, but includes brace
-// expansion, tilde expansion, and globbing.
+// expansion, tilde expansion, and field splitting.
 //
 // If env is nil, the current environment variables are used. Empty variables
-// are treated as unset; to support variables which are set but empty, use the
-// expand package directly.
+// are treated as unset; to support variables which are set but empty, use
+// [FieldsConfig] with an [expand.Environ] such as [expand.ListEnviron].
+//
+// Globbing is not performed, as it would need access to the filesystem.
+// To glob, use [FieldsConfig] with [expand.Config.FS] or [expand.Config.ReadDir2].
 //
 // An error will be reported if the input string had invalid syntax.
 func Fields(s string, env func(string) string) ([]string, error) {
+	return FieldsConfig(s, envConfig(env))
+}
+
+// FieldsConfig is like [Fields], but performs the expansion with cfg,
+// allowing the use of any [expand.Environ] as well as any other options,
+// such as an [expand.Config.FS] to glob with and [expand.Config.ExtGlob]
+// to support extended globbing.
+//
+// If cfg or its Env field is nil, the current environment variables are used.
+// The given configuration is not modified.
+func FieldsConfig(s string, cfg *expand.Config) ([]string, error) {
 	p := syntax.NewParser()
 	var words []*syntax.Word
 	err := p.Words(strings.NewReader(s), func(w *syntax.Word) bool {
@@ -55,9 +75,26 @@ func Fields(s string, env func(string) string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return expand.Fields(withEnv(cfg), words...)
+}
+
+func envConfig(env func(string) string) *expand.Config {
 	if env == nil {
 		env = os.Getenv
 	}
-	cfg := &expand.Config{Env: expand.FuncEnviron(env)}
-	return expand.Fields(cfg, words...)
+	return &expand.Config{Env: expand.FuncEnviron(env)}
+}
+
+// withEnv returns a copy of cfg, using the current environment variables
+// if it has no Env. A copy is also needed as the expand package may
+// modify the configuration it is given.
+func withEnv(cfg *expand.Config) *expand.Config {
+	var cfg2 expand.Config
+	if cfg != nil {
+		cfg2 = *cfg
+	}
+	if cfg2.Env == nil {
+		cfg2.Env = expand.FuncEnviron(os.Getenv)
+	}
+	return &cfg2
 }
@@ -10,6 +10,9 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"testing/fstest"
+
+	"mvdan.cc/sh/v3/expand"
 )
 
 func strEnviron(pairs ...string) func(string) string {
@@ -124,3 +127,46 @@ func TestFields(t *testing.T) {
 		})
 	}
 }
+
+func TestExpandConfig(t *testing.T) {
+	t.Parallel()
+	cfg := &expand.Config{Env: expand.ListEnviron("x=")}
+	got, err := ExpandConfig("${x-unset} ${y-unset}", cfg)
+	if want := " unset"; err != nil || got != want {
+		t.Fatalf("\nwant: %q\ngot:  %q, %v", want, got, err)
+	}
+	cfg = &expand.Config{NoUnset: true}
+	if _, err := ExpandConfig("$INTERP_SHELL_MISSING", cfg); err == nil {
+		t.Fatalf("wanted an error with NoUnset")
+	}
+	if cfg.Env != nil {
+		t.Fatalf("the given config was modified")
+	}
+	if _, err := ExpandConfig("${", nil); err == nil {
+		t.Fatalf("wanted a syntax error")
+	}
+}
+
+func TestFieldsConfig(t *testing.T) {
+	t.Parallel()
+	cfg := &expand.Config{
+		Env: expand.ListEnviron("x=a b"),
+		FS: fstest.MapFS{
+			"a.go":     {},
+			"b.go":     {},
+			"c.txt":    {},
+			"dir/d.go": {},
+		},
+		ExtGlob: true,
+	}
+	got, err := FieldsConfig("$x *.go !(*.go) dir/*", cfg)
+	want := []string{"a", "b", "a.go", "b.go", "c.txt", "dir", "dir/d.go"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("\nwant: %q\ngot:  %q, %v", want, got, err)
+	}
+	got, err = FieldsConfig("{x,y}z", nil)
+	want = []string{"xz", "yz"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Fatalf("\nwant: %q\ngot:  %q, %v", want, got, err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package shell
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"strings"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Result holds the outcome of running a program via [Run].
+type Result struct {
+	// Stdout and Stderr hold the program's standard output and error.
+	Stdout, Stderr string
+
+	// ExitCode is the program's exit status, such as 1 after "false"
+	// or 3 after "exit 3".
+	ExitCode int
+
+	// Vars holds the variables at the end of the program, including those
+	// from the environment it was given.
+	Vars map[string]expand.Variable
+}
+
+// Run parses and runs a shell program with an interpreter, capturing its
+// output, exit status, and final variables. It saves the usual boilerplate
+// needed to run a program with the syntax and interp packages.
+//
+// The program is run via [interp.New] with the given options, so by default,
+// it uses the current process's environment and working directory, and can
+// run any external program. Use options like [interp.Sandbox] and [interp.Env]
+// to change that. Its standard input is empty; note that passing
+// [interp.StdIO] disables capturing the output.
+//
+// A program exiting with a non-zero status is not an error; see
+// [Result.ExitCode]. An error is returned if the program cannot be parsed,
+// or if it stops due to a fatal error such as ctx being cancelled, in which
+// case the returned result holds what happened up to that point.
+func Run(ctx context.Context, src string, opts ...interp.RunnerOption) (Result, error) {
+	file, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+	if err != nil {
+		return Result{}, err
+	}
+	var stdout, stderr bytes.Buffer
+	opts = append([]interp.RunnerOption{
+		interp.StdIO(nil, &stdout, &stderr),
+	}, opts...)
+	r, err := interp.New(opts...)
+	if err != nil {
+		return Result{}, err
+	}
+	err = r.Run(ctx, file)
+	res := Result{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+		Vars:   maps.Clone(r.Vars),
+	}
+	if status, ok := interp.IsExitStatus(err); ok {
+		res.ExitCode = int(status)
+		err = nil
+	}
+	return res, err
+}
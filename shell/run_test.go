@@ -0,0 +1,72 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package shell
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+)
+
+var runTests = []struct {
+	src    string
+	stdout string
+	stderr string
+	code   int
+}{
+	{"echo foo", "foo\n", "", 0},
+	{"echo foo >&2; false", "", "foo\n", 1},
+	{"echo $FOO; exit 3; echo unreachable", "bar\n", "", 3},
+	{"f() { return 4; }; f", "", "", 4},
+	{"printf '%s-' a b | { read -r x; echo $x; }", "a-b-\n", "", 0},
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	for _, tc := range runTests {
+		res, err := Run(context.Background(), tc.src,
+			interp.Env(expand.ListEnviron("FOO=bar")))
+		if err != nil {
+			t.Errorf("Run(%q) errored: %v", tc.src, err)
+			continue
+		}
+		if res.Stdout != tc.stdout || res.Stderr != tc.stderr || res.ExitCode != tc.code {
+			t.Errorf("Run(%q) got %q, %q, %d; want %q, %q, %d", tc.src,
+				res.Stdout, res.Stderr, res.ExitCode, tc.stdout, tc.stderr, tc.code)
+		}
+	}
+
+	res, err := Run(context.Background(), "export x=1; y=(a b); unset FOO",
+		interp.Env(expand.ListEnviron("FOO=bar", "BAZ=qux")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vr := res.Vars["x"]; vr.String() != "1" || !vr.Exported {
+		t.Errorf("unexpected x: %#v", vr)
+	}
+	if vr := res.Vars["y"]; vr.Kind != expand.Indexed || len(vr.List) != 2 {
+		t.Errorf("unexpected y: %#v", vr)
+	}
+	if vr := res.Vars["BAZ"]; vr.String() != "qux" {
+		t.Errorf("unexpected BAZ: %#v", vr)
+	}
+
+	if _, err := Run(context.Background(), "echo ${"); err == nil {
+		t.Errorf("wanted a parse error")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	res, err = Run(ctx, "echo before; while true; do :; done")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wanted a deadline error, got %v", err)
+	}
+	if res.Stdout != "before\n" {
+		t.Errorf("wanted the output so far, got %q", res.Stdout)
+	}
+}
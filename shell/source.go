@@ -0,0 +1,99 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package shell
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Sourced holds what a script run via [SourceFile] or [SourceNode] defined.
+type Sourced struct {
+	// Vars holds the variables which the script set or modified,
+	// not including those which it left as they were in the environment.
+	Vars map[string]expand.Variable
+
+	// Funcs holds the functions which the script defined.
+	Funcs map[string]*syntax.Stmt
+}
+
+// SourceFile parses and runs a shell script file, returning the variables and
+// functions it defined, such as when loading a configuration file written in
+// shell.
+//
+// See [SourceNode] for how the script is run.
+func SourceFile(ctx context.Context, path string, opts ...interp.RunnerOption) (*Sourced, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open: %w", err)
+	}
+	defer f.Close()
+	file, err := syntax.NewParser().Parse(f, path)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse: %w", err)
+	}
+	return SourceNode(ctx, file, opts...)
+}
+
+// SourceNode runs a shell program from a node, returning the variables and
+// functions it defined. It accepts the same set of node types that
+// [interp.Runner.Run] does.
+//
+// The program is run with [interp.Sandbox], so it is safe to source untrusted
+// scripts; in particular, they cannot run external programs nor write to files.
+// It also runs with an empty environment, so that it cannot read the current
+// process's environment variables, which may hold secrets. Its output is
+// discarded.
+//
+// The options are applied after those defaults, so they can be used to
+// configure the runner further, such as [interp.Env] to give the program an
+// environment, [interp.Limits] to further limit it, or [interp.StdIO] to see
+// its output. Note that the sandbox cannot be disabled; to source trusted
+// scripts without restrictions, use a [interp.Runner] directly.
+//
+// Use ctx to limit how long the program may run for, as Run on a sandboxed
+// runner already times out after ten seconds.
+//
+// An error is returned if the program fails, such as when it exits with a
+// non-zero status.
+func SourceNode(ctx context.Context, node syntax.Node, opts ...interp.RunnerOption) (*Sourced, error) {
+	opts = append([]interp.RunnerOption{
+		interp.Sandbox(),
+		interp.Env(expand.ListEnviron()),
+	}, opts...)
+	r, err := interp.New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// Run an empty program first, so that we can tell apart the variables
+	// which were set by the program from those set by the runner itself,
+	// like PWD, or those inherited from the environment.
+	if err := r.Run(ctx, &syntax.File{}); err != nil {
+		return nil, err
+	}
+	before := maps.Clone(r.Vars)
+	if err := r.Run(ctx, node); err != nil {
+		return nil, fmt.Errorf("could not run: %w", err)
+	}
+	sourced := &Sourced{
+		Vars:  make(map[string]expand.Variable),
+		Funcs: maps.Clone(r.Funcs),
+	}
+	for name, vr := range r.Vars {
+		if prev, ok := before[name]; !ok || !reflect.DeepEqual(vr, prev) {
+			sourced.Vars[name] = vr
+		}
+	}
+	if sourced.Funcs == nil {
+		sourced.Funcs = make(map[string]*syntax.Stmt)
+	}
+	return sourced, nil
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package shell
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+func TestSourceFile(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.sh")
+	src := `
+name=foo
+export LEVEL=$((LEVEL + 1))
+list=(a b)
+greet() { echo "hi $name"; }
+echo "loaded $name"
+`
+	if err := os.WriteFile(path, []byte(src), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	sourced, err := SourceFile(context.Background(), path,
+		interp.Env(expand.ListEnviron("LEVEL=2", "OTHER=x")),
+		interp.StdIO(nil, &out, &out),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range sourced.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if want := []string{"LEVEL", "list", "name"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("got vars %q, want %q", names, want)
+	}
+	if vr := sourced.Vars["LEVEL"]; vr.String() != "3" || !vr.Exported {
+		t.Fatalf("unexpected LEVEL: %#v", vr)
+	}
+	if vr := sourced.Vars["list"]; !reflect.DeepEqual(vr.List, []string{"a", "b"}) {
+		t.Fatalf("unexpected list: %#v", vr)
+	}
+	if sourced.Funcs["greet"] == nil || len(sourced.Funcs) != 1 {
+		t.Fatalf("unexpected funcs: %v", sourced.Funcs)
+	}
+	if got, want := out.String(), "loaded foo\n"; got != want {
+		t.Fatalf("got output %q, want %q", got, want)
+	}
+
+	if _, err := SourceFile(context.Background(), filepath.Join(dir, "missing.sh")); err == nil {
+		t.Fatal("wanted an error for a missing file")
+	}
+}
+
+func TestSourceNodeSandbox(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	parse := func(src string) *syntax.File {
+		f, err := syntax.NewParser().Parse(strings.NewReader(src), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+	ctx := context.Background()
+
+	// The environment is empty by default.
+	sourced, err := SourceNode(ctx, parse(`leaked=$PATH`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sourced.Vars["leaked"].String(); got != "" {
+		t.Fatalf("the environment leaked: %q", got)
+	}
+
+	// Writing files and running programs is not allowed.
+	target := filepath.Join(dir, "written")
+	if _, err := SourceNode(ctx, parse(`echo foo >`+target)); err == nil {
+		t.Fatal("wanted an error when writing a file")
+	}
+	if _, err := os.Stat(target); err == nil {
+		t.Fatal("the file was written")
+	}
+	if _, err := SourceNode(ctx, parse(`touch `+target)); err == nil {
+		t.Fatal("wanted an error when running a program")
+	}
+
+	// The context can stop an endless program.
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = SourceNode(ctx, parse(`while true; do :; done`), interp.Limits(interp.LimitConfig{}))
+	if err == nil {
+		t.Fatal("wanted an error for an endless program")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, interp.ErrSandboxLimit) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -9,30 +9,42 @@ import (
 	"testing"
 )
 
+var benchParseSrc = "" +
+	strings.Repeat("\n\n\t\t        \n", 10) +
+	"# " + strings.Repeat("foo bar ", 10) + "\n" +
+	strings.Repeat("longlit_", 10) + "\n" +
+	"'" + strings.Repeat("foo bar ", 10) + "'\n" +
+	`"` + strings.Repeat("foo bar ", 10) + `"` + "\n" +
+	strings.Repeat("aa bb cc dd; ", 6) +
+	"a() { (b); { c; }; }; $(d; `e`)\n" +
+	"foo=bar; a=b; c=d$foo${bar}e $simple ${complex:-default}\n" +
+	"if a; then while b; do for c in d e; do f; done; done; fi\n" +
+	"a | b && c || d | e && g || f\n" +
+	"foo >a <b <<<c 2>&1 <<EOF\n" +
+	strings.Repeat("somewhat long heredoc line\n", 10) +
+	"EOF" +
+	""
+
 func BenchmarkParse(b *testing.B) {
 	b.ReportAllocs()
-	src := "" +
-		strings.Repeat("\n\n\t\t        \n", 10) +
-		"# " + strings.Repeat("foo bar ", 10) + "\n" +
-		strings.Repeat("longlit_", 10) + "\n" +
-		"'" + strings.Repeat("foo bar ", 10) + "'\n" +
-		`"` + strings.Repeat("foo bar ", 10) + `"` + "\n" +
-		strings.Repeat("aa bb cc dd; ", 6) +
-		"a() { (b); { c; }; }; $(d; `e`)\n" +
-		"foo=bar; a=b; c=d$foo${bar}e $simple ${complex:-default}\n" +
-		"if a; then while b; do for c in d e; do f; done; done; fi\n" +
-		"a | b && c || d | e && g || f\n" +
-		"foo >a <b <<<c 2>&1 <<EOF\n" +
-		strings.Repeat("somewhat long heredoc line\n", 10) +
-		"EOF" +
-		""
 	p := NewParser(KeepComments(true))
-	in := strings.NewReader(src)
+	in := strings.NewReader(benchParseSrc)
 	for i := 0; i < b.N; i++ {
 		if _, err := p.Parse(in, ""); err != nil {
 			b.Fatal(err)
 		}
-		in.Reset(src)
+		in.Reset(benchParseSrc)
+	}
+}
+
+func BenchmarkParseBytes(b *testing.B) {
+	b.ReportAllocs()
+	p := NewParser(KeepComments(true))
+	src := []byte(benchParseSrc)
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ParseBytes(src, ""); err != nil {
+			b.Fatal(err)
+		}
 	}
 }
 
@@ -0,0 +1,577 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	"mvdan.cc/sh/v3/internal/shebang"
+)
+
+// ConvertError describes a construct which [Convert] could not rewrite for
+// another language variant, as it has no equivalent which is safe to use.
+type ConvertError struct {
+	Pos     Pos
+	Feature string
+	Lang    LangVariant
+}
+
+func (e ConvertError) Error() string {
+	return fmt.Sprintf("%s: cannot convert %s to %s", e.Pos, e.Feature, e.Lang)
+}
+
+// Convert modifies a node parsed as the language variant from so that it can
+// be run as the variant to, which must be [LangPOSIX], [LangBash], or
+// [LangMirBSDKorn]. It returns the errors for the constructs which could not
+// be converted, sorted by position; those are left as they were.
+//
+// Only rewrites which keep what a program does are made. For instance, when
+// converting to POSIX:
+//
+//	function foo { bar; }   →  foo() { bar; }
+//	foo &>file              →  foo >file 2>&1
+//	foo |& bar              →  foo 2>&1 | bar
+//	echo $'a\tb'            →  echo 'a	b'
+//	[[ -n $a && $b == x ]]  →  [ -n "$a" ] && [ "$b" = x ]
+//	((i > 0))               →  [ "$((i > 0))" -ne 0 ]
+//	a+=b                    →  a=${a}b
+//
+// Whereas constructs like arrays or process substitutions are errors.
+// Test expressions with patterns like "[[ $a == x* ]]" are errors as well,
+// as "[" can only compare strings.
+//
+// If the node is a [*File] starting with a shebang for the source language,
+// like "#!/bin/bash", it is updated to use the target language.
+func Convert(node Node, from, to LangVariant) []ConvertError {
+	switch to {
+	case LangPOSIX, LangBash, LangMirBSDKorn:
+	default:
+		panic(fmt.Sprintf("cannot convert to %s", to))
+	}
+	c := converter{from: from, to: to}
+	if f, ok := node.(*File); ok {
+		c.shebang(f)
+	}
+	Walk(node, c.visit)
+	slices.SortStableFunc(c.errs, func(a, b ConvertError) int {
+		return cmp.Compare(a.Pos.Offset(), b.Pos.Offset())
+	})
+	return c.errs
+}
+
+type converter struct {
+	from, to LangVariant
+	errs     []ConvertError
+}
+
+func (c *converter) errorf(pos Pos, feature string) {
+	c.errs = append(c.errs, ConvertError{Pos: pos, Feature: feature, Lang: c.to})
+}
+
+func (c *converter) posix() bool { return c.to == LangPOSIX }
+func (c *converter) bash() bool  { return c.to == LangBash }
+func (c *converter) mksh() bool  { return c.to == LangMirBSDKorn }
+
+// shellNames are the names of the shells in shebangs for each language.
+var shellNames = map[LangVariant]string{
+	LangPOSIX:      "sh",
+	LangBash:       "bash",
+	LangMirBSDKorn: "mksh",
+}
+
+func (c *converter) shebang(f *File) {
+	var com *Comment
+	switch {
+	case len(f.Stmts) > 0 && len(f.Stmts[0].Comments) > 0:
+		com = &f.Stmts[0].Comments[0]
+	case len(f.Last) > 0:
+		com = &f.Last[0]
+	default:
+		return
+	}
+	if com.Hash.Offset() != 0 || !strings.HasPrefix(com.Text, "!") {
+		return
+	}
+	shell := shebang.Shell([]byte("#" + com.Text))
+	if shell == "" || shell == shellNames[c.to] || shell != shellNames[c.from] {
+		return
+	}
+	// Replace the shell's name as a whole word, such as in "#!/bin/sh"
+	// or "#!/usr/bin/env bash", but not in "#!/bin/dash".
+	for i := strings.LastIndex(com.Text, shell); i > 0; i = strings.LastIndex(com.Text[:i], shell) {
+		end := i + len(shell)
+		if strings.ContainsRune("/ \t", rune(com.Text[i-1])) &&
+			(end == len(com.Text) || strings.ContainsRune(" \t", rune(com.Text[end]))) {
+			com.Text = com.Text[:i] + shellNames[c.to] + com.Text[end:]
+			return
+		}
+	}
+}
+
+func (c *converter) visit(node Node) bool {
+	switch node := node.(type) {
+	case *Stmt:
+		c.stmt(node)
+	case *BinaryCmd:
+		if node.Op == PipeAll && !c.bash() {
+			node.Op = Pipe
+			node.X.Redirs = append(node.X.Redirs, dupStderr(node.X.End()))
+		}
+	case *FuncDecl:
+		if node.RsrvWord && (c.posix() || (c.mksh() && c.from != LangMirBSDKorn)) {
+			// Korn shells scope some state to functions declared with
+			// the "function" keyword, unlike Bash.
+			if !ValidName(node.Name.Value) {
+				c.errorf(node.Name.Pos(), "function names which are not valid variable names")
+				break
+			}
+			node.RsrvWord = false
+			node.Parens = true
+		}
+	case *ForClause:
+		if node.Select && c.posix() {
+			c.errorf(node.Pos(), "select loops")
+		}
+		if node.Braces && c.posix() {
+			node.Braces = false
+		}
+		if _, ok := node.Loop.(*CStyleLoop); ok && !c.bash() {
+			c.errorf(node.Pos(), "c-style fors")
+		}
+	case *WordIter:
+		if !c.bash() && !c.mksh() {
+			c.braces(node.Items)
+		}
+	case *CallExpr:
+		if c.posix() {
+			c.braces(node.Args)
+		}
+	case *CaseItem:
+		switch node.Op {
+		case Fallthrough:
+			if c.posix() {
+				c.errorf(node.OpPos, "case fallthroughs")
+			}
+		case Resume, ResumeKorn:
+			switch {
+			case c.posix():
+				c.errorf(node.OpPos, "case fallthroughs")
+			case c.bash():
+				node.Op = Resume
+			case c.mksh():
+				node.Op = ResumeKorn
+			}
+		}
+	case *Assign:
+		c.assign(node)
+	case *Word:
+		c.word(node)
+	case *DblQuoted:
+		if node.Dollar && !c.bash() {
+			node.Dollar = false
+		}
+	case *ParamExp:
+		c.paramExp(node)
+	case *CmdSubst:
+		if (node.TempFile || node.ReplyVar) && !c.mksh() {
+			c.errorf(node.Pos(), "mksh command substitutions")
+		}
+	case *ArithmExp:
+		if node.Bracket {
+			node.Bracket = false
+		}
+		if node.Unsigned && !c.mksh() {
+			c.errorf(node.Pos(), "unsigned expressions")
+		}
+	case *ArithmCmd:
+		if node.Unsigned && !c.mksh() {
+			c.errorf(node.Pos(), "unsigned expressions")
+		}
+	case *UnaryArithm:
+		if (node.Op == Inc || node.Op == Dec) && c.posix() {
+			c.errorf(node.OpPos, "increments and decrements")
+		}
+	case *BinaryArithm:
+		if (node.Op == Pow || node.Op == Comma) && c.posix() {
+			c.errorf(node.OpPos, fmt.Sprintf("the %s arithmetic operator", node.Op))
+		}
+	case *TestClause:
+		if c.posix() {
+			// Tests which can be converted were replaced in stmt.
+			return false
+		}
+		if c.mksh() {
+			Walk(node.X, func(node Node) bool {
+				if b, ok := node.(*BinaryTest); ok && b.Op == TsReMatch {
+					c.errorf(b.OpPos, "regex tests")
+				}
+				return true
+			})
+		}
+	case *ExtGlob:
+		if c.posix() {
+			c.errorf(node.Pos(), "extended globs")
+		}
+	case *ProcSubst:
+		if !c.bash() {
+			c.errorf(node.Pos(), "process substitutions")
+		}
+	case *CoprocClause:
+		if !c.bash() {
+			c.errorf(node.Pos(), "coprocesses")
+		}
+	case *LetClause:
+		if c.posix() {
+			c.errorf(node.Pos(), "let")
+		}
+	case *DeclClause:
+		switch v := node.Variant.Value; {
+		case c.posix() && v != "export" && v != "readonly":
+			c.errorf(node.Pos(), v)
+		case c.mksh() && v == "declare":
+			c.errorf(node.Pos(), v)
+		}
+	case *TestDecl:
+		if c.from == LangBats {
+			c.errorf(node.Pos(), "bats tests")
+		}
+	}
+	return true
+}
+
+func (c *converter) stmt(s *Stmt) {
+	if s.Coprocess && !c.mksh() {
+		c.errorf(s.Semicolon, "coprocesses")
+	}
+	if len(s.Redirs) > 0 {
+		redirs := make([]*Redirect, 0, len(s.Redirs))
+		for _, r := range s.Redirs {
+			redirs = append(redirs, r)
+			if r.N != nil && strings.HasPrefix(r.N.Value, "{") && !c.bash() {
+				c.errorf(r.Pos(), "{varname} redirects")
+			}
+			switch r.Op {
+			case RdrAll, AppAll:
+				if c.posix() {
+					if r.Op == RdrAll {
+						r.Op = RdrOut
+					} else {
+						r.Op = AppOut
+					}
+					redirs = append(redirs, dupStderr(r.End()))
+				}
+			case WordHdoc:
+				if c.posix() {
+					c.errorf(r.OpPos, "herestrings")
+				}
+			}
+		}
+		s.Redirs = redirs
+	}
+	if !c.posix() {
+		return
+	}
+	switch cmd := s.Cmd.(type) {
+	case *TestClause:
+		t := c.test(cmd.X)
+		if t == nil {
+			break
+		}
+		if _, ok := t.Cmd.(*BinaryCmd); ok && (s.Negated || len(s.Redirs) > 0) {
+			// "! [ a ] && [ b ]" would only negate the first test.
+			s.Cmd = &Block{Lbrace: t.Pos(), Rbrace: t.End(), Stmts: []*Stmt{t}}
+		} else {
+			s.Cmd = t.Cmd
+			s.Negated = s.Negated != t.Negated
+		}
+	case *ArithmCmd:
+		if cmd.Unsigned {
+			break
+		}
+		left, right := cmd.Pos(), cmd.End()
+		s.Cmd = &CallExpr{Args: []*Word{
+			newLitWord(left, "["),
+			{Parts: []WordPart{&DblQuoted{
+				Left:  left,
+				Right: right,
+				Parts: []WordPart{&ArithmExp{Left: left, Right: right, X: cmd.X}},
+			}}},
+			newLitWord(right, "-ne"),
+			newLitWord(right, "0"),
+			newLitWord(right, "]"),
+		}}
+	}
+}
+
+// dupStderr returns a "2>&1" redirect at the given position.
+func dupStderr(pos Pos) *Redirect {
+	pos = posAddCol(pos, 1)
+	return &Redirect{
+		OpPos: posAddCol(pos, 1),
+		Op:    DplOut,
+		N:     newLit(pos, "2"),
+		Word:  newLitWord(posAddCol(pos, 3), "1"),
+	}
+}
+
+func newLit(pos Pos, value string) *Lit {
+	end := pos
+	if pos.IsValid() {
+		end = posAddCol(pos, len(value))
+	}
+	return &Lit{ValuePos: pos, ValueEnd: end, Value: value}
+}
+
+func newLitWord(pos Pos, value string) *Word {
+	return &Word{Parts: []WordPart{newLit(pos, value)}}
+}
+
+// braces reports brace expansions in words, which are only supported by Bash
+// and mksh.
+func (c *converter) braces(words []*Word) {
+	if c.from != LangBash && c.from != LangMirBSDKorn && c.from != LangBats {
+		return // they were literal strings to begin with
+	}
+	for _, w := range words {
+		// SplitBraces only modifies the word's list of parts.
+		if SplitBraces(&Word{Parts: w.Parts}) {
+			c.errorf(w.Pos(), "brace expansions")
+		}
+	}
+}
+
+func (c *converter) assign(as *Assign) {
+	if as.Array != nil && c.posix() {
+		c.errorf(as.Pos(), "arrays")
+		return
+	}
+	if as.Index != nil && c.posix() {
+		c.errorf(as.Pos(), "arrays")
+		return
+	}
+	if as.Append && c.posix() && as.Name != nil {
+		// a+=b is the same as a=${a}b for strings.
+		as.Append = false
+		pe := &ParamExp{Dollar: as.Name.Pos(), Rbrace: as.Name.End(), Param: newLit(as.Name.Pos(), as.Name.Value)}
+		if as.Value == nil {
+			as.Value = &Word{}
+		}
+		as.Value.Parts = append([]WordPart{pe}, as.Value.Parts...)
+	}
+}
+
+func (c *converter) paramExp(pe *ParamExp) {
+	switch {
+	case pe.Width && !c.mksh():
+		c.errorf(pe.Pos(), "${%name} expansions")
+	case pe.Names != 0 && !c.bash():
+		c.errorf(pe.Pos(), "${!prefix*} expansions")
+	case pe.Excl && !c.bash():
+		c.errorf(pe.Pos(), "indirect expansions")
+	case pe.Index != nil && c.posix():
+		c.errorf(pe.Pos(), "arrays")
+	case pe.Slice != nil && c.posix():
+		c.errorf(pe.Pos(), "slicing")
+	case pe.Repl != nil && c.posix():
+		c.errorf(pe.Pos(), "search and replace")
+	case pe.Exp == nil:
+	case pe.Exp.Op >= UpperFirst && pe.Exp.Op <= LowerAll && !c.bash():
+		c.errorf(pe.Pos(), "case modifications")
+	case pe.Exp.Op == OtherParamOps:
+		switch op := pe.Exp.Word.Lit(); {
+		case c.posix(),
+			op == "#" && !c.mksh(),
+			op != "#" && op != "Q" && !c.bash():
+			c.errorf(pe.Pos(), "the @"+op+" expansion operator")
+		}
+	}
+}
+
+// word converts the $'...' strings in a word to plain single quotes.
+func (c *converter) word(w *Word) {
+	if !c.posix() {
+		return
+	}
+	for i := 0; i < len(w.Parts); i++ {
+		sq, ok := w.Parts[i].(*SglQuoted)
+		if !ok || !sq.Dollar {
+			continue
+		}
+		var sb strings.Builder
+		if err := unquoteDollar(&sb, sq.Value, 0); err != nil {
+			c.errorf(sq.Pos(), "$'' strings with null characters")
+			continue
+		}
+		// Single quotes cannot hold single quotes, so those are escaped
+		// outside of any quotes, like 'it'\''s'.
+		var parts []WordPart
+		for j, s := range strings.Split(sb.String(), "'") {
+			if j > 0 {
+				parts = append(parts, newLit(sq.Pos(), `\'`))
+			}
+			if s != "" || j == 0 {
+				parts = append(parts, &SglQuoted{Left: sq.Left, Right: sq.Right, Value: s})
+			}
+		}
+		w.Parts = slices.Replace(w.Parts, i, i+1, parts...)
+		i += len(parts) - 1
+	}
+}
+
+// posixUnaryTests maps the unary operators of [[ ]] to those supported by the
+// POSIX test command, which is often "-e" for "-a".
+var posixUnaryTests = map[UnTestOperator]string{
+	TsExists: "-e", TsRegFile: "-f", TsDirect: "-d", TsCharSp: "-c",
+	TsBlckSp: "-b", TsNmPipe: "-p", TsSocket: "-S", TsSmbLink: "-h",
+	TsGIDSet: "-g", TsUIDSet: "-u", TsRead: "-r", TsWrite: "-w",
+	TsExec: "-x", TsNoEmpty: "-s", TsFdTerm: "-t", TsEmpStr: "-z",
+	TsNempStr: "-n",
+}
+
+// test converts a [[ ]] expression to a statement using POSIX test commands,
+// or returns nil if it cannot be converted.
+func (c *converter) test(expr TestExpr) *Stmt {
+	switch x := expr.(type) {
+	case *Word:
+		w := c.testOperand(x)
+		if w == nil {
+			return nil
+		}
+		return testStmt(newLitWord(x.Pos(), "-n"), w)
+	case *ParenTest:
+		return c.test(x.X)
+	case *UnaryTest:
+		if x.Op == TsNot {
+			s := c.test(x.X)
+			if s == nil {
+				return nil
+			}
+			if _, ok := s.Cmd.(*BinaryCmd); ok {
+				s = &Stmt{Position: s.Pos(), Cmd: &Block{Lbrace: s.Pos(), Rbrace: s.End(), Stmts: []*Stmt{s}}}
+			}
+			s.Negated = !s.Negated
+			return s
+		}
+		op, ok := posixUnaryTests[x.Op]
+		if !ok {
+			c.errorf(x.OpPos, fmt.Sprintf("the %s test operator", x.Op))
+			return nil
+		}
+		w, ok := x.X.(*Word)
+		if !ok {
+			return nil
+		}
+		if w = c.testOperand(w); w == nil {
+			return nil
+		}
+		return testStmt(newLitWord(x.OpPos, op), w)
+	case *BinaryTest:
+		switch x.Op {
+		case AndTest, OrTest:
+			left, right := c.test(x.X), c.test(x.Y)
+			if left == nil || right == nil {
+				return nil
+			}
+			if _, ok := right.Cmd.(*BinaryCmd); ok {
+				// The shell's && and || have the same precedence,
+				// unlike in test expressions.
+				right = &Stmt{Position: right.Pos(), Cmd: &Block{Lbrace: right.Pos(), Rbrace: right.End(), Stmts: []*Stmt{right}}}
+			}
+			op := AndStmt
+			if x.Op == OrTest {
+				op = OrStmt
+			}
+			return &Stmt{Position: left.Pos(), Cmd: &BinaryCmd{OpPos: x.OpPos, Op: op, X: left, Y: right}}
+		case TsMatch, TsMatchShort, TsNoMatch,
+			TsEql, TsNeq, TsLeq, TsGeq, TsLss, TsGtr:
+		default:
+			c.errorf(x.OpPos, fmt.Sprintf("the %s test operator", x.Op))
+			return nil
+		}
+		op := x.Op.String()
+		wx, okx := x.X.(*Word)
+		wy, oky := x.Y.(*Word)
+		if !okx || !oky {
+			return nil
+		}
+		switch x.Op {
+		case TsMatch, TsMatchShort, TsNoMatch:
+			// The right side is a pattern, which cannot be converted
+			// unless it is a plain string.
+			if !c.plainPattern(wy) {
+				return nil
+			}
+			if x.Op == TsMatch {
+				op = "="
+			}
+		}
+		if wx, wy = c.testOperand(wx), c.testOperand(wy); wx == nil || wy == nil {
+			return nil
+		}
+		return testStmt(wx, newLitWord(x.OpPos, op), wy)
+	}
+	return nil
+}
+
+// testStmt returns a statement like "[ args ]".
+func testStmt(args ...*Word) *Stmt {
+	first, last := args[0].Pos(), args[len(args)-1].End()
+	if first.IsValid() {
+		first = posAddCol(first, -2)
+	}
+	args = append([]*Word{newLitWord(first, "[")}, args...)
+	args = append(args, newLitWord(posAddCol(last, 1), "]"))
+	return &Stmt{Position: first, Cmd: &CallExpr{Args: args}}
+}
+
+// plainPattern reports whether a pattern on the right side of "==" in [[ ]]
+// only matches itself, so that it can be compared as a string.
+func (c *converter) plainPattern(w *Word) bool {
+	for _, part := range w.Parts {
+		switch part := part.(type) {
+		case *Lit:
+			if strings.ContainsAny(part.Value, `*?[\`) {
+				c.errorf(w.Pos(), "pattern matching in tests")
+				return false
+			}
+		case *SglQuoted, *DblQuoted:
+		default:
+			// The value of an expansion may have special characters.
+			c.errorf(part.Pos(), "unquoted expansions as patterns in tests")
+			return false
+		}
+	}
+	return true
+}
+
+// testOperand converts an operand in [[ ]] to one for the POSIX test command,
+// which is subject to field splitting and globbing.
+func (c *converter) testOperand(w *Word) *Word {
+	parts := make([]WordPart, 0, len(w.Parts))
+	for _, part := range w.Parts {
+		switch part := part.(type) {
+		case *Lit:
+			if strings.ContainsAny(part.Value, "*?[{") {
+				if strings.Contains(part.Value, `\`) {
+					c.errorf(part.Pos(), "glob characters in tests")
+					return nil
+				}
+				parts = append(parts, &SglQuoted{Left: part.Pos(), Right: part.End(), Value: part.Value})
+				continue
+			}
+		case *ParamExp, *CmdSubst, *ArithmExp:
+			parts = append(parts, &DblQuoted{Left: part.Pos(), Right: part.End(), Parts: []WordPart{part}})
+			continue
+		case *SglQuoted, *DblQuoted:
+		default:
+			c.errorf(part.Pos(), "this test operand")
+			return nil
+		}
+		parts = append(parts, part)
+	}
+	return &Word{Parts: parts}
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var convertTests = [...]struct {
+	from, to LangVariant
+	in, want string
+	errs     []string
+}{
+	// bash to posix
+	{LangBash, LangPOSIX, "function foo { bar; }", "foo() { bar; }", nil},
+	{LangBash, LangPOSIX, "function foo() { bar; }", "foo() { bar; }", nil},
+	{LangBash, LangPOSIX, "function a-b { :; }", "function a-b { :; }", []string{
+		"1:10: cannot convert function names which are not valid variable names to posix",
+	}},
+	{LangBash, LangPOSIX, "foo &>f; bar &>>f", "foo >f 2>&1\nbar >>f 2>&1", nil},
+	{LangBash, LangPOSIX, "foo |& bar", "foo 2>&1 | bar", nil},
+	{LangBash, LangPOSIX, `echo $'a\tb' $'it\'s'`, "echo 'a\tb' 'it'\\''s'", nil},
+	{LangBash, LangPOSIX, `echo $"foo $bar"`, `echo "foo $bar"`, nil},
+	{LangBash, LangPOSIX, "echo $[1 + 2]", "echo $((1 + 2))", nil},
+	{LangBash, LangPOSIX, "a+=b; c+=$d", "a=${a}b\nc=${c}$d", nil},
+	{LangBash, LangPOSIX, "for i in a b; { :; }", "for i in a b; do :; done", nil},
+	{LangBash, LangPOSIX, "((i > 0)) && foo", `[ "$((i > 0))" -ne 0 ] && foo`, nil},
+
+	// bash tests to posix
+	{LangBash, LangPOSIX, "[[ -n $a ]]", `[ -n "$a" ]`, nil},
+	{LangBash, LangPOSIX, "[[ $a ]]", `[ -n "$a" ]`, nil},
+	{LangBash, LangPOSIX, "[[ -a $f && -d dir ]]", `[ -e "$f" ] && [ -d dir ]`, nil},
+	{LangBash, LangPOSIX, `[[ $a == "x" || $b != *y* ]]`, `[[ $a == "x" || $b != *y* ]]`, []string{
+		"1:23: cannot convert pattern matching in tests to posix",
+	}},
+	{LangBash, LangPOSIX, `[[ $a == "$b" ]]`, `[ "$a" = "$b" ]`, nil},
+	{LangBash, LangPOSIX, "[[ $a == $b ]]", "[[ $a == $b ]]", []string{
+		"1:10: cannot convert unquoted expansions as patterns in tests to posix",
+	}},
+	{LangBash, LangPOSIX, "[[ $a -eq 3 ]]", `[ "$a" -eq 3 ]`, nil},
+	{LangBash, LangPOSIX, `[[ x*y = "$a" ]]`, `[ 'x*y' = "$a" ]`, nil},
+	{LangBash, LangPOSIX, "[[ a < b ]]", "[[ a < b ]]", []string{
+		"1:6: cannot convert the < test operator to posix",
+	}},
+	{LangBash, LangPOSIX, "[[ a && (b || c) ]]", "[ -n a ] && { [ -n b ] || [ -n c ]; }", nil},
+	{LangBash, LangPOSIX, "[[ ! (a && b) ]]", "! { [ -n a ] && [ -n b ]; }", nil},
+	{LangBash, LangPOSIX, "! [[ ! -f f ]]", "[ -f f ]", nil},
+	{LangBash, LangPOSIX, "[[ a && b ]] >f", "{ [ -n a ] && [ -n b ]; } >f", nil},
+	{LangBash, LangPOSIX, "[[ $a =~ x ]]", "[[ $a =~ x ]]", []string{
+		"1:7: cannot convert the =~ test operator to posix",
+	}},
+
+	// bash to posix errors
+	{LangBash, LangPOSIX, "a=(b c); echo ${a[0]}", "a=(b c)\necho ${a[0]}", []string{
+		"1:1: cannot convert arrays to posix",
+		"1:15: cannot convert arrays to posix",
+	}},
+	{LangBash, LangPOSIX, "local a; declare -x b; export c", "local a\ndeclare -x b\nexport c", []string{
+		"1:1: cannot convert local to posix",
+		"1:10: cannot convert declare to posix",
+	}},
+	{LangBash, LangPOSIX, "cat <(foo) <<<bar", "cat <(foo) <<<bar", []string{
+		"1:5: cannot convert process substitutions to posix",
+		"1:12: cannot convert herestrings to posix",
+	}},
+	{LangBash, LangPOSIX, "echo {a,b} '{a,b}'", "echo {a,b} '{a,b}'", []string{
+		"1:6: cannot convert brace expansions to posix",
+	}},
+	{LangBash, LangPOSIX, "echo ${a/b/c} ${a^} ${a@Q} $((a++))", "echo ${a/b/c} ${a^} ${a@Q} $((a++))", []string{
+		"1:6: cannot convert search and replace to posix",
+		"1:15: cannot convert case modifications to posix",
+		"1:21: cannot convert the @Q expansion operator to posix",
+		"1:32: cannot convert increments and decrements to posix",
+	}},
+	{LangBash, LangPOSIX, "for ((i = 0; i < 3; i++)); do :; done", "for ((i = 0; i < 3; i++)); do :; done", []string{
+		"1:1: cannot convert c-style fors to posix",
+		"1:22: cannot convert increments and decrements to posix",
+	}},
+	{LangBash, LangPOSIX, "case a in a) foo ;& b) bar ;;& esac", "case a in a) foo ;& b) bar ;;& esac", []string{
+		"1:18: cannot convert case fallthroughs to posix",
+		"1:28: cannot convert case fallthroughs to posix",
+	}},
+
+	// between bash and mksh
+	{LangMirBSDKorn, LangBash, "case a in a) foo ;| esac", "case a in a) foo ;;& esac", nil},
+	{LangBash, LangMirBSDKorn, "case a in a) foo ;;& esac", "case a in a) foo ;| esac", nil},
+	{LangBash, LangMirBSDKorn, "function foo { bar |& baz; }", "foo() { bar 2>&1 | baz; }", nil},
+	{LangMirBSDKorn, LangMirBSDKorn, "function foo { :; }", "function foo { :; }", nil},
+	{LangMirBSDKorn, LangBash, "foo |& bar; echo ${|foo;} ${%a}", "foo |&\nbar\necho ${|foo;} ${%a}", []string{
+		"1:5: cannot convert coprocesses to bash",
+		"1:18: cannot convert mksh command substitutions to bash",
+		"1:27: cannot convert ${%name} expansions to bash",
+	}},
+	{LangBash, LangMirBSDKorn, "[[ $a =~ x ]]; echo ${a,,} ${!a} ${a@U}", "[[ $a =~ x ]]\necho ${a,,} ${!a} ${a@U}", []string{
+		"1:7: cannot convert regex tests to mksh",
+		"1:21: cannot convert case modifications to mksh",
+		"1:28: cannot convert indirect expansions to mksh",
+		"1:34: cannot convert the @U expansion operator to mksh",
+	}},
+
+	// posix to bash needs no changes
+	{LangPOSIX, LangBash, "foo() { [ -n \"$a\" ] && echo {a,b}; }", "foo() { [ -n \"$a\" ] && echo {a,b}; }", nil},
+
+	// shebangs
+	{LangBash, LangPOSIX, "#!/bin/bash\nfoo", "#!/bin/sh\nfoo", nil},
+	{LangBash, LangPOSIX, "#!/usr/bin/env bash\n# bash\n", "#!/usr/bin/env sh\n# bash", nil},
+	{LangPOSIX, LangBash, "#!/bin/sh -e\nfoo", "#!/bin/bash -e\nfoo", nil},
+	{LangPOSIX, LangBash, "#!/bin/dash\nfoo", "#!/bin/dash\nfoo", nil},
+	{LangBash, LangPOSIX, "foo\n#!/bin/bash", "foo\n#!/bin/bash", nil},
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+	printer := NewPrinter()
+	for _, tc := range convertTests {
+		t.Run("", func(t *testing.T) {
+			parser := NewParser(KeepComments(true), Variant(tc.from))
+			prog, err := parser.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs := Convert(prog, tc.from, tc.to)
+			var buf bytes.Buffer
+			printer.Print(&buf, prog)
+			want := tc.want + "\n"
+			if got := buf.String(); got != want {
+				t.Fatalf("Convert mismatch of %q\nwant: %q\ngot:  %q",
+					tc.in, want, got)
+			}
+			var gotErrs []string
+			for _, err := range errs {
+				gotErrs = append(gotErrs, err.Error())
+			}
+			if strings.Join(gotErrs, "\n") != strings.Join(tc.errs, "\n") {
+				t.Fatalf("Convert errors of %q\nwant: %q\ngot:  %q",
+					tc.in, tc.errs, gotErrs)
+			}
+			if len(errs) > 0 {
+				return
+			}
+			// The result must be valid in the target language.
+			parser = NewParser(Variant(tc.to))
+			if _, err := parser.Parse(strings.NewReader(want), ""); err != nil {
+				t.Fatalf("result of %q is not valid %s: %v", tc.in, tc.to, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Severity is how serious a [Diagnostic] is.
+type Severity uint8
+
+const (
+	SeverityError   Severity = iota + 1 // the input cannot be used, like a parse error
+	SeverityWarning                     // the input is likely a mistake, like a lint finding
+	SeverityInfo                        // the input may be improved
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return fmt.Sprintf("Severity(%d)", uint8(s))
+}
+
+// Diagnostic is an issue found in a shell program, such as a parse error,
+// a lint finding, or a warning from the interpreter. It is the common shape
+// which the errors and findings in this module can be turned into, so that
+// tools can report them all in the same way.
+type Diagnostic struct {
+	Filename string
+
+	// Pos is where the issue starts. End is where it ends, and it may be
+	// invalid if the issue is at a single position.
+	Pos, End Pos
+
+	Severity Severity
+
+	// Code identifies the kind of issue, such as "parse" for parse errors,
+	// or the name of the lint rule which found it.
+	Code string
+
+	Message string
+
+	// Fixes holds any suggested ways to fix the issue.
+	Fixes []SuggestedFix
+}
+
+// SuggestedFix is a way to fix a [Diagnostic] by editing the source.
+type SuggestedFix struct {
+	Message string
+	Edits   []TextEdit
+}
+
+// TextEdit replaces the source from Pos to End with NewText.
+// If Pos and End are equal, NewText is inserted at Pos.
+type TextEdit struct {
+	Pos, End Pos
+	NewText  string
+}
+
+// Error formats the diagnostic like [ParseError], such as:
+//
+//	foo.sh:3:7: reached EOF without closing quote "
+func (d Diagnostic) Error() string {
+	if d.Filename == "" {
+		return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+	}
+	return fmt.Sprintf("%s:%s: %s", d.Filename, d.Pos, d.Message)
+}
+
+// AsDiagnostic finds the first error in err's tree which is a [Diagnostic],
+// or which can be turned into one via a method like [ParseError.Diagnostic],
+// such as those returned by [Parser.Parse] or [Vet].
+func AsDiagnostic(err error) (Diagnostic, bool) {
+	var d Diagnostic
+	if errors.As(err, &d) {
+		return d, true
+	}
+	var de interface{ Diagnostic() Diagnostic }
+	if errors.As(err, &de) {
+		return de.Diagnostic(), true
+	}
+	return Diagnostic{}, false
+}
+
+// Diagnostic returns the error as a [Diagnostic] with the code "parse".
+func (e ParseError) Diagnostic() Diagnostic {
+	return Diagnostic{
+		Filename: e.Filename,
+		Pos:      e.Pos,
+		Severity: SeverityError,
+		Code:     "parse",
+		Message:  e.Text,
+	}
+}
+
+// Diagnostic returns the error as a [Diagnostic] with the code "lang".
+func (e LangError) Diagnostic() Diagnostic {
+	return Diagnostic{
+		Filename: e.Filename,
+		Pos:      e.Pos,
+		Severity: SeverityError,
+		Code:     "lang",
+		Message:  e.message(),
+	}
+}
+
+// Diagnostic returns the error as a [Diagnostic] with the code "vet",
+// spanning the node which breaks the invariant.
+func (e VetError) Diagnostic() Diagnostic {
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     "vet",
+		Message:  fmt.Sprintf("%T: %s", e.Node, e.Text),
+	}
+	if e.Node != nil {
+		d.Pos, d.End = vetRange(e.Node)
+	}
+	return d
+}
+
+// vetRange returns the range of a node which failed [Vet], where either
+// position may be invalid as the node may be missing fields it needs.
+func vetRange(node Node) (pos, end Pos) {
+	defer func() { recover() }()
+	pos = node.Pos()
+	end = node.End()
+	return pos, end
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestAsDiagnostic(t *testing.T) {
+	t.Parallel()
+	parse := func(lang LangVariant, src string) error {
+		_, err := NewParser(Variant(lang)).Parse(strings.NewReader(src), "foo.sh")
+		return err
+	}
+	badWord := &Word{Parts: []WordPart{nil}}
+	tests := []struct {
+		err  error
+		want string // the code, severity, and message
+		str  string // the Error method of the diagnostic
+	}{
+		{
+			parse(LangBash, "echo 'foo"),
+			"parse error: reached EOF without closing quote '",
+			"foo.sh:1:6: reached EOF without closing quote '",
+		},
+		{
+			parse(LangPOSIX, "echo ${foo[1]}"),
+			"lang error: arrays are a bash/mksh feature",
+			"foo.sh:1:11: arrays are a bash/mksh feature",
+		},
+		{
+			fmt.Errorf("wrapped: %w", Vet(badWord)),
+			"vet error: *syntax.Word: nil word part",
+			"?:?: *syntax.Word: nil word part",
+		},
+		{
+			Diagnostic{Pos: NewPos(3, 1, 4), Severity: SeverityWarning, Code: "custom", Message: "careful"},
+			"custom warning: careful",
+			"1:4: careful",
+		},
+	}
+	for _, test := range tests {
+		d, ok := AsDiagnostic(test.err)
+		qt.Assert(t, qt.IsTrue(ok), qt.Commentf("%v", test.err))
+		qt.Check(t, qt.Equals(fmt.Sprintf("%s %s: %s", d.Code, d.Severity, d.Message), test.want))
+		qt.Check(t, qt.Equals(d.Error(), test.str))
+	}
+
+	_, ok := AsDiagnostic(errors.New("other"))
+	qt.Assert(t, qt.IsFalse(ok))
+	_, ok = AsDiagnostic(nil)
+	qt.Assert(t, qt.IsFalse(ok))
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+// FixRule is a set of mechanical rewrites which [Fix] may apply.
+// Rules can be combined with the bitwise OR operator.
+type FixRule uint
+
+const (
+	// FixQuoteExpansions quotes parameter expansions in command arguments,
+	// such that they do not undergo field splitting and globbing.
+	//
+	//	echo $foo ${bar}    →  echo "$foo" "${bar}"
+	//
+	// Special parameters which expand to a number, like "$#", are left as
+	// they are, as well as expansions like "$*" where the quoted form would
+	// join all the fields into one, and expansions whose words contain quotes,
+	// which would mean something else within double quotes.
+	FixQuoteExpansions FixRule = 1 << iota
+
+	// FixBackquotes replaces deprecated backquotes in command substitutions.
+	//
+	//	foo=`date`          →  foo=$(date)
+	FixBackquotes
+
+	// FixTestAndOr splits test commands using the ambiguous "-a" and "-o"
+	// operators into multiple commands joined with "&&" and "||".
+	//
+	//	[ -n $a -a -z $b ]  →  [ -n $a ] && [ -z $b ]
+	//
+	// Only simple expressions are split, as the operators have different
+	// precedences, and "-a" is also a unary operator.
+	FixTestAndOr
+
+	// FixAll applies all of the rules above.
+	FixAll = FixQuoteExpansions | FixBackquotes | FixTestAndOr
+)
+
+// Fix modifies a node to apply a set of mechanical rewrites which fix common
+// mistakes, and returns whether any changes were made. Unlike [Simplify],
+// the rewrites may change what a program does, such as by no longer splitting
+// the fields resulting from an expansion, which is usually what was meant.
+func Fix(n Node, rules FixRule) bool {
+	f := fixer{rules: rules}
+	Walk(n, f.visit)
+	return f.modified
+}
+
+type fixer struct {
+	rules    FixRule
+	modified bool
+}
+
+func (f *fixer) visit(node Node) bool {
+	switch node := node.(type) {
+	case *Stmt:
+		if f.rules&FixTestAndOr != 0 && f.splitTest(node) {
+			f.modified = true
+		}
+	case *CallExpr:
+		if f.rules&FixQuoteExpansions == 0 || len(node.Args) == 0 {
+			break
+		}
+		for _, arg := range node.Args[1:] {
+			for i, part := range arg.Parts {
+				pe, ok := part.(*ParamExp)
+				if !ok || !quotableParam(pe) {
+					continue
+				}
+				arg.Parts[i] = &DblQuoted{
+					Left:  pe.Pos(),
+					Right: posAddCol(pe.End(), 1),
+					Parts: []WordPart{pe},
+				}
+				f.modified = true
+			}
+		}
+	case *CmdSubst:
+		// Backquotes holding only a comment must stay, as the comment
+		// would otherwise swallow the closing parenthesis.
+		if f.rules&FixBackquotes != 0 && node.Backquotes && len(node.Stmts) > 0 {
+			node.Backquotes = false
+			f.modified = true
+		}
+	}
+	return true
+}
+
+// quotableParam reports whether an unquoted parameter expansion undergoes
+// field splitting, and whether quoting it keeps its meaning otherwise.
+func quotableParam(pe *ParamExp) bool {
+	if pe.Length || pe.Width || pe.Excl || pe.Param == nil {
+		return false
+	}
+	switch pe.Param.Value {
+	case "#", "?", "$", "!", "-", "*":
+		return false
+	}
+	if w, ok := pe.Index.(*Word); ok && w.Lit() == "*" {
+		return false // ${a[*]}
+	}
+	if pe.Exp != nil && hasQuotes(pe.Exp.Word) {
+		return false
+	}
+	if pe.Repl != nil && (hasQuotes(pe.Repl.Orig) || hasQuotes(pe.Repl.With)) {
+		return false
+	}
+	return true
+}
+
+func hasQuotes(w *Word) bool {
+	if w == nil {
+		return false
+	}
+	for _, part := range w.Parts {
+		switch part.(type) {
+		case *SglQuoted, *DblQuoted:
+			return true
+		}
+	}
+	return false
+}
+
+// binaryTestOps are the binary operators supported by test commands,
+// excluding "-a" and "-o".
+var binaryTestOps = map[string]bool{
+	"=": true, "==": true, "!=": true, "<": true, ">": true,
+	"-eq": true, "-ne": true, "-lt": true, "-le": true, "-gt": true, "-ge": true,
+	"-nt": true, "-ot": true, "-ef": true,
+}
+
+// splitTest splits a statement like "[ a -a b ]" into "[ a ] && [ b ]".
+func (f *fixer) splitTest(stmt *Stmt) bool {
+	call, ok := stmt.Cmd.(*CallExpr)
+	if !ok || stmt.Negated || stmt.Background || stmt.Coprocess ||
+		len(stmt.Redirs) > 0 || len(call.Assigns) > 0 || len(call.Args) < 2 {
+		return false
+	}
+	name := call.Args[0].Lit()
+	args := call.Args[1:]
+	switch name {
+	case "[":
+		if args[len(args)-1].Lit() != "]" {
+			return false
+		}
+		args = args[:len(args)-1]
+	case "test":
+	default:
+		return false
+	}
+
+	var exprs [][]*Word
+	var ops []*Word
+	start := 0
+	for i, arg := range args {
+		if lit := arg.Lit(); lit == "-a" || lit == "-o" {
+			exprs = append(exprs, args[start:i])
+			ops = append(ops, arg)
+			start = i + 1
+		}
+	}
+	exprs = append(exprs, args[start:])
+	if len(ops) == 0 {
+		return false
+	}
+	for i, op := range ops {
+		// "a -o b -a c" means "a -o (b -a c)", whereas "||" and "&&"
+		// have the same precedence in the shell.
+		if op.Lit() == "-a" && i > 0 && ops[i-1].Lit() == "-o" {
+			return false
+		}
+	}
+	for _, expr := range exprs {
+		if !simpleTestExpr(expr) {
+			return false
+		}
+	}
+
+	newStmt := func(expr []*Word) *Stmt {
+		args := append([]*Word{call.Args[0]}, expr...)
+		if name == "[" {
+			end := expr[len(expr)-1].End()
+			args = append(args, &Word{Parts: []WordPart{&Lit{
+				ValuePos: posAddCol(end, 1),
+				ValueEnd: posAddCol(end, 2),
+				Value:    "]",
+			}}})
+		}
+		return &Stmt{Position: args[0].Pos(), Cmd: &CallExpr{Args: args}}
+	}
+	x := newStmt(exprs[0])
+	for i, op := range ops {
+		binOp := AndStmt
+		if op.Lit() == "-o" {
+			binOp = OrStmt
+		}
+		y := newStmt(exprs[i+1])
+		bin := &BinaryCmd{OpPos: op.Pos(), Op: binOp, X: x, Y: y}
+		x = &Stmt{Position: stmt.Position, Cmd: bin}
+	}
+	stmt.Cmd = x.Cmd
+	return true
+}
+
+// simpleTestExpr reports whether a test expression is made up of one to three
+// arguments which can only be parsed in one way, like "-n foo" or "a = b".
+func simpleTestExpr(expr []*Word) bool {
+	for _, w := range expr {
+		switch w.Lit() {
+		case "(", ")", "!":
+			return false
+		}
+	}
+	switch len(expr) {
+	case 1:
+		return !binaryTestOps[expr[0].Lit()]
+	case 2:
+		lit := expr[0].Lit()
+		return len(lit) == 2 && lit[0] == '-'
+	case 3:
+		return binaryTestOps[expr[1].Lit()]
+	}
+	return false
+}
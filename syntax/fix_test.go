@@ -0,0 +1,87 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var fixTests = [...]struct {
+	rules    FixRule
+	in, want string
+}{
+	// quoting expansions
+	{FixQuoteExpansions, "echo $foo ${bar} $1 $@", `echo "$foo" "${bar}" "$1" "$@"`},
+	{FixQuoteExpansions, "echo a$foo/b ${x:-y}", `echo a"$foo"/b "${x:-y}"`},
+	{FixQuoteExpansions, "[ -n $foo ]", `[ -n "$foo" ]`},
+	{FixQuoteExpansions, "echo $# $? ${#a} $* ${a[*]} ${!a}", "echo $# $? ${#a} $* ${a[*]} ${!a}"},
+	{FixQuoteExpansions, `echo ${x:-'y'} ${x/a/"b"}`, `echo ${x:-'y'} ${x/a/"b"}`},
+	{FixQuoteExpansions, `$cmd "$foo" $((a + b))`, `$cmd "$foo" $((a + b))`},
+	{FixQuoteExpansions, "foo=$bar\nfor i in $list; do :; done", "foo=$bar\nfor i in $list; do :; done"},
+	{FixQuoteExpansions, "echo $(ls $dir)", `echo $(ls "$dir")`},
+
+	// backquotes
+	{FixBackquotes, "foo=`date`", "foo=$(date)"},
+	{FixBackquotes, "echo `echo \\`x\\``", "echo $(echo $(x))"},
+	{FixBackquotes, "foo `# comment`", "foo `# comment`"},
+
+	// splitting test commands
+	{FixTestAndOr, "[ -n a -a -z b ]", "[ -n a ] && [ -z b ]"},
+	{FixTestAndOr, "test a = b -o c", "test a = b || test c"},
+	{FixTestAndOr, "[ a -a b -o c != d ]", "[ a ] && [ b ] || [ c != d ]"},
+	{FixTestAndOr, "if [ a -a b ]; then :; fi", "if [ a ] && [ b ]; then :; fi"},
+	{FixTestAndOr, "[ a -o b -a c ]", "[ a -o b -a c ]"},
+	{FixTestAndOr, "[ -a file ]", "[ -a file ]"},
+	{FixTestAndOr, `[ "$x" = -a ]`, `[ "$x" = -a ]`},
+	{FixTestAndOr, `[ \( a -a b \) -o c ]`, `[ \( a -a b \) -o c ]`},
+	{FixTestAndOr, "! [ a -a b ]", "! [ a -a b ]"},
+	{FixTestAndOr, "[ a -a b ] >f", "[ a -a b ] >f"},
+	{FixTestAndOr, "[ a b c d -a e ]", "[ a b c d -a e ]"},
+
+	// all rules
+	{FixAll, "[ $a = `x` -a -n $b ]", `[ "$a" = $(x) ] && [ -n "$b" ]`},
+	{FixQuoteExpansions | FixBackquotes, "[ $a -a `x` ]", `[ "$a" -a $(x) ]`},
+}
+
+func TestFix(t *testing.T) {
+	t.Parallel()
+	parser := NewParser(KeepComments(true))
+	printer := NewPrinter()
+	for _, tc := range fixTests {
+		t.Run("", func(t *testing.T) {
+			prog, err := parser.Parse(strings.NewReader(tc.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fixed := Fix(prog, tc.rules)
+			var buf bytes.Buffer
+			printer.Print(&buf, prog)
+			want := tc.want + "\n"
+			if got := buf.String(); got != want {
+				t.Fatalf("Fix mismatch of %q\nwant: %q\ngot:  %q",
+					tc.in, want, got)
+			}
+			// Printing turns backquotes into $( ) on its own,
+			// so only check the result for other rules.
+			if tc.rules == FixBackquotes {
+				return
+			}
+			if fixed && tc.in == tc.want {
+				t.Fatalf("returned true but did not fix")
+			} else if !fixed && tc.in != tc.want {
+				t.Fatalf("returned false but did fix")
+			}
+			// The result must be stable.
+			prog, err = parser.Parse(strings.NewReader(want), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Fix(prog, tc.rules) {
+				t.Fatalf("fixing %q again made more changes", want)
+			}
+		})
+	}
+}
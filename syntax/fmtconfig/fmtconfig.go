@@ -0,0 +1,176 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package fmtconfig loads the formatting options for shell scripts from
+// shfmt's configuration files, so that tools other than shfmt can format
+// a project's scripts the same way.
+//
+// A configuration file is named ".shfmt" and uses the EditorConfig syntax,
+// with properties named after shfmt's long flags:
+//
+//	root = true
+//
+//	[*]
+//	indent = 2
+//	case-indent = true
+//	binary-next-line = true
+//
+//	[[bash]]
+//	language-dialect = bash
+//
+//	[third_party/**]
+//	ignore = true
+//
+// Configuration files apply to the files in their directory and all of its
+// subdirectories. As with EditorConfig, when multiple files apply to a path,
+// the properties in the files closer to it take precedence, and files further
+// up are not read once one sets "root = true". Sections may also use
+// "[[shell]]" or "[[bash]]" to match any shell or bash scripts; see
+// [Languages].
+package fmtconfig
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"mvdan.cc/editorconfig"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// FileName is the name of the configuration files.
+const FileName = ".shfmt"
+
+// Config holds the formatting options for a shell script.
+type Config struct {
+	// Lang is set via "language-dialect". It is [syntax.LangAuto] when
+	// unset, meaning that the language should be detected from the script.
+	Lang syntax.LangVariant
+
+	Indent           uint // "indent"; 0 means tabs
+	BinaryNextLine   bool // "binary-next-line"
+	SwitchCaseIndent bool // "case-indent"
+	SpaceRedirects   bool // "space-redirects"
+	KeepPadding      bool // "keep-padding"
+	FunctionNextLine bool // "func-next-line"
+
+	Simplify bool // "simplify"; see [syntax.Simplify]
+	Minify   bool // "minify", which implies Simplify
+
+	// Ignore is set via "ignore", meaning that the script should not be
+	// formatted at all, such as when it is vendored from another project.
+	Ignore bool
+}
+
+// ParserOptions returns the parser options for the configuration. If Lang is
+// [syntax.LangAuto], no language variant is set.
+func (c *Config) ParserOptions() []syntax.ParserOption {
+	if c.Lang == syntax.LangAuto {
+		return nil
+	}
+	return []syntax.ParserOption{syntax.Variant(c.Lang)}
+}
+
+// PrinterOptions returns the printer options for the configuration.
+// All options are included, even those left as their default values,
+// so that they can be used to reset a printer which is reused.
+func (c *Config) PrinterOptions() []syntax.PrinterOption {
+	return []syntax.PrinterOption{
+		syntax.Indent(c.Indent),
+		syntax.BinaryNextLine(c.BinaryNextLine),
+		syntax.SwitchCaseIndent(c.SwitchCaseIndent),
+		syntax.SpaceRedirects(c.SpaceRedirects),
+		syntax.KeepPadding(c.KeepPadding),
+		syntax.FunctionNextLine(c.FunctionNextLine),
+		syntax.Minify(c.Minify),
+	}
+}
+
+// Languages returns the names of the language sections which match scripts
+// in a language variant. All shells match "[[shell]]", and bash as well as
+// bats also match "[[bash]]".
+func Languages(lang syntax.LangVariant) []string {
+	switch lang {
+	case syntax.LangBash, syntax.LangBats:
+		return []string{"shell", "bash"}
+	case syntax.LangPOSIX, syntax.LangMirBSDKorn, syntax.LangAuto:
+		return []string{"shell"}
+	}
+	return nil
+}
+
+// Loader finds and loads configuration files from disk. Its zero value is
+// ready to use; it caches the files it reads, so it should be reused when
+// loading the configuration for many scripts.
+type Loader struct {
+	query editorconfig.Query
+}
+
+// Load returns the configuration for the script at path, which does not need
+// to be absolute, given its language variant. A nil configuration is returned
+// if no configuration files set any properties for the script.
+//
+// An error is returned if a configuration file cannot be read or parsed,
+// or if a property has an invalid value.
+func (l *Loader) Load(path string, lang syntax.LangVariant) (*Config, error) {
+	if l.query.FileCache == nil {
+		l.query = editorconfig.Query{
+			ConfigName:  FileName,
+			FileCache:   make(map[string]*editorconfig.File),
+			RegexpCache: make(map[string]*regexp.Regexp),
+		}
+	}
+	props, err := l.query.Find(path, Languages(lang))
+	if err != nil {
+		return nil, err
+	}
+	if len(props.Properties) == 0 {
+		return nil, nil
+	}
+	cfg, err := fromProperties(props)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// fromProperties builds a configuration from properties found in
+// configuration files, ignoring any unknown properties.
+func fromProperties(props editorconfig.Section) (*Config, error) {
+	cfg := &Config{}
+	for _, prop := range props.Properties {
+		var err error
+		switch prop.Name {
+		case "language-dialect":
+			err = cfg.Lang.Set(prop.Value)
+		case "indent":
+			var n uint64
+			n, err = strconv.ParseUint(prop.Value, 10, 0)
+			cfg.Indent = uint(n)
+		case "binary-next-line":
+			cfg.BinaryNextLine, err = strconv.ParseBool(prop.Value)
+		case "case-indent":
+			cfg.SwitchCaseIndent, err = strconv.ParseBool(prop.Value)
+		case "space-redirects":
+			cfg.SpaceRedirects, err = strconv.ParseBool(prop.Value)
+		case "keep-padding":
+			cfg.KeepPadding, err = strconv.ParseBool(prop.Value)
+		case "func-next-line":
+			cfg.FunctionNextLine, err = strconv.ParseBool(prop.Value)
+		case "simplify":
+			cfg.Simplify, err = strconv.ParseBool(prop.Value)
+		case "minify":
+			cfg.Minify, err = strconv.ParseBool(prop.Value)
+		case "ignore":
+			cfg.Ignore, err = strconv.ParseBool(prop.Value)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s property %s=%q", FileName, prop.Name, prop.Value)
+		}
+	}
+	if cfg.Minify {
+		cfg.Simplify = true
+	}
+	return cfg, nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package fmtconfig_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"mvdan.cc/sh/v3/syntax"
+	"mvdan.cc/sh/v3/syntax/fmtconfig"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile := func(name, content string) {
+		path := filepath.Join(dir, name)
+		qt.Assert(t, qt.IsNil(os.MkdirAll(filepath.Dir(path), 0o777)))
+		qt.Assert(t, qt.IsNil(os.WriteFile(path, []byte(content), 0o666)))
+	}
+	writeFile(".shfmt", `
+root = true
+
+[*]
+indent = 2
+case-indent = true
+
+[[bash]]
+binary-next-line = true
+
+[third_party/**]
+ignore = true
+`)
+	writeFile("sub/.shfmt", `
+[*.sh]
+indent = 4
+language-dialect = posix
+minify = true
+`)
+	writeFile("bad/.shfmt", `
+[*]
+keep-padding = maybe
+`)
+
+	var loader fmtconfig.Loader
+	tests := []struct {
+		path string
+		lang syntax.LangVariant
+		want *fmtconfig.Config
+	}{
+		{"foo.sh", syntax.LangPOSIX, &fmtconfig.Config{
+			Indent:           2,
+			SwitchCaseIndent: true,
+		}},
+		{"foo.bash", syntax.LangBash, &fmtconfig.Config{
+			Indent:           2,
+			SwitchCaseIndent: true,
+			BinaryNextLine:   true,
+		}},
+		{"third_party/foo.sh", syntax.LangAuto, &fmtconfig.Config{
+			Indent:           2,
+			SwitchCaseIndent: true,
+			Ignore:           true,
+		}},
+		{"sub/foo.sh", syntax.LangBash, &fmtconfig.Config{
+			Lang:             syntax.LangPOSIX,
+			Indent:           4,
+			SwitchCaseIndent: true,
+			BinaryNextLine:   true,
+			Minify:           true,
+			Simplify:         true,
+		}},
+	}
+	for _, test := range tests {
+		got, err := loader.Load(filepath.Join(dir, test.path), test.lang)
+		qt.Assert(t, qt.IsNil(err))
+		qt.Check(t, qt.DeepEquals(got, test.want), qt.Commentf("%s", test.path))
+	}
+
+	_, err := loader.Load(filepath.Join(dir, "bad", "foo.sh"), syntax.LangAuto)
+	qt.Assert(t, qt.ErrorMatches(err, `.*foo\.sh: invalid \.shfmt property keep-padding="maybe"`))
+
+	// No configuration files apply outside of dir.
+	got, err := loader.Load(filepath.Join(filepath.Dir(dir), "foo.sh"), syntax.LangAuto)
+	qt.Assert(t, qt.IsNil(err))
+	qt.Assert(t, qt.IsNil(got))
+}
+
+func TestConfigOptions(t *testing.T) {
+	t.Parallel()
+
+	cfg := &fmtconfig.Config{Lang: syntax.LangPOSIX, Indent: 2, SwitchCaseIndent: true}
+	parser := syntax.NewParser(cfg.ParserOptions()...)
+	_, err := parser.Parse(strings.NewReader("foo=(bar)\n"), "")
+	qt.Assert(t, qt.ErrorMatches(err, `.*arrays are a bash.*`))
+
+	f, err := syntax.NewParser().Parse(strings.NewReader("case $x in\na) foo ;;\nesac\n"), "")
+	qt.Assert(t, qt.IsNil(err))
+	var buf bytes.Buffer
+	printer := syntax.NewPrinter(syntax.Indent(8), syntax.KeepPadding(true))
+	for _, opt := range cfg.PrinterOptions() {
+		opt(printer)
+	}
+	qt.Assert(t, qt.IsNil(printer.Print(&buf, f)))
+	qt.Assert(t, qt.Equals(buf.String(), "case $x in\n  a) foo ;;\nesac\n"))
+}
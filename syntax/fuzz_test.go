@@ -76,6 +76,11 @@ func FuzzQuote(f *testing.F) {
 			return true
 		})
 
+		// Unquote should give us the original string back.
+		unquoted, err := Unquote(quoted, lang)
+		qt.Assert(t, qt.IsNil(err), qt.Commentf("in: %q, quoted: %s", s, quoted))
+		qt.Assert(t, qt.Equals(unquoted, s), qt.Commentf("quoted: %s", quoted))
+
 		// The process below shouldn't run arbitrary code,
 		// since our parser checks above should catch the use of ';' or '$',
 		// in the case that Quote were too naive to quote them.
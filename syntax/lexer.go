@@ -134,6 +134,17 @@ retry:
 // had not yet been used at the end of the buffer are slid into the
 // beginning of the buffer.
 func (p *Parser) fill() {
+	if p.src == nil {
+		// Parsing bytes via ParseBytes; there is nothing else to read.
+		if p.bsp <= uint(len(p.bs)) {
+			p.offs += int64(p.bsp)
+			p.bs, p.bsp = p.bs[p.bsp:], 0
+		}
+		if len(p.bs) == 0 {
+			p.bs = nil
+		}
+		return
+	}
 	p.offs += int64(p.bsp)
 	left := len(p.bs) - int(p.bsp)
 	copy(p.readBuf[:left], p.readBuf[p.bsp:])
@@ -819,17 +830,42 @@ func (p *Parser) newLit(r rune) {
 
 func (p *Parser) endLit() (s string) {
 	if p.r == utf8.RuneSelf || p.r == escNewl {
-		s = string(p.litBs)
+		s = p.internLit(p.litBs)
 	} else if p.r == '`' && p.w > 1 {
 		// If we ended at a nested and escaped backquote, litBs does not include the backslash.
-		s = string(p.litBs[:len(p.litBs)-1])
+		s = p.internLit(p.litBs[:len(p.litBs)-1])
 	} else {
-		s = string(p.litBs[:len(p.litBs)-p.w])
+		s = p.internLit(p.litBs[:len(p.litBs)-p.w])
 	}
 	p.litBs = nil
 	return
 }
 
+const (
+	internMaxLen   = 16   // longer literals are rarely repeated
+	internMaxCount = 4096 // bounds the memory kept by a parser
+)
+
+// internLit returns bs as a string, reusing a previous string with the same
+// contents if bs is short, to avoid allocating for common words like "echo".
+func (p *Parser) internLit(bs []byte) string {
+	if len(bs) > internMaxLen {
+		return string(bs)
+	}
+	// The compiler does not allocate for a string conversion used as a key.
+	if s, ok := p.interned[string(bs)]; ok {
+		return s
+	}
+	s := string(bs)
+	if p.interned == nil {
+		p.interned = make(map[string]string)
+	}
+	if len(p.interned) < internMaxCount {
+		p.interned[s] = s
+	}
+	return s
+}
+
 func (p *Parser) isLitRedir() bool {
 	lit := p.litBs[:len(p.litBs)-1]
 	if lit[0] == '{' && lit[len(lit)-1] == '}' {
@@ -161,8 +161,25 @@ func NewParser(options ...ParserOption) *Parser {
 // Parser can be reused once it is done working.
 func (p *Parser) Parse(r io.Reader, name string) (*File, error) {
 	p.reset()
-	p.f = &File{Name: name}
 	p.src = r
+	return p.file(name)
+}
+
+// ParseBytes is like [Parser.Parse], but parses a shell program held in
+// memory. Unlike with Parse and a [bytes.Reader], src is scanned directly
+// rather than being copied into a buffer bit by bit.
+//
+// src is not modified, and it is not retained once ParseBytes returns.
+func (p *Parser) ParseBytes(src []byte, name string) (*File, error) {
+	p.reset()
+	p.bs, p.readErr = src, io.EOF
+	f, err := p.file(name)
+	p.bs = nil
+	return f, err
+}
+
+func (p *Parser) file(name string) (*File, error) {
+	p.f = &File{Name: name}
 	p.rune()
 	p.next()
 	p.f.Stmts, p.f.Last = p.stmtList()
@@ -336,11 +353,11 @@ func (p *Parser) Arithmetic(r io.Reader) (ArithmExpr, error) {
 // Parser holds the internal state of the parsing mechanism of a
 // program.
 type Parser struct {
-	src io.Reader
-	bs  []byte // current chunk of read bytes
-	bsp uint   // pos within chunk for the rune after r; uint helps eliminate bounds checks
-	r   rune   // next rune
-	w   int    // width of r
+	src io.Reader // nil if parsing bytes via ParseBytes
+	bs  []byte    // current chunk of read bytes
+	bsp uint      // pos within chunk for the rune after r; uint helps eliminate bounds checks
+	r   rune      // next rune
+	w   int       // width of r
 
 	f *File
 
@@ -393,10 +410,16 @@ type Parser struct {
 
 	litBatch  []Lit
 	wordBatch []wordAlloc
+	stmtBatch []Stmt
+	callBatch []callAlloc
 
 	readBuf [bufSize]byte
 	litBuf  [bufSize]byte
 	litBs   []byte
+
+	// interned holds short literal strings which were already seen,
+	// as the same words tend to appear many times in a program.
+	interned map[string]string
 }
 
 // Incomplete reports whether the parser is waiting to read more bytes because
@@ -415,6 +438,7 @@ func (p *Parser) Incomplete() bool {
 const bufSize = 1 << 10
 
 func (p *Parser) reset() {
+	p.src = nil
 	p.tok, p.val = illegalTok, ""
 	p.eqlOffs = 0
 	p.bs, p.bsp = nil, 0
@@ -431,6 +455,8 @@ func (p *Parser) reset() {
 	p.accComs, p.curComs = nil, &p.accComs
 	p.litBatch = nil
 	p.wordBatch = nil
+	p.stmtBatch = nil
+	p.callBatch = nil
 	p.litBs = nil
 }
 
@@ -468,6 +494,16 @@ type wordAlloc struct {
 	parts [1]WordPart
 }
 
+func (p *Parser) stmt(pos Pos) *Stmt {
+	if len(p.stmtBatch) == 0 {
+		p.stmtBatch = make([]Stmt, 32)
+	}
+	s := &p.stmtBatch[0]
+	p.stmtBatch = p.stmtBatch[1:]
+	s.Position = pos
+	return s
+}
+
 func (p *Parser) wordAnyNumber() *Word {
 	if len(p.wordBatch) == 0 {
 		p.wordBatch = make([]wordAlloc, 32)
@@ -491,11 +527,17 @@ func (p *Parser) wordOne(part WordPart) *Word {
 	return w
 }
 
+type callAlloc struct {
+	ce CallExpr
+	ws [4]*Word
+}
+
 func (p *Parser) call(w *Word) *CallExpr {
-	var alloc struct {
-		ce CallExpr
-		ws [4]*Word
+	if len(p.callBatch) == 0 {
+		p.callBatch = make([]callAlloc, 32)
 	}
+	alloc := &p.callBatch[0]
+	p.callBatch = p.callBatch[1:]
 	ce := &alloc.ce
 	ce.Args = alloc.ws[:1]
 	ce.Args[0] = w
@@ -594,6 +636,11 @@ func (p *Parser) doHeredocs() {
 	}
 	p.rune() // consume '\n', since we know p.tok == _Newl
 	old := p.quote
+	// Comments read before the bodies, such as an inline comment after
+	// "<<EOF;", belong to the statement which contains the heredoc,
+	// not to any statements within the bodies.
+	oldComs := p.accComs
+	p.accComs = nil
 	p.heredocs = p.heredocs[:p.buriedHdocs]
 	for i, r := range hdocs {
 		if p.err != nil {
@@ -634,6 +681,7 @@ func (p *Parser) doHeredocs() {
 		p.hdocStops = p.hdocStops[:len(p.hdocStops)-1]
 	}
 	p.quote = old
+	p.accComs = append(oldComs, p.accComs...)
 }
 
 func (p *Parser) got(tok token) bool {
@@ -807,11 +855,14 @@ type LangError struct {
 }
 
 func (e LangError) Error() string {
-	var buf bytes.Buffer
-	if e.Filename != "" {
-		buf.WriteString(e.Filename + ":")
+	if e.Filename == "" {
+		return fmt.Sprintf("%s: %s", e.Pos.String(), e.message())
 	}
-	buf.WriteString(e.Pos.String() + ": ")
+	return fmt.Sprintf("%s:%s: %s", e.Filename, e.Pos.String(), e.message())
+}
+
+func (e LangError) message() string {
+	var buf bytes.Buffer
 	buf.WriteString(e.Feature)
 	if strings.HasSuffix(e.Feature, "s") {
 		buf.WriteString(" are a ")
@@ -1637,7 +1688,7 @@ func (p *Parser) doRedirect(s *Stmt) {
 
 func (p *Parser) getStmt(readEnd, binCmd, fnBody bool) *Stmt {
 	pos, ok := p.gotRsrv("!")
-	s := &Stmt{Position: pos}
+	s := p.stmt(pos)
 	if ok {
 		s.Negated = true
 		if p.stopToken() {
@@ -1669,7 +1720,7 @@ func (p *Parser) getStmt(readEnd, binCmd, fnBody bool) *Stmt {
 			p.followErr(b.OpPos, b.Op.String(), "a statement")
 			return nil
 		}
-		s = &Stmt{Position: s.Position}
+		s = p.stmt(s.Position)
 		s.Cmd = b
 		s.Comments, b.X.Comments = b.X.Comments, nil
 	}
@@ -1838,11 +1889,11 @@ func (p *Parser) gotStmtPipe(s *Stmt, binCmd bool) *Stmt {
 		b := &BinaryCmd{OpPos: p.pos, Op: BinCmdOperator(p.tok), X: s}
 		p.next()
 		p.got(_Newl)
-		if b.Y = p.gotStmtPipe(&Stmt{Position: p.pos}, true); b.Y == nil || p.err != nil {
+		if b.Y = p.gotStmtPipe(p.stmt(p.pos), true); b.Y == nil || p.err != nil {
 			p.followErr(b.OpPos, b.Op.String(), "a statement")
 			break
 		}
-		s = &Stmt{Position: s.Position}
+		s = p.stmt(s.Position)
 		s.Cmd = b
 		s.Comments, b.X.Comments = b.X.Comments, nil
 		// in "! x | y", the bang applies to the entire pipeline
@@ -2302,7 +2353,7 @@ func (p *Parser) timeClause(s *Stmt) {
 	if _, ok := p.gotRsrv("-p"); ok {
 		tc.PosixFormat = true
 	}
-	tc.Stmt = p.gotStmtPipe(&Stmt{Position: p.pos}, false)
+	tc.Stmt = p.gotStmtPipe(p.stmt(p.pos), false)
 	s.Cmd = tc
 }
 
@@ -2310,19 +2361,19 @@ func (p *Parser) coprocClause(s *Stmt) {
 	cc := &CoprocClause{Coproc: p.pos}
 	if p.next(); isBashCompoundCommand(p.tok, p.val) {
 		// has no name
-		cc.Stmt = p.gotStmtPipe(&Stmt{Position: p.pos}, false)
+		cc.Stmt = p.gotStmtPipe(p.stmt(p.pos), false)
 		s.Cmd = cc
 		return
 	}
 	cc.Name = p.getWord()
-	cc.Stmt = p.gotStmtPipe(&Stmt{Position: p.pos}, false)
+	cc.Stmt = p.gotStmtPipe(p.stmt(p.pos), false)
 	if cc.Stmt == nil {
 		if cc.Name == nil {
 			p.posErr(cc.Coproc, "coproc clause requires a command")
 			return
 		}
 		// name was in fact the stmt
-		cc.Stmt = &Stmt{Position: cc.Name.Pos()}
+		cc.Stmt = p.stmt(cc.Name.Pos())
 		cc.Stmt.Cmd = p.call(cc.Name)
 		cc.Name = nil
 	} else if cc.Name != nil {
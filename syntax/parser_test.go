@@ -2070,6 +2070,30 @@ func TestInputName(t *testing.T) {
 	}
 }
 
+func TestParseBytes(t *testing.T) {
+	t.Parallel()
+	var inputs []string
+	for _, c := range append(fileTests, fileTestsNoPrint...) {
+		inputs = append(inputs, c.Strs...)
+	}
+	for _, c := range shellTests {
+		inputs = append(inputs, c.in)
+	}
+	// Parsers are reused, to check that state such as interned strings
+	// does not leak between inputs.
+	p1 := NewParser(KeepComments(true))
+	p2 := NewParser(KeepComments(true))
+	for _, in := range inputs {
+		want, wantErr := p1.Parse(strings.NewReader(in), "f.sh")
+		got, gotErr := p2.ParseBytes([]byte(in), "f.sh")
+		if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+			t.Fatalf("Error mismatch in %q\nwant: %v\ngot:  %v", in, wantErr, gotErr)
+		}
+		// Unlike other tests, positions must match too.
+		qt.Assert(t, qt.CmpEquals(got, want, cmp.AllowUnexported(Pos{})), qt.Commentf("input: %q", in))
+	}
+}
+
 var errBadReader = fmt.Errorf("write: expected error")
 
 type badReader struct{}
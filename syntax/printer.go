@@ -8,11 +8,12 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"reflect"
 	"strings"
 	"text/tabwriter"
 	"unicode"
 
-	"mvdan.cc/sh/v3/fileutil"
+	"mvdan.cc/sh/v3/internal/shebang"
 )
 
 // PrinterOption is a function which can be passed to NewPrinter
@@ -94,6 +95,38 @@ func FunctionNextLine(enabled bool) PrinterOption {
 	return func(p *Printer) { p.funcNextLine = enabled }
 }
 
+// Lossless makes the printer copy any statements which are unchanged since
+// being parsed from src exactly as they appear in src, byte-for-byte. This
+// includes their spacing, such as within "$((  1+2 ))", their comments, and
+// any trailing whitespace. Statements which were modified are formatted as
+// usual, and so are the lines they share with any other syntax. If no
+// statements were modified at all, src is printed as-is.
+//
+// The parser options must be the ones used to parse the program being
+// printed, as src is parsed again to tell which statements are unchanged.
+// Lossless only applies when printing a *File, and it has no effect with
+// Minify or SingleLine. A nil src disables the option.
+func Lossless(src []byte, opts ...ParserOption) PrinterOption {
+	return func(p *Printer) {
+		p.source = nil
+		if src == nil {
+			return
+		}
+		f, err := NewParser(opts...).ParseBytes(src, "")
+		if err != nil {
+			return // the printed program can't come from src
+		}
+		source := &printSource{src: src, file: f, stmts: make(map[[2]Pos]*Stmt)}
+		Walk(f, func(node Node) bool {
+			if s, ok := node.(*Stmt); ok {
+				source.stmts[[2]Pos{s.Pos(), s.End()}] = s
+			}
+			return true
+		})
+		p.source = source
+	}
+}
+
 // NewPrinter allocates a new Printer and applies any number of options.
 func NewPrinter(opts ...PrinterOption) *Printer {
 	p := &Printer{
@@ -118,6 +151,13 @@ func (p *Printer) Print(w io.Writer, node Node) error {
 	if p.minify && p.singleLine {
 		return fmt.Errorf("Minify and SingleLine together are not supported yet; please file an issue describing your use case: https://github.com/mvdan/sh/issues")
 	}
+	if f, ok := node.(*File); ok && p.source != nil && !p.minify && !p.singleLine {
+		if p.source.unchanged(f) {
+			_, err := w.Write(p.source.src)
+			return err
+		}
+		p.useSource = true
+	}
 
 	// TODO: consider adding a raw mode to skip the tab writer, much like in
 	// go/printer.
@@ -137,7 +177,9 @@ func (p *Printer) Print(w io.Writer, node Node) error {
 	switch node := node.(type) {
 	case *File:
 		p.stmtList(node.Stmts, node.Last)
-		p.newline(Pos{})
+		if !endsInEscape(node) {
+			p.newline(Pos{})
+		}
 	case *Stmt:
 		p.stmtList([]*Stmt{node}, nil)
 	case Command:
@@ -181,6 +223,25 @@ type colCounter struct {
 	*bufio.Writer
 	column    int
 	lineStart bool
+
+	// pendingPad is the number of padding spaces yet to be written.
+	// They are dropped if a newline follows, to not leave trailing whitespace.
+	pendingPad int
+}
+
+func (c *colCounter) pad() {
+	c.pendingPad++
+	c.column++
+}
+
+func (c *colCounter) flushPad(next byte) {
+	if next == '\n' {
+		c.pendingPad = 0
+		return
+	}
+	for ; c.pendingPad > 0; c.pendingPad-- {
+		c.Writer.WriteByte(' ')
+	}
 }
 
 func (c *colCounter) addByte(b byte) {
@@ -188,7 +249,10 @@ func (c *colCounter) addByte(b byte) {
 	case '\n':
 		c.column = 0
 		c.lineStart = true
-	case '\t', ' ', tabwriter.Escape:
+	case tabwriter.Escape:
+		// The tab writer strips escapes, so they don't take up a column.
+		return
+	case '\t', ' ':
 	default:
 		c.lineStart = false
 	}
@@ -196,20 +260,40 @@ func (c *colCounter) addByte(b byte) {
 }
 
 func (c *colCounter) WriteByte(b byte) error {
+	c.flushPad(b)
 	c.addByte(b)
 	return c.Writer.WriteByte(b)
 }
 
+func (c *colCounter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		c.flushPad(p[0])
+	}
+	for _, b := range p {
+		c.addByte(b)
+	}
+	return c.Writer.Write(p)
+}
+
 func (c *colCounter) WriteString(s string) (int, error) {
+	if len(s) > 0 {
+		c.flushPad(s[0])
+	}
 	for _, b := range []byte(s) {
 		c.addByte(b)
 	}
 	return c.Writer.WriteString(s)
 }
 
+func (c *colCounter) Flush() error {
+	c.pendingPad = 0
+	return c.Writer.Flush()
+}
+
 func (c *colCounter) Reset(w io.Writer) {
 	c.column = 1
 	c.lineStart = true
+	c.pendingPad = 0
 	c.Writer.Reset(w)
 }
 
@@ -262,6 +346,63 @@ type Printer struct {
 
 	// used when printing <<- heredocs with tab indentation
 	tabsPrinter *Printer
+
+	// source is set by Lossless, and useSource reports whether we are
+	// printing a file which may copy from it.
+	source    *printSource
+	useSource bool
+}
+
+// printSource holds a program's source and its syntax tree for Lossless.
+type printSource struct {
+	src  []byte
+	file *File
+
+	// stmts indexes all statements in file by their start and end.
+	stmts map[[2]Pos]*Stmt
+}
+
+// unchanged reports whether f is the same program that was parsed from src.
+func (s *printSource) unchanged(f *File) bool {
+	return reflect.DeepEqual(f.Stmts, s.file.Stmts) && reflect.DeepEqual(f.Last, s.file.Last)
+}
+
+// span returns the source range covered by a statement, including its
+// comments and heredoc bodies, if it's unchanged since it was parsed.
+func (s *printSource) span(st *Stmt) (start, end Pos, ok bool) {
+	orig := s.stmts[[2]Pos{st.Pos(), st.End()}]
+	if orig == nil || !reflect.DeepEqual(st, orig) {
+		return Pos{}, Pos{}, false
+	}
+	start, end, ok = st.Pos(), st.End(), true
+	Walk(st, func(node Node) bool {
+		switch node := node.(type) {
+		case nil:
+			return false
+		case *Redirect:
+			if (node.Op == Hdoc || node.Op == DashHdoc) && node.Hdoc == nil {
+				// The closing word of an empty heredoc isn't part of
+				// any node, so we can't tell where the statement ends.
+				ok = false
+			}
+		}
+		if pos := node.Pos(); pos.IsValid() && start.After(pos) {
+			start = pos
+		}
+		if nodeEnd := node.End(); nodeEnd.After(end) {
+			end = nodeEnd
+		}
+		return ok
+	})
+	if end.Offset() > uint(len(s.src)) {
+		ok = false
+	}
+	return start, end, ok
+}
+
+// onlySpace reports whether bs consists of whitespace only.
+func onlySpace(bs []byte) bool {
+	return len(bytes.TrimLeft(bs, " \t\r\n")) == 0
 }
 
 func (p *Printer) reset() {
@@ -277,6 +418,7 @@ func (p *Printer) reset() {
 	p.levelIncs = p.levelIncs[:0]
 	p.nestedBinary = false
 	p.pendingHdocs = p.pendingHdocs[:0]
+	p.useSource = false
 }
 
 func (p *Printer) spaces(n uint) {
@@ -301,7 +443,8 @@ func (p *Printer) spacePad(pos Pos) {
 		p.wantSpace = spaceWritten
 	}
 	for p.cols.column > 0 && p.cols.column < int(pos.Col()) {
-		p.WriteByte(' ')
+		p.cols.pad()
+		p.wantSpace = spaceWritten
 	}
 }
 
@@ -594,7 +737,7 @@ func (p *Printer) flushComments() {
 func (p *Printer) comments(comments ...Comment) {
 	if p.minify {
 		for _, c := range comments {
-			if fileutil.Shebang([]byte("#"+c.Text)) != "" && c.Hash.Col() == 1 && c.Hash.Line() == 1 {
+			if shebang.Shell([]byte("#"+c.Text)) != "" && c.Hash.Col() == 1 && c.Hash.Line() == 1 {
 				p.WriteString(strings.TrimRightFunc("#"+c.Text, unicode.IsSpace))
 				p.WriteString("\n")
 				p.line++
@@ -675,7 +818,21 @@ func (p *Printer) wordPart(wp, next WordPart) {
 			} else {
 				p.wantSpace = spaceNotRequired
 			}
-			p.nestedStmts(wp.Stmts, wp.Last, wp.Right)
+			closing := wp.Right
+			if wp.Backquotes && len(wp.Stmts) > 0 && endsInHeredoc(wp.Stmts[len(wp.Stmts)-1]) {
+				// A closing backquote may follow a heredoc's stop word,
+				// but a closing parenthesis has to go on the next line.
+				// Treat both the same, so that we print the same way
+				// when given our own output.
+				closing = NewPos(closing.Offset(), closing.Line()+1, 1)
+			}
+			hdocs := len(p.pendingHdocs)
+			p.nestedStmts(wp.Stmts, wp.Last, closing)
+			if len(p.pendingHdocs) > hdocs {
+				// The heredoc bodies must come before the closing
+				// parenthesis, even when printing in a single line.
+				p.mustNewline = true
+			}
 			p.rightParen(wp.Right)
 		}
 	case *ParamExp:
@@ -1354,7 +1511,15 @@ func (p *Printer) ifClause(ic *IfClause, elif bool) {
 
 func (p *Printer) stmtList(stmts []*Stmt, last []Comment) {
 	sep := p.wantNewline || (len(stmts) > 0 && stmts[0].Pos().Line() > p.line)
-	for i, s := range stmts {
+	for i := 0; i < len(stmts); i++ {
+		if n, withLast := p.sourceLines(stmts[i:], last); n > 0 {
+			i += n - 1
+			if withLast {
+				last = nil
+			}
+			continue
+		}
+		s := stmts[i]
 		if i > 0 && p.singleLine && p.wantNewline && !p.wroteSemi {
 			// In singleLine mode, ensure we use semicolons between
 			// statements.
@@ -1395,6 +1560,130 @@ func (p *Printer) stmtList(stmts []*Stmt, last []Comment) {
 	p.comments(last...)
 }
 
+// sourceLines copies the source lines of the longest run of unchanged
+// statements at the start of stmts when using Lossless. The lines must not
+// contain any other syntax. If the run covers all of stmts, the comments in
+// last are copied too when possible. It returns the number of statements
+// copied, and whether last was copied.
+func (p *Printer) sourceLines(stmts []*Stmt, last []Comment) (n int, withLast bool) {
+	if !p.useSource {
+		return 0, false
+	}
+	src := p.source.src
+	lineEnd := func(end Pos) uint {
+		if i := bytes.IndexByte(src[end.Offset():], '\n'); i >= 0 {
+			return end.Offset() + uint(i)
+		}
+		return uint(len(src))
+	}
+	endsLine := func(end Pos) bool {
+		return onlySpace(src[end.Offset():lineEnd(end)])
+	}
+	// The run can only stop where no statement overlaps the next, such as
+	// with "cat <<EOF; foo", and at the end of a line.
+	var start, end, runEnd Pos
+	all := true
+	for i, s := range stmts {
+		if i > 0 && !end.After(s.Pos()) && endsLine(end) {
+			n, runEnd = i, end
+		}
+		sStart, sEnd, ok := p.source.span(s)
+		if !ok {
+			all = false
+			break
+		}
+		if i == 0 {
+			start = sStart
+		} else if !end.After(sStart) && !onlySpace(src[end.Offset():sStart.Offset()]) {
+			all = false
+			break
+		}
+		if sEnd.After(end) {
+			end = sEnd
+		}
+	}
+	if all {
+		if len(last) > 0 {
+			withLast = true
+			for _, c := range last {
+				if end.After(c.Pos()) || !onlySpace(src[end.Offset():c.Pos().Offset()]) {
+					withLast = false
+					break
+				}
+				end = c.End()
+			}
+			withLast = withLast && endsLine(end)
+		}
+		if withLast || (len(last) == 0 && endsLine(end)) {
+			n, runEnd = len(stmts), end
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	from := uint(bytes.LastIndexByte(src[:start.Offset()], '\n') + 1)
+	if !onlySpace(src[from:start.Offset()]) {
+		return 0, false
+	}
+	end = runEnd
+	lines := src[from:lineEnd(end)]
+	if bytes.IndexByte(lines, tabwriter.Escape) >= 0 {
+		// The tab writer would strip these bytes.
+		return 0, false
+	}
+
+	if p.firstLine && len(p.pendingComments) == 0 {
+		p.firstLine = false
+	} else {
+		p.flushHeredocs()
+		p.flushComments()
+		p.WriteByte('\n')
+		if start.Line() > p.line+1 {
+			p.WriteByte('\n') // preserve single empty lines
+		}
+	}
+	p.WriteByte(tabwriter.Escape)
+	p.Write(lines)
+	p.WriteByte(tabwriter.Escape)
+	p.advanceLine(end.Line())
+	p.wantSpace = spaceRequired
+	p.wantNewline, p.mustNewline = true, true
+	p.wroteSemi = false
+	return n, withLast
+}
+
+// endsInHeredoc reports whether a statement ends with a heredoc body.
+func endsInHeredoc(st *Stmt) bool {
+	found := false
+	Walk(st, func(node Node) bool {
+		if rd, ok := node.(*Redirect); ok && rd.Hdoc != nil && rd.Hdoc.End() == st.End() {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// endsInEscape reports whether a file ends with a literal whose last
+// character is an escaping backslash, such as "foo\" without a trailing
+// newline. Adding a newline would turn it into a line continuation,
+// so the file's last line is left as-is.
+func endsInEscape(f *File) bool {
+	if len(f.Stmts) == 0 || len(f.Last) > 0 {
+		return false
+	}
+	last := f.Stmts[len(f.Stmts)-1]
+	found := false
+	Walk(last, func(node Node) bool {
+		if lit, ok := node.(*Lit); ok && lit.End() == last.End() {
+			trailing := len(lit.Value) - len(strings.TrimRight(lit.Value, "\\"))
+			found = trailing%2 == 1
+		}
+		return !found
+	})
+	return found
+}
+
 func (p *Printer) nestedStmts(stmts []*Stmt, last []Comment, closing Pos) {
 	p.incLevel()
 	switch {
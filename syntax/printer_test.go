@@ -537,6 +537,10 @@ var printTests = []printCase{
 	samePrint("f <<EOF\nEOF\n# comment"),
 	samePrint("f <<EOF\nEOF\n# comment\nbar"),
 	samePrint("f <<EOF # inline\n$(\n\t# inside\n)\nEOF\n# outside\nbar"),
+	{
+		"f <<EOF; # inline\n$(\n\tfoo\n)\nEOF",
+		"f <<EOF # inline\n$(\n\tfoo\n)\nEOF",
+	},
 	samePrint("while foo; do\n\tbar\ndone <<-EOF # inline\n\tbaz\nEOF"),
 	samePrint("{\n\tcat <<EOF\nEOF\n\t# comment\n}"),
 	{
@@ -1225,7 +1229,11 @@ func printTest(t *testing.T, parser *Parser, printer *Printer, in, want string)
 		t.Fatalf("parsing got an error: %s:\n%s", err, in)
 	}
 	origWant := want
-	want += "\n"
+	// A trailing escaping backslash is left as the last line,
+	// as adding a newline would turn it into a line continuation.
+	if trailing := len(want) - len(strings.TrimRight(want, `\`)); trailing%2 == 0 {
+		want += "\n"
+	}
 	got, err := strPrint(printer, prog)
 	if err != nil {
 		t.Fatal(err)
@@ -1236,8 +1244,7 @@ func printTest(t *testing.T, parser *Parser, printer *Printer, in, want string)
 
 	// With the original "want" output string,
 	// make sure that it's idempotent when formatted again.
-	// Note that we don't want the added newline,
-	// as that can change the meaning of trailing backslashes.
+	// Note that we don't want any added newline.
 	progAgain, err := parser.Parse(strings.NewReader(origWant), "")
 	if err != nil {
 		t.Fatalf("Result is not valid shell:\n%s", want)
@@ -1383,3 +1390,231 @@ func TestKeepPaddingRepeated(t *testing.T) {
 	KeepPadding(false)(printer)
 	printTest(t, parser, printer, "foo  bar", "foo bar")
 }
+
+// TestPrintRoundTrip checks that printing is idempotent across the entire
+// corpus of test programs and all printer options; that is, a program which
+// is already formatted is printed back byte-for-byte.
+type corpusFile struct {
+	lang LangVariant
+	src  string
+}
+
+// printCorpus returns all the programs from the parser and printer tests.
+func printCorpus() []corpusFile {
+	var corpus []corpusFile
+	for _, c := range append(append(fileTests, fileTestsNoPrint...), fileTestsKeepComments...) {
+		for _, lang := range [...]struct {
+			variant LangVariant
+			want    *File
+		}{
+			{LangBash, c.Bash},
+			{LangPOSIX, c.Posix},
+			{LangMirBSDKorn, c.MirBSDKorn},
+			{LangBats, c.Bats},
+		} {
+			if lang.want == nil {
+				continue
+			}
+			for _, src := range c.Strs {
+				corpus = append(corpus, corpusFile{lang.variant, src})
+			}
+		}
+	}
+	for _, tc := range printTests {
+		corpus = append(corpus, corpusFile{LangBash, tc.in})
+	}
+	return corpus
+}
+
+func TestPrintRoundTrip(t *testing.T) {
+	t.Parallel()
+	corpus := printCorpus()
+	optionSets := []struct {
+		name string
+		opts []PrinterOption
+	}{
+		{"default", nil},
+		{"indent", []PrinterOption{Indent(4)}},
+		{"binary-next-line", []PrinterOption{BinaryNextLine(true)}},
+		{"switch-case-indent", []PrinterOption{SwitchCaseIndent(true)}},
+		{"space-redirects", []PrinterOption{SpaceRedirects(true)}},
+		{"keep-padding", []PrinterOption{KeepPadding(true)}},
+		{"func-next-line", []PrinterOption{FunctionNextLine(true)}},
+		{"minify", []PrinterOption{Minify(true)}},
+		{"single-line", []PrinterOption{SingleLine(true)}},
+		{"all", []PrinterOption{
+			Indent(2), BinaryNextLine(true), SwitchCaseIndent(true),
+			SpaceRedirects(true), FunctionNextLine(true),
+		}},
+	}
+	for _, set := range optionSets {
+		printer := NewPrinter(set.opts...)
+		t.Run(set.name, func(t *testing.T) {
+			t.Parallel()
+			for _, file := range corpus {
+				parser := NewParser(KeepComments(true), Variant(file.lang))
+				prog, err := parser.Parse(strings.NewReader(file.src), "")
+				if err != nil {
+					t.Fatalf("parsing got an error: %s:\n%q", err, file.src)
+				}
+				formatted, err := strPrint(printer, prog)
+				if err != nil {
+					t.Fatal(err)
+				}
+				prog, err = parser.Parse(strings.NewReader(formatted), "")
+				if err != nil {
+					t.Fatalf("Result is not valid shell: %s:\n%q", err, formatted)
+				}
+				got, err := strPrint(printer, prog)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != formatted {
+					t.Fatalf("Re-print mismatch:\nin:\n%q\nwant:\n%q\ngot:\n%q", file.src, formatted, got)
+				}
+			}
+		})
+	}
+}
+
+func TestPrintLossless(t *testing.T) {
+	t.Parallel()
+	corpus := printCorpus()
+	optionSets := []struct {
+		name string
+		opts []PrinterOption
+	}{
+		{"default", nil},
+		{"keep-padding", []PrinterOption{KeepPadding(true)}},
+		{"all", []PrinterOption{
+			Indent(2), BinaryNextLine(true), SwitchCaseIndent(true),
+			SpaceRedirects(true), FunctionNextLine(true),
+		}},
+	}
+	for _, set := range optionSets {
+		opts := set.opts
+		t.Run(set.name, func(t *testing.T) {
+			t.Parallel()
+			for _, file := range corpus {
+				parserOpts := []ParserOption{KeepComments(true), Variant(file.lang)}
+				prog, err := NewParser(parserOpts...).Parse(strings.NewReader(file.src), "")
+				if err != nil {
+					t.Fatalf("parsing got an error: %s:\n%q", err, file.src)
+				}
+				printer := NewPrinter(append(opts, Lossless([]byte(file.src), parserOpts...))...)
+				got, err := strPrint(printer, prog)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != file.src {
+					t.Fatalf("Lossless print mismatch:\nin:\n%q\ngot:\n%q", file.src, got)
+				}
+			}
+		})
+	}
+	// Pretend that each statement in turn was changed, and check that the
+	// output is the same program as when formatting everything.
+	t.Run("changed", func(t *testing.T) {
+		t.Parallel()
+		for _, file := range corpus {
+			parserOpts := []ParserOption{KeepComments(true), Variant(file.lang)}
+			parser := NewParser(parserOpts...)
+			prog, err := parser.Parse(strings.NewReader(file.src), "")
+			if err != nil {
+				t.Fatalf("parsing got an error: %s:\n%q", err, file.src)
+			}
+			want, err := strPrint(NewPrinter(), prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			printer := NewPrinter(Lossless([]byte(file.src), parserOpts...))
+			for _, orig := range printer.source.stmts {
+				orig.Negated = !orig.Negated
+				got, err := strPrint(printer, prog)
+				orig.Negated = !orig.Negated
+				if err != nil {
+					t.Fatal(err)
+				}
+				reparsed, err := parser.Parse(strings.NewReader(got), "")
+				if err != nil {
+					t.Fatalf("Result is not valid shell: %s:\nin:\n%q\ngot:\n%q", err, file.src, got)
+				}
+				if got, _ := strPrint(NewPrinter(), reparsed); got != want {
+					t.Fatalf("Lossless print changed the program:\nin:\n%q\nwant:\n%q\ngot:\n%q", file.src, want, got)
+				}
+			}
+		}
+	})
+}
+
+func TestPrintLosslessChanged(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		in, old, new, want string
+	}{
+		{
+			"foo   $((  1+2 ))   # comment\nold  x\n",
+			"old", "new",
+			"foo   $((  1+2 ))   # comment\nnew x\n",
+		},
+		{
+			"old  x\nfoo \t\n\n\n# comment\nbar\n",
+			"old", "new",
+			"new x\nfoo \t\n\n\n# comment\nbar\n",
+		},
+		{
+			"foo  a; old\nbar  b\n",
+			"old", "new",
+			"foo a\nnew\nbar  b\n",
+		},
+		{
+			"f() {\n    a   b\n    old\n    # last\n}\n",
+			"old", "new",
+			"f() {\n    a   b\n\tnew\n\t# last\n}\n",
+		},
+		{
+			"f() {\n    old\n    a   b\n    # last\n}\n",
+			"old", "new",
+			"f() {\n\tnew\n    a   b\n    # last\n}\n",
+		},
+		{
+			"cat <<EOF;  echo   x\nbody  \nEOF\nold\n",
+			"old", "new",
+			"cat <<EOF;  echo   x\nbody  \nEOF\nnew\n",
+		},
+		{
+			"cat <<EOF;  old   x\nbody  \nEOF\nfoo  \n",
+			"old", "new",
+			"cat <<EOF\nbody  \nEOF\nnew x\nfoo  \n",
+		},
+		{
+			"old  <<EOF\n$(\n  foo   bar\n)\nEOF\n",
+			"old", "new",
+			"new <<EOF\n$(\n  foo   bar\n)\nEOF\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+			prog, err := NewParser(KeepComments(true)).Parse(strings.NewReader(test.in), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			Walk(prog, func(node Node) bool {
+				if lit, ok := node.(*Lit); ok && lit.Value == test.old {
+					lit.Value = test.new
+				}
+				return true
+			})
+			printer := NewPrinter(Lossless([]byte(test.in), KeepComments(true)))
+			got, err := strPrint(printer, prog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("Lossless print mismatch:\nin:\n%q\nwant:\n%q\ngot:\n%q", test.in, test.want, got)
+			}
+		})
+	}
+}
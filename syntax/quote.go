@@ -35,8 +35,14 @@ const (
 // Without quoting, one can run into syntax errors,
 // as well as the possibility of running unintended code.
 //
+// Non-printable characters are escaped like $'\n' in the variants supporting
+// it, and kept as-is within quotes with [LangPOSIX], which lacks escape
+// sequences. Note that earlier versions returned a *QuoteError for any
+// non-printable character with [LangPOSIX]; now only invalid UTF-8 does, as
+// quotes preserve all other characters, including newlines.
+//
 // An error is returned when a string cannot be quoted for a variant.
-// For instance, POSIX lacks escape sequences for non-printable characters,
+// For instance, POSIX cannot represent invalid UTF-8 without escape sequences,
 // and no language variant can represent a string containing null bytes.
 // In such cases, the returned error type will be *QuoteError.
 //
@@ -78,10 +84,17 @@ func Quote(s string, lang LangVariant) (string, error) {
 			return "", &QuoteError{ByteOffset: offs, Message: quoteErrNull}
 		}
 		if r == utf8.RuneError || !unicode.IsPrint(r) {
-			if lang == LangPOSIX {
+			switch {
+			case lang != LangPOSIX:
+				nonPrintable = true
+			case r == utf8.RuneError && size == 1:
+				// Invalid UTF-8 cannot be parsed back.
 				return "", &QuoteError{ByteOffset: offs, Message: quoteErrPOSIX}
+			default:
+				// POSIX lacks $'' escape sequences, but any other
+				// character is kept as-is within quotes.
+				shellChars = true
 			}
-			nonPrintable = true
 		}
 		rem = rem[size:]
 		offs += size
@@ -183,3 +196,229 @@ func isHex(r rune) bool {
 		(r >= 'a' && r <= 'f') ||
 		(r >= 'A' && r <= 'F')
 }
+
+// QuoteFields quotes each of the fields via [Quote] and joins them with spaces,
+// so that the result is interpreted as the original fields in the given
+// language variant. It is useful to build a command string from argv-like
+// slices, such as the ones from [os.Args] or [os/exec.Cmd.Args].
+//
+// The returned error wraps a *QuoteError and reports the offending field.
+func QuoteFields(fields []string, lang LangVariant) (string, error) {
+	var b strings.Builder
+	for i, field := range fields {
+		quoted, err := Quote(field, lang)
+		if err != nil {
+			return "", fmt.Errorf("cannot quote field %d: %w", i, err)
+		}
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(quoted)
+	}
+	return b.String(), nil
+}
+
+type UnquoteError struct {
+	ByteOffset int
+	Message    string
+}
+
+func (e UnquoteError) Error() string {
+	return fmt.Sprintf("cannot unquote word at byte %d: %s", e.ByteOffset, e.Message)
+}
+
+const (
+	unquoteErrFields = "input must be exactly one word"
+	unquoteErrExpand = "word is not a literal string"
+	unquoteErrNull   = "shell strings cannot contain null bytes"
+)
+
+// Unquote reverses [Quote], returning the string which a single literal word
+// expands to in the given language variant.
+//
+// Only words without any expansions are supported, such as those returned by
+// [Quote]. A word using parameter expansions, command substitutions,
+// arithmetic expansions, globbing, brace expansion, or tilde expansion cannot
+// be unquoted, as its value depends on the state of the shell running it.
+// In such cases, or when the input is not exactly one word,
+// the returned error type will be *UnquoteError unless the input failed to parse.
+func Unquote(s string, lang LangVariant) (string, error) {
+	fields, err := UnquoteFields(s, lang)
+	if err != nil {
+		return "", err
+	}
+	if len(fields) != 1 {
+		return "", &UnquoteError{Message: unquoteErrFields}
+	}
+	return fields[0], nil
+}
+
+// UnquoteFields reverses [QuoteFields], returning the strings which each of
+// the literal words in the input expands to.
+// See [Unquote] for the words which are supported.
+func UnquoteFields(s string, lang LangVariant) ([]string, error) {
+	var fields []string
+	var unqErr error
+	err := NewParser(Variant(lang)).Words(strings.NewReader(s), func(w *Word) bool {
+		field, err := unquoteWord(w)
+		if err != nil {
+			unqErr = err
+			return false
+		}
+		fields = append(fields, field)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if unqErr != nil {
+		return nil, unqErr
+	}
+	return fields, nil
+}
+
+func unquoteWord(w *Word) (string, error) {
+	var b strings.Builder
+	for i, wp := range w.Parts {
+		offs := int(wp.Pos().Offset())
+		switch wp := wp.(type) {
+		case *Lit:
+			val := wp.Value
+			if i == 0 && strings.HasPrefix(val, "~") {
+				return "", &UnquoteError{ByteOffset: offs, Message: unquoteErrExpand}
+			}
+			for j := 0; j < len(val); j++ {
+				switch c := val[j]; c {
+				case '\\':
+					j++
+					if j < len(val) && val[j] != '\n' {
+						b.WriteByte(val[j])
+					}
+				case '*', '?', '[', '{':
+					return "", &UnquoteError{ByteOffset: offs + j, Message: unquoteErrExpand}
+				default:
+					b.WriteByte(c)
+				}
+			}
+		case *SglQuoted:
+			if !wp.Dollar {
+				b.WriteString(wp.Value)
+				break
+			}
+			// Skip the leading "$'".
+			if err := unquoteDollar(&b, wp.Value, offs+2); err != nil {
+				return "", err
+			}
+		case *DblQuoted:
+			for _, dp := range wp.Parts {
+				lit, ok := dp.(*Lit)
+				if !ok {
+					return "", &UnquoteError{ByteOffset: int(dp.Pos().Offset()), Message: unquoteErrExpand}
+				}
+				val := lit.Value
+				for j := 0; j < len(val); j++ {
+					c := val[j]
+					if c == '\\' && j+1 < len(val) {
+						switch val[j+1] {
+						case '$', '`', '"', '\\':
+							j++
+							c = val[j]
+						case '\n':
+							j++
+							continue
+						}
+					}
+					b.WriteByte(c)
+				}
+			}
+		default:
+			return "", &UnquoteError{ByteOffset: offs, Message: unquoteErrExpand}
+		}
+	}
+	return b.String(), nil
+}
+
+// unquoteDollar writes the value of the body of a $'...' string,
+// decoding its escape sequences.
+func unquoteDollar(b *strings.Builder, s string, offs int) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		start := i
+		i++
+		switch c := s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'e', 'E':
+			b.WriteByte('\x1b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			b.WriteByte(c)
+		case 'x', 'u', 'U', '0', '1', '2', '3', '4', '5', '6', '7':
+			base, maxDigits := 16, 2
+			switch c {
+			case 'u':
+				maxDigits = 4
+			case 'U':
+				maxDigits = 8
+			default:
+				if c != 'x' {
+					// Octal digits are part of the number itself.
+					base, maxDigits = 8, 3
+					i--
+				}
+			}
+			n, digits := 0, 0
+			for digits < maxDigits && i+1 < len(s) {
+				d := hexValue(s[i+1])
+				if d < 0 || d >= base {
+					break
+				}
+				n = n*base + d
+				digits++
+				i++
+			}
+			switch {
+			case digits == 0:
+				// Not an escape sequence after all, such as "\xZ".
+				b.WriteString(s[start : i+1])
+			case n == 0:
+				return &UnquoteError{ByteOffset: offs + start, Message: unquoteErrNull}
+			case c == 'u' || c == 'U':
+				b.WriteRune(rune(n))
+			default:
+				b.WriteByte(byte(n))
+			}
+		default:
+			// Unknown escape sequences are kept as-is.
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+	return nil
+}
+
+func hexValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	}
+	return -1
+}
@@ -25,8 +25,10 @@ func TestQuote(t *testing.T) {
 		{"\t", LangBash, `$'\t'`},
 		{"\v", LangBash, `$'\v'`},
 		{"null\x00", LangBash, &QuoteError{4, quoteErrNull}},
-		{"posix\x1b", LangPOSIX, &QuoteError{5, quoteErrPOSIX}},
-		{"posix\n", LangPOSIX, &QuoteError{5, quoteErrPOSIX}},
+		{"posix\x1b", LangPOSIX, "'posix\x1b'"},
+		{"posix\n", LangPOSIX, "'posix\n'"},
+		{"posix's\t", LangPOSIX, "\"posix's\t\""},
+		{"posix\xff", LangPOSIX, &QuoteError{5, quoteErrPOSIX}},
 		{"mksh16\U00086199", LangMirBSDKorn, &QuoteError{6, quoteErrMksh}},
 		{"\x1b\x1caaa", LangBash, `$'\x1b\x1caaa'`},
 		{"\x1b\x1caaa", LangMirBSDKorn, `$'\x1b\x1c'$'aaa'`},
@@ -52,3 +54,106 @@ func TestQuote(t *testing.T) {
 		})
 	}
 }
+
+func TestQuoteFields(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		fields []string
+		lang   LangVariant
+		want   string
+	}{
+		{nil, LangBash, ``},
+		{[]string{""}, LangBash, `''`},
+		{[]string{"echo", "foo bar", "won't"}, LangBash, `echo 'foo bar' "won't"`},
+		{[]string{"printf", "%s\n", "$HOME"}, LangBash, `printf $'%s\n' '$HOME'`},
+		{[]string{"ls", "*.go", "~"}, LangPOSIX, `ls '*.go' '~'`},
+		{[]string{"printf", "%s\n"}, LangPOSIX, "printf '%s\n'"},
+		{[]string{"echo", "foo bar", "won't"}, LangPOSIX, `echo 'foo bar' "won't"`},
+		{[]string{"echo", "foo bar", "won't"}, LangMirBSDKorn, `echo 'foo bar' "won't"`},
+		{[]string{"printf", "%s\n", "$HOME"}, LangMirBSDKorn, `printf $'%s\n' '$HOME'`},
+		{[]string{"echo", "\x1bcafé"}, LangPOSIX, "echo '\x1bcafé'"},
+		{[]string{"echo", "\x1bcafé"}, LangBash, `echo $'\x1bcafé'`},
+		// mksh would read more than two hexadecimal digits after \x.
+		{[]string{"echo", "\x1bcafé"}, LangMirBSDKorn, `echo $'\x1b'$'café'`},
+		{[]string{"echo", "\u200b\U000E0001"}, LangBash, `echo $'\u200b\U000e0001'`},
+		{[]string{"echo", "\u200b"}, LangMirBSDKorn, `echo $'\u200b'`},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			got, err := QuoteFields(test.fields, test.lang)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.Equals(got, test.want))
+
+			fields, err := UnquoteFields(got, test.lang)
+			qt.Assert(t, qt.IsNil(err))
+			qt.Assert(t, qt.DeepEquals(fields, test.fields))
+		})
+	}
+
+	_, err := QuoteFields([]string{"echo", "posix\xff"}, LangPOSIX)
+	qt.Assert(t, qt.ErrorMatches(err, `cannot quote field 1: .*`))
+	var quoteErr *QuoteError
+	qt.Assert(t, qt.ErrorAs(err, &quoteErr))
+	qt.Assert(t, qt.Equals(quoteErr.ByteOffset, 5))
+
+	// mksh can only escape codepoints up to 16 bits.
+	_, err = QuoteFields([]string{"echo", "\u200b\U000E0001"}, LangMirBSDKorn)
+	qt.Assert(t, qt.ErrorAs(err, &quoteErr))
+	qt.Assert(t, qt.DeepEquals(quoteErr, &QuoteError{3, quoteErrMksh}))
+}
+
+func TestUnquote(t *testing.T) {
+	t.Parallel()
+	tests := [...]struct {
+		str  string
+		lang LangVariant
+		want any
+	}{
+		{`foo`, LangBash, `foo`},
+		{`''`, LangBash, ``},
+		{`""`, LangBash, ``},
+		{`'foo bar'`, LangBash, `foo bar`},
+		{`foo\ bar\\`, LangBash, `foo bar\`},
+		{`"\"won't\" \$x \a"`, LangBash, `"won't" $x \a`},
+		{`a'b'"c"`, LangBash, `abc`},
+		{`$'\a\b\e\f\n\r\t\v\\\'\"'`, LangBash, "\a\b\x1b\f\n\r\t\v\\'\""},
+		{`$'\x1b\x1caaa\xZ'`, LangBash, "\x1b\x1caaa\\xZ"},
+		{`$'\101\7é\U0001F600\q'`, LangBash, "A\aé\U0001F600\\q"},
+		{`$'\x00'`, LangBash, &UnquoteError{2, unquoteErrNull}},
+		{`foo bar`, LangBash, &UnquoteError{0, unquoteErrFields}},
+		{``, LangBash, &UnquoteError{0, unquoteErrFields}},
+		{`$foo`, LangBash, &UnquoteError{0, unquoteErrExpand}},
+		{`"a $b"`, LangBash, &UnquoteError{3, unquoteErrExpand}},
+		{`x$(y)`, LangBash, &UnquoteError{1, unquoteErrExpand}},
+		{`~/foo`, LangBash, &UnquoteError{0, unquoteErrExpand}},
+		{`glob-*`, LangBash, &UnquoteError{5, unquoteErrExpand}},
+		{`glob-\*`, LangBash, `glob-*`},
+		{`{a,b}`, LangBash, &UnquoteError{0, unquoteErrExpand}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run("", func(t *testing.T) {
+			t.Parallel()
+
+			got, gotErr := Unquote(test.str, test.lang)
+			switch want := test.want.(type) {
+			case string:
+				qt.Assert(t, qt.IsNil(gotErr))
+				qt.Assert(t, qt.Equals(got, want))
+			case *UnquoteError:
+				qt.Assert(t, qt.Equals(got, ""))
+				qt.Assert(t, qt.DeepEquals(gotErr, error(want)))
+			default:
+				t.Fatalf("unexpected type: %T", want)
+			}
+		})
+	}
+
+	_, err := Unquote(`foo;`, LangBash)
+	qt.Assert(t, qt.ErrorMatches(err, `1:4: ; is not a valid word`))
+}
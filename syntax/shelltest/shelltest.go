@@ -0,0 +1,286 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+// Package shelltest checks the syntax and interp packages against real
+// shells such as Bash, Dash, and mksh, by parsing or running the same inputs
+// with both and reporting where they disagree.
+//
+// These are the same kind of checks which this module runs on its own test
+// cases, exposed so that they can be run on other corpora of shell programs,
+// or fuzzed via [FuzzParse] and [FuzzRun].
+package shelltest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Shell is a real shell to compare against.
+type Shell struct {
+	// Path is the shell program to run, which is looked up in $PATH
+	// if it does not contain a path separator.
+	Path string
+
+	// Lang is the language variant which the shell implements,
+	// used to configure the parser.
+	Lang syntax.LangVariant
+}
+
+// The shells which this module is usually compared against.
+var (
+	Bash = Shell{Path: "bash", Lang: syntax.LangBash}
+	Dash = Shell{Path: "dash", Lang: syntax.LangPOSIX}
+	Mksh = Shell{Path: "mksh", Lang: syntax.LangMirBSDKorn}
+)
+
+// Available reports whether the shell program can be found.
+func (s Shell) Available() bool {
+	_, err := exec.LookPath(s.Path)
+	return err == nil
+}
+
+var extGlobRe = regexp.MustCompile(`[@?*+!]\(`)
+
+// flags returns the flags to run the shell with for src.
+func (s Shell) flags(src string) []string {
+	if s.Lang == syntax.LangBash && extGlobRe.MatchString(src) {
+		// Otherwise bash refuses to parse extended globs,
+		// which the parser always accepts.
+		return []string{"-O", "extglob"}
+	}
+	return nil
+}
+
+// Parse checks whether the shell accepts src without running it,
+// returning an error with what the shell printed if it does not.
+//
+// Since the shell only checks the syntax via its -n flag, errors which
+// shells only find when running a program, such as those in the commands
+// within backquotes, are not reported.
+func (s Shell) Parse(ctx context.Context, src string) error {
+	cmd := exec.CommandContext(ctx, s.Path, append(s.flags(src), "-n")...)
+	cmd.Stdin = strings.NewReader(src)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if stderr.Len() > 0 {
+		// Bash sometimes errors on an input via stderr while forgetting
+		// to set a non-zero exit status. Warnings are errors too.
+		return errors.New(strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+// Result is the outcome of running a program.
+type Result struct {
+	Stdout string
+	Stderr string
+	Exit   int
+}
+
+// Run runs src in the shell as a script read from standard input,
+// with dir as its working directory.
+// A non-zero exit status is not an error.
+func (s Shell) Run(ctx context.Context, dir, src string) (Result, error) {
+	cmd := exec.CommandContext(ctx, s.Path, s.flags(src)...)
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(src)
+	var stdout, stderr strings.Builder
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	res := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && ctx.Err() == nil {
+		res.Exit = exitErr.ExitCode()
+		err = nil
+	}
+	return res, err
+}
+
+// RunInterp runs src via [interp.Runner] like [Shell.Run] does with a shell,
+// parsing it as the given language variant.
+// Any options are applied after setting up the standard I/O and directory.
+func RunInterp(ctx context.Context, lang syntax.LangVariant, dir, src string, opts ...interp.RunnerOption) (Result, error) {
+	file, err := syntax.NewParser(syntax.Variant(lang)).Parse(strings.NewReader(src), "")
+	if err != nil {
+		return Result{}, err
+	}
+	var stdout, stderr strings.Builder
+	opts = append([]interp.RunnerOption{
+		interp.StdIO(nil, &stdout, &stderr),
+		interp.Dir(dir),
+	}, opts...)
+	r, err := interp.New(opts...)
+	if err != nil {
+		return Result{}, err
+	}
+	err = r.Run(ctx, file)
+	res := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	if status, ok := interp.IsExitStatus(err); ok {
+		res.Exit = int(status)
+		err = nil
+	}
+	return res, err
+}
+
+// Mismatch is the error returned when a shell and this module disagree.
+type Mismatch struct {
+	Shell string // the shell's program
+	Src   string // the input program
+	What  string // what was compared, such as "parse" or "stdout"
+
+	Want string // what the shell did
+	Got  string // what this module did
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("%s mismatch with %s on %q:\nwant: %s\ngot:  %s",
+		m.What, m.Shell, m.Src, m.Want, m.Got)
+}
+
+// CompareParse checks that the shell and [syntax.Parser] agree on whether
+// src is valid, returning a [*Mismatch] otherwise.
+func CompareParse(ctx context.Context, s Shell, src string) error {
+	shellErr := s.Parse(ctx, src)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	_, parseErr := syntax.NewParser(syntax.Variant(s.Lang)).Parse(strings.NewReader(src), "")
+	if (shellErr == nil) == (parseErr == nil) {
+		return nil
+	}
+	return &Mismatch{
+		Shell: s.Path, Src: src, What: "parse",
+		Want: describeErr(shellErr), Got: describeErr(parseErr),
+	}
+}
+
+func describeErr(err error) string {
+	if err == nil {
+		return "valid"
+	}
+	return "invalid: " + err.Error()
+}
+
+// CompareRun runs src with the shell and [interp.Runner], each in a new
+// temporary directory, and checks that they agree on the standard output
+// and the exit status, returning a [*Mismatch] otherwise.
+// Standard error is not compared, as each shell words its errors differently.
+//
+// If the shell and the parser disagree on whether src is valid, the
+// mismatch is returned as with [CompareParse]. Invalid programs are not run.
+//
+// Note that src is run as is; only use CompareRun with trusted programs,
+// or within a sandbox.
+func CompareRun(ctx context.Context, s Shell, src string, opts ...interp.RunnerOption) error {
+	if err := CompareParse(ctx, s, src); err != nil {
+		return err
+	}
+	if s.Parse(ctx, src) != nil {
+		return nil // neither accepts it
+	}
+	want, err := runInTemp(func(dir string) (Result, error) {
+		return s.Run(ctx, dir, src)
+	})
+	if err != nil {
+		return err
+	}
+	got, err := runInTemp(func(dir string) (Result, error) {
+		return RunInterp(ctx, s.Lang, dir, src, opts...)
+	})
+	if err != nil {
+		return err
+	}
+	switch {
+	case want.Stdout != got.Stdout:
+		return &Mismatch{
+			Shell: s.Path, Src: src, What: "stdout",
+			Want: fmt.Sprintf("%q", want.Stdout), Got: fmt.Sprintf("%q", got.Stdout),
+		}
+	case want.Exit != got.Exit:
+		return &Mismatch{
+			Shell: s.Path, Src: src, What: "exit status",
+			Want: fmt.Sprint(want.Exit), Got: fmt.Sprint(got.Exit),
+		}
+	}
+	return nil
+}
+
+func runInTemp(fn func(dir string) (Result, error)) (Result, error) {
+	dir, err := os.MkdirTemp("", "shelltest")
+	if err != nil {
+		return Result{}, err
+	}
+	defer os.RemoveAll(dir)
+	return fn(dir)
+}
+
+// fuzzTimeout limits how long each fuzzed input may take,
+// as inputs like "while :; do :; done" never finish.
+const fuzzTimeout = 2 * time.Second
+
+// FuzzParse fuzzes the parser against a shell via [CompareParse], using seeds
+// as the initial corpus. It skips the fuzz test if the shell is not available.
+//
+// For example, in a test file:
+//
+//	func FuzzBashParse(f *testing.F) {
+//		shelltest.FuzzParse(f, shelltest.Bash, []string{"echo foo", "if x; then y; fi"})
+//	}
+func FuzzParse(f *testing.F, s Shell, seeds []string) {
+	if !s.Available() {
+		f.Skipf("%s is required to fuzz", s.Path)
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		ctx, cancel := context.WithTimeout(context.Background(), fuzzTimeout)
+		defer cancel()
+		err := CompareParse(ctx, s, src)
+		if ctx.Err() != nil {
+			t.Skip("timed out")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// FuzzRun fuzzes the interpreter against a shell via [CompareRun], using
+// seeds as the initial corpus. It skips the fuzz test if the shell is not
+// available.
+//
+// Since fuzzing generates arbitrary programs which are then run by the shell,
+// FuzzRun should only be used within a sandbox such as a container.
+func FuzzRun(f *testing.F, s Shell, seeds []string, opts ...interp.RunnerOption) {
+	if !s.Available() {
+		f.Skipf("%s is required to fuzz", s.Path)
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		ctx, cancel := context.WithTimeout(context.Background(), fuzzTimeout)
+		defer cancel()
+		err := CompareRun(ctx, s, src, opts...)
+		if ctx.Err() != nil {
+			t.Skip("timed out")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package shelltest_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+
+	"mvdan.cc/sh/v3/syntax"
+	"mvdan.cc/sh/v3/syntax/shelltest"
+)
+
+// Shells which accept and reject everything, as they ignore their input.
+var (
+	acceptAll = shelltest.Shell{Path: "true", Lang: syntax.LangBash}
+	rejectAll = shelltest.Shell{Path: "false", Lang: syntax.LangBash}
+)
+
+func TestCompareParse(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires the true and false programs")
+	}
+	ctx := context.Background()
+	tests := []struct {
+		shell    shelltest.Shell
+		src      string
+		mismatch bool
+	}{
+		{acceptAll, "echo foo", false},
+		{acceptAll, "if", true},
+		{rejectAll, "echo foo", true},
+		{rejectAll, "if", false},
+	}
+	for _, test := range tests {
+		err := shelltest.CompareParse(ctx, test.shell, test.src)
+		var mismatch *shelltest.Mismatch
+		qt.Check(t, qt.Equals(errors.As(err, &mismatch), test.mismatch),
+			qt.Commentf("%s on %q: %v", test.shell.Path, test.src, err))
+	}
+}
+
+func TestCompareRun(t *testing.T) {
+	t.Parallel()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires the true program")
+	}
+	ctx := context.Background()
+	tests := []struct {
+		shell shelltest.Shell
+		src   string
+		what  string // empty if there is no mismatch
+	}{
+		{acceptAll, "true", ""},
+		{acceptAll, "echo >&2 errors are not compared", ""},
+		{acceptAll, "echo foo", "stdout"},
+		{acceptAll, "exit 3", "exit status"},
+		{acceptAll, "if", "parse"},
+		{rejectAll, "if", ""},
+	}
+	if shelltest.Bash.Available() {
+		tests = append(tests, []struct {
+			shell shelltest.Shell
+			src   string
+			what  string
+		}{
+			{shelltest.Bash, "echo foo; touch bar; echo *; exit 3", ""},
+			{shelltest.Bash, "x=1; echo $((x + 2)) ${y:-z}", ""},
+		}...)
+	}
+	for _, test := range tests {
+		err := shelltest.CompareRun(ctx, test.shell, test.src)
+		var mismatch *shelltest.Mismatch
+		if errors.As(err, &mismatch) {
+			qt.Check(t, qt.Equals(mismatch.What, test.what),
+				qt.Commentf("%s on %q: %v", test.shell.Path, test.src, err))
+		} else {
+			qt.Check(t, qt.IsNil(err))
+			qt.Check(t, qt.Equals(test.what, ""),
+				qt.Commentf("%s on %q: no mismatch", test.shell.Path, test.src))
+		}
+	}
+}
+
+func FuzzParseBash(f *testing.F) {
+	shelltest.FuzzParse(f, shelltest.Bash, []string{
+		"echo foo",
+		"if a; then b; fi",
+		"foo() { bar; }",
+		"echo $((1 + 2)) ${x:-y}",
+	})
+}
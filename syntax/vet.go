@@ -0,0 +1,557 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import "fmt"
+
+// VetError describes a syntax tree node which breaks one of the invariants
+// that a parsed tree always satisfies.
+type VetError struct {
+	Node Node
+	Text string
+}
+
+func (e VetError) Error() string {
+	return fmt.Sprintf("%T: %s", e.Node, e.Text)
+}
+
+// Vet checks that a syntax tree satisfies the invariants that the parser
+// guarantees and that the printer relies upon, returning a [VetError] for the
+// first node which does not.
+//
+// A tree returned by [Parser.Parse] always passes Vet. It is meant for trees
+// built or modified programmatically, so that mistakes such as missing
+// required fields, mismatched operator kinds, or positions which go backwards
+// are caught before printing, rather than causing a panic or invalid output.
+//
+// Zero positions are always accepted, as they are common when building nodes
+// by hand.
+func Vet(node Node) error {
+	v := vetter{}
+	v.node(node)
+	return v.err
+}
+
+type vetter struct {
+	err error
+}
+
+func (v *vetter) errf(node Node, format string, a ...any) {
+	if v.err == nil {
+		v.err = VetError{Node: node, Text: fmt.Sprintf(format, a...)}
+	}
+}
+
+func (v *vetter) stmts(parent Node, stmts []*Stmt) {
+	var prevEnd Pos
+	for _, s := range stmts {
+		if s == nil {
+			v.errf(parent, "nil statement")
+			return
+		}
+		v.node(s)
+		if v.err != nil {
+			return
+		}
+		if pos := s.Pos(); pos.IsValid() && prevEnd.IsValid() && prevEnd.After(pos) {
+			v.errf(s, "statement at %s overlaps the previous one ending at %s", pos, prevEnd)
+			return
+		}
+		prevEnd = s.End()
+	}
+}
+
+func (v *vetter) words(parent Node, words []*Word) {
+	for _, w := range words {
+		if w == nil {
+			v.errf(parent, "nil word")
+			return
+		}
+		v.node(w)
+	}
+}
+
+func (v *vetter) wordParts(parent Node, parts []WordPart) {
+	for _, wp := range parts {
+		if wp == nil {
+			v.errf(parent, "nil word part")
+			return
+		}
+		v.node(wp)
+	}
+}
+
+func (v *vetter) node(node Node) {
+	if v.err != nil {
+		return
+	}
+	switch node := node.(type) {
+	case nil:
+		v.errf(node, "nil node")
+	case *File:
+		v.stmts(node, node.Stmts)
+	case *Comment:
+	case *Stmt:
+		if node.Cmd == nil && len(node.Redirs) == 0 {
+			v.errf(node, "statement has neither a command nor redirects")
+			return
+		}
+		if node.Background && node.Coprocess {
+			v.errf(node, "statement cannot be both a background job and a coprocess")
+			return
+		}
+		if node.Cmd != nil {
+			v.node(node.Cmd)
+		}
+		for _, r := range node.Redirs {
+			if r == nil {
+				v.errf(node, "nil redirect")
+				return
+			}
+			v.node(r)
+		}
+	case *Assign:
+		switch {
+		case node.Name == nil:
+			if !node.Naked || node.Value == nil {
+				// Only "declare $foo" has no name, as a naked value.
+				v.errf(node, "assignment without a name must be a naked value")
+				return
+			}
+		case !ValidName(node.Name.Value):
+			v.errf(node, "invalid variable name %q", node.Name.Value)
+			return
+		case node.Naked && (node.Value != nil || node.Array != nil || node.Append):
+			v.errf(node, "naked assignment cannot have a value")
+			return
+		}
+		if node.Value != nil && node.Array != nil {
+			v.errf(node, "assignment cannot have both a value and an array")
+			return
+		}
+		if node.Index != nil && node.Array != nil {
+			v.errf(node, "array assignment cannot have an index")
+			return
+		}
+		if node.Name != nil {
+			v.node(node.Name)
+		}
+		if node.Index != nil {
+			v.node(node.Index)
+		}
+		if node.Value != nil {
+			v.node(node.Value)
+		}
+		if node.Array != nil {
+			v.node(node.Array)
+		}
+	case *Redirect:
+		if !validRedirOp(node.Op) {
+			v.errf(node, "invalid redirect operator %d", node.Op)
+			return
+		}
+		if node.Word == nil {
+			v.errf(node, "redirect %s has no word", node.Op)
+			return
+		}
+		isHdoc := node.Op == Hdoc || node.Op == DashHdoc
+		if node.Hdoc != nil && !isHdoc {
+			v.errf(node, "redirect %s cannot have a heredoc body", node.Op)
+			return
+		}
+		if node.N != nil {
+			v.node(node.N)
+		}
+		v.node(node.Word)
+		if node.Hdoc != nil {
+			v.node(node.Hdoc)
+		}
+	case *CallExpr:
+		if len(node.Assigns) == 0 && len(node.Args) == 0 {
+			v.errf(node, "call expression has neither assignments nor arguments")
+			return
+		}
+		for _, as := range node.Assigns {
+			if as == nil {
+				v.errf(node, "nil assignment")
+				return
+			}
+			if as.Name == nil || as.Naked {
+				v.errf(as, "assignment in a call expression must have a name and a value")
+				return
+			}
+			v.node(as)
+		}
+		v.words(node, node.Args)
+	case *Subshell:
+		v.stmts(node, node.Stmts)
+	case *Block:
+		v.stmts(node, node.Stmts)
+	case *IfClause:
+		v.stmts(node, node.Cond)
+		v.stmts(node, node.Then)
+		if node.Else != nil {
+			v.node(node.Else)
+		}
+	case *WhileClause:
+		if len(node.Cond) == 0 {
+			v.errf(node, "loop has no condition")
+			return
+		}
+		v.stmts(node, node.Cond)
+		v.stmts(node, node.Do)
+	case *ForClause:
+		if node.Loop == nil {
+			v.errf(node, "for clause has no loop")
+			return
+		}
+		if _, ok := node.Loop.(*CStyleLoop); ok && node.Select {
+			v.errf(node, "select clause cannot use a C-style loop")
+			return
+		}
+		v.node(node.Loop)
+		v.stmts(node, node.Do)
+	case *WordIter:
+		if node.Name == nil || !ValidName(node.Name.Value) {
+			v.errf(node, "loop needs a valid variable name")
+			return
+		}
+		v.node(node.Name)
+		v.words(node, node.Items)
+	case *CStyleLoop:
+		for _, x := range [...]ArithmExpr{node.Init, node.Cond, node.Post} {
+			if x != nil {
+				v.node(x)
+			}
+		}
+	case *BinaryCmd:
+		if !validBinCmdOp(node.Op) {
+			v.errf(node, "invalid binary command operator %d", node.Op)
+			return
+		}
+		if node.X == nil || node.Y == nil {
+			v.errf(node, "binary command %s is missing an operand", node.Op)
+			return
+		}
+		v.node(node.X)
+		v.node(node.Y)
+	case *FuncDecl:
+		if node.Name == nil || node.Name.Value == "" {
+			v.errf(node, "function declaration has no name")
+			return
+		}
+		if node.Body == nil {
+			v.errf(node, "function %s has no body", node.Name.Value)
+			return
+		}
+		v.node(node.Name)
+		v.node(node.Body)
+	case *Word:
+		if len(node.Parts) == 0 {
+			v.errf(node, "word has no parts")
+			return
+		}
+		v.wordParts(node, node.Parts)
+	case *Lit:
+	case *SglQuoted:
+	case *DblQuoted:
+		v.wordParts(node, node.Parts)
+	case *CmdSubst:
+		v.stmts(node, node.Stmts)
+	case *ParamExp:
+		if node.Param == nil || node.Param.Value == "" {
+			v.errf(node, "parameter expansion has no parameter")
+			return
+		}
+		ops := 0
+		for _, set := range [...]bool{
+			node.Slice != nil, node.Repl != nil,
+			node.Exp != nil, node.Names != 0,
+		} {
+			ops += oneIf(set)
+		}
+		if ops > 1 {
+			v.errf(node, "parameter expansion can only have one operator")
+			return
+		}
+		if node.Short && (ops > 0 || node.Excl || node.Length || node.Width) {
+			v.errf(node, "short parameter expansion $%s cannot have operators", node.Param.Value)
+			return
+		}
+		if node.Names != 0 && node.Names != NamesPrefix && node.Names != NamesPrefixWords {
+			v.errf(node, "invalid names operator %d", node.Names)
+			return
+		}
+		if node.Exp != nil && !validParExpOp(node.Exp.Op) {
+			v.errf(node, "invalid parameter expansion operator %d", node.Exp.Op)
+			return
+		}
+		v.node(node.Param)
+		if node.Index != nil {
+			v.node(node.Index)
+		}
+		if node.Slice != nil {
+			if node.Slice.Offset != nil {
+				v.node(node.Slice.Offset)
+			}
+			if node.Slice.Length != nil {
+				v.node(node.Slice.Length)
+			}
+		}
+		if node.Repl != nil {
+			if node.Repl.Orig != nil {
+				v.node(node.Repl.Orig)
+			}
+			if node.Repl.With != nil {
+				v.node(node.Repl.With)
+			}
+		}
+		if node.Exp != nil && node.Exp.Word != nil {
+			v.node(node.Exp.Word)
+		}
+	case *ArithmExp:
+		if node.X != nil {
+			v.node(node.X)
+		}
+	case *ArithmCmd:
+		if node.X != nil {
+			v.node(node.X)
+		}
+	case *BinaryArithm:
+		if !validBinAritOp(node.Op) {
+			v.errf(node, "invalid binary arithmetic operator %d", node.Op)
+			return
+		}
+		if node.X == nil || node.Y == nil {
+			v.errf(node, "binary arithmetic %s is missing an operand", node.Op)
+			return
+		}
+		v.node(node.X)
+		v.node(node.Y)
+	case *UnaryArithm:
+		switch node.Op {
+		case Inc, Dec:
+		case Not, BitNegation, Plus, Minus:
+			if node.Post {
+				v.errf(node, "unary arithmetic %s cannot be a postfix", node.Op)
+				return
+			}
+		default:
+			v.errf(node, "invalid unary arithmetic operator %d", node.Op)
+			return
+		}
+		if node.X == nil {
+			v.errf(node, "unary arithmetic %s is missing an operand", node.Op)
+			return
+		}
+		v.node(node.X)
+	case *ParenArithm:
+		if node.X == nil {
+			v.errf(node, "parentheses are empty")
+			return
+		}
+		v.node(node.X)
+	case *BinaryTest:
+		if !validBinTestOp(node.Op) {
+			v.errf(node, "invalid binary test operator %d", node.Op)
+			return
+		}
+		if node.X == nil || node.Y == nil {
+			v.errf(node, "binary test %s is missing an operand", node.Op)
+			return
+		}
+		v.node(node.X)
+		v.node(node.Y)
+	case *UnaryTest:
+		if !validUnTestOp(node.Op) {
+			v.errf(node, "invalid unary test operator %d", node.Op)
+			return
+		}
+		if node.X == nil {
+			v.errf(node, "unary test %s is missing an operand", node.Op)
+			return
+		}
+		v.node(node.X)
+	case *ParenTest:
+		if node.X == nil {
+			v.errf(node, "parentheses are empty")
+			return
+		}
+		v.node(node.X)
+	case *CaseClause:
+		if node.Word == nil {
+			v.errf(node, "case clause has no word")
+			return
+		}
+		v.node(node.Word)
+		for _, ci := range node.Items {
+			if ci == nil {
+				v.errf(node, "nil case item")
+				return
+			}
+			v.node(ci)
+		}
+	case *CaseItem:
+		if len(node.Patterns) == 0 {
+			v.errf(node, "case item has no patterns")
+			return
+		}
+		if node.Op < Break || node.Op > ResumeKorn {
+			v.errf(node, "invalid case operator %d", node.Op)
+			return
+		}
+		v.words(node, node.Patterns)
+		v.stmts(node, node.Stmts)
+	case *TestClause:
+		if node.X == nil {
+			v.errf(node, "test clause is empty")
+			return
+		}
+		v.node(node.X)
+	case *DeclClause:
+		if node.Variant == nil {
+			v.errf(node, "declare clause has no variant")
+			return
+		}
+		switch node.Variant.Value {
+		case "declare", "local", "export", "readonly", "typeset", "nameref":
+		default:
+			v.errf(node, "invalid declare variant %q", node.Variant.Value)
+			return
+		}
+		for _, as := range node.Args {
+			if as == nil {
+				v.errf(node, "nil assignment")
+				return
+			}
+			v.node(as)
+		}
+	case *ArrayExpr:
+		for _, elem := range node.Elems {
+			if elem == nil {
+				v.errf(node, "nil array element")
+				return
+			}
+			v.node(elem)
+		}
+	case *ArrayElem:
+		if node.Index == nil && node.Value == nil {
+			v.errf(node, "array element has neither an index nor a value")
+			return
+		}
+		if node.Index != nil {
+			v.node(node.Index)
+		}
+		if node.Value != nil {
+			v.node(node.Value)
+		}
+	case *ExtGlob:
+		if node.Op < GlobZeroOrOne || node.Op > GlobExcept {
+			v.errf(node, "invalid extended glob operator %d", node.Op)
+			return
+		}
+		if node.Pattern == nil {
+			v.errf(node, "extended glob has no pattern")
+			return
+		}
+		v.node(node.Pattern)
+	case *ProcSubst:
+		if node.Op != CmdIn && node.Op != CmdOut {
+			v.errf(node, "invalid process substitution operator %d", node.Op)
+			return
+		}
+		v.stmts(node, node.Stmts)
+	case *TimeClause:
+		if node.Stmt != nil {
+			v.node(node.Stmt)
+		}
+	case *CoprocClause:
+		if node.Stmt == nil {
+			v.errf(node, "coproc clause has no statement")
+			return
+		}
+		if node.Name != nil {
+			v.node(node.Name)
+		}
+		v.node(node.Stmt)
+	case *LetClause:
+		if len(node.Exprs) == 0 {
+			v.errf(node, "let clause has no expressions")
+			return
+		}
+		for _, x := range node.Exprs {
+			if x == nil {
+				v.errf(node, "nil arithmetic expression")
+				return
+			}
+			v.node(x)
+		}
+	case *BraceExp:
+		if node.Sequence && (len(node.Elems) < 2 || len(node.Elems) > 3) {
+			v.errf(node, "brace sequence must have two or three elements")
+			return
+		}
+		if len(node.Elems) == 0 {
+			v.errf(node, "brace expression has no elements")
+			return
+		}
+		v.words(node, node.Elems)
+	case *TestDecl:
+		if node.Description == nil || node.Body == nil {
+			v.errf(node, "test declaration needs a description and a body")
+			return
+		}
+		v.node(node.Description)
+		v.node(node.Body)
+	default:
+		v.errf(node, "unexpected node type")
+		return
+	}
+	if v.err != nil {
+		return
+	}
+	// The node and its children are well formed at this point,
+	// so it's safe to call Pos and End.
+	if pos, end := node.Pos(), node.End(); pos.IsValid() && end.IsValid() && pos.After(end) {
+		v.errf(node, "position %s is after the end position %s", pos, end)
+	}
+}
+
+func oneIf(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func validRedirOp(op RedirOperator) bool { return op >= RdrOut && op <= AppAll }
+
+func validBinCmdOp(op BinCmdOperator) bool { return op >= AndStmt && op <= PipeAll }
+
+func validParExpOp(op ParExpOperator) bool {
+	return op >= AlternateUnset && op <= OtherParamOps
+}
+
+func validBinAritOp(op BinAritOperator) bool {
+	switch op {
+	case Add, Sub, Mul, Quo, Rem, Pow, Eql, Gtr, Lss, Neq, Leq, Geq,
+		And, Or, Xor, Shr, Shl, AndArit, OrArit, Comma, TernQuest, TernColon,
+		Assgn, AddAssgn, SubAssgn, MulAssgn, QuoAssgn, RemAssgn,
+		AndAssgn, OrAssgn, XorAssgn, ShlAssgn, ShrAssgn:
+		return true
+	}
+	return false
+}
+
+func validUnTestOp(op UnTestOperator) bool {
+	return (op >= TsExists && op <= TsRefVar) || op == TsNot || op == TsParen
+}
+
+func validBinTestOp(op BinTestOperator) bool {
+	switch op {
+	case AndTest, OrTest, TsMatchShort, TsMatch, TsNoMatch, TsBefore, TsAfter:
+		return true
+	}
+	return op >= TsReMatch && op <= TsGtr
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2024, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package syntax
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-quicktest/qt"
+)
+
+func TestVetParsed(t *testing.T) {
+	t.Parallel()
+	for i, c := range append(fileTests, fileTestsNoPrint...) {
+		for _, lang := range [...]struct {
+			variant LangVariant
+			want    *File
+		}{
+			{LangBash, c.Bash},
+			{LangPOSIX, c.Posix},
+			{LangMirBSDKorn, c.MirBSDKorn},
+			{LangBats, c.Bats},
+		} {
+			if lang.want == nil {
+				continue
+			}
+			p := NewParser(Variant(lang.variant), KeepComments(true))
+			for j, in := range c.Strs {
+				t.Run(fmt.Sprintf("#%03d-%d-%s", i, j, lang.variant), func(t *testing.T) {
+					f, err := p.Parse(strings.NewReader(in), "")
+					qt.Assert(t, qt.IsNil(err))
+					qt.Assert(t, qt.IsNil(Vet(f)))
+				})
+			}
+		}
+	}
+}
+
+var vetTests = []struct {
+	node Node
+	want string
+}{
+	{litStmt("foo"), ""},
+	{&Stmt{}, "*syntax.Stmt: statement has neither a command nor redirects"},
+	{&CallExpr{}, "*syntax.CallExpr: call expression has neither assignments nor arguments"},
+	{call(&Word{}), "*syntax.Word: word has no parts"},
+	{call(word(nil)), "*syntax.Word: nil word part"},
+	{
+		&File{Stmts: []*Stmt{litStmt("foo"), nil}},
+		"*syntax.File: nil statement",
+	},
+	{
+		&CallExpr{Assigns: []*Assign{{Name: lit("foo-bar"), Value: litWord("x")}}},
+		`*syntax.Assign: invalid variable name "foo-bar"`,
+	},
+	{
+		&CallExpr{Assigns: []*Assign{{Name: lit("foo"), Naked: true}}},
+		"*syntax.Assign: assignment in a call expression must have a name and a value",
+	},
+	{
+		&Assign{Name: lit("foo"), Value: litWord("x"), Array: arrValues(litWord("y"))},
+		"*syntax.Assign: assignment cannot have both a value and an array",
+	},
+	{
+		&BinaryCmd{Op: AndStmt, X: litStmt("foo")},
+		"*syntax.BinaryCmd: binary command && is missing an operand",
+	},
+	{
+		&BinaryCmd{Op: BinCmdOperator(TsMatch), X: litStmt("foo"), Y: litStmt("bar")},
+		"*syntax.BinaryCmd: invalid binary command operator 40",
+	},
+	{
+		&Redirect{Op: RdrOut},
+		"*syntax.Redirect: redirect > has no word",
+	},
+	{
+		&Redirect{Op: RdrOut, Word: litWord("f"), Hdoc: litWord("body")},
+		"*syntax.Redirect: redirect > cannot have a heredoc body",
+	},
+	{&FuncDecl{Name: lit("foo")}, "*syntax.FuncDecl: function foo has no body"},
+	{&ParamExp{}, "*syntax.ParamExp: parameter expansion has no parameter"},
+	{
+		&ParamExp{Param: lit("a"), Short: true, Exp: &Expansion{Op: DefaultUnset}},
+		"*syntax.ParamExp: short parameter expansion $a cannot have operators",
+	},
+	{
+		&ParamExp{
+			Param: lit("a"),
+			Slice: &Slice{Offset: litWord("1")},
+			Exp:   &Expansion{Op: DefaultUnset},
+		},
+		"*syntax.ParamExp: parameter expansion can only have one operator",
+	},
+	{
+		&UnaryArithm{Op: Not, Post: true, X: litWord("a")},
+		"*syntax.UnaryArithm: unary arithmetic ! cannot be a postfix",
+	},
+	{
+		&BinaryArithm{Op: Add, X: litWord("a")},
+		"*syntax.BinaryArithm: binary arithmetic + is missing an operand",
+	},
+	{&TestClause{}, "*syntax.TestClause: test clause is empty"},
+	{
+		&DeclClause{Variant: lit("foo")},
+		`*syntax.DeclClause: invalid declare variant "foo"`,
+	},
+	{
+		&CaseClause{Word: litWord("a"), Items: []*CaseItem{{Op: Break}}},
+		"*syntax.CaseItem: case item has no patterns",
+	},
+	{
+		&BraceExp{Sequence: true, Elems: litWords("a")},
+		"*syntax.BraceExp: brace sequence must have two or three elements",
+	},
+	{
+		&Lit{ValuePos: NewPos(10, 1, 11), ValueEnd: NewPos(5, 1, 6), Value: "foo"},
+		"*syntax.Lit: position 1:11 is after the end position 1:6",
+	},
+	{
+		&File{Stmts: []*Stmt{
+			{Position: NewPos(4, 1, 5), Cmd: call(&Word{Parts: []WordPart{
+				&Lit{ValuePos: NewPos(4, 1, 5), ValueEnd: NewPos(7, 1, 8), Value: "foo"},
+			}})},
+			{Position: NewPos(0, 1, 1), Cmd: call(&Word{Parts: []WordPart{
+				&Lit{ValuePos: NewPos(0, 1, 1), ValueEnd: NewPos(3, 1, 4), Value: "bar"},
+			}})},
+		}},
+		"*syntax.Stmt: statement at 1:1 overlaps the previous one ending at 1:8",
+	},
+}
+
+func TestVet(t *testing.T) {
+	t.Parallel()
+	for _, tc := range vetTests {
+		t.Run("", func(t *testing.T) {
+			err := Vet(tc.node)
+			if tc.want == "" {
+				qt.Assert(t, qt.IsNil(err))
+				return
+			}
+			qt.Assert(t, qt.IsNotNil(err))
+			qt.Assert(t, qt.Equals(err.Error(), tc.want))
+			var verr VetError
+			qt.Assert(t, qt.IsTrue(errors.As(err, &verr)))
+		})
+	}
+}